@@ -0,0 +1,207 @@
+// Package apikey verifies the API keys clients present to the proxy.
+// Keys are kept only as SHA-256 digests, so configuration and key files
+// can hold digests instead of the secrets themselves.
+package apikey
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/sunbankio/qwencoder-proxy/config"
+)
+
+// hashPrefix marks a configured key as a digest rather than a plain text key
+const hashPrefix = "sha256:"
+
+var (
+	// ErrMissingKey is returned when a request carries no API key
+	ErrMissingKey = errors.New("missing API key")
+	// ErrInvalidKey is returned for keys that are not configured
+	ErrInvalidKey = errors.New("invalid API key")
+	// ErrExpiredKey is returned for keys past their expiry time
+	ErrExpiredKey = errors.New("API key has expired")
+)
+
+// Key is a configured client key
+type Key struct {
+	Hash      string    // sha256:<hex> digest of the secret
+	Label     string    // Identifies the key's owner in logs; may be empty
+	ExpiresAt time.Time // Zero for keys that never expire
+	Admin     bool      // Grants access to the /admin endpoints
+	Policy    Policy
+}
+
+// Name identifies the key in logs without revealing it
+func (k *Key) Name() string {
+	if k.Label != "" {
+		return k.Label
+	}
+	// A digest prefix is enough to tell keys apart
+	return k.Hash[:len(hashPrefix)+8]
+}
+
+// Expired reports whether the key has expired at now
+func (k *Key) Expired(now time.Time) bool {
+	return !k.ExpiresAt.IsZero() && !now.Before(k.ExpiresAt)
+}
+
+// Hash returns the digest stored for a secret key
+func Hash(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hashPrefix + hex.EncodeToString(sum[:])
+}
+
+// normalizeHash accepts a plain text key or a digest and returns the digest
+func normalizeHash(value string) (string, error) {
+	digest, ok := strings.CutPrefix(value, hashPrefix)
+	if !ok {
+		return Hash(value), nil
+	}
+	digest = strings.ToLower(digest)
+	if decoded, err := hex.DecodeString(digest); err != nil || len(decoded) != sha256.Size {
+		return "", fmt.Errorf("invalid key digest %q: want sha256: followed by 64 hex digits", value)
+	}
+	return hashPrefix + digest, nil
+}
+
+// Store holds the configured keys by digest
+type Store struct {
+	keys map[string]*Key
+}
+
+// NewStore creates a store from keys
+func NewStore(keys ...*Key) *Store {
+	s := &Store{keys: make(map[string]*Key)}
+	for _, k := range keys {
+		s.keys[k.Hash] = k
+	}
+	return s
+}
+
+// Len returns the number of configured keys
+func (s *Store) Len() int {
+	return len(s.keys)
+}
+
+// Lookup returns the key matching a secret, or an error if it is unknown or expired
+func (s *Store) Lookup(secret string, now time.Time) (*Key, error) {
+	if secret == "" {
+		return nil, ErrMissingKey
+	}
+	key, ok := s.keys[Hash(secret)]
+	if !ok {
+		return nil, ErrInvalidKey
+	}
+	if key.Expired(now) {
+		return nil, ErrExpiredKey
+	}
+	return key, nil
+}
+
+// fileKey is one entry of a key file. Either Key or Hash is set.
+type fileKey struct {
+	Key       string     `json:"key,omitempty"`
+	Hash      string     `json:"hash,omitempty"`
+	Label     string     `json:"label,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	Admin     bool       `json:"admin,omitempty"`
+
+	Providers     []string `json:"providers,omitempty"`
+	Models        []string `json:"models,omitempty"`
+	RPM           int      `json:"rpm,omitempty"`
+	TPM           int      `json:"tpm,omitempty"`
+	DailyTokens   int64    `json:"daily_tokens,omitempty"`
+	MonthlyTokens int64    `json:"monthly_tokens,omitempty"`
+}
+
+// keyFile is the layout of a key file
+type keyFile struct {
+	Keys []fileKey `json:"keys"`
+}
+
+// Load builds a store from the keys in the configuration and the key file, if any
+func Load(cfg config.AuthConfig) (*Store, error) {
+	var keys []*Key
+	for _, value := range cfg.APIKeys {
+		hash, err := normalizeHash(value)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, &Key{Hash: hash})
+	}
+
+	if cfg.KeysFile != "" {
+		fileKeys, err := loadFile(cfg.KeysFile)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, fileKeys...)
+	}
+	return NewStore(keys...), nil
+}
+
+// loadFile reads the keys in a key file
+func loadFile(path string) ([]*Key, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read API key file: %w", err)
+	}
+	var file keyFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse API key file %s: %w", path, err)
+	}
+
+	keys := make([]*Key, 0, len(file.Keys))
+	for i, entry := range file.Keys {
+		if (entry.Key == "") == (entry.Hash == "") {
+			return nil, fmt.Errorf("API key file %s: entry %d must set exactly one of key and hash", path, i)
+		}
+		value := entry.Key
+		if entry.Hash != "" {
+			value = entry.Hash
+			if !strings.HasPrefix(value, hashPrefix) {
+				value = hashPrefix + value
+			}
+		}
+		hash, err := normalizeHash(value)
+		if err != nil {
+			return nil, fmt.Errorf("API key file %s: entry %d: %w", path, i, err)
+		}
+		if entry.RPM < 0 || entry.TPM < 0 || entry.DailyTokens < 0 || entry.MonthlyTokens < 0 {
+			return nil, fmt.Errorf("API key file %s: entry %d: limits must not be negative", path, i)
+		}
+		key := &Key{Hash: hash, Label: entry.Label, Admin: entry.Admin, Policy: Policy{
+			Providers:         entry.Providers,
+			Models:            entry.Models,
+			RequestsPerMinute: entry.RPM,
+			TokensPerMinute:   entry.TPM,
+			DailyTokens:       entry.DailyTokens,
+			MonthlyTokens:     entry.MonthlyTokens,
+		}}
+		if entry.ExpiresAt != nil {
+			key.ExpiresAt = *entry.ExpiresAt
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+type keyContextKey struct{}
+
+// WithKey returns a context carrying the authenticated key
+func WithKey(ctx context.Context, key *Key) context.Context {
+	return context.WithValue(ctx, keyContextKey{}, key)
+}
+
+// FromContext returns the authenticated key of a request, or nil when authentication is disabled
+func FromContext(ctx context.Context) *Key {
+	key, _ := ctx.Value(keyContextKey{}).(*Key)
+	return key
+}
@@ -0,0 +1,91 @@
+package apikey
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sunbankio/qwencoder-proxy/config"
+)
+
+func TestLoadAndLookup(t *testing.T) {
+	now := time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC)
+	file := filepath.Join(t.TempDir(), "keys.json")
+	content := `{"keys": [
+		{"hash": "` + strings.TrimPrefix(Hash("sk-ci"), hashPrefix) + `", "label": "ci", "models": ["claude-sonnet-*"], "rpm": 10, "daily_tokens": 500000},
+		{"key": "sk-old", "label": "retired", "expires_at": "2026-01-01T00:00:00Z"},
+		{"key": "sk-trial", "label": "trial", "expires_at": "2026-12-31T00:00:00Z"}
+	]}`
+	if err := os.WriteFile(file, []byte(content), 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	store, err := Load(config.AuthConfig{APIKeys: []string{"sk-plain", Hash("sk-digest")}, KeysFile: file})
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if store.Len() != 5 {
+		t.Errorf("Len() = %d, want 5", store.Len())
+	}
+
+	tests := []struct {
+		secret    string
+		wantLabel string
+		wantErr   error
+	}{
+		{secret: "sk-plain"},
+		{secret: "sk-digest"},
+		{secret: "sk-ci", wantLabel: "ci"},
+		{secret: "sk-trial", wantLabel: "trial"},
+		{secret: "sk-old", wantErr: ErrExpiredKey},
+		{secret: "sk-unknown", wantErr: ErrInvalidKey},
+		{secret: "", wantErr: ErrMissingKey},
+	}
+
+	for _, tt := range tests {
+		key, err := store.Lookup(tt.secret, now)
+		if !errors.Is(err, tt.wantErr) {
+			t.Errorf("Lookup(%q) error = %v, want %v", tt.secret, err, tt.wantErr)
+			continue
+		}
+		if err == nil && key.Label != tt.wantLabel {
+			t.Errorf("Lookup(%q) label = %q, want %q", tt.secret, key.Label, tt.wantLabel)
+		}
+	}
+
+	ci, _ := store.Lookup("sk-ci", now)
+	if p := ci.Policy; len(p.Models) != 1 || p.RequestsPerMinute != 10 || p.DailyTokens != 500000 {
+		t.Errorf("policy = %+v, want the file's limits", p)
+	}
+}
+
+func TestLoadRejectsInvalidKeys(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		os.WriteFile(path, []byte(content), 0o600)
+		return path
+	}
+
+	tests := []struct {
+		name string
+		cfg  config.AuthConfig
+		want string
+	}{
+		{"short digest", config.AuthConfig{APIKeys: []string{"sha256:abcd"}}, "invalid key digest"},
+		{"missing file", config.AuthConfig{KeysFile: filepath.Join(dir, "missing.json")}, "failed to read API key file"},
+		{"malformed file", config.AuthConfig{KeysFile: write("bad.json", "{")}, "failed to parse API key file"},
+		{"key and hash", config.AuthConfig{KeysFile: write("both.json", `{"keys":[{"key":"a","hash":"b"}]}`)}, "exactly one of key and hash"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Load(tt.cfg); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Load() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,341 @@
+package apikey
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// ErrNotAllowed is returned when a key's policy does not permit a provider or model
+var ErrNotAllowed = errors.New("not permitted for this API key")
+
+// Policy restricts what a key may use. Zero values leave a limit unset.
+type Policy struct {
+	Providers         []string // Glob patterns of allowed providers; empty allows all
+	Models            []string // Glob patterns of allowed models; empty allows all
+	RequestsPerMinute int
+	TokensPerMinute   int
+	DailyTokens       int64 // Budget per UTC day
+	MonthlyTokens     int64 // Budget per UTC calendar month
+}
+
+// Permits reports whether the policy allows a model on a provider
+func (p Policy) Permits(providerName, model string) bool {
+	return matchesAny(p.Providers, providerName) && matchesAny(p.Models, model)
+}
+
+// matchesAny reports whether value matches one of the patterns, or the list is empty
+func matchesAny(patterns []string, value string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if matchGlob(pattern, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchGlob matches value against a pattern where "*" matches any run of characters
+// and "?" any single character. Unlike path.Match, "*" also matches "/", which
+// appears in some model names.
+func matchGlob(pattern, value string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for i := len(value); i >= 0; i-- {
+				if matchGlob(pattern[1:], value[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if value == "" {
+				return false
+			}
+		default:
+			if value == "" || value[0] != pattern[0] {
+				return false
+			}
+		}
+		pattern, value = pattern[1:], value[1:]
+	}
+	return value == ""
+}
+
+// Limit names reported in LimitError
+const (
+	LimitRequests      = "requests"
+	LimitTokens        = "tokens"
+	LimitDailyTokens   = "daily_tokens"
+	LimitMonthlyTokens = "monthly_tokens"
+)
+
+// LimitError is returned when a key has exceeded one of its limits
+type LimitError struct {
+	Key     string // Key name, as returned by Key.Name
+	Limit   string // One of the Limit* names
+	Max     int64
+	ResetAt time.Time // When the request would next be admitted
+}
+
+func (e *LimitError) Error() string {
+	switch e.Limit {
+	case LimitRequests:
+		return fmt.Sprintf("rate limit of %d requests per minute exceeded for API key %s; retry after %s", e.Max, e.Key, e.ResetAt.UTC().Format(time.RFC3339))
+	case LimitTokens:
+		return fmt.Sprintf("rate limit of %d tokens per minute exceeded for API key %s; retry after %s", e.Max, e.Key, e.ResetAt.UTC().Format(time.RFC3339))
+	case LimitDailyTokens:
+		return fmt.Sprintf("daily budget of %d tokens exhausted for API key %s; resets at %s", e.Max, e.Key, e.ResetAt.UTC().Format(time.RFC3339))
+	default:
+		return fmt.Sprintf("monthly budget of %d tokens exhausted for API key %s; resets at %s", e.Max, e.Key, e.ResetAt.UTC().Format(time.RFC3339))
+	}
+}
+
+// bucket is a token bucket holding up to capacity tokens and refilling at the same amount
+// per minute. Token usage is only known once a response completes, so the level may go
+// negative; requests are then refused until it has refilled above zero.
+type bucket struct {
+	level    float64
+	capacity float64
+	updated  time.Time
+}
+
+func newBucket(capacity int, now time.Time) *bucket {
+	return &bucket{level: float64(capacity), capacity: float64(capacity), updated: now}
+}
+
+// refill adds the tokens accrued since the last update
+func (b *bucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.updated); elapsed > 0 {
+		b.level += b.capacity * elapsed.Minutes()
+		if b.level > b.capacity {
+			b.level = b.capacity
+		}
+	}
+	b.updated = now
+}
+
+// availableAt returns when the bucket will hold at least n tokens
+func (b *bucket) availableAt(n float64, now time.Time) time.Time {
+	if b.level >= n {
+		return now
+	}
+	return now.Add(time.Duration((n - b.level) / b.capacity * float64(time.Minute)))
+}
+
+// keyState holds the buckets of one key
+type keyState struct {
+	requests *bucket
+	tokens   *bucket
+}
+
+// budgetUsage is the persisted token usage of one key in the current periods
+type budgetUsage struct {
+	Day         string `json:"day"` // UTC date, 2006-01-02
+	DayTokens   int64  `json:"day_tokens"`
+	Month       string `json:"month"` // UTC month, 2006-01
+	MonthTokens int64  `json:"month_tokens"`
+}
+
+// rollover resets the counters of periods that have ended
+func (u *budgetUsage) rollover(now time.Time) {
+	now = now.UTC()
+	if day := now.Format("2006-01-02"); u.Day != day {
+		u.Day, u.DayTokens = day, 0
+	}
+	if month := now.Format("2006-01"); u.Month != month {
+		u.Month, u.MonthTokens = month, 0
+	}
+}
+
+// BudgetSaveInterval is how often SaveEvery writes changed budget usage to the file
+const BudgetSaveInterval = 10 * time.Second
+
+// Limiter enforces key policies. Rate limits are kept in memory; budget usage is
+// saved to a file by Save so it survives restarts.
+type Limiter struct {
+	mu      sync.Mutex
+	saveMu  sync.Mutex // Serializes writes of the budget file, outside mu
+	path    string     // Empty keeps budgets in memory only
+	keys    map[string]*keyState
+	budgets map[string]*budgetUsage // By key hash
+	dirty   bool                    // Budget usage changed since it was last saved
+}
+
+// NewLimiter creates a limiter, loading budget usage saved at path
+func NewLimiter(path string) (*Limiter, error) {
+	l := &Limiter{path: path, keys: make(map[string]*keyState), budgets: make(map[string]*budgetUsage)}
+	if path == "" {
+		return l, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return l, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read budget usage: %w", err)
+	}
+	if err := json.Unmarshal(data, &l.budgets); err != nil {
+		return nil, fmt.Errorf("failed to parse budget usage %s: %w", path, err)
+	}
+	return l, nil
+}
+
+// state returns the buckets of a key, creating them on first use
+func (l *Limiter) state(key *Key, now time.Time) *keyState {
+	s, ok := l.keys[key.Hash]
+	if !ok {
+		s = &keyState{}
+		if key.Policy.RequestsPerMinute > 0 {
+			s.requests = newBucket(key.Policy.RequestsPerMinute, now)
+		}
+		if key.Policy.TokensPerMinute > 0 {
+			s.tokens = newBucket(key.Policy.TokensPerMinute, now)
+		}
+		l.keys[key.Hash] = s
+	}
+	return s
+}
+
+// Allow admits a request by key for a model on a provider. It returns an error wrapping
+// ErrNotAllowed when the policy forbids the model, or a *LimitError when a limit is
+// exhausted. An admitted request counts against the request rate limit.
+func (l *Limiter) Allow(key *Key, providerName, model string, now time.Time) error {
+	policy := key.Policy
+	if !policy.Permits(providerName, model) {
+		return fmt.Errorf("model %s on provider %s is %w", model, providerName, ErrNotAllowed)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if usage, ok := l.budgets[key.Hash]; ok {
+		usage.rollover(now)
+		utc := now.UTC()
+		if policy.DailyTokens > 0 && usage.DayTokens >= policy.DailyTokens {
+			nextDay := time.Date(utc.Year(), utc.Month(), utc.Day()+1, 0, 0, 0, 0, time.UTC)
+			return &LimitError{Key: key.Name(), Limit: LimitDailyTokens, Max: policy.DailyTokens, ResetAt: nextDay}
+		}
+		if policy.MonthlyTokens > 0 && usage.MonthTokens >= policy.MonthlyTokens {
+			nextMonth := time.Date(utc.Year(), utc.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+			return &LimitError{Key: key.Name(), Limit: LimitMonthlyTokens, Max: policy.MonthlyTokens, ResetAt: nextMonth}
+		}
+	}
+
+	s := l.state(key, now)
+	if s.tokens != nil {
+		s.tokens.refill(now)
+		if s.tokens.level <= 0 {
+			return &LimitError{Key: key.Name(), Limit: LimitTokens, Max: int64(policy.TokensPerMinute), ResetAt: s.tokens.availableAt(1, now)}
+		}
+	}
+	if s.requests != nil {
+		s.requests.refill(now)
+		if s.requests.level < 1 {
+			return &LimitError{Key: key.Name(), Limit: LimitRequests, Max: int64(policy.RequestsPerMinute), ResetAt: s.requests.availableAt(1, now)}
+		}
+		s.requests.level--
+	}
+	return nil
+}
+
+// Record charges the tokens used by a completed request to a key's limits and budgets.
+// Budget usage is written to the file by the next Save.
+func (l *Limiter) Record(key *Key, tokens int64, now time.Time) {
+	if tokens <= 0 {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if s := l.state(key, now); s.tokens != nil {
+		s.tokens.refill(now)
+		s.tokens.level -= float64(tokens)
+	}
+
+	if key.Policy.DailyTokens == 0 && key.Policy.MonthlyTokens == 0 {
+		return
+	}
+	usage, ok := l.budgets[key.Hash]
+	if !ok {
+		usage = &budgetUsage{}
+		l.budgets[key.Hash] = usage
+	}
+	usage.rollover(now)
+	usage.DayTokens += tokens
+	usage.MonthTokens += tokens
+	l.dirty = true
+}
+
+// Save writes budget usage to the file, replacing it atomically, if it changed since it
+// was last saved. Requests are only held up while the usage is copied.
+func (l *Limiter) Save() error {
+	l.saveMu.Lock()
+	defer l.saveMu.Unlock()
+
+	l.mu.Lock()
+	if l.path == "" || !l.dirty {
+		l.mu.Unlock()
+		return nil
+	}
+	data, err := json.MarshalIndent(l.budgets, "", "  ")
+	l.dirty = false
+	l.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to encode budget usage: %w", err)
+	}
+	if err := l.write(data); err != nil {
+		l.mu.Lock()
+		l.dirty = true // Try again on the next Save
+		l.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// SaveEvery calls Save in a goroutine every interval until ctx is done, and once more
+// then, passing errors to onError. The returned channel is closed after that last Save.
+func (l *Limiter) SaveEvery(ctx context.Context, interval time.Duration, onError func(error)) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				if err := l.Save(); err != nil {
+					onError(err)
+				}
+				return
+			case <-ticker.C:
+				if err := l.Save(); err != nil {
+					onError(err)
+				}
+			}
+		}
+	}()
+	return done
+}
+
+// write replaces the budget file with data atomically
+func (l *Limiter) write(data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(l.path), 0700); err != nil {
+		return fmt.Errorf("failed to create budget usage directory: %w", err)
+	}
+	tmp := l.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write budget usage: %w", err)
+	}
+	if err := os.Rename(tmp, l.path); err != nil {
+		return fmt.Errorf("failed to write budget usage: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package apikey
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPolicyPermits(t *testing.T) {
+	policy := Policy{Providers: []string{"kiro", "qwen"}, Models: []string{"claude-sonnet-*", "qwen3-coder-?lus", "Qwen/*"}}
+	tests := []struct {
+		provider, model string
+		want            bool
+	}{
+		{"kiro", "claude-sonnet-4-5", true},
+		{"kiro", "claude-opus-4-1", false},
+		{"qwen", "qwen3-coder-plus", true},
+		{"qwen", "qwen3-coder-flash", false},
+		{"qwen", "Qwen/Qwen3-Coder-480B", true},
+		{"iflow", "claude-sonnet-4-5", false},
+	}
+	for _, tt := range tests {
+		if got := policy.Permits(tt.provider, tt.model); got != tt.want {
+			t.Errorf("Permits(%s, %s) = %v, want %v", tt.provider, tt.model, got, tt.want)
+		}
+	}
+	if !(Policy{}).Permits("kiro", "claude-opus-4-1") {
+		t.Error("an empty policy should permit every model")
+	}
+}
+
+func TestLimiterRateLimits(t *testing.T) {
+	now := time.Date(2026, 6, 1, 12, 0, 0, 0, time.UTC)
+	key := &Key{Hash: Hash("sk-a"), Label: "intern", Policy: Policy{RequestsPerMinute: 2, TokensPerMinute: 1000}}
+	limiter, _ := NewLimiter("")
+
+	for i := 0; i < 2; i++ {
+		if err := limiter.Allow(key, "qwen", "qwen3-coder-plus", now); err != nil {
+			t.Fatalf("request %d: Allow() error = %v", i, err)
+		}
+	}
+	var limitErr *LimitError
+	if err := limiter.Allow(key, "qwen", "qwen3-coder-plus", now); !errors.As(err, &limitErr) || limitErr.Limit != LimitRequests {
+		t.Fatalf("Allow() error = %v, want request limit", err)
+	}
+	if want := now.Add(30 * time.Second); !limitErr.ResetAt.Equal(want) {
+		t.Errorf("ResetAt = %s, want %s", limitErr.ResetAt, want)
+	}
+	if err := limiter.Allow(key, "qwen", "qwen3-coder-plus", now.Add(30*time.Second)); err != nil {
+		t.Errorf("Allow() after refill error = %v", err)
+	}
+
+	// A large response overdraws the token bucket until it has refilled
+	limiter.Record(key, 1500, now.Add(30*time.Second))
+	later := now.Add(time.Minute)
+	if err := limiter.Allow(key, "qwen", "qwen3-coder-plus", later); !errors.As(err, &limitErr) || limitErr.Limit != LimitTokens {
+		t.Fatalf("Allow() error = %v, want token limit", err)
+	}
+	if err := limiter.Allow(key, "qwen", "qwen3-coder-plus", limitErr.ResetAt); err != nil {
+		t.Errorf("Allow() at reset time error = %v", err)
+	}
+
+	if err := limiter.Allow(&Key{Hash: Hash("sk-b"), Policy: Policy{Models: []string{"qwen3-*"}}}, "kiro", "claude-opus-4-1", now); !errors.Is(err, ErrNotAllowed) {
+		t.Errorf("Allow() error = %v, want ErrNotAllowed", err)
+	}
+}
+
+func TestLimiterBudgetsPersist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "budgets.json")
+	now := time.Date(2026, 6, 15, 22, 0, 0, 0, time.UTC)
+	key := &Key{Hash: Hash("sk-a"), Policy: Policy{DailyTokens: 1000, MonthlyTokens: 1500}}
+
+	limiter, err := NewLimiter(path)
+	if err != nil {
+		t.Fatalf("NewLimiter() error = %v", err)
+	}
+	limiter.Record(key, 1000, now)
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Record() wrote the budget file, want it left to Save; stat error = %v", err)
+	}
+	if err := limiter.Save(); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	// A restarted limiter still knows the day's budget is spent
+	limiter, err = NewLimiter(path)
+	if err != nil {
+		t.Fatalf("NewLimiter() error = %v", err)
+	}
+	var limitErr *LimitError
+	if err := limiter.Allow(key, "kiro", "claude-sonnet-4-5", now); !errors.As(err, &limitErr) || limitErr.Limit != LimitDailyTokens {
+		t.Fatalf("Allow() error = %v, want daily budget", err)
+	}
+	if want := time.Date(2026, 6, 16, 0, 0, 0, 0, time.UTC); !limitErr.ResetAt.Equal(want) {
+		t.Errorf("ResetAt = %s, want %s", limitErr.ResetAt, want)
+	}
+
+	// The month's usage carries over into the next day, and a new month starts afresh
+	nextDay := now.Add(3 * time.Hour)
+	if err := limiter.Allow(key, "kiro", "claude-sonnet-4-5", nextDay); err != nil {
+		t.Fatalf("Allow() on the next day error = %v", err)
+	}
+	limiter.Record(key, 600, nextDay)
+	if err := limiter.Allow(key, "kiro", "claude-sonnet-4-5", nextDay); !errors.As(err, &limitErr) || limitErr.Limit != LimitMonthlyTokens {
+		t.Fatalf("Allow() error = %v, want monthly budget", err)
+	}
+	if want := time.Date(2026, 7, 1, 0, 0, 0, 0, time.UTC); !limitErr.ResetAt.Equal(want) {
+		t.Errorf("ResetAt = %s, want %s", limitErr.ResetAt, want)
+	}
+	if err := limiter.Allow(key, "kiro", "claude-sonnet-4-5", time.Date(2026, 7, 1, 9, 0, 0, 0, time.UTC)); err != nil {
+		t.Errorf("Allow() in a new month error = %v", err)
+	}
+}
+
+func TestLimiterSaveEveryWritesOnStop(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "budgets.json")
+	limiter, err := NewLimiter(path)
+	if err != nil {
+		t.Fatalf("NewLimiter() error = %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	saved := limiter.SaveEvery(ctx, time.Hour, func(err error) { t.Errorf("Save() error = %v", err) })
+	limiter.Record(&Key{Hash: Hash("sk-a"), Policy: Policy{DailyTokens: 1000}}, 10, time.Now())
+
+	cancel()
+	<-saved
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("budget file not written once SaveEvery stopped: %v", err)
+	}
+}
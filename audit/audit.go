@@ -0,0 +1,242 @@
+// Package audit records proxied exchanges for debugging. Each record holds the client's
+// request, every upstream call made for it with the native request and the raw response
+// or stream, the response sent back and timings, so converter bugs can be reproduced
+// from the log. Records are JSON lines in files rotated by size and age.
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sunbankio/qwencoder-proxy/config"
+)
+
+// Record is one audited exchange
+type Record struct {
+	Time      time.Time  `json:"time"` // When the request arrived
+	RequestID string     `json:"request_id,omitempty"`
+	Key       string     `json:"key,omitempty"`
+	Provider  string     `json:"provider,omitempty"`
+	Model     string     `json:"model,omitempty"`
+	Request   Message    `json:"request"`
+	Upstream  []Upstream `json:"upstream,omitempty"` // Retries and fallbacks add calls
+	Response  Message    `json:"response"`
+	Timings   Timings    `json:"timings"`
+	// PromptsRedacted is set when message text was replaced before recording
+	PromptsRedacted bool `json:"prompts_redacted,omitempty"`
+}
+
+// Message is a recorded HTTP request or response. The body is kept in the first field
+// that fits: Events for server-sent event streams, Body for JSON, Text for other text
+// and Raw for binary data.
+type Message struct {
+	Method    string          `json:"method,omitempty"`
+	URL       string          `json:"url,omitempty"`
+	Status    int             `json:"status,omitempty"`
+	Header    http.Header     `json:"header,omitempty"`
+	Body      json.RawMessage `json:"body,omitempty"`
+	Text      string          `json:"text,omitempty"`
+	Raw       []byte          `json:"raw,omitempty"`
+	Events    []Event         `json:"events,omitempty"`
+	Truncated bool            `json:"truncated,omitempty"` // The body exceeded the size limit
+	Omitted   string          `json:"omitted,omitempty"`   // Why the body was left out
+}
+
+// Event is one server-sent event
+type Event struct {
+	Event string          `json:"event,omitempty"`
+	Data  json.RawMessage `json:"data,omitempty"` // JSON payloads
+	Text  string          `json:"text,omitempty"` // Other payloads, such as [DONE]
+}
+
+// Upstream is one call to a provider
+type Upstream struct {
+	Request  Message `json:"request"`
+	Response Message `json:"response"`
+	Error    string  `json:"error,omitempty"` // Set when no response was received
+	Timings  Timings `json:"timings"`
+}
+
+// Timings are in milliseconds from the arrival of the client's request
+type Timings struct {
+	StartMs     int64 `json:"start_ms,omitempty"`      // When an upstream call started
+	FirstByteMs int64 `json:"first_byte_ms,omitempty"` // When the first body byte arrived or was sent
+	EndMs       int64 `json:"end_ms"`
+}
+
+// filePrefix and fileLayout name audit files by the time they were started, so names
+// sort in time order
+const (
+	filePrefix = "audit-"
+	fileLayout = "20060102T150405.000Z"
+)
+
+// Recorder writes sampled exchanges to audit files in a directory
+type Recorder struct {
+	dir           string
+	sampleRate    float64
+	redactPrompts bool
+	maxBody       int
+	maxFileBytes  int64
+	maxFileAge    time.Duration
+	maxFiles      int
+
+	mu     sync.Mutex
+	file   *os.File
+	opened time.Time
+	size   int64
+}
+
+// Open creates a recorder from the audit settings. It returns nil when the audit log
+// is disabled.
+func Open(cfg config.AuditConfig) (*Recorder, error) {
+	if cfg.Dir == "" {
+		return nil, nil
+	}
+	if cfg.SampleRate < 0 || cfg.SampleRate > 1 {
+		return nil, fmt.Errorf("audit sample rate %v is not between 0 and 1", cfg.SampleRate)
+	}
+	if err := os.MkdirAll(cfg.Dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create audit directory: %w", err)
+	}
+	return &Recorder{
+		dir:           cfg.Dir,
+		sampleRate:    cfg.SampleRate,
+		redactPrompts: cfg.RedactPrompts,
+		maxBody:       cfg.MaxBodyBytes,
+		maxFileBytes:  cfg.MaxFileBytes,
+		maxFileAge:    cfg.MaxFileAge,
+		maxFiles:      cfg.MaxFiles,
+	}, nil
+}
+
+// sampled decides whether to record a request
+func (r *Recorder) sampled() bool {
+	return r.sampleRate >= 1 || rand.Float64() < r.sampleRate
+}
+
+// Write appends a record to the current file, starting a new one when the current
+// file is too large or too old
+func (r *Recorder) Write(record *Record) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit record: %w", err)
+	}
+	data = append(data, '\n')
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	if r.file == nil || r.full(now) {
+		if err := r.rotate(now); err != nil {
+			return err
+		}
+	}
+	n, err := r.file.Write(data)
+	r.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("failed to write audit record: %w", err)
+	}
+	return nil
+}
+
+// full reports whether the current file has reached its size or age limit
+func (r *Recorder) full(now time.Time) bool {
+	return (r.maxFileBytes > 0 && r.size >= r.maxFileBytes) || (r.maxFileAge > 0 && now.Sub(r.opened) >= r.maxFileAge)
+}
+
+// rotate starts a new file and deletes the oldest ones beyond maxFiles
+func (r *Recorder) rotate(now time.Time) error {
+	if r.file != nil {
+		r.file.Close()
+		r.file = nil
+	}
+	path := filepath.Join(r.dir, filePrefix+now.UTC().Format(fileLayout)+".jsonl")
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open audit file: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to open audit file: %w", err)
+	}
+	r.file, r.opened, r.size = file, now, info.Size()
+
+	if r.maxFiles > 0 {
+		files, _ := Files(r.dir)
+		for len(files) > r.maxFiles {
+			os.Remove(files[0])
+			files = files[1:]
+		}
+	}
+	return nil
+}
+
+// Close closes the current file
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.file == nil {
+		return nil
+	}
+	err := r.file.Close()
+	r.file = nil
+	return err
+}
+
+// ReadFile calls fn for each record in an audit file. Lines that cannot be parsed, such
+// as a record still being written, are skipped.
+func ReadFile(path string, fn func(*Record) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open audit file: %w", err)
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 && err == nil {
+			var record Record
+			if json.Unmarshal(line, &record) == nil {
+				if err := fn(&record); err != nil {
+					return err
+				}
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read audit file %s: %w", path, err)
+		}
+	}
+}
+
+// Files lists the audit files in dir, oldest first
+func Files(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list audit files: %w", err)
+	}
+	var files []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, filePrefix) && strings.HasSuffix(name, ".jsonl") {
+			files = append(files, filepath.Join(dir, name))
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
@@ -0,0 +1,255 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sunbankio/qwencoder-proxy/config"
+)
+
+func openRecorder(t *testing.T, cfg config.AuditConfig) *Recorder {
+	t.Helper()
+	if cfg.Dir == "" {
+		cfg.Dir = t.TempDir()
+	}
+	if cfg.MaxBodyBytes == 0 {
+		cfg.MaxBodyBytes = 1 << 20
+	}
+	if cfg.SampleRate == 0 {
+		cfg.SampleRate = 1
+	}
+	r, err := Open(cfg)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	t.Cleanup(func() { r.Close() })
+	return r
+}
+
+// readRecords returns the records in every audit file of dir
+func readRecords(t *testing.T, dir string) []Record {
+	t.Helper()
+	files, err := Files(dir)
+	if err != nil {
+		t.Fatalf("Files() error = %v", err)
+	}
+	var records []Record
+	for _, path := range files {
+		file, err := os.Open(path)
+		if err != nil {
+			t.Fatalf("open %s: %v", path, err)
+		}
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(nil, 1<<20)
+		for scanner.Scan() {
+			var record Record
+			if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+				t.Fatalf("invalid record %q: %v", scanner.Text(), err)
+			}
+			records = append(records, record)
+		}
+		file.Close()
+	}
+	return records
+}
+
+func TestOpenDisabled(t *testing.T) {
+	r, err := Open(config.AuditConfig{})
+	if r != nil || err != nil {
+		t.Errorf("Open() without a directory = %v, %v; want nil, nil", r, err)
+	}
+	if e, _ := r.Begin(httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)); e != nil {
+		t.Errorf("nil recorder began an exchange")
+	}
+	if _, err := Open(config.AuditConfig{Dir: t.TempDir(), SampleRate: 1.5}); err == nil {
+		t.Errorf("Open() accepted a sample rate above 1")
+	}
+}
+
+func TestRecordExchange(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if !strings.Contains(string(body), `"contents"`) {
+			t.Errorf("upstream received %q", body)
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, "data: {\"candidates\":[{\"content\":{\"parts\":[{\"text\":\"Hi\"}]}}]}\n\n")
+		io.WriteString(w, "data: {\"candidates\":[{\"finishReason\":\"STOP\"}]}\n\n")
+	}))
+	defer upstream.Close()
+
+	dir := t.TempDir()
+	recorder := openRecorder(t, config.AuditConfig{Dir: dir})
+	client := &http.Client{Transport: Transport(http.DefaultTransport)}
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.ReadAll(r.Body)
+		req, _ := http.NewRequestWithContext(r.Context(), http.MethodPost, upstream.URL+"/v1:streamGenerateContent?alt=sse&key=secret",
+			strings.NewReader(`{"contents":[{"role":"user","parts":[{"text":"Hello"}]}]}`))
+		req.Header.Set("Authorization", "Bearer upstream-token")
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("upstream call failed: %v", err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, "data: {\"choices\":[{\"delta\":{\"content\":\"Hi\"}}]}\n\n")
+		io.WriteString(w, "data: [DONE]\n\n")
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"gemini-2.5-pro","stream":true,"messages":[{"role":"user","content":"Hello"}]}`))
+	req.Header.Set("Authorization", "Bearer sk-client")
+	exchange, req := recorder.Begin(req)
+	if exchange == nil {
+		t.Fatal("Begin() did not sample the request at rate 1")
+	}
+	handler.ServeHTTP(exchange.WrapResponse(httptest.NewRecorder()), req)
+	if err := exchange.Finish(Meta{RequestID: "req-1", Key: "ops", Provider: "gemini-cli", Model: "gemini-2.5-pro"}); err != nil {
+		t.Fatalf("Finish() error = %v", err)
+	}
+
+	records := readRecords(t, dir)
+	if len(records) != 1 {
+		t.Fatalf("recorded %d exchanges, want 1", len(records))
+	}
+	record := records[0]
+	if record.RequestID != "req-1" || record.Provider != "gemini-cli" || record.Key != "ops" {
+		t.Errorf("record metadata = %+v", record)
+	}
+	if record.Request.Header.Get("Authorization") != redacted || !strings.Contains(string(record.Request.Body), `"content":"Hello"`) {
+		t.Errorf("client request = %+v", record.Request)
+	}
+	if len(record.Upstream) != 1 {
+		t.Fatalf("recorded %d upstream calls, want 1", len(record.Upstream))
+	}
+	call := record.Upstream[0]
+	if call.Request.Header.Get("Authorization") != redacted || strings.Contains(call.Request.URL, "secret") || !strings.Contains(call.Request.URL, "alt=sse") {
+		t.Errorf("upstream request not redacted: %+v", call.Request)
+	}
+	if call.Response.Status != http.StatusOK || len(call.Response.Events) != 2 || !strings.Contains(string(call.Response.Events[1].Data), "STOP") {
+		t.Errorf("upstream response = %+v", call.Response)
+	}
+	events := record.Response.Events
+	if len(events) != 2 || !strings.Contains(string(events[0].Data), `"content":"Hi"`) || events[1].Text != "[DONE]" {
+		t.Errorf("client response events = %+v", events)
+	}
+	if record.Timings.EndMs < call.Timings.EndMs || call.Timings.EndMs < call.Timings.StartMs {
+		t.Errorf("timings out of order: exchange %+v, upstream %+v", record.Timings, call.Timings)
+	}
+}
+
+func TestRedactJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		prompts bool
+		want    string
+	}{
+		{"secrets", `{"refresh_token":"r","api_key":"k","max_tokens":10}`, false, `{"api_key":"[REDACTED]","max_tokens":10,"refresh_token":"[REDACTED]"}`},
+		{"nothing to redact keeps the bytes", `{"model": "m", "n": 1.50}`, false, `{"model": "m", "n": 1.50}`},
+		{"prompts kept by default", `{"messages":[{"role":"user","content":"hi"}]}`, false, `{"messages":[{"role":"user","content":"hi"}]}`},
+		{"openai prompts", `{"messages":[{"role":"user","content":"hello"}]}`, true, `{"messages":[{"content":"[REDACTED 5 chars]","role":"user"}]}`},
+		{"anthropic blocks keep their types", `{"content":[{"type":"text","text":"hey"},{"type":"tool_use","name":"get_weather","input":{"city":"Paris"}}]}`, true,
+			`{"content":[{"text":"[REDACTED 3 chars]","type":"text"},{"input":{"city":"[REDACTED 5 chars]"},"name":"get_weather","type":"tool_use"}]}`},
+		{"tool call arguments", `{"tool_calls":[{"function":{"name":"f","arguments":"{\"a\":1}"}}]}`, true, `{"tool_calls":[{"function":{"arguments":"[REDACTED 7 chars]","name":"f"}}]}`},
+		{"finish reasons stay", `{"candidates":[{"finishReason":"STOP"}]}`, true, `{"candidates":[{"finishReason":"STOP"}]}`},
+	}
+	for _, tt := range tests {
+		if got := string(redactJSON([]byte(tt.body), tt.prompts)); got != tt.want {
+			t.Errorf("%s: redactJSON() = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRedactURLAndHeader(t *testing.T) {
+	u, _ := url.Parse("https://generativelanguage.googleapis.com/v1/models?key=abc&alt=sse")
+	if got := redactURL(u); got != "https://generativelanguage.googleapis.com/v1/models?alt=sse&key=%5BREDACTED%5D" {
+		t.Errorf("redactURL() = %s", got)
+	}
+	header := http.Header{"X-Api-Key": {"k"}, "Cookie": {"c"}, "Content-Type": {"application/json"}}
+	got := redactHeader(header)
+	if got.Get("X-Api-Key") != redacted || got.Get("Cookie") != redacted || got.Get("Content-Type") != "application/json" {
+		t.Errorf("redactHeader() = %v", got)
+	}
+	if header.Get("X-Api-Key") != "k" {
+		t.Errorf("redactHeader() modified its argument")
+	}
+}
+
+func TestRedactPromptsOmitsBinaryBodies(t *testing.T) {
+	recorder := openRecorder(t, config.AuditConfig{RedactPrompts: true})
+	body := &capture{limit: 1 << 10}
+	body.Write([]byte{0x00, 0x00, 0x01, 0xff, 0xfe})
+	msg := recorder.message(Message{Header: http.Header{"Content-Type": {"application/vnd.amazon.eventstream"}}}, body)
+	if msg.Raw != nil || msg.Omitted == "" {
+		t.Errorf("binary body recorded with prompt redaction: %+v", msg)
+	}
+}
+
+func TestTruncation(t *testing.T) {
+	recorder := openRecorder(t, config.AuditConfig{MaxBodyBytes: 8})
+	body := &capture{limit: recorder.maxBody}
+	body.Write([]byte(`{"model":"long-model-name"}`))
+	msg := recorder.message(Message{}, body)
+	if !msg.Truncated || msg.Text != `{"model"` || msg.Body != nil {
+		t.Errorf("truncated body = %+v", msg)
+	}
+}
+
+func TestRotation(t *testing.T) {
+	dir := t.TempDir()
+	recorder := openRecorder(t, config.AuditConfig{Dir: dir, MaxFileBytes: 1, MaxFiles: 2})
+	for i := 0; i < 4; i++ {
+		if err := recorder.Write(&Record{Model: "m"}); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+		time.Sleep(2 * time.Millisecond) // File names have millisecond precision
+	}
+	files, _ := Files(dir)
+	if len(files) != 2 {
+		t.Errorf("kept %d files, want 2", len(files))
+	}
+	if records := readRecords(t, dir); len(records) != 2 {
+		t.Errorf("kept %d records, want the last 2", len(records))
+	}
+
+	// Age starts a new file as well
+	dir = t.TempDir()
+	recorder = openRecorder(t, config.AuditConfig{Dir: dir, MaxFileAge: time.Hour})
+	recorder.Write(&Record{})
+	recorder.Write(&Record{})
+	recorder.opened = recorder.opened.Add(-2 * time.Hour)
+	time.Sleep(2 * time.Millisecond)
+	recorder.Write(&Record{})
+	if files, _ := Files(dir); len(files) != 2 {
+		t.Errorf("age rotation produced %d files, want 2", len(files))
+	}
+}
+
+func TestSampling(t *testing.T) {
+	recorder := openRecorder(t, config.AuditConfig{})
+	recorder.sampleRate = 0
+	if e, _ := recorder.Begin(httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)); e != nil {
+		t.Errorf("Begin() sampled a request at rate 0")
+	}
+	recorder.sampleRate = 0.5
+	sampled := 0
+	for i := 0; i < 1000; i++ {
+		if e, _ := recorder.Begin(httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)); e != nil {
+			sampled++
+		}
+	}
+	if sampled < 400 || sampled > 600 {
+		t.Errorf("sampled %d of 1000 requests at rate 0.5", sampled)
+	}
+}
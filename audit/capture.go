@@ -0,0 +1,387 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Exchange collects what is recorded about one request while it is served
+type Exchange struct {
+	recorder *Recorder
+	start    time.Time
+	request  Message
+	body     *capture
+	response *responseRecorder
+
+	mu       sync.Mutex
+	upstream []*upstreamCall
+}
+
+type exchangeKey struct{}
+
+// Begin starts recording a request if it is sampled. The returned request captures
+// its body as the handler reads it and carries the Exchange for Transport. The
+// Exchange is nil when the request is not recorded.
+func (r *Recorder) Begin(req *http.Request) (*Exchange, *http.Request) {
+	if r == nil || !r.sampled() {
+		return nil, req
+	}
+	e := &Exchange{
+		recorder: r,
+		start:    time.Now(),
+		request: Message{
+			Method: req.Method,
+			URL:    redactURL(req.URL),
+			Header: req.Header.Clone(),
+		},
+		body: &capture{limit: r.maxBody},
+	}
+	req = req.WithContext(context.WithValue(req.Context(), exchangeKey{}, e))
+	if req.Body != nil && req.Body != http.NoBody {
+		req.Body = &teeBody{ReadCloser: req.Body, capture: e.body}
+	}
+	return e, req
+}
+
+// WrapResponse returns a writer that records the response sent to the client
+func (e *Exchange) WrapResponse(w http.ResponseWriter) http.ResponseWriter {
+	e.response = &responseRecorder{ResponseWriter: w, body: &capture{limit: e.recorder.maxBody}}
+	return e.response
+}
+
+// Meta describes the exchange once it has been served
+type Meta struct {
+	RequestID string
+	Key       string
+	Provider  string
+	Model     string
+}
+
+// Finish writes the record of the exchange
+func (e *Exchange) Finish(meta Meta) error {
+	end := time.Now()
+	record := &Record{
+		Time:            e.start,
+		RequestID:       meta.RequestID,
+		Key:             meta.Key,
+		Provider:        meta.Provider,
+		Model:           meta.Model,
+		Request:         e.recorder.message(e.request, e.body),
+		Timings:         Timings{EndMs: e.offset(end)},
+		PromptsRedacted: e.recorder.redactPrompts,
+	}
+	if resp := e.response; resp != nil {
+		status := resp.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		record.Response = e.recorder.message(Message{Status: status, Header: resp.Header().Clone()}, resp.body)
+		if !resp.firstWrite.IsZero() {
+			record.Timings.FirstByteMs = e.offset(resp.firstWrite)
+		}
+	}
+
+	e.mu.Lock()
+	calls := append([]*upstreamCall(nil), e.upstream...)
+	e.mu.Unlock()
+	for _, call := range calls {
+		record.Upstream = append(record.Upstream, call.record(e))
+	}
+	return e.recorder.Write(record)
+}
+
+// offset returns the milliseconds from the start of the exchange to t
+func (e *Exchange) offset(t time.Time) int64 {
+	return t.Sub(e.start).Milliseconds()
+}
+
+// message completes msg with a redacted copy of its headers and captured body
+func (r *Recorder) message(msg Message, body *capture) Message {
+	msg.Header = redactHeader(msg.Header)
+	data, truncated := body.snapshot()
+	msg.Truncated = truncated
+	if len(data) == 0 {
+		return msg
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	switch {
+	case mediaType == "text/event-stream":
+		msg.Events = ParseEvents(data)
+		for i, event := range msg.Events {
+			if event.Data != nil {
+				msg.Events[i].Data = redactJSON(event.Data, r.redactPrompts)
+			}
+		}
+	case json.Valid(data):
+		msg.Body = redactJSON(data, r.redactPrompts)
+	case !utf8.Valid(data):
+		if r.redactPrompts {
+			msg.Omitted = "binary body cannot be redacted"
+		} else {
+			msg.Raw = data
+		}
+	case r.redactPrompts:
+		msg.Omitted = "non-JSON body cannot be redacted"
+	default:
+		msg.Text = string(data)
+	}
+	return msg
+}
+
+// ParseEvents parses a server-sent event stream; an incomplete last event is dropped
+func ParseEvents(data []byte) []Event {
+	var events []Event
+	var name string
+	var payload []string
+	flush := func() {
+		if name != "" || payload != nil {
+			event := Event{Event: name}
+			joined := []byte(strings.Join(payload, "\n"))
+			if json.Valid(joined) {
+				event.Data = joined
+			} else {
+				event.Text = string(joined)
+			}
+			events = append(events, event)
+		}
+		name, payload = "", nil
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), len(data)+1)
+	for scanner.Scan() {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch {
+		case line == "":
+			flush()
+		case field == "event":
+			name = value
+		case field == "data":
+			payload = append(payload, value)
+		}
+	}
+	return events
+}
+
+// capture keeps the first limit bytes written to it
+type capture struct {
+	mu        sync.Mutex
+	buf       bytes.Buffer
+	limit     int
+	truncated bool
+}
+
+// Write implements io.Writer interface
+func (c *capture) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if room := c.limit - c.buf.Len(); len(p) > room {
+		c.buf.Write(p[:max(room, 0)])
+		c.truncated = true
+	} else {
+		c.buf.Write(p)
+	}
+	return len(p), nil
+}
+
+// snapshot returns a copy of the captured bytes
+func (c *capture) snapshot() ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return bytes.Clone(c.buf.Bytes()), c.truncated
+}
+
+// teeBody captures a request body as it is read
+type teeBody struct {
+	io.ReadCloser
+	capture *capture
+}
+
+// Read implements io.Reader interface
+func (b *teeBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.capture.Write(p[:n])
+	return n, err
+}
+
+// responseRecorder records the status, headers and body sent to the client
+type responseRecorder struct {
+	http.ResponseWriter
+	status     int
+	firstWrite time.Time
+	body       *capture
+}
+
+// WriteHeader records and forwards the status code
+func (w *responseRecorder) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// Write records and forwards body bytes
+func (w *responseRecorder) Write(b []byte) (int, error) {
+	if w.firstWrite.IsZero() {
+		w.firstWrite = time.Now()
+	}
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush implements http.Flusher interface
+func (w *responseRecorder) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap returns the wrapped writer for http.ResponseController
+func (w *responseRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Transport wraps an upstream RoundTripper so calls made for a recorded request are
+// added to its Exchange
+func Transport(base http.RoundTripper) http.RoundTripper {
+	return &transport{base: base}
+}
+
+type transport struct {
+	base http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper interface
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	e, _ := req.Context().Value(exchangeKey{}).(*Exchange)
+	if e == nil {
+		return t.base.RoundTrip(req)
+	}
+
+	call := &upstreamCall{
+		start:    time.Now(),
+		request:  Message{Method: req.Method, URL: redactURL(req.URL), Header: req.Header.Clone()},
+		sent:     &capture{limit: e.recorder.maxBody},
+		received: &capture{limit: e.recorder.maxBody},
+	}
+	if req.Body != nil && req.Body != http.NoBody {
+		// Upstream bodies are built in memory, so reading one ahead costs little
+		data, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		call.sent.Write(data)
+		req = req.Clone(req.Context())
+		req.Body = io.NopCloser(bytes.NewReader(data))
+		req.GetBody = func() (io.ReadCloser, error) { return io.NopCloser(bytes.NewReader(data)), nil }
+	}
+	e.mu.Lock()
+	e.upstream = append(e.upstream, call)
+	e.mu.Unlock()
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		call.finish(err)
+		return nil, err
+	}
+	call.mu.Lock()
+	call.status, call.header = resp.StatusCode, resp.Header.Clone()
+	call.mu.Unlock()
+	resp.Body = &upstreamBody{ReadCloser: resp.Body, call: call}
+	return resp, nil
+}
+
+// upstreamCall is one recorded call to a provider
+type upstreamCall struct {
+	start    time.Time
+	request  Message
+	sent     *capture
+	received *capture
+
+	mu        sync.Mutex // The response body may still be read while the record is built
+	status    int
+	header    http.Header
+	firstByte time.Time
+	end       time.Time
+	err       error
+}
+
+// finish records the end of the call
+func (c *upstreamCall) finish(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.end.IsZero() {
+		c.end, c.err = time.Now(), err
+	}
+}
+
+// record builds the recorded form of the call
+func (c *upstreamCall) record(e *Exchange) Upstream {
+	c.mu.Lock()
+	status, header, firstByte, end, err := c.status, c.header, c.firstByte, c.end, c.err
+	c.mu.Unlock()
+
+	upstream := Upstream{
+		Request: e.recorder.message(c.request, c.sent),
+		Timings: Timings{StartMs: e.offset(c.start)},
+	}
+	if status != 0 {
+		upstream.Response = e.recorder.message(Message{Status: status, Header: header}, c.received)
+	}
+	if err != nil {
+		upstream.Error = err.Error()
+	}
+	if !firstByte.IsZero() {
+		upstream.Timings.FirstByteMs = e.offset(firstByte)
+	}
+	if end.IsZero() {
+		end = time.Now() // Still being read, as when the client went away
+	}
+	upstream.Timings.EndMs = e.offset(end)
+	return upstream
+}
+
+// upstreamBody captures an upstream response body as it is read
+type upstreamBody struct {
+	io.ReadCloser
+	call *upstreamCall
+}
+
+// Read implements io.Reader interface
+func (b *upstreamBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		b.call.mu.Lock()
+		if b.call.firstByte.IsZero() {
+			b.call.firstByte = time.Now()
+		}
+		b.call.mu.Unlock()
+		b.call.received.Write(p[:n])
+	}
+	if err == io.EOF {
+		b.call.finish(nil)
+	} else if err != nil {
+		b.call.finish(err)
+	}
+	return n, err
+}
+
+// Close implements io.Closer interface
+func (b *upstreamBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.call.finish(nil)
+	return err
+}
@@ -0,0 +1,141 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// redacted replaces secret values
+const redacted = "[REDACTED]"
+
+// secretNames are header, query parameter and JSON field names holding credentials,
+// lowercased without dashes and underscores
+var secretNames = map[string]bool{
+	"authorization":      true,
+	"proxyauthorization": true,
+	"cookie":             true,
+	"setcookie":          true,
+	"key":                true,
+	"apikey":             true,
+	"xapikey":            true,
+	"xgoogapikey":        true,
+	"token":              true,
+	"accesstoken":        true,
+	"refreshtoken":       true,
+	"idtoken":            true,
+	"sessiontoken":       true,
+	"clientsecret":       true,
+	"secret":             true,
+	"password":           true,
+}
+
+func isSecret(name string) bool {
+	name = strings.NewReplacer("-", "", "_", "").Replace(strings.ToLower(name))
+	return secretNames[name]
+}
+
+// promptFields hold message text; their string values are replaced when prompts are
+// redacted. Tool arguments can hold anything, so every string under argFields is replaced.
+var (
+	promptFields = map[string]bool{
+		"content":           true,
+		"text":              true,
+		"system":            true,
+		"prompt":            true,
+		"instructions":      true,
+		"thinking":          true,
+		"reasoning_content": true,
+		"partial_json":      true,
+	}
+	argFields = map[string]bool{
+		"arguments": true,
+		"args":      true,
+		"input":     true,
+	}
+)
+
+// redactHeader returns a copy of h with credentials replaced
+func redactHeader(h http.Header) http.Header {
+	if len(h) == 0 {
+		return nil
+	}
+	out := h.Clone()
+	for name := range out {
+		if isSecret(name) {
+			out[name] = []string{redacted}
+		}
+	}
+	return out
+}
+
+// redactURL replaces credentials passed as query parameters, such as key=
+func redactURL(u *url.URL) string {
+	if u.RawQuery == "" {
+		return u.String()
+	}
+	query := u.Query()
+	for name := range query {
+		if isSecret(name) {
+			query[name] = []string{redacted}
+		}
+	}
+	clean := *u
+	clean.RawQuery = query.Encode()
+	return clean.String()
+}
+
+// redactJSON replaces credentials and, with prompts set, message text in a JSON value.
+// The original bytes are returned when nothing was replaced.
+func redactJSON(data []byte, prompts bool) []byte {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber() // Keep numbers exactly as sent
+	var value interface{}
+	if dec.Decode(&value) != nil {
+		return data
+	}
+	r := redactor{prompts: prompts}
+	value = r.walk(value, "", false)
+	if !r.changed {
+		return data
+	}
+	out, err := json.Marshal(value)
+	if err != nil {
+		return data
+	}
+	return out
+}
+
+type redactor struct {
+	prompts bool
+	changed bool
+}
+
+// walk redacts value, found under key; all strings are replaced when under a tool argument
+func (r *redactor) walk(value interface{}, key string, underArgs bool) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for k, child := range v {
+			v[k] = r.walk(child, k, underArgs || (r.prompts && argFields[k]))
+		}
+		return v
+	case []interface{}:
+		for i, child := range v {
+			v[i] = r.walk(child, key, underArgs)
+		}
+		return v
+	case string:
+		switch {
+		case isSecret(key):
+			r.changed = true
+			return redacted
+		case r.prompts && (underArgs || promptFields[key] || argFields[key]):
+			r.changed = true
+			return fmt.Sprintf("[REDACTED %d chars]", len(v))
+		}
+	}
+	return value
+}
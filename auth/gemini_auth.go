@@ -16,6 +16,9 @@ import (
 	"time"
 
 	"github.com/sunbankio/qwencoder-proxy/logging"
+	"github.com/sunbankio/qwencoder-proxy/metrics"
+	"github.com/sunbankio/qwencoder-proxy/tracing"
+	"github.com/sunbankio/qwencoder-proxy/transport"
 	"golang.org/x/oauth2"
 )
 
@@ -27,6 +30,7 @@ type GeminiOAuthConfig struct {
 	RedirectPort int
 	CredsDir     string
 	CredsFile    string
+	ProviderName string // Provider whose proxy and CA settings token requests use; defaults to gemini-cli
 }
 
 // DefaultGeminiOAuthConfig returns the default Gemini OAuth configuration
@@ -66,13 +70,24 @@ func NewGeminiAuthenticator(config *GeminiOAuthConfig) *GeminiAuthenticator {
 	}
 	return &GeminiAuthenticator{
 		config:     config,
-		logger:     logging.NewLogger(),
-		httpClient: &http.Client{Timeout: 30 * time.Second},
+		logger:     logging.NewLogger("auth"),
+		httpClient: transport.NewAuthClient(config.providerName()),
 	}
 }
 
-// GetCredentialsPath returns the path to the credentials file
+// providerName returns the provider whose egress settings the authenticator uses
+func (c *GeminiOAuthConfig) providerName() string {
+	if c.ProviderName == "" {
+		return "gemini-cli"
+	}
+	return c.ProviderName
+}
+
+// GetCredentialsPath returns the path to the credentials file; CredsDir is relative to the home directory unless absolute
 func (a *GeminiAuthenticator) GetCredentialsPath() string {
+	if filepath.IsAbs(a.config.CredsDir) {
+		return filepath.Join(a.config.CredsDir, a.config.CredsFile)
+	}
 	homeDir, _ := os.UserHomeDir()
 	return filepath.Join(homeDir, a.config.CredsDir, a.config.CredsFile)
 }
@@ -100,6 +115,16 @@ func (a *GeminiAuthenticator) IsAuthenticated() bool {
 	return a.credentials != nil && time.Unix(a.credentials.ExpiryDate, 0).After(time.Now().Add(buffer))
 }
 
+// TokenExpiry returns when the stored access token expires
+func (a *GeminiAuthenticator) TokenExpiry() time.Time {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if a.credentials == nil || a.credentials.ExpiryDate == 0 {
+		return time.Time{}
+	}
+	return time.Unix(a.credentials.ExpiryDate, 0)
+}
+
 // loadCredentials loads credentials from file
 func (a *GeminiAuthenticator) loadCredentials() (*GeminiCredentials, error) {
 	credsPath := a.GetCredentialsPath()
@@ -156,7 +181,14 @@ func (a *GeminiAuthenticator) ClearCredentials() error {
 }
 
 // GetToken returns a valid access token, refreshing if necessary
-func (a *GeminiAuthenticator) GetToken(ctx context.Context) (string, error) {
+func (a *GeminiAuthenticator) GetToken(ctx context.Context) (accessToken string, err error) {
+	logger := a.logger.WithContext(ctx)
+	ctx, span := tracing.Start(ctx, "GetToken", tracing.String("auth.authenticator", a.config.providerName()))
+	defer func() {
+		span.RecordError(err)
+		span.End()
+	}()
+
 	a.mu.Lock()
 	defer a.mu.Unlock()
 
@@ -196,7 +228,7 @@ func (a *GeminiAuthenticator) GetToken(ctx context.Context) (string, error) {
 
 	// Check if we need to force refresh (if within buffer or expired)
 	if time.Until(token.Expiry) < buffer {
-		a.logger.InfoLog("[Gemini Auth] Token expiring in less than 30m or expired, forcing refresh")
+		logger.InfoLog("[Gemini Auth] Token expiring in less than 30m or expired, forcing refresh")
 		// Trick ReuseTokenSource by making the token look expired
 		token.Expiry = time.Now().Add(-1 * time.Second)
 	}
@@ -207,7 +239,8 @@ func (a *GeminiAuthenticator) GetToken(ctx context.Context) (string, error) {
 	// Get token (this will refresh if needed/forced)
 	newToken, err := ts.Token()
 	if err != nil {
-		a.logger.ErrorLog("[Gemini Auth] Token refresh failed: %v", err)
+		logger.ErrorLog("[Gemini Auth] Token refresh failed: %v", err)
+		metrics.ObserveTokenRefresh(a.config.providerName(), err)
 		// Clear creds on failure so we retry/reload next time
 		a.credentials = nil
 		return "", fmt.Errorf("failed to refresh token: %w", err)
@@ -215,7 +248,8 @@ func (a *GeminiAuthenticator) GetToken(ctx context.Context) (string, error) {
 
 	// Check if token changed or was refreshed
 	if newToken.AccessToken != a.credentials.AccessToken || newToken.RefreshToken != a.credentials.RefreshToken {
-		a.logger.InfoLog("[Gemini Auth] Token refreshed successfully, saving credentials")
+		logger.InfoLog("[Gemini Auth] Token refreshed successfully, saving credentials")
+		metrics.ObserveTokenRefresh(a.config.providerName(), nil)
 		
 		a.credentials.AccessToken = newToken.AccessToken
 		// ReuseTokenSource ensures RefreshToken is preserved if not returned
@@ -228,7 +262,7 @@ func (a *GeminiAuthenticator) GetToken(ctx context.Context) (string, error) {
 		}
 
 		if err := a.saveCredentials(a.credentials); err != nil {
-			a.logger.ErrorLog("Failed to save refreshed credentials: %v", err)
+			logger.ErrorLog("Failed to save refreshed credentials: %v", err)
 		}
 	}
 
@@ -237,6 +271,7 @@ func (a *GeminiAuthenticator) GetToken(ctx context.Context) (string, error) {
 
 // ForceRefresh forces a token refresh regardless of expiry
 func (a *GeminiAuthenticator) ForceRefresh(ctx context.Context) error {
+	logger := a.logger.WithContext(ctx)
 	a.mu.Lock()
 	defer a.mu.Unlock()
 
@@ -282,7 +317,7 @@ func (a *GeminiAuthenticator) ForceRefresh(ctx context.Context) error {
 	ctx = context.WithValue(ctx, oauth2.HTTPClient, a.httpClient)
 	ts := conf.TokenSource(ctx, token)
 	newToken, err := ts.Token()
-	
+	metrics.ObserveTokenRefresh(a.config.providerName(), err)
 	if err != nil {
 		a.credentials.ExpiryDate = originalExpiry // Restore if failed
 		return fmt.Errorf("failed to refresh token: %w", err)
@@ -294,10 +329,10 @@ func (a *GeminiAuthenticator) ForceRefresh(ctx context.Context) error {
 	a.credentials.ExpiryDate = newToken.Expiry.Unix()
 	
 	if err := a.saveCredentials(a.credentials); err != nil {
-		a.logger.ErrorLog("Failed to save refreshed credentials: %v", err)
+		logger.ErrorLog("Failed to save refreshed credentials: %v", err)
 	}
 	
-	a.logger.InfoLog("[Gemini Auth] Token forced refresh successful")
+	logger.InfoLog("[Gemini Auth] Token forced refresh successful")
 	return nil
 }
 
@@ -434,6 +469,6 @@ func (a *GeminiAuthenticator) exchangeCodeForTokens(ctx context.Context, code, r
 		return fmt.Errorf("failed to save credentials: %w", err)
 	}
 
-	a.logger.DebugLog("[Gemini Auth] Authentication successful, credentials saved")
+	a.logger.WithContext(ctx).DebugLog("[Gemini Auth] Authentication successful, credentials saved")
 	return nil
 }
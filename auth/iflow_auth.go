@@ -17,6 +17,9 @@ import (
 	"time"
 
 	"github.com/sunbankio/qwencoder-proxy/logging"
+	"github.com/sunbankio/qwencoder-proxy/metrics"
+	"github.com/sunbankio/qwencoder-proxy/tracing"
+	"github.com/sunbankio/qwencoder-proxy/transport"
 	"golang.org/x/oauth2"
 )
 
@@ -144,8 +147,8 @@ func NewIFlowAuthenticator(config *IFlowOAuthConfig) *IFlowAuthenticator {
 	}
 	return &IFlowAuthenticator{
 		config:     config,
-		logger:     logging.NewLogger(),
-		httpClient: &http.Client{Timeout: 30 * time.Second},
+		logger:     logging.NewLogger("auth"),
+		httpClient: transport.NewAuthClient("iflow"),
 	}
 }
 
@@ -174,14 +177,21 @@ func (a *IFlowAuthenticator) Authenticate(ctx context.Context) error {
 
 	// Fetch user info and API key
 	if err := a.fetchUserInfo(); err != nil {
-		a.logger.DebugLog("[iFlow] Failed to fetch user info: %v", err)
+		a.logger.WithContext(ctx).DebugLog("[iFlow] Failed to fetch user info: %v", err)
 	}
 
 	return nil
 }
 
 // GetToken returns a valid API key for LLM calls, refreshing if necessary
-func (a *IFlowAuthenticator) GetToken(ctx context.Context) (string, error) {
+func (a *IFlowAuthenticator) GetToken(ctx context.Context) (accessToken string, err error) {
+	logger := a.logger.WithContext(ctx)
+	ctx, span := tracing.Start(ctx, "GetToken", tracing.String("auth.authenticator", "iflow"))
+	defer func() {
+		span.RecordError(err)
+		span.End()
+	}()
+
 	a.mu.Lock()
 	defer a.mu.Unlock()
 
@@ -228,13 +238,15 @@ func (a *IFlowAuthenticator) GetToken(ctx context.Context) (string, error) {
 	// Get token (this will refresh if needed)
 	newToken, err := ts.Token()
 	if err != nil {
-		a.logger.ErrorLog("[iFlow Auth] Token refresh failed: %v", err)
+		logger.ErrorLog("[iFlow Auth] Token refresh failed: %v", err)
+		metrics.ObserveTokenRefresh("iflow", err)
 		return "", fmt.Errorf("failed to refresh token: %w", err)
 	}
 
 	// Update credentials if token changed
 	if newToken.AccessToken != a.credentials.AccessToken || newToken.RefreshToken != a.credentials.RefreshToken {
-		a.logger.InfoLog("[iFlow Auth] Token refreshed successfully, saving credentials")
+		logger.InfoLog("[iFlow Auth] Token refreshed successfully, saving credentials")
+		metrics.ObserveTokenRefresh("iflow", nil)
 
 		a.credentials.AccessToken = newToken.AccessToken
 		a.credentials.RefreshToken = newToken.RefreshToken
@@ -246,20 +258,20 @@ func (a *IFlowAuthenticator) GetToken(ctx context.Context) (string, error) {
 
 		// Fetch user info and API key after refresh
 		if err := a.fetchUserInfo(); err != nil {
-			a.logger.ErrorLog("[iFlow Auth] Failed to fetch user info after refresh: %v", err)
+			logger.ErrorLog("[iFlow Auth] Failed to fetch user info after refresh: %v", err)
 		} else {
-			a.logger.InfoLog("[iFlow Auth] User info and API key updated successfully")
+			logger.InfoLog("[iFlow Auth] User info and API key updated successfully")
 		}
 
 		if err := a.saveCredentials(); err != nil {
-			a.logger.ErrorLog("Failed to save refreshed credentials: %v", err)
+			logger.ErrorLog("Failed to save refreshed credentials: %v", err)
 		}
 	}
 
 	// If we still don't have an API key, try to fetch it
 	if a.credentials.APIKey == "" {
 		if err := a.fetchUserInfo(); err != nil {
-			a.logger.ErrorLog("[iFlow Auth] Failed to fetch API key: %v", err)
+			logger.ErrorLog("[iFlow Auth] Failed to fetch API key: %v", err)
 		} else {
 			a.saveCredentials()
 		}
@@ -284,6 +296,16 @@ func (a *IFlowAuthenticator) GetAPIKey() string {
 	return a.credentials.APIKey
 }
 
+// GetEmail returns the email address of the authenticated account
+func (a *IFlowAuthenticator) GetEmail() string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if a.credentials == nil {
+		return ""
+	}
+	return a.credentials.Email
+}
+
 // IsAuthenticated checks if valid credentials exist
 func (a *IFlowAuthenticator) IsAuthenticated() bool {
 	a.mu.RLock()
@@ -300,8 +322,22 @@ func (a *IFlowAuthenticator) IsAuthenticated() bool {
 	return a.credentials.IsValid()
 }
 
-// GetCredentialsPath returns the path to stored credentials
+// TokenExpiry returns when the stored access token expires; cookie logins do not expire
+func (a *IFlowAuthenticator) TokenExpiry() time.Time {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if a.credentials == nil {
+		return time.Time{}
+	}
+	expire, _ := time.Parse(time.RFC3339, a.credentials.GetExpire())
+	return expire
+}
+
+// GetCredentialsPath returns the path to stored credentials; CredsDir is relative to the home directory unless absolute
 func (a *IFlowAuthenticator) GetCredentialsPath() string {
+	if filepath.IsAbs(a.config.CredsDir) {
+		return filepath.Join(a.config.CredsDir, a.config.CredsFile)
+	}
 	homeDir, _ := os.UserHomeDir()
 	return filepath.Join(homeDir, a.config.CredsDir, a.config.CredsFile)
 }
@@ -13,6 +13,9 @@ import (
 	"time"
 
 	"github.com/sunbankio/qwencoder-proxy/logging"
+	"github.com/sunbankio/qwencoder-proxy/metrics"
+	"github.com/sunbankio/qwencoder-proxy/tracing"
+	"github.com/sunbankio/qwencoder-proxy/transport"
 	"golang.org/x/oauth2"
 )
 
@@ -65,8 +68,8 @@ func NewKiroAuthenticator(config *KiroOAuthConfig) *KiroAuthenticator {
 	}
 	return &KiroAuthenticator{
 		config:     config,
-		logger:     logging.NewLogger(),
-		httpClient: &http.Client{Timeout: 30 * time.Second},
+		logger:     logging.NewLogger("auth"),
+		httpClient: transport.NewAuthClient("kiro"),
 	}
 }
 
@@ -108,6 +111,17 @@ func (a *KiroAuthenticator) IsAuthenticated() bool {
 	return a.credentials != nil && a.credentials.AccessToken != ""
 }
 
+// TokenExpiry returns when the stored access token expires
+func (a *KiroAuthenticator) TokenExpiry() time.Time {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if a.credentials == nil {
+		return time.Time{}
+	}
+	expiresAt, _ := time.Parse(time.RFC3339, a.credentials.ExpiresAt)
+	return expiresAt
+}
+
 // loadCredentials loads credentials from file
 func (a *KiroAuthenticator) loadCredentials() (*KiroCredentials, error) {
 	credsPath := a.GetCredentialsPath()
@@ -227,7 +241,14 @@ func (k *kiroTokenRefresher) Token() (*oauth2.Token, error) {
 }
 
 // GetToken returns a valid access token, refreshing if necessary
-func (a *KiroAuthenticator) GetToken(ctx context.Context) (string, error) {
+func (a *KiroAuthenticator) GetToken(ctx context.Context) (accessToken string, err error) {
+	logger := a.logger.WithContext(ctx)
+	ctx, span := tracing.Start(ctx, "GetToken", tracing.String("auth.authenticator", "kiro"))
+	defer func() {
+		span.RecordError(err)
+		span.End()
+	}()
+
 	a.mu.Lock()
 	defer a.mu.Unlock()
 
@@ -262,7 +283,7 @@ func (a *KiroAuthenticator) GetToken(ctx context.Context) (string, error) {
 	// Check buffer (30 mins)
 	buffer := time.Duration(TokenRefreshBufferMs) * time.Millisecond
 	if time.Until(token.Expiry) < buffer {
-		a.logger.InfoLog("[Kiro Auth] Token expiring in less than 30m or expired, forcing refresh")
+		logger.InfoLog("[Kiro Auth] Token expiring in less than 30m or expired, forcing refresh")
 		token.Expiry = time.Now().Add(-1 * time.Second)
 	}
 
@@ -272,7 +293,7 @@ func (a *KiroAuthenticator) GetToken(ctx context.Context) (string, error) {
 	// Get token (this triggers refresh if expired)
 	newToken, err := ts.Token()
 	if err != nil {
-		a.logger.ErrorLog("[Kiro Auth] Failed to refresh token: %v", err)
+		logger.ErrorLog("[Kiro Auth] Failed to refresh token: %v", err)
 		// Continue with existing token if refresh fails??
 		// Original code: "Continue with existing token if refresh fails"
 		// But if it's expired, we probably shouldn't.
@@ -285,7 +306,7 @@ func (a *KiroAuthenticator) GetToken(ctx context.Context) (string, error) {
 
 	// Update credentials if changed
 	if newToken.AccessToken != a.credentials.AccessToken || newToken.RefreshToken != a.credentials.RefreshToken {
-		a.logger.InfoLog("[Kiro Auth] Token refreshed successfully, saving credentials")
+		logger.InfoLog("[Kiro Auth] Token refreshed successfully, saving credentials")
 		a.credentials.AccessToken = newToken.AccessToken
 		// ReuseTokenSource preserves refresh token if not returned, so it should be safe.
 		// But kiroTokenRefresher logic below ensures it's set in the returned token.
@@ -293,7 +314,7 @@ func (a *KiroAuthenticator) GetToken(ctx context.Context) (string, error) {
 		a.credentials.ExpiresAt = newToken.Expiry.Format(time.RFC3339)
 
 		if err := a.saveCredentials(a.credentials); err != nil {
-			a.logger.ErrorLog("Failed to save refreshed credentials: %v", err)
+			logger.ErrorLog("Failed to save refreshed credentials: %v", err)
 		}
 	}
 
@@ -301,7 +322,9 @@ func (a *KiroAuthenticator) GetToken(ctx context.Context) (string, error) {
 }
 
 // performRefresh executes the custom Kiro refresh logic and returns an oauth2.Token
-func (a *KiroAuthenticator) performRefresh(ctx context.Context) (*oauth2.Token, error) {
+func (a *KiroAuthenticator) performRefresh(ctx context.Context) (token *oauth2.Token, err error) {
+	defer func() { metrics.ObserveTokenRefresh("kiro", err) }()
+
 	if a.credentials == nil || a.credentials.RefreshToken == "" {
 		return nil, fmt.Errorf("no refresh token available")
 	}
@@ -391,6 +414,7 @@ func (a *KiroAuthenticator) refreshToken(ctx context.Context) error {
 // Authenticate performs the authentication flow
 // For Kiro, we expect pre-existing credentials in the AWS SSO cache
 func (a *KiroAuthenticator) Authenticate(ctx context.Context) error {
+	logger := a.logger.WithContext(ctx)
 	// Try to load existing credentials
 	creds, err := a.loadCredentials()
 	if err != nil {
@@ -404,14 +428,14 @@ func (a *KiroAuthenticator) Authenticate(ctx context.Context) error {
 	// Try to refresh if needed (using new GetToken logic essentially, or just GetToken)
 	_, err = a.GetToken(ctx)
 	if err != nil {
-		a.logger.ErrorLog("[Kiro Auth] Initial token check/refresh failed: %v", err)
+		logger.ErrorLog("[Kiro Auth] Initial token check/refresh failed: %v", err)
 	}
 
 	if a.credentials.AccessToken == "" {
 		return fmt.Errorf("no valid access token found in credentials")
 	}
 
-	a.logger.DebugLog("[Kiro Auth] Authentication successful using existing credentials")
+	logger.DebugLog("[Kiro Auth] Authentication successful using existing credentials")
 	return nil
 }
 
@@ -7,23 +7,32 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"sync"
 	"time"
 
 	"github.com/gofrs/flock"
+	"github.com/sunbankio/qwencoder-proxy/metrics"
+	"github.com/sunbankio/qwencoder-proxy/transport"
 	"golang.org/x/oauth2"
 )
 
 // oauthHTTPClient is a dedicated HTTP client for OAuth operations
-var oauthHTTPClient *http.Client
+var (
+	oauthHTTPClient     *http.Client
+	oauthHTTPClientOnce sync.Once
+)
 
-// initOAuthHTTPClient initializes the OAuth HTTP client with appropriate settings
+// initOAuthHTTPClient initializes the OAuth HTTP client with the Qwen provider's proxy and CA settings
 func initOAuthHTTPClient() {
-	if oauthHTTPClient == nil {
-		// Use conservative timeouts for OAuth operations since they are infrequent
-		oauthHTTPClient = &http.Client{
-			Timeout: 30 * time.Second, // Shorter timeout for auth operations
-		}
-	}
+	oauthHTTPClientOnce.Do(func() {
+		oauthHTTPClient = transport.NewAuthClient("qwen")
+	})
+}
+
+// withOAuthHTTPClient returns a context that makes oauth2 requests use the OAuth HTTP client
+func withOAuthHTTPClient(ctx context.Context) context.Context {
+	initOAuthHTTPClient()
+	return context.WithValue(ctx, oauth2.HTTPClient, oauthHTTPClient)
 }
 
 // OAuthCreds represents the structure of the qwenproxy_creds.json file
@@ -108,7 +117,9 @@ func IsTokenValid(credentials OAuthCreds) bool {
 }
 
 // RefreshAccessToken refreshes the OAuth token using the refresh token
-func RefreshAccessToken(credentials OAuthCreds) (OAuthCreds, error) {
+func RefreshAccessToken(credentials OAuthCreds) (refreshed OAuthCreds, err error) {
+	defer func() { metrics.ObserveTokenRefresh("qwen", err) }()
+
 	if credentials.RefreshToken == "" {
 		return OAuthCreds{}, fmt.Errorf("no refresh token available")
 	}
@@ -120,7 +131,7 @@ func RefreshAccessToken(credentials OAuthCreds) (OAuthCreds, error) {
 		},
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	ctx, cancel := context.WithTimeout(withOAuthHTTPClient(context.Background()), 30*time.Second)
 	defer cancel()
 
 	token := &oauth2.Token{
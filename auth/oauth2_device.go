@@ -25,7 +25,7 @@ func AuthenticateWithDeviceFlow() error {
 		},
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	ctx, cancel := context.WithTimeout(withOAuthHTTPClient(context.Background()), 2*time.Minute)
 	defer cancel()
 
 	codeVerifier, err := generateCodeVerifier()
@@ -53,7 +53,7 @@ func AuthenticateWithDeviceFlow() error {
 
 	// Try to open the verification URI in the browser
 	if err := openBrowser(verificationURL); err != nil {
-		logging.NewLogger().WarningLog("Failed to open browser automatically: %v. Please open the URL manually.", err)
+		logging.NewLogger("auth").WarningLog("Failed to open browser automatically: %v. Please open the URL manually.", err)
 	}
 
 	fmt.Printf("\n=== Qwen OAuth Authentication ===\n")
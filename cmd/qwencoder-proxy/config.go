@@ -0,0 +1,54 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/sunbankio/qwencoder-proxy/config"
+)
+
+// runConfig implements the config subcommand. "config validate [file]" checks a config
+// file, by default the one named by CONFIG_FILE, and lists every problem found in it.
+func runConfig(cfg *config.Config, args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("config", flag.ContinueOnError)
+	fs.SetOutput(out)
+	fs.Usage = func() {
+		fmt.Fprintln(out, "Usage: qwencoder-proxy config validate [file]")
+		fmt.Fprintln(out, "Checks a config file, by default the one named by CONFIG_FILE")
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.Arg(0) != "validate" || fs.NArg() > 2 {
+		fs.Usage()
+		return flag.ErrHelp
+	}
+	path := fs.Arg(1)
+	if path == "" {
+		path = os.Getenv("CONFIG_FILE")
+	}
+	if path == "" {
+		return errors.New("no config file to validate: pass its path or set CONFIG_FILE")
+	}
+
+	file, err := config.LoadFile(path)
+	var fileErr *config.FileError
+	if errors.As(err, &fileErr) {
+		for _, problem := range fileErr.Problems {
+			fmt.Fprintf(out, "%s: %s\n", path, problem)
+		}
+		if len(fileErr.Problems) == 1 {
+			return fmt.Errorf("%s: 1 problem found", path)
+		}
+		return fmt.Errorf("%s: %d problems found", path, len(fileErr.Problems))
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "%s: OK, providers %s\n", path, strings.Join(file.EnabledProviders(), ", "))
+	return nil
+}
@@ -4,94 +4,201 @@ import (
 	"context"
 	"flag"
 	"fmt"
-	"log"
+	"io"
 	"net/http"
 	"os"
+	"os/signal"
+	"sort"
 	"strings"
+	"syscall"
+	"time"
 
+	"github.com/sunbankio/qwencoder-proxy/apikey"
+	"github.com/sunbankio/qwencoder-proxy/audit"
 	"github.com/sunbankio/qwencoder-proxy/auth"
 	"github.com/sunbankio/qwencoder-proxy/config"
 	"github.com/sunbankio/qwencoder-proxy/converter"
 	"github.com/sunbankio/qwencoder-proxy/logging"
 	"github.com/sunbankio/qwencoder-proxy/proxy"
 	"github.com/sunbankio/qwencoder-proxy/provider"
-	"github.com/sunbankio/qwencoder-proxy/provider/antigravity"
-	"github.com/sunbankio/qwencoder-proxy/provider/gemini"
-	iflowProvider "github.com/sunbankio/qwencoder-proxy/provider/iflow"
-	"github.com/sunbankio/qwencoder-proxy/provider/kiro"
-	"github.com/sunbankio/qwencoder-proxy/provider/qwen"
 	"github.com/sunbankio/qwencoder-proxy/qwenclient"
+	"github.com/sunbankio/qwencoder-proxy/tracing"
+	"github.com/sunbankio/qwencoder-proxy/transport"
+	"github.com/sunbankio/qwencoder-proxy/usage"
 )
 
 func main() {
-	// Load configuration
-	cfg := config.LoadConfig()
+	// Load configuration from the config file named by CONFIG_FILE, if any, and the environment
+	cfg, cfgErr := config.Load(os.Getenv("CONFIG_FILE"))
+
+	// Subcommands run instead of the server
+	subcommands := map[string]func(*config.Config, []string, io.Writer) error{
+		"usage":  runUsage,
+		"replay": runReplay,
+		"config": runConfig,
+	}
+	if len(os.Args) > 1 && subcommands[os.Args[1]] != nil {
+		// The config subcommand checks the config file itself, so it runs even if it is invalid
+		if cfgErr != nil && os.Args[1] != "config" {
+			fmt.Fprintln(os.Stderr, cfgErr)
+			os.Exit(1)
+		}
+		run := subcommands[os.Args[1]]
+		if err := run(cfg, os.Args[2:], os.Stdout); err != nil {
+			if err != flag.ErrHelp {
+				fmt.Fprintln(os.Stderr, err)
+			}
+			os.Exit(1)
+		}
+		return
+	}
+	if cfgErr != nil {
+		fmt.Fprintln(os.Stderr, cfgErr)
+		os.Exit(1)
+	}
+
+	if err := runServer(cfg); err != nil {
+		logging.NewLogger("main").ErrorLog("%v", err)
+		os.Exit(1)
+	}
+}
+
+// shutdownTimeout is how long in-flight requests may run once the server is asked to stop
+const shutdownTimeout = 30 * time.Second
+
+// runServer serves the proxy until it is interrupted or terminated, then stops accepting
+// requests, lets in-flight ones finish and writes the state kept in memory
+func runServer(cfg *config.Config) error {
+	// Stop on an interrupt or termination signal
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
 	// Define the debug flag
 	var debugFlag bool
-	flag.BoolVar(&debugFlag, "debug", cfg.Logging.IsDebugMode, "Enable debug mode for verbose logging")
+	flag.BoolVar(&debugFlag, "debug", cfg.Logging.Level == "debug", "Enable debug mode for verbose logging")
 	flag.Parse()
 
-	// Set the global debug mode variable
-	logging.IsDebugMode = debugFlag
+	// Apply logging settings; -debug lowers the default level
+	if debugFlag {
+		cfg.Logging.Level = "debug"
+	}
+	logger := logging.NewLogger("main")
+	if err := logging.Configure(cfg.Logging); err != nil {
+		return fmt.Errorf("invalid logging settings: %w", err)
+	}
+
+	// Apply streaming error handling settings
+	proxy.ConfigureStreaming(cfg.Streaming)
+	proxy.ConfigureContextGuard(cfg.Context)
+
+	// Apply upstream connection and timeout settings before providers build their clients
+	if err := transport.Configure(cfg); err != nil {
+		return fmt.Errorf("invalid upstream connection settings: %w", err)
+	}
+
+	// Start exporting trace spans, if an exporter is configured
+	if err := tracing.Configure(cfg.Tracing); err != nil {
+		return fmt.Errorf("invalid tracing settings: %w", err)
+	}
+
+	// Load the API keys clients must present
+	apiKeys, err := apikey.Load(cfg.Auth)
+	if err != nil {
+		return fmt.Errorf("failed to load API keys: %w", err)
+	}
+	if apiKeys.Len() == 0 {
+		logger.WarningLog("No API keys configured; the proxy accepts unauthenticated requests. Set API_KEYS or API_KEYS_FILE to require a key.")
+	} else {
+		logger.InfoLog("Loaded %d API keys", apiKeys.Len())
+	}
+	limiter, err := apikey.NewLimiter(cfg.Auth.BudgetFile)
+	if err != nil {
+		return fmt.Errorf("failed to load API key budgets: %w", err)
+	}
+	budgetsSaved := limiter.SaveEvery(ctx, apikey.BudgetSaveInterval, func(err error) {
+		logger.ErrorLog("Failed to save API key budgets: %v", err)
+	})
+
+	// Open the usage store that records every completed request
+	usageStore, err := usage.Open(cfg.Usage.Dir, cfg.Usage.RetentionDays)
+	if err != nil {
+		return fmt.Errorf("failed to open usage store: %w", err)
+	}
+	defer usageStore.Close()
+
+	// Open the audit log, if enabled
+	auditRecorder, err := audit.Open(cfg.Audit)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+	if auditRecorder != nil {
+		defer auditRecorder.Close()
+		logger.InfoLog("Recording %.0f%% of requests to the audit log in %s", cfg.Audit.SampleRate*100, cfg.Audit.Dir)
+	}
 
 	// Create provider factory and register providers
 	factory := provider.NewFactory()
 
-	// Register Qwen provider (existing)
-	qwenProvider := qwen.NewProvider()
-	factory.Register(qwenProvider)
-	
-	// Register Gemini provider
-	geminiAuth := auth.NewGeminiAuthenticator(nil)
-	geminiProvider := gemini.NewProvider(geminiAuth)
-	factory.Register(geminiProvider)
-
-	// Register Kiro provider
-	kiroAuth := auth.NewKiroAuthenticator(nil)
-	kiroProvider := kiro.NewProvider(kiroAuth)
-	factory.Register(kiroProvider)
-
-	// Register Antigravity provider
-	antigravityAuth := auth.NewGeminiAuthenticator(&auth.GeminiOAuthConfig{
-		ClientID:     "1071006060591-tmhssin2h21lcre235vtolojh4g403ep.apps.googleusercontent.com",
-		ClientSecret: "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",
-		Scope:        "https://www.googleapis.com/auth/cloud-platform",
-		RedirectPort: 8086,
-		CredsDir:     ".antigravity",
-		CredsFile:    "oauth_creds.json",
+	// Register the providers enabled in the config file, all of them by default
+	if err := registerProviders(factory, cfg.Providers, logger); err != nil {
+		return fmt.Errorf("failed to register providers: %w", err)
+	}
+	factory.SetAliases(cfg.Aliases)
+	factory.SetCircuitBreaker(provider.CircuitBreaker{
+		FailureThreshold: cfg.Routing.CircuitFailureThreshold,
+		Cooldown:         time.Duration(cfg.Routing.CircuitCooldownSeconds) * time.Second,
 	})
-	antigravityProvider := antigravity.NewProvider(antigravityAuth)
-	factory.Register(antigravityProvider)
+	if err := factory.SetConfiguredRules(configuredRules(cfg.Routing)); err != nil {
+		return fmt.Errorf("invalid routing rules in the config file: %w", err)
+	}
 
-	// Register iFlow provider
-	iflowAuth := auth.NewIFlowAuthenticator(nil)
-	iflowProv := iflowProvider.NewProvider(iflowAuth)
-	factory.Register(iflowProv)
+	// Apply the routing rules set through the admin API before any request is routed
+	if err := factory.LoadRoutingRules(cfg.Routing.RulesFile); err != nil {
+		return fmt.Errorf("failed to load routing rules: %w", err)
+	}
 
 	// Create converter factory
 	convFactory := converter.NewFactory()
 
+	// Restore the routing state of the last run. With a cached model catalog, requests are
+	// served from it while the providers' catalogs are fetched in the background.
+	catalogRestored, err := factory.RestoreState(cfg.Routing.StateFile)
+	if err != nil {
+		logger.WarningLog("Failed to restore routing state: %v", err)
+	}
+	stateSaved := factory.SnapshotState(ctx)
+
 	// Populate model providers mapping at startup
-	log.Println("Populating model providers mapping...")
-	if err := factory.PopulateModelProviders(context.Background()); err != nil {
-		log.Printf("Warning: Failed to populate model providers: %v", err)
+	if catalogRestored {
+		logger.InfoLog("Serving the cached model catalog; refreshing model providers mapping in the background...")
+		go func() {
+			if err := factory.PopulateModelProviders(ctx); err != nil {
+				logger.WarningLog("Failed to populate model providers: %v", err)
+				return
+			}
+			logger.InfoLog("Successfully refreshed %d models", len(factory.GetAllModels()))
+		}()
 	} else {
-		// Log the available models and their providers
-		allModels := factory.GetAllModels()
-		log.Printf("Successfully populated %d models:", len(allModels))
-		for model, providers := range allModels {
-			providerNames := make([]string, len(providers))
-			for i, p := range providers {
-				providerNames[i] = string(p)
+		logger.InfoLog("Populating model providers mapping...")
+		if err := factory.PopulateModelProviders(ctx); err != nil {
+			logger.WarningLog("Failed to populate model providers: %v", err)
+		} else {
+			// Log the available models and their providers
+			allModels := factory.GetAllModels()
+			logger.InfoLog("Successfully populated %d models:", len(allModels))
+			for model, providers := range allModels {
+				providerNames := make([]string, len(providers))
+				for i, p := range providers {
+					providerNames[i] = string(p)
+				}
+				logger.InfoLog("  - %s: %v", model, providerNames)
 			}
-			log.Printf("  - %s: %v", model, providerNames)
 		}
 	}
 
 	// Register native format routes
-	proxy.RegisterGeminiRoutes(http.DefaultServeMux, factory)
-	proxy.RegisterAnthropicRoutes(http.DefaultServeMux, factory)
+	proxy.RegisterGeminiRoutes(http.DefaultServeMux, factory, convFactory)
+	proxy.RegisterAnthropicRoutes(http.DefaultServeMux, factory, convFactory)
 
 	// Register provider-specific OpenAI-compatible routes FIRST (more specific)
 	proxy.RegisterProviderSpecificRoutes(http.DefaultServeMux, factory, convFactory)
@@ -99,78 +206,103 @@ func main() {
 	// Register general OpenAI-compatible routes LAST (more general)
 	proxy.RegisterOpenAIRoutes(http.DefaultServeMux, factory, convFactory)
 
-	// Check for credentials on startup
-	log.Println("Checking Qwen credentials...")
-	_, _, err := qwenclient.GetValidTokenAndEndpoint()
-	if err != nil {
-		errorMsg := err.Error()
-		if strings.Contains(errorMsg, "credentials not found") || strings.Contains(errorMsg, "failed to refresh token") {
-			log.Println("Credentials not found or invalid. Initiating authentication flow...")
-			// Ensure the credentials file is removed before attempting authentication
-			credsPath := auth.GetQwenCredentialsPath()
-			if _, fileErr := os.Stat(credsPath); fileErr == nil {
-				if removeErr := os.Remove(credsPath); removeErr != nil {
-					log.Printf("Failed to remove existing credentials file %s: %v", credsPath, removeErr)
-				} else {
-					log.Printf("Successfully removed existing credentials file: %s", credsPath)
+	// Register admin routes
+	proxy.RegisterAdminRoutes(http.DefaultServeMux, usageStore)
+
+	// Check provider health in the background for the health endpoints
+	healthMonitor := provider.NewHealthMonitor(factory, time.Duration(cfg.Health.IntervalSeconds)*time.Second, time.Duration(cfg.Health.TimeoutSeconds)*time.Second)
+	healthMonitor.Start(ctx)
+	proxy.RegisterHealthRoutes(http.DefaultServeMux, healthMonitor)
+
+	// Serve the admin dashboard at /admin
+	proxy.RegisterDashboardRoutes(http.DefaultServeMux, factory)
+
+	// Register the admin endpoints that pin, reorder, disable and drain providers
+	proxy.RegisterRoutingRoutes(http.DefaultServeMux, factory)
+
+	// Register the Prometheus metrics endpoint
+	proxy.RegisterMetricsRoute(http.DefaultServeMux, factory)
+
+	// Check for Qwen credentials on startup, authenticating if there are none
+	if _, err := factory.Get(provider.ProviderQwen); err == nil {
+		logger.InfoLog("Checking Qwen credentials...")
+		_, _, err = qwenclient.GetValidTokenAndEndpoint()
+		if err != nil {
+			errorMsg := err.Error()
+			if strings.Contains(errorMsg, "credentials not found") || strings.Contains(errorMsg, "failed to refresh token") {
+				logger.InfoLog("Credentials not found or invalid. Initiating authentication flow...")
+				// Ensure the credentials file is removed before attempting authentication
+				credsPath := auth.GetQwenCredentialsPath()
+				if _, fileErr := os.Stat(credsPath); fileErr == nil {
+					if removeErr := os.Remove(credsPath); removeErr != nil {
+						logger.InfoLog("Failed to remove existing credentials file %s: %v", credsPath, removeErr)
+					} else {
+						logger.InfoLog("Successfully removed existing credentials file: %s", credsPath)
+					}
 				}
-			}
 
-			authErr := auth.AuthenticateWithOAuth()
-			if authErr != nil {
-				log.Fatalf("Authentication failed during startup: %v", authErr)
+				authErr := auth.AuthenticateWithOAuth()
+				if authErr != nil {
+					return fmt.Errorf("authentication failed during startup: %w", authErr)
+				}
+				logger.InfoLog("Authentication successful. Starting proxy server...")
+			} else {
+				return fmt.Errorf("failed to check credentials on startup: %w", err)
 			}
-			log.Println("Authentication successful. Starting proxy server...")
 		} else {
-			log.Fatalf("Failed to check credentials on startup: %v", err)
+			logger.InfoLog("Credentials found and valid. Starting proxy server...")
 		}
-	} else {
-		log.Println("Credentials found and valid. Starting proxy server...")
 	}
 
 	// Check other providers credentials on startup
-	ctx := context.Background()
-	log.Println("Checking other providers credentials validity and refreshing if needed...")
-
-	// Gemini
-	log.Println("Checking Gemini credentials...")
-	if _, err := geminiProvider.GetAuthenticator().GetToken(ctx); err != nil {
-		log.Printf("Warning: Gemini credentials check failed: %v", err)
-	} else {
-		log.Println("Gemini credentials are valid.")
-	}
-
-	// Kiro
-	log.Println("Checking Kiro credentials...")
-	if _, err := kiroProvider.GetAuthenticator().GetToken(ctx); err != nil {
-		log.Printf("Warning: Kiro credentials check failed: %v", err)
-	} else {
-		log.Println("Kiro credentials are valid.")
+	logger.InfoLog("Checking other providers credentials validity and refreshing if needed...")
+	providerTypes := factory.ListTypes()
+	sort.Slice(providerTypes, func(i, j int) bool { return providerTypes[i] < providerTypes[j] })
+	for _, providerType := range providerTypes {
+		p, err := factory.Get(providerType)
+		if err != nil || providerType == provider.ProviderQwen {
+			continue
+		}
+		logger.InfoLog("Checking %s credentials...", providerType)
+		if _, err := p.GetAuthenticator().GetToken(ctx); err != nil {
+			logger.WarningLog("%s credentials check failed: %v", providerType, err)
+		} else {
+			logger.InfoLog("%s credentials are valid.", providerType)
+		}
 	}
 
-	// Antigravity
-	log.Println("Checking Antigravity credentials...")
-	if _, err := antigravityProvider.GetAuthenticator().GetToken(ctx); err != nil {
-		log.Printf("Warning: Antigravity credentials check failed: %v", err)
-	} else {
-		log.Println("Antigravity credentials are valid.")
+	// Start the server
+	server := &http.Server{
+		Addr:    ":" + cfg.Server.Port,
+		Handler: proxy.AssignRequestIDs(proxy.RequireAPIKey(apiKeys, proxy.TraceRequests(proxy.ObserveRequests(factory, proxy.RecordUsage(usageStore, proxy.RecordAudit(auditRecorder, proxy.ApplyKeyPolicy(limiter, http.DefaultServeMux))))))),
 	}
+	serveErr := make(chan error, 1)
+	go func() {
+		logger.InfoLog("Proxy server starting on port %s (log level %s)", cfg.Server.Port, cfg.Logging.Level)
+		serveErr <- server.ListenAndServe()
+	}()
 
-	// iFlow
-	log.Println("Checking iFlow credentials...")
-	if _, err := iflowProv.GetAuthenticator().GetToken(ctx); err != nil {
-		log.Printf("Warning: iFlow credentials check failed: %v", err)
-	} else {
-		log.Println("iFlow credentials are valid.")
+	var runErr error
+	select {
+	case err := <-serveErr:
+		runErr = fmt.Errorf("server failed: %w", err)
+		stop() // Write the final snapshots below
+	case <-ctx.Done():
+		stop() // A second signal stops the process at once
+		logger.InfoLog("Shutting down; waiting up to %s for in-flight requests...", shutdownTimeout)
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if shutdownErr := server.Shutdown(shutdownCtx); shutdownErr != nil {
+			logger.WarningLog("Failed to finish in-flight requests: %v", shutdownErr)
+		}
+		if flushErr := tracing.Shutdown(shutdownCtx); flushErr != nil {
+			logger.WarningLog("Failed to export the remaining trace spans: %v", flushErr)
+		}
 	}
 
-	// Start the server
-	debugStatus := ""
-	if logging.IsDebugMode {
-		debugStatus = " [DEBUG ON]"
-	}
-	fmt.Printf("Proxy server starting on port %s%s\n", cfg.Server.Port, debugStatus)
-	if err := http.ListenAndServe(":"+cfg.Server.Port, nil); err != nil {
-		log.Fatal("Server failed to start: ", err)
-	}
-}
\ No newline at end of file
+	// The usage store and audit log are closed by their deferred calls once these are written
+	<-budgetsSaved
+	<-stateSaved
+	logger.InfoLog("Proxy server stopped")
+	return runErr
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+
+	"github.com/sunbankio/qwencoder-proxy/auth"
+	"github.com/sunbankio/qwencoder-proxy/config"
+	"github.com/sunbankio/qwencoder-proxy/logging"
+	"github.com/sunbankio/qwencoder-proxy/provider"
+	"github.com/sunbankio/qwencoder-proxy/provider/antigravity"
+	"github.com/sunbankio/qwencoder-proxy/provider/gemini"
+	iflowProvider "github.com/sunbankio/qwencoder-proxy/provider/iflow"
+	"github.com/sunbankio/qwencoder-proxy/provider/kiro"
+	"github.com/sunbankio/qwencoder-proxy/provider/qwen"
+)
+
+// registerProviders registers the enabled providers and their additional accounts with
+// factory, applying the provider settings of the config file
+func registerProviders(factory *provider.Factory, settings map[string]config.ProviderConfig, logger *logging.Logger) error {
+	for _, providerType := range config.ProviderTypes {
+		cfg := settings[providerType]
+		if !config.IsEnabled(cfg.Enabled) {
+			logger.InfoLog("Provider %s is disabled in the config file", providerType)
+			continue
+		}
+		factory.Register(newProvider(provider.ProviderType(providerType), cfg, config.AccountConfig{}))
+		for _, name := range slices.Sorted(maps.Keys(cfg.Accounts)) {
+			account := cfg.Accounts[name]
+			if !config.IsEnabled(account.Enabled) {
+				continue
+			}
+			p, ok := newProvider(provider.ProviderType(providerType), cfg, account).(namedProvider)
+			if !ok {
+				return fmt.Errorf("provider %s does not support additional accounts", providerType)
+			}
+			p.SetName(provider.ProviderType(name))
+			factory.Register(p)
+			logger.InfoLog("Registered %s account %s", providerType, name)
+		}
+	}
+	return nil
+}
+
+// namedProvider is a provider that can be registered under the name of an account
+type namedProvider interface {
+	provider.Provider
+	SetName(name provider.ProviderType)
+}
+
+// newProvider creates a provider of the given type from its settings, with the
+// credentials of account when it is an additional account
+func newProvider(providerType provider.ProviderType, cfg config.ProviderConfig, account config.AccountConfig) provider.Provider {
+	credsDir := firstNonEmpty(account.CredsDir, cfg.CredsDir)
+	switch providerType {
+	case provider.ProviderGeminiCLI:
+		oauth := auth.DefaultGeminiOAuthConfig()
+		applyOAuthSettings(oauth, cfg, credsDir)
+		p := gemini.NewProvider(auth.NewGeminiAuthenticator(oauth))
+		if cfg.BaseURL != "" {
+			p.SetBaseURL(cfg.BaseURL)
+		}
+		return p
+	case provider.ProviderKiro:
+		oauth := auth.DefaultKiroOAuthConfig()
+		oauth.CredsPath = firstNonEmpty(account.CredsPath, cfg.CredsPath, oauth.CredsPath)
+		oauth.Region = firstNonEmpty(account.Region, cfg.Region, oauth.Region)
+		oauth.BaseURL = firstNonEmpty(cfg.BaseURL, oauth.BaseURL)
+		p := kiro.NewProvider(auth.NewKiroAuthenticator(oauth))
+		p.SetModelMappings(cfg.ModelMappings)
+		return p
+	case provider.ProviderAntigravity:
+		oauth := antigravity.DefaultOAuthConfig()
+		applyOAuthSettings(oauth, cfg, credsDir)
+		p := antigravity.NewProvider(auth.NewGeminiAuthenticator(oauth))
+		if cfg.DailyBaseURL != "" {
+			p.SetDailyBaseURL(cfg.DailyBaseURL)
+		}
+		if cfg.AutopushBaseURL != "" {
+			p.SetAutopushBaseURL(cfg.AutopushBaseURL)
+		}
+		p.SetModelMappings(cfg.ModelMappings)
+		return p
+	case provider.ProviderIFlow:
+		oauth := auth.DefaultIFlowOAuthConfig()
+		oauth.ClientID = firstNonEmpty(cfg.ClientID, oauth.ClientID)
+		oauth.ClientSecret = firstNonEmpty(cfg.ClientSecret, oauth.ClientSecret)
+		oauth.CredsDir = firstNonEmpty(credsDir, oauth.CredsDir)
+		if cfg.RedirectPort != 0 {
+			oauth.RedirectPort = cfg.RedirectPort
+		}
+		p := iflowProvider.NewProvider(auth.NewIFlowAuthenticator(oauth))
+		if cfg.BaseURL != "" {
+			p.SetBaseURL(cfg.BaseURL)
+		}
+		return p
+	case provider.ProviderQwen:
+		// Qwen signs in once for the whole process, with the credentials in
+		// ~/.qwen/qwenproxy_creds.json, so the config file cannot give it settings or accounts
+		return qwen.NewProvider()
+	}
+	panic(fmt.Sprintf("unknown provider %s", providerType))
+}
+
+// applyOAuthSettings applies the OAuth client settings of a provider signing in with Google
+func applyOAuthSettings(oauth *auth.GeminiOAuthConfig, cfg config.ProviderConfig, credsDir string) {
+	oauth.ClientID = firstNonEmpty(cfg.ClientID, oauth.ClientID)
+	oauth.ClientSecret = firstNonEmpty(cfg.ClientSecret, oauth.ClientSecret)
+	oauth.CredsDir = firstNonEmpty(credsDir, oauth.CredsDir)
+	if cfg.RedirectPort != 0 {
+		oauth.RedirectPort = cfg.RedirectPort
+	}
+}
+
+// configuredRules converts the routing rules of the config file to the factory's
+func configuredRules(routing config.RoutingConfig) provider.RoutingRules {
+	rules := provider.RoutingRules{
+		Models:    make(map[string]provider.ModelRule, len(routing.Models)),
+		Providers: make(map[provider.ProviderType]provider.ProviderRule, len(routing.Providers)),
+	}
+	for model, route := range routing.Models {
+		rule := provider.ModelRule{Pin: provider.ProviderType(route.Pin)}
+		for _, name := range route.Order {
+			rule.Order = append(rule.Order, provider.ProviderType(name))
+		}
+		rules.Models[model] = rule
+	}
+	for name, route := range routing.Providers {
+		rules.Providers[provider.ProviderType(name)] = provider.ProviderRule{Disabled: route.Disabled, Draining: route.Draining}
+	}
+	return rules
+}
+
+// firstNonEmpty returns the first of values that is not empty
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sunbankio/qwencoder-proxy/audit"
+	"github.com/sunbankio/qwencoder-proxy/config"
+	"github.com/sunbankio/qwencoder-proxy/replay"
+)
+
+// errStopReplay ends the walk over the audit files once the limit is reached
+var errStopReplay = errors.New("replay limit reached")
+
+// runReplay implements the replay subcommand, which sends requests recorded in the
+// audit log to a running proxy and reports structural differences in the responses
+func runReplay(cfg *config.Config, args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("replay", flag.ContinueOnError)
+	fs.SetOutput(out)
+	target := fs.String("target", "http://localhost:"+cfg.Server.Port, "Base URL of the proxy to replay against")
+	key := fs.String("key", os.Getenv("REPLAY_API_KEY"), "Client API key for the target; defaults to $REPLAY_API_KEY")
+	providerName := fs.String("provider", "", "Send OpenAI requests to this provider's route, such as qwen or kiro")
+	model := fs.String("model", "", "Ask for this model instead of the recorded one")
+	requestID := fs.String("request-id", "", "Only replay the exchange with this request ID")
+	limit := fs.Int("limit", 0, "Replay at most this many exchanges; 0 replays all")
+	timeout := fs.Duration("timeout", 5*time.Minute, "Timeout for each replayed request")
+	format := fs.String("format", "text", "Output format: text or json")
+	fs.Usage = func() {
+		fmt.Fprintln(out, "Usage: qwencoder-proxy replay [flags] [audit files or directories]")
+		fmt.Fprintln(out, "Directories default to the audit directory.")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *format != "text" && *format != "json" {
+		return fmt.Errorf("unknown format %q: want text or json", *format)
+	}
+
+	paths := fs.Args()
+	if len(paths) == 0 {
+		if cfg.Audit.Dir == "" {
+			return fmt.Errorf("no audit files given and AUDIT_DIR is not set")
+		}
+		paths = []string{cfg.Audit.Dir}
+	}
+	files, err := auditFiles(paths)
+	if err != nil {
+		return err
+	}
+
+	opts := replay.Options{
+		Target:   *target,
+		APIKey:   *key,
+		Provider: *providerName,
+		Model:    *model,
+		Client:   &http.Client{Timeout: *timeout},
+	}
+	enc := json.NewEncoder(out)
+	counts := map[string]int{}
+	var replayed, differing, failed, skipped int
+
+	for _, path := range files {
+		err := audit.ReadFile(path, func(record *audit.Record) error {
+			if *requestID != "" && record.RequestID != *requestID {
+				return nil
+			}
+			if *limit > 0 && replayed >= *limit {
+				return errStopReplay
+			}
+			result := replay.Replay(context.Background(), opts, record)
+			switch {
+			case result.Skipped != "":
+				skipped++
+			case result.Error != "":
+				replayed++
+				failed++
+			default:
+				replayed++
+				if len(result.Differences) > 0 {
+					differing++
+				}
+				for _, diff := range result.Differences {
+					counts[diff.Kind]++
+				}
+			}
+			if *format == "json" {
+				return enc.Encode(result)
+			}
+			printResult(out, result)
+			return nil
+		})
+		if errors.Is(err, errStopReplay) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if *format == "text" {
+		fmt.Fprintf(out, "\n%d replayed, %d differ, %d failed, %d skipped\n", replayed, differing, failed, skipped)
+		kinds := make([]string, 0, len(counts))
+		for kind := range counts {
+			kinds = append(kinds, kind)
+		}
+		sort.Strings(kinds)
+		for _, kind := range kinds {
+			fmt.Fprintf(out, "  %-18s %d\n", kind, counts[kind])
+		}
+	}
+	if differing > 0 || failed > 0 {
+		return fmt.Errorf("%d of %d replayed exchanges differ or failed", differing+failed, replayed)
+	}
+	return nil
+}
+
+// auditFiles expands directories to the audit files they hold
+func auditFiles(paths []string) ([]string, error) {
+	var files []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, fmt.Errorf("no audit records in %s: %w", path, err)
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+		found, err := audit.Files(path)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, found...)
+	}
+	return files, nil
+}
+
+// printResult writes one line per exchange, followed by its differences
+func printResult(out io.Writer, result replay.Result) {
+	id := result.RequestID
+	if id == "" {
+		id = "-"
+	}
+	switch {
+	case result.Skipped != "":
+		fmt.Fprintf(out, "SKIP  %s %s: %s\n", id, result.Path, result.Skipped)
+	case result.Error != "":
+		fmt.Fprintf(out, "FAIL  %s %s: %s\n", id, result.Path, result.Error)
+	case len(result.Differences) == 0:
+		fmt.Fprintf(out, "OK    %s %s\n", id, result.Path)
+	default:
+		kinds := make([]string, len(result.Differences))
+		for i, diff := range result.Differences {
+			kinds[i] = diff.Kind
+		}
+		fmt.Fprintf(out, "DIFF  %s %s: %s\n", id, result.Path, strings.Join(kinds, ", "))
+		for _, diff := range result.Differences {
+			fmt.Fprintf(out, "      %s\n", diff)
+		}
+	}
+}
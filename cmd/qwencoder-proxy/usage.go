@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/sunbankio/qwencoder-proxy/config"
+	"github.com/sunbankio/qwencoder-proxy/usage"
+)
+
+// runUsage implements the usage subcommand, which reports on the recorded usage
+// directly from the usage directory, so it works while the proxy is stopped
+func runUsage(cfg *config.Config, args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("usage", flag.ContinueOnError)
+	fs.SetOutput(out)
+	dir := fs.String("dir", cfg.Usage.Dir, "Directory holding the usage records")
+	from := fs.String("from", "24h", "Start of the report: RFC 3339 time, date, or duration before now")
+	to := fs.String("to", "", "End of the report, in the same formats; defaults to now")
+	groupBy := fs.String("group-by", "", "Comma-separated dimensions: key, provider, model, account, status, day, hour")
+	key := fs.String("key", "", "Only include requests made with this key label")
+	providerName := fs.String("provider", "", "Only include requests served by this provider")
+	model := fs.String("model", "", "Only include requests for this model")
+	format := fs.String("format", "table", "Output format: table, csv or json")
+	fs.Usage = func() {
+		fmt.Fprintln(out, "Usage: qwencoder-proxy usage [flags]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	values := url.Values{}
+	for name, value := range map[string]string{"from": *from, "to": *to, "group_by": *groupBy, "key": *key, "provider": *providerName, "model": *model} {
+		if value != "" {
+			values.Set(name, value)
+		}
+	}
+	query, err := usage.ParseQuery(values, time.Now())
+	if err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(*dir); err != nil {
+		return fmt.Errorf("no usage records in %s: %w", *dir, err)
+	}
+	store, err := usage.Open(*dir, 0)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	rows, err := store.Report(query)
+	if err != nil {
+		return err
+	}
+
+	switch *format {
+	case "csv":
+		return usage.WriteCSV(out, query.GroupBy, rows)
+	case "json":
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(rows)
+	case "table":
+		tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', tabwriter.AlignRight)
+		fmt.Fprintln(tw, strings.Join(usage.Columns(query.GroupBy), "\t")+"\t")
+		for _, row := range rows {
+			fmt.Fprintln(tw, strings.Join(row.Values(query.GroupBy), "\t")+"\t")
+		}
+		return tw.Flush()
+	default:
+		return fmt.Errorf("unknown format %q: want table, csv or json", *format)
+	}
+}
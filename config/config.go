@@ -1,7 +1,10 @@
 package config
 
 import (
+	"net"
 	"net/http"
+	"os"
+	"path/filepath"
 	"time"
 )
 
@@ -10,26 +13,248 @@ type ServerConfig struct {
 	Port string
 }
 
-// HTTPClientConfig holds HTTP client configuration
+// HTTPClientConfig holds HTTP client configuration.
+// Timeouts are in seconds; 0 disables a timeout.
 type HTTPClientConfig struct {
 	MaxIdleConns            int
 	MaxIdleConnsPerHost     int
 	IdleConnTimeoutSeconds  int
-	RequestTimeoutSeconds   int
-	StreamingTimeoutSeconds int
-	ReadTimeoutSeconds      int
+	RequestTimeoutSeconds   int // Total time for a non-streaming upstream request
+	StreamingTimeoutSeconds int // Total time for a streaming upstream request
+	ReadTimeoutSeconds      int // Longest silence between response body reads
+	// ConnectTimeoutSeconds bounds establishing a TCP connection to an upstream
+	ConnectTimeoutSeconds int
+	// TLSHandshakeTimeoutSeconds bounds the TLS handshake with an upstream
+	TLSHandshakeTimeoutSeconds int
+	// FirstByteTimeoutSeconds bounds the wait for the first byte of a streaming response
+	FirstByteTimeoutSeconds int
+	// ProviderTimeouts and ModelTimeouts override the timeouts above by provider and model name
+	ProviderTimeouts map[string]TimeoutOverrides
+	ModelTimeouts    map[string]TimeoutOverrides
+	// Egress controls how connections reach upstreams; ProviderEgress overrides it by provider name
+	Egress         EgressConfig
+	ProviderEgress map[string]EgressConfig
+}
+
+// DirectProxy is the ProxyURL value that connects without a proxy
+const DirectProxy = "direct"
+
+// EgressConfig describes the proxy and trusted certificates used to reach upstreams
+type EgressConfig struct {
+	// ProxyURL is an http://, https:// or socks5:// proxy. Empty falls back to the
+	// HTTPS_PROXY, HTTP_PROXY and NO_PROXY environment variables, and DirectProxy disables proxying.
+	ProxyURL string
+	// NoProxy lists hosts reached without the proxy, in NO_PROXY syntax
+	NoProxy string
+	// CABundles are PEM files trusted in addition to the system roots
+	CABundles []string
+}
+
+// EgressFor resolves the egress settings for a provider. Provider settings replace the
+// proxy and no-proxy list when set, and their CA bundles are trusted alongside the global ones.
+func (c HTTPClientConfig) EgressFor(providerName string) EgressConfig {
+	egress := c.Egress
+	override, ok := c.ProviderEgress[providerName]
+	if !ok {
+		return egress
+	}
+	if override.ProxyURL != "" {
+		egress.ProxyURL = override.ProxyURL
+		egress.NoProxy = ""
+	}
+	if override.NoProxy != "" {
+		egress.NoProxy = override.NoProxy
+	}
+	egress.CABundles = append(append([]string(nil), egress.CABundles...), override.CABundles...)
+	return egress
+}
+
+// Timeout names used as keys in TimeoutOverrides
+const (
+	TimeoutConnect     = "connect"
+	TimeoutTLS         = "tls"
+	TimeoutFirstByte   = "first_byte"
+	TimeoutIdle        = "idle"
+	TimeoutTotal       = "total"
+	TimeoutStreamTotal = "stream_total"
+)
+
+// TimeoutOverrides maps timeout names to replacement values in seconds
+type TimeoutOverrides map[string]int
+
+// UpstreamTimeouts holds the timeouts that apply to one upstream request; zero disables a timeout
+type UpstreamTimeouts struct {
+	Connect      time.Duration
+	TLSHandshake time.Duration
+	FirstByte    time.Duration
+	Idle         time.Duration
+	Total        time.Duration
+}
+
+// Timeouts resolves the timeouts for a request to a provider, applying provider overrides and
+// then model overrides. Connections are pooled per provider, so only provider overrides affect
+// the connect and TLS timeouts. Non-streaming responses arrive in one piece, so they are bounded
+// by the total timeout rather than the first byte timeout.
+func (c HTTPClientConfig) Timeouts(providerName, model string, streaming bool) UpstreamTimeouts {
+	seconds := map[string]int{
+		TimeoutConnect:     c.ConnectTimeoutSeconds,
+		TimeoutTLS:         c.TLSHandshakeTimeoutSeconds,
+		TimeoutFirstByte:   c.FirstByteTimeoutSeconds,
+		TimeoutIdle:        c.ReadTimeoutSeconds,
+		TimeoutTotal:       c.RequestTimeoutSeconds,
+		TimeoutStreamTotal: c.StreamingTimeoutSeconds,
+	}
+	for name, value := range c.ProviderTimeouts[providerName] {
+		seconds[name] = value
+	}
+	for name, value := range c.ModelTimeouts[model] {
+		if name != TimeoutConnect && name != TimeoutTLS {
+			seconds[name] = value
+		}
+	}
+
+	inSeconds := func(name string) time.Duration {
+		return time.Duration(seconds[name]) * time.Second
+	}
+	timeouts := UpstreamTimeouts{
+		Connect:      inSeconds(TimeoutConnect),
+		TLSHandshake: inSeconds(TimeoutTLS),
+		Idle:         inSeconds(TimeoutIdle),
+		Total:        inSeconds(TimeoutTotal),
+	}
+	if streaming {
+		timeouts.FirstByte = inSeconds(TimeoutFirstByte)
+		timeouts.Total = inSeconds(TimeoutStreamTotal)
+	}
+	return timeouts
+}
+
+// StreamingConfig holds streaming relay and error handling configuration
+type StreamingConfig struct {
+	MaxErrors    int // Malformed upstream chunks tolerated before a stream is aborted; 0 disables the limit
+	BufferSize   int // Read buffer size in bytes for relaying streams to clients
+	MaxEventSize int // Largest upstream SSE event accepted, in bytes
+	// KeepaliveSeconds is how long a stream may be silent before a keepalive comment is sent; 0 disables keepalives
+	KeepaliveSeconds int
+	// IdleTimeoutSeconds is how long a stream may be silent before it is aborted; 0 disables the timeout
+	IdleTimeoutSeconds int
+	// AggregateProviders serve non-streaming requests by streaming from upstream and assembling the response; "*" matches all
+	AggregateProviders []string
+	// SimulateProviders serve streaming requests with a unary upstream call replayed as a stream; "*" matches all
+	SimulateProviders []string
+}
+
+// ContextConfig holds the settings of the context window check done before dispatch
+type ContextConfig struct {
+	// RejectMarginPercent is how far, in percent of the window, the estimated prompt plus
+	// max_tokens may exceed a model's context window before the request is rejected.
+	// The estimate approximates the upstream tokenizer, so upstreams decide the close calls.
+	RejectMarginPercent int
+}
+
+// AuthConfig holds the API keys clients must present to use the proxy.
+// Authentication is disabled when no keys are configured.
+type AuthConfig struct {
+	// APIKeys are client keys, either in plain text or as sha256:<hex> digests
+	APIKeys []string
+	// KeysFile is a JSON file of keys with optional labels, expiry times and policies
+	KeysFile string
+	// BudgetFile persists the token budget usage of keys across restarts
+	BudgetFile string
+}
+
+// UsageConfig holds usage accounting configuration
+type UsageConfig struct {
+	// Dir holds the usage records, one file per UTC day
+	Dir string
+	// RetentionDays is how long records are kept; 0 keeps them forever
+	RetentionDays int
+}
+
+// AuditConfig holds the request/response audit log configuration
+type AuditConfig struct {
+	// Dir holds the audit files; empty disables the audit log
+	Dir string
+	// SampleRate is the share of requests recorded, from 0 to 1
+	SampleRate float64
+	// RedactPrompts replaces message text in recorded bodies, keeping their structure
+	RedactPrompts bool
+	// MaxBodyBytes caps each recorded body; longer bodies are truncated
+	MaxBodyBytes int
+	// MaxFileBytes starts a new file once the current one reaches this size
+	MaxFileBytes int64
+	// MaxFileAge starts a new file once the current one is this old
+	MaxFileAge time.Duration
+	// MaxFiles is how many files are kept; 0 keeps them all
+	MaxFiles int
+}
+
+// RoutingConfig holds the settings of operator routing overrides
+type RoutingConfig struct {
+	// RulesFile keeps the pins, provider orders and disabled providers set through the admin API
+	RulesFile string
+	// StateFile keeps the last successful providers, circuit breakers and model catalog across restarts
+	StateFile string
+	// CircuitFailureThreshold is the number of consecutive upstream failures that opens a provider's circuit
+	CircuitFailureThreshold int
+	// CircuitCooldownSeconds is how long an open circuit skips its provider before trying it again
+	CircuitCooldownSeconds int
+	// Models and Providers are routing rules from the config file; rules set through the
+	// admin API replace them
+	Models    map[string]ModelRoute
+	Providers map[string]ProviderRoute
+}
+
+// HealthConfig holds the background provider health check settings
+type HealthConfig struct {
+	// IntervalSeconds is the time between health checks of every provider
+	IntervalSeconds int
+	// TimeoutSeconds bounds one provider's health check
+	TimeoutSeconds int
+}
+
+// TracingConfig holds distributed tracing configuration, named after the OpenTelemetry variables
+type TracingConfig struct {
+	// Exporter is where spans are sent: "none", "otlp" or "console" (stdout)
+	Exporter string
+	// Endpoint is the OTLP/HTTP traces URL of the collector
+	Endpoint string
+	// Headers are sent with every export request, for collector authentication
+	Headers map[string]string
+	// ServiceName identifies the proxy in the collector
+	ServiceName string
+	// SampleRatio is the share of new traces recorded; traces started by clients follow their sampled flag
+	SampleRatio float64
 }
 
 // LoggingConfig holds logging-related configuration
 type LoggingConfig struct {
-	IsDebugMode bool
+	// Level is the default level: debug, info, warn or error
+	Level string
+	// Format is text or json, both written to stdout without colors
+	Format string
+	// Levels overrides Level for subsystems such as proxy, kiro or auth
+	Levels map[string]string
 }
 
 // Config holds all configuration for the application
 type Config struct {
 	Server     ServerConfig
 	HTTPClient HTTPClientConfig
+	Streaming  StreamingConfig
+	Context    ContextConfig
+	Auth       AuthConfig
+	Usage      UsageConfig
+	Audit      AuditConfig
+	Health     HealthConfig
+	Routing    RoutingConfig
+	Tracing    TracingConfig
 	Logging    LoggingConfig
+	// Providers configures providers by type, from the config file; providers not listed
+	// are registered with their defaults
+	Providers map[string]ProviderConfig
+	// Aliases are model names clients may send in place of others
+	Aliases map[string]string
 }
 
 // DefaultConfig returns the default configuration
@@ -44,17 +269,80 @@ func DefaultConfig() *Config {
 			IdleConnTimeoutSeconds:  180,
 			RequestTimeoutSeconds:   300,
 			StreamingTimeoutSeconds: 900, // Using the value from proxy/client.go as it's longer
-			ReadTimeoutSeconds:      45,
+			// Thinking models can stay silent for minutes before and between chunks
+			ReadTimeoutSeconds:         120,
+			ConnectTimeoutSeconds:      10,
+			TLSHandshakeTimeoutSeconds: 10,
+			FirstByteTimeoutSeconds:    180,
+		},
+		Streaming: StreamingConfig{
+			MaxErrors:    10,
+			BufferSize:   4096,
+			MaxEventSize: 16 << 20,
+			// Under the 30-60s idle limits common in load balancers and IDE clients
+			KeepaliveSeconds:   15,
+			IdleTimeoutSeconds: 300,
+			// Long unary generations on these providers outlive the request timeout
+			AggregateProviders: []string{"kiro", "antigravity"},
+		},
+		Context: ContextConfig{
+			RejectMarginPercent: 10,
+		},
+		Auth: AuthConfig{
+			BudgetFile: stateFile("key_budgets.json"),
+		},
+		Usage: UsageConfig{
+			Dir:           stateFile("usage"),
+			RetentionDays: 90,
+		},
+		Audit: AuditConfig{
+			SampleRate:   1,
+			MaxBodyBytes: 1 << 20,
+			MaxFileBytes: 100 << 20,
+			MaxFileAge:   24 * time.Hour,
+			MaxFiles:     10,
+		},
+		Health: HealthConfig{
+			IntervalSeconds: 60,
+			TimeoutSeconds:  15,
+		},
+		Routing: RoutingConfig{
+			RulesFile: stateFile("routing_rules.json"),
+			StateFile: stateFile("routing_state.json"),
+			// Rides out a burst of errors without skipping a provider that failed once
+			CircuitFailureThreshold: 5,
+			CircuitCooldownSeconds:  30,
+		},
+		Tracing: TracingConfig{
+			Exporter:    "none",
+			Endpoint:    "http://localhost:4318/v1/traces",
+			ServiceName: "qwencoder-proxy",
+			SampleRatio: 1,
 		},
 		Logging: LoggingConfig{
-			IsDebugMode: false,
+			Level:  "info",
+			Format: "text",
 		},
 	}
 }
 
+// stateFile returns the path of a file the proxy keeps state in, under ~/.qwencoder-proxy
+func stateFile(name string) string {
+	homeDir, _ := os.UserHomeDir()
+	return filepath.Join(homeDir, ".qwencoder-proxy", name)
+}
+
 // SharedHTTPClient creates and returns a shared HTTP client with the configured settings
 func (c *Config) SharedHTTPClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   time.Duration(c.HTTPClient.ConnectTimeoutSeconds) * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
 	transport := &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		DialContext:         dialer.DialContext,
+		ForceAttemptHTTP2:   true,
+		TLSHandshakeTimeout: time.Duration(c.HTTPClient.TLSHandshakeTimeoutSeconds) * time.Second,
 		MaxIdleConns:        c.HTTPClient.MaxIdleConns,
 		MaxIdleConnsPerHost: c.HTTPClient.MaxIdleConnsPerHost,
 		IdleConnTimeout:     time.Duration(c.HTTPClient.IdleConnTimeoutSeconds) * time.Second,
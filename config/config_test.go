@@ -2,7 +2,9 @@ package config
 
 import (
 	"os"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestDefaultConfig(t *testing.T) {
@@ -52,8 +54,8 @@ func TestLoadConfigFromEnv(t *testing.T) {
 		t.Errorf("Expected max idle connections from environment to be 100, got %d", cfg.HTTPClient.MaxIdleConns)
 	}
 
-	if cfg.Logging.IsDebugMode != true {
-		t.Errorf("Expected debug mode from environment to be true, got %t", cfg.Logging.IsDebugMode)
+	if cfg.Logging.Level != "debug" {
+		t.Errorf("Expected log level from DEBUG=true to be debug, got %s", cfg.Logging.Level)
 	}
 }
 
@@ -82,3 +84,194 @@ func TestHTTPClients(t *testing.T) {
 		t.Errorf("Expected streaming HTTP client timeout to be 900 seconds, got %f", streamingClient.Timeout.Seconds())
 	}
 }
+
+func TestLoadStreamingConfig(t *testing.T) {
+	t.Setenv("STREAMING_MAX_ERRORS", "3")
+	t.Setenv("STREAMING_BUFFER_SIZE", "8192")
+	t.Setenv("STREAMING_KEEPALIVE_SECONDS", "0")
+	t.Setenv("STREAMING_IDLE_TIMEOUT_SECONDS", "120")
+	t.Setenv("STREAMING_AGGREGATE_PROVIDERS", "")
+	t.Setenv("STREAMING_SIMULATE_PROVIDERS", " iflow, qwen ,")
+
+	cfg := LoadConfig()
+	if cfg.Streaming.MaxErrors != 3 {
+		t.Errorf("Expected streaming max errors to be 3, got %d", cfg.Streaming.MaxErrors)
+	}
+	if cfg.Streaming.BufferSize != 8192 {
+		t.Errorf("Expected streaming buffer size to be 8192, got %d", cfg.Streaming.BufferSize)
+	}
+	if cfg.Streaming.KeepaliveSeconds != 0 {
+		t.Errorf("Expected keepalives to be disabled, got %d", cfg.Streaming.KeepaliveSeconds)
+	}
+	if cfg.Streaming.IdleTimeoutSeconds != 120 {
+		t.Errorf("Expected streaming idle timeout to be 120, got %d", cfg.Streaming.IdleTimeoutSeconds)
+	}
+	if len(cfg.Streaming.AggregateProviders) != 0 {
+		t.Errorf("Expected aggregation to be disabled, got %v", cfg.Streaming.AggregateProviders)
+	}
+	if got := cfg.Streaming.SimulateProviders; len(got) != 2 || got[0] != "iflow" || got[1] != "qwen" {
+		t.Errorf("Expected simulated streaming for [iflow qwen], got %v", got)
+	}
+
+	// Invalid values keep the defaults
+	t.Setenv("STREAMING_MAX_ERRORS", "-1")
+	t.Setenv("STREAMING_BUFFER_SIZE", "0")
+
+	cfg = LoadConfig()
+	if cfg.Streaming.MaxErrors != 10 {
+		t.Errorf("Expected default streaming max errors 10, got %d", cfg.Streaming.MaxErrors)
+	}
+	if cfg.Streaming.BufferSize != 4096 {
+		t.Errorf("Expected default streaming buffer size 4096, got %d", cfg.Streaming.BufferSize)
+	}
+}
+
+func TestLoadContextConfig(t *testing.T) {
+	if cfg := LoadConfig(); cfg.Context.RejectMarginPercent != 10 {
+		t.Errorf("Expected default context reject margin 10, got %d", cfg.Context.RejectMarginPercent)
+	}
+	t.Setenv("CONTEXT_REJECT_MARGIN_PERCENT", "0")
+	if cfg := LoadConfig(); cfg.Context.RejectMarginPercent != 0 {
+		t.Errorf("Expected context reject margin 0, got %d", cfg.Context.RejectMarginPercent)
+	}
+	t.Setenv("CONTEXT_REJECT_MARGIN_PERCENT", "-5")
+	if cfg := LoadConfig(); cfg.Context.RejectMarginPercent != 10 {
+		t.Errorf("Expected default context reject margin for an invalid value, got %d", cfg.Context.RejectMarginPercent)
+	}
+}
+
+func TestUpstreamTimeouts(t *testing.T) {
+	t.Setenv("FIRST_BYTE_TIMEOUT_SECONDS", "60")
+	t.Setenv("PROVIDER_TIMEOUTS", "kiro.first_byte=300, kiro.connect=5, antigravity.stream_total=0, kiro.bogus=1, kiro.idle=x")
+	t.Setenv("MODEL_TIMEOUTS", "gemini-2.5-pro.idle=240,gemini-2.5-pro.connect=1")
+
+	cfg := LoadConfig()
+	tests := []struct {
+		name      string
+		provider  string
+		model     string
+		streaming bool
+		want      UpstreamTimeouts
+	}{
+		{
+			name:     "defaults for a unary request",
+			provider: "qwen", model: "qwen3-coder-plus",
+			want: UpstreamTimeouts{Connect: 10 * time.Second, TLSHandshake: 10 * time.Second, Idle: 120 * time.Second, Total: 300 * time.Second},
+		},
+		{
+			name:     "streaming uses the first byte and streaming totals",
+			provider: "qwen", model: "qwen3-coder-plus", streaming: true,
+			want: UpstreamTimeouts{Connect: 10 * time.Second, TLSHandshake: 10 * time.Second, FirstByte: 60 * time.Second, Idle: 120 * time.Second, Total: 900 * time.Second},
+		},
+		{
+			name:     "provider overrides",
+			provider: "kiro", model: "claude-sonnet-4-5", streaming: true,
+			want: UpstreamTimeouts{Connect: 5 * time.Second, TLSHandshake: 10 * time.Second, FirstByte: 300 * time.Second, Idle: 120 * time.Second, Total: 900 * time.Second},
+		},
+		{
+			name:     "zero disables a timeout",
+			provider: "antigravity", model: "gemini-3-pro", streaming: true,
+			want: UpstreamTimeouts{Connect: 10 * time.Second, TLSHandshake: 10 * time.Second, FirstByte: 60 * time.Second, Idle: 120 * time.Second},
+		},
+		{
+			name:     "model overrides skip connection timeouts",
+			provider: "gemini-cli", model: "gemini-2.5-pro",
+			want: UpstreamTimeouts{Connect: 10 * time.Second, TLSHandshake: 10 * time.Second, Idle: 240 * time.Second, Total: 300 * time.Second},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := cfg.HTTPClient.Timeouts(tt.provider, tt.model, tt.streaming); got != tt.want {
+				t.Errorf("Timeouts() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	if _, ok := cfg.HTTPClient.ProviderTimeouts["kiro"]["bogus"]; ok {
+		t.Errorf("Expected unknown timeout names to be ignored, got %v", cfg.HTTPClient.ProviderTimeouts["kiro"])
+	}
+}
+
+func TestProviderEgress(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.HTTPClient.Egress = EgressConfig{ProxyURL: "http://proxy:3128", NoProxy: "localhost", CABundles: []string{"/etc/corp.pem"}}
+	cfg.HTTPClient.ProviderEgress = loadProviderEgress([]string{
+		"KIRO_UPSTREAM_PROXY=socks5://127.0.0.1:1080",
+		"GEMINI_CLI_UPSTREAM_NO_PROXY=oauth2.googleapis.com",
+		"GEMINI_CLI_UPSTREAM_CA_BUNDLES=/etc/google.pem, /etc/extra.pem",
+		"QWEN_UPSTREAM_PROXY=",
+		"UPSTREAM_PROXY=http://ignored:1",
+	})
+
+	tests := []struct {
+		provider string
+		want     EgressConfig
+	}{
+		{"qwen", EgressConfig{ProxyURL: "http://proxy:3128", NoProxy: "localhost", CABundles: []string{"/etc/corp.pem"}}},
+		{"kiro", EgressConfig{ProxyURL: "socks5://127.0.0.1:1080", CABundles: []string{"/etc/corp.pem"}}},
+		{"gemini-cli", EgressConfig{ProxyURL: "http://proxy:3128", NoProxy: "oauth2.googleapis.com", CABundles: []string{"/etc/corp.pem", "/etc/google.pem", "/etc/extra.pem"}}},
+	}
+
+	for _, tt := range tests {
+		got := cfg.HTTPClient.EgressFor(tt.provider)
+		if got.ProxyURL != tt.want.ProxyURL || got.NoProxy != tt.want.NoProxy || strings.Join(got.CABundles, ",") != strings.Join(tt.want.CABundles, ",") {
+			t.Errorf("EgressFor(%s) = %+v, want %+v", tt.provider, got, tt.want)
+		}
+	}
+	if len(cfg.HTTPClient.ProviderEgress) != 2 {
+		t.Errorf("Expected settings for kiro and gemini-cli only, got %v", cfg.HTTPClient.ProviderEgress)
+	}
+}
+
+func TestLoadTracingConfig(t *testing.T) {
+	t.Setenv("OTEL_TRACES_EXPORTER", "OTLP")
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "http://collector:4318/")
+	t.Setenv("OTEL_EXPORTER_OTLP_HEADERS", "Authorization=Bearer%20abc, x-tenant = team-a,invalid")
+	t.Setenv("OTEL_TRACES_SAMPLER_ARG", "0.25")
+
+	cfg := LoadConfig()
+	if cfg.Tracing.Exporter != "otlp" {
+		t.Errorf("Expected otlp exporter, got %q", cfg.Tracing.Exporter)
+	}
+	if cfg.Tracing.Endpoint != "http://collector:4318/v1/traces" {
+		t.Errorf("Expected the traces path appended to the base endpoint, got %q", cfg.Tracing.Endpoint)
+	}
+	if got := cfg.Tracing.Headers; len(got) != 2 || got["Authorization"] != "Bearer abc" || got["x-tenant"] != "team-a" {
+		t.Errorf("Unexpected headers %v", got)
+	}
+	if cfg.Tracing.SampleRatio != 0.25 {
+		t.Errorf("Expected sample ratio 0.25, got %v", cfg.Tracing.SampleRatio)
+	}
+
+	// The traces endpoint is used as is, and out of range ratios keep the default
+	t.Setenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "https://collector/custom")
+	t.Setenv("OTEL_TRACES_SAMPLER_ARG", "2")
+	cfg = LoadConfig()
+	if cfg.Tracing.Endpoint != "https://collector/custom" {
+		t.Errorf("Expected the traces endpoint, got %q", cfg.Tracing.Endpoint)
+	}
+	if cfg.Tracing.SampleRatio != 1 {
+		t.Errorf("Expected default sample ratio 1, got %v", cfg.Tracing.SampleRatio)
+	}
+}
+
+func TestLoadLoggingConfig(t *testing.T) {
+	t.Setenv("DEBUG", "true")
+	t.Setenv("LOG_FORMAT", "JSON")
+	t.Setenv("LOG_LEVELS", "Kiro=DEBUG, auth = warn,invalid")
+
+	cfg := LoadConfig()
+	if cfg.Logging.Level != "debug" || cfg.Logging.Format != "json" {
+		t.Errorf("Expected debug level and json format, got %q and %q", cfg.Logging.Level, cfg.Logging.Format)
+	}
+	if got := cfg.Logging.Levels; len(got) != 2 || got["kiro"] != "debug" || got["auth"] != "warn" {
+		t.Errorf("Unexpected subsystem levels %v", got)
+	}
+
+	// LOG_LEVEL wins over DEBUG
+	t.Setenv("LOG_LEVEL", "Error")
+	if cfg = LoadConfig(); cfg.Logging.Level != "error" {
+		t.Errorf("Expected error level, got %q", cfg.Logging.Level)
+	}
+}
@@ -0,0 +1,573 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ProviderTypes are the names of the built-in providers, as provider.ProviderType values
+var ProviderTypes = []string{"qwen", "gemini-cli", "kiro", "antigravity", "iflow"}
+
+// ProviderConfig configures a provider from the config file. Settings left empty keep the
+// provider's built-in defaults, and each provider takes only the settings it has a use for.
+type ProviderConfig struct {
+	// Enabled set to false leaves the provider and its accounts unregistered
+	Enabled *bool `json:"enabled,omitempty"`
+	// ClientID, ClientSecret and RedirectPort replace the OAuth client of gemini-cli,
+	// antigravity and iflow
+	ClientID     string `json:"client_id,omitempty"`
+	ClientSecret string `json:"client_secret,omitempty"`
+	RedirectPort int    `json:"redirect_port,omitempty"`
+	// CredsDir holds the credentials of gemini-cli, antigravity and iflow, relative to the
+	// home directory unless absolute
+	CredsDir string `json:"creds_dir,omitempty"`
+	// CredsPath is the credentials file of kiro
+	CredsPath string `json:"creds_path,omitempty"`
+	// Region is the AWS region kiro uses when its credentials name none
+	Region string `json:"region,omitempty"`
+	// BaseURL is the API base URL of gemini-cli, kiro or iflow; "{{region}}" in the kiro
+	// URL is replaced by the region
+	BaseURL string `json:"base_url,omitempty"`
+	// DailyBaseURL and AutopushBaseURL are the antigravity environments, tried in that order
+	DailyBaseURL    string `json:"daily_base_url,omitempty"`
+	AutopushBaseURL string `json:"autopush_base_url,omitempty"`
+	// ModelMappings map model names to the names the kiro or antigravity API expects
+	ModelMappings map[string]string `json:"model_mappings,omitempty"`
+	// Accounts are further accounts of the provider, each registered and routed under its
+	// own name with its own credentials and otherwise the provider's settings
+	Accounts map[string]AccountConfig `json:"accounts,omitempty"`
+}
+
+// AccountConfig configures an additional account of a provider
+type AccountConfig struct {
+	Enabled   *bool  `json:"enabled,omitempty"`
+	CredsDir  string `json:"creds_dir,omitempty"`
+	CredsPath string `json:"creds_path,omitempty"`
+	Region    string `json:"region,omitempty"`
+}
+
+// IsEnabled reports whether a provider or account is enabled; it is unless disabled explicitly
+func IsEnabled(enabled *bool) bool {
+	return enabled == nil || *enabled
+}
+
+// providerSettings lists the settings each provider takes, by their names in the file
+var providerSettings = map[string][]string{
+	"qwen":        {},
+	"gemini-cli":  {"client_id", "client_secret", "redirect_port", "creds_dir", "base_url", "accounts"},
+	"kiro":        {"creds_path", "region", "base_url", "model_mappings", "accounts"},
+	"antigravity": {"client_id", "client_secret", "redirect_port", "creds_dir", "daily_base_url", "autopush_base_url", "model_mappings", "accounts"},
+	"iflow":       {"client_id", "client_secret", "redirect_port", "creds_dir", "base_url", "accounts"},
+}
+
+// reservedNames cannot name accounts, as their routes would clash with the proxy's own
+var reservedNames = map[string]bool{"v1": true, "v1beta": true, "admin": true, "anthropic": true, "gemini": true, "metrics": true, "healthz": true, "readyz": true}
+
+// accountNamePattern matches account names, which appear in URL paths and metric labels
+var accountNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+// ModelRoute is a routing rule for a model from the config file
+type ModelRoute struct {
+	Pin   string   `json:"pin,omitempty"`
+	Order []string `json:"order,omitempty"`
+}
+
+// ProviderRoute is a routing rule for a provider or account from the config file
+type ProviderRoute struct {
+	Disabled bool `json:"disabled,omitempty"`
+	Draining bool `json:"draining,omitempty"`
+}
+
+// File is the layout of the config file. Sections left out keep their defaults, and
+// environment variables take precedence over the file.
+type File struct {
+	Server    *fileServer               `json:"server,omitempty"`
+	Timeouts  *fileTimeouts             `json:"timeouts,omitempty"`
+	Providers map[string]ProviderConfig `json:"providers,omitempty"`
+	Aliases   map[string]string         `json:"aliases,omitempty"`
+	Routing   *fileRouting              `json:"routing,omitempty"`
+}
+
+type fileServer struct {
+	Port *int `json:"port,omitempty"`
+}
+
+// fileTimeouts holds upstream timeouts in seconds; 0 disables a timeout
+type fileTimeouts struct {
+	Connect     *int                        `json:"connect,omitempty"`
+	TLS         *int                        `json:"tls,omitempty"`
+	FirstByte   *int                        `json:"first_byte,omitempty"`
+	Idle        *int                        `json:"idle,omitempty"`
+	Total       *int                        `json:"total,omitempty"`
+	StreamTotal *int                        `json:"stream_total,omitempty"`
+	Providers   map[string]TimeoutOverrides `json:"providers,omitempty"`
+	Models      map[string]TimeoutOverrides `json:"models,omitempty"`
+}
+
+// fileTimeout is one of the timeouts in fileTimeouts, with its Timeout* name
+type fileTimeout struct {
+	name    string
+	seconds *int
+}
+
+// global returns the timeouts that apply to every provider
+func (t *fileTimeouts) global() []fileTimeout {
+	return []fileTimeout{
+		{TimeoutConnect, t.Connect}, {TimeoutTLS, t.TLS}, {TimeoutFirstByte, t.FirstByte},
+		{TimeoutIdle, t.Idle}, {TimeoutTotal, t.Total}, {TimeoutStreamTotal, t.StreamTotal},
+	}
+}
+
+type fileRouting struct {
+	RulesFile               string                   `json:"rules_file,omitempty"`
+	StateFile               string                   `json:"state_file,omitempty"`
+	CircuitFailureThreshold int                      `json:"circuit_failure_threshold,omitempty"`
+	CircuitCooldownSeconds  int                      `json:"circuit_cooldown_seconds,omitempty"`
+	Models                  map[string]ModelRoute    `json:"models,omitempty"`
+	Providers               map[string]ProviderRoute `json:"providers,omitempty"`
+}
+
+// FileError lists the problems found in a config file
+type FileError struct {
+	Path     string
+	Problems []string
+}
+
+func (e *FileError) Error() string {
+	return fmt.Sprintf("invalid config file %s: %s", e.Path, strings.Join(e.Problems, "; "))
+}
+
+// LoadFile reads the config file at path, replacing ${VAR} with the value of environment
+// variable VAR and ${VAR:-default} with default when VAR is unset or empty; $${ is a
+// literal ${. Every problem found is reported in a *FileError.
+func LoadFile(path string) (*File, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+	fileErr := &FileError{Path: path}
+	data, fileErr.Problems = interpolate(data)
+	if len(fileErr.Problems) > 0 {
+		return nil, fileErr
+	}
+
+	var raw interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		fileErr.Problems = append(fileErr.Problems, decodeProblem(data, err))
+		return nil, fileErr
+	}
+	fileErr.Problems = unknownFields("", raw, reflect.TypeOf(File{}))
+	var file File
+	if err := json.Unmarshal(data, &file); err != nil {
+		fileErr.Problems = append(fileErr.Problems, decodeProblem(data, err))
+	} else {
+		fileErr.Problems = append(fileErr.Problems, file.validate()...)
+	}
+	if len(fileErr.Problems) > 0 {
+		return nil, fileErr
+	}
+	return &file, nil
+}
+
+// envReference matches ${VAR} and ${VAR:-default}, with an optional leading $ escaping it
+var envReference = regexp.MustCompile(`\$?\$\{([A-Za-z_][A-Za-z0-9_]*)(:-[^}]*)?\}`)
+
+// interpolate replaces environment variable references in data. Values and defaults are
+// escaped for a JSON string, so they may hold quotes and backslashes, and an unquoted
+// reference can supply a number.
+func interpolate(data []byte) ([]byte, []string) {
+	var problems []string
+	var out bytes.Buffer
+	last := 0
+	for _, match := range envReference.FindAllSubmatchIndex(data, -1) {
+		out.Write(data[last:match[0]])
+		last = match[1]
+		reference := data[match[0]:match[1]]
+		if bytes.HasPrefix(reference, []byte("$$")) {
+			out.Write(reference[1:])
+			continue
+		}
+		name := string(data[match[2]:match[3]])
+		value, ok := os.LookupEnv(name)
+		if match[4] >= 0 && value == "" {
+			value, ok = string(data[match[4]+2:match[5]]), true
+		}
+		if !ok {
+			line, _ := position(data, match[0])
+			problems = append(problems, fmt.Sprintf("line %d: environment variable %s is not set", line, name))
+			continue
+		}
+		escaped, _ := json.Marshal(value)
+		out.Write(escaped[1 : len(escaped)-1])
+	}
+	out.Write(data[last:])
+	return out.Bytes(), problems
+}
+
+// decodeProblem describes a JSON decoding error with its position in data
+func decodeProblem(data []byte, err error) string {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxErr):
+		line, column := position(data, int(syntaxErr.Offset))
+		return fmt.Sprintf("line %d, column %d: %v", line, column, syntaxErr)
+	case errors.As(err, &typeErr):
+		line, _ := position(data, int(typeErr.Offset))
+		want := strings.TrimPrefix(typeErr.Type.String(), "*")
+		if typeErr.Type.Kind() == reflect.Map || typeErr.Type.Kind() == reflect.Struct {
+			want = "object"
+		}
+		return fmt.Sprintf("line %d: %s: want %s, got %s", line, typeErr.Field, want, typeErr.Value)
+	}
+	return err.Error()
+}
+
+// position returns the line and column of a byte offset in data, both counted from 1
+func position(data []byte, offset int) (int, int) {
+	if offset > len(data) {
+		offset = len(data)
+	}
+	line := bytes.Count(data[:offset], []byte("\n")) + 1
+	column := offset - bytes.LastIndexByte(data[:offset], '\n')
+	return line, column
+}
+
+// unknownFields lists the object keys in raw that have no field in t, a struct or map type
+func unknownFields(path string, raw interface{}, t reflect.Type) []string {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	object, ok := raw.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	var problems []string
+	keys := make([]string, 0, len(object))
+	for key := range object {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		switch t.Kind() {
+		case reflect.Map:
+			problems = append(problems, unknownFields(joinPath(path, key), object[key], t.Elem())...)
+		case reflect.Struct:
+			field, ok := fieldByTag(t, key)
+			if !ok {
+				problems = append(problems, fmt.Sprintf("%s: unknown setting", joinPath(path, key)))
+				continue
+			}
+			problems = append(problems, unknownFields(joinPath(path, key), object[key], field.Type)...)
+		}
+	}
+	return problems
+}
+
+// fieldByTag returns the field of struct type t with the JSON name key
+func fieldByTag(t reflect.Type, key string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if name, _, _ := strings.Cut(field.Tag.Get("json"), ","); name == key {
+			return field, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// joinPath appends a key to a dotted setting path
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// validate checks the settings of the file against each other
+func (f *File) validate() []string {
+	var problems []string
+	problem := func(path, format string, v ...interface{}) {
+		problems = append(problems, path+": "+fmt.Sprintf(format, v...))
+	}
+
+	if f.Server != nil && f.Server.Port != nil && (*f.Server.Port < 1 || *f.Server.Port > 65535) {
+		problem("server.port", "must be between 1 and 65535")
+	}
+
+	// Providers and accounts, which routing rules may name
+	names := make(map[string]bool)
+	for _, name := range sortedKeys(f.Providers) {
+		cfg := f.Providers[name]
+		path := "providers." + name
+		settings, ok := providerSettings[name]
+		if !ok {
+			problem(path, "unknown provider; want one of %s", strings.Join(ProviderTypes, ", "))
+			continue
+		}
+		for _, setting := range cfg.settings() {
+			switch {
+			case contains(settings, setting):
+			case name == "qwen":
+				problem(path+"."+setting, "not supported by qwen, which takes only enabled and always uses ~/.qwen/qwenproxy_creds.json")
+			default:
+				problem(path+"."+setting, "not supported by %s", name)
+			}
+		}
+		if cfg.RedirectPort < 0 || cfg.RedirectPort > 65535 {
+			problem(path+".redirect_port", "must be between 1 and 65535")
+		}
+		for _, setting := range []struct{ name, value string }{{"base_url", cfg.BaseURL}, {"daily_base_url", cfg.DailyBaseURL}, {"autopush_base_url", cfg.AutopushBaseURL}} {
+			if setting.value == "" {
+				continue
+			}
+			if u, err := url.Parse(setting.value); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				problem(path+"."+setting.name, "%q is not an http or https URL", setting.value)
+			}
+		}
+		for _, model := range sortedKeys(cfg.ModelMappings) {
+			if cfg.ModelMappings[model] == "" {
+				problem(path+".model_mappings."+model, "maps to an empty model name")
+			}
+		}
+		if !IsEnabled(cfg.Enabled) {
+			continue
+		}
+		names[name] = true
+		for _, account := range sortedKeys(cfg.Accounts) {
+			accountPath := path + ".accounts." + account
+			switch {
+			case !accountNamePattern.MatchString(account):
+				problem(accountPath, "account names are lowercase letters, digits and dashes")
+			case reservedNames[account] || contains(ProviderTypes, account):
+				problem(accountPath, "%q is reserved", account)
+			case names[account]:
+				problem(accountPath, "another account is named %q", account)
+			}
+			accountCfg := cfg.Accounts[account]
+			if name == "kiro" {
+				if accountCfg.CredsDir != "" {
+					problem(accountPath+".creds_dir", "not supported by kiro; use creds_path")
+				}
+				if accountCfg.CredsPath == "" {
+					problem(accountPath+".creds_path", "required, so the account has credentials of its own")
+				}
+			} else {
+				if accountCfg.CredsPath != "" || accountCfg.Region != "" {
+					problem(accountPath, "%s accounts take only enabled and creds_dir", name)
+				}
+				if accountCfg.CredsDir == "" {
+					problem(accountPath+".creds_dir", "required, so the account has credentials of its own")
+				}
+			}
+			if IsEnabled(accountCfg.Enabled) {
+				names[account] = true
+			}
+		}
+	}
+	for _, name := range ProviderTypes {
+		if _, ok := f.Providers[name]; !ok {
+			names[name] = true
+		}
+	}
+
+	if t := f.Timeouts; t != nil {
+		for _, timeout := range t.global() {
+			if timeout.seconds != nil && *timeout.seconds < 0 {
+				problem("timeouts."+timeout.name, "must not be negative")
+			}
+		}
+		for _, section := range []struct {
+			name      string
+			overrides map[string]TimeoutOverrides
+		}{{"providers", t.Providers}, {"models", t.Models}} {
+			for _, name := range sortedKeys(section.overrides) {
+				path := "timeouts." + section.name + "." + name
+				if section.name == "providers" && !contains(ProviderTypes, name) {
+					problem(path, "unknown provider; accounts use the timeouts of their provider")
+				}
+				for _, timeout := range sortedKeys(section.overrides[name]) {
+					switch {
+					case !isTimeoutName(timeout):
+						problem(path+"."+timeout, "unknown timeout; want connect, tls, first_byte, idle, total or stream_total")
+					case section.overrides[name][timeout] < 0:
+						problem(path+"."+timeout, "must not be negative")
+					}
+				}
+			}
+		}
+	}
+
+	for _, alias := range sortedKeys(f.Aliases) {
+		target := f.Aliases[alias]
+		switch _, chained := f.Aliases[target]; {
+		case target == "":
+			problem("aliases."+alias, "stands for an empty model name")
+		case chained:
+			problem("aliases."+alias, "stands for %q, which is an alias too; aliases cannot chain", target)
+		}
+	}
+
+	if f.Routing != nil {
+		if f.Routing.CircuitFailureThreshold < 0 {
+			problem("routing.circuit_failure_threshold", "must be positive")
+		}
+		if f.Routing.CircuitCooldownSeconds < 0 {
+			problem("routing.circuit_cooldown_seconds", "must be positive")
+		}
+		for _, model := range sortedKeys(f.Routing.Models) {
+			rule := f.Routing.Models[model]
+			path := "routing.models." + model
+			if rule.Pin != "" && !names[rule.Pin] {
+				problem(path+".pin", "no enabled provider or account is named %q", rule.Pin)
+			}
+			seen := make(map[string]bool)
+			for i, name := range rule.Order {
+				switch {
+				case !names[name]:
+					problem(fmt.Sprintf("%s.order[%d]", path, i), "no enabled provider or account is named %q", name)
+				case seen[name]:
+					problem(fmt.Sprintf("%s.order[%d]", path, i), "%s is listed twice", name)
+				}
+				seen[name] = true
+			}
+		}
+		for _, name := range sortedKeys(f.Routing.Providers) {
+			if !names[name] {
+				problem("routing.providers."+name, "no enabled provider or account is named %q", name)
+			}
+		}
+	}
+	return problems
+}
+
+// EnabledProviders returns the names of the providers and accounts the file enables, in order
+func (f *File) EnabledProviders() []string {
+	var names []string
+	for _, name := range ProviderTypes {
+		cfg := f.Providers[name]
+		if !IsEnabled(cfg.Enabled) {
+			continue
+		}
+		names = append(names, name)
+		for _, account := range sortedKeys(cfg.Accounts) {
+			if IsEnabled(cfg.Accounts[account].Enabled) {
+				names = append(names, account)
+			}
+		}
+	}
+	return names
+}
+
+// settings lists the names of the settings set in a provider's config
+func (c ProviderConfig) settings() []string {
+	var set []string
+	for setting, isSet := range map[string]bool{
+		"client_id":         c.ClientID != "",
+		"client_secret":     c.ClientSecret != "",
+		"redirect_port":     c.RedirectPort != 0,
+		"creds_dir":         c.CredsDir != "",
+		"creds_path":        c.CredsPath != "",
+		"region":            c.Region != "",
+		"base_url":          c.BaseURL != "",
+		"daily_base_url":    c.DailyBaseURL != "",
+		"autopush_base_url": c.AutopushBaseURL != "",
+		"model_mappings":    len(c.ModelMappings) > 0,
+		"accounts":          len(c.Accounts) > 0,
+	} {
+		if isSet {
+			set = append(set, setting)
+		}
+	}
+	sort.Strings(set)
+	return set
+}
+
+// apply sets the settings of the file on config
+func (f *File) apply(config *Config) {
+	if f.Server != nil && f.Server.Port != nil {
+		config.Server.Port = strconv.Itoa(*f.Server.Port)
+	}
+	if t := f.Timeouts; t != nil {
+		fields := map[string]*int{
+			TimeoutConnect:     &config.HTTPClient.ConnectTimeoutSeconds,
+			TimeoutTLS:         &config.HTTPClient.TLSHandshakeTimeoutSeconds,
+			TimeoutFirstByte:   &config.HTTPClient.FirstByteTimeoutSeconds,
+			TimeoutIdle:        &config.HTTPClient.ReadTimeoutSeconds,
+			TimeoutTotal:       &config.HTTPClient.RequestTimeoutSeconds,
+			TimeoutStreamTotal: &config.HTTPClient.StreamingTimeoutSeconds,
+		}
+		for _, timeout := range t.global() {
+			if timeout.seconds != nil {
+				*fields[timeout.name] = *timeout.seconds
+			}
+		}
+		config.HTTPClient.ProviderTimeouts = mergeTimeoutOverrides(config.HTTPClient.ProviderTimeouts, t.Providers)
+		config.HTTPClient.ModelTimeouts = mergeTimeoutOverrides(config.HTTPClient.ModelTimeouts, t.Models)
+	}
+	config.Providers = f.Providers
+	config.Aliases = f.Aliases
+	if r := f.Routing; r != nil {
+		if r.RulesFile != "" {
+			config.Routing.RulesFile = r.RulesFile
+		}
+		if r.StateFile != "" {
+			config.Routing.StateFile = r.StateFile
+		}
+		if r.CircuitFailureThreshold > 0 {
+			config.Routing.CircuitFailureThreshold = r.CircuitFailureThreshold
+		}
+		if r.CircuitCooldownSeconds > 0 {
+			config.Routing.CircuitCooldownSeconds = r.CircuitCooldownSeconds
+		}
+		config.Routing.Models = r.Models
+		config.Routing.Providers = r.Providers
+	}
+}
+
+// mergeTimeoutOverrides adds the overrides in src to dst, replacing timeouts set in both
+func mergeTimeoutOverrides(dst, src map[string]TimeoutOverrides) map[string]TimeoutOverrides {
+	if len(src) == 0 {
+		return dst
+	}
+	if dst == nil {
+		dst = make(map[string]TimeoutOverrides)
+	}
+	for name, overrides := range src {
+		if dst[name] == nil {
+			dst[name] = TimeoutOverrides{}
+		}
+		for timeout, seconds := range overrides {
+			dst[name][timeout] = seconds
+		}
+	}
+	return dst
+}
+
+// sortedKeys returns the keys of a map in order, so problems are reported in a stable order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// contains reports whether list holds value
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,213 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeConfigFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write config file: %v", err)
+	}
+	return path
+}
+
+// fileProblems loads a config file expected to be invalid and returns its problems
+func fileProblems(t *testing.T, content string) []string {
+	t.Helper()
+	_, err := LoadFile(writeConfigFile(t, content))
+	var fileErr *FileError
+	if !errors.As(err, &fileErr) {
+		t.Fatalf("LoadFile() error = %v, want a *FileError", err)
+	}
+	return fileErr.Problems
+}
+
+func TestLoadFileInterpolation(t *testing.T) {
+	t.Setenv("TEST_KIRO_REGION", "eu-west-1")
+	t.Setenv("TEST_FIRST_BYTE", "90")
+	file, err := LoadFile(writeConfigFile(t, `{
+		"timeouts": {"first_byte": ${TEST_FIRST_BYTE}},
+		"providers": {"kiro": {"region": "${TEST_KIRO_REGION}", "base_url": "${TEST_UNSET_URL:-https://kiro.example.com}"}},
+		"aliases": {"$${literal}": "claude-sonnet-4-5"}
+	}`))
+	if err != nil {
+		t.Fatalf("LoadFile() error = %v", err)
+	}
+	kiro := file.Providers["kiro"]
+	if kiro.Region != "eu-west-1" || kiro.BaseURL != "https://kiro.example.com" {
+		t.Errorf("kiro settings = %+v, want the interpolated region and default base URL", kiro)
+	}
+	if *file.Timeouts.FirstByte != 90 {
+		t.Errorf("first_byte = %d, want 90", *file.Timeouts.FirstByte)
+	}
+	if _, ok := file.Aliases["${literal}"]; !ok {
+		t.Errorf("aliases = %v, want the escaped reference kept", file.Aliases)
+	}
+
+	// Values and defaults are inserted as string contents, whatever characters they hold
+	t.Setenv("TEST_CLIENT_SECRET", `se"cr\et`)
+	file, err = LoadFile(writeConfigFile(t, `{
+		"providers": {
+			"gemini-cli": {"client_secret": "${TEST_CLIENT_SECRET}", "creds_dir": "${TEST_UNSET_DIR:-C:\creds\"gemini"}"}
+		}
+	}`))
+	if err != nil {
+		t.Fatalf("LoadFile() with quotes and backslashes error = %v", err)
+	}
+	if gemini := file.Providers["gemini-cli"]; gemini.ClientSecret != `se"cr\et` || gemini.CredsDir != `C:\creds\"gemini"` {
+		t.Errorf("gemini-cli settings = %+v, want the value and default kept verbatim", gemini)
+	}
+
+	problems := fileProblems(t, "{\n\"providers\": {\"kiro\": {\"region\": \"${TEST_UNSET_REGION}\"}}}")
+	if len(problems) != 1 || problems[0] != "line 2: environment variable TEST_UNSET_REGION is not set" {
+		t.Errorf("problems = %q, want the unset variable and its line", problems)
+	}
+}
+
+func TestLoadFileDecodeErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{"syntax", "{\n  \"timeouts\": {\"connect\": 10,}}", "line 2, column"},
+		{"type", "{\n  \"timeouts\": {\"connect\": \"10\"}}", "line 2: timeouts.connect: want int, got string"},
+		{"unknown setting", `{"providers": {"kiro": {"regoin": "us-east-1"}}}`, "providers.kiro.regoin: unknown setting"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			problems := fileProblems(t, tt.content)
+			if len(problems) != 1 || !strings.HasPrefix(problems[0], tt.want) {
+				t.Errorf("problems = %q, want one starting with %q", problems, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadFileValidation(t *testing.T) {
+	problems := fileProblems(t, `{
+		"server": {"port": 70000},
+		"timeouts": {"idle": -1, "providers": {"kiro": {"idel": 3}}},
+		"providers": {
+			"gemini-cli": {"enabled": false},
+			"qwen": {"base_url": "https://qwen.example.com", "creds_dir": ".qwen-work", "accounts": {"qwen-work": {"creds_dir": ".qwen-work"}}},
+			"kiro": {"accounts": {"Work": {"creds_path": "/creds/work.json"}, "kiro-home": {}}},
+			"antigravity": {"daily_base_url": "not a url"},
+			"openai": {}
+		},
+		"aliases": {"s": "sonnet", "sonnet": "claude-sonnet-4-5"},
+		"routing": {"circuit_cooldown_seconds": -30, "models": {"m": {"pin": "gemini-cli", "order": ["kiro", "kiro"]}}}
+	}`)
+	want := []string{
+		"server.port",
+		"timeouts.idle",
+		"timeouts.providers.kiro.idel",
+		"providers.qwen.base_url",
+		"providers.qwen.creds_dir",
+		"providers.qwen.accounts",
+		"providers.kiro.accounts.Work",
+		"providers.kiro.accounts.kiro-home.creds_path",
+		"providers.antigravity.daily_base_url",
+		"providers.openai",
+		"aliases.s",
+		"routing.circuit_cooldown_seconds",
+		"routing.models.m.pin",
+		"routing.models.m.order[1]",
+	}
+	for _, path := range want {
+		found := false
+		for _, problem := range problems {
+			if strings.HasPrefix(problem, path+":") {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("no problem reported for %s in %q", path, problems)
+		}
+	}
+	if len(problems) != len(want) {
+		t.Errorf("got %d problems, want %d: %q", len(problems), len(want), problems)
+	}
+}
+
+func TestLoadFileServerPort(t *testing.T) {
+	for _, content := range []string{`{"server": {"port": 0}}`, `{"server": {"port": -1}}`} {
+		if problems := fileProblems(t, content); len(problems) != 1 || !strings.HasPrefix(problems[0], "server.port:") {
+			t.Errorf("problems of %s = %q, want server.port", content, problems)
+		}
+	}
+	// A server section without a port keeps the default
+	file, err := LoadFile(writeConfigFile(t, `{"server": {}}`))
+	if err != nil {
+		t.Fatalf("LoadFile() error = %v", err)
+	}
+	cfg := DefaultConfig()
+	file.apply(cfg)
+	if cfg.Server.Port != "8143" {
+		t.Errorf("port = %s, want the default 8143", cfg.Server.Port)
+	}
+}
+
+func TestLoadFileEnabledProviders(t *testing.T) {
+	file, err := LoadFile(writeConfigFile(t, `{
+		"providers": {
+			"gemini-cli": {"enabled": false},
+			"kiro": {"accounts": {"kiro-work": {"creds_path": "/creds/work.json"}, "kiro-old": {"enabled": false, "creds_path": "/creds/old.json"}}}
+		},
+		"routing": {"models": {"claude-sonnet-4-5": {"order": ["kiro-work", "kiro"]}}}
+	}`))
+	if err != nil {
+		t.Fatalf("LoadFile() error = %v", err)
+	}
+	got := strings.Join(file.EnabledProviders(), ",")
+	if got != "qwen,kiro,kiro-work,antigravity,iflow" {
+		t.Errorf("EnabledProviders() = %s, want the enabled providers and accounts", got)
+	}
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	path := writeConfigFile(t, `{
+		"server": {"port": 9100},
+		"timeouts": {"first_byte": 60, "connect": 5, "providers": {"kiro": {"first_byte": 300}}},
+		"aliases": {"sonnet": "claude-sonnet-4-5"},
+		"routing": {"circuit_failure_threshold": 3, "circuit_cooldown_seconds": 60}
+	}`)
+	t.Setenv("PORT", "9200")
+	t.Setenv("CIRCUIT_COOLDOWN_SECONDS", "120")
+	t.Setenv("CONNECT_TIMEOUT_SECONDS", "")
+	t.Setenv("FIRST_BYTE_TIMEOUT_SECONDS", "")
+	t.Setenv("PROVIDER_TIMEOUTS", "kiro.idle=30")
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.Server.Port != "9200" {
+		t.Errorf("port = %s, want the environment's 9200 over the file's", cfg.Server.Port)
+	}
+	if cfg.HTTPClient.FirstByteTimeoutSeconds != 60 || cfg.HTTPClient.ConnectTimeoutSeconds != 5 {
+		t.Errorf("timeouts = %d first byte, %d connect; want the file's 60 and 5",
+			cfg.HTTPClient.FirstByteTimeoutSeconds, cfg.HTTPClient.ConnectTimeoutSeconds)
+	}
+	kiro := cfg.HTTPClient.ProviderTimeouts["kiro"]
+	if kiro[TimeoutFirstByte] != 300 || kiro[TimeoutIdle] != 30 {
+		t.Errorf("kiro timeouts = %v, want the file's first byte merged with the environment's idle", kiro)
+	}
+	if cfg.Aliases["sonnet"] != "claude-sonnet-4-5" {
+		t.Errorf("aliases = %v, want the file's", cfg.Aliases)
+	}
+	if cfg.Routing.CircuitFailureThreshold != 3 || cfg.Routing.CircuitCooldownSeconds != 120 {
+		t.Errorf("circuit breaker = %d failures, %ds cooldown; want the file's 3 and the environment's 120",
+			cfg.Routing.CircuitFailureThreshold, cfg.Routing.CircuitCooldownSeconds)
+	}
+
+	if _, err := Load(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("Load() of a missing file succeeded")
+	}
+}
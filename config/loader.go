@@ -1,15 +1,37 @@
 package config
 
 import (
+	"net/url"
 	"os"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // LoadConfig loads configuration from environment variables
 func LoadConfig() *Config {
 	config := DefaultConfig()
+	loadEnv(config)
+	return config
+}
+
+// Load loads the configuration: the defaults, then the config file at path unless path is
+// empty, then environment variables, which take precedence over the file
+func Load(path string) (*Config, error) {
+	config := DefaultConfig()
+	if path != "" {
+		file, err := LoadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		file.apply(config)
+	}
+	loadEnv(config)
+	return config, nil
+}
 
+// loadEnv applies the settings of environment variables to config
+func loadEnv(config *Config) {
 	// Load server configuration
 	if port := os.Getenv("PORT"); port != "" {
 		config.Server.Port = port
@@ -47,10 +69,296 @@ func LoadConfig() *Config {
 		}
 	}
 
+	if connectTimeout := os.Getenv("CONNECT_TIMEOUT_SECONDS"); connectTimeout != "" {
+		if val, err := strconv.Atoi(connectTimeout); err == nil && val >= 0 {
+			config.HTTPClient.ConnectTimeoutSeconds = val
+		}
+	}
+	if tlsTimeout := os.Getenv("TLS_HANDSHAKE_TIMEOUT_SECONDS"); tlsTimeout != "" {
+		if val, err := strconv.Atoi(tlsTimeout); err == nil && val >= 0 {
+			config.HTTPClient.TLSHandshakeTimeoutSeconds = val
+		}
+	}
+	if firstByteTimeout := os.Getenv("FIRST_BYTE_TIMEOUT_SECONDS"); firstByteTimeout != "" {
+		if val, err := strconv.Atoi(firstByteTimeout); err == nil && val >= 0 {
+			config.HTTPClient.FirstByteTimeoutSeconds = val
+		}
+	}
+	if providerTimeouts := os.Getenv("PROVIDER_TIMEOUTS"); providerTimeouts != "" {
+		config.HTTPClient.ProviderTimeouts = mergeTimeoutOverrides(config.HTTPClient.ProviderTimeouts, parseTimeoutOverrides(providerTimeouts))
+	}
+	if modelTimeouts := os.Getenv("MODEL_TIMEOUTS"); modelTimeouts != "" {
+		config.HTTPClient.ModelTimeouts = mergeTimeoutOverrides(config.HTTPClient.ModelTimeouts, parseTimeoutOverrides(modelTimeouts))
+	}
+	config.HTTPClient.Egress = EgressConfig{
+		ProxyURL:  strings.TrimSpace(os.Getenv("UPSTREAM_PROXY")),
+		NoProxy:   strings.TrimSpace(os.Getenv("UPSTREAM_NO_PROXY")),
+		CABundles: splitList(os.Getenv("UPSTREAM_CA_BUNDLES")),
+	}
+	config.HTTPClient.ProviderEgress = loadProviderEgress(os.Environ())
+
+	// Load streaming configuration
+	if maxErrors := os.Getenv("STREAMING_MAX_ERRORS"); maxErrors != "" {
+		if val, err := strconv.Atoi(maxErrors); err == nil && val >= 0 {
+			config.Streaming.MaxErrors = val
+		}
+	}
+	if bufferSize := os.Getenv("STREAMING_BUFFER_SIZE"); bufferSize != "" {
+		if val, err := strconv.Atoi(bufferSize); err == nil && val > 0 {
+			config.Streaming.BufferSize = val
+		}
+	}
+	if maxEventSize := os.Getenv("STREAMING_MAX_EVENT_SIZE"); maxEventSize != "" {
+		if val, err := strconv.Atoi(maxEventSize); err == nil && val > 0 {
+			config.Streaming.MaxEventSize = val
+		}
+	}
+	if keepalive := os.Getenv("STREAMING_KEEPALIVE_SECONDS"); keepalive != "" {
+		if val, err := strconv.Atoi(keepalive); err == nil && val >= 0 {
+			config.Streaming.KeepaliveSeconds = val
+		}
+	}
+	if idleTimeout := os.Getenv("STREAMING_IDLE_TIMEOUT_SECONDS"); idleTimeout != "" {
+		if val, err := strconv.Atoi(idleTimeout); err == nil && val >= 0 {
+			config.Streaming.IdleTimeoutSeconds = val
+		}
+	}
+	// An empty value is meaningful here: it turns the feature off for every provider
+	if aggregate, ok := os.LookupEnv("STREAMING_AGGREGATE_PROVIDERS"); ok {
+		config.Streaming.AggregateProviders = splitList(aggregate)
+	}
+	if simulate, ok := os.LookupEnv("STREAMING_SIMULATE_PROVIDERS"); ok {
+		config.Streaming.SimulateProviders = splitList(simulate)
+	}
+
+	// Load context window check configuration
+	if margin := os.Getenv("CONTEXT_REJECT_MARGIN_PERCENT"); margin != "" {
+		if val, err := strconv.Atoi(margin); err == nil && val >= 0 {
+			config.Context.RejectMarginPercent = val
+		}
+	}
+
+	// Load client authentication configuration
+	config.Auth.APIKeys = splitList(os.Getenv("API_KEYS"))
+	config.Auth.KeysFile = strings.TrimSpace(os.Getenv("API_KEYS_FILE"))
+	if budgetFile := strings.TrimSpace(os.Getenv("API_KEY_BUDGET_FILE")); budgetFile != "" {
+		config.Auth.BudgetFile = budgetFile
+	}
+
+	// Load usage accounting configuration
+	if usageDir := strings.TrimSpace(os.Getenv("USAGE_DIR")); usageDir != "" {
+		config.Usage.Dir = usageDir
+	}
+	if retention := os.Getenv("USAGE_RETENTION_DAYS"); retention != "" {
+		if val, err := strconv.Atoi(retention); err == nil && val >= 0 {
+			config.Usage.RetentionDays = val
+		}
+	}
+
+	// Load audit log configuration
+	config.Audit.Dir = strings.TrimSpace(os.Getenv("AUDIT_DIR"))
+	if rate := os.Getenv("AUDIT_SAMPLE_RATE"); rate != "" {
+		if val, err := strconv.ParseFloat(rate, 64); err == nil && val >= 0 && val <= 1 {
+			config.Audit.SampleRate = val
+		}
+	}
+	if redact := os.Getenv("AUDIT_REDACT_PROMPTS"); redact != "" {
+		config.Audit.RedactPrompts = strings.ToLower(redact) == "true"
+	}
+	if maxBody := os.Getenv("AUDIT_MAX_BODY_KB"); maxBody != "" {
+		if val, err := strconv.Atoi(maxBody); err == nil && val > 0 {
+			config.Audit.MaxBodyBytes = val << 10
+		}
+	}
+	if maxSize := os.Getenv("AUDIT_MAX_FILE_MB"); maxSize != "" {
+		if val, err := strconv.ParseInt(maxSize, 10, 64); err == nil && val > 0 {
+			config.Audit.MaxFileBytes = val << 20
+		}
+	}
+	if maxAge := os.Getenv("AUDIT_MAX_FILE_HOURS"); maxAge != "" {
+		if val, err := strconv.Atoi(maxAge); err == nil && val > 0 {
+			config.Audit.MaxFileAge = time.Duration(val) * time.Hour
+		}
+	}
+	if maxFiles := os.Getenv("AUDIT_MAX_FILES"); maxFiles != "" {
+		if val, err := strconv.Atoi(maxFiles); err == nil && val >= 0 {
+			config.Audit.MaxFiles = val
+		}
+	}
+
+	// Load health check configuration
+	if interval := os.Getenv("HEALTH_CHECK_INTERVAL_SECONDS"); interval != "" {
+		if val, err := strconv.Atoi(interval); err == nil && val > 0 {
+			config.Health.IntervalSeconds = val
+		}
+	}
+	if timeout := os.Getenv("HEALTH_CHECK_TIMEOUT_SECONDS"); timeout != "" {
+		if val, err := strconv.Atoi(timeout); err == nil && val > 0 {
+			config.Health.TimeoutSeconds = val
+		}
+	}
+
+	// Load routing configuration
+	if rulesFile := strings.TrimSpace(os.Getenv("ROUTING_RULES_FILE")); rulesFile != "" {
+		config.Routing.RulesFile = rulesFile
+	}
+	if stateFile := strings.TrimSpace(os.Getenv("ROUTING_STATE_FILE")); stateFile != "" {
+		config.Routing.StateFile = stateFile
+	}
+	if threshold := os.Getenv("CIRCUIT_FAILURE_THRESHOLD"); threshold != "" {
+		if val, err := strconv.Atoi(threshold); err == nil && val > 0 {
+			config.Routing.CircuitFailureThreshold = val
+		}
+	}
+	if cooldown := os.Getenv("CIRCUIT_COOLDOWN_SECONDS"); cooldown != "" {
+		if val, err := strconv.Atoi(cooldown); err == nil && val > 0 {
+			config.Routing.CircuitCooldownSeconds = val
+		}
+	}
+
+	// Load tracing configuration
+	if exporter := strings.TrimSpace(os.Getenv("OTEL_TRACES_EXPORTER")); exporter != "" {
+		config.Tracing.Exporter = strings.ToLower(exporter)
+	}
+	if endpoint := strings.TrimSpace(os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT")); endpoint != "" {
+		config.Tracing.Endpoint = endpoint
+	} else if endpoint := strings.TrimSpace(os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")); endpoint != "" {
+		// The general endpoint is a base URL that signal paths are appended to
+		config.Tracing.Endpoint = strings.TrimSuffix(endpoint, "/") + "/v1/traces"
+	}
+	config.Tracing.Headers = parseHeaders(os.Getenv("OTEL_EXPORTER_OTLP_HEADERS"))
+	if serviceName := strings.TrimSpace(os.Getenv("OTEL_SERVICE_NAME")); serviceName != "" {
+		config.Tracing.ServiceName = serviceName
+	}
+	if ratio := os.Getenv("OTEL_TRACES_SAMPLER_ARG"); ratio != "" {
+		if val, err := strconv.ParseFloat(ratio, 64); err == nil && val >= 0 && val <= 1 {
+			config.Tracing.SampleRatio = val
+		}
+	}
+
 	// Load logging configuration
-	if debugMode := os.Getenv("DEBUG"); debugMode != "" {
-		config.Logging.IsDebugMode = strings.ToLower(debugMode) == "true"
+	if debugMode := os.Getenv("DEBUG"); strings.ToLower(debugMode) == "true" {
+		config.Logging.Level = "debug"
 	}
+	if level := strings.TrimSpace(os.Getenv("LOG_LEVEL")); level != "" {
+		config.Logging.Level = strings.ToLower(level)
+	}
+	if format := strings.TrimSpace(os.Getenv("LOG_FORMAT")); format != "" {
+		config.Logging.Format = strings.ToLower(format)
+	}
+	config.Logging.Levels = parseLevels(os.Getenv("LOG_LEVELS"))
+}
 
-	return config
+// splitList parses a comma-separated list, dropping empty entries
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// parseHeaders parses comma-separated key=value pairs with URL-encoded values,
+// the format of OTEL_EXPORTER_OTLP_HEADERS
+func parseHeaders(value string) map[string]string {
+	headers := make(map[string]string)
+	for _, pair := range splitList(value) {
+		key, val, ok := strings.Cut(pair, "=")
+		if !ok {
+			continue
+		}
+		if unescaped, err := url.PathUnescape(strings.TrimSpace(val)); err == nil {
+			val = unescaped
+		}
+		headers[strings.TrimSpace(key)] = val
+	}
+	return headers
+}
+
+// parseLevels parses subsystem=level pairs such as "kiro=debug,auth=warn"
+func parseLevels(value string) map[string]string {
+	levels := make(map[string]string)
+	for _, pair := range splitList(value) {
+		subsystem, level, ok := strings.Cut(pair, "=")
+		if !ok {
+			continue
+		}
+		levels[strings.ToLower(strings.TrimSpace(subsystem))] = strings.ToLower(strings.TrimSpace(level))
+	}
+	return levels
+}
+
+// Suffixes of the per-provider egress variables, such as KIRO_UPSTREAM_PROXY
+const (
+	proxyEnvSuffix     = "_UPSTREAM_PROXY"
+	noProxyEnvSuffix   = "_UPSTREAM_NO_PROXY"
+	caBundlesEnvSuffix = "_UPSTREAM_CA_BUNDLES"
+)
+
+// loadProviderEgress collects per-provider egress settings from environment entries.
+// The provider name is the variable prefix lowercased with underscores as dashes,
+// so GEMINI_CLI_UPSTREAM_PROXY configures the gemini-cli provider.
+func loadProviderEgress(environ []string) map[string]EgressConfig {
+	egress := make(map[string]EgressConfig)
+	for _, entry := range environ {
+		key, value, _ := strings.Cut(entry, "=")
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+		for _, suffix := range []string{noProxyEnvSuffix, caBundlesEnvSuffix, proxyEnvSuffix} {
+			prefix, ok := strings.CutSuffix(key, suffix)
+			if !ok || prefix == "" {
+				continue
+			}
+			name := strings.ReplaceAll(strings.ToLower(prefix), "_", "-")
+			cfg := egress[name]
+			switch suffix {
+			case proxyEnvSuffix:
+				cfg.ProxyURL = value
+			case noProxyEnvSuffix:
+				cfg.NoProxy = value
+			case caBundlesEnvSuffix:
+				cfg.CABundles = splitList(value)
+			}
+			egress[name] = cfg
+			break
+		}
+	}
+	return egress
+}
+
+// parseTimeoutOverrides parses a comma-separated list of name.timeout=seconds entries,
+// such as "kiro.first_byte=300,gemini-2.5-pro.idle=240". Names may contain dots, so the
+// timeout name is taken from after the last one. Invalid entries are ignored.
+func parseTimeoutOverrides(value string) map[string]TimeoutOverrides {
+	overrides := make(map[string]TimeoutOverrides)
+	for _, entry := range splitList(value) {
+		key, seconds, ok := strings.Cut(entry, "=")
+		dot := strings.LastIndex(key, ".")
+		if !ok || dot <= 0 {
+			continue
+		}
+		name, timeout := strings.TrimSpace(key[:dot]), strings.TrimSpace(key[dot+1:])
+		val, err := strconv.Atoi(strings.TrimSpace(seconds))
+		if err != nil || val < 0 || !isTimeoutName(timeout) {
+			continue
+		}
+		if overrides[name] == nil {
+			overrides[name] = TimeoutOverrides{}
+		}
+		overrides[name][timeout] = val
+	}
+	return overrides
+}
+
+// isTimeoutName reports whether name is one of the Timeout* names
+func isTimeoutName(name string) bool {
+	switch name {
+	case TimeoutConnect, TimeoutTLS, TimeoutFirstByte, TimeoutIdle, TimeoutTotal, TimeoutStreamTotal:
+		return true
+	}
+	return false
 }
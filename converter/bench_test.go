@@ -0,0 +1,90 @@
+package converter
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// geminiStreamChunk is a typical Gemini streaming payload as received from upstream
+var geminiStreamChunk = []byte(`{"candidates":[{"content":{"role":"model","parts":[{"text":"Here is the next part of the answer, streamed as a delta."}]},"index":0}],"usageMetadata":{"promptTokenCount":120,"candidatesTokenCount":48,"totalTokenCount":168}}`)
+
+// BenchmarkGeminiStreamChunk measures the typed hot path: raw JSON in, SSE payload out
+func BenchmarkGeminiStreamChunk(b *testing.B) {
+	conv := NewGeminiConverter()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		chunk, err := conv.ToOpenAIStreamChunk(json.RawMessage(geminiStreamChunk), "gemini-2.5-pro")
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, err := json.Marshal(chunk); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkGeminiStreamChunkMap reproduces the previous map-based path for comparison:
+// decode to interface{}, re-encode into the Gemini struct, build a map chunk and marshal it
+func BenchmarkGeminiStreamChunkMap(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var native interface{}
+		if err := json.Unmarshal(geminiStreamChunk, &native); err != nil {
+			b.Fatal(err)
+		}
+		resp, err := asGeminiResponse(native)
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		choices := []interface{}{}
+		for i, candidate := range resp.Candidates {
+			delta := map[string]interface{}{}
+			if candidate.Content != nil {
+				var content string
+				for _, part := range candidate.Content.Parts {
+					content += part.Text
+				}
+				if content != "" {
+					delta["content"] = content
+				}
+			}
+			choices = append(choices, map[string]interface{}{
+				"index":         i,
+				"finish_reason": nil,
+				"delta":         delta,
+			})
+		}
+		chunk := map[string]interface{}{
+			"id":      "chatcmpl-stream",
+			"object":  "chat.completion.chunk",
+			"created": getCurrentTimestamp(),
+			"model":   "gemini-2.5-pro",
+			"choices": choices,
+			"usage": map[string]interface{}{
+				"prompt_tokens":     resp.UsageMetadata.PromptTokenCount,
+				"completion_tokens": resp.UsageMetadata.CandidatesTokenCount,
+				"total_tokens":      resp.UsageMetadata.TotalTokenCount,
+			},
+		}
+		if _, err := json.Marshal(chunk); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkOpenAIPassthroughChunk measures decoding and re-encoding an OpenAI-format chunk
+func BenchmarkOpenAIPassthroughChunk(b *testing.B) {
+	raw := json.RawMessage(`{"id":"chatcmpl-1","object":"chat.completion.chunk","created":1700000000,"model":"qwen3-coder-plus","choices":[{"index":0,"delta":{"content":"partial text"},"finish_reason":null}]}`)
+	conv := NewQwenConverter()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		chunk, err := conv.ToOpenAIStreamChunk(raw, "qwen3-coder-plus")
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, err := json.Marshal(chunk); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -2,9 +2,12 @@
 package converter
 
 import (
+	"encoding/json"
 	"fmt"
+	"strings"
 	"time"
 
+	"github.com/sunbankio/qwencoder-proxy/openai"
 	"github.com/sunbankio/qwencoder-proxy/provider"
 	"github.com/sunbankio/qwencoder-proxy/provider/kiro"
 )
@@ -18,111 +21,154 @@ func NewClaudeConverter() *ClaudeConverter {
 }
 
 // ToOpenAIRequest converts Claude format to OpenAI format
-func (c *ClaudeConverter) ToOpenAIRequest(native interface{}) (interface{}, error) {
-	// For now, return as-is - we'll implement proper conversion later
-	return native, nil
-}
+func (c *ClaudeConverter) ToOpenAIRequest(native interface{}) (*openai.ChatCompletionRequest, error) {
+	claudeReq, err := asClaudeRequest(native)
+	if err != nil {
+		return nil, err
+	}
 
-// ToOpenAIResponse converts Claude format to OpenAI format
-func (c *ClaudeConverter) ToOpenAIResponse(native interface{}, model string) (interface{}, error) {
-	// Parse the Claude response
-	claudeResp, ok := native.(*kiro.ClaudeResponse)
-	if !ok {
-		// Try to parse from map if it's not already a ClaudeResponse
-		respMap, ok := native.(map[string]interface{})
-		if !ok {
-			return nil, fmt.Errorf("invalid response type, expected *kiro.ClaudeResponse or map[string]interface{}")
-		}
-		
-		// Convert map to ClaudeResponse
-		claudeResp = &kiro.ClaudeResponse{}
-		if id, ok := respMap["id"].(string); ok {
-			claudeResp.ID = id
-		}
-		if role, ok := respMap["role"].(string); ok {
-			claudeResp.Role = role
-		}
-		if modelStr, ok := respMap["model"].(string); ok {
-			claudeResp.Model = modelStr
+	openAIReq := &openai.ChatCompletionRequest{
+		Model:       claudeReq.Model,
+		Messages:    []openai.Message{},
+		Stream:      claudeReq.Stream,
+		Temperature: claudeReq.Temperature,
+		TopP:        claudeReq.TopP,
+		Stop:        claudeReq.StopSequences,
+	}
+	if claudeReq.MaxTokens > 0 {
+		maxTokens := claudeReq.MaxTokens
+		openAIReq.MaxTokens = &maxTokens
+	}
+
+	if claudeReq.System != nil {
+		if system := claudeToolResultText(claudeReq.System); system != "" {
+			openAIReq.Messages = append(openAIReq.Messages, openai.Message{Role: "system", Content: openai.TextContent(system)})
 		}
-		if stopReason, ok := respMap["stop_reason"].(string); ok {
-			claudeResp.StopReason = stopReason
+	}
+
+	for _, msg := range claudeReq.Messages {
+		blocks, err := claudeContentBlocks(msg.Content)
+		if err != nil {
+			return nil, fmt.Errorf("invalid message content: %w", err)
 		}
-		
-		// Parse content array
-		if contentRaw, ok := respMap["content"].([]interface{}); ok {
-			for _, contentItem := range contentRaw {
-				if contentMap, ok := contentItem.(map[string]interface{}); ok {
-					block := kiro.ContentBlock{}
-					if blockType, ok := contentMap["type"].(string); ok {
-						block.Type = blockType
-					}
-					if text, ok := contentMap["text"].(string); ok {
-						block.Text = text
-					}
-					claudeResp.Content = append(claudeResp.Content, block)
+
+		var parts []openai.ContentPart
+		var toolCalls []openai.ToolCall
+		for _, block := range blocks {
+			switch block.Type {
+			case "text":
+				parts = append(parts, openai.ContentPart{Type: "text", Text: block.Text})
+			case "image":
+				if block.Source == nil {
+					continue
+				}
+				imageURL := block.Source.URL
+				if block.Source.Type != "url" {
+					imageURL = fmt.Sprintf("data:%s;base64,%s", block.Source.MediaType, block.Source.Data)
 				}
+				parts = append(parts, openai.ContentPart{Type: "image_url", ImageURL: &openai.ImageURL{URL: imageURL}})
+			case "tool_use":
+				toolCalls = append(toolCalls, openAIToolCall(block.ID, block.Name, block.Input))
+			case "tool_result":
+				// Tool results become standalone tool messages in OpenAI format
+				openAIReq.Messages = append(openAIReq.Messages, openai.Message{
+					Role:       "tool",
+					ToolCallID: block.ToolUseID,
+					Content:    openai.TextContent(claudeToolResultText(block.Content)),
+				})
 			}
 		}
-		
-		// Parse usage
-		if usageRaw, ok := respMap["usage"].(map[string]interface{}); ok {
-			usage := &kiro.Usage{}
-			if inputTokens, ok := usageRaw["input_tokens"].(float64); ok {
-				usage.InputTokens = int(inputTokens)
-			}
-			if outputTokens, ok := usageRaw["output_tokens"].(float64); ok {
-				usage.OutputTokens = int(outputTokens)
+
+		if len(parts) == 0 && len(toolCalls) == 0 {
+			continue
+		}
+
+		openAIMsg := openai.Message{Role: msg.Role, ToolCalls: toolCalls}
+		if len(parts) > 0 {
+			openAIMsg.Content = openai.PartsContent(parts...)
+		}
+		openAIReq.Messages = append(openAIReq.Messages, openAIMsg)
+	}
+
+	for _, tool := range claudeReq.Tools {
+		openAIReq.Tools = append(openAIReq.Tools, openai.Tool{
+			Type: "function",
+			Function: openai.FunctionDefinition{
+				Name:        tool.Name,
+				Description: tool.Description,
+				Parameters:  tool.InputSchema,
+			},
+		})
+	}
+
+	if claudeReq.ToolChoice != nil {
+		var choice interface{}
+		switch claudeReq.ToolChoice.Type {
+		case "any":
+			choice = "required"
+		case "none":
+			choice = "none"
+		case "tool":
+			choice = map[string]interface{}{
+				"type":     "function",
+				"function": map[string]interface{}{"name": claudeReq.ToolChoice.Name},
 			}
-			claudeResp.Usage = usage
+		default:
+			choice = "auto"
 		}
+		openAIReq.ToolChoice, _ = json.Marshal(choice)
+	}
+
+	return openAIReq, nil
+}
+
+// ToOpenAIResponse converts Claude format to OpenAI format
+func (c *ClaudeConverter) ToOpenAIResponse(native interface{}, model string) (*openai.ChatCompletionResponse, error) {
+	claudeResp, err := asClaudeResponse(native)
+	if err != nil {
+		return nil, err
 	}
 
-	// Extract text content from Claude content blocks
-	var content string
+	// Extract text content and tool calls from Claude content blocks
+	var content strings.Builder
+	var toolCalls []openai.ToolCall
 	for _, block := range claudeResp.Content {
-		if block.Type == "text" {
-			content += block.Text
+		switch block.Type {
+		case "text":
+			content.WriteString(block.Text)
+		case "tool_use":
+			toolCalls = append(toolCalls, openAIToolCall(block.ID, block.Name, block.Input))
 		}
 	}
 
-	// Convert stop_reason to OpenAI finish_reason
-	finishReason := "stop"
-	switch claudeResp.StopReason {
-	case "end_turn":
-		finishReason = "stop"
-	case "max_tokens":
-		finishReason = "length"
-	case "stop_sequence":
-		finishReason = "stop"
-	case "tool_use":
-		finishReason = "tool_calls"
+	role := claudeResp.Role
+	if role == "" {
+		role = "assistant"
 	}
 
 	// Create OpenAI response structure
-	openAIResp := map[string]interface{}{
-		"id":      claudeResp.ID,
-		"object":  "chat.completion",
-		"created": time.Now().Unix(),
-		"model":   claudeResp.Model,
-		"choices": []map[string]interface{}{
-			{
-				"index": 0,
-				"message": map[string]interface{}{
-					"role":    claudeResp.Role,
-					"content": content,
-				},
-				"finish_reason": finishReason,
+	openAIResp := &openai.ChatCompletionResponse{
+		ID:      claudeResp.ID,
+		Object:  "chat.completion",
+		Created: time.Now().Unix(),
+		Model:   claudeResp.Model,
+		Choices: []openai.Choice{{
+			Index: 0,
+			Message: openai.Message{
+				Role:      role,
+				Content:   openai.TextContent(content.String()),
+				ToolCalls: toolCalls,
 			},
-		},
+			FinishReason: claudeFinishReason(claudeResp.StopReason),
+		}},
 	}
 
 	// Add usage if available
 	if claudeResp.Usage != nil {
-		openAIResp["usage"] = map[string]interface{}{
-			"prompt_tokens":     claudeResp.Usage.InputTokens,
-			"completion_tokens": claudeResp.Usage.OutputTokens,
-			"total_tokens":      claudeResp.Usage.InputTokens + claudeResp.Usage.OutputTokens,
+		openAIResp.Usage = &openai.Usage{
+			PromptTokens:     claudeResp.Usage.InputTokens,
+			CompletionTokens: claudeResp.Usage.OutputTokens,
+			TotalTokens:      claudeResp.Usage.InputTokens + claudeResp.Usage.OutputTokens,
 		}
 	}
 
@@ -130,129 +176,79 @@ func (c *ClaudeConverter) ToOpenAIResponse(native interface{}, model string) (in
 }
 
 // ToOpenAIStreamChunk converts Claude format to OpenAI format
-func (c *ClaudeConverter) ToOpenAIStreamChunk(native interface{}, model string) (interface{}, error) {
-	// Parse the Claude stream event
+func (c *ClaudeConverter) ToOpenAIStreamChunk(native interface{}, model string) (*openai.ChatCompletionChunk, error) {
 	streamEvent, ok := native.(*kiro.StreamEvent)
 	if !ok {
-		// Try to parse from map if it's not already a StreamEvent
-		eventMap, ok := native.(map[string]interface{})
-		if !ok {
-			return nil, fmt.Errorf("invalid stream event type, expected *kiro.StreamEvent or map[string]interface{}")
-		}
-		
-		// Convert map to StreamEvent
 		streamEvent = &kiro.StreamEvent{}
-		if eventType, ok := eventMap["type"].(string); ok {
-			streamEvent.Type = eventType
-		}
-		if index, ok := eventMap["index"].(float64); ok {
-			streamEvent.Index = int(index)
-		}
-		
-		// Parse delta
-		if deltaRaw, ok := eventMap["delta"].(map[string]interface{}); ok {
-			delta := &kiro.Delta{}
-			if text, ok := deltaRaw["text"].(string); ok {
-				delta.Text = text
-			}
-			if stopReason, ok := deltaRaw["stop_reason"].(string); ok {
-				delta.StopReason = stopReason
-			}
-			streamEvent.Delta = delta
-		}
-		
-		// Parse content_block
-		if blockRaw, ok := eventMap["content_block"].(map[string]interface{}); ok {
-			block := &kiro.ContentBlock{}
-			if blockType, ok := blockRaw["type"].(string); ok {
-				block.Type = blockType
-			}
-			if text, ok := blockRaw["text"].(string); ok {
-				block.Text = text
-			}
-			streamEvent.ContentBlock = block
+		if err := remarshal(native, streamEvent); err != nil {
+			return nil, fmt.Errorf("invalid stream event type %T: %w", native, err)
 		}
 	}
 
 	// Create base OpenAI stream chunk
-	chunk := map[string]interface{}{
-		"id":      fmt.Sprintf("chatcmpl-%d", streamEvent.Index),
-		"object":  "chat.completion.chunk",
-		"created": time.Now().Unix(),
-		"model":   model,
-		"choices": []map[string]interface{}{
-			{
-				"index": streamEvent.Index,
-				"delta": map[string]interface{}{},
-			},
-		},
+	chunk := &openai.ChatCompletionChunk{
+		ID:      fmt.Sprintf("chatcmpl-%d", streamEvent.Index),
+		Object:  "chat.completion.chunk",
+		Created: time.Now().Unix(),
+		Model:   model,
+		Choices: []openai.ChunkChoice{{Index: 0}},
 	}
-
-	choice := chunk["choices"].([]map[string]interface{})[0]
+	choice := &chunk.Choices[0]
 
 	// Handle different event types
 	switch streamEvent.Type {
 	case "message_start":
 		// First chunk with role
-		choice["delta"] = map[string]interface{}{
-			"role": "assistant",
-		}
+		choice.Delta.Role = "assistant"
 	case "content_block_start":
-		// Content block started, no delta content yet
-		choice["delta"] = map[string]interface{}{}
+		// Tool use blocks announce the call; text blocks carry no content yet
+		if block := streamEvent.ContentBlock; block != nil && block.Type == "tool_use" {
+			index := streamEvent.Index
+			choice.Delta.ToolCalls = []openai.ToolCall{{
+				Index:    &index,
+				ID:       block.ID,
+				Type:     "function",
+				Function: openai.FunctionCall{Name: block.Name},
+			}}
+		}
 	case "content_block_delta":
-		// Content delta
-		if streamEvent.Delta != nil && streamEvent.Delta.Text != "" {
-			choice["delta"] = map[string]interface{}{
-				"content": streamEvent.Delta.Text,
+		if streamEvent.Delta != nil {
+			switch {
+			case streamEvent.Delta.Text != "":
+				choice.Delta.Content = streamEvent.Delta.Text
+			case streamEvent.Delta.Thinking != "":
+				choice.Delta.ReasoningContent = streamEvent.Delta.Thinking
+			case streamEvent.Delta.PartialJSON != "":
+				index := streamEvent.Index
+				choice.Delta.ToolCalls = []openai.ToolCall{{
+					Index:    &index,
+					Function: openai.FunctionCall{Arguments: streamEvent.Delta.PartialJSON},
+				}}
 			}
 		}
-	case "content_block_stop":
-		// Content block ended
-		choice["delta"] = map[string]interface{}{}
 	case "message_delta":
 		// Message delta with stop reason
 		if streamEvent.Delta != nil && streamEvent.Delta.StopReason != "" {
-			finishReason := "stop"
-			switch streamEvent.Delta.StopReason {
-			case "end_turn":
-				finishReason = "stop"
-			case "max_tokens":
-				finishReason = "length"
-			case "stop_sequence":
-				finishReason = "stop"
-			case "tool_use":
-				finishReason = "tool_calls"
+			choice.FinishReason = openai.FinishReason(claudeFinishReason(streamEvent.Delta.StopReason))
+		}
+		if streamEvent.Usage != nil {
+			chunk.Usage = &openai.Usage{
+				PromptTokens:     streamEvent.Usage.InputTokens,
+				CompletionTokens: streamEvent.Usage.OutputTokens,
+				TotalTokens:      streamEvent.Usage.InputTokens + streamEvent.Usage.OutputTokens,
 			}
-			choice["finish_reason"] = finishReason
 		}
-		choice["delta"] = map[string]interface{}{}
 	case "message_stop":
 		// Message ended
-		choice["finish_reason"] = "stop"
-		choice["delta"] = map[string]interface{}{}
-	default:
-		// Unknown event type, return empty delta
-		choice["delta"] = map[string]interface{}{}
+		choice.FinishReason = openai.FinishReason("stop")
 	}
 
 	return chunk, nil
 }
 
 // FromOpenAIRequest converts OpenAI format to Claude format
-func (c *ClaudeConverter) FromOpenAIRequest(req interface{}) (interface{}, error) {
-	// Convert the request map to Claude format
-	reqMap, ok := req.(map[string]interface{})
-	if !ok {
-		return nil, fmt.Errorf("invalid request type, expected map[string]interface{}")
-	}
-
-	// Extract model
-	model, _ := reqMap["model"].(string)
-
-	// Extract messages
-	messagesRaw, ok := reqMap["messages"].([]interface{})
-	if !ok {
+func (c *ClaudeConverter) FromOpenAIRequest(req *openai.ChatCompletionRequest) (interface{}, error) {
+	if req.Messages == nil {
 		return nil, fmt.Errorf("messages field is required and must be an array")
 	}
 
@@ -260,75 +256,150 @@ func (c *ClaudeConverter) FromOpenAIRequest(req interface{}) (interface{}, error
 	var messages []kiro.Message
 	var systemPrompt string
 
-	for _, msgRaw := range messagesRaw {
-		msgMap, ok := msgRaw.(map[string]interface{})
-		if !ok {
-			continue
-		}
-
-		role, _ := msgMap["role"].(string)
-		content := msgMap["content"]
-
-		// Handle system messages separately in Claude
-		if role == "system" {
-			if contentStr, ok := content.(string); ok {
-				systemPrompt = contentStr
+	for _, msg := range req.Messages {
+		switch msg.Role {
+		case "system":
+			// Handle system messages separately in Claude
+			systemPrompt = msg.Content.String()
+		case "tool":
+			// Tool results are sent back as user messages with tool_result blocks
+			messages = append(messages, kiro.Message{
+				Role: "user",
+				Content: []kiro.ContentBlock{{
+					Type:      "tool_result",
+					ToolUseID: msg.ToolCallID,
+					Content:   msg.Content.String(),
+				}},
+			})
+		default:
+			var content kiro.MessageContent = msg.Content.Text
+			if msg.Content.IsParts() || len(msg.ToolCalls) > 0 {
+				blocks := claudeBlocksFromContent(msg.Content)
+				for _, call := range msg.ToolCalls {
+					blocks = append(blocks, kiro.ContentBlock{
+						Type:  "tool_use",
+						ID:    call.ID,
+						Name:  call.Function.Name,
+						Input: toArgsMap(call.Function.Arguments),
+					})
+				}
+				content = blocks
 			}
-			continue
-		}
-
-		// Create message with content
-		msg := kiro.Message{
-			Role:    role,
-			Content: content,
+			messages = append(messages, kiro.Message{Role: msg.Role, Content: content})
 		}
-		messages = append(messages, msg)
 	}
 
-	// Extract max_tokens
 	maxTokens := 4096 // default
-	if mt, ok := reqMap["max_tokens"].(float64); ok {
-		maxTokens = int(mt)
-	} else if mt, ok := reqMap["max_tokens"].(int); ok {
-		maxTokens = mt
-	}
-
-	// Extract optional parameters
-	var temperature *float64
-	if temp, ok := reqMap["temperature"].(float64); ok {
-		temperature = &temp
-	}
-
-	var topP *float64
-	if tp, ok := reqMap["top_p"].(float64); ok {
-		topP = &tp
+	if req.MaxTokens != nil {
+		maxTokens = *req.MaxTokens
 	}
 
 	// Create Claude request
 	claudeReq := &kiro.ClaudeRequest{
-		Model:       model,
-		Messages:    messages,
-		MaxTokens:   maxTokens,
-		System:      systemPrompt,
-		Temperature: temperature,
-		TopP:        topP,
+		Model:         req.Model,
+		Messages:      messages,
+		MaxTokens:     maxTokens,
+		Temperature:   req.Temperature,
+		TopP:          req.TopP,
+		StopSequences: req.Stop,
+		Stream:        req.Stream,
+	}
+	if systemPrompt != "" {
+		claudeReq.System = systemPrompt
 	}
 
-	// Handle stream parameter
-	if stream, ok := reqMap["stream"].(bool); ok {
-		claudeReq.Stream = stream
+	for _, tool := range req.Tools {
+		claudeReq.Tools = append(claudeReq.Tools, kiro.Tool{
+			Name:        tool.Function.Name,
+			Description: tool.Function.Description,
+			InputSchema: tool.Function.Parameters,
+		})
 	}
 
 	return claudeReq, nil
 }
 
 // FromOpenAIResponse converts OpenAI format to Claude format
-func (c *ClaudeConverter) FromOpenAIResponse(resp interface{}) (interface{}, error) {
-	// For now, return as-is - we'll implement proper conversion later
-	return resp, nil
+func (c *ClaudeConverter) FromOpenAIResponse(resp *openai.ChatCompletionResponse) (interface{}, error) {
+	claudeResp := &kiro.ClaudeResponse{
+		ID:         resp.ID,
+		Type:       "message",
+		Role:       "assistant",
+		Model:      resp.Model,
+		Content:    []kiro.ContentBlock{},
+		StopReason: "end_turn",
+	}
+
+	if len(resp.Choices) > 0 {
+		choice := resp.Choices[0]
+		if text := choice.Message.Content.String(); text != "" {
+			claudeResp.Content = append(claudeResp.Content, kiro.ContentBlock{Type: "text", Text: text})
+		}
+		for _, call := range choice.Message.ToolCalls {
+			claudeResp.Content = append(claudeResp.Content, kiro.ContentBlock{
+				Type:  "tool_use",
+				ID:    call.ID,
+				Name:  call.Function.Name,
+				Input: toArgsMap(call.Function.Arguments),
+			})
+		}
+
+		claudeResp.StopReason = claudeStopReason(choice.FinishReason)
+	}
+
+	if resp.Usage != nil {
+		claudeResp.Usage = &kiro.Usage{
+			InputTokens:  resp.Usage.PromptTokens,
+			OutputTokens: resp.Usage.CompletionTokens,
+		}
+	}
+
+	return claudeResp, nil
 }
 
 // Protocol returns the native protocol
 func (c *ClaudeConverter) Protocol() provider.ProtocolType {
 	return provider.ProtocolClaude
 }
+
+// claudeFinishReason converts a Claude stop_reason to an OpenAI finish_reason
+func claudeFinishReason(stopReason string) string {
+	switch stopReason {
+	case "max_tokens":
+		return "length"
+	case "tool_use":
+		return "tool_calls"
+	case "refusal":
+		return "content_filter"
+	default:
+		return "stop"
+	}
+}
+
+// claudeBlocksFromContent converts OpenAI message content into Claude content blocks
+func claudeBlocksFromContent(content openai.MessageContent) []kiro.ContentBlock {
+	if !content.IsParts() {
+		if content.Text == "" {
+			return nil
+		}
+		return []kiro.ContentBlock{{Type: "text", Text: content.Text}}
+	}
+
+	blocks := make([]kiro.ContentBlock, 0, len(content.Parts))
+	for _, part := range content.Parts {
+		switch part.Type {
+		case "text":
+			blocks = append(blocks, kiro.ContentBlock{Type: "text", Text: part.Text})
+		case "image_url":
+			if part.ImageURL == nil {
+				continue
+			}
+			if mimeType, data, ok := parseDataURI(part.ImageURL.URL); ok {
+				blocks = append(blocks, kiro.ContentBlock{Type: "image", Source: &kiro.Source{Type: "base64", MediaType: mimeType, Data: data}})
+			} else {
+				blocks = append(blocks, kiro.ContentBlock{Type: "image", Source: &kiro.Source{Type: "url", URL: part.ImageURL.URL}})
+			}
+		}
+	}
+	return blocks
+}
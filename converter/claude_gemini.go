@@ -0,0 +1,660 @@
+// Package converter provides format conversion between different LLM API formats
+package converter
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sunbankio/qwencoder-proxy/provider"
+	"github.com/sunbankio/qwencoder-proxy/provider/gemini"
+	"github.com/sunbankio/qwencoder-proxy/provider/kiro"
+)
+
+// ClaudeToGeminiTranslator serves Claude (Anthropic Messages) clients from Gemini-protocol providers.
+// Thinking blocks keep their signatures and tool calls keep their IDs, which the OpenAI hub would drop.
+type ClaudeToGeminiTranslator struct{}
+
+// NewClaudeToGeminiTranslator creates a new Claude -> Gemini translator
+func NewClaudeToGeminiTranslator() *ClaudeToGeminiTranslator {
+	return &ClaudeToGeminiTranslator{}
+}
+
+// Source returns the client protocol
+func (t *ClaudeToGeminiTranslator) Source() provider.ProtocolType {
+	return provider.ProtocolClaude
+}
+
+// Target returns the upstream protocol
+func (t *ClaudeToGeminiTranslator) Target() provider.ProtocolType {
+	return provider.ProtocolGemini
+}
+
+// TranslateRequest converts a Claude request to a Gemini request
+func (t *ClaudeToGeminiTranslator) TranslateRequest(req interface{}) (interface{}, error) {
+	claudeReq, err := asClaudeRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	return claudeRequestToGemini(claudeReq)
+}
+
+// TranslateResponse converts a Gemini response to a Claude response
+func (t *ClaudeToGeminiTranslator) TranslateResponse(resp interface{}, model string) (interface{}, error) {
+	geminiResp, err := asGeminiResponse(resp)
+	if err != nil {
+		return nil, err
+	}
+	return geminiResponseToClaude(geminiResp, model), nil
+}
+
+// GeminiToClaudeTranslator serves Gemini clients from Claude-protocol providers
+type GeminiToClaudeTranslator struct{}
+
+// NewGeminiToClaudeTranslator creates a new Gemini -> Claude translator
+func NewGeminiToClaudeTranslator() *GeminiToClaudeTranslator {
+	return &GeminiToClaudeTranslator{}
+}
+
+// Source returns the client protocol
+func (t *GeminiToClaudeTranslator) Source() provider.ProtocolType {
+	return provider.ProtocolGemini
+}
+
+// Target returns the upstream protocol
+func (t *GeminiToClaudeTranslator) Target() provider.ProtocolType {
+	return provider.ProtocolClaude
+}
+
+// TranslateRequest converts a Gemini request to a Claude request
+func (t *GeminiToClaudeTranslator) TranslateRequest(req interface{}) (interface{}, error) {
+	geminiReq, err := asGeminiRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	return geminiRequestToClaude(geminiReq), nil
+}
+
+// TranslateResponse converts a Claude response to a Gemini response
+func (t *GeminiToClaudeTranslator) TranslateResponse(resp interface{}, model string) (interface{}, error) {
+	claudeResp, err := asClaudeResponse(resp)
+	if err != nil {
+		return nil, err
+	}
+	return claudeResponseToGemini(claudeResp), nil
+}
+
+// claudeRequestToGemini maps a Claude request onto the Gemini generateContent schema
+func claudeRequestToGemini(req *kiro.ClaudeRequest) (*gemini.GeminiRequest, error) {
+	geminiReq := &gemini.GeminiRequest{
+		Contents: []gemini.Content{},
+	}
+
+	if req.System != nil {
+		blocks, err := claudeContentBlocks(req.System)
+		if err != nil {
+			return nil, fmt.Errorf("invalid system prompt: %w", err)
+		}
+		var parts []gemini.Part
+		for _, block := range blocks {
+			if block.Type == "text" && block.Text != "" {
+				parts = append(parts, gemini.Part{Text: block.Text})
+			}
+		}
+		if len(parts) > 0 {
+			geminiReq.SystemInstruction = &gemini.Content{Role: "user", Parts: parts}
+		}
+	}
+
+	// Gemini function responses are matched by name, Claude tool results by ID
+	toolNames := make(map[string]string)
+
+	for _, msg := range req.Messages {
+		blocks, err := claudeContentBlocks(msg.Content)
+		if err != nil {
+			return nil, fmt.Errorf("invalid message content: %w", err)
+		}
+
+		role := "user"
+		if msg.Role == "assistant" {
+			role = "model"
+		}
+
+		var parts []gemini.Part
+		for _, block := range blocks {
+			switch block.Type {
+			case "text":
+				if block.Text != "" {
+					parts = append(parts, gemini.Part{Text: block.Text})
+				}
+			case "thinking":
+				parts = append(parts, gemini.Part{
+					Text:             block.Thinking,
+					Thought:          true,
+					ThoughtSignature: block.Signature,
+				})
+			case "image":
+				if block.Source == nil {
+					continue
+				}
+				if block.Source.Type == "url" {
+					parts = append(parts, gemini.Part{FileData: &gemini.FileData{
+						MimeType: block.Source.MediaType,
+						FileURI:  block.Source.URL,
+					}})
+				} else {
+					parts = append(parts, gemini.Part{InlineData: &gemini.InlineData{
+						MimeType: block.Source.MediaType,
+						Data:     block.Source.Data,
+					}})
+				}
+			case "tool_use":
+				toolNames[block.ID] = block.Name
+				parts = append(parts, gemini.Part{FunctionCall: &gemini.FunctionCall{
+					ID:   block.ID,
+					Name: block.Name,
+					Args: toArgsMap(block.Input),
+				}})
+			case "tool_result":
+				response := map[string]interface{}{"content": claudeToolResultText(block.Content)}
+				if block.IsError {
+					response = map[string]interface{}{"error": claudeToolResultText(block.Content)}
+				}
+				parts = append(parts, gemini.Part{FunctionResponse: &gemini.FunctionResponse{
+					ID:       block.ToolUseID,
+					Name:     toolNames[block.ToolUseID],
+					Response: response,
+				}})
+			}
+		}
+
+		if len(parts) == 0 {
+			continue
+		}
+		geminiReq.Contents = append(geminiReq.Contents, gemini.Content{Role: role, Parts: parts})
+	}
+
+	genConfig := &gemini.GenerationConfig{
+		Temperature:   req.Temperature,
+		TopP:          req.TopP,
+		TopK:          req.TopK,
+		StopSequences: req.StopSequences,
+	}
+	if req.MaxTokens > 0 {
+		maxTokens := req.MaxTokens
+		genConfig.MaxOutputTokens = &maxTokens
+	}
+	if req.Thinking != nil && req.Thinking.Type == "enabled" {
+		budget := req.Thinking.BudgetTokens
+		genConfig.ThinkingConfig = &gemini.ThinkingConfig{
+			IncludeThoughts: true,
+			ThinkingBudget:  &budget,
+		}
+	}
+	geminiReq.GenerationConfig = genConfig
+
+	if len(req.Tools) > 0 {
+		decls := make([]gemini.FunctionDeclaration, 0, len(req.Tools))
+		for _, tool := range req.Tools {
+			params := make(map[string]interface{}, len(tool.InputSchema))
+			for k, v := range tool.InputSchema {
+				if k == "$schema" {
+					continue
+				}
+				params[k] = v
+			}
+			decls = append(decls, gemini.FunctionDeclaration{
+				Name:        tool.Name,
+				Description: tool.Description,
+				Parameters:  params,
+			})
+		}
+		geminiReq.Tools = []gemini.Tool{{FunctionDeclarations: decls}}
+	}
+
+	if req.ToolChoice != nil {
+		callingConfig := &gemini.FunctionCallingConfig{}
+		switch req.ToolChoice.Type {
+		case "any":
+			callingConfig.Mode = "ANY"
+		case "tool":
+			callingConfig.Mode = "ANY"
+			callingConfig.AllowedFunctionNames = []string{req.ToolChoice.Name}
+		case "none":
+			callingConfig.Mode = "NONE"
+		default:
+			callingConfig.Mode = "AUTO"
+		}
+		geminiReq.ToolConfig = &gemini.ToolConfig{FunctionCallingConfig: callingConfig}
+	}
+
+	return geminiReq, nil
+}
+
+// geminiResponseToClaude maps the first Gemini candidate onto a Claude message
+func geminiResponseToClaude(resp *gemini.GeminiResponse, model string) *kiro.ClaudeResponse {
+	claudeResp := &kiro.ClaudeResponse{
+		ID:         fmt.Sprintf("msg_%d", time.Now().UnixNano()),
+		Type:       "message",
+		Role:       "assistant",
+		Model:      model,
+		Content:    []kiro.ContentBlock{},
+		StopReason: "end_turn",
+	}
+
+	if len(resp.Candidates) > 0 {
+		candidate := resp.Candidates[0]
+		var fullText string
+		hasToolUse := false
+
+		if candidate.Content != nil {
+			for i, part := range candidate.Content.Parts {
+				switch {
+				case part.Thought:
+					claudeResp.Content = append(claudeResp.Content, kiro.ContentBlock{
+						Type:      "thinking",
+						Thinking:  part.Text,
+						Signature: part.ThoughtSignature,
+					})
+				case part.FunctionCall != nil:
+					id := part.FunctionCall.ID
+					if id == "" {
+						id = fmt.Sprintf("toolu_%s_%d", part.FunctionCall.Name, i)
+					}
+					args := part.FunctionCall.Args
+					if args == nil {
+						args = map[string]interface{}{}
+					}
+					claudeResp.Content = append(claudeResp.Content, kiro.ContentBlock{
+						Type:  "tool_use",
+						ID:    id,
+						Name:  part.FunctionCall.Name,
+						Input: args,
+					})
+					hasToolUse = true
+				case part.Text != "":
+					fullText += part.Text
+					// Merge adjacent text parts into a single block
+					if n := len(claudeResp.Content); n > 0 && claudeResp.Content[n-1].Type == "text" {
+						claudeResp.Content[n-1].Text += part.Text
+					} else {
+						claudeResp.Content = append(claudeResp.Content, kiro.ContentBlock{Type: "text", Text: part.Text})
+					}
+				}
+			}
+		}
+
+		if candidate.CitationMetadata != nil {
+			citations := make([]kiro.Citation, 0, len(candidate.CitationMetadata.CitationSources))
+			for _, source := range candidate.CitationMetadata.CitationSources {
+				citation := kiro.Citation{
+					Type:      "web_search_result_location",
+					URL:       source.URI,
+					Title:     source.Title,
+					StartChar: source.StartIndex,
+					EndChar:   source.EndIndex,
+				}
+				if source.StartIndex >= 0 && source.EndIndex > source.StartIndex && source.EndIndex <= len(fullText) {
+					citation.CitedText = fullText[source.StartIndex:source.EndIndex]
+				}
+				citations = append(citations, citation)
+			}
+			for i := len(claudeResp.Content) - 1; i >= 0; i-- {
+				if claudeResp.Content[i].Type == "text" {
+					claudeResp.Content[i].Citations = citations
+					break
+				}
+			}
+		}
+
+		switch candidate.FinishReason {
+		case "MAX_TOKENS":
+			claudeResp.StopReason = "max_tokens"
+		case "SAFETY", "RECITATION", "PROHIBITED_CONTENT", "BLOCKLIST", "SPII":
+			claudeResp.StopReason = "refusal"
+		}
+		if hasToolUse {
+			claudeResp.StopReason = "tool_use"
+		}
+	}
+
+	if resp.UsageMetadata != nil {
+		claudeResp.Usage = &kiro.Usage{
+			InputTokens:          resp.UsageMetadata.PromptTokenCount - resp.UsageMetadata.CachedContentTokenCount,
+			OutputTokens:         resp.UsageMetadata.CandidatesTokenCount + resp.UsageMetadata.ThoughtsTokenCount,
+			CacheReadInputTokens: resp.UsageMetadata.CachedContentTokenCount,
+		}
+	}
+
+	return claudeResp
+}
+
+// geminiRequestToClaude maps a Gemini generateContent request onto the Claude Messages schema
+func geminiRequestToClaude(req *gemini.GeminiRequest) *kiro.ClaudeRequest {
+	claudeReq := &kiro.ClaudeRequest{
+		MaxTokens: 4096,
+	}
+
+	if req.SystemInstruction != nil {
+		var texts []string
+		for _, part := range req.SystemInstruction.Parts {
+			if part.Text != "" {
+				texts = append(texts, part.Text)
+			}
+		}
+		if len(texts) > 0 {
+			claudeReq.System = strings.Join(texts, "\n")
+		}
+	}
+
+	// Gemini may omit call IDs; pair calls and responses by name in order
+	pendingIDs := make(map[string][]string)
+	callCount := 0
+
+	for _, content := range req.Contents {
+		role := "user"
+		if content.Role == "model" {
+			role = "assistant"
+		}
+
+		var blocks []kiro.ContentBlock
+		for _, part := range content.Parts {
+			switch {
+			case part.Thought:
+				blocks = append(blocks, kiro.ContentBlock{
+					Type:      "thinking",
+					Thinking:  part.Text,
+					Signature: part.ThoughtSignature,
+				})
+			case part.FunctionCall != nil:
+				id := part.FunctionCall.ID
+				if id == "" {
+					id = fmt.Sprintf("toolu_%s_%d", part.FunctionCall.Name, callCount)
+				}
+				callCount++
+				pendingIDs[part.FunctionCall.Name] = append(pendingIDs[part.FunctionCall.Name], id)
+				args := part.FunctionCall.Args
+				if args == nil {
+					args = map[string]interface{}{}
+				}
+				blocks = append(blocks, kiro.ContentBlock{
+					Type:  "tool_use",
+					ID:    id,
+					Name:  part.FunctionCall.Name,
+					Input: args,
+				})
+			case part.FunctionResponse != nil:
+				id := part.FunctionResponse.ID
+				if queue := pendingIDs[part.FunctionResponse.Name]; len(queue) > 0 {
+					if id == "" {
+						id = queue[0]
+					}
+					pendingIDs[part.FunctionResponse.Name] = queue[1:]
+				}
+				resultJSON, _ := json.Marshal(part.FunctionResponse.Response)
+				_, isError := part.FunctionResponse.Response["error"]
+				blocks = append(blocks, kiro.ContentBlock{
+					Type:      "tool_result",
+					ToolUseID: id,
+					Content:   string(resultJSON),
+					IsError:   isError,
+				})
+			case part.InlineData != nil:
+				blocks = append(blocks, kiro.ContentBlock{
+					Type: "image",
+					Source: &kiro.Source{
+						Type:      "base64",
+						MediaType: part.InlineData.MimeType,
+						Data:      part.InlineData.Data,
+					},
+				})
+			case part.FileData != nil:
+				blocks = append(blocks, kiro.ContentBlock{
+					Type:   "image",
+					Source: &kiro.Source{Type: "url", URL: part.FileData.FileURI},
+				})
+			case part.Text != "":
+				blocks = append(blocks, kiro.ContentBlock{Type: "text", Text: part.Text})
+			}
+		}
+
+		if len(blocks) == 0 {
+			continue
+		}
+		claudeReq.Messages = append(claudeReq.Messages, kiro.Message{Role: role, Content: blocks})
+	}
+
+	if cfg := req.GenerationConfig; cfg != nil {
+		if cfg.MaxOutputTokens != nil {
+			claudeReq.MaxTokens = *cfg.MaxOutputTokens
+		}
+		claudeReq.Temperature = cfg.Temperature
+		claudeReq.TopP = cfg.TopP
+		claudeReq.TopK = cfg.TopK
+		claudeReq.StopSequences = cfg.StopSequences
+		if cfg.ThinkingConfig != nil && cfg.ThinkingConfig.ThinkingBudget != nil && *cfg.ThinkingConfig.ThinkingBudget > 0 {
+			claudeReq.Thinking = &kiro.Thinking{
+				Type:         "enabled",
+				BudgetTokens: *cfg.ThinkingConfig.ThinkingBudget,
+			}
+		}
+	}
+
+	for _, tool := range req.Tools {
+		for _, decl := range tool.FunctionDeclarations {
+			schema := decl.Parameters
+			if schema == nil {
+				schema = map[string]interface{}{"type": "object", "properties": map[string]interface{}{}}
+			}
+			claudeReq.Tools = append(claudeReq.Tools, kiro.Tool{
+				Name:        decl.Name,
+				Description: decl.Description,
+				InputSchema: schema,
+			})
+		}
+	}
+
+	if req.ToolConfig != nil && req.ToolConfig.FunctionCallingConfig != nil {
+		cfg := req.ToolConfig.FunctionCallingConfig
+		switch cfg.Mode {
+		case "ANY":
+			if len(cfg.AllowedFunctionNames) == 1 {
+				claudeReq.ToolChoice = &kiro.ToolChoice{Type: "tool", Name: cfg.AllowedFunctionNames[0]}
+			} else {
+				claudeReq.ToolChoice = &kiro.ToolChoice{Type: "any"}
+			}
+		case "NONE":
+			claudeReq.ToolChoice = &kiro.ToolChoice{Type: "none"}
+		case "AUTO":
+			claudeReq.ToolChoice = &kiro.ToolChoice{Type: "auto"}
+		}
+	}
+
+	return claudeReq
+}
+
+// claudeResponseToGemini maps a Claude message onto a single Gemini candidate
+func claudeResponseToGemini(resp *kiro.ClaudeResponse) *gemini.GeminiResponse {
+	content := &gemini.Content{Role: "model", Parts: []gemini.Part{}}
+	var sources []gemini.CitationSource
+	var fullText string
+
+	for _, block := range resp.Content {
+		switch block.Type {
+		case "text":
+			for _, citation := range block.Citations {
+				source := gemini.CitationSource{URI: citation.URL, Title: citation.Title}
+				if idx := strings.Index(block.Text, citation.CitedText); citation.CitedText != "" && idx >= 0 {
+					source.StartIndex = len(fullText) + idx
+					source.EndIndex = source.StartIndex + len(citation.CitedText)
+				}
+				sources = append(sources, source)
+			}
+			fullText += block.Text
+			content.Parts = append(content.Parts, gemini.Part{Text: block.Text})
+		case "thinking":
+			content.Parts = append(content.Parts, gemini.Part{
+				Text:             block.Thinking,
+				Thought:          true,
+				ThoughtSignature: block.Signature,
+			})
+		case "tool_use":
+			content.Parts = append(content.Parts, gemini.Part{FunctionCall: &gemini.FunctionCall{
+				ID:   block.ID,
+				Name: block.Name,
+				Args: toArgsMap(block.Input),
+			}})
+		}
+	}
+
+	finishReason := "STOP"
+	switch resp.StopReason {
+	case "max_tokens":
+		finishReason = "MAX_TOKENS"
+	case "refusal":
+		finishReason = "SAFETY"
+	}
+
+	candidate := gemini.Candidate{
+		Content:      content,
+		FinishReason: finishReason,
+	}
+	if len(sources) > 0 {
+		candidate.CitationMetadata = &gemini.CitationMetadata{CitationSources: sources}
+	}
+
+	geminiResp := &gemini.GeminiResponse{Candidates: []gemini.Candidate{candidate}}
+	if resp.Usage != nil {
+		prompt := resp.Usage.InputTokens + resp.Usage.CacheReadInputTokens + resp.Usage.CacheCreationInputTokens
+		geminiResp.UsageMetadata = &gemini.UsageMetadata{
+			PromptTokenCount:        prompt,
+			CandidatesTokenCount:    resp.Usage.OutputTokens,
+			TotalTokenCount:         prompt + resp.Usage.OutputTokens,
+			CachedContentTokenCount: resp.Usage.CacheReadInputTokens,
+		}
+	}
+	return geminiResp
+}
+
+// claudeContentBlocks normalizes Claude message content (string, typed or decoded blocks) into content blocks
+func claudeContentBlocks(content interface{}) ([]kiro.ContentBlock, error) {
+	switch v := content.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return []kiro.ContentBlock{{Type: "text", Text: v}}, nil
+	case []kiro.ContentBlock:
+		return v, nil
+	default:
+		var blocks []kiro.ContentBlock
+		if err := remarshal(v, &blocks); err != nil {
+			return nil, err
+		}
+		return blocks, nil
+	}
+}
+
+// claudeToolResultText flattens tool_result content (string or text blocks) into a string
+func claudeToolResultText(content interface{}) string {
+	if s, ok := content.(string); ok {
+		return s
+	}
+	blocks, err := claudeContentBlocks(content)
+	if err != nil {
+		return fmt.Sprintf("%v", content)
+	}
+	var texts []string
+	for _, block := range blocks {
+		if block.Type == "text" {
+			texts = append(texts, block.Text)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+// toArgsMap converts tool input of any shape into a JSON object
+func toArgsMap(input interface{}) map[string]interface{} {
+	switch v := input.(type) {
+	case nil:
+		return map[string]interface{}{}
+	case map[string]interface{}:
+		return v
+	case string:
+		var args map[string]interface{}
+		if err := json.Unmarshal([]byte(v), &args); err == nil {
+			return args
+		}
+		return map[string]interface{}{"input": v}
+	default:
+		var args map[string]interface{}
+		if err := remarshal(v, &args); err != nil {
+			return map[string]interface{}{}
+		}
+		return args
+	}
+}
+
+// asClaudeRequest accepts a *kiro.ClaudeRequest or any JSON-compatible value
+func asClaudeRequest(req interface{}) (*kiro.ClaudeRequest, error) {
+	if r, ok := req.(*kiro.ClaudeRequest); ok {
+		return r, nil
+	}
+	r := &kiro.ClaudeRequest{}
+	if err := remarshal(req, r); err != nil {
+		return nil, fmt.Errorf("unexpected request type %T: %w", req, err)
+	}
+	return r, nil
+}
+
+// asClaudeResponse accepts a *kiro.ClaudeResponse or any JSON-compatible value
+func asClaudeResponse(resp interface{}) (*kiro.ClaudeResponse, error) {
+	if r, ok := resp.(*kiro.ClaudeResponse); ok {
+		return r, nil
+	}
+	r := &kiro.ClaudeResponse{}
+	if err := remarshal(resp, r); err != nil {
+		return nil, fmt.Errorf("unexpected response type %T: %w", resp, err)
+	}
+	return r, nil
+}
+
+// asGeminiRequest accepts a *gemini.GeminiRequest or any JSON-compatible value
+func asGeminiRequest(req interface{}) (*gemini.GeminiRequest, error) {
+	if r, ok := req.(*gemini.GeminiRequest); ok {
+		return r, nil
+	}
+	r := &gemini.GeminiRequest{}
+	if err := remarshal(req, r); err != nil {
+		return nil, fmt.Errorf("unexpected request type %T: %w", req, err)
+	}
+	return r, nil
+}
+
+// asGeminiResponse accepts a *gemini.GeminiResponse or any JSON-compatible value
+func asGeminiResponse(resp interface{}) (*gemini.GeminiResponse, error) {
+	if r, ok := resp.(*gemini.GeminiResponse); ok {
+		return r, nil
+	}
+	r := &gemini.GeminiResponse{}
+	if err := remarshal(resp, r); err != nil {
+		return nil, fmt.Errorf("unexpected response type %T: %w", resp, err)
+	}
+	return r, nil
+}
+
+// remarshal converts between JSON-compatible representations.
+// Raw JSON input is decoded directly without an intermediate encode.
+func remarshal(in interface{}, out interface{}) error {
+	switch v := in.(type) {
+	case json.RawMessage:
+		return json.Unmarshal(v, out)
+	case []byte:
+		return json.Unmarshal(v, out)
+	}
+	data, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
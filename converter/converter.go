@@ -2,19 +2,21 @@
 package converter
 
 import (
+	"github.com/sunbankio/qwencoder-proxy/openai"
 	"github.com/sunbankio/qwencoder-proxy/provider"
 )
 
-// Converter handles protocol translation between different API formats
+// Converter handles protocol translation between different API formats.
+// Native values may be typed structs, decoded maps or raw JSON (json.RawMessage).
 type Converter interface {
 	// ToOpenAI converts native format to OpenAI format
-	ToOpenAIRequest(native interface{}) (interface{}, error)
-	ToOpenAIResponse(native interface{}, model string) (interface{}, error)
-	ToOpenAIStreamChunk(native interface{}, model string) (interface{}, error)
+	ToOpenAIRequest(native interface{}) (*openai.ChatCompletionRequest, error)
+	ToOpenAIResponse(native interface{}, model string) (*openai.ChatCompletionResponse, error)
+	ToOpenAIStreamChunk(native interface{}, model string) (*openai.ChatCompletionChunk, error)
 
 	// FromOpenAI converts OpenAI format to native format
-	FromOpenAIRequest(req interface{}) (interface{}, error)
-	FromOpenAIResponse(resp interface{}) (interface{}, error)
+	FromOpenAIRequest(req *openai.ChatCompletionRequest) (interface{}, error)
+	FromOpenAIResponse(resp *openai.ChatCompletionResponse) (interface{}, error)
 
 	// Protocol returns the native protocol this converter handles
 	Protocol() provider.ProtocolType
@@ -22,19 +24,25 @@ type Converter interface {
 
 // Factory manages converter instances
 type Factory struct {
-	converters map[provider.ProtocolType]Converter
+	converters  map[provider.ProtocolType]Converter
+	translators map[protocolPair]Translator
 }
 
 // NewFactory creates a new converter factory
 func NewFactory() *Factory {
 	f := &Factory{
-		converters: make(map[provider.ProtocolType]Converter),
+		converters:  make(map[provider.ProtocolType]Converter),
+		translators: make(map[protocolPair]Translator),
 	}
 	// Register default converters
 	f.Register(NewOpenAIConverter())
 	f.Register(NewGeminiConverter())
 	f.Register(NewClaudeConverter())
 	f.Register(NewQwenConverter())
+
+	// Register direct translators that avoid the lossy OpenAI hub
+	f.RegisterTranslator(NewClaudeToGeminiTranslator())
+	f.RegisterTranslator(NewGeminiToClaudeTranslator())
 	return f
 }
 
@@ -4,8 +4,10 @@ package converter
 import (
 	"encoding/json"
 	"fmt"
+	"strings"
 	"time"
 
+	"github.com/sunbankio/qwencoder-proxy/openai"
 	"github.com/sunbankio/qwencoder-proxy/provider"
 	"github.com/sunbankio/qwencoder-proxy/provider/gemini"
 )
@@ -19,219 +21,328 @@ func NewGeminiConverter() *GeminiConverter {
 }
 
 // ToOpenAIRequest converts Gemini format to OpenAI format
-func (c *GeminiConverter) ToOpenAIRequest(native interface{}) (interface{}, error) {
-	// For now, return as-is - we'll implement proper conversion later
-	return native, nil
+func (c *GeminiConverter) ToOpenAIRequest(native interface{}) (*openai.ChatCompletionRequest, error) {
+	geminiReq, err := asGeminiRequest(native)
+	if err != nil {
+		return nil, err
+	}
+
+	openAIReq := &openai.ChatCompletionRequest{Messages: []openai.Message{}}
+	if geminiReq.SystemInstruction != nil {
+		var system string
+		for _, part := range geminiReq.SystemInstruction.Parts {
+			system += part.Text
+		}
+		if system != "" {
+			openAIReq.Messages = append(openAIReq.Messages, openai.Message{Role: "system", Content: openai.TextContent(system)})
+		}
+	}
+
+	for _, content := range geminiReq.Contents {
+		role := "user"
+		if content.Role == "model" {
+			role = "assistant"
+		}
+
+		var parts []openai.ContentPart
+		var toolCalls []openai.ToolCall
+		for _, part := range content.Parts {
+			switch {
+			case part.Thought:
+				continue
+			case part.FunctionCall != nil:
+				toolCalls = append(toolCalls, openAIToolCall(part.FunctionCall.ID, part.FunctionCall.Name, part.FunctionCall.Args))
+			case part.FunctionResponse != nil:
+				result, _ := json.Marshal(part.FunctionResponse.Response)
+				toolCallID := part.FunctionResponse.ID
+				if toolCallID == "" {
+					toolCallID = part.FunctionResponse.Name
+				}
+				openAIReq.Messages = append(openAIReq.Messages, openai.Message{
+					Role:       "tool",
+					ToolCallID: toolCallID,
+					Content:    openai.TextContent(string(result)),
+				})
+			case part.InlineData != nil:
+				parts = append(parts, openai.ContentPart{
+					Type:     "image_url",
+					ImageURL: &openai.ImageURL{URL: fmt.Sprintf("data:%s;base64,%s", part.InlineData.MimeType, part.InlineData.Data)},
+				})
+			case part.FileData != nil:
+				parts = append(parts, openai.ContentPart{
+					Type:     "image_url",
+					ImageURL: &openai.ImageURL{URL: part.FileData.FileURI},
+				})
+			case part.Text != "":
+				parts = append(parts, openai.ContentPart{Type: "text", Text: part.Text})
+			}
+		}
+
+		if len(parts) == 0 && len(toolCalls) == 0 {
+			continue
+		}
+
+		openAIMsg := openai.Message{Role: role, ToolCalls: toolCalls}
+		if len(parts) > 0 {
+			openAIMsg.Content = openai.PartsContent(parts...)
+		}
+		openAIReq.Messages = append(openAIReq.Messages, openAIMsg)
+	}
+
+	if cfg := geminiReq.GenerationConfig; cfg != nil {
+		openAIReq.Temperature = cfg.Temperature
+		openAIReq.TopP = cfg.TopP
+		openAIReq.MaxTokens = cfg.MaxOutputTokens
+		openAIReq.Stop = cfg.StopSequences
+	}
+
+	for _, tool := range geminiReq.Tools {
+		for _, decl := range tool.FunctionDeclarations {
+			openAIReq.Tools = append(openAIReq.Tools, openai.Tool{
+				Type: "function",
+				Function: openai.FunctionDefinition{
+					Name:        decl.Name,
+					Description: decl.Description,
+					Parameters:  decl.Parameters,
+				},
+			})
+		}
+	}
+
+	return openAIReq, nil
 }
 
 // ToOpenAIResponse converts Gemini format to OpenAI format
-func (c *GeminiConverter) ToOpenAIResponse(native interface{}, model string) (interface{}, error) {
-	// Convert Gemini response to OpenAI format
-	geminiResp, ok := native.(*gemini.GeminiResponse)
-	if !ok {
-		// Try to convert from map if it's not already a GeminiResponse
-		if data, ok := native.(map[string]interface{}); ok {
-			jsonBytes, _ := json.Marshal(data)
-			geminiResp = &gemini.GeminiResponse{}
-			json.Unmarshal(jsonBytes, geminiResp)
-		} else {
-			return nil, fmt.Errorf("unexpected response type: %T", native)
-		}
+func (c *GeminiConverter) ToOpenAIResponse(native interface{}, model string) (*openai.ChatCompletionResponse, error) {
+	geminiResp, err := asGeminiResponse(native)
+	if err != nil {
+		return nil, err
 	}
 
 	// Create OpenAI-compatible response
-	openAIResp := map[string]interface{}{
-		"id":      "chatcmpl-" + generateID(),
-		"object":  "chat.completion",
-		"created": getCurrentTimestamp(),
-		"model":   model,
-		"choices": []interface{}{},
-		"usage": map[string]interface{}{
-			"prompt_tokens":     0,
-			"completion_tokens": 0,
-			"total_tokens":      0,
-		},
+	openAIResp := &openai.ChatCompletionResponse{
+		ID:      generateID(),
+		Object:  "chat.completion",
+		Created: getCurrentTimestamp(),
+		Model:   model,
+		Choices: []openai.Choice{},
+		Usage:   &openai.Usage{},
 	}
 
 	if geminiResp.UsageMetadata != nil {
-		openAIResp["usage"] = map[string]interface{}{
-			"prompt_tokens":     geminiResp.UsageMetadata.PromptTokenCount,
-			"completion_tokens": geminiResp.UsageMetadata.CandidatesTokenCount,
-			"total_tokens":      geminiResp.UsageMetadata.TotalTokenCount,
+		openAIResp.Usage = &openai.Usage{
+			PromptTokens:     geminiResp.UsageMetadata.PromptTokenCount,
+			CompletionTokens: geminiResp.UsageMetadata.CandidatesTokenCount,
+			TotalTokens:      geminiResp.UsageMetadata.TotalTokenCount,
 		}
 	}
 
-	choices := []interface{}{}
 	for i, candidate := range geminiResp.Candidates {
-		if candidate.Content != nil {
-			var content string
-			for _, part := range candidate.Content.Parts {
-				if part.Text != "" {
-					content += part.Text
-				}
-			}
+		if candidate.Content == nil {
+			continue
+		}
 
-			choice := map[string]interface{}{
-				"index": i,
-				"message": map[string]interface{}{
-					"role":    "assistant",
-					"content": content,
-				},
-				"finish_reason": convertFinishReason(candidate.FinishReason),
+		var content strings.Builder
+		var toolCalls []openai.ToolCall
+		for _, part := range candidate.Content.Parts {
+			if part.Thought {
+				continue
 			}
-			choices = append(choices, choice)
+			if part.FunctionCall != nil {
+				toolCalls = append(toolCalls, openAIToolCall(part.FunctionCall.ID, part.FunctionCall.Name, part.FunctionCall.Args))
+				continue
+			}
+			content.WriteString(part.Text)
 		}
-	}
 
-	openAIResp["choices"] = choices
+		finishReason := convertFinishReason(candidate.FinishReason)
+		if len(toolCalls) > 0 {
+			finishReason = "tool_calls"
+		}
+
+		openAIResp.Choices = append(openAIResp.Choices, openai.Choice{
+			Index: i,
+			Message: openai.Message{
+				Role:      "assistant",
+				Content:   openai.TextContent(content.String()),
+				ToolCalls: toolCalls,
+			},
+			FinishReason: finishReason,
+		})
+	}
 
 	return openAIResp, nil
 }
 
 // ToOpenAIStreamChunk converts Gemini format to OpenAI format for streaming
-func (c *GeminiConverter) ToOpenAIStreamChunk(native interface{}, model string) (interface{}, error) {
-	geminiResp, ok := native.(*gemini.GeminiResponse)
-	if !ok {
-		// Try to convert from map
-		if data, ok := native.(map[string]interface{}); ok {
-			jsonBytes, _ := json.Marshal(data)
-			geminiResp = &gemini.GeminiResponse{}
-			json.Unmarshal(jsonBytes, geminiResp)
-		} else {
-			return nil, fmt.Errorf("unexpected response type: %T", native)
-		}
+func (c *GeminiConverter) ToOpenAIStreamChunk(native interface{}, model string) (*openai.ChatCompletionChunk, error) {
+	geminiResp, err := asGeminiResponse(native)
+	if err != nil {
+		return nil, err
 	}
 
-	openAIChunk := map[string]interface{}{
-		"id":      "chatcmpl-stream", // ID should be consistent, but stateless converter can't guarantee. Fixed placeholder or caller overrides.
-		"object":  "chat.completion.chunk",
-		"created": getCurrentTimestamp(),
-		"model":   model,
-		"choices": []interface{}{},
+	openAIChunk := &openai.ChatCompletionChunk{
+		ID:      "chatcmpl-stream", // ID should be consistent, but stateless converter can't guarantee. Fixed placeholder or caller overrides.
+		Object:  "chat.completion.chunk",
+		Created: getCurrentTimestamp(),
+		Model:   model,
+		Choices: make([]openai.ChunkChoice, 0, len(geminiResp.Candidates)),
 	}
 
-	choices := []interface{}{}
 	for i, candidate := range geminiResp.Candidates {
-		choice := map[string]interface{}{
-			"index":         i,
-			"finish_reason": nil,
-		}
-
-		delta := map[string]interface{}{}
+		choice := openai.ChunkChoice{Index: i}
 
-		// Handle content
+		// Native Gemini stream chunks carry the delta text in each part
 		if candidate.Content != nil {
-			var content string
+			var content strings.Builder
 			for _, part := range candidate.Content.Parts {
-				if part.Text != "" {
-					content += part.Text
+				if part.Thought {
+					continue
 				}
+				if part.FunctionCall != nil {
+					call := openAIToolCall(part.FunctionCall.ID, part.FunctionCall.Name, part.FunctionCall.Args)
+					index := len(choice.Delta.ToolCalls)
+					call.Index = &index
+					choice.Delta.ToolCalls = append(choice.Delta.ToolCalls, call)
+					continue
+				}
+				content.WriteString(part.Text)
 			}
-			if content != "" {
-				delta["content"] = content
-			}
-			// Only send role on first chunk? Gemini sends full structure often.
-			// Ideally we track state, but for now sending role in every chunk is often tolerated or we check emptiness.
-			// Gemini candidates often contain the whole accumulated content or just the delta? 
-			// The API docs say "Response stream... Returns the generated content...".
-			// Native Gemini stream chunks usually contain the *delta* text in `text`.
+			choice.Delta.Content = content.String()
 		}
-		
-		choice["delta"] = delta
-		
+
 		if candidate.FinishReason != "" {
-			choice["finish_reason"] = convertFinishReason(candidate.FinishReason)
+			reason := convertFinishReason(candidate.FinishReason)
+			if len(choice.Delta.ToolCalls) > 0 {
+				reason = "tool_calls"
+			}
+			choice.FinishReason = openai.FinishReason(reason)
 		}
-		
-		choices = append(choices, choice)
+
+		openAIChunk.Choices = append(openAIChunk.Choices, choice)
 	}
 
-	openAIChunk["choices"] = choices
-	
 	// Handle usage if present (Gemini sends it at the end)
 	if geminiResp.UsageMetadata != nil {
-		openAIChunk["usage"] = map[string]interface{}{
-			"prompt_tokens":     geminiResp.UsageMetadata.PromptTokenCount,
-			"completion_tokens": geminiResp.UsageMetadata.CandidatesTokenCount,
-			"total_tokens":      geminiResp.UsageMetadata.TotalTokenCount,
+		openAIChunk.Usage = &openai.Usage{
+			PromptTokens:     geminiResp.UsageMetadata.PromptTokenCount,
+			CompletionTokens: geminiResp.UsageMetadata.CandidatesTokenCount,
+			TotalTokens:      geminiResp.UsageMetadata.TotalTokenCount,
 		}
 	}
 
 	return openAIChunk, nil
 }
-// FromOpenAIRequest converts OpenAI format to Gemini format
-func (c *GeminiConverter) FromOpenAIRequest(req interface{}) (interface{}, error) {
-	// Convert OpenAI request format to Gemini format
-	openAIReq, ok := req.(map[string]interface{})
-	if !ok {
-		return nil, fmt.Errorf("unexpected request type: %T", req)
-	}
 
-	// Create Gemini request
+// FromOpenAIRequest converts OpenAI format to Gemini format
+func (c *GeminiConverter) FromOpenAIRequest(req *openai.ChatCompletionRequest) (interface{}, error) {
 	geminiReq := &gemini.GeminiRequest{
 		Contents: []gemini.Content{},
 	}
 
-	// Convert messages
-	if messages, ok := openAIReq["messages"].([]interface{}); ok {
-		for _, msg := range messages {
-			if msgMap, ok := msg.(map[string]interface{}); ok {
-				role, _ := msgMap["role"].(string)
-				content := convertContent(msgMap["content"])
-
-				// Map OpenAI roles to Gemini roles
-				geminiRole := role
-				if role == "system" {
-					// For system messages, we'll use the systemInstruction field
-					geminiReq.SystemInstruction = &gemini.Content{
-						Role:  "user",
-						Parts: []gemini.Part{{Text: content}},
-					}
-					continue
-				} else if role == "assistant" {
-					geminiRole = "model"
-				} else if role == "user" {
-					geminiRole = "user"
-				}
+	// Tool responses only carry the call ID; Gemini wants the function name
+	toolNames := make(map[string]string)
 
-				geminiReq.Contents = append(geminiReq.Contents, gemini.Content{
-					Role:  geminiRole,
-					Parts: []gemini.Part{{Text: content}},
-				})
+	for _, msg := range req.Messages {
+		switch msg.Role {
+		case "system":
+			// For system messages, we'll use the systemInstruction field
+			geminiReq.SystemInstruction = &gemini.Content{
+				Role:  "user",
+				Parts: []gemini.Part{{Text: msg.Content.String()}},
+			}
+		case "tool":
+			geminiReq.Contents = append(geminiReq.Contents, gemini.Content{
+				Role: "user",
+				Parts: []gemini.Part{{FunctionResponse: &gemini.FunctionResponse{
+					ID:       msg.ToolCallID,
+					Name:     toolNames[msg.ToolCallID],
+					Response: map[string]interface{}{"result": msg.Content.String()},
+				}}},
+			})
+		default:
+			geminiRole := "user"
+			if msg.Role == "assistant" {
+				geminiRole = "model"
 			}
-		}
-	}
 
-	// Convert generation config
-	if temp, ok := openAIReq["temperature"].(float64); ok {
-		if geminiReq.GenerationConfig == nil {
-			geminiReq.GenerationConfig = &gemini.GenerationConfig{}
+			parts := geminiPartsFromContent(msg.Content)
+			for _, call := range msg.ToolCalls {
+				toolNames[call.ID] = call.Function.Name
+				parts = append(parts, gemini.Part{FunctionCall: &gemini.FunctionCall{
+					ID:   call.ID,
+					Name: call.Function.Name,
+					Args: toArgsMap(call.Function.Arguments),
+				}})
+			}
+			if len(parts) == 0 {
+				parts = []gemini.Part{{Text: ""}}
+			}
+
+			geminiReq.Contents = append(geminiReq.Contents, gemini.Content{
+				Role:  geminiRole,
+				Parts: parts,
+			})
 		}
-		geminiReq.GenerationConfig.Temperature = &temp
 	}
 
-	if topP, ok := openAIReq["top_p"].(float64); ok {
-		if geminiReq.GenerationConfig == nil {
-			geminiReq.GenerationConfig = &gemini.GenerationConfig{}
+	// Convert generation config
+	if req.Temperature != nil || req.TopP != nil || req.MaxTokens != nil || len(req.Stop) > 0 {
+		geminiReq.GenerationConfig = &gemini.GenerationConfig{
+			Temperature:     req.Temperature,
+			TopP:            req.TopP,
+			MaxOutputTokens: req.MaxTokens,
+			StopSequences:   req.Stop,
 		}
-		geminiReq.GenerationConfig.TopP = &topP
 	}
 
-	if maxTokens, ok := openAIReq["max_tokens"].(float64); ok {
-		maxTokensInt := int(maxTokens)
-		if geminiReq.GenerationConfig == nil {
-			geminiReq.GenerationConfig = &gemini.GenerationConfig{}
+	if len(req.Tools) > 0 {
+		decls := make([]gemini.FunctionDeclaration, 0, len(req.Tools))
+		for _, tool := range req.Tools {
+			decls = append(decls, gemini.FunctionDeclaration{
+				Name:        tool.Function.Name,
+				Description: tool.Function.Description,
+				Parameters:  tool.Function.Parameters,
+			})
 		}
-		geminiReq.GenerationConfig.MaxOutputTokens = &maxTokensInt
+		geminiReq.Tools = []gemini.Tool{{FunctionDeclarations: decls}}
 	}
 
 	return geminiReq, nil
 }
 
 // FromOpenAIResponse converts OpenAI format to Gemini format
-func (c *GeminiConverter) FromOpenAIResponse(resp interface{}) (interface{}, error) {
-	// For now, return as-is - we'll implement proper conversion later
-	return resp, nil
+func (c *GeminiConverter) FromOpenAIResponse(resp *openai.ChatCompletionResponse) (interface{}, error) {
+	geminiResp := &gemini.GeminiResponse{Candidates: []gemini.Candidate{}}
+	for i, choice := range resp.Choices {
+		var parts []gemini.Part
+		if text := choice.Message.Content.String(); text != "" {
+			parts = append(parts, gemini.Part{Text: text})
+		}
+		for _, call := range choice.Message.ToolCalls {
+			parts = append(parts, gemini.Part{FunctionCall: &gemini.FunctionCall{
+				ID:   call.ID,
+				Name: call.Function.Name,
+				Args: toArgsMap(call.Function.Arguments),
+			}})
+		}
+
+		geminiResp.Candidates = append(geminiResp.Candidates, gemini.Candidate{
+			Content:      &gemini.Content{Role: "model", Parts: parts},
+			FinishReason: geminiFinishReason(choice.FinishReason),
+			Index:        i,
+		})
+	}
+
+	if resp.Usage != nil {
+		geminiResp.UsageMetadata = &gemini.UsageMetadata{
+			PromptTokenCount:     resp.Usage.PromptTokens,
+			CandidatesTokenCount: resp.Usage.CompletionTokens,
+			TotalTokenCount:      resp.Usage.TotalTokens,
+		}
+	}
+
+	return geminiResp, nil
 }
 
 // Protocol returns the native protocol
@@ -239,30 +350,48 @@ func (c *GeminiConverter) Protocol() provider.ProtocolType {
 	return provider.ProtocolGemini
 }
 
-// Helper functions
-func convertContent(content interface{}) string {
-	if contentStr, ok := content.(string); ok {
-		return contentStr
+// geminiPartsFromContent converts OpenAI message content into Gemini parts
+func geminiPartsFromContent(content openai.MessageContent) []gemini.Part {
+	if !content.IsParts() {
+		if content.Text == "" {
+			return nil
+		}
+		return []gemini.Part{{Text: content.Text}}
 	}
 
-	// Handle content as array of content parts (for vision models)
-	if contentArr, ok := content.([]interface{}); ok {
-		var result string
-		for _, part := range contentArr {
-			if partMap, ok := part.(map[string]interface{}); ok {
-				if text, exists := partMap["text"]; exists {
-					if textStr, ok := text.(string); ok {
-						result += textStr
-					}
-				}
+	parts := make([]gemini.Part, 0, len(content.Parts))
+	for _, part := range content.Parts {
+		switch part.Type {
+		case "text":
+			parts = append(parts, gemini.Part{Text: part.Text})
+		case "image_url":
+			if part.ImageURL == nil {
+				continue
+			}
+			if mimeType, data, ok := parseDataURI(part.ImageURL.URL); ok {
+				parts = append(parts, gemini.Part{InlineData: &gemini.InlineData{MimeType: mimeType, Data: data}})
+			} else {
+				parts = append(parts, gemini.Part{FileData: &gemini.FileData{FileURI: part.ImageURL.URL}})
 			}
 		}
-		return result
 	}
+	return parts
+}
 
-	return fmt.Sprintf("%v", content)
+// parseDataURI splits a base64 data URI into its media type and payload
+func parseDataURI(uri string) (mimeType, data string, ok bool) {
+	rest, found := strings.CutPrefix(uri, "data:")
+	if !found {
+		return "", "", false
+	}
+	meta, payload, found := strings.Cut(rest, ",")
+	if !found || !strings.HasSuffix(meta, ";base64") {
+		return "", "", false
+	}
+	return strings.TrimSuffix(meta, ";base64"), payload, true
 }
 
+// Helper functions
 func convertFinishReason(geminiFinishReason string) string {
 	switch geminiFinishReason {
 	case "STOP":
@@ -2,6 +2,10 @@
 package converter
 
 import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/sunbankio/qwencoder-proxy/openai"
 	"github.com/sunbankio/qwencoder-proxy/provider"
 )
 
@@ -14,31 +18,31 @@ func NewOpenAIConverter() *OpenAIConverter {
 }
 
 // ToOpenAIRequest converts native format to OpenAI format
-func (c *OpenAIConverter) ToOpenAIRequest(native interface{}) (interface{}, error) {
-	// For OpenAI format, no conversion needed
-	return native, nil
+func (c *OpenAIConverter) ToOpenAIRequest(native interface{}) (*openai.ChatCompletionRequest, error) {
+	// For OpenAI format, only the Go representation changes
+	return asOpenAIRequest(native)
 }
 
 // ToOpenAIResponse converts native format to OpenAI format
-func (c *OpenAIConverter) ToOpenAIResponse(native interface{}, model string) (interface{}, error) {
-	// For OpenAI format, no conversion needed
-	return native, nil
+func (c *OpenAIConverter) ToOpenAIResponse(native interface{}, model string) (*openai.ChatCompletionResponse, error) {
+	// For OpenAI format, only the Go representation changes
+	return asOpenAIResponse(native)
 }
 
 // ToOpenAIStreamChunk converts native format to OpenAI format
-func (c *OpenAIConverter) ToOpenAIStreamChunk(native interface{}, model string) (interface{}, error) {
-	// For OpenAI format, no conversion needed
-	return native, nil
+func (c *OpenAIConverter) ToOpenAIStreamChunk(native interface{}, model string) (*openai.ChatCompletionChunk, error) {
+	// For OpenAI format, only the Go representation changes
+	return asOpenAIChunk(native)
 }
 
 // FromOpenAIRequest converts OpenAI format to native format
-func (c *OpenAIConverter) FromOpenAIRequest(req interface{}) (interface{}, error) {
+func (c *OpenAIConverter) FromOpenAIRequest(req *openai.ChatCompletionRequest) (interface{}, error) {
 	// For OpenAI format, no conversion needed
 	return req, nil
 }
 
 // FromOpenAIResponse converts OpenAI format to native format
-func (c *OpenAIConverter) FromOpenAIResponse(resp interface{}) (interface{}, error) {
+func (c *OpenAIConverter) FromOpenAIResponse(resp *openai.ChatCompletionResponse) (interface{}, error) {
 	// For OpenAI format, no conversion needed
 	return resp, nil
 }
@@ -46,4 +50,56 @@ func (c *OpenAIConverter) FromOpenAIResponse(resp interface{}) (interface{}, err
 // Protocol returns the native protocol
 func (c *OpenAIConverter) Protocol() provider.ProtocolType {
 	return provider.ProtocolOpenAI
-}
\ No newline at end of file
+}
+
+// asOpenAIRequest accepts a typed request or any JSON-compatible value
+func asOpenAIRequest(req interface{}) (*openai.ChatCompletionRequest, error) {
+	if r, ok := req.(*openai.ChatCompletionRequest); ok {
+		return r, nil
+	}
+	r := &openai.ChatCompletionRequest{}
+	if err := remarshal(req, r); err != nil {
+		return nil, fmt.Errorf("unexpected request type %T: %w", req, err)
+	}
+	return r, nil
+}
+
+// asOpenAIResponse accepts a typed response or any JSON-compatible value
+func asOpenAIResponse(resp interface{}) (*openai.ChatCompletionResponse, error) {
+	if r, ok := resp.(*openai.ChatCompletionResponse); ok {
+		return r, nil
+	}
+	r := &openai.ChatCompletionResponse{}
+	if err := remarshal(resp, r); err != nil {
+		return nil, fmt.Errorf("unexpected response type %T: %w", resp, err)
+	}
+	return r, nil
+}
+
+// asOpenAIChunk accepts a typed chunk or any JSON-compatible value
+func asOpenAIChunk(chunk interface{}) (*openai.ChatCompletionChunk, error) {
+	if c, ok := chunk.(*openai.ChatCompletionChunk); ok {
+		return c, nil
+	}
+	c := &openai.ChatCompletionChunk{}
+	if err := remarshal(chunk, c); err != nil {
+		return nil, fmt.Errorf("unexpected chunk type %T: %w", chunk, err)
+	}
+	return c, nil
+}
+
+// openAIToolCall builds an OpenAI tool call entry
+func openAIToolCall(id, name string, args interface{}) openai.ToolCall {
+	argsJSON, err := json.Marshal(args)
+	if err != nil || string(argsJSON) == "null" {
+		argsJSON = []byte("{}")
+	}
+	return openai.ToolCall{
+		ID:   id,
+		Type: "function",
+		Function: openai.FunctionCall{
+			Name:      name,
+			Arguments: string(argsJSON),
+		},
+	}
+}
@@ -2,9 +2,11 @@
 package converter
 
 import (
+	"encoding/json"
 	"fmt"
 	"time"
 
+	"github.com/sunbankio/qwencoder-proxy/openai"
 	"github.com/sunbankio/qwencoder-proxy/provider"
 )
 
@@ -16,121 +18,89 @@ func NewQwenConverter() *QwenConverter {
 	return &QwenConverter{}
 }
 
+// qwenEnvelope captures the DashScope-style wrapper some Qwen responses use
+type qwenEnvelope struct {
+	Output *openai.ChatCompletionResponse `json:"output"`
+	Usage  *openai.Usage                  `json:"usage"`
+}
+
 // ToOpenAIRequest converts Qwen format to OpenAI format
-func (c *QwenConverter) ToOpenAIRequest(native interface{}) (interface{}, error) {
-	return native, nil
+func (c *QwenConverter) ToOpenAIRequest(native interface{}) (*openai.ChatCompletionRequest, error) {
+	return asOpenAIRequest(native)
 }
 
 // ToOpenAIResponse converts Qwen format to OpenAI format
-func (c *QwenConverter) ToOpenAIResponse(native interface{}, model string) (interface{}, error) {
-	qwenResp, ok := native.(map[string]interface{})
-	if !ok {
-		return native, nil
+func (c *QwenConverter) ToOpenAIResponse(native interface{}, model string) (*openai.ChatCompletionResponse, error) {
+	if resp, ok := native.(*openai.ChatCompletionResponse); ok {
+		return resp, nil
 	}
 
-	if qwenResp["error"] != nil {
-		return native, nil
+	data, err := json.Marshal(native)
+	if err != nil {
+		return nil, fmt.Errorf("unexpected response type %T: %w", native, err)
 	}
 
-	openAIResp := map[string]interface{}{
-		"id":      fmt.Sprintf("chatcmpl-%s", model),
-		"object":  "chat.completion",
-		"created": time.Now().Unix(),
-		"model":   model,
-		"choices": []interface{}{},
-		"usage": map[string]interface{}{
-			"prompt_tokens":     0,
-			"completion_tokens": 0,
-			"total_tokens":      0,
-		},
+	openAIResp := &openai.ChatCompletionResponse{}
+	if err := json.Unmarshal(data, openAIResp); err != nil {
+		return nil, fmt.Errorf("failed to decode Qwen response: %w", err)
 	}
 
-	// Helper to extract choices from a map
-	extractChoices := func(m map[string]interface{}) ([]interface{}, bool) {
-		if choices, ok := m["choices"].([]interface{}); ok {
-			return choices, true
-		}
-		if output, ok := m["output"].(map[string]interface{}); ok {
-			if choices, ok := output["choices"].([]interface{}); ok {
-				return choices, true
-			}
-		}
-		return nil, false
+	if apiErr, ok := openAIResp.Extra["error"]; ok && string(apiErr) != "null" {
+		return nil, fmt.Errorf("qwen API error: %s", apiErr)
 	}
 
-	// Helper to extract usage from a map
-	extractUsage := func(m map[string]interface{}) (map[string]interface{}, bool) {
-		if usage, ok := m["usage"].(map[string]interface{}); ok {
-			return usage, true
-		}
-		if output, ok := m["output"].(map[string]interface{}); ok {
-			if usage, ok := output["usage"].(map[string]interface{}); ok {
-				return usage, true
-			}
-		}
-		return nil, false
-	}
-
-	if choices, ok := extractChoices(qwenResp); ok {
-		var openAIChoices []interface{}
-		for i, choice := range choices {
-			if choiceMap, ok := choice.(map[string]interface{}); ok {
-				content := ""
-				if msg, exists := choiceMap["message"]; exists {
-					if msgMap, msgOk := msg.(map[string]interface{}); msgOk {
-						if c, ok := msgMap["content"].(string); ok {
-							content = c
-						}
-					}
+	// Responses wrapped as {"output": {"choices": [...]}, "usage": {...}} are unwrapped
+	if len(openAIResp.Choices) == 0 {
+		if _, wrapped := openAIResp.Extra["output"]; wrapped {
+			var envelope qwenEnvelope
+			if err := json.Unmarshal(data, &envelope); err == nil && envelope.Output != nil {
+				openAIResp = envelope.Output
+				if openAIResp.Usage == nil {
+					openAIResp.Usage = envelope.Usage
 				}
-
-				finishReason := "stop"
-				if reason, ok := choiceMap["finish_reason"].(string); ok {
-					finishReason = reason
-				}
-
-				openAIChoices = append(openAIChoices, map[string]interface{}{
-					"index": i,
-					"message": map[string]interface{}{
-						"role":    "assistant",
-						"content": content,
-					},
-					"finish_reason": finishReason,
-				})
 			}
 		}
-		openAIResp["choices"] = openAIChoices
 	}
 
-	if usage, ok := extractUsage(qwenResp); ok {
-		targetUsage := openAIResp["usage"].(map[string]interface{})
-		keys := []string{"prompt_tokens", "completion_tokens", "total_tokens"}
-		for _, key := range keys {
-			if val, ok := usage[key]; ok {
-				if fval, ok := val.(float64); ok {
-					targetUsage[key] = int(fval)
-				} else if ival, ok := val.(int); ok {
-					targetUsage[key] = ival
-				}
-			}
+	if openAIResp.ID == "" {
+		openAIResp.ID = fmt.Sprintf("chatcmpl-%s", model)
+	}
+	if openAIResp.Object == "" {
+		openAIResp.Object = "chat.completion"
+	}
+	if openAIResp.Created == 0 {
+		openAIResp.Created = time.Now().Unix()
+	}
+	if openAIResp.Model == "" {
+		openAIResp.Model = model
+	}
+	if openAIResp.Choices == nil {
+		openAIResp.Choices = []openai.Choice{}
+	}
+	for i := range openAIResp.Choices {
+		if openAIResp.Choices[i].FinishReason == "" {
+			openAIResp.Choices[i].FinishReason = "stop"
 		}
 	}
+	if openAIResp.Usage == nil {
+		openAIResp.Usage = &openai.Usage{}
+	}
 
 	return openAIResp, nil
 }
 
 // ToOpenAIStreamChunk converts Qwen format to OpenAI format
-func (c *QwenConverter) ToOpenAIStreamChunk(native interface{}, model string) (interface{}, error) {
-	return native, nil
+func (c *QwenConverter) ToOpenAIStreamChunk(native interface{}, model string) (*openai.ChatCompletionChunk, error) {
+	return asOpenAIChunk(native)
 }
 
 // FromOpenAIRequest converts OpenAI format to Qwen format
-func (c *QwenConverter) FromOpenAIRequest(req interface{}) (interface{}, error) {
+func (c *QwenConverter) FromOpenAIRequest(req *openai.ChatCompletionRequest) (interface{}, error) {
 	return req, nil
 }
 
 // FromOpenAIResponse converts OpenAI format to Qwen format
-func (c *QwenConverter) FromOpenAIResponse(resp interface{}) (interface{}, error) {
+func (c *QwenConverter) FromOpenAIResponse(resp *openai.ChatCompletionResponse) (interface{}, error) {
 	return resp, nil
 }
 
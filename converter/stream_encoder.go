@@ -0,0 +1,405 @@
+// Package converter provides format conversion between different LLM API formats
+package converter
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/sunbankio/qwencoder-proxy/openai"
+	"github.com/sunbankio/qwencoder-proxy/provider"
+	"github.com/sunbankio/qwencoder-proxy/provider/gemini"
+	"github.com/sunbankio/qwencoder-proxy/sse"
+)
+
+// StreamEncoder renders a sequence of OpenAI stream chunks as a native protocol's server-sent events
+type StreamEncoder interface {
+	// Encode returns the events for one chunk
+	Encode(chunk *openai.ChatCompletionChunk) ([]sse.Event, error)
+
+	// Finish returns the events that close the stream
+	Finish() ([]sse.Event, error)
+}
+
+// NewStreamEncoder returns an encoder producing the streaming format of the given protocol
+func (f *Factory) NewStreamEncoder(protocol provider.ProtocolType, model string) (StreamEncoder, error) {
+	switch protocol {
+	case provider.ProtocolOpenAI, provider.ProtocolQwen:
+		return &openAIStreamEncoder{}, nil
+	case provider.ProtocolClaude:
+		return &claudeStreamEncoder{model: model, toolBlocks: make(map[int]*claudeToolBlock)}, nil
+	case provider.ProtocolGemini:
+		return &geminiStreamEncoder{toolCalls: make(map[int]*openai.Accumulator)}, nil
+	default:
+		return nil, &ConverterError{Protocol: protocol, Message: "stream encoder not found"}
+	}
+}
+
+// jsonEvent marshals v as the data of an event of the given type
+func jsonEvent(eventType string, v interface{}) (sse.Event, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return sse.Event{}, fmt.Errorf("failed to marshal %s event: %w", eventType, err)
+	}
+	return sse.Event{Type: eventType, Data: string(data)}, nil
+}
+
+// eventList collects the events produced for one chunk
+type eventList []sse.Event
+
+// add appends an event carrying v as JSON
+func (l *eventList) add(eventType string, v interface{}) error {
+	ev, err := jsonEvent(eventType, v)
+	if err != nil {
+		return err
+	}
+	*l = append(*l, ev)
+	return nil
+}
+
+// openAIStreamEncoder writes chunks unchanged and terminates with [DONE]
+type openAIStreamEncoder struct{}
+
+func (e *openAIStreamEncoder) Encode(chunk *openai.ChatCompletionChunk) ([]sse.Event, error) {
+	ev, err := jsonEvent("", chunk)
+	if err != nil {
+		return nil, err
+	}
+	return []sse.Event{ev}, nil
+}
+
+func (e *openAIStreamEncoder) Finish() ([]sse.Event, error) {
+	return []sse.Event{{Data: "[DONE]"}}, nil
+}
+
+// claudeStreamEncoder produces the Anthropic Messages event sequence:
+// message_start, content blocks (start, deltas, stop), message_delta and message_stop
+type claudeStreamEncoder struct {
+	model      string
+	started    bool
+	blockOpen  bool
+	blockType  string                   // Type of the open block: text, thinking or tool_use
+	nextBlock  int                      // Index the next content block will use
+	toolBlocks map[int]*claudeToolBlock // OpenAI tool call index to the block carrying it
+	stopReason string
+	usage      *openai.Usage
+}
+
+func (e *claudeStreamEncoder) Encode(chunk *openai.ChatCompletionChunk) ([]sse.Event, error) {
+	var events eventList
+	emit := events.add
+
+	if !e.started {
+		if err := e.start(chunk.ID, emit); err != nil {
+			return nil, err
+		}
+	}
+	if chunk.Usage != nil {
+		e.usage = chunk.Usage
+	}
+
+	// Anthropic messages have a single alternative
+	for _, choice := range chunk.Choices {
+		if choice.Index != 0 {
+			continue
+		}
+		delta := choice.Delta
+		if delta.ReasoningContent != "" {
+			if err := e.textDelta("thinking", "thinking_delta", "thinking", delta.ReasoningContent, emit); err != nil {
+				return nil, err
+			}
+		}
+		if delta.Content != "" {
+			if err := e.textDelta("text", "text_delta", "text", delta.Content, emit); err != nil {
+				return nil, err
+			}
+		}
+		for _, call := range delta.ToolCalls {
+			if err := e.toolDelta(call, emit); err != nil {
+				return nil, err
+			}
+		}
+		if choice.FinishReason != nil && *choice.FinishReason != "" {
+			e.stopReason = claudeStopReason(*choice.FinishReason)
+		}
+	}
+	return events, nil
+}
+
+func (e *claudeStreamEncoder) Finish() ([]sse.Event, error) {
+	var events eventList
+	emit := events.add
+
+	if !e.started {
+		if err := e.start("", emit); err != nil {
+			return nil, err
+		}
+	}
+	if err := e.closeBlock(emit); err != nil {
+		return nil, err
+	}
+
+	stopReason := e.stopReason
+	if stopReason == "" {
+		stopReason = "end_turn"
+	}
+	usage := map[string]interface{}{"output_tokens": 0}
+	if e.usage != nil {
+		usage = map[string]interface{}{"input_tokens": e.usage.PromptTokens, "output_tokens": e.usage.CompletionTokens}
+	}
+	if err := emit("message_delta", map[string]interface{}{
+		"type":  "message_delta",
+		"delta": map[string]interface{}{"stop_reason": stopReason, "stop_sequence": nil},
+		"usage": usage,
+	}); err != nil {
+		return nil, err
+	}
+	if err := emit("message_stop", map[string]interface{}{"type": "message_stop"}); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// start emits message_start; token counts are only known once the stream ends
+func (e *claudeStreamEncoder) start(id string, emit func(string, interface{}) error) error {
+	e.started = true
+	if id == "" {
+		id = fmt.Sprintf("msg_%d", time.Now().UnixNano())
+	}
+	return emit("message_start", map[string]interface{}{
+		"type": "message_start",
+		"message": map[string]interface{}{
+			"id":            id,
+			"type":          "message",
+			"role":          "assistant",
+			"model":         e.model,
+			"content":       []interface{}{},
+			"stop_reason":   nil,
+			"stop_sequence": nil,
+			"usage":         map[string]interface{}{"input_tokens": 0, "output_tokens": 0},
+		},
+	})
+}
+
+// openBlock closes the current block and starts a new one
+func (e *claudeStreamEncoder) openBlock(blockType string, block map[string]interface{}, emit func(string, interface{}) error) error {
+	if err := e.closeBlock(emit); err != nil {
+		return err
+	}
+	e.blockOpen, e.blockType = true, blockType
+	return emit("content_block_start", map[string]interface{}{
+		"type":          "content_block_start",
+		"index":         e.nextBlock,
+		"content_block": block,
+	})
+}
+
+// closeBlock stops the open block, if any
+func (e *claudeStreamEncoder) closeBlock(emit func(string, interface{}) error) error {
+	if !e.blockOpen {
+		return nil
+	}
+	e.blockOpen = false
+	index := e.nextBlock
+	e.nextBlock++
+	return emit("content_block_stop", map[string]interface{}{"type": "content_block_stop", "index": index})
+}
+
+// textDelta appends text to an open block of blockType, starting one if needed
+func (e *claudeStreamEncoder) textDelta(blockType, deltaType, field, text string, emit func(string, interface{}) error) error {
+	if !e.blockOpen || e.blockType != blockType {
+		if err := e.openBlock(blockType, map[string]interface{}{"type": blockType, field: ""}, emit); err != nil {
+			return err
+		}
+	}
+	return emit("content_block_delta", map[string]interface{}{
+		"type":  "content_block_delta",
+		"index": e.nextBlock,
+		"delta": map[string]interface{}{"type": deltaType, field: text},
+	})
+}
+
+// claudeToolBlock tracks the tool_use block carrying one OpenAI tool call
+type claudeToolBlock struct {
+	index int
+	id    string
+	args  string
+}
+
+// startsNewCall reports whether a tool call delta begins a different call than the block holds.
+// Upstreams that reuse one index for every call send each call whole, so a named delta
+// after complete arguments is a new call.
+func (b *claudeToolBlock) startsNewCall(call openai.ToolCall) bool {
+	if call.ID != "" {
+		return b.id != "" && call.ID != b.id
+	}
+	return call.Function.Name != "" && b.args != "" && json.Valid([]byte(b.args))
+}
+
+// toolDelta starts a tool_use block for a new call and streams its argument fragments
+func (e *claudeStreamEncoder) toolDelta(call openai.ToolCall, emit func(string, interface{}) error) error {
+	index := 0
+	if call.Index != nil {
+		index = *call.Index
+	}
+
+	block, known := e.toolBlocks[index]
+	if !known || block.startsNewCall(call) {
+		id := call.ID
+		if id == "" {
+			id = fmt.Sprintf("toolu_%d_%d", time.Now().UnixNano(), e.nextBlock)
+		}
+		if err := e.openBlock("tool_use", map[string]interface{}{
+			"type":  "tool_use",
+			"id":    id,
+			"name":  call.Function.Name,
+			"input": map[string]interface{}{},
+		}, emit); err != nil {
+			return err
+		}
+		block = &claudeToolBlock{index: e.nextBlock, id: call.ID}
+		e.toolBlocks[index] = block
+	}
+
+	if call.Function.Arguments == "" {
+		return nil
+	}
+	block.args += call.Function.Arguments
+	return emit("content_block_delta", map[string]interface{}{
+		"type":  "content_block_delta",
+		"index": block.index,
+		"delta": map[string]interface{}{"type": "input_json_delta", "partial_json": call.Function.Arguments},
+	})
+}
+
+// claudeStopReason converts an OpenAI finish_reason to a Claude stop_reason
+func claudeStopReason(finishReason string) string {
+	switch finishReason {
+	case "length":
+		return "max_tokens"
+	case "tool_calls", "function_call":
+		return "tool_use"
+	case "content_filter":
+		return "refusal"
+	default:
+		return "end_turn"
+	}
+}
+
+// geminiStreamEncoder produces streamGenerateContent responses. Text is sent as it arrives;
+// function calls must be complete, so they are held back and sent with the finish reason.
+type geminiStreamEncoder struct {
+	toolCalls     map[int]*openai.Accumulator // Per candidate, assembles tool call fragments
+	finishReasons map[int]string
+	usage         *openai.Usage
+}
+
+func (e *geminiStreamEncoder) Encode(chunk *openai.ChatCompletionChunk) ([]sse.Event, error) {
+	if chunk.Usage != nil {
+		e.usage = chunk.Usage
+	}
+
+	resp := &gemini.GeminiResponse{Candidates: []gemini.Candidate{}}
+	for _, choice := range chunk.Choices {
+		if len(choice.Delta.ToolCalls) > 0 {
+			acc, ok := e.toolCalls[choice.Index]
+			if !ok {
+				acc = openai.NewAccumulator()
+				e.toolCalls[choice.Index] = acc
+			}
+			acc.Add(&openai.ChatCompletionChunk{Choices: []openai.ChunkChoice{{Delta: openai.Delta{ToolCalls: choice.Delta.ToolCalls}}}})
+		}
+		if choice.FinishReason != nil && *choice.FinishReason != "" {
+			if e.finishReasons == nil {
+				e.finishReasons = make(map[int]string)
+			}
+			e.finishReasons[choice.Index] = *choice.FinishReason
+		}
+
+		var parts []gemini.Part
+		if choice.Delta.ReasoningContent != "" {
+			parts = append(parts, gemini.Part{Text: choice.Delta.ReasoningContent, Thought: true})
+		}
+		if choice.Delta.Content != "" {
+			parts = append(parts, gemini.Part{Text: choice.Delta.Content})
+		}
+		if len(parts) > 0 {
+			resp.Candidates = append(resp.Candidates, gemini.Candidate{
+				Content: &gemini.Content{Role: "model", Parts: parts},
+				Index:   choice.Index,
+			})
+		}
+	}
+
+	if len(resp.Candidates) == 0 {
+		return nil, nil
+	}
+	ev, err := jsonEvent("", resp)
+	if err != nil {
+		return nil, err
+	}
+	return []sse.Event{ev}, nil
+}
+
+func (e *geminiStreamEncoder) Finish() ([]sse.Event, error) {
+	indexes := []int{0}
+	for index := range e.toolCalls {
+		if index != 0 {
+			indexes = append(indexes, index)
+		}
+	}
+	for index := range e.finishReasons {
+		if index != 0 && e.toolCalls[index] == nil {
+			indexes = append(indexes, index)
+		}
+	}
+	sort.Ints(indexes)
+
+	resp := &gemini.GeminiResponse{Candidates: []gemini.Candidate{}}
+	for _, index := range indexes {
+		var parts []gemini.Part
+		if acc, ok := e.toolCalls[index]; ok {
+			for _, call := range acc.Response().Choices[0].Message.ToolCalls {
+				parts = append(parts, gemini.Part{FunctionCall: &gemini.FunctionCall{
+					ID:   call.ID,
+					Name: call.Function.Name,
+					Args: toArgsMap(call.Function.Arguments),
+				}})
+			}
+		}
+		if len(parts) == 0 {
+			parts = []gemini.Part{{Text: ""}}
+		}
+		resp.Candidates = append(resp.Candidates, gemini.Candidate{
+			Content:      &gemini.Content{Role: "model", Parts: parts},
+			FinishReason: geminiFinishReason(e.finishReasons[index]),
+			Index:        index,
+		})
+	}
+	if e.usage != nil {
+		resp.UsageMetadata = &gemini.UsageMetadata{
+			PromptTokenCount:     e.usage.PromptTokens,
+			CandidatesTokenCount: e.usage.CompletionTokens,
+			TotalTokenCount:      e.usage.TotalTokens,
+		}
+	}
+
+	ev, err := jsonEvent("", resp)
+	if err != nil {
+		return nil, err
+	}
+	return []sse.Event{ev}, nil
+}
+
+// geminiFinishReason converts an OpenAI finish_reason to a Gemini finishReason
+func geminiFinishReason(finishReason string) string {
+	switch finishReason {
+	case "length":
+		return "MAX_TOKENS"
+	case "content_filter":
+		return "SAFETY"
+	default:
+		return "STOP"
+	}
+}
@@ -0,0 +1,135 @@
+package converter
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/sunbankio/qwencoder-proxy/openai"
+	"github.com/sunbankio/qwencoder-proxy/provider"
+	"github.com/sunbankio/qwencoder-proxy/provider/gemini"
+	"github.com/sunbankio/qwencoder-proxy/sse"
+)
+
+// toolCallChunks is a text answer followed by a tool call streamed in fragments
+func toolCallChunks() []openai.ChatCompletionChunk {
+	index := 0
+	return []openai.ChatCompletionChunk{
+		{ID: "chatcmpl-1", Choices: []openai.ChunkChoice{{Delta: openai.Delta{Role: "assistant", ReasoningContent: "Need the weather."}}}},
+		{ID: "chatcmpl-1", Choices: []openai.ChunkChoice{{Delta: openai.Delta{Content: "Checking "}}}},
+		{ID: "chatcmpl-1", Choices: []openai.ChunkChoice{{Delta: openai.Delta{Content: "now."}}}},
+		{ID: "chatcmpl-1", Choices: []openai.ChunkChoice{{Delta: openai.Delta{ToolCalls: []openai.ToolCall{
+			{Index: &index, ID: "call_1", Type: "function", Function: openai.FunctionCall{Name: "get_weather", Arguments: `{"city":`}},
+		}}}}},
+		{ID: "chatcmpl-1", Choices: []openai.ChunkChoice{{Delta: openai.Delta{ToolCalls: []openai.ToolCall{
+			{Index: &index, Function: openai.FunctionCall{Arguments: `"Paris"}`}},
+		}}}}},
+		{ID: "chatcmpl-1", Choices: []openai.ChunkChoice{{FinishReason: openai.FinishReason("tool_calls")}},
+			Usage: &openai.Usage{PromptTokens: 10, CompletionTokens: 6, TotalTokens: 16}},
+	}
+}
+
+func encodeAll(t *testing.T, protocol provider.ProtocolType, chunks []openai.ChatCompletionChunk) []sse.Event {
+	t.Helper()
+	enc, err := NewFactory().NewStreamEncoder(protocol, "test-model")
+	if err != nil {
+		t.Fatalf("NewStreamEncoder() error = %v", err)
+	}
+	var events []sse.Event
+	for i := range chunks {
+		evs, err := enc.Encode(&chunks[i])
+		if err != nil {
+			t.Fatalf("Encode() error = %v", err)
+		}
+		events = append(events, evs...)
+	}
+	evs, err := enc.Finish()
+	if err != nil {
+		t.Fatalf("Finish() error = %v", err)
+	}
+	return append(events, evs...)
+}
+
+func TestClaudeStreamEncoder(t *testing.T) {
+	events := encodeAll(t, provider.ProtocolClaude, toolCallChunks())
+
+	var types []string
+	for _, ev := range events {
+		types = append(types, ev.Type)
+	}
+	want := []string{
+		"message_start",
+		"content_block_start", "content_block_delta", "content_block_stop", // thinking
+		"content_block_start", "content_block_delta", "content_block_delta", "content_block_stop", // text
+		"content_block_start", "content_block_delta", "content_block_delta", "content_block_stop", // tool_use
+		"message_delta", "message_stop",
+	}
+	if strings.Join(types, ",") != strings.Join(want, ",") {
+		t.Fatalf("event types = %v\nwant %v", types, want)
+	}
+
+	var toolStart struct {
+		Index        int `json:"index"`
+		ContentBlock struct {
+			Type string `json:"type"`
+			ID   string `json:"id"`
+			Name string `json:"name"`
+		} `json:"content_block"`
+	}
+	json.Unmarshal([]byte(events[8].Data), &toolStart)
+	if toolStart.Index != 2 || toolStart.ContentBlock.Type != "tool_use" || toolStart.ContentBlock.ID != "call_1" {
+		t.Errorf("tool_use block start = %s", events[8].Data)
+	}
+
+	var messageDelta struct {
+		Delta struct {
+			StopReason string `json:"stop_reason"`
+		} `json:"delta"`
+		Usage struct {
+			OutputTokens int `json:"output_tokens"`
+		} `json:"usage"`
+	}
+	json.Unmarshal([]byte(events[12].Data), &messageDelta)
+	if messageDelta.Delta.StopReason != "tool_use" || messageDelta.Usage.OutputTokens != 6 {
+		t.Errorf("message_delta = %s", events[12].Data)
+	}
+}
+
+func TestClaudeStreamEncoderEmptyStream(t *testing.T) {
+	events := encodeAll(t, provider.ProtocolClaude, nil)
+	if len(events) != 3 || events[0].Type != "message_start" || events[2].Type != "message_stop" {
+		t.Errorf("unexpected events for empty stream: %+v", events)
+	}
+}
+
+func TestGeminiStreamEncoder(t *testing.T) {
+	events := encodeAll(t, provider.ProtocolGemini, toolCallChunks())
+	if len(events) != 4 {
+		t.Fatalf("got %d events, want 4: %+v", len(events), events)
+	}
+
+	var first gemini.GeminiResponse
+	json.Unmarshal([]byte(events[0].Data), &first)
+	if part := first.Candidates[0].Content.Parts[0]; !part.Thought || part.Text != "Need the weather." {
+		t.Errorf("thought part = %+v", part)
+	}
+
+	var last gemini.GeminiResponse
+	if err := json.Unmarshal([]byte(events[3].Data), &last); err != nil {
+		t.Fatalf("invalid final event %s: %v", events[3].Data, err)
+	}
+	call := last.Candidates[0].Content.Parts[0].FunctionCall
+	if call == nil || call.Name != "get_weather" || call.Args["city"] != "Paris" {
+		t.Errorf("function call = %+v", call)
+	}
+	if last.Candidates[0].FinishReason != "STOP" || last.UsageMetadata == nil || last.UsageMetadata.TotalTokenCount != 16 {
+		t.Errorf("final event = %s", events[3].Data)
+	}
+}
+
+func TestOpenAIStreamEncoder(t *testing.T) {
+	events := encodeAll(t, provider.ProtocolOpenAI, toolCallChunks())
+	if len(events) != 7 || events[6].Data != "[DONE]" {
+		t.Errorf("unexpected events: %+v", events)
+	}
+}
@@ -0,0 +1,98 @@
+// Package converter provides format conversion between different LLM API formats
+package converter
+
+import (
+	"github.com/sunbankio/qwencoder-proxy/provider"
+)
+
+// Translator converts requests and responses directly between two native protocols
+type Translator interface {
+	// Source returns the protocol the client speaks
+	Source() provider.ProtocolType
+
+	// Target returns the protocol the upstream provider speaks
+	Target() provider.ProtocolType
+
+	// TranslateRequest converts a source-protocol request into a target-protocol request
+	TranslateRequest(req interface{}) (interface{}, error)
+
+	// TranslateResponse converts a target-protocol response back into a source-protocol response
+	TranslateResponse(resp interface{}, model string) (interface{}, error)
+}
+
+// protocolPair keys the translator registry
+type protocolPair struct {
+	source provider.ProtocolType
+	target provider.ProtocolType
+}
+
+// RegisterTranslator adds a direct translator to the factory
+func (f *Factory) RegisterTranslator(t Translator) {
+	f.translators[protocolPair{source: t.Source(), target: t.Target()}] = t
+}
+
+// GetTranslator returns a translator from source to target protocol.
+// A directly registered translator is preferred; otherwise the translation
+// is composed through the OpenAI format using the registered converters.
+func (f *Factory) GetTranslator(source, target provider.ProtocolType) (Translator, error) {
+	if t, ok := f.translators[protocolPair{source: source, target: target}]; ok {
+		return t, nil
+	}
+
+	if source == target {
+		return &identityTranslator{protocol: source}, nil
+	}
+
+	sourceConv, err := f.Get(source)
+	if err != nil {
+		return nil, err
+	}
+	targetConv, err := f.Get(target)
+	if err != nil {
+		return nil, err
+	}
+	return &composedTranslator{source: sourceConv, target: targetConv}, nil
+}
+
+// identityTranslator passes requests and responses through unchanged
+type identityTranslator struct {
+	protocol provider.ProtocolType
+}
+
+func (t *identityTranslator) Source() provider.ProtocolType { return t.protocol }
+func (t *identityTranslator) Target() provider.ProtocolType { return t.protocol }
+
+func (t *identityTranslator) TranslateRequest(req interface{}) (interface{}, error) {
+	return req, nil
+}
+
+func (t *identityTranslator) TranslateResponse(resp interface{}, model string) (interface{}, error) {
+	return resp, nil
+}
+
+// composedTranslator translates through the OpenAI format as an intermediate hub
+type composedTranslator struct {
+	source Converter
+	target Converter
+}
+
+func (t *composedTranslator) Source() provider.ProtocolType { return t.source.Protocol() }
+func (t *composedTranslator) Target() provider.ProtocolType { return t.target.Protocol() }
+
+// TranslateRequest converts source -> OpenAI -> target
+func (t *composedTranslator) TranslateRequest(req interface{}) (interface{}, error) {
+	openAIReq, err := t.source.ToOpenAIRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	return t.target.FromOpenAIRequest(openAIReq)
+}
+
+// TranslateResponse converts target -> OpenAI -> source
+func (t *composedTranslator) TranslateResponse(resp interface{}, model string) (interface{}, error) {
+	openAIResp, err := t.target.ToOpenAIResponse(resp, model)
+	if err != nil {
+		return nil, err
+	}
+	return t.source.FromOpenAIResponse(openAIResp)
+}
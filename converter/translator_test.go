@@ -0,0 +1,124 @@
+package converter
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/sunbankio/qwencoder-proxy/provider"
+	"github.com/sunbankio/qwencoder-proxy/provider/gemini"
+	"github.com/sunbankio/qwencoder-proxy/provider/kiro"
+)
+
+func TestGetTranslator(t *testing.T) {
+	f := NewFactory()
+
+	tests := []struct {
+		name     string
+		source   provider.ProtocolType
+		target   provider.ProtocolType
+		wantType string
+	}{
+		{"claude to gemini is direct", provider.ProtocolClaude, provider.ProtocolGemini, "*converter.ClaudeToGeminiTranslator"},
+		{"gemini to claude is direct", provider.ProtocolGemini, provider.ProtocolClaude, "*converter.GeminiToClaudeTranslator"},
+		{"same protocol is identity", provider.ProtocolGemini, provider.ProtocolGemini, "*converter.identityTranslator"},
+		{"claude to openai is composed", provider.ProtocolClaude, provider.ProtocolOpenAI, "*converter.composedTranslator"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr, err := f.GetTranslator(tt.source, tt.target)
+			if err != nil {
+				t.Fatalf("GetTranslator() error = %v", err)
+			}
+			if got := fmt.Sprintf("%T", tr); got != tt.wantType {
+				t.Errorf("GetTranslator() type = %s, want %s", got, tt.wantType)
+			}
+		})
+	}
+}
+
+func TestClaudeToGeminiPreservesToolsAndThinking(t *testing.T) {
+	req := &kiro.ClaudeRequest{
+		Model:     "gemini-2.5-pro",
+		MaxTokens: 1024,
+		System:    "be brief",
+		Thinking:  &kiro.Thinking{Type: "enabled", BudgetTokens: 2048},
+		Messages: []kiro.Message{
+			{Role: "user", Content: "weather in Paris?"},
+			{Role: "assistant", Content: []interface{}{
+				map[string]interface{}{"type": "thinking", "thinking": "need a tool", "signature": "sig-1"},
+				map[string]interface{}{"type": "tool_use", "id": "toolu_1", "name": "get_weather", "input": map[string]interface{}{"city": "Paris"}},
+			}},
+			{Role: "user", Content: []interface{}{
+				map[string]interface{}{"type": "tool_result", "tool_use_id": "toolu_1", "content": "sunny"},
+			}},
+		},
+	}
+
+	out, err := NewClaudeToGeminiTranslator().TranslateRequest(req)
+	if err != nil {
+		t.Fatalf("TranslateRequest() error = %v", err)
+	}
+	geminiReq := out.(*gemini.GeminiRequest)
+
+	if geminiReq.SystemInstruction == nil || geminiReq.SystemInstruction.Parts[0].Text != "be brief" {
+		t.Errorf("system instruction not preserved: %+v", geminiReq.SystemInstruction)
+	}
+	if cfg := geminiReq.GenerationConfig; cfg == nil || cfg.ThinkingConfig == nil || cfg.ThinkingConfig.ThinkingBudget == nil || *cfg.ThinkingConfig.ThinkingBudget != 2048 {
+		t.Errorf("thinking budget not preserved: %+v", geminiReq.GenerationConfig)
+	}
+	if len(geminiReq.Contents) != 3 {
+		t.Fatalf("expected 3 contents, got %d", len(geminiReq.Contents))
+	}
+
+	model := geminiReq.Contents[1]
+	if model.Role != "model" || len(model.Parts) != 2 {
+		t.Fatalf("unexpected model content: %+v", model)
+	}
+	if !model.Parts[0].Thought || model.Parts[0].ThoughtSignature != "sig-1" {
+		t.Errorf("thinking block not preserved: %+v", model.Parts[0])
+	}
+	if call := model.Parts[1].FunctionCall; call == nil || call.ID != "toolu_1" || call.Args["city"] != "Paris" {
+		t.Errorf("tool_use not preserved: %+v", model.Parts[1])
+	}
+
+	result := geminiReq.Contents[2].Parts[0].FunctionResponse
+	if result == nil || result.ID != "toolu_1" || result.Name != "get_weather" {
+		t.Errorf("tool_result not paired with its call: %+v", result)
+	}
+}
+
+func TestGeminiToClaudeResponse(t *testing.T) {
+	resp := &gemini.GeminiResponse{
+		Candidates: []gemini.Candidate{{
+			Content: &gemini.Content{Role: "model", Parts: []gemini.Part{
+				{Text: "thinking...", Thought: true, ThoughtSignature: "sig-2"},
+				{FunctionCall: &gemini.FunctionCall{ID: "call_1", Name: "lookup", Args: map[string]interface{}{"q": "x"}}},
+			}},
+			FinishReason: "STOP",
+		}},
+		UsageMetadata: &gemini.UsageMetadata{PromptTokenCount: 10, CandidatesTokenCount: 5, TotalTokenCount: 15, CachedContentTokenCount: 4},
+	}
+
+	out, err := NewClaudeToGeminiTranslator().TranslateResponse(resp, "gemini-2.5-pro")
+	if err != nil {
+		t.Fatalf("TranslateResponse() error = %v", err)
+	}
+	claudeResp := out.(*kiro.ClaudeResponse)
+
+	if claudeResp.StopReason != "tool_use" {
+		t.Errorf("StopReason = %s, want tool_use", claudeResp.StopReason)
+	}
+	if len(claudeResp.Content) != 2 {
+		t.Fatalf("expected 2 content blocks, got %d", len(claudeResp.Content))
+	}
+	if b := claudeResp.Content[0]; b.Type != "thinking" || b.Signature != "sig-2" {
+		t.Errorf("thought part not preserved: %+v", b)
+	}
+	if b := claudeResp.Content[1]; b.Type != "tool_use" || b.ID != "call_1" || b.Name != "lookup" {
+		t.Errorf("function call not preserved: %+v", b)
+	}
+	if claudeResp.Usage == nil || claudeResp.Usage.CacheReadInputTokens != 4 {
+		t.Errorf("cache usage not preserved: %+v", claudeResp.Usage)
+	}
+}
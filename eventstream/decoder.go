@@ -0,0 +1,164 @@
+// Package eventstream implements the AWS vnd.amazon.eventstream binary framing
+// used by Kiro (CodeWhisperer) and Bedrock streaming responses.
+//
+// Each message on the wire is laid out as:
+//
+//	total length  (4 bytes, big endian)
+//	headers length (4 bytes, big endian)
+//	prelude CRC32 (4 bytes, over the first 8 bytes)
+//	headers       (headers length bytes)
+//	payload       (total length - headers length - 16 bytes)
+//	message CRC32 (4 bytes, over everything before it)
+package eventstream
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"iter"
+)
+
+const (
+	preludeLen = 12
+	crcLen     = 4
+
+	// minMessageLen is the size of a message with no headers and no payload
+	minMessageLen = preludeLen + crcLen
+
+	// MaxMessageLen is the largest message the decoder accepts (16 MiB, the AWS limit)
+	MaxMessageLen = 16 * 1024 * 1024
+
+	// MaxHeadersLen is the largest headers section the decoder accepts (128 KiB, the AWS limit)
+	MaxHeadersLen = 128 * 1024
+)
+
+var (
+	// ErrPreludeChecksum is returned when the prelude CRC does not match
+	ErrPreludeChecksum = errors.New("eventstream: prelude checksum mismatch")
+
+	// ErrMessageChecksum is returned when the message CRC does not match
+	ErrMessageChecksum = errors.New("eventstream: message checksum mismatch")
+
+	// ErrMessageTooLarge is returned when a message exceeds MaxMessageLen or MaxHeadersLen
+	ErrMessageTooLarge = errors.New("eventstream: message too large")
+)
+
+// Message is a single decoded event-stream message
+type Message struct {
+	Headers Headers
+	Payload []byte
+}
+
+// MessageType returns the :message-type header ("event", "exception" or "error")
+func (m *Message) MessageType() string {
+	return m.Headers.String(":message-type")
+}
+
+// EventType returns the :event-type header
+func (m *Message) EventType() string {
+	return m.Headers.String(":event-type")
+}
+
+// Err returns an *ExceptionError if the message reports an exception or error, nil otherwise
+func (m *Message) Err() error {
+	switch m.MessageType() {
+	case "exception":
+		return &ExceptionError{
+			Type:    m.Headers.String(":exception-type"),
+			Message: exceptionMessage(m.Payload),
+		}
+	case "error":
+		return &ExceptionError{
+			Type:    m.Headers.String(":error-code"),
+			Message: m.Headers.String(":error-message"),
+		}
+	}
+	return nil
+}
+
+// ExceptionError is an exception or error delivered in-band on the stream
+type ExceptionError struct {
+	Type    string
+	Message string
+}
+
+func (e *ExceptionError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("eventstream exception: %s", e.Type)
+	}
+	return fmt.Sprintf("eventstream exception: %s: %s", e.Type, e.Message)
+}
+
+// Decoder reads event-stream messages from an io.Reader
+type Decoder struct {
+	r       *bufio.Reader
+	prelude [preludeLen]byte
+}
+
+// NewDecoder creates a decoder reading from r
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: bufio.NewReader(r)}
+}
+
+// Decode reads the next message. It returns io.EOF when the stream ends cleanly
+// between messages and io.ErrUnexpectedEOF when it ends inside a message.
+func (d *Decoder) Decode() (*Message, error) {
+	if _, err := io.ReadFull(d.r, d.prelude[:]); err != nil {
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		return nil, fmt.Errorf("eventstream: reading prelude: %w", io.ErrUnexpectedEOF)
+	}
+
+	totalLen := binary.BigEndian.Uint32(d.prelude[0:4])
+	headersLen := binary.BigEndian.Uint32(d.prelude[4:8])
+	preludeCRC := binary.BigEndian.Uint32(d.prelude[8:12])
+
+	if crc32.ChecksumIEEE(d.prelude[:8]) != preludeCRC {
+		return nil, ErrPreludeChecksum
+	}
+	if totalLen > MaxMessageLen || headersLen > MaxHeadersLen {
+		return nil, ErrMessageTooLarge
+	}
+	if totalLen < minMessageLen || headersLen > totalLen-minMessageLen {
+		return nil, fmt.Errorf("eventstream: invalid lengths (total %d, headers %d)", totalLen, headersLen)
+	}
+
+	rest := make([]byte, totalLen-preludeLen)
+	if _, err := io.ReadFull(d.r, rest); err != nil {
+		return nil, fmt.Errorf("eventstream: reading message: %w", io.ErrUnexpectedEOF)
+	}
+
+	body := rest[:len(rest)-crcLen]
+	messageCRC := binary.BigEndian.Uint32(rest[len(rest)-crcLen:])
+	crc := crc32.Update(crc32.ChecksumIEEE(d.prelude[:]), crc32.IEEETable, body)
+	if crc != messageCRC {
+		return nil, ErrMessageChecksum
+	}
+
+	headers, err := decodeHeaders(body[:headersLen])
+	if err != nil {
+		return nil, err
+	}
+
+	return &Message{Headers: headers, Payload: body[headersLen:]}, nil
+}
+
+// All returns an iterator over the remaining messages. Iteration stops after the
+// first error, which is yielded with a nil message; a clean end of stream yields no error.
+func (d *Decoder) All() iter.Seq2[*Message, error] {
+	return func(yield func(*Message, error) bool) {
+		for {
+			msg, err := d.Decode()
+			if err == io.EOF {
+				return
+			}
+			if !yield(msg, err) || err != nil {
+				return
+			}
+		}
+	}
+}
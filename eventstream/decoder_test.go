@@ -0,0 +1,189 @@
+package eventstream
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+func encodeMessages(t *testing.T, msgs ...*Message) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	for _, msg := range msgs {
+		if err := Encode(&buf, msg); err != nil {
+			t.Fatalf("Encode() error = %v", err)
+		}
+	}
+	return buf.Bytes()
+}
+
+func eventMessage(eventType, payload string) *Message {
+	return &Message{
+		Headers: Headers{
+			StringHeader(":message-type", "event"),
+			StringHeader(":event-type", eventType),
+			StringHeader(":content-type", "application/json"),
+		},
+		Payload: []byte(payload),
+	}
+}
+
+func TestDecodeRoundTripAllHeaderTypes(t *testing.T) {
+	ts := time.UnixMilli(1700000000123).UTC()
+	uuid := [16]byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+	want := &Message{
+		Headers: Headers{
+			{Name: "true", Type: HeaderBoolTrue, Value: true},
+			{Name: "false", Type: HeaderBoolFalse, Value: false},
+			{Name: "byte", Type: HeaderByte, Value: int8(-7)},
+			{Name: "short", Type: HeaderShort, Value: int16(-300)},
+			{Name: "int", Type: HeaderInteger, Value: int32(70000)},
+			{Name: "long", Type: HeaderLong, Value: int64(1 << 40)},
+			{Name: "bytes", Type: HeaderBytes, Value: []byte{0, 1, 2}},
+			{Name: "string", Type: HeaderString, Value: "hello"},
+			{Name: "ts", Type: HeaderTimestamp, Value: ts},
+			{Name: "uuid", Type: HeaderUUID, Value: uuid},
+		},
+		Payload: []byte(`{"content":"hi"}`),
+	}
+
+	dec := NewDecoder(bytes.NewReader(encodeMessages(t, want)))
+	got, err := dec.Decode()
+	if err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+
+	if string(got.Payload) != string(want.Payload) {
+		t.Errorf("Payload = %s, want %s", got.Payload, want.Payload)
+	}
+	if len(got.Headers) != len(want.Headers) {
+		t.Fatalf("got %d headers, want %d", len(got.Headers), len(want.Headers))
+	}
+	for i, h := range want.Headers {
+		g := got.Headers[i]
+		if g.Name != h.Name || g.Type != h.Type {
+			t.Errorf("header %d = %s/%d, want %s/%d", i, g.Name, g.Type, h.Name, h.Type)
+			continue
+		}
+		switch v := h.Value.(type) {
+		case []byte:
+			if !bytes.Equal(g.Value.([]byte), v) {
+				t.Errorf("header %s = %v, want %v", h.Name, g.Value, v)
+			}
+		case time.Time:
+			if !g.Value.(time.Time).Equal(v) {
+				t.Errorf("header %s = %v, want %v", h.Name, g.Value, v)
+			}
+		default:
+			if g.Value != h.Value {
+				t.Errorf("header %s = %v, want %v", h.Name, g.Value, h.Value)
+			}
+		}
+	}
+
+	if _, err := dec.Decode(); err != io.EOF {
+		t.Errorf("Decode() at end = %v, want io.EOF", err)
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	frame := encodeMessages(t, eventMessage("assistantResponseEvent", `{"content":"hi"}`))
+
+	corrupt := func(offset int) []byte {
+		b := append([]byte(nil), frame...)
+		b[offset] ^= 0xFF
+		return b
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"prelude checksum", corrupt(9), ErrPreludeChecksum},
+		{"length corrupts prelude checksum", corrupt(2), ErrPreludeChecksum},
+		{"payload corrupts message checksum", corrupt(len(frame) - 6), ErrMessageChecksum},
+		{"message checksum", corrupt(len(frame) - 1), ErrMessageChecksum},
+		{"truncated prelude", frame[:6], io.ErrUnexpectedEOF},
+		{"truncated body", frame[:len(frame)-3], io.ErrUnexpectedEOF},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewDecoder(bytes.NewReader(tt.data)).Decode()
+			if !errors.Is(err, tt.want) {
+				t.Errorf("Decode() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestMessageErr(t *testing.T) {
+	exception := &Message{
+		Headers: Headers{
+			StringHeader(":message-type", "exception"),
+			StringHeader(":exception-type", "ThrottlingException"),
+		},
+		Payload: []byte(`{"message":"Too many requests"}`),
+	}
+	errorMsg := &Message{
+		Headers: Headers{
+			StringHeader(":message-type", "error"),
+			StringHeader(":error-code", "InternalFailure"),
+			StringHeader(":error-message", "boom"),
+		},
+	}
+
+	tests := []struct {
+		name     string
+		msg      *Message
+		wantType string
+		wantMsg  string
+	}{
+		{"exception", exception, "ThrottlingException", "Too many requests"},
+		{"error", errorMsg, "InternalFailure", "boom"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var exc *ExceptionError
+			if !errors.As(tt.msg.Err(), &exc) {
+				t.Fatalf("Err() = %v, want *ExceptionError", tt.msg.Err())
+			}
+			if exc.Type != tt.wantType || exc.Message != tt.wantMsg {
+				t.Errorf("Err() = %+v, want %s/%s", exc, tt.wantType, tt.wantMsg)
+			}
+		})
+	}
+
+	if err := eventMessage("assistantResponseEvent", `{}`).Err(); err != nil {
+		t.Errorf("event message Err() = %v, want nil", err)
+	}
+}
+
+func TestAllStopsAtFirstError(t *testing.T) {
+	data := encodeMessages(t,
+		eventMessage("assistantResponseEvent", `{"content":"a"}`),
+		eventMessage("assistantResponseEvent", `{"content":"b"}`),
+	)
+	data[len(data)-1] ^= 0xFF
+
+	var events []string
+	var gotErr error
+	for msg, err := range NewDecoder(bytes.NewReader(data)).All() {
+		if err != nil {
+			gotErr = err
+			continue
+		}
+		events = append(events, string(msg.Payload))
+	}
+
+	if len(events) != 1 || events[0] != `{"content":"a"}` {
+		t.Errorf("events = %v, want only the first message", events)
+	}
+	if !errors.Is(gotErr, ErrMessageChecksum) {
+		t.Errorf("error = %v, want ErrMessageChecksum", gotErr)
+	}
+}
@@ -0,0 +1,90 @@
+package eventstream
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"time"
+)
+
+// Encode writes msg to w in event-stream framing
+func Encode(w io.Writer, msg *Message) error {
+	var headers bytes.Buffer
+	for _, h := range msg.Headers {
+		if err := encodeHeader(&headers, h); err != nil {
+			return err
+		}
+	}
+
+	totalLen := minMessageLen + headers.Len() + len(msg.Payload)
+	if totalLen > MaxMessageLen || headers.Len() > MaxHeadersLen {
+		return ErrMessageTooLarge
+	}
+
+	buf := make([]byte, 0, totalLen)
+	buf = binary.BigEndian.AppendUint32(buf, uint32(totalLen))
+	buf = binary.BigEndian.AppendUint32(buf, uint32(headers.Len()))
+	buf = binary.BigEndian.AppendUint32(buf, crc32.ChecksumIEEE(buf[:8]))
+	buf = append(buf, headers.Bytes()...)
+	buf = append(buf, msg.Payload...)
+	buf = binary.BigEndian.AppendUint32(buf, crc32.ChecksumIEEE(buf))
+
+	_, err := w.Write(buf)
+	return err
+}
+
+// encodeHeader appends a single header in wire format
+func encodeHeader(buf *bytes.Buffer, h Header) error {
+	if len(h.Name) == 0 || len(h.Name) > 255 {
+		return fmt.Errorf("eventstream: invalid header name length %d", len(h.Name))
+	}
+	buf.WriteByte(byte(len(h.Name)))
+	buf.WriteString(h.Name)
+	buf.WriteByte(byte(h.Type))
+
+	var scratch [8]byte
+	switch h.Type {
+	case HeaderBoolTrue, HeaderBoolFalse:
+	case HeaderByte:
+		v, _ := h.Value.(int8)
+		buf.WriteByte(byte(v))
+	case HeaderShort:
+		v, _ := h.Value.(int16)
+		binary.BigEndian.PutUint16(scratch[:], uint16(v))
+		buf.Write(scratch[:2])
+	case HeaderInteger:
+		v, _ := h.Value.(int32)
+		binary.BigEndian.PutUint32(scratch[:], uint32(v))
+		buf.Write(scratch[:4])
+	case HeaderLong:
+		v, _ := h.Value.(int64)
+		binary.BigEndian.PutUint64(scratch[:], uint64(v))
+		buf.Write(scratch[:8])
+	case HeaderTimestamp:
+		v, _ := h.Value.(time.Time)
+		binary.BigEndian.PutUint64(scratch[:], uint64(v.UnixMilli()))
+		buf.Write(scratch[:8])
+	case HeaderUUID:
+		v, _ := h.Value.([16]byte)
+		buf.Write(v[:])
+	case HeaderBytes, HeaderString:
+		var value []byte
+		switch v := h.Value.(type) {
+		case string:
+			value = []byte(v)
+		case []byte:
+			value = v
+		}
+		if len(value) > 0xFFFF {
+			return fmt.Errorf("eventstream: header %q value too long", h.Name)
+		}
+		binary.BigEndian.PutUint16(scratch[:], uint16(len(value)))
+		buf.Write(scratch[:2])
+		buf.Write(value)
+	default:
+		return fmt.Errorf("eventstream: unknown type %d for header %q", h.Type, h.Name)
+	}
+	return nil
+}
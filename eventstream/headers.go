@@ -0,0 +1,161 @@
+package eventstream
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// HeaderType identifies the wire type of a header value
+type HeaderType byte
+
+// Header value types defined by the event-stream specification
+const (
+	HeaderBoolTrue  HeaderType = 0
+	HeaderBoolFalse HeaderType = 1
+	HeaderByte      HeaderType = 2
+	HeaderShort     HeaderType = 3
+	HeaderInteger   HeaderType = 4
+	HeaderLong      HeaderType = 5
+	HeaderBytes     HeaderType = 6
+	HeaderString    HeaderType = 7
+	HeaderTimestamp HeaderType = 8
+	HeaderUUID      HeaderType = 9
+)
+
+// Header is a single typed message header.
+// Value holds a bool, int8, int16, int32, int64, []byte, string, time.Time or [16]byte.
+type Header struct {
+	Name  string
+	Type  HeaderType
+	Value interface{}
+}
+
+// Headers is the ordered list of headers on a message
+type Headers []Header
+
+// Get returns the header with the given name
+func (h Headers) Get(name string) (Header, bool) {
+	for _, header := range h {
+		if header.Name == name {
+			return header, true
+		}
+	}
+	return Header{}, false
+}
+
+// String returns the named header as a string, or "" if it is absent or not a string
+func (h Headers) String(name string) string {
+	header, ok := h.Get(name)
+	if !ok {
+		return ""
+	}
+	switch v := header.Value.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	}
+	return ""
+}
+
+// StringHeader creates a string-typed header
+func StringHeader(name, value string) Header {
+	return Header{Name: name, Type: HeaderString, Value: value}
+}
+
+// decodeHeaders parses the headers section of a message
+func decodeHeaders(b []byte) (Headers, error) {
+	var headers Headers
+	for len(b) > 0 {
+		nameLen := int(b[0])
+		if nameLen == 0 || len(b) < 1+nameLen+1 {
+			return nil, fmt.Errorf("eventstream: truncated header name")
+		}
+		name := string(b[1 : 1+nameLen])
+		typ := HeaderType(b[1+nameLen])
+		b = b[2+nameLen:]
+
+		var value interface{}
+		var n int
+		switch typ {
+		case HeaderBoolTrue:
+			value, n = true, 0
+		case HeaderBoolFalse:
+			value, n = false, 0
+		case HeaderByte:
+			n = 1
+			if len(b) >= n {
+				value = int8(b[0])
+			}
+		case HeaderShort:
+			n = 2
+			if len(b) >= n {
+				value = int16(binary.BigEndian.Uint16(b))
+			}
+		case HeaderInteger:
+			n = 4
+			if len(b) >= n {
+				value = int32(binary.BigEndian.Uint32(b))
+			}
+		case HeaderLong:
+			n = 8
+			if len(b) >= n {
+				value = int64(binary.BigEndian.Uint64(b))
+			}
+		case HeaderTimestamp:
+			n = 8
+			if len(b) >= n {
+				value = time.UnixMilli(int64(binary.BigEndian.Uint64(b))).UTC()
+			}
+		case HeaderUUID:
+			n = 16
+			if len(b) >= n {
+				var uuid [16]byte
+				copy(uuid[:], b)
+				value = uuid
+			}
+		case HeaderBytes, HeaderString:
+			if len(b) < 2 {
+				return nil, fmt.Errorf("eventstream: truncated value for header %q", name)
+			}
+			valueLen := int(binary.BigEndian.Uint16(b))
+			b = b[2:]
+			n = valueLen
+			if len(b) >= n {
+				if typ == HeaderString {
+					value = string(b[:n])
+				} else {
+					value = append([]byte(nil), b[:n]...)
+				}
+			}
+		default:
+			return nil, fmt.Errorf("eventstream: unknown type %d for header %q", typ, name)
+		}
+
+		if len(b) < n {
+			return nil, fmt.Errorf("eventstream: truncated value for header %q", name)
+		}
+		b = b[n:]
+		headers = append(headers, Header{Name: name, Type: typ, Value: value})
+	}
+	return headers, nil
+}
+
+// exceptionMessage extracts a human readable message from an exception payload
+func exceptionMessage(payload []byte) string {
+	var body struct {
+		Message      string `json:"message"`
+		MessageUpper string `json:"Message"`
+	}
+	if err := json.Unmarshal(payload, &body); err == nil {
+		if body.Message != "" {
+			return body.Message
+		}
+		if body.MessageUpper != "" {
+			return body.MessageUpper
+		}
+	}
+	return string(payload)
+}
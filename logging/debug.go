@@ -1,4 +0,0 @@
-package logging
-
-// IsDebugMode is a global flag to control debug logging
-var IsDebugMode bool
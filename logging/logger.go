@@ -1,138 +1,224 @@
 package logging
 
 import (
+	"context"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
 	"os"
-)
-
-// ANSI color codes
-const (
-	Reset   = "\033[0m"
-	Red     = "\033[31m"
-	Green   = "\033[32m"
-	Yellow  = "\033[33m"
-	Blue    = "\033[34m"
-	Magenta = "\033[35m"
-	Cyan    = "\033[36m"
-	White   = "\033[37m"
-	Dim     = "\033[2m"
-	Bold    = "\033[1m"
-)
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
-// Log tags with emojis
-const (
-	StreamTag        = "▶ [ST]"
-	NonStreamTag     = "▶ [NS]"
-	DoneTag          = "■ [ST]"
-	DoneNonStreamTag = "■ [NS]"
-	Separator        = "===================================="
-	DebugTag         = "🐛 [DBG]" // New debug tag
+	"github.com/sunbankio/qwencoder-proxy/config"
 )
 
-// Logger wraps the standard logger with color support
+// Logger writes leveled log lines for one subsystem through log/slog
 type Logger struct {
-	*log.Logger
+	subsystem string
+	ctx       context.Context // Request the lines belong to, if any
 }
 
-// NewLogger creates a new Logger instance
-func NewLogger() *Logger {
-	return &Logger{
-		Logger: log.New(os.Stdout, "", log.LstdFlags),
-	}
+// NewLogger creates a Logger for a subsystem such as "proxy" or "kiro", whose level
+// can be changed at runtime with SetLevel
+func NewLogger(subsystem string) *Logger {
+	levelOf(subsystem) // Register the subsystem so Levels lists it
+	return &Logger{subsystem: subsystem, ctx: context.Background()}
 }
 
-// StreamLog logs streaming requests with blue color
-func (l *Logger) StreamLog(format string, v ...interface{}) {
-	message := fmt.Sprintf(format, v...)
-	l.Printf("%s%s%s %s", Blue, StreamTag, Reset, message)
+// WithContext returns a Logger that adds the request ID, provider and model of the
+// request ctx belongs to, as set by WithRequestID and SetRoute, to every line
+func (l *Logger) WithContext(ctx context.Context) *Logger {
+	return &Logger{subsystem: l.subsystem, ctx: ctx}
 }
 
-// NonStreamLog logs non-streaming requests with cyan color
-func (l *Logger) NonStreamLog(format string, v ...interface{}) {
-	message := fmt.Sprintf(format, v...)
-	l.Printf("%s%s%s %s", Cyan, NonStreamTag, Reset, message)
+// Enabled reports whether lines at level are written for the logger's subsystem
+func (l *Logger) Enabled(level slog.Level) bool {
+	return level >= levelOf(l.subsystem).Level()
 }
 
-// DoneLog logs streaming completions with green color
-func (l *Logger) DoneLog(format string, v ...interface{}) {
-	message := fmt.Sprintf(format, v...)
-	l.Printf("%s%s%s %s", Green, DoneTag, Reset, message)
+// DebugLog logs debug messages, such as request and response bodies
+func (l *Logger) DebugLog(format string, v ...interface{}) {
+	l.log(slog.LevelDebug, format, v...)
 }
 
-// DoneNonStreamLog logs non-streaming completions with green color
-func (l *Logger) DoneNonStreamLog(format string, v ...interface{}) {
-	message := fmt.Sprintf(format, v...)
-	l.Printf("%s%s%s %s", Green, DoneNonStreamTag, Reset, message)
+// InfoLog logs informational messages
+func (l *Logger) InfoLog(format string, v ...interface{}) {
+	l.log(slog.LevelInfo, format, v...)
 }
 
-// SeparatorLog prints a dimmed separator line
-func (l *Logger) SeparatorLog() {
-	l.Printf("%s%s%s", Dim, Separator, Reset)
+// WarningLog logs warnings
+func (l *Logger) WarningLog(format string, v ...interface{}) {
+	l.log(slog.LevelWarn, format, v...)
 }
 
-// ErrorLog logs errors with red color
+// ErrorLog logs errors
 func (l *Logger) ErrorLog(format string, v ...interface{}) {
-	message := fmt.Sprintf(format, v...)
-	l.Printf("%s⚠️  [ERROR] %s%s", Red, Reset, message)
+	l.log(slog.LevelError, format, v...)
 }
 
-// WarningLog logs warnings with yellow color
-func (l *Logger) WarningLog(format string, v ...interface{}) {
-	message := fmt.Sprintf(format, v...)
-	l.Printf("%s⚠️  [WARN] %s%s", Yellow, Reset, message)
+// FatalLog logs an error and exits the process
+func (l *Logger) FatalLog(format string, v ...interface{}) {
+	l.log(slog.LevelError, format, v...)
+	os.Exit(1)
 }
 
-// DebugLog logs debug messages with magenta color if debug mode is enabled
-func (l *Logger) DebugLog(format string, v ...interface{}) {
-	if IsDebugMode {
-		message := fmt.Sprintf(format, v...)
-		l.Printf("%s%s%s %s", Magenta, DebugTag, Reset, message)
+func (l *Logger) log(level slog.Level, format string, v ...interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+	record := slog.NewRecord(time.Now(), level, fmt.Sprintf(format, v...), 0)
+	record.AddAttrs(slog.String("subsystem", l.subsystem))
+	if fields, ok := l.ctx.Value(requestFieldsKey{}).(*requestFields); ok {
+		record.AddAttrs(fields.attrs()...)
 	}
+	handler := current.Load().handler
+	handler.Handle(l.ctx, record)
 }
 
-// DebugRawLog logs raw debug messages without extra formatting if debug mode is enabled
-func (l *Logger) DebugRawLog(format string, v ...interface{}) {
-	if IsDebugMode {
-		message := fmt.Sprintf(format, v...)
-		l.Printf("%s%s", Dim, message)
+// output is the configured slog handler; levels are checked by Logger, so it accepts all
+type output struct {
+	handler slog.Handler
+}
+
+var current atomic.Pointer[output]
+
+func init() {
+	current.Store(&output{handler: newHandler(os.Stdout, "text")})
+}
+
+func newHandler(w io.Writer, format string) slog.Handler {
+	opts := &slog.HandlerOptions{Level: slog.LevelDebug}
+	if format == "json" {
+		return slog.NewJSONHandler(w, opts)
 	}
+	return slog.NewTextHandler(w, opts)
 }
 
-// InfoLog logs informational messages with white color
-func (l *Logger) InfoLog(format string, v ...interface{}) {
-	message := fmt.Sprintf(format, v...)
-	l.Printf("%sℹ️  [INFO] %s%s", White, Reset, message)
-}
-
-// ProxyRequestLog logs proxy request information in a simplified format
-func (l *Logger) ProxyRequestLog(clientIP, method, path, userAgent string, model string, reqSize int, isStream bool, upstreamStatus, clientStatus int, respSize int, durationMs int64) {
-	// Use WarningLog when upstreamStatus and clientStatus are not both 200
-	if upstreamStatus != 200 || clientStatus != 200 {
-		l.WarningLog("%s %s %s | Model: %s | User-Agent: %s | ReqSize: %d | Stream: %t | Upstream: %d | Client: %d | RespSize: %d | Duration: %dms",
-			clientIP,
-			method,
-			path,
-			model,
-			userAgent,
-			reqSize,
-			isStream,
-			upstreamStatus,
-			clientStatus,
-			respSize,
-			durationMs)
-	} else {
-		l.InfoLog("%s %s %s | Model: %s | User-Agent: %s | ReqSize: %d | Stream: %t | Upstream: %d | Client: %d | RespSize: %d | Duration: %dms",
-			clientIP,
-			method,
-			path,
-			model,
-			userAgent,
-			reqSize,
-			isStream,
-			upstreamStatus,
-			clientStatus,
-			respSize,
-			durationMs)
+// Configure applies the logging settings and routes the standard log package through
+// the same handler
+func Configure(cfg config.LoggingConfig) error {
+	return configure(os.Stdout, cfg)
+}
+
+func configure(w io.Writer, cfg config.LoggingConfig) error {
+	switch cfg.Format {
+	case "", "text", "json":
+	default:
+		return fmt.Errorf("unknown log format %q, expected text or json", cfg.Format)
+	}
+	level := slog.LevelInfo
+	if cfg.Level != "" {
+		var err error
+		if level, err = ParseLevel(cfg.Level); err != nil {
+			return err
+		}
+	}
+	overrides := make(map[string]slog.Level, len(cfg.Levels))
+	for subsystem, value := range cfg.Levels {
+		subsystemLevel, err := ParseLevel(value)
+		if err != nil {
+			return fmt.Errorf("subsystem %s: %w", subsystem, err)
+		}
+		overrides[subsystem] = subsystemLevel
+	}
+
+	handler := newHandler(w, cfg.Format)
+	current.Store(&output{handler: handler})
+	slog.SetDefault(slog.New(handler).With("subsystem", "main"))
+	SetDefaultLevel(level)
+	for subsystem, subsystemLevel := range overrides {
+		SetLevel(subsystem, subsystemLevel)
+	}
+	return nil
+}
+
+// ParseLevel parses debug, info, warn (or warning) and error, case insensitively
+func ParseLevel(value string) (slog.Level, error) {
+	var level slog.Level
+	if strings.EqualFold(value, "warning") {
+		value = "warn"
 	}
+	if err := level.UnmarshalText([]byte(value)); err != nil {
+		return 0, fmt.Errorf("invalid log level %q, expected debug, info, warn or error", value)
+	}
+	return level, nil
+}
+
+// Subsystem levels. A subsystem without an override follows the default level.
+var (
+	levelsMu     sync.Mutex
+	defaultLevel = new(slog.LevelVar) // Zero value is info
+	subsystems   = make(map[string]*subsystemLevel)
+)
+
+// subsystemLevel is the level of one subsystem; override is nil when it follows the default
+type subsystemLevel struct {
+	override atomic.Pointer[slog.Level]
+}
+
+// Level implements slog.Leveler
+func (s *subsystemLevel) Level() slog.Level {
+	if level := s.override.Load(); level != nil {
+		return *level
+	}
+	return defaultLevel.Level()
+}
+
+func levelOf(subsystem string) *subsystemLevel {
+	levelsMu.Lock()
+	defer levelsMu.Unlock()
+	level, ok := subsystems[subsystem]
+	if !ok {
+		level = &subsystemLevel{}
+		subsystems[subsystem] = level
+	}
+	return level
+}
+
+// SetDefaultLevel sets the level of subsystems without an override
+func SetDefaultLevel(level slog.Level) {
+	defaultLevel.Set(level)
+}
+
+// SetLevel overrides the level of a subsystem
+func SetLevel(subsystem string, level slog.Level) {
+	levelOf(subsystem).override.Store(&level)
+}
+
+// ResetLevel makes a subsystem follow the default level again
+func ResetLevel(subsystem string) {
+	levelOf(subsystem).override.Store(nil)
+}
+
+// LevelSettings lists the default level and the effective level of each known subsystem
+type LevelSettings struct {
+	Default    string            `json:"default"`
+	Subsystems map[string]string `json:"subsystems"`
+	Overrides  []string          `json:"overrides"` // Subsystems not following the default
+}
+
+// Levels returns the current level settings
+func Levels() LevelSettings {
+	levelsMu.Lock()
+	defer levelsMu.Unlock()
+	settings := LevelSettings{
+		Default:    levelName(defaultLevel.Level()),
+		Subsystems: make(map[string]string, len(subsystems)),
+		Overrides:  []string{},
+	}
+	for name, level := range subsystems {
+		settings.Subsystems[name] = levelName(level.Level())
+		if level.override.Load() != nil {
+			settings.Overrides = append(settings.Overrides, name)
+		}
+	}
+	sort.Strings(settings.Overrides)
+	return settings
+}
+
+func levelName(level slog.Level) string {
+	return strings.ToLower(level.String())
 }
@@ -1,23 +1,155 @@
 package logging
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"log/slog"
+	"os"
+	"strings"
 	"testing"
+
+	"github.com/sunbankio/qwencoder-proxy/config"
 )
 
-func TestLogger(t *testing.T) {
-	logger := NewLogger()
+// capture configures logging to write into a buffer until the test ends
+func capture(t *testing.T, cfg config.LoggingConfig) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := configure(&buf, cfg); err != nil {
+		t.Fatalf("configure() error = %v", err)
+	}
+	t.Cleanup(func() {
+		for subsystem := range Levels().Subsystems {
+			ResetLevel(subsystem)
+		}
+		configure(os.Stdout, config.LoggingConfig{})
+	})
+	return &buf
+}
 
-	// Test that we can create a logger
-	if logger == nil {
-		t.Error("Failed to create logger")
+// lines decodes the JSON log lines in buf
+func lines(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	t.Helper()
+	var out []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var entry map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("line is not JSON: %q", line)
+		}
+		out = append(out, entry)
+	}
+	return out
+}
+
+func TestJSONLines(t *testing.T) {
+	buf := capture(t, config.LoggingConfig{Format: "json"})
+
+	ctx := WithRequestID(context.Background(), "req-1")
+	logger := NewLogger("proxy").WithContext(ctx)
+	logger.InfoLog("[Handler] Request: %s", "POST")
+	SetRoute(ctx, "kiro", "claude-sonnet-4")
+	logger.ErrorLog("[Handler] Failed: %v", "boom")
+	NewLogger("proxy").WarningLog("no request")
+
+	entries := lines(t, buf)
+	if len(entries) != 3 {
+		t.Fatalf("wrote %d lines, want 3:\n%s", len(entries), buf)
+	}
+	first, second, third := entries[0], entries[1], entries[2]
+	if first["msg"] != "[Handler] Request: POST" || first["level"] != "INFO" || first["subsystem"] != "proxy" || first["request_id"] != "req-1" {
+		t.Errorf("first line = %v", first)
 	}
+	if _, ok := first["provider"]; ok {
+		t.Errorf("first line has a provider before one was chosen: %v", first)
+	}
+	if second["level"] != "ERROR" || second["provider"] != "kiro" || second["model"] != "claude-sonnet-4" {
+		t.Errorf("second line = %v, want the route set in between", second)
+	}
+	if _, ok := third["request_id"]; ok {
+		t.Errorf("line outside a request has a request ID: %v", third)
+	}
+	if strings.Contains(buf.String(), "\033[") {
+		t.Errorf("output contains ANSI escapes:\n%s", buf)
+	}
+}
+
+func TestSubsystemLevels(t *testing.T) {
+	buf := capture(t, config.LoggingConfig{Format: "json", Level: "warn", Levels: map[string]string{"kiro": "debug"}})
+
+	kiro, qwen := NewLogger("kiro"), NewLogger("qwen")
+	kiro.DebugLog("kiro debug")
+	qwen.InfoLog("qwen info")
+	qwen.WarningLog("qwen warning")
+
+	SetLevel("qwen", slog.LevelDebug)
+	ResetLevel("kiro")
+	kiro.InfoLog("kiro info")
+	qwen.DebugLog("qwen debug")
 
-	// Test logging functions (these should not panic)
-	logger.StreamLog("Test stream log")
-	logger.NonStreamLog("Test non-stream log")
-	logger.DoneLog("Test done log")
-	logger.DoneNonStreamLog("Test done non-stream log")
-	logger.SeparatorLog()
-	logger.ErrorLog("Test error log")
-	logger.WarningLog("Test warning log")
+	var got []string
+	for _, entry := range lines(t, buf) {
+		got = append(got, entry["msg"].(string))
+	}
+	if want := "kiro debug,qwen warning,qwen debug"; strings.Join(got, ",") != want {
+		t.Errorf("logged %v, want %s", got, want)
+	}
+
+	settings := Levels()
+	if settings.Default != "warn" || settings.Subsystems["kiro"] != "warn" || settings.Subsystems["qwen"] != "debug" {
+		t.Errorf("Levels() = %+v", settings)
+	}
+	if strings.Join(settings.Overrides, ",") != "qwen" {
+		t.Errorf("Levels().Overrides = %v, want [qwen]", settings.Overrides)
+	}
+}
+
+func TestStandardLogRedirected(t *testing.T) {
+	buf := capture(t, config.LoggingConfig{})
+	log.Printf("from the log package")
+	if out := buf.String(); !strings.Contains(out, "level=INFO") || !strings.Contains(out, `msg="from the log package"`) || !strings.Contains(out, "subsystem=main") {
+		t.Errorf("standard log output = %q", out)
+	}
+}
+
+func TestConfigureErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  config.LoggingConfig
+	}{
+		{"format", config.LoggingConfig{Format: "logfmt"}},
+		{"level", config.LoggingConfig{Level: "verbose"}},
+		{"subsystem level", config.LoggingConfig{Levels: map[string]string{"kiro": "loud"}}},
+	}
+	for _, tt := range tests {
+		if err := configure(&bytes.Buffer{}, tt.cfg); err == nil {
+			t.Errorf("%s: configure() succeeded, want an error", tt.name)
+		}
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    slog.Level
+		wantErr bool
+	}{
+		{"debug", slog.LevelDebug, false},
+		{"INFO", slog.LevelInfo, false},
+		{"warn", slog.LevelWarn, false},
+		{"Warning", slog.LevelWarn, false},
+		{"error", slog.LevelError, false},
+		{"trace", 0, true},
+		{"", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseLevel(tt.value)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseLevel(%q) = %v, %v; want %v, error %v", tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
 }
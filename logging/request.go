@@ -0,0 +1,56 @@
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+)
+
+// requestFields are the request details added to log lines. Provider and model are
+// learned while the request is handled, so they are set in place.
+type requestFields struct {
+	mu       sync.Mutex
+	id       string
+	provider string
+	model    string
+}
+
+type requestFieldsKey struct{}
+
+// WithRequestID returns a context whose log lines carry a request ID
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestFieldsKey{}, &requestFields{id: id})
+}
+
+// RequestID returns the request ID of ctx, or "" outside a request
+func RequestID(ctx context.Context) string {
+	if fields, ok := ctx.Value(requestFieldsKey{}).(*requestFields); ok {
+		return fields.id
+	}
+	return ""
+}
+
+// SetRoute records the provider and model serving the request of ctx, for the log
+// lines that follow
+func SetRoute(ctx context.Context, provider, model string) {
+	fields, ok := ctx.Value(requestFieldsKey{}).(*requestFields)
+	if !ok {
+		return
+	}
+	fields.mu.Lock()
+	defer fields.mu.Unlock()
+	fields.provider, fields.model = provider, model
+}
+
+func (f *requestFields) attrs() []slog.Attr {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	attrs := []slog.Attr{slog.String("request_id", f.id)}
+	if f.provider != "" {
+		attrs = append(attrs, slog.String("provider", f.provider))
+	}
+	if f.model != "" {
+		attrs = append(attrs, slog.String("model", f.model))
+	}
+	return attrs
+}
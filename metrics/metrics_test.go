@@ -0,0 +1,88 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegistryExposition(t *testing.T) {
+	r := NewRegistry()
+	requests := r.NewCounter("test_requests_total", "Requests\nhandled", "route", "status")
+	inFlight := r.NewGauge("test_in_flight", "In flight")
+	latency := r.NewHistogram("test_latency_seconds", "Latency", []float64{1, 0.1}, "route")
+
+	requests.Inc("/v1/chat", "200")
+	requests.Add(2, "/v1/chat", "200")
+	requests.Inc(`/a"b`, "500")
+	inFlight.Add(3)
+	inFlight.Add(-1)
+	latency.Observe(0.05, "/v1/chat")
+	latency.Observe(0.1, "/v1/chat")
+	latency.Observe(0.5, "/v1/chat")
+	latency.Observe(7, "/v1/chat")
+
+	var b strings.Builder
+	if _, err := r.WriteTo(&b); err != nil {
+		t.Fatalf("WriteTo: %v", err)
+	}
+	want := `# HELP test_in_flight In flight
+# TYPE test_in_flight gauge
+test_in_flight 2
+# HELP test_latency_seconds Latency
+# TYPE test_latency_seconds histogram
+test_latency_seconds_bucket{route="/v1/chat",le="0.1"} 2
+test_latency_seconds_bucket{route="/v1/chat",le="1"} 3
+test_latency_seconds_bucket{route="/v1/chat",le="+Inf"} 4
+test_latency_seconds_sum{route="/v1/chat"} 7.65
+test_latency_seconds_count{route="/v1/chat"} 4
+# HELP test_requests_total Requests\nhandled
+# TYPE test_requests_total counter
+test_requests_total{route="/a\"b",status="500"} 1
+test_requests_total{route="/v1/chat",status="200"} 3
+`
+	if b.String() != want {
+		t.Errorf("exposition =\n%s\nwant\n%s", b.String(), want)
+	}
+}
+
+func TestRegistryOnScrape(t *testing.T) {
+	r := NewRegistry()
+	models := r.NewGauge("test_models", "Models", "provider")
+	models.Set(5, "stale")
+	r.OnScrape(func() {
+		models.Reset()
+		models.Set(2, "qwen")
+	})
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := rec.Body.String()
+	if !strings.Contains(body, `test_models{provider="qwen"} 2`) {
+		t.Errorf("scrape did not update the gauge:\n%s", body)
+	}
+	if strings.Contains(body, "stale") {
+		t.Errorf("reset series still exported:\n%s", body)
+	}
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/metrics", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST status = %d, want %d", rec.Code, http.StatusMethodNotAllowed)
+	}
+}
+
+func TestLabelCountMismatchPanics(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounter("test_total", "Test", "a", "b")
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Inc with the wrong number of label values did not panic")
+		}
+	}()
+	c.Inc("only-one")
+}
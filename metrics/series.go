@@ -0,0 +1,65 @@
+package metrics
+
+// namespace prefixes every series the proxy exports
+const namespace = "qwencoder_proxy_"
+
+// Buckets for request phases, from a cached reply to a long agentic generation
+var (
+	latencyBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600}
+	streamBuckets  = ExponentialBuckets(0.5, 2, 12)
+)
+
+// Requests
+var (
+	Requests = Default.NewCounter(namespace+"requests_total",
+		"Requests handled, by route, model, provider and status code", "route", "model", "provider", "status")
+	RequestDuration = Default.NewHistogram(namespace+"request_duration_seconds",
+		"Time from receiving a request to finishing its response", latencyBuckets, "route", "model", "provider", "status")
+	TimeToFirstToken = Default.NewHistogram(namespace+"time_to_first_token_seconds",
+		"Time from receiving a streaming request to sending its first event", latencyBuckets, "route", "model", "provider")
+	StreamDuration = Default.NewHistogram(namespace+"stream_duration_seconds",
+		"Time from the first event of a stream to its end", streamBuckets, "route", "model", "provider")
+	Tokens = Default.NewCounter(namespace+"tokens_total",
+		"Tokens reported by upstream responses, by direction: input, output or cached", "provider", "model", "direction")
+	InFlight = Default.NewGauge(namespace+"requests_in_flight",
+		"Requests being handled, by route", "route")
+	StreamsInFlight = Default.NewGauge(namespace+"streams_in_flight",
+		"Event streams being sent to clients")
+	UpstreamInFlight = Default.NewGauge(namespace+"upstream_requests_in_flight",
+		"Requests being served by each provider", "provider")
+)
+
+// Upstream reliability
+var (
+	Retries = Default.NewCounter(namespace+"upstream_retries_total",
+		"Upstream requests repeated against the same provider, by reason", "provider", "reason")
+	Failovers = Default.NewCounter(namespace+"failovers_total",
+		"Requests moved to another provider after a failure", "from", "to")
+	CircuitState = Default.NewGauge(namespace+"circuit_state",
+		"Circuit breaker state of each provider: 0 closed, 1 half-open, 2 open", "provider")
+	TokenRefreshes = Default.NewCounter(namespace+"token_refreshes_total",
+		"Access token refreshes, by authenticator and result", "authenticator", "result")
+)
+
+// Model catalog
+var (
+	CatalogModels = Default.NewGauge(namespace+"catalog_models",
+		"Distinct models in the catalog")
+	ProviderModels = Default.NewGauge(namespace+"provider_models",
+		"Models each provider serves", "provider")
+)
+
+// Retry reasons
+const (
+	RetryAuth          = "auth"           // Repeated with a refreshed token after a 401 or 403
+	RetryUnaryFallback = "unary_fallback" // Stream simulated from a unary call the upstream could serve
+)
+
+// ObserveTokenRefresh counts a token refresh by an authenticator as a success or failure
+func ObserveTokenRefresh(authenticator string, err error) {
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	TokenRefreshes.Inc(authenticator, result)
+}
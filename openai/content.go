@@ -0,0 +1,121 @@
+package openai
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// MessageContent is either a plain string or a list of content parts.
+// A nil content (JSON null) is preserved, as assistant messages with tool calls use it.
+type MessageContent struct {
+	Text  string
+	Parts []ContentPart
+
+	isParts bool
+	isNull  bool
+}
+
+// TextContent creates a plain string content
+func TextContent(text string) MessageContent {
+	return MessageContent{Text: text}
+}
+
+// PartsContent creates a multi-part content
+func PartsContent(parts ...ContentPart) MessageContent {
+	return MessageContent{Parts: parts, isParts: true}
+}
+
+// NullContent creates an explicit null content
+func NullContent() MessageContent {
+	return MessageContent{isNull: true}
+}
+
+// IsParts reports whether the content is a list of parts
+func (c MessageContent) IsParts() bool {
+	return c.isParts
+}
+
+// IsNull reports whether the content was JSON null
+func (c MessageContent) IsNull() bool {
+	return c.isNull
+}
+
+// String flattens the content to text, joining text parts
+func (c MessageContent) String() string {
+	if !c.isParts {
+		return c.Text
+	}
+	var sb strings.Builder
+	for _, part := range c.Parts {
+		if part.Type == "text" {
+			sb.WriteString(part.Text)
+		}
+	}
+	return sb.String()
+}
+
+// UnmarshalJSON accepts a string, an array of parts or null
+func (c *MessageContent) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	switch {
+	case len(data) == 0 || bytes.Equal(data, []byte("null")):
+		*c = NullContent()
+		return nil
+	case data[0] == '"':
+		var text string
+		if err := json.Unmarshal(data, &text); err != nil {
+			return err
+		}
+		*c = TextContent(text)
+		return nil
+	case data[0] == '[':
+		var parts []ContentPart
+		if err := json.Unmarshal(data, &parts); err != nil {
+			return err
+		}
+		*c = PartsContent(parts...)
+		return nil
+	default:
+		return fmt.Errorf("message content must be a string, an array or null")
+	}
+}
+
+// MarshalJSON writes the content in the form it was received
+func (c MessageContent) MarshalJSON() ([]byte, error) {
+	switch {
+	case c.isNull:
+		return []byte("null"), nil
+	case c.isParts:
+		return json.Marshal(c.Parts)
+	default:
+		return json.Marshal(c.Text)
+	}
+}
+
+// StringList is a stop sequence field that accepts a string or an array of strings
+type StringList []string
+
+// UnmarshalJSON accepts a string, an array of strings or null
+func (s *StringList) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if bytes.Equal(data, []byte("null")) {
+		*s = nil
+		return nil
+	}
+	if len(data) > 0 && data[0] == '"' {
+		var single string
+		if err := json.Unmarshal(data, &single); err != nil {
+			return err
+		}
+		*s = StringList{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*s = list
+	return nil
+}
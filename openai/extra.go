@@ -0,0 +1,102 @@
+package openai
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Extra holds JSON fields that are not modelled by a typed struct.
+// Values are kept as raw JSON so they round-trip byte for byte.
+type Extra map[string]json.RawMessage
+
+// knownFieldsCache maps a struct type to the set of JSON keys it declares
+var knownFieldsCache sync.Map
+
+// knownFields returns the JSON keys declared by the struct type t
+func knownFields(t reflect.Type) map[string]struct{} {
+	if cached, ok := knownFieldsCache.Load(t); ok {
+		return cached.(map[string]struct{})
+	}
+
+	fields := make(map[string]struct{}, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" || !f.IsExported() {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+		if name == "" {
+			name = f.Name
+		}
+		fields[name] = struct{}{}
+	}
+
+	knownFieldsCache.Store(t, fields)
+	return fields
+}
+
+// unmarshalExtra decodes data into alias (a pointer to an alias struct) and
+// returns every top-level key the alias does not declare
+func unmarshalExtra(data []byte, alias interface{}) (Extra, error) {
+	if err := json.Unmarshal(data, alias); err != nil {
+		return nil, err
+	}
+
+	var all map[string]json.RawMessage
+	if err := json.Unmarshal(data, &all); err != nil {
+		return nil, err
+	}
+
+	known := knownFields(reflect.TypeOf(alias).Elem())
+	var extra Extra
+	for k, v := range all {
+		if _, ok := known[k]; ok {
+			continue
+		}
+		if extra == nil {
+			extra = make(Extra)
+		}
+		extra[k] = v
+	}
+	return extra, nil
+}
+
+// marshalExtra encodes alias and splices the extra fields into the resulting object.
+// Typed fields win over extra fields with the same key.
+func marshalExtra(alias interface{}, extra Extra) ([]byte, error) {
+	data, err := json.Marshal(alias)
+	if err != nil || len(extra) == 0 {
+		return data, err
+	}
+
+	known := knownFields(reflect.TypeOf(alias).Elem())
+	var buf bytes.Buffer
+	buf.Grow(len(data) + 64*len(extra))
+	buf.Write(data[:len(data)-1])
+	keys := make([]string, 0, len(extra))
+	for k := range extra {
+		if _, ok := known[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	wroteField := len(data) > 2
+	for _, k := range keys {
+		if wroteField {
+			buf.WriteByte(',')
+		}
+		key, _ := json.Marshal(k)
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(extra[k])
+		wroteField = true
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
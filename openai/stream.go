@@ -0,0 +1,220 @@
+package openai
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+)
+
+// Accumulator assembles a sequence of stream chunks into a complete chat completion
+type Accumulator struct {
+	resp    ChatCompletionResponse
+	choices map[int]*choiceAccumulator
+}
+
+// choiceAccumulator collects the deltas for one completion alternative
+type choiceAccumulator struct {
+	role         string
+	content      strings.Builder
+	reasoning    strings.Builder
+	toolCalls    []ToolCall
+	toolSlots    map[int]int // Stream tool call index to position in toolCalls
+	finishReason string
+}
+
+// NewAccumulator creates an empty Accumulator
+func NewAccumulator() *Accumulator {
+	return &Accumulator{choices: make(map[int]*choiceAccumulator)}
+}
+
+// Add merges one chunk into the response
+func (a *Accumulator) Add(chunk *ChatCompletionChunk) {
+	if a.resp.ID == "" {
+		a.resp.ID = chunk.ID
+	}
+	if a.resp.Model == "" {
+		a.resp.Model = chunk.Model
+	}
+	if a.resp.Created == 0 {
+		a.resp.Created = chunk.Created
+	}
+	if chunk.SystemFingerprint != "" {
+		a.resp.SystemFingerprint = chunk.SystemFingerprint
+	}
+	if chunk.Usage != nil {
+		usage := *chunk.Usage
+		a.resp.Usage = &usage
+	}
+
+	for i := range chunk.Choices {
+		cc := &chunk.Choices[i]
+		choice, ok := a.choices[cc.Index]
+		if !ok {
+			choice = &choiceAccumulator{toolSlots: make(map[int]int)}
+			a.choices[cc.Index] = choice
+		}
+		if cc.Delta.Role != "" {
+			choice.role = cc.Delta.Role
+		}
+		choice.content.WriteString(cc.Delta.Content)
+		choice.reasoning.WriteString(cc.Delta.ReasoningContent)
+		for _, call := range cc.Delta.ToolCalls {
+			choice.addToolCall(call)
+		}
+		if cc.FinishReason != nil && *cc.FinishReason != "" {
+			choice.finishReason = *cc.FinishReason
+		}
+	}
+}
+
+// addToolCall merges a tool call delta. The first delta for a call carries its ID and name
+// and later ones append argument fragments. Some upstreams reuse an index for every call
+// and send each call whole, so a named delta for a slot whose arguments are already
+// complete starts a new call.
+func (c *choiceAccumulator) addToolCall(delta ToolCall) {
+	index := len(c.toolCalls)
+	if delta.Index != nil {
+		index = *delta.Index
+	} else if delta.ID == "" && len(c.toolCalls) > 0 {
+		index = len(c.toolCalls) - 1
+	}
+
+	pos, ok := c.toolSlots[index]
+	if ok {
+		existing := &c.toolCalls[pos]
+		switch {
+		case delta.ID != "" && existing.ID != "" && delta.ID != existing.ID:
+			ok = false
+		case delta.ID == "" && delta.Function.Name != "" && existing.Function.Name != "" &&
+			existing.Function.Arguments != "" && json.Valid([]byte(existing.Function.Arguments)):
+			ok = false
+		}
+	}
+	if !ok {
+		c.toolSlots[index] = len(c.toolCalls)
+		c.toolCalls = append(c.toolCalls, ToolCall{ID: delta.ID, Type: delta.Type, Function: delta.Function})
+		return
+	}
+
+	existing := &c.toolCalls[pos]
+	if existing.ID == "" {
+		existing.ID = delta.ID
+	}
+	if existing.Type == "" {
+		existing.Type = delta.Type
+	}
+	if existing.Function.Name == "" {
+		existing.Function.Name = delta.Function.Name
+	}
+	existing.Function.Arguments += delta.Function.Arguments
+}
+
+// Response returns the assembled response. A stream without choices yields a single empty one.
+func (a *Accumulator) Response() *ChatCompletionResponse {
+	resp := a.resp
+	resp.Object = "chat.completion"
+
+	indexes := make([]int, 0, len(a.choices))
+	for index := range a.choices {
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+	if len(indexes) == 0 {
+		indexes = []int{0}
+		a.choices[0] = &choiceAccumulator{}
+	}
+
+	resp.Choices = make([]Choice, 0, len(indexes))
+	for _, index := range indexes {
+		choice := a.choices[index]
+		msg := Message{
+			Role:             choice.role,
+			Content:          TextContent(choice.content.String()),
+			ReasoningContent: choice.reasoning.String(),
+		}
+		if msg.Role == "" {
+			msg.Role = "assistant"
+		}
+		for _, call := range choice.toolCalls {
+			if call.Type == "" {
+				call.Type = "function"
+			}
+			if call.Function.Arguments == "" {
+				call.Function.Arguments = "{}"
+			}
+			msg.ToolCalls = append(msg.ToolCalls, call)
+		}
+		if len(msg.ToolCalls) > 0 && msg.Content.Text == "" {
+			msg.Content = NullContent()
+		}
+
+		finishReason := choice.finishReason
+		if finishReason == "" {
+			finishReason = "stop"
+			if len(msg.ToolCalls) > 0 {
+				finishReason = "tool_calls"
+			}
+		}
+		resp.Choices = append(resp.Choices, Choice{Index: index, Message: msg, FinishReason: finishReason})
+	}
+	return &resp
+}
+
+// ResponseChunks splits a complete response into the chunks a streaming upstream would send:
+// the role and reasoning, the content, the tool calls, then the finish reason with usage.
+func ResponseChunks(resp *ChatCompletionResponse) []ChatCompletionChunk {
+	newChunk := func(choice ChunkChoice) ChatCompletionChunk {
+		return ChatCompletionChunk{
+			ID:                resp.ID,
+			Object:            "chat.completion.chunk",
+			Created:           resp.Created,
+			Model:             resp.Model,
+			SystemFingerprint: resp.SystemFingerprint,
+			Choices:           []ChunkChoice{choice},
+		}
+	}
+
+	var chunks []ChatCompletionChunk
+	for _, choice := range resp.Choices {
+		role := choice.Message.Role
+		if role == "" {
+			role = "assistant"
+		}
+		chunks = append(chunks, newChunk(ChunkChoice{
+			Index: choice.Index,
+			Delta: Delta{Role: role, ReasoningContent: choice.Message.ReasoningContent},
+		}))
+
+		if text := choice.Message.Content.String(); text != "" {
+			chunks = append(chunks, newChunk(ChunkChoice{Index: choice.Index, Delta: Delta{Content: text}}))
+		}
+
+		if len(choice.Message.ToolCalls) > 0 {
+			calls := make([]ToolCall, len(choice.Message.ToolCalls))
+			for i, call := range choice.Message.ToolCalls {
+				index := i
+				call.Index = &index
+				if call.Type == "" {
+					call.Type = "function"
+				}
+				calls[i] = call
+			}
+			chunks = append(chunks, newChunk(ChunkChoice{Index: choice.Index, Delta: Delta{ToolCalls: calls}}))
+		}
+
+		finishReason := choice.FinishReason
+		if finishReason == "" {
+			finishReason = "stop"
+		}
+		chunks = append(chunks, newChunk(ChunkChoice{Index: choice.Index, FinishReason: FinishReason(finishReason)}))
+	}
+
+	if len(chunks) == 0 {
+		chunks = append(chunks, newChunk(ChunkChoice{Delta: Delta{Role: "assistant"}, FinishReason: FinishReason("stop")}))
+	}
+	if resp.Usage != nil {
+		usage := *resp.Usage
+		chunks[len(chunks)-1].Usage = &usage
+	}
+	return chunks
+}
@@ -0,0 +1,119 @@
+package openai
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func decodeChunks(t *testing.T, lines ...string) []ChatCompletionChunk {
+	t.Helper()
+	chunks := make([]ChatCompletionChunk, len(lines))
+	for i, line := range lines {
+		if err := json.Unmarshal([]byte(line), &chunks[i]); err != nil {
+			t.Fatalf("Unmarshal(%s) error = %v", line, err)
+		}
+	}
+	return chunks
+}
+
+func TestAccumulatorOpenAIStream(t *testing.T) {
+	chunks := decodeChunks(t,
+		`{"id":"chatcmpl-1","model":"qwen3-coder-plus","created":1,"choices":[{"index":0,"delta":{"role":"assistant","content":""}}]}`,
+		`{"id":"chatcmpl-1","choices":[{"index":0,"delta":{"reasoning_content":"Thinking."}}]}`,
+		`{"id":"chatcmpl-1","choices":[{"index":0,"delta":{"content":"Let me "}}]}`,
+		`{"id":"chatcmpl-1","choices":[{"index":0,"delta":{"content":"check."}}]}`,
+		`{"id":"chatcmpl-1","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"id":"call_a","type":"function","function":{"name":"get_weather","arguments":""}}]}}]}`,
+		`{"id":"chatcmpl-1","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"{\"city\":"}}]}}]}`,
+		`{"id":"chatcmpl-1","choices":[{"index":0,"delta":{"tool_calls":[{"index":1,"id":"call_b","type":"function","function":{"name":"get_time","arguments":"{}"}}]}}]}`,
+		`{"id":"chatcmpl-1","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"\"Paris\"}"}}]}}]}`,
+		`{"id":"chatcmpl-1","choices":[{"index":0,"delta":{},"finish_reason":"tool_calls"}]}`,
+		`{"id":"chatcmpl-1","choices":[],"usage":{"prompt_tokens":10,"completion_tokens":5,"total_tokens":15}}`,
+	)
+
+	acc := NewAccumulator()
+	for i := range chunks {
+		acc.Add(&chunks[i])
+	}
+	resp := acc.Response()
+
+	if resp.ID != "chatcmpl-1" || resp.Model != "qwen3-coder-plus" || resp.Object != "chat.completion" {
+		t.Errorf("unexpected metadata: %+v", resp)
+	}
+	if len(resp.Choices) != 1 {
+		t.Fatalf("got %d choices, want 1", len(resp.Choices))
+	}
+	msg := resp.Choices[0].Message
+	if msg.Content.String() != "Let me check." || msg.ReasoningContent != "Thinking." {
+		t.Errorf("content = %q, reasoning = %q", msg.Content.String(), msg.ReasoningContent)
+	}
+	if len(msg.ToolCalls) != 2 {
+		t.Fatalf("got %d tool calls, want 2: %+v", len(msg.ToolCalls), msg.ToolCalls)
+	}
+	if msg.ToolCalls[0].ID != "call_a" || msg.ToolCalls[0].Function.Arguments != `{"city":"Paris"}` {
+		t.Errorf("first tool call = %+v", msg.ToolCalls[0])
+	}
+	if msg.ToolCalls[1].Function.Name != "get_time" || msg.ToolCalls[0].Index != nil {
+		t.Errorf("second tool call = %+v", msg.ToolCalls[1])
+	}
+	if resp.Choices[0].FinishReason != "tool_calls" {
+		t.Errorf("FinishReason = %s, want tool_calls", resp.Choices[0].FinishReason)
+	}
+	if resp.Usage == nil || resp.Usage.TotalTokens != 15 {
+		t.Errorf("Usage = %+v, want 15 total tokens", resp.Usage)
+	}
+}
+
+func TestAccumulatorReusedToolIndex(t *testing.T) {
+	// Gemini-derived chunks send each call whole and number calls per chunk
+	chunks := decodeChunks(t,
+		`{"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"type":"function","function":{"name":"read_file","arguments":"{\"path\":\"a.go\"}"}}]}}]}`,
+		`{"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"type":"function","function":{"name":"read_file","arguments":"{\"path\":\"b.go\"}"}}]}}]}`,
+	)
+
+	acc := NewAccumulator()
+	for i := range chunks {
+		acc.Add(&chunks[i])
+	}
+	calls := acc.Response().Choices[0].Message.ToolCalls
+	if len(calls) != 2 || calls[1].Function.Arguments != `{"path":"b.go"}` {
+		t.Errorf("tool calls = %+v, want two separate calls", calls)
+	}
+	if !acc.Response().Choices[0].Message.Content.IsNull() {
+		t.Errorf("content should be null for a tool-only message")
+	}
+}
+
+func TestResponseChunksRoundTrip(t *testing.T) {
+	resp := &ChatCompletionResponse{
+		ID:      "chatcmpl-2",
+		Object:  "chat.completion",
+		Created: 2,
+		Model:   "claude-sonnet-4-5",
+		Choices: []Choice{{
+			Message: Message{
+				Role:    "assistant",
+				Content: TextContent("Checking."),
+				ToolCalls: []ToolCall{
+					{ID: "t1", Type: "function", Function: FunctionCall{Name: "get_weather", Arguments: `{"city":"Paris"}`}},
+				},
+			},
+			FinishReason: "tool_calls",
+		}},
+		Usage: &Usage{PromptTokens: 3, CompletionTokens: 4, TotalTokens: 7},
+	}
+
+	chunks := ResponseChunks(resp)
+	if last := chunks[len(chunks)-1]; last.Usage == nil || *last.Choices[0].FinishReason != "tool_calls" {
+		t.Errorf("last chunk should carry finish reason and usage: %+v", last)
+	}
+
+	acc := NewAccumulator()
+	for i := range chunks {
+		acc.Add(&chunks[i])
+	}
+	got, _ := json.Marshal(acc.Response())
+	want, _ := json.Marshal(resp)
+	if string(got) != string(want) {
+		t.Errorf("round trip mismatch:\n got %s\nwant %s", got, want)
+	}
+}
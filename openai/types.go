@@ -0,0 +1,460 @@
+// Package openai defines typed OpenAI chat completion requests, responses and stream chunks.
+// Fields the proxy does not model are kept in Extra and written back unchanged.
+package openai
+
+import (
+	"encoding/json"
+)
+
+// ChatCompletionRequest represents a POST /v1/chat/completions request body
+type ChatCompletionRequest struct {
+	Model            string          `json:"model"`
+	Messages         []Message       `json:"messages"`
+	Stream           bool            `json:"stream,omitempty"`
+	StreamOptions    *StreamOptions  `json:"stream_options,omitempty"`
+	MaxTokens        *int            `json:"max_tokens,omitempty"`
+	Temperature      *float64        `json:"temperature,omitempty"`
+	TopP             *float64        `json:"top_p,omitempty"`
+	N                *int            `json:"n,omitempty"`
+	Stop             StringList      `json:"stop,omitempty"`
+	PresencePenalty  *float64        `json:"presence_penalty,omitempty"`
+	FrequencyPenalty *float64        `json:"frequency_penalty,omitempty"`
+	User             string          `json:"user,omitempty"`
+	Tools            []Tool          `json:"tools,omitempty"`
+	ToolChoice       json.RawMessage `json:"tool_choice,omitempty"`
+	ReasoningEffort  string          `json:"reasoning_effort,omitempty"`
+
+	// Extra holds request fields not modelled above
+	Extra Extra `json:"-"`
+}
+
+// StreamOptions controls optional streaming behaviour
+type StreamOptions struct {
+	IncludeUsage bool `json:"include_usage,omitempty"`
+}
+
+// Message represents a single chat message
+type Message struct {
+	Role             string         `json:"role"`
+	Content          MessageContent `json:"content"`
+	Name             string         `json:"name,omitempty"`
+	ReasoningContent string         `json:"reasoning_content,omitempty"`
+	ToolCalls        []ToolCall     `json:"tool_calls,omitempty"`
+	ToolCallID       string         `json:"tool_call_id,omitempty"`
+
+	// Extra holds message fields not modelled above
+	Extra Extra `json:"-"`
+}
+
+// ContentPart represents one element of a multi-part message content
+type ContentPart struct {
+	Type     string    `json:"type"`
+	Text     string    `json:"text,omitempty"`
+	ImageURL *ImageURL `json:"image_url,omitempty"`
+
+	// Extra holds part fields not modelled above, such as input_audio or cache_control
+	Extra Extra `json:"-"`
+}
+
+// ImageURL references an image by URL or data URI
+type ImageURL struct {
+	URL    string `json:"url"`
+	Detail string `json:"detail,omitempty"`
+
+	// Extra holds image fields not modelled above
+	Extra Extra `json:"-"`
+}
+
+// Tool represents a tool the model may call
+type Tool struct {
+	Type     string             `json:"type"`
+	Function FunctionDefinition `json:"function"`
+
+	// Extra holds tool fields not modelled above, such as vendor tool settings
+	Extra Extra `json:"-"`
+}
+
+// FunctionDefinition describes a callable function
+type FunctionDefinition struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description,omitempty"`
+	Parameters  map[string]interface{} `json:"parameters,omitempty"`
+	Strict      *bool                  `json:"strict,omitempty"`
+
+	// Extra holds function fields not modelled above
+	Extra Extra `json:"-"`
+}
+
+// ToolCall represents a function call made by the model
+type ToolCall struct {
+	Index    *int         `json:"index,omitempty"`
+	ID       string       `json:"id,omitempty"`
+	Type     string       `json:"type,omitempty"`
+	Function FunctionCall `json:"function"`
+
+	// Extra holds tool call fields not modelled above
+	Extra Extra `json:"-"`
+}
+
+// FunctionCall holds the function name and JSON-encoded arguments
+type FunctionCall struct {
+	Name      string `json:"name,omitempty"`
+	Arguments string `json:"arguments"`
+
+	// Extra holds function call fields not modelled above
+	Extra Extra `json:"-"`
+}
+
+// ChatCompletionResponse represents a non-streaming chat completion
+type ChatCompletionResponse struct {
+	ID                string   `json:"id"`
+	Object            string   `json:"object"`
+	Created           int64    `json:"created"`
+	Model             string   `json:"model"`
+	Choices           []Choice `json:"choices"`
+	Usage             *Usage   `json:"usage,omitempty"`
+	SystemFingerprint string   `json:"system_fingerprint,omitempty"`
+
+	// Extra holds response fields not modelled above
+	Extra Extra `json:"-"`
+}
+
+// Choice represents one completion alternative
+type Choice struct {
+	Index        int     `json:"index"`
+	Message      Message `json:"message"`
+	FinishReason string  `json:"finish_reason"`
+
+	// Extra holds choice fields not modelled above, such as logprobs
+	Extra Extra `json:"-"`
+}
+
+// Usage reports token consumption
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+
+	// Extra holds usage details not modelled above
+	Extra Extra `json:"-"`
+}
+
+// ChatCompletionChunk represents one streamed chat completion event
+type ChatCompletionChunk struct {
+	ID                string        `json:"id"`
+	Object            string        `json:"object"`
+	Created           int64         `json:"created"`
+	Model             string        `json:"model"`
+	Choices           []ChunkChoice `json:"choices"`
+	Usage             *Usage        `json:"usage,omitempty"`
+	SystemFingerprint string        `json:"system_fingerprint,omitempty"`
+
+	// Extra holds chunk fields not modelled above
+	Extra Extra `json:"-"`
+}
+
+// ChunkChoice represents the delta for one completion alternative
+type ChunkChoice struct {
+	Index        int     `json:"index"`
+	Delta        Delta   `json:"delta"`
+	FinishReason *string `json:"finish_reason"`
+
+	// Extra holds choice fields not modelled above, such as logprobs
+	Extra Extra `json:"-"`
+}
+
+// Delta holds incremental message content
+type Delta struct {
+	Role             string     `json:"role,omitempty"`
+	Content          string     `json:"content,omitempty"`
+	ReasoningContent string     `json:"reasoning_content,omitempty"`
+	ToolCalls        []ToolCall `json:"tool_calls,omitempty"`
+
+	// Extra holds delta fields not modelled above
+	Extra Extra `json:"-"`
+}
+
+// FinishReason returns a pointer to reason for use in ChunkChoice
+func FinishReason(reason string) *string {
+	return &reason
+}
+
+type requestAlias ChatCompletionRequest
+
+// UnmarshalJSON decodes the request and keeps unknown fields in Extra
+func (r *ChatCompletionRequest) UnmarshalJSON(data []byte) error {
+	var alias requestAlias
+	extra, err := unmarshalExtra(data, &alias)
+	if err != nil {
+		return err
+	}
+	*r = ChatCompletionRequest(alias)
+	r.Extra = extra
+	return nil
+}
+
+// MarshalJSON encodes the request including unknown fields
+func (r ChatCompletionRequest) MarshalJSON() ([]byte, error) {
+	alias := requestAlias(r)
+	return marshalExtra(&alias, r.Extra)
+}
+
+type messageAlias Message
+
+// UnmarshalJSON decodes the message and keeps unknown fields in Extra
+func (m *Message) UnmarshalJSON(data []byte) error {
+	var alias messageAlias
+	extra, err := unmarshalExtra(data, &alias)
+	if err != nil {
+		return err
+	}
+	*m = Message(alias)
+	m.Extra = extra
+	return nil
+}
+
+// MarshalJSON encodes the message including unknown fields
+func (m Message) MarshalJSON() ([]byte, error) {
+	alias := messageAlias(m)
+	return marshalExtra(&alias, m.Extra)
+}
+
+type responseAlias ChatCompletionResponse
+
+// UnmarshalJSON decodes the response and keeps unknown fields in Extra
+func (r *ChatCompletionResponse) UnmarshalJSON(data []byte) error {
+	var alias responseAlias
+	extra, err := unmarshalExtra(data, &alias)
+	if err != nil {
+		return err
+	}
+	*r = ChatCompletionResponse(alias)
+	r.Extra = extra
+	return nil
+}
+
+// MarshalJSON encodes the response including unknown fields
+func (r ChatCompletionResponse) MarshalJSON() ([]byte, error) {
+	alias := responseAlias(r)
+	return marshalExtra(&alias, r.Extra)
+}
+
+type chunkAlias ChatCompletionChunk
+
+// UnmarshalJSON decodes the chunk and keeps unknown fields in Extra
+func (c *ChatCompletionChunk) UnmarshalJSON(data []byte) error {
+	var alias chunkAlias
+	extra, err := unmarshalExtra(data, &alias)
+	if err != nil {
+		return err
+	}
+	*c = ChatCompletionChunk(alias)
+	c.Extra = extra
+	return nil
+}
+
+// MarshalJSON encodes the chunk including unknown fields
+func (c ChatCompletionChunk) MarshalJSON() ([]byte, error) {
+	alias := chunkAlias(c)
+	return marshalExtra(&alias, c.Extra)
+}
+
+type choiceAlias Choice
+
+// UnmarshalJSON decodes the choice and keeps unknown fields in Extra
+func (c *Choice) UnmarshalJSON(data []byte) error {
+	var alias choiceAlias
+	extra, err := unmarshalExtra(data, &alias)
+	if err != nil {
+		return err
+	}
+	*c = Choice(alias)
+	c.Extra = extra
+	return nil
+}
+
+// MarshalJSON encodes the choice including unknown fields
+func (c Choice) MarshalJSON() ([]byte, error) {
+	alias := choiceAlias(c)
+	return marshalExtra(&alias, c.Extra)
+}
+
+type chunkChoiceAlias ChunkChoice
+
+// UnmarshalJSON decodes the chunk choice and keeps unknown fields in Extra
+func (c *ChunkChoice) UnmarshalJSON(data []byte) error {
+	var alias chunkChoiceAlias
+	extra, err := unmarshalExtra(data, &alias)
+	if err != nil {
+		return err
+	}
+	*c = ChunkChoice(alias)
+	c.Extra = extra
+	return nil
+}
+
+// MarshalJSON encodes the chunk choice including unknown fields
+func (c ChunkChoice) MarshalJSON() ([]byte, error) {
+	alias := chunkChoiceAlias(c)
+	return marshalExtra(&alias, c.Extra)
+}
+
+type deltaAlias Delta
+
+// UnmarshalJSON decodes the delta and keeps unknown fields in Extra
+func (d *Delta) UnmarshalJSON(data []byte) error {
+	var alias deltaAlias
+	extra, err := unmarshalExtra(data, &alias)
+	if err != nil {
+		return err
+	}
+	*d = Delta(alias)
+	d.Extra = extra
+	return nil
+}
+
+// MarshalJSON encodes the delta including unknown fields
+func (d Delta) MarshalJSON() ([]byte, error) {
+	alias := deltaAlias(d)
+	return marshalExtra(&alias, d.Extra)
+}
+
+type usageAlias Usage
+
+// UnmarshalJSON decodes the usage and keeps unknown fields in Extra
+func (u *Usage) UnmarshalJSON(data []byte) error {
+	var alias usageAlias
+	extra, err := unmarshalExtra(data, &alias)
+	if err != nil {
+		return err
+	}
+	*u = Usage(alias)
+	u.Extra = extra
+	return nil
+}
+
+// MarshalJSON encodes the usage including unknown fields
+func (u Usage) MarshalJSON() ([]byte, error) {
+	alias := usageAlias(u)
+	return marshalExtra(&alias, u.Extra)
+}
+
+type contentPartAlias ContentPart
+
+// UnmarshalJSON decodes the content part and keeps unknown fields in Extra
+func (p *ContentPart) UnmarshalJSON(data []byte) error {
+	var alias contentPartAlias
+	extra, err := unmarshalExtra(data, &alias)
+	if err != nil {
+		return err
+	}
+	*p = ContentPart(alias)
+	p.Extra = extra
+	return nil
+}
+
+// MarshalJSON encodes the content part including unknown fields
+func (p ContentPart) MarshalJSON() ([]byte, error) {
+	alias := contentPartAlias(p)
+	return marshalExtra(&alias, p.Extra)
+}
+
+type imageURLAlias ImageURL
+
+// UnmarshalJSON decodes the image URL and keeps unknown fields in Extra
+func (u *ImageURL) UnmarshalJSON(data []byte) error {
+	var alias imageURLAlias
+	extra, err := unmarshalExtra(data, &alias)
+	if err != nil {
+		return err
+	}
+	*u = ImageURL(alias)
+	u.Extra = extra
+	return nil
+}
+
+// MarshalJSON encodes the image URL including unknown fields
+func (u ImageURL) MarshalJSON() ([]byte, error) {
+	alias := imageURLAlias(u)
+	return marshalExtra(&alias, u.Extra)
+}
+
+type toolAlias Tool
+
+// UnmarshalJSON decodes the tool and keeps unknown fields in Extra
+func (t *Tool) UnmarshalJSON(data []byte) error {
+	var alias toolAlias
+	extra, err := unmarshalExtra(data, &alias)
+	if err != nil {
+		return err
+	}
+	*t = Tool(alias)
+	t.Extra = extra
+	return nil
+}
+
+// MarshalJSON encodes the tool including unknown fields
+func (t Tool) MarshalJSON() ([]byte, error) {
+	alias := toolAlias(t)
+	return marshalExtra(&alias, t.Extra)
+}
+
+type functionDefinitionAlias FunctionDefinition
+
+// UnmarshalJSON decodes the function definition and keeps unknown fields in Extra
+func (f *FunctionDefinition) UnmarshalJSON(data []byte) error {
+	var alias functionDefinitionAlias
+	extra, err := unmarshalExtra(data, &alias)
+	if err != nil {
+		return err
+	}
+	*f = FunctionDefinition(alias)
+	f.Extra = extra
+	return nil
+}
+
+// MarshalJSON encodes the function definition including unknown fields
+func (f FunctionDefinition) MarshalJSON() ([]byte, error) {
+	alias := functionDefinitionAlias(f)
+	return marshalExtra(&alias, f.Extra)
+}
+
+type toolCallAlias ToolCall
+
+// UnmarshalJSON decodes the tool call and keeps unknown fields in Extra
+func (c *ToolCall) UnmarshalJSON(data []byte) error {
+	var alias toolCallAlias
+	extra, err := unmarshalExtra(data, &alias)
+	if err != nil {
+		return err
+	}
+	*c = ToolCall(alias)
+	c.Extra = extra
+	return nil
+}
+
+// MarshalJSON encodes the tool call including unknown fields
+func (c ToolCall) MarshalJSON() ([]byte, error) {
+	alias := toolCallAlias(c)
+	return marshalExtra(&alias, c.Extra)
+}
+
+type functionCallAlias FunctionCall
+
+// UnmarshalJSON decodes the function call and keeps unknown fields in Extra
+func (f *FunctionCall) UnmarshalJSON(data []byte) error {
+	var alias functionCallAlias
+	extra, err := unmarshalExtra(data, &alias)
+	if err != nil {
+		return err
+	}
+	*f = FunctionCall(alias)
+	f.Extra = extra
+	return nil
+}
+
+// MarshalJSON encodes the function call including unknown fields
+func (f FunctionCall) MarshalJSON() ([]byte, error) {
+	alias := functionCallAlias(f)
+	return marshalExtra(&alias, f.Extra)
+}
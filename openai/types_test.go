@@ -0,0 +1,140 @@
+package openai
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestChatCompletionRequestRoundTrip(t *testing.T) {
+	input := `{"model":"qwen3-coder-plus","messages":[{"role":"system","content":"be brief","cache_hint":true},{"role":"user","content":[{"type":"text","text":"hi"},{"type":"image_url","image_url":{"url":"data:image/png;base64,AAAA"}}]},{"role":"assistant","content":null,"tool_calls":[{"id":"call_1","type":"function","function":{"name":"f","arguments":"{}"}}]}],"max_tokens":256,"stop":"END","seed":42,"response_format":{"type":"json_object"}}`
+
+	var req ChatCompletionRequest
+	if err := json.Unmarshal([]byte(input), &req); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+
+	if req.MaxTokens == nil || *req.MaxTokens != 256 {
+		t.Errorf("MaxTokens = %v, want 256", req.MaxTokens)
+	}
+	if len(req.Stop) != 1 || req.Stop[0] != "END" {
+		t.Errorf("Stop = %v, want [END]", req.Stop)
+	}
+	if string(req.Extra["seed"]) != "42" {
+		t.Errorf("Extra[seed] = %s, want 42", req.Extra["seed"])
+	}
+	if string(req.Messages[0].Extra["cache_hint"]) != "true" {
+		t.Errorf("message Extra[cache_hint] = %s, want true", req.Messages[0].Extra["cache_hint"])
+	}
+	if !req.Messages[1].Content.IsParts() || req.Messages[1].Content.String() != "hi" {
+		t.Errorf("multi-part content not decoded: %+v", req.Messages[1].Content)
+	}
+	if !req.Messages[2].Content.IsNull() {
+		t.Errorf("null content not preserved")
+	}
+
+	out, err := json.Marshal(req)
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+
+	var want, got map[string]interface{}
+	json.Unmarshal([]byte(input), &want)
+	json.Unmarshal(out, &got)
+
+	// stop is normalised to a list; everything else must survive unchanged
+	want["stop"] = []interface{}{"END"}
+	wantJSON, _ := json.Marshal(want)
+	gotJSON, _ := json.Marshal(got)
+	if string(wantJSON) != string(gotJSON) {
+		t.Errorf("round trip mismatch\n got: %s\nwant: %s", gotJSON, wantJSON)
+	}
+}
+
+func TestMessageContent(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    string
+		isParts bool
+		wantErr bool
+	}{
+		{"string", `"hello"`, "hello", false, false},
+		{"parts", `[{"type":"text","text":"a"},{"type":"text","text":"b"}]`, "ab", true, false},
+		{"null", `null`, "", false, false},
+		{"number", `42`, "", false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var c MessageContent
+			err := json.Unmarshal([]byte(tt.input), &c)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Unmarshal() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if c.String() != tt.want {
+				t.Errorf("String() = %q, want %q", c.String(), tt.want)
+			}
+			if c.IsParts() != tt.isParts {
+				t.Errorf("IsParts() = %v, want %v", c.IsParts(), tt.isParts)
+			}
+			out, _ := json.Marshal(c)
+			if string(out) != tt.input {
+				t.Errorf("Marshal() = %s, want %s", out, tt.input)
+			}
+		})
+	}
+}
+
+func TestChatCompletionChunkKeepsUnknownFields(t *testing.T) {
+	input := `{"id":"c1","object":"chat.completion.chunk","created":1,"model":"m","choices":[{"index":0,"delta":{"content":"x","refusal":null},"finish_reason":null,"logprobs":{"content":[]}}],"x_request_id":"abc"}`
+
+	var chunk ChatCompletionChunk
+	if err := json.Unmarshal([]byte(input), &chunk); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if chunk.Choices[0].Delta.Content != "x" {
+		t.Errorf("Delta.Content = %q, want x", chunk.Choices[0].Delta.Content)
+	}
+
+	out, _ := json.Marshal(chunk)
+	var want, got interface{}
+	json.Unmarshal([]byte(input), &want)
+	json.Unmarshal(out, &got)
+	wantJSON, _ := json.Marshal(want)
+	gotJSON, _ := json.Marshal(got)
+	if string(wantJSON) != string(gotJSON) {
+		t.Errorf("round trip mismatch\n got: %s\nwant: %s", gotJSON, wantJSON)
+	}
+}
+
+func TestContentPartsAndToolsRoundTrip(t *testing.T) {
+	input := `{"model":"m","messages":[{"role":"user","content":[{"type":"text","text":"hi","cache_control":{"type":"ephemeral"}},{"type":"input_audio","input_audio":{"data":"AAAA","format":"wav"}},{"type":"image_url","image_url":{"url":"https://example.com/a.png","vendor_hint":1}}]},{"role":"assistant","content":null,"tool_calls":[{"id":"call_1","type":"function","function":{"name":"f","arguments":"{}","thought_signature":"sig"},"vendor_id":"v1"}]}],"tools":[{"type":"function","function":{"name":"f","parameters":{"type":"object"},"cache_control":{"type":"ephemeral"}},"vendor_tool_key":true}]}`
+
+	var req ChatCompletionRequest
+	if err := json.Unmarshal([]byte(input), &req); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	parts := req.Messages[0].Content.Parts
+	if len(parts) != 3 || string(parts[1].Extra["input_audio"]) != `{"data":"AAAA","format":"wav"}` {
+		t.Errorf("parts = %+v, want the input_audio payload kept", parts)
+	}
+	if string(req.Tools[0].Extra["vendor_tool_key"]) != "true" {
+		t.Errorf("tool Extra = %v, want vendor_tool_key", req.Tools[0].Extra)
+	}
+
+	out, err := json.Marshal(req)
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	var want, got map[string]interface{}
+	json.Unmarshal([]byte(input), &want)
+	json.Unmarshal(out, &got)
+	wantJSON, _ := json.Marshal(want)
+	gotJSON, _ := json.Marshal(got)
+	if string(wantJSON) != string(gotJSON) {
+		t.Errorf("round trip mismatch\n got: %s\nwant: %s", gotJSON, wantJSON)
+	}
+}
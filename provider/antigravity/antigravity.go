@@ -16,8 +16,11 @@ import (
 
 	"github.com/sunbankio/qwencoder-proxy/auth"
 	"github.com/sunbankio/qwencoder-proxy/logging"
+	"github.com/sunbankio/qwencoder-proxy/metrics"
 	"github.com/sunbankio/qwencoder-proxy/provider"
 	"github.com/sunbankio/qwencoder-proxy/provider/gemini"
+	"github.com/sunbankio/qwencoder-proxy/tracing"
+	"github.com/sunbankio/qwencoder-proxy/transport"
 )
 
 const (
@@ -59,6 +62,7 @@ var ModelAliasMapping = map[string]string{
 
 // Provider implements the provider.Provider interface for Antigravity
 type Provider struct {
+	name            provider.ProviderType
 	dailyBaseURL    string
 	autopushBaseURL string
 	authenticator   *auth.GeminiAuthenticator // Antigravity uses similar auth to Gemini CLI
@@ -68,35 +72,53 @@ type Provider struct {
 	isInitialized   bool
 	cachedModels    map[string]bool
 	cacheMu         sync.RWMutex
+	modelMappings   map[string]string // added to ModelAliasMapping for this instance
+}
+
+// DefaultOAuthConfig returns the OAuth configuration of Antigravity, which signs in with Google
+func DefaultOAuthConfig() *auth.GeminiOAuthConfig {
+	return &auth.GeminiOAuthConfig{
+		ClientID:     "1071006060591-tmhssin2h21lcre235vtolojh4g403ep.apps.googleusercontent.com",
+		ClientSecret: "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",
+		Scope:        "https://www.googleapis.com/auth/cloud-platform",
+		RedirectPort: 8086,
+		CredsDir:     ".antigravity",
+		CredsFile:    "oauth_creds.json",
+		ProviderName: string(provider.ProviderAntigravity),
+	}
 }
 
 // NewProvider creates a new Antigravity provider
 func NewProvider(authenticator *auth.GeminiAuthenticator) *Provider {
 	if authenticator == nil {
-		authenticator = auth.NewGeminiAuthenticator(&auth.GeminiOAuthConfig{
-			ClientID:     "1071006060591-tmhssin2h21lcre235vtolojh4g403ep.apps.googleusercontent.com",
-			ClientSecret: "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",
-			Scope:        "https://www.googleapis.com/auth/cloud-platform",
-			RedirectPort: 8086,
-			CredsDir:     ".antigravity",
-			CredsFile:    "oauth_creds.json",
-		})
+		authenticator = auth.NewGeminiAuthenticator(DefaultOAuthConfig())
 	}
 	return &Provider{
+		name:            provider.ProviderAntigravity,
 		dailyBaseURL:    DefaultDailyBaseURL,
 		autopushBaseURL: DefaultAutopushBaseURL,
 		authenticator:   authenticator,
-		httpClient:      &http.Client{Timeout: 5 * time.Minute},
-		logger:          logging.NewLogger(),
+		httpClient:      transport.NewClient(string(provider.ProviderAntigravity)),
+		logger:          logging.NewLogger("antigravity"),
 		cachedModels:    make(map[string]bool),
 	}
 }
 
 // Name returns the provider identifier
 func (p *Provider) Name() provider.ProviderType {
+	return p.name
+}
+
+// Type returns the provider type, which differs from the name of additional accounts
+func (p *Provider) Type() provider.ProviderType {
 	return provider.ProviderAntigravity
 }
 
+// SetName sets the name the provider is registered and routed under, such as the name of an additional account
+func (p *Provider) SetName(name provider.ProviderType) {
+	p.name = name
+}
+
 // Protocol returns the native protocol
 func (p *Provider) Protocol() provider.ProtocolType {
 	return provider.ProtocolGemini
@@ -160,7 +182,7 @@ func (p *Provider) GetAuthenticator() provider.Authenticator {
 func (p *Provider) IsHealthy(ctx context.Context) bool {
 	// Initialize the provider as part of health check
 	if err := p.Initialize(ctx); err != nil {
-		p.logger.ErrorLog("[Antigravity] Health check failed during initialization: %v", err)
+		p.logger.WithContext(ctx).ErrorLog("[Antigravity] Health check failed during initialization: %v", err)
 		return false
 	}
 
@@ -171,6 +193,7 @@ func (p *Provider) IsHealthy(ctx context.Context) bool {
 
 // doRequestWithRetry executes a request with retry logic for 401 updates
 func (p *Provider) doRequestWithRetry(ctx context.Context, reqFunc func(string) (*http.Response, error)) (*http.Response, error) {
+	logger := p.logger.WithContext(ctx)
 	// First attempt
 	token, err := p.authenticator.GetToken(ctx)
 	if err != nil {
@@ -184,12 +207,12 @@ func (p *Provider) doRequestWithRetry(ctx context.Context, reqFunc func(string)
 
 	// Check for 401
 	if resp.StatusCode == http.StatusUnauthorized {
-		p.logger.DebugLog("[Antigravity] Received 401 Unauthorized. Retrying with fresh token...")
+		logger.DebugLog("[Antigravity] Received 401 Unauthorized. Retrying with fresh token...")
 		resp.Body.Close() // Close the failed response body
 
 		// Force refresh
 		if err := p.authenticator.ForceRefresh(ctx); err != nil {
-			p.logger.ErrorLog("[Antigravity] Failed to force refresh token: %v", err)
+			logger.ErrorLog("[Antigravity] Failed to force refresh token: %v", err)
 			return nil, fmt.Errorf("failed to force refresh token: %w", err)
 		}
 
@@ -199,6 +222,7 @@ func (p *Provider) doRequestWithRetry(ctx context.Context, reqFunc func(string)
 			return nil, fmt.Errorf("failed to get refreshed token: %w", err)
 		}
 
+		metrics.Retries.Inc(string(p.Name()), metrics.RetryAuth)
 		return reqFunc(token)
 	}
 
@@ -207,6 +231,7 @@ func (p *Provider) doRequestWithRetry(ctx context.Context, reqFunc func(string)
 
 // ListModels returns available models in native format
 func (p *Provider) ListModels(ctx context.Context) (interface{}, error) {
+	logger := p.logger.WithContext(ctx)
 	// Initialize if not already done
 	if !p.isInitialized {
 		if err := p.Initialize(ctx); err != nil {
@@ -225,7 +250,7 @@ func (p *Provider) ListModels(ctx context.Context) (interface{}, error) {
 		req.Header.Set("Content-Type", "application/json")
 		req.Header.Set("User-Agent", DefaultUserAgent)
 
-		p.logger.DebugLog("[Antigravity] Sending fetchAvailableModels request to %s", url)
+		logger.DebugLog("[Antigravity] Sending fetchAvailableModels request to %s", url)
 		return p.httpClient.Do(req)
 	}
 
@@ -237,7 +262,7 @@ func (p *Provider) ListModels(ctx context.Context) (interface{}, error) {
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("API error (status %d): %s", resp.StatusCode, string(body))
+		return nil, provider.NewAPIError(p.Name(), resp, body)
 	}
 
 	// Debug: read the raw response body to see what we get
@@ -246,7 +271,7 @@ func (p *Provider) ListModels(ctx context.Context) (interface{}, error) {
 		return nil, fmt.Errorf("failed to read response body: %w", err)
 	}
 	
-	p.logger.DebugLog("[Antigravity] Raw fetchAvailableModels response: %s", string(respBody))
+	logger.DebugLog("[Antigravity] Raw fetchAvailableModels response: %s", string(respBody))
 	
 	// Try to unmarshal the response into a generic map
 	var rawResponse map[string]interface{}
@@ -307,10 +332,10 @@ func (p *Provider) ListModels(ctx context.Context) (interface{}, error) {
 				}
 			}
 		} else {
-			p.logger.ErrorLog("[Antigravity] models field is not an object: %T", modelsData)
+			logger.ErrorLog("[Antigravity] models field is not an object: %T", modelsData)
 		}
 	} else {
-		p.logger.ErrorLog("[Antigravity] No models field found in response: %+v", rawResponse)
+		logger.ErrorLog("[Antigravity] No models field found in response: %+v", rawResponse)
 	}
 
 	// Update dynamic cache
@@ -325,7 +350,8 @@ func (p *Provider) ListModels(ctx context.Context) (interface{}, error) {
 
 // discoverProjectAndModels discovers the project ID and available models
 func (p *Provider) discoverProjectAndModels(ctx context.Context) (string, error) {
-	p.logger.DebugLog("[Antigravity] Discovering Project ID...")
+	logger := p.logger.WithContext(ctx)
+	logger.DebugLog("[Antigravity] Discovering Project ID...")
 
 	// Prepare client metadata
 	clientMetadata := map[string]interface{}{
@@ -348,7 +374,7 @@ func (p *Provider) discoverProjectAndModels(ctx context.Context) (string, error)
 	for _, baseURL := range baseURLs {
 		projectID, err := p.callAPI(ctx, "loadCodeAssist", loadRequest, baseURL)
 		if err != nil {
-			p.logger.ErrorLog("[Antigravity] Error calling loadCodeAssist on %s: %v", baseURL, err)
+			logger.ErrorLog("[Antigravity] Error calling loadCodeAssist on %s: %v", baseURL, err)
 			lastErr = err
 			continue
 		}
@@ -356,7 +382,7 @@ func (p *Provider) discoverProjectAndModels(ctx context.Context) (string, error)
 		// Check if we already have a project ID from the response
 		if project, exists := projectID["cloudaicompanionProject"]; exists && project != nil {
 			if projectStr, ok := project.(string); ok && projectStr != "" {
-				p.logger.DebugLog("[Antigravity] Discovered existing Project ID: %s", projectStr)
+				logger.DebugLog("[Antigravity] Discovered existing Project ID: %s", projectStr)
 				return projectStr, nil
 			}
 		}
@@ -395,7 +421,7 @@ func (p *Provider) discoverProjectAndModels(ctx context.Context) (string, error)
 		// Call onboardUser
 		lroResponse, err := p.callAPI(ctx, "onboardUser", onboardRequest, baseURL)
 		if err != nil {
-			p.logger.ErrorLog("[Antigravity] Error calling onboardUser on %s: %v", baseURL, err)
+			logger.ErrorLog("[Antigravity] Error calling onboardUser on %s: %v", baseURL, err)
 			lastErr = err
 			continue
 		}
@@ -442,14 +468,14 @@ func (p *Provider) discoverProjectAndModels(ctx context.Context) (string, error)
 		}
 
 		if discoveredProjectId != "" {
-			p.logger.DebugLog("[Antigravity] Onboarded and discovered Project ID: %s", discoveredProjectId)
+			logger.DebugLog("[Antigravity] Onboarded and discovered Project ID: %s", discoveredProjectId)
 			return discoveredProjectId, nil
 		}
 	}
 
 	// If all base URLs failed, return fallback project ID
 	fallbackProjectId := p.generateProjectID()
-	p.logger.DebugLog("[Antigravity] Generated fallback Project ID: %s", fallbackProjectId)
+	logger.DebugLog("[Antigravity] Generated fallback Project ID: %s", fallbackProjectId)
 
 	if lastErr != nil {
 		return fallbackProjectId, fmt.Errorf("all base URLs failed, using fallback: %w", lastErr)
@@ -477,7 +503,7 @@ func (p *Provider) callAPI(ctx context.Context, method string, body map[string]i
 		req.Header.Set("Content-Type", "application/json")
 		req.Header.Set("User-Agent", DefaultUserAgent)
 
-		p.logger.DebugLog("[Antigravity] Sending %s request to %s", method, url)
+		p.logger.WithContext(ctx).DebugLog("[Antigravity] Sending %s request to %s", method, url)
 		return p.httpClient.Do(req)
 	}
 
@@ -489,7 +515,7 @@ func (p *Provider) callAPI(ctx context.Context, method string, body map[string]i
 
 	if resp.StatusCode != http.StatusOK {
 		bodyContent, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("API error (status %d): %s", resp.StatusCode, string(bodyContent))
+		return nil, provider.NewAPIError(p.Name(), resp, bodyContent)
 	}
 
 	var response map[string]interface{}
@@ -501,12 +527,19 @@ func (p *Provider) callAPI(ctx context.Context, method string, body map[string]i
 }
 
 // Initialize performs the initialization process for the Antigravity provider
-func (p *Provider) Initialize(ctx context.Context) error {
+func (p *Provider) Initialize(ctx context.Context) (err error) {
+	logger := p.logger.WithContext(ctx)
 	if p.isInitialized {
 		return nil
 	}
+	ctx, span := tracing.Start(ctx, "Initialize", tracing.String("proxy.provider", string(p.Name())))
+	defer func() {
+		span.SetAttributes(tracing.String("antigravity.project_id", p.projectID))
+		span.RecordError(err)
+		span.End()
+	}()
 
-	p.logger.DebugLog("[Antigravity] Initializing Antigravity API Service...")
+	logger.DebugLog("[Antigravity] Initializing Antigravity API Service...")
 
 	// Initialize auth
 	if err := p.initializeAuth(ctx); err != nil {
@@ -521,11 +554,11 @@ func (p *Provider) Initialize(ctx context.Context) error {
 		}
 		p.projectID = projectID
 	} else {
-		p.logger.DebugLog("[Antigravity] Using provided Project ID: %s", p.projectID)
+		logger.DebugLog("[Antigravity] Using provided Project ID: %s", p.projectID)
 	}
 
 	p.isInitialized = true
-	p.logger.DebugLog("[Antigravity] Initialization complete. Project ID: %s", p.projectID)
+	logger.DebugLog("[Antigravity] Initialization complete. Project ID: %s", p.projectID)
 	return nil
 }
 
@@ -695,10 +728,7 @@ func (p *Provider) GenerateContent(ctx context.Context, model string, request in
 	}
 
 	// Map model alias if needed
-	actualModel := model
-	if alias, exists := ModelAliasMapping[model]; exists {
-		actualModel = alias
-	}
+	actualModel := p.mapModel(model)
 
 	// Convert request to map for processing
 	var reqMap map[string]interface{}
@@ -726,8 +756,9 @@ func (p *Provider) GenerateContent(ctx context.Context, model string, request in
 	// Use the correct endpoint
 	url := fmt.Sprintf("%s/%s:generateContent", p.getBaseURLForModel(actualModel), APIVersion)
 
+	reqCtx := transport.WithModel(ctx, model, false)
 	reqFunc := func(token string) (*http.Response, error) {
-		req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(reqBody))
+		req, err := http.NewRequestWithContext(reqCtx, "POST", url, bytes.NewReader(reqBody))
 		if err != nil {
 			return nil, fmt.Errorf("failed to create request: %w", err)
 		}
@@ -736,7 +767,7 @@ func (p *Provider) GenerateContent(ctx context.Context, model string, request in
 		req.Header.Set("Content-Type", "application/json")
 		req.Header.Set("User-Agent", DefaultUserAgent)
 
-		p.logger.DebugLog("[Antigravity] Sending generateContent request to %s", url)
+		p.logger.WithContext(ctx).DebugLog("[Antigravity] Sending generateContent request to %s", url)
 		return p.httpClient.Do(req)
 	}
 
@@ -748,7 +779,7 @@ func (p *Provider) GenerateContent(ctx context.Context, model string, request in
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("API error (status %d): %s", resp.StatusCode, string(body))
+		return nil, provider.NewAPIError(p.Name(), resp, body)
 	}
 
 	var rawResponse map[string]interface{}
@@ -787,10 +818,7 @@ func (p *Provider) GenerateContentStream(ctx context.Context, model string, requ
 	}
 
 	// Map model alias if needed
-	actualModel := model
-	if alias, exists := ModelAliasMapping[model]; exists {
-		actualModel = alias
-	}
+	actualModel := p.mapModel(model)
 
 	// Convert request to map for processing
 	var reqMap map[string]interface{}
@@ -818,8 +846,9 @@ func (p *Provider) GenerateContentStream(ctx context.Context, model string, requ
 	// Use the correct streaming endpoint with alt=sse parameter
 	url := fmt.Sprintf("%s/%s:streamGenerateContent?alt=sse", p.getBaseURLForModel(actualModel), APIVersion)
 
+	reqCtx := transport.WithModel(ctx, model, true)
 	reqFunc := func(token string) (*http.Response, error) {
-		req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(reqBody))
+		req, err := http.NewRequestWithContext(reqCtx, "POST", url, bytes.NewReader(reqBody))
 		if err != nil {
 			return nil, fmt.Errorf("failed to create request: %w", err)
 		}
@@ -829,7 +858,7 @@ func (p *Provider) GenerateContentStream(ctx context.Context, model string, requ
 		req.Header.Set("User-Agent", DefaultUserAgent)
 		req.Header.Set("Accept", "text/event-stream")
 
-		p.logger.DebugLog("[Antigravity] Sending streaming generateContent request to %s", url)
+		p.logger.WithContext(ctx).DebugLog("[Antigravity] Sending streaming generateContent request to %s", url)
 		return p.httpClient.Do(req)
 	}
 
@@ -842,7 +871,7 @@ func (p *Provider) GenerateContentStream(ctx context.Context, model string, requ
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
 		resp.Body.Close()
-		return nil, fmt.Errorf("API error (status %d): %s", resp.StatusCode, string(body))
+		return nil, provider.NewAPIError(p.Name(), resp, body)
 	}
 
 	return resp.Body, nil
@@ -874,3 +903,19 @@ func (p *Provider) SetDailyBaseURL(url string) {
 func (p *Provider) SetAutopushBaseURL(url string) {
 	p.autopushBaseURL = url
 }
+
+// SetModelMappings sets model aliases of this provider, used ahead of ModelAliasMapping
+func (p *Provider) SetModelMappings(mappings map[string]string) {
+	p.modelMappings = mappings
+}
+
+// mapModel returns the model name the Antigravity API expects for model
+func (p *Provider) mapModel(model string) string {
+	if alias, exists := p.modelMappings[model]; exists {
+		return alias
+	}
+	if alias, exists := ModelAliasMapping[model]; exists {
+		return alias
+	}
+	return model
+}
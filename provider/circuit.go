@@ -0,0 +1,204 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// CircuitState is the state of a provider's circuit breaker
+type CircuitState int
+
+const (
+	// CircuitClosed routes requests to the provider normally
+	CircuitClosed CircuitState = iota
+	// CircuitHalfOpen lets requests through again after the cooldown; the next result decides
+	CircuitHalfOpen
+	// CircuitOpen skips the provider while another one serves the model
+	CircuitOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitHalfOpen:
+		return "half-open"
+	case CircuitOpen:
+		return "open"
+	}
+	return "closed"
+}
+
+// Default circuit breaker settings
+const (
+	circuitFailureThreshold = 5                // Consecutive failures that open the circuit
+	circuitCooldown         = 30 * time.Second // Time an open circuit waits before letting requests through
+)
+
+// CircuitBreaker holds the settings of the providers' circuit breakers
+type CircuitBreaker struct {
+	// FailureThreshold is the number of consecutive failures that opens a circuit
+	FailureThreshold int
+	// Cooldown is how long an open circuit waits before letting requests through again
+	Cooldown time.Duration
+}
+
+// defaultCircuitBreaker is used until SetCircuitBreaker is called
+var defaultCircuitBreaker = CircuitBreaker{FailureThreshold: circuitFailureThreshold, Cooldown: circuitCooldown}
+
+// circuit tracks the recent failures of one provider
+type circuit struct {
+	failures int
+	openedAt time.Time     // Zero while closed
+	cooldown time.Duration // How long the circuit stays open; zero means the breaker's cooldown
+}
+
+// state returns the circuit's state at now
+func (c *circuit) state(now time.Time, breaker CircuitBreaker) CircuitState {
+	cooldown := c.cooldown
+	if cooldown == 0 {
+		cooldown = breaker.Cooldown
+	}
+	switch {
+	case c.openedAt.IsZero():
+		return CircuitClosed
+	case now.Sub(c.openedAt) < cooldown:
+		return CircuitOpen
+	}
+	return CircuitHalfOpen
+}
+
+// fail counts a failure, opening the circuit at the threshold or again after a half-open
+// trial. An upstream asking to retry after longer than the cooldown, such as a rate limit
+// lasting until its next window, opens the circuit for that long at once.
+func (c *circuit) fail(now time.Time, retryAfter time.Duration, breaker CircuitBreaker) {
+	c.failures++
+	switch {
+	case retryAfter > breaker.Cooldown:
+		c.openedAt, c.cooldown = now, retryAfter
+	case c.failures >= breaker.FailureThreshold || c.state(now, breaker) == CircuitHalfOpen:
+		c.openedAt, c.cooldown = now, 0
+	}
+}
+
+// tripsCircuit reports whether an error points at the provider rather than the request:
+// server errors, rate limits, rejected credentials and failed connections
+func tripsCircuit(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, ErrStreamingUnsupported) {
+		return false
+	}
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.StatusCode {
+		case http.StatusUnauthorized, http.StatusForbidden, http.StatusTooManyRequests:
+			return true
+		}
+		return apiErr.StatusCode >= http.StatusInternalServerError
+	}
+	return true
+}
+
+// maxRecentErrors bounds the provider failures kept for the admin dashboard
+const maxRecentErrors = 50
+
+// ProviderError is a failure of a provider
+type ProviderError struct {
+	Provider ProviderType `json:"provider,omitempty"`
+	Model    string       `json:"model,omitempty"` // Empty for health check failures
+	Message  string       `json:"message"`
+	Time     time.Time    `json:"time"`
+}
+
+// RecordFailure records that a provider failed to serve a model. Failures caused by the
+// request itself are ignored; enough consecutive provider failures open its circuit.
+func (f *Factory) RecordFailure(model string, providerType ProviderType, err error) {
+	if !tripsCircuit(err) {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	c, ok := f.circuits[providerType]
+	if !ok {
+		c = &circuit{}
+		f.circuits[providerType] = c
+	}
+	var retryAfter time.Duration
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		retryAfter = apiErr.RetryAfter
+	}
+	now := time.Now()
+	c.fail(now, retryAfter, f.breaker)
+	f.dirty = true
+	failure := ProviderError{Provider: providerType, Model: model, Message: err.Error(), Time: now}
+	f.lastErrors[providerType] = failure
+	if len(f.recentErrors) == maxRecentErrors {
+		f.recentErrors = append(f.recentErrors[:0], f.recentErrors[1:]...)
+	}
+	f.recentErrors = append(f.recentErrors, failure)
+}
+
+// RecentErrors returns the latest provider failures, newest first
+func (f *Factory) RecentErrors() []ProviderError {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	errs := make([]ProviderError, len(f.recentErrors))
+	for i, failure := range f.recentErrors {
+		errs[len(errs)-1-i] = failure
+	}
+	return errs
+}
+
+// LastError returns the last failure recorded for a provider, if any
+func (f *Factory) LastError(providerType ProviderType) (ProviderError, bool) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	lastErr, ok := f.lastErrors[providerType]
+	return lastErr, ok
+}
+
+// SetCircuitBreaker replaces the circuit breaker settings. Settings that are zero or
+// negative keep their defaults.
+func (f *Factory) SetCircuitBreaker(breaker CircuitBreaker) {
+	if breaker.FailureThreshold <= 0 {
+		breaker.FailureThreshold = circuitFailureThreshold
+	}
+	if breaker.Cooldown <= 0 {
+		breaker.Cooldown = circuitCooldown
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.breaker = breaker
+}
+
+// CircuitState returns the state of a provider's circuit breaker
+func (f *Factory) CircuitState(providerType ProviderType) CircuitState {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.circuitState(providerType, time.Now())
+}
+
+// circuitState returns a provider's circuit state. The caller must hold f.mu.
+func (f *Factory) circuitState(providerType ProviderType, now time.Time) CircuitState {
+	if c, ok := f.circuits[providerType]; ok {
+		return c.state(now, f.breaker)
+	}
+	return CircuitClosed
+}
+
+// available filters out the candidates whose circuit is open, unless that leaves none.
+// The caller must hold f.mu.
+func (f *Factory) available(candidates []Provider) []Provider {
+	now := time.Now()
+	usable := make([]Provider, 0, len(candidates))
+	for _, p := range candidates {
+		if f.circuitState(p.Name(), now) != CircuitOpen {
+			usable = append(usable, p)
+		}
+	}
+	if len(usable) == 0 {
+		return candidates
+	}
+	return usable
+}
@@ -0,0 +1,146 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// stubProvider serves a single model; other Provider methods are not used by the factory
+type stubProvider struct {
+	Provider
+	name ProviderType
+}
+
+func (s *stubProvider) Name() ProviderType { return s.name }
+func (s *stubProvider) ListModels(ctx context.Context) (interface{}, error) {
+	return map[string]interface{}{"data": []interface{}{map[string]interface{}{"id": "shared-model"}}}, nil
+}
+
+func TestTripsCircuit(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"canceled", fmt.Errorf("read: %w", context.Canceled), false},
+		{"streaming unsupported", ErrStreamingUnsupported, false},
+		{"bad request", &APIError{StatusCode: http.StatusBadRequest}, false},
+		{"not found", &APIError{StatusCode: http.StatusNotFound}, false},
+		{"unauthorized", &APIError{StatusCode: http.StatusUnauthorized}, true},
+		{"rate limited", &APIError{StatusCode: http.StatusTooManyRequests}, true},
+		{"server error", fmt.Errorf("upstream: %w", &APIError{StatusCode: http.StatusBadGateway}), true},
+		{"connection refused", errors.New("dial tcp: connection refused"), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tripsCircuit(tt.err); got != tt.want {
+				t.Errorf("tripsCircuit(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCircuitStates(t *testing.T) {
+	start := time.Now()
+	c := &circuit{}
+	for i := 1; i < circuitFailureThreshold; i++ {
+		c.fail(start, 0, defaultCircuitBreaker)
+	}
+	if got := c.state(start, defaultCircuitBreaker); got != CircuitClosed {
+		t.Fatalf("state after %d failures = %v, want closed", circuitFailureThreshold-1, got)
+	}
+	c.fail(start, 0, defaultCircuitBreaker)
+	if got := c.state(start, defaultCircuitBreaker); got != CircuitOpen {
+		t.Fatalf("state at threshold = %v, want open", got)
+	}
+
+	trial := start.Add(circuitCooldown)
+	if got := c.state(trial, defaultCircuitBreaker); got != CircuitHalfOpen {
+		t.Fatalf("state after cooldown = %v, want half-open", got)
+	}
+	c.fail(trial, 0, defaultCircuitBreaker)
+	if got := c.state(trial.Add(time.Second), defaultCircuitBreaker); got != CircuitOpen {
+		t.Errorf("state after failed trial = %v, want open", got)
+	}
+}
+
+func TestCircuitRetryAfter(t *testing.T) {
+	start := time.Now()
+	c := &circuit{}
+	c.fail(start, circuitCooldown/2, defaultCircuitBreaker)
+	if got := c.state(start, defaultCircuitBreaker); got != CircuitClosed {
+		t.Fatalf("state after a short Retry-After = %v, want closed", got)
+	}
+	c.fail(start, 10*time.Minute, defaultCircuitBreaker)
+	if got := c.state(start.Add(circuitCooldown), defaultCircuitBreaker); got != CircuitOpen {
+		t.Errorf("state after the default cooldown = %v, want open until Retry-After", got)
+	}
+	if got := c.state(start.Add(10*time.Minute), defaultCircuitBreaker); got != CircuitHalfOpen {
+		t.Errorf("state after Retry-After = %v, want half-open", got)
+	}
+}
+
+func TestFactorySkipsOpenCircuits(t *testing.T) {
+	f := NewFactory()
+	f.Register(&stubProvider{name: ProviderQwen})
+	f.Register(&stubProvider{name: ProviderIFlow})
+	if err := f.PopulateModelProviders(context.Background()); err != nil {
+		t.Fatalf("PopulateModelProviders() error = %v", err)
+	}
+	f.RecordSuccess("shared-model", ProviderQwen)
+
+	serverErr := &APIError{StatusCode: http.StatusServiceUnavailable}
+	for i := 0; i < circuitFailureThreshold; i++ {
+		f.RecordFailure("shared-model", ProviderQwen, serverErr)
+	}
+	if got := f.CircuitState(ProviderQwen); got != CircuitOpen {
+		t.Fatalf("CircuitState(qwen) = %v, want open", got)
+	}
+	p, err := f.GetByModel("shared-model")
+	if err != nil || p.Name() != ProviderIFlow {
+		t.Errorf("GetByModel() = %v, %v; want iflow while qwen's circuit is open", p, err)
+	}
+
+	// With every circuit open the model is still served rather than refused
+	for i := 0; i < circuitFailureThreshold; i++ {
+		f.RecordFailure("shared-model", ProviderIFlow, serverErr)
+	}
+	if _, err := f.GetByModel("shared-model"); err != nil {
+		t.Errorf("GetByModel() with all circuits open error = %v", err)
+	}
+	if alt, err := f.GetAlternativeProvider("shared-model", ProviderQwen); err != nil || alt.Name() != ProviderIFlow {
+		t.Errorf("GetAlternativeProvider() = %v, %v; want iflow", alt, err)
+	}
+
+	f.RecordSuccess("shared-model", ProviderQwen)
+	if got := f.CircuitState(ProviderQwen); got != CircuitClosed {
+		t.Errorf("CircuitState(qwen) after success = %v, want closed", got)
+	}
+}
+
+func TestSetCircuitBreaker(t *testing.T) {
+	f := NewFactory()
+	f.SetCircuitBreaker(CircuitBreaker{FailureThreshold: 2, Cooldown: time.Hour})
+	serverErr := &APIError{StatusCode: http.StatusServiceUnavailable}
+	f.RecordFailure("shared-model", ProviderQwen, serverErr)
+	if got := f.CircuitState(ProviderQwen); got != CircuitClosed {
+		t.Fatalf("CircuitState(qwen) after one failure = %v, want closed", got)
+	}
+	f.RecordFailure("shared-model", ProviderQwen, serverErr)
+	if got := f.CircuitState(ProviderQwen); got != CircuitOpen {
+		t.Fatalf("CircuitState(qwen) at the configured threshold = %v, want open", got)
+	}
+	if got := f.circuits[ProviderQwen].state(time.Now().Add(circuitCooldown), f.breaker); got != CircuitOpen {
+		t.Errorf("state after the default cooldown = %v, want open for the configured hour", got)
+	}
+
+	f.SetCircuitBreaker(CircuitBreaker{})
+	if f.breaker != defaultCircuitBreaker {
+		t.Errorf("breaker = %+v, want the defaults for zero settings", f.breaker)
+	}
+}
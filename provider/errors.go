@@ -0,0 +1,82 @@
+package provider
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrStreamingUnsupported is returned by GenerateContentStream when the upstream only serves unary calls;
+// callers fall back to GenerateContent and replay the response as a stream
+var ErrStreamingUnsupported = errors.New("streaming not supported by upstream")
+
+// APIError is returned when an upstream API answers with a non-success status
+type APIError struct {
+	Provider   ProviderType
+	StatusCode int
+	Body       string
+	RetryAfter time.Duration // zero when the upstream did not send Retry-After
+}
+
+// NewAPIError builds an APIError from an upstream response and its already-read body
+func NewAPIError(providerType ProviderType, resp *http.Response, body []byte) *APIError {
+	return &APIError{
+		Provider:   providerType,
+		StatusCode: resp.StatusCode,
+		Body:       string(body),
+		RetryAfter: ParseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+	}
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("API error (status %d): %s", e.StatusCode, e.Body)
+}
+
+// Message returns the human readable message from the upstream error body,
+// falling back to the raw body when it is not a recognised JSON error shape
+func (e *APIError) Message() string {
+	var body struct {
+		Error   json.RawMessage `json:"error"`
+		Message string          `json:"message"`
+	}
+	if err := json.Unmarshal([]byte(e.Body), &body); err == nil {
+		var nested struct {
+			Message string `json:"message"`
+		}
+		var text string
+		switch {
+		case json.Unmarshal(body.Error, &nested) == nil && nested.Message != "":
+			return nested.Message
+		case json.Unmarshal(body.Error, &text) == nil && text != "":
+			return text
+		case body.Message != "":
+			return body.Message
+		}
+	}
+	if msg := strings.TrimSpace(e.Body); msg != "" {
+		return msg
+	}
+	return http.StatusText(e.StatusCode)
+}
+
+// ParseRetryAfter parses a Retry-After header given in seconds or as an HTTP date
+func ParseRetryAfter(value string, now time.Time) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(value); err == nil {
+		if secs < 0 {
+			return 0
+		}
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+	return 0
+}
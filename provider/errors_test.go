@@ -0,0 +1,45 @@
+package provider
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{"", 0},
+		{"30", 30 * time.Second},
+		{"-5", 0},
+		{now.Add(90 * time.Second).Format(http.TimeFormat), 90 * time.Second},
+		{"garbage", 0},
+	}
+	for _, tt := range tests {
+		if got := ParseRetryAfter(tt.value, now); got != tt.want {
+			t.Errorf("ParseRetryAfter(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestAPIErrorMessage(t *testing.T) {
+	tests := []struct {
+		body string
+		want string
+	}{
+		{`{"error":{"message":"quota exceeded","code":429}}`, "quota exceeded"},
+		{`{"error":"invalid_token"}`, "invalid_token"},
+		{`{"message":"Improperly formed request."}`, "Improperly formed request."},
+		{"upstream exploded", "upstream exploded"},
+		{"", "Service Unavailable"},
+	}
+	for _, tt := range tests {
+		e := &APIError{StatusCode: http.StatusServiceUnavailable, Body: tt.body}
+		if got := e.Message(); got != tt.want {
+			t.Errorf("Message() for %q = %q, want %q", tt.body, got, tt.want)
+		}
+	}
+}
@@ -8,6 +8,9 @@ import (
 	"math/rand"
 	"sync"
 	"time"
+
+	"github.com/sunbankio/qwencoder-proxy/logging"
+	"github.com/sunbankio/qwencoder-proxy/metrics"
 )
 
 // ModelProviderMap maps models to the providers that support them
@@ -23,11 +26,25 @@ type OpenAIModel struct {
 
 // Factory manages provider instances
 type Factory struct {
-	providers      map[ProviderType]Provider
-	lastSuccess    map[string]ProviderType // model -> providerType
-	modelProviders ModelProviderMap        // model -> list of providers
-	mu             sync.RWMutex
-	rng            *rand.Rand
+	providers       map[ProviderType]Provider
+	lastSuccess     map[string]ProviderType // model -> providerType
+	modelProviders  ModelProviderMap        // model -> list of providers
+	contextLimits   map[ProviderType]map[string]int
+	circuits        map[ProviderType]*circuit
+	breaker         CircuitBreaker
+	lastErrors      map[ProviderType]ProviderError
+	recentErrors    []ProviderError   // Oldest first, at most maxRecentErrors
+	rules           RoutingRules      // Operator overrides in effect, see routing.go
+	adminRules      RoutingRules      // Rules set through the admin API, ahead of configuredRules
+	configuredRules RoutingRules      // Rules from the config file
+	aliases         map[string]string // Model names clients may use in place of others
+	rulesPath       string            // File the admin rules are saved to; empty keeps them in memory
+	statePath       string            // File the routing state is snapshotted to, see state.go
+	dirty           bool              // Routing state changed since the last snapshot
+	logger          *logging.Logger
+	mu              sync.RWMutex
+	saveMu          sync.Mutex // Serialises writes of the state file
+	rng             *rand.Rand
 }
 
 // NewFactory creates a new provider factory
@@ -36,6 +53,11 @@ func NewFactory() *Factory {
 		providers:      make(map[ProviderType]Provider),
 		lastSuccess:    make(map[string]ProviderType),
 		modelProviders: make(ModelProviderMap),
+		contextLimits:  make(map[ProviderType]map[string]int),
+		circuits:       make(map[ProviderType]*circuit),
+		breaker:        defaultCircuitBreaker,
+		lastErrors:     make(map[ProviderType]ProviderError),
+		logger:         logging.NewLogger("provider"),
 		rng:            rand.New(rand.NewSource(time.Now().UnixNano())),
 	}
 }
@@ -70,12 +92,32 @@ func (f *Factory) GetByModel(model string) (Provider, error) {
 		return nil, fmt.Errorf("no provider found for model: %s", model)
 	}
 
+	// Apply the operator's routing rules, then skip providers whose circuit is open while
+	// others can serve the model
+	candidates = f.routable(model, candidates)
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrProvidersDisabled, model)
+	}
+	candidates = f.available(candidates)
+
 	// If only one provider supports this model, use it
 	if len(candidates) == 1 {
 		return candidates[0], nil
 	}
 
-	// Multiple providers support this model. Try to use the last successful provider.
+	// Multiple providers support this model. A pinned provider takes precedence, then the
+	// model's provider order, then the last successful provider.
+	rule := f.rules.Models[model]
+	if rule.Pin != "" {
+		for _, p := range candidates {
+			if p.Name() == rule.Pin {
+				return p, nil
+			}
+		}
+	}
+	if len(rule.Order) > 0 {
+		return candidates[0], nil
+	}
 	if lastType, ok := f.lastSuccess[model]; ok {
 		for _, p := range candidates {
 			if p.Name() == lastType {
@@ -99,22 +141,34 @@ func (f *Factory) GetAlternativeProvider(model string, excludeProvider ProviderT
 		return nil, fmt.Errorf("no provider found for model: %s", model)
 	}
 
-	// Find the first provider that is not the excluded one
+	// Find the first provider that is not the excluded one, following the routing rules and
+	// preferring those whose circuit is not open
+	others := make([]Provider, 0, len(candidates))
 	for _, p := range candidates {
 		if p.Name() != excludeProvider {
-			return p, nil
+			others = append(others, p)
 		}
 	}
+	if others = f.routable(model, others); len(others) > 0 {
+		return f.available(others)[0], nil
+	}
 
 	return nil, fmt.Errorf("no alternative provider found for model: %s (excluding %s)", model, excludeProvider)
 }
 
-// RecordSuccess records that a provider successfully served a model
+// RecordSuccess records that a provider successfully served a model, closing its circuit
 func (f *Factory) RecordSuccess(model string, providerType ProviderType) {
 	f.mu.Lock()
 	defer f.mu.Unlock()
 
-	f.lastSuccess[model] = providerType
+	if f.lastSuccess[model] != providerType {
+		f.lastSuccess[model] = providerType
+		f.dirty = true
+	}
+	if _, ok := f.circuits[providerType]; ok {
+		delete(f.circuits, providerType)
+		f.dirty = true
+	}
 }
 
 // List returns all registered providers
@@ -141,20 +195,23 @@ func (f *Factory) ListTypes() []ProviderType {
 	return types
 }
 
-// PopulateModelProviders fetches all available models from all providers and builds the model-to-provider mapping
+// PopulateModelProviders fetches all available models from all providers and builds the model-to-provider mapping.
+// Models are fetched without holding the lock, so requests keep being routed with the current
+// catalog, which may have been restored from the state file, while discovery runs. Providers
+// that fail to list their models keep the models they had.
 func (f *Factory) PopulateModelProviders(ctx context.Context) error {
-	f.mu.Lock()
-	defer f.mu.Unlock()
-
-	// Clear existing mappings
-	f.modelProviders = make(ModelProviderMap)
+	providers := f.List()
+	fetched := make(map[ProviderType][]string, len(providers))
+	limits := make(map[ProviderType]map[string]int, len(providers))
 
 	// Fetch models from all providers
-	for providerType, provider := range f.providers {
+	for _, provider := range providers {
+		providerType := provider.Name()
+
 		// Check if provider needs initialization (like Antigravity)
 		if initProvider, ok := provider.(interface{ Initialize(context.Context) error }); ok {
 			if err := initProvider.Initialize(ctx); err != nil {
-				fmt.Printf("Warning: Failed to initialize provider %s: %v\n", providerType, err)
+				f.logger.WarningLog("[Factory] Failed to initialize provider %s: %v", providerType, err)
 				continue
 			}
 		}
@@ -162,100 +219,92 @@ func (f *Factory) PopulateModelProviders(ctx context.Context) error {
 		modelsData, err := provider.ListModels(ctx)
 		if err != nil {
 			// Log the error but continue with other providers
-			fmt.Printf("Warning: Failed to fetch models from provider %s: %v\n", providerType, err)
+			f.logger.WarningLog("[Factory] Failed to fetch models from provider %s: %v", providerType, err)
 			continue
 		}
 
 		// Extract models from the response generically
-		modelNames := f.extractModelNames(modelsData)
-
-		// Add this provider to each model it supports
-		for _, modelName := range modelNames {
-			if _, exists := f.modelProviders[modelName]; !exists {
-				f.modelProviders[modelName] = []Provider{}
-			}
+		fetched[providerType] = f.extractModelNames(modelsData)
+		limits[providerType] = f.extractContextLimits(modelsData)
+	}
 
-			// Check if this provider is already in the list for this model
-			alreadyAdded := false
-			for _, existingProvider := range f.modelProviders[modelName] {
-				if existingProvider.Name() == provider.Name() {
-					alreadyAdded = true
-					break
-				}
-			}
+	f.mu.Lock()
+	defer f.mu.Unlock()
 
-			if !alreadyAdded {
-				f.modelProviders[modelName] = append(f.modelProviders[modelName], provider)
+	catalog := make(ModelProviderMap)
+	for model, list := range f.modelProviders {
+		for _, p := range list {
+			if _, ok := fetched[p.Name()]; !ok {
+				catalog.add(model, p)
 			}
 		}
 	}
+	for _, provider := range providers {
+		modelNames, ok := fetched[provider.Name()]
+		if !ok {
+			continue
+		}
+		f.contextLimits[provider.Name()] = limits[provider.Name()]
+		for _, modelName := range modelNames {
+			catalog.add(modelName, provider)
+		}
+	}
+	f.modelProviders = catalog
+	f.dirty = true
 
 	return nil
 }
 
 // RefreshProviderModels fetches models from a specific provider and updates the model-to-provider mapping
 func (f *Factory) RefreshProviderModels(ctx context.Context, providerType ProviderType) error {
-	f.mu.Lock()
-	defer f.mu.Unlock()
-
-	provider, exists := f.providers[providerType]
-	if !exists {
-		return fmt.Errorf("provider not found: %s", providerType)
+	provider, err := f.Get(providerType)
+	if err != nil {
+		return err
 	}
 
 	modelsData, err := provider.ListModels(ctx)
 	if err != nil {
 		return fmt.Errorf("failed to fetch models from provider %s: %w", providerType, err)
 	}
+	modelNames := f.extractModelNames(modelsData)
+	limits := f.extractContextLimits(modelsData)
 
-	// Get the existing models for this provider to remove old associations
-	existingModels := make(map[string]bool)
-	for modelName, providers := range f.modelProviders {
-		for _, p := range providers {
-			if p.Name() == providerType {
-				existingModels[modelName] = true
-				break
-			}
-		}
-	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
 
 	// Remove this provider from all its previously associated models
-	for modelName := range existingModels {
-		newProviders := []Provider{}
-		for _, p := range f.modelProviders[modelName] {
+	for modelName, providers := range f.modelProviders {
+		remaining := make([]Provider, 0, len(providers))
+		for _, p := range providers {
 			if p.Name() != providerType {
-				newProviders = append(newProviders, p)
+				remaining = append(remaining, p)
 			}
 		}
-		if len(newProviders) == 0 {
+		if len(remaining) == 0 {
 			delete(f.modelProviders, modelName)
 		} else {
-			f.modelProviders[modelName] = newProviders
+			f.modelProviders[modelName] = remaining
 		}
 	}
 
-	// Extract models from the response and add this provider to each model
-	modelNames := f.extractModelNames(modelsData)
+	// Add this provider to each model it now lists
+	f.contextLimits[providerType] = limits
 	for _, modelName := range modelNames {
-		if _, exists := f.modelProviders[modelName]; !exists {
-			f.modelProviders[modelName] = []Provider{}
-		}
+		f.modelProviders.add(modelName, provider)
+	}
+	f.dirty = true
 
-		// Check if this provider is already in the list for this model
-		alreadyAdded := false
-		for _, existingProvider := range f.modelProviders[modelName] {
-			if existingProvider.Name() == provider.Name() {
-				alreadyAdded = true
-				break
-			}
-		}
+	return nil
+}
 
-		if !alreadyAdded {
-			f.modelProviders[modelName] = append(f.modelProviders[modelName], provider)
+// add appends a provider to the providers of a model unless it is already listed
+func (m ModelProviderMap) add(model string, p Provider) {
+	for _, existing := range m[model] {
+		if existing.Name() == p.Name() {
+			return
 		}
 	}
-
-	return nil
+	m[model] = append(m[model], p)
 }
 
 // GetAllModels returns all available models and their providers
@@ -274,6 +323,45 @@ func (f *Factory) GetAllModels() map[string][]ProviderType {
 	return result
 }
 
+// PublishMetrics updates the catalog size and circuit state gauges. It runs before every
+// scrape of the metrics endpoint.
+func (f *Factory) PublishMetrics() {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	counts := make(map[ProviderType]int, len(f.providers))
+	for _, providers := range f.modelProviders {
+		for _, p := range providers {
+			counts[p.Name()]++
+		}
+	}
+	metrics.CatalogModels.Set(float64(len(f.modelProviders)))
+	metrics.ProviderModels.Reset()
+	metrics.CircuitState.Reset()
+	now := time.Now()
+	for providerType := range f.providers {
+		metrics.ProviderModels.Set(float64(counts[providerType]), string(providerType))
+		metrics.CircuitState.Set(float64(f.circuitState(providerType, now)), string(providerType))
+	}
+}
+
+// ModelCount returns the number of catalog models a provider serves
+func (f *Factory) ModelCount(providerType ProviderType) int {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	count := 0
+	for _, providers := range f.modelProviders {
+		for _, p := range providers {
+			if p.Name() == providerType {
+				count++
+				break
+			}
+		}
+	}
+	return count
+}
+
 // GetModelProviders returns the list of providers that support a specific model
 func (f *Factory) GetModelProviders(model string) []Provider {
 	f.mu.RLock()
@@ -299,6 +387,49 @@ func (f *Factory) GetLastSuccessProvider(model string) (ProviderType, bool) {
 	return providerType, exists
 }
 
+// ContextLimit returns the input token limit a provider reported for a model, or 0 if unknown
+func (f *Factory) ContextLimit(providerType ProviderType, model string) int {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	return f.contextLimits[providerType][model]
+}
+
+// extractContextLimits extracts per-model input token limits from a model listing
+func (f *Factory) extractContextLimits(data interface{}) map[string]int {
+	limits := make(map[string]int)
+
+	dataMap, ok := f.convertToMap(data)
+	if !ok {
+		return limits
+	}
+
+	// Gemini/Antigravity listings carry inputTokenLimit, OpenAI-style listings may carry context_length
+	for _, field := range []string{"models", "data"} {
+		items, ok := dataMap[field].([]interface{})
+		if !ok {
+			continue
+		}
+		for _, item := range items {
+			itemMap, ok := item.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			name, _ := itemMap["id"].(string)
+			if name == "" {
+				name, _ = itemMap["name"].(string)
+			}
+			for _, key := range []string{"inputTokenLimit", "context_length", "context_window"} {
+				if limit, ok := itemMap[key].(float64); ok && limit > 0 && name != "" {
+					limits[name] = int(limit)
+					break
+				}
+			}
+		}
+	}
+	return limits
+}
+
 // extractModelNames extracts model names from various response formats
 func (f *Factory) extractModelNames(data interface{}) []string {
 	var modelNames []string
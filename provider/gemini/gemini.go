@@ -9,11 +9,12 @@ import (
 	"io"
 	"net/http"
 	"strings"
-	"time"
 
 	"github.com/sunbankio/qwencoder-proxy/auth"
 	"github.com/sunbankio/qwencoder-proxy/logging"
+	"github.com/sunbankio/qwencoder-proxy/metrics"
 	"github.com/sunbankio/qwencoder-proxy/provider"
+	"github.com/sunbankio/qwencoder-proxy/transport"
 )
 
 const (
@@ -34,6 +35,7 @@ var SupportedModels = []string{
 
 // Provider implements the provider.Provider interface for Gemini CLI
 type Provider struct {
+	name             provider.ProviderType
 	baseURL          string
 	authenticator    *auth.GeminiAuthenticator
 	httpClient       *http.Client
@@ -48,18 +50,34 @@ func NewProvider(authenticator *auth.GeminiAuthenticator) *Provider {
 		authenticator = auth.NewGeminiAuthenticator(nil)
 	}
 	return &Provider{
+		name:          provider.ProviderGeminiCLI,
 		baseURL:       DefaultBaseURL,
 		authenticator: authenticator,
-		httpClient:    &http.Client{Timeout: 5 * time.Minute},
-		logger:        logging.NewLogger(),
+		httpClient:    transport.NewClient(string(provider.ProviderGeminiCLI)),
+		logger:        logging.NewLogger("gemini"),
 	}
 }
 
 // Name returns the provider identifier
 func (p *Provider) Name() provider.ProviderType {
+	return p.name
+}
+
+// Type returns the provider type, which differs from the name of additional accounts
+func (p *Provider) Type() provider.ProviderType {
 	return provider.ProviderGeminiCLI
 }
 
+// SetName sets the name the provider is registered and routed under, such as the name of an additional account
+func (p *Provider) SetName(name provider.ProviderType) {
+	p.name = name
+}
+
+// SetBaseURL sets the Code Assist API base URL
+func (p *Provider) SetBaseURL(url string) {
+	p.baseURL = url
+}
+
 // Protocol returns the native protocol
 func (p *Provider) Protocol() provider.ProtocolType {
 	return provider.ProtocolGemini
@@ -103,10 +121,11 @@ func (p *Provider) ClearInitializationError() {
 
 // ListModels returns available models in native Gemini format
 func (p *Provider) ListModels(ctx context.Context) (interface{}, error) {
+	logger := p.logger.WithContext(ctx)
 	// Try to initialize project to get actual models
 	if p.projectID == "" {
 		if err := p.initializeProject(ctx); err != nil {
-			p.logger.DebugLog("[Gemini] Failed to initialize project for model discovery: %v", err)
+			logger.DebugLog("[Gemini] Failed to initialize project for model discovery: %v", err)
 			// Fall back to hardcoded models if initialization fails
 			return p.getHardcodedModels(), nil
 		}
@@ -116,7 +135,7 @@ func (p *Provider) ListModels(ctx context.Context) (interface{}, error) {
 	if actualModels, err := p.discoverModels(ctx); err == nil {
 		return actualModels, nil
 	} else {
-		p.logger.DebugLog("[Gemini] Failed to discover models from API: %v", err)
+		logger.DebugLog("[Gemini] Failed to discover models from API: %v", err)
 		// Fall back to hardcoded models if discovery fails
 		return p.getHardcodedModels(), nil
 	}
@@ -132,8 +151,8 @@ func (p *Provider) getHardcodedModels() interface{} {
 			Name:                       modelID,
 			DisplayName:                modelID,
 			Description:                fmt.Sprintf("A generative model for text and chat generation. ID: %s", modelID),
-			InputTokenLimit:            32768,
-			OutputTokenLimit:           8192,
+			InputTokenLimit:            1048576,
+			OutputTokenLimit:           65536,
 			SupportedGenerationMethods: []string{"generateContent", "streamGenerateContent"},
 		})
 	}
@@ -150,24 +169,25 @@ func (p *Provider) discoverModels(ctx context.Context) (interface{}, error) {
 
 // initializeProject discovers or creates a project for the Cloud Code Assist API
 func (p *Provider) initializeProject(ctx context.Context) error {
-	p.logger.DebugLog("[Gemini] Starting project initialization...")
+	logger := p.logger.WithContext(ctx)
+	logger.DebugLog("[Gemini] Starting project initialization...")
 
 	// Check if we have cached credentials
 	if p.authenticator.IsAuthenticated() {
-		p.logger.DebugLog("[Gemini] Found valid cached credentials")
+		logger.DebugLog("[Gemini] Found valid cached credentials")
 	} else {
-		p.logger.DebugLog("[Gemini] No valid cached credentials found")
+		logger.DebugLog("[Gemini] No valid cached credentials found")
 	}
 
 	token, err := p.authenticator.GetToken(ctx)
 	if err != nil {
 		// Store the error to prevent repeated initialization attempts when auth fails
 		p.projectInitError = err
-		p.logger.ErrorLog("[Gemini] Failed to get token for project initialization: %v", err)
+		logger.ErrorLog("[Gemini] Failed to get token for project initialization: %v", err)
 		return fmt.Errorf("failed to get token for project initialization: %w", err)
 	}
 
-	p.logger.DebugLog("[Gemini] Successfully obtained access token (length: %d)", len(token))
+	logger.DebugLog("[Gemini] Successfully obtained access token (length: %d)", len(token))
 
 	// Clear any previous initialization errors since we got the token successfully
 	p.projectInitError = nil
@@ -224,7 +244,7 @@ func (p *Provider) initializeProject(ctx context.Context) error {
 	// Check if we already have a project ID from the response
 	if projectID, ok := loadResponse["cloudaicompanionProject"].(string); ok && projectID != "" {
 		p.projectID = projectID
-		p.logger.DebugLog("[Gemini] Using existing project ID: %s", p.projectID)
+		logger.DebugLog("[Gemini] Using existing project ID: %s", p.projectID)
 		return nil
 	}
 
@@ -297,7 +317,7 @@ func (p *Provider) initializeProject(ctx context.Context) error {
 		if project, exists := response["cloudaicompanionProject"].(map[string]interface{}); exists {
 			if id, idExists := project["id"].(string); idExists {
 				p.projectID = id
-				p.logger.DebugLog("[Gemini] Created new project ID: %s", p.projectID)
+				logger.DebugLog("[Gemini] Created new project ID: %s", p.projectID)
 				return nil
 			}
 		}
@@ -306,7 +326,7 @@ func (p *Provider) initializeProject(ctx context.Context) error {
 	// Fallback: try to get project ID directly from response
 	if id, exists := onboardResponse["cloudaicompanionProject"].(string); exists {
 		p.projectID = id
-		p.logger.DebugLog("[Gemini] Discovered project ID: %s", p.projectID)
+		logger.DebugLog("[Gemini] Discovered project ID: %s", p.projectID)
 		return nil
 	}
 
@@ -315,6 +335,7 @@ func (p *Provider) initializeProject(ctx context.Context) error {
 
 // GenerateContent handles non-streaming requests with native format
 func (p *Provider) GenerateContent(ctx context.Context, model string, request interface{}) (interface{}, error) {
+	logger := p.logger.WithContext(ctx)
 	// Check if there was a previous initialization error to avoid repeated auth failures
 	if p.projectInitError != nil {
 		return nil, fmt.Errorf("project initialization failed due to authentication error, please re-authenticate: %w", p.projectInitError)
@@ -330,7 +351,7 @@ func (p *Provider) GenerateContent(ctx context.Context, model string, request in
 	// First attempt to get token
 	token, err := p.authenticator.GetToken(ctx)
 	if err != nil {
-		p.logger.ErrorLog("[Gemini] Token retrieval failed on first attempt: %v", err)
+		logger.ErrorLog("[Gemini] Token retrieval failed on first attempt: %v", err)
 		// The authenticator should handle refresh internally, but if it failed,
 		// we return the error which will propagate up to the user
 		return nil, fmt.Errorf("failed to get token: %w", err)
@@ -377,7 +398,8 @@ func (p *Provider) GenerateContent(ctx context.Context, model string, request in
 	}
 
 	url := fmt.Sprintf("%s:generateContent", p.baseURL)
-	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(reqBody))
+	reqCtx := transport.WithModel(ctx, model, false)
+	req, err := http.NewRequestWithContext(reqCtx, "POST", url, bytes.NewReader(reqBody))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
@@ -385,7 +407,7 @@ func (p *Provider) GenerateContent(ctx context.Context, model string, request in
 	req.Header.Set("Authorization", "Bearer "+token)
 	req.Header.Set("Content-Type", "application/json")
 
-	p.logger.DebugLog("[Gemini] Sending generateContent request to %s", url)
+	logger.DebugLog("[Gemini] Sending generateContent request to %s", url)
 
 	resp, err := p.httpClient.Do(req)
 	if err != nil {
@@ -395,7 +417,7 @@ func (p *Provider) GenerateContent(ctx context.Context, model string, request in
 
 	if resp.StatusCode == http.StatusUnauthorized {
 		// Try to refresh the token and retry the request once, following the JavaScript reference implementation
-		p.logger.DebugLog("[Gemini] Received %d, attempting to refresh token and retry", resp.StatusCode)
+		logger.DebugLog("[Gemini] Received %d, attempting to refresh token and retry", resp.StatusCode)
 
 		// Read and close the original response body
 		bodyBytes, _ := io.ReadAll(resp.Body)
@@ -405,9 +427,10 @@ func (p *Provider) GenerateContent(ctx context.Context, model string, request in
 		// We need to implement a method to force refresh the token
 		_, refreshErr := p.authenticator.GetToken(ctx)
 		if refreshErr != nil {
-			p.logger.ErrorLog("[Gemini] Token refresh failed: %v", refreshErr)
-			return nil, fmt.Errorf("API error (status %d) and token refresh failed: %s", resp.StatusCode, string(bodyBytes))
+			logger.ErrorLog("[Gemini] Token refresh failed: %v", refreshErr)
+			return nil, fmt.Errorf("token refresh failed: %w", provider.NewAPIError(p.Name(), resp, bodyBytes))
 		}
+		metrics.Retries.Inc(string(p.Name()), metrics.RetryAuth)
 
 		// Retry the request with the refreshed token
 		// Recreate the request body with the same finalRequest
@@ -416,7 +439,7 @@ func (p *Provider) GenerateContent(ctx context.Context, model string, request in
 			return nil, fmt.Errorf("failed to marshal request for retry: %w", marshalErr)
 		}
 
-		retryReq, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(retryReqBody))
+		retryReq, err := http.NewRequestWithContext(reqCtx, "POST", url, bytes.NewReader(retryReqBody))
 		if err != nil {
 			return nil, fmt.Errorf("failed to create retry request: %w", err)
 		}
@@ -438,13 +461,13 @@ func (p *Provider) GenerateContent(ctx context.Context, model string, request in
 
 		if retryResp.StatusCode != http.StatusOK {
 			retryBody, _ := io.ReadAll(retryResp.Body)
-			return nil, fmt.Errorf("retry failed with API error (status %d): %s", retryResp.StatusCode, string(retryBody))
+			return nil, fmt.Errorf("retry failed: %w", provider.NewAPIError(p.Name(), retryResp, retryBody))
 		}
 
 		resp = retryResp // Use the successful retry response
 	} else if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("API error (status %d): %s", resp.StatusCode, string(body))
+		return nil, provider.NewAPIError(p.Name(), resp, body)
 	}
 
 	// The Cloud Code Assist API returns responses wrapped in a "response" field
@@ -476,6 +499,7 @@ func (p *Provider) GenerateContent(ctx context.Context, model string, request in
 
 // GenerateContentStream handles streaming requests with native format
 func (p *Provider) GenerateContentStream(ctx context.Context, model string, request interface{}) (io.ReadCloser, error) {
+	logger := p.logger.WithContext(ctx)
 	// Ensure project is initialized
 	if p.projectID == "" {
 		if err := p.initializeProject(ctx); err != nil {
@@ -531,7 +555,8 @@ func (p *Provider) GenerateContentStream(ctx context.Context, model string, requ
 
 	// Add the crucial alt=sse query parameter for streaming
 	url := fmt.Sprintf("%s:streamGenerateContent?alt=sse", p.baseURL)
-	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(reqBody))
+	reqCtx := transport.WithModel(ctx, model, true)
+	req, err := http.NewRequestWithContext(reqCtx, "POST", url, bytes.NewReader(reqBody))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
@@ -540,7 +565,7 @@ func (p *Provider) GenerateContentStream(ctx context.Context, model string, requ
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Accept", "text/event-stream")
 
-	p.logger.DebugLog("[Gemini] Sending streamGenerateContent request to %s", url)
+	logger.DebugLog("[Gemini] Sending streamGenerateContent request to %s", url)
 
 	resp, err := p.httpClient.Do(req)
 	if err != nil {
@@ -549,7 +574,7 @@ func (p *Provider) GenerateContentStream(ctx context.Context, model string, requ
 
 	if resp.StatusCode == http.StatusUnauthorized {
 		// Try to refresh the token and retry the request once, following the JavaScript reference implementation
-		p.logger.DebugLog("[Gemini] Received %d, attempting to refresh token and retry", resp.StatusCode)
+		logger.DebugLog("[Gemini] Received %d, attempting to refresh token and retry", resp.StatusCode)
 
 		// Read and close the original response body
 		bodyBytes, _ := io.ReadAll(resp.Body)
@@ -558,9 +583,10 @@ func (p *Provider) GenerateContentStream(ctx context.Context, model string, requ
 		// Refresh the token by calling GetToken which handles refresh internally
 		_, refreshErr := p.authenticator.GetToken(ctx)
 		if refreshErr != nil {
-			p.logger.ErrorLog("[Gemini] Token refresh failed: %v", refreshErr)
-			return nil, fmt.Errorf("API error (status %d) and token refresh failed: %s", resp.StatusCode, string(bodyBytes))
+			logger.ErrorLog("[Gemini] Token refresh failed: %v", refreshErr)
+			return nil, fmt.Errorf("token refresh failed: %w", provider.NewAPIError(p.Name(), resp, bodyBytes))
 		}
+		metrics.Retries.Inc(string(p.Name()), metrics.RetryAuth)
 
 		// Retry the request with the refreshed token
 		// Recreate the request body with the same finalRequest
@@ -569,7 +595,7 @@ func (p *Provider) GenerateContentStream(ctx context.Context, model string, requ
 			return nil, fmt.Errorf("failed to marshal request for retry: %w", marshalErr)
 		}
 
-		retryReq, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(retryReqBody))
+		retryReq, err := http.NewRequestWithContext(reqCtx, "POST", url, bytes.NewReader(retryReqBody))
 		if err != nil {
 			return nil, fmt.Errorf("failed to create retry request: %w", err)
 		}
@@ -592,14 +618,14 @@ func (p *Provider) GenerateContentStream(ctx context.Context, model string, requ
 		if retryResp.StatusCode != http.StatusOK {
 			retryBody, _ := io.ReadAll(retryResp.Body)
 			retryResp.Body.Close()
-			return nil, fmt.Errorf("retry failed with API error (status %d): %s", retryResp.StatusCode, string(retryBody))
+			return nil, fmt.Errorf("retry failed: %w", provider.NewAPIError(p.Name(), retryResp, retryBody))
 		}
 
 		return retryResp.Body, nil
 	} else if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
 		resp.Body.Close()
-		return nil, fmt.Errorf("API error (status %d): %s", resp.StatusCode, string(body))
+		return nil, provider.NewAPIError(p.Name(), resp, body)
 	}
 
 	return resp.Body, nil
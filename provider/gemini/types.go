@@ -3,12 +3,12 @@ package gemini
 
 // GeminiRequest represents a Gemini API generateContent request
 type GeminiRequest struct {
-	Contents          []Content          `json:"contents"`
-	SystemInstruction *Content           `json:"systemInstruction,omitempty"`
-	GenerationConfig  *GenerationConfig  `json:"generationConfig,omitempty"`
-	SafetySettings    []SafetySetting    `json:"safetySettings,omitempty"`
-	Tools             []Tool             `json:"tools,omitempty"`
-	ToolConfig        *ToolConfig        `json:"toolConfig,omitempty"`
+	Contents          []Content         `json:"contents"`
+	SystemInstruction *Content          `json:"systemInstruction,omitempty"`
+	GenerationConfig  *GenerationConfig `json:"generationConfig,omitempty"`
+	SafetySettings    []SafetySetting   `json:"safetySettings,omitempty"`
+	Tools             []Tool            `json:"tools,omitempty"`
+	ToolConfig        *ToolConfig       `json:"toolConfig,omitempty"`
 }
 
 // Content represents a content block in Gemini format
@@ -19,9 +19,27 @@ type Content struct {
 
 // Part represents a part of content (text, image, etc.)
 type Part struct {
-	Text       string      `json:"text,omitempty"`
-	InlineData *InlineData `json:"inlineData,omitempty"`
-	FileData   *FileData   `json:"fileData,omitempty"`
+	Text             string            `json:"text,omitempty"`
+	Thought          bool              `json:"thought,omitempty"`
+	ThoughtSignature string            `json:"thoughtSignature,omitempty"`
+	InlineData       *InlineData       `json:"inlineData,omitempty"`
+	FileData         *FileData         `json:"fileData,omitempty"`
+	FunctionCall     *FunctionCall     `json:"functionCall,omitempty"`
+	FunctionResponse *FunctionResponse `json:"functionResponse,omitempty"`
+}
+
+// FunctionCall represents a function call predicted by the model
+type FunctionCall struct {
+	ID   string                 `json:"id,omitempty"`
+	Name string                 `json:"name"`
+	Args map[string]interface{} `json:"args,omitempty"`
+}
+
+// FunctionResponse represents the result of a function call sent back to the model
+type FunctionResponse struct {
+	ID       string                 `json:"id,omitempty"`
+	Name     string                 `json:"name"`
+	Response map[string]interface{} `json:"response"`
 }
 
 // InlineData represents inline binary data (e.g., images)
@@ -38,13 +56,21 @@ type FileData struct {
 
 // GenerationConfig contains generation parameters
 type GenerationConfig struct {
-	Temperature      *float64 `json:"temperature,omitempty"`
-	TopP             *float64 `json:"topP,omitempty"`
-	TopK             *int     `json:"topK,omitempty"`
-	MaxOutputTokens  *int     `json:"maxOutputTokens,omitempty"`
-	StopSequences    []string `json:"stopSequences,omitempty"`
-	CandidateCount   *int     `json:"candidateCount,omitempty"`
-	ResponseMimeType string   `json:"responseMimeType,omitempty"`
+	Temperature      *float64        `json:"temperature,omitempty"`
+	TopP             *float64        `json:"topP,omitempty"`
+	TopK             *int            `json:"topK,omitempty"`
+	MaxOutputTokens  *int            `json:"maxOutputTokens,omitempty"`
+	StopSequences    []string        `json:"stopSequences,omitempty"`
+	CandidateCount   *int            `json:"candidateCount,omitempty"`
+	ResponseMimeType string          `json:"responseMimeType,omitempty"`
+	ThinkingConfig   *ThinkingConfig `json:"thinkingConfig,omitempty"`
+}
+
+// ThinkingConfig controls the model's internal reasoning
+type ThinkingConfig struct {
+	IncludeThoughts bool   `json:"includeThoughts,omitempty"`
+	ThinkingBudget  *int   `json:"thinkingBudget,omitempty"`
+	ThinkingLevel   string `json:"thinkingLevel,omitempty"`
 }
 
 // SafetySetting represents a safety setting
@@ -85,10 +111,25 @@ type GeminiResponse struct {
 
 // Candidate represents a response candidate
 type Candidate struct {
-	Content       *Content       `json:"content,omitempty"`
-	FinishReason  string         `json:"finishReason,omitempty"`
-	Index         int            `json:"index"`
-	SafetyRatings []SafetyRating `json:"safetyRatings,omitempty"`
+	Content          *Content          `json:"content,omitempty"`
+	FinishReason     string            `json:"finishReason,omitempty"`
+	Index            int               `json:"index"`
+	SafetyRatings    []SafetyRating    `json:"safetyRatings,omitempty"`
+	CitationMetadata *CitationMetadata `json:"citationMetadata,omitempty"`
+}
+
+// CitationMetadata holds the sources cited by a candidate
+type CitationMetadata struct {
+	CitationSources []CitationSource `json:"citationSources,omitempty"`
+}
+
+// CitationSource attributes a span of the candidate text to a source
+type CitationSource struct {
+	StartIndex int    `json:"startIndex,omitempty"`
+	EndIndex   int    `json:"endIndex,omitempty"`
+	URI        string `json:"uri,omitempty"`
+	Title      string `json:"title,omitempty"`
+	License    string `json:"license,omitempty"`
 }
 
 // SafetyRating represents a safety rating
@@ -105,9 +146,11 @@ type PromptFeedback struct {
 
 // UsageMetadata represents token usage information
 type UsageMetadata struct {
-	PromptTokenCount     int `json:"promptTokenCount"`
-	CandidatesTokenCount int `json:"candidatesTokenCount"`
-	TotalTokenCount      int `json:"totalTokenCount"`
+	PromptTokenCount        int `json:"promptTokenCount"`
+	CandidatesTokenCount    int `json:"candidatesTokenCount"`
+	TotalTokenCount         int `json:"totalTokenCount"`
+	CachedContentTokenCount int `json:"cachedContentTokenCount,omitempty"`
+	ThoughtsTokenCount      int `json:"thoughtsTokenCount,omitempty"`
 }
 
 // GeminiModel represents a model in the Gemini API
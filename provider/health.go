@@ -0,0 +1,175 @@
+package provider
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sunbankio/qwencoder-proxy/logging"
+)
+
+// ProviderStatus is the health of one provider as reported by the health endpoints
+type ProviderStatus struct {
+	Name          ProviderType   `json:"name"`
+	Account       string         `json:"account,omitempty"`
+	Authenticated bool           `json:"authenticated"`
+	TokenExpiry   *time.Time     `json:"token_expiry,omitempty"`
+	Healthy       bool           `json:"healthy"`
+	CheckedAt     *time.Time     `json:"checked_at,omitempty"` // Nil until the first check completes
+	Circuit       string         `json:"circuit"`
+	Models        int            `json:"models"`
+	LastError     *ProviderError `json:"last_error,omitempty"` // From requests or health checks, whichever is later
+}
+
+// Ready reports whether the provider can serve requests
+func (s ProviderStatus) Ready() bool {
+	return s.Authenticated && s.Healthy && s.Circuit != CircuitOpen.String()
+}
+
+// probe is the cached result of one provider's health check
+type probe struct {
+	authenticated bool
+	expiry        time.Time
+	healthy       bool
+	checkedAt     time.Time
+	err           *ProviderError
+}
+
+// HealthMonitor checks the providers of a factory in the background and caches the
+// results, so the health endpoints never call upstreams on the request path
+type HealthMonitor struct {
+	factory  *Factory
+	interval time.Duration
+	timeout  time.Duration
+	logger   *logging.Logger
+
+	mu     sync.RWMutex
+	probes map[ProviderType]probe
+}
+
+// NewHealthMonitor creates a monitor checking every provider in factory each interval
+func NewHealthMonitor(factory *Factory, interval, timeout time.Duration) *HealthMonitor {
+	return &HealthMonitor{
+		factory:  factory,
+		interval: interval,
+		timeout:  timeout,
+		logger:   logging.NewLogger("provider"),
+		probes:   make(map[ProviderType]probe),
+	}
+}
+
+// Start checks the providers now and then every interval until ctx is done
+func (m *HealthMonitor) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(m.interval)
+		defer ticker.Stop()
+		for {
+			m.Check(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Check runs the health check of every provider concurrently and caches the results
+func (m *HealthMonitor) Check(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, p := range m.factory.List() {
+		wg.Add(1)
+		go func(p Provider) {
+			defer wg.Done()
+			result := m.check(ctx, p)
+			m.mu.Lock()
+			m.probes[p.Name()] = result
+			m.mu.Unlock()
+		}(p)
+	}
+	wg.Wait()
+}
+
+// check runs one provider's health check. Only credential checks and catalog calls are
+// made, so checks never use generation quota.
+func (m *HealthMonitor) check(ctx context.Context, p Provider) probe {
+	ctx, cancel := context.WithTimeout(ctx, m.timeout)
+	defer cancel()
+
+	result := probe{authenticated: true}
+	if authenticator := p.GetAuthenticator(); authenticator != nil {
+		result.authenticated = authenticator.IsAuthenticated()
+		if reporter, ok := authenticator.(ExpiryReporter); ok {
+			result.expiry = reporter.TokenExpiry()
+		}
+	}
+	result.healthy = p.IsHealthy(ctx)
+	result.checkedAt = time.Now()
+
+	switch {
+	case !result.authenticated:
+		result.err = &ProviderError{Provider: p.Name(), Message: "not authenticated", Time: result.checkedAt}
+	case !result.healthy && ctx.Err() != nil:
+		result.err = &ProviderError{Provider: p.Name(), Message: "health check timed out", Time: result.checkedAt}
+	case !result.healthy:
+		result.err = &ProviderError{Provider: p.Name(), Message: "health check failed", Time: result.checkedAt}
+	}
+	if result.err != nil {
+		m.logger.WarningLog("[Health] Provider %s: %s", p.Name(), result.err.Message)
+	}
+	return result
+}
+
+// Statuses returns the status of every provider, sorted by name. Probe results are
+// cached; circuit state, model count and request errors are current.
+func (m *HealthMonitor) Statuses() []ProviderStatus {
+	m.mu.RLock()
+	probes := make(map[ProviderType]probe, len(m.probes))
+	for name, result := range m.probes {
+		probes[name] = result
+	}
+	m.mu.RUnlock()
+
+	types := m.factory.ListTypes()
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+	statuses := make([]ProviderStatus, 0, len(types))
+	for _, providerType := range types {
+		result := probes[providerType]
+		status := ProviderStatus{
+			Name:          providerType,
+			Authenticated: result.authenticated,
+			Healthy:       result.healthy,
+			Circuit:       m.factory.CircuitState(providerType).String(),
+			Models:        m.factory.ModelCount(providerType),
+			LastError:     result.err,
+		}
+		if p, err := m.factory.Get(providerType); err == nil {
+			if reporter, ok := p.(AccountReporter); ok {
+				status.Account = reporter.Account()
+			}
+		}
+		if !result.expiry.IsZero() {
+			status.TokenExpiry = &result.expiry
+		}
+		if !result.checkedAt.IsZero() {
+			status.CheckedAt = &result.checkedAt
+		}
+		if lastErr, ok := m.factory.LastError(providerType); ok && (status.LastError == nil || lastErr.Time.After(status.LastError.Time)) {
+			status.LastError = &lastErr
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// Ready reports whether at least one provider is authenticated and healthy with its
+// circuit not open
+func (m *HealthMonitor) Ready() bool {
+	for _, status := range m.Statuses() {
+		if status.Ready() {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,57 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// healthStub is a stubProvider with a fixed health check result
+type healthStub struct {
+	stubProvider
+	healthy bool
+}
+
+func (h *healthStub) GetAuthenticator() Authenticator    { return nil }
+func (h *healthStub) IsHealthy(ctx context.Context) bool { return h.healthy }
+
+func TestHealthMonitor(t *testing.T) {
+	f := NewFactory()
+	f.Register(&healthStub{stubProvider: stubProvider{name: ProviderQwen}, healthy: true})
+	f.Register(&healthStub{stubProvider: stubProvider{name: ProviderIFlow}, healthy: false})
+	if err := f.PopulateModelProviders(context.Background()); err != nil {
+		t.Fatalf("PopulateModelProviders() error = %v", err)
+	}
+
+	m := NewHealthMonitor(f, time.Minute, time.Second)
+	if m.Ready() {
+		t.Fatal("Ready() before the first check = true, want false")
+	}
+	m.Check(context.Background())
+
+	statuses := m.Statuses()
+	if len(statuses) != 2 || statuses[0].Name != ProviderIFlow || statuses[1].Name != ProviderQwen {
+		t.Fatalf("Statuses() = %+v, want iflow then qwen", statuses)
+	}
+	iflow, qwen := statuses[0], statuses[1]
+	if !qwen.Ready() || qwen.Models != 1 || qwen.CheckedAt == nil || qwen.LastError != nil {
+		t.Errorf("qwen status = %+v, want ready with one model and no error", qwen)
+	}
+	if iflow.Ready() || iflow.LastError == nil || iflow.LastError.Message != "health check failed" {
+		t.Errorf("iflow status = %+v, want unhealthy with a health check error", iflow)
+	}
+	if !m.Ready() {
+		t.Error("Ready() = false, want true while qwen is healthy")
+	}
+
+	for i := 0; i < circuitFailureThreshold; i++ {
+		f.RecordFailure("shared-model", ProviderQwen, errors.New("connection refused"))
+	}
+	if m.Ready() {
+		t.Error("Ready() = true, want false with the only healthy provider's circuit open")
+	}
+	if qwen := m.Statuses()[1]; qwen.LastError == nil || qwen.LastError.Message != "connection refused" {
+		t.Errorf("qwen last error = %+v, want the request failure", qwen.LastError)
+	}
+}
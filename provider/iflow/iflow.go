@@ -13,7 +13,9 @@ import (
 
 	"github.com/sunbankio/qwencoder-proxy/auth"
 	"github.com/sunbankio/qwencoder-proxy/logging"
+	"github.com/sunbankio/qwencoder-proxy/metrics"
 	"github.com/sunbankio/qwencoder-proxy/provider"
+	"github.com/sunbankio/qwencoder-proxy/transport"
 )
 
 const (
@@ -42,6 +44,7 @@ var SupportedModels = []string{
 
 // Provider implements the provider.Provider interface for iFlow
 type Provider struct {
+	name          provider.ProviderType
 	baseURL       string
 	authenticator *auth.IFlowAuthenticator
 	httpClient    *http.Client
@@ -54,16 +57,32 @@ func NewProvider(authenticator *auth.IFlowAuthenticator) *Provider {
 		authenticator = auth.NewIFlowAuthenticator(nil)
 	}
 	return &Provider{
+		name:          provider.ProviderIFlow,
 		baseURL:       APIBaseURL,
 		authenticator: authenticator,
-		httpClient:    &http.Client{Timeout: 5 * time.Minute},
-		logger:        logging.NewLogger(),
+		httpClient:    transport.NewClient(string(provider.ProviderIFlow)),
+		logger:        logging.NewLogger("iflow"),
 	}
 }
 
 // Name returns the provider identifier
 func (p *Provider) Name() provider.ProviderType {
-	return "iflow"
+	return p.name
+}
+
+// Type returns the provider type, which differs from the name of additional accounts
+func (p *Provider) Type() provider.ProviderType {
+	return provider.ProviderIFlow
+}
+
+// SetName sets the name the provider is registered and routed under, such as the name of an additional account
+func (p *Provider) SetName(name provider.ProviderType) {
+	p.name = name
+}
+
+// SetBaseURL sets the API base URL
+func (p *Provider) SetBaseURL(url string) {
+	p.baseURL = url
 }
 
 // Protocol returns the native protocol
@@ -91,11 +110,15 @@ func (p *Provider) GetAuthenticator() provider.Authenticator {
 	return p.authenticator
 }
 
+// Account returns the email address of the authenticated account
+func (p *Provider) Account() string {
+	return p.authenticator.GetEmail()
+}
+
 // IsHealthy checks if the provider is available
 func (p *Provider) IsHealthy(ctx context.Context) bool {
-	// Try to list models as a health check
-	_, err := p.ListModels(ctx)
-	return err == nil
+	// The model list is static, so only the credentials can be checked without using quota
+	return p.authenticator.IsAuthenticated()
 }
 
 // ListModels returns available models in OpenAI format
@@ -120,6 +143,7 @@ func (p *Provider) ListModels(ctx context.Context) (interface{}, error) {
 
 // GenerateContent handles non-streaming requests with OpenAI format
 func (p *Provider) GenerateContent(ctx context.Context, model string, request interface{}) (interface{}, error) {
+	logger := p.logger.WithContext(ctx)
 	token, err := p.authenticator.GetToken(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get token: %w", err)
@@ -129,7 +153,7 @@ func (p *Provider) GenerateContent(ctx context.Context, model string, request in
 	if len(token) > 20 {
 		tokenPrefix = token[:20]
 	}
-	p.logger.DebugLog("[iFlow] Using access token (first 20 chars): %s", tokenPrefix)
+	logger.DebugLog("[iFlow] Using access token (first 20 chars): %s", tokenPrefix)
 
 	// Marshal the request
 	reqBody, err := json.Marshal(request)
@@ -138,7 +162,8 @@ func (p *Provider) GenerateContent(ctx context.Context, model string, request in
 	}
 
 	url := fmt.Sprintf("%s/chat/completions", p.baseURL)
-	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(reqBody))
+	reqCtx := transport.WithModel(ctx, model, false)
+	req, err := http.NewRequestWithContext(reqCtx, "POST", url, bytes.NewReader(reqBody))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
@@ -148,7 +173,7 @@ func (p *Provider) GenerateContent(ctx context.Context, model string, request in
 	req.Header.Set("Accept", "application/json")
 	req.Header.Set("User-Agent", "iflow-cli/0.4.8")
 
-	p.logger.DebugLog("[iFlow] Sending chat completions request to %s", url)
+	logger.DebugLog("[iFlow] Sending chat completions request to %s", url)
 
 	resp, err := p.httpClient.Do(req)
 	if err != nil {
@@ -156,18 +181,19 @@ func (p *Provider) GenerateContent(ctx context.Context, model string, request in
 	}
 	defer resp.Body.Close()
 
-	p.logger.DebugLog("[iFlow] Response status: %d", resp.StatusCode)
-	p.logger.DebugLog("[iFlow] Response headers: %v", resp.Header)
+	logger.DebugLog("[iFlow] Response status: %d", resp.StatusCode)
+	logger.DebugLog("[iFlow] Response headers: %v", resp.Header)
 
 	if resp.StatusCode == http.StatusUnauthorized {
 		// Try to refresh token and retry
 		_, refreshErr := p.authenticator.GetToken(ctx)
 		if refreshErr != nil {
 			body, _ := io.ReadAll(resp.Body)
-			return nil, fmt.Errorf("unauthorized and token refresh failed: %s", string(body))
+			return nil, fmt.Errorf("unauthorized and token refresh failed: %w", provider.NewAPIError(p.Name(), resp, body))
 		}
 
 		// Retry with refreshed token
+		metrics.Retries.Inc(string(p.Name()), metrics.RetryAuth)
 		token, _ = p.authenticator.GetToken(ctx)
 		req.Header.Set("Authorization", "Bearer "+token)
 
@@ -180,8 +206,8 @@ func (p *Provider) GenerateContent(ctx context.Context, model string, request in
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		p.logger.DebugLog("[iFlow] API error response body: %s", string(body))
-		return nil, fmt.Errorf("API error (status %d): %s", resp.StatusCode, string(body))
+		logger.DebugLog("[iFlow] API error response body: %s", string(body))
+		return nil, provider.NewAPIError(p.Name(), resp, body)
 	}
 
 	respBody, err := io.ReadAll(resp.Body)
@@ -189,7 +215,7 @@ func (p *Provider) GenerateContent(ctx context.Context, model string, request in
 		return nil, fmt.Errorf("failed to read response body: %w", err)
 	}
 
-	p.logger.DebugLog("[iFlow] Raw response body: %s", string(respBody))
+	logger.DebugLog("[iFlow] Raw response body: %s", string(respBody))
 
 	var chatResp OpenAIChatResponse
 	if err := json.Unmarshal(respBody, &chatResp); err != nil {
@@ -201,6 +227,7 @@ func (p *Provider) GenerateContent(ctx context.Context, model string, request in
 
 // GenerateContentStream handles streaming requests with OpenAI format
 func (p *Provider) GenerateContentStream(ctx context.Context, model string, request interface{}) (io.ReadCloser, error) {
+	logger := p.logger.WithContext(ctx)
 	token, err := p.authenticator.GetToken(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get token: %w", err)
@@ -213,7 +240,8 @@ func (p *Provider) GenerateContentStream(ctx context.Context, model string, requ
 	}
 
 	url := fmt.Sprintf("%s/chat/completions", p.baseURL)
-	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(reqBody))
+	reqCtx := transport.WithModel(ctx, model, true)
+	req, err := http.NewRequestWithContext(reqCtx, "POST", url, bytes.NewReader(reqBody))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
@@ -223,15 +251,15 @@ func (p *Provider) GenerateContentStream(ctx context.Context, model string, requ
 	req.Header.Set("Accept", "text/event-stream, application/json")
 	req.Header.Set("User-Agent", "qwencoder-proxy/1.0")
 
-	p.logger.DebugLog("[iFlow] Sending streaming chat completions request to %s", url)
+	logger.DebugLog("[iFlow] Sending streaming chat completions request to %s", url)
 
 	resp, err := p.httpClient.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to send request: %w", err)
 	}
 
-	p.logger.DebugLog("[iFlow] Streaming response status: %d", resp.StatusCode)
-	p.logger.DebugLog("[iFlow] Streaming response headers: %v", resp.Header)
+	logger.DebugLog("[iFlow] Streaming response status: %d", resp.StatusCode)
+	logger.DebugLog("[iFlow] Streaming response headers: %v", resp.Header)
 
 	if resp.StatusCode == http.StatusUnauthorized {
 		// Try to refresh token and retry
@@ -239,10 +267,11 @@ func (p *Provider) GenerateContentStream(ctx context.Context, model string, requ
 		if refreshErr != nil {
 			body, _ := io.ReadAll(resp.Body)
 			resp.Body.Close()
-			return nil, fmt.Errorf("unauthorized and token refresh failed: %s", string(body))
+			return nil, fmt.Errorf("unauthorized and token refresh failed: %w", provider.NewAPIError(p.Name(), resp, body))
 		}
 
 		// Retry with refreshed token
+		metrics.Retries.Inc(string(p.Name()), metrics.RetryAuth)
 		token, _ = p.authenticator.GetToken(ctx)
 		req.Header.Set("Authorization", "Bearer "+token)
 
@@ -255,8 +284,8 @@ func (p *Provider) GenerateContentStream(ctx context.Context, model string, requ
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
 		resp.Body.Close()
-		p.logger.DebugLog("[iFlow] Streaming API error response body: %s", string(body))
-		return nil, fmt.Errorf("API error (status %d): %s", resp.StatusCode, string(body))
+		logger.DebugLog("[iFlow] Streaming API error response body: %s", string(body))
+		return nil, provider.NewAPIError(p.Name(), resp, body)
 	}
 
 	return resp.Body, nil
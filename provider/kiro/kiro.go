@@ -2,12 +2,10 @@
 package kiro
 
 import (
-	"bufio"
 	"bytes"
 	"context"
 	"crypto/rand"
 	"crypto/sha256"
-	"encoding/binary"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
@@ -15,12 +13,18 @@ import (
 	"net/http"
 	"os"
 	"runtime"
+	"slices"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/sunbankio/qwencoder-proxy/auth"
+	"github.com/sunbankio/qwencoder-proxy/eventstream"
 	"github.com/sunbankio/qwencoder-proxy/logging"
+	"github.com/sunbankio/qwencoder-proxy/openai"
 	"github.com/sunbankio/qwencoder-proxy/provider"
+	"github.com/sunbankio/qwencoder-proxy/sse"
+	"github.com/sunbankio/qwencoder-proxy/transport"
 )
 
 const (
@@ -54,10 +58,12 @@ var ModelMapping = map[string]string{
 
 // Provider implements the provider.Provider interface for Kiro
 type Provider struct {
+	name          provider.ProviderType
 	authenticator *auth.KiroAuthenticator
 	httpClient    *http.Client
 	logger        *logging.Logger
 	machineID     string
+	modelMappings map[string]string // added to ModelMapping and SupportedModels for this instance
 }
 
 // NewProvider creates a new Kiro provider
@@ -66,9 +72,10 @@ func NewProvider(authenticator *auth.KiroAuthenticator) *Provider {
 		authenticator = auth.NewKiroAuthenticator(nil)
 	}
 	return &Provider{
+		name:          provider.ProviderKiro,
 		authenticator: authenticator,
-		httpClient:    &http.Client{Timeout: 5 * time.Minute},
-		logger:        logging.NewLogger(),
+		httpClient:    transport.NewClient(string(provider.ProviderKiro)),
+		logger:        logging.NewLogger("kiro"),
 		machineID:     generateMachineID(),
 	}
 }
@@ -82,17 +89,51 @@ func generateMachineID() string {
 
 // Name returns the provider identifier
 func (p *Provider) Name() provider.ProviderType {
+	return p.name
+}
+
+// Type returns the provider type, which differs from the name of additional accounts
+func (p *Provider) Type() provider.ProviderType {
 	return provider.ProviderKiro
 }
 
+// SetName sets the name the provider is registered and routed under, such as the name of an additional account
+func (p *Provider) SetName(name provider.ProviderType) {
+	p.name = name
+}
+
 // Protocol returns the native protocol
 func (p *Provider) Protocol() provider.ProtocolType {
 	return provider.ProtocolClaude
 }
 
+// SetModelMappings sets model mappings of this provider, used ahead of ModelMapping. The
+// mapped models are listed as supported, as Kiro has no model list endpoint.
+func (p *Provider) SetModelMappings(mappings map[string]string) {
+	p.modelMappings = mappings
+}
+
 // SupportedModels returns list of supported model IDs
 func (p *Provider) SupportedModels() []string {
-	return SupportedModels
+	if len(p.modelMappings) == 0 {
+		return SupportedModels
+	}
+	models := slices.Clone(SupportedModels)
+	for model := range p.modelMappings {
+		if !slices.Contains(models, model) {
+			models = append(models, model)
+		}
+	}
+	sort.Strings(models[len(SupportedModels):])
+	return models
+}
+
+// mapModel maps an external model name to the internal Kiro model name
+func (p *Provider) mapModel(model string) string {
+	if mapped, exists := p.modelMappings[model]; exists {
+		return mapped
+	}
+	return MapModelName(model)
 }
 
 // SupportsModel checks if the provider supports the given model
@@ -101,7 +142,7 @@ func (p *Provider) SupportsModel(model string) bool {
 	if strings.HasPrefix(modelLower, "claude-") {
 		return true
 	}
-	for _, m := range SupportedModels {
+	for _, m := range p.SupportedModels() {
 		if strings.EqualFold(m, model) {
 			return true
 		}
@@ -114,6 +155,11 @@ func (p *Provider) GetAuthenticator() provider.Authenticator {
 	return p.authenticator
 }
 
+// Account returns the profile ARN of the authenticated account
+func (p *Provider) Account() string {
+	return p.authenticator.GetProfileArn()
+}
+
 // IsHealthy checks if the provider is available
 func (p *Provider) IsHealthy(ctx context.Context) bool {
 	return p.authenticator.IsAuthenticated()
@@ -149,8 +195,9 @@ func (p *Provider) getHeaders(token string, invocationID string) map[string]stri
 // ListModels returns available models in Claude format
 func (p *Provider) ListModels(ctx context.Context) (interface{}, error) {
 	// Kiro doesn't have a model list endpoint, return static list
-	models := make([]ClaudeModel, len(SupportedModels))
-	for i, model := range SupportedModels {
+	supported := p.SupportedModels()
+	models := make([]ClaudeModel, len(supported))
+	for i, model := range supported {
 		models[i] = ClaudeModel{
 			ID:          model,
 			DisplayName: model,
@@ -173,10 +220,7 @@ func (p *Provider) GenerateContent(ctx context.Context, model string, request in
 	}
 
 	// Map model name
-	internalModel := model
-	if mapped, exists := ModelMapping[model]; exists {
-		internalModel = mapped
-	}
+	internalModel := p.mapModel(model)
 
 	// Build Kiro request
 	kiroReq := p.buildKiroRequest(claudeReq, internalModel)
@@ -187,7 +231,8 @@ func (p *Provider) GenerateContent(ctx context.Context, model string, request in
 	}
 
 	url := p.getBaseURL() + "/generateAssistantResponse"
-	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(reqBody))
+	reqCtx := transport.WithModel(ctx, model, false)
+	req, err := http.NewRequestWithContext(reqCtx, "POST", url, bytes.NewReader(reqBody))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
@@ -198,10 +243,9 @@ func (p *Provider) GenerateContent(ctx context.Context, model string, request in
 		req.Header.Set(k, v)
 	}
 
-	p.logger.DebugLog("[Kiro] Sending generateAssistantResponse request to %s", url)
-	p.logger.DebugLog("[Kiro] Request body: %s", string(reqBody))
-	fmt.Println("[Kiro DEBUG] Request body:", string(reqBody))
-	fmt.Println("[Kiro DEBUG] Invocation ID:", invocationID)
+	logger := p.logger.WithContext(ctx)
+	logger.DebugLog("[Kiro] Sending generateAssistantResponse request to %s (invocation %s)", url, invocationID)
+	logger.DebugLog("[Kiro] Request body: %s", string(reqBody))
 
 	resp, err := p.httpClient.Do(req)
 	if err != nil {
@@ -211,17 +255,14 @@ func (p *Provider) GenerateContent(ctx context.Context, model string, request in
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("API error (status %d): %s", resp.StatusCode, string(body))
+		return nil, provider.NewAPIError(p.Name(), resp, body)
 	}
 
-	// Read the entire response body
-	respData, err := io.ReadAll(resp.Body)
+	// Decode the AWS event stream body
+	events, err := p.decodeKiroEvents(resp.Body)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response body: %w", err)
+		return nil, err
 	}
-
-	// Parse AWS Event Stream
-	events := p.parseAwsEventStream(respData)
 	if len(events) == 0 {
 		return nil, fmt.Errorf("no events found in response")
 	}
@@ -243,10 +284,7 @@ func (p *Provider) GenerateContentStream(ctx context.Context, model string, requ
 	}
 
 	// Map model name
-	internalModel := model
-	if mapped, exists := ModelMapping[model]; exists {
-		internalModel = mapped
-	}
+	internalModel := p.mapModel(model)
 	// Build Kiro request
 	kiroReq := p.buildKiroRequest(claudeReq, internalModel)
 
@@ -256,7 +294,8 @@ func (p *Provider) GenerateContentStream(ctx context.Context, model string, requ
 	}
 
 	url := p.getBaseURL() + "/SendMessageStreaming"
-	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(reqBody))
+	reqCtx := transport.WithModel(ctx, model, true)
+	req, err := http.NewRequestWithContext(reqCtx, "POST", url, bytes.NewReader(reqBody))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
@@ -268,8 +307,7 @@ func (p *Provider) GenerateContentStream(ctx context.Context, model string, requ
 	}
 	req.Header.Set("Accept", "text/event-stream")
 
-	p.logger.DebugLog("[Kiro] Sending SendMessageStreaming request to %s", url)
-	fmt.Println("[Kiro DEBUG] Streaming Invocation ID:", invocationID)
+	p.logger.WithContext(ctx).DebugLog("[Kiro] Sending SendMessageStreaming request to %s (invocation %s)", url, invocationID)
 
 	resp, err := p.httpClient.Do(req)
 	if err != nil {
@@ -279,106 +317,98 @@ func (p *Provider) GenerateContentStream(ctx context.Context, model string, requ
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
 		resp.Body.Close()
-		return nil, fmt.Errorf("API error (status %d): %s", resp.StatusCode, string(body))
+		return nil, provider.NewAPIError(p.Name(), resp, body)
 	}
 
 	return p.convertBedrockStreamToOpenAI(ctx, resp.Body, model), nil
 }
 
-// convertBedrockStreamToOpenAI converts AWS Bedrock event stream to OpenAI SSE
+// convertBedrockStreamToOpenAI converts AWS Bedrock event stream to OpenAI SSE.
+// Exceptions and framing errors close the pipe with an error so callers see them.
 func (p *Provider) convertBedrockStreamToOpenAI(ctx context.Context, body io.ReadCloser, model string) io.ReadCloser {
 	r, w := io.Pipe()
 
 	go func() {
 		defer body.Close()
-		defer w.Close()
 
-		reader := bufio.NewReader(body)
-		
 		// Use a unique ID for the whole stream
 		id := "chatcmpl-" + generateUUID()
 		created := time.Now().Unix()
-
-		for {
-			select {
-			case <-ctx.Done():
-				return
-			default:
+		sw := sse.NewWriter(w)
+		writeChunk := func(delta openai.Delta, finishReason *string) error {
+			chunk := openai.ChatCompletionChunk{
+				ID:      id,
+				Object:  "chat.completion.chunk",
+				Created: created,
+				Model:   model,
+				Choices: []openai.ChunkChoice{{Index: 0, Delta: delta, FinishReason: finishReason}},
 			}
+			chunkBytes, _ := json.Marshal(chunk)
+			return sw.WriteData(chunkBytes)
+		}
 
-			// 1. Read Total Length (4 bytes)
-			lenBuf := make([]byte, 4)
-			if _, err := io.ReadFull(reader, lenBuf); err != nil {
-				if err != io.EOF {
-					p.logger.ErrorLog("Error reading stream length: %v", err)
-				}
-				break
-			}
-			totalLen := binary.BigEndian.Uint32(lenBuf)
+		// Tool calls are numbered in the order their toolUseId first appears
+		toolIndex := make(map[string]int)
 
-			// 2. Read Header Length (4 bytes)
-			if _, err := io.ReadFull(reader, lenBuf); err != nil {
-				break
-			}
-			headerLen := binary.BigEndian.Uint32(lenBuf)
-
-			// 3. Read Prelude CRC (4 bytes) - discard
-			if _, err := reader.Discard(4); err != nil {
-				break
+		for msg, err := range eventstream.NewDecoder(body).All() {
+			if ctx.Err() != nil {
+				w.CloseWithError(ctx.Err())
+				return
 			}
-
-			// 4. Read Headers (headerLen bytes) - discard
-			if _, err := reader.Discard(int(headerLen)); err != nil {
-				break
+			if err == nil {
+				err = msg.Err()
 			}
-
-			// 5. Read Payload
-			// Payload length = TotalLen - 12 (prelude) - HeaderLen - 4 (MessageCRC)
-			payloadLen := int(totalLen) - 16 - int(headerLen)
-			
-			payload := make([]byte, payloadLen)
-			if _, err := io.ReadFull(reader, payload); err != nil {
-				break
+			if err != nil {
+				p.logger.WithContext(ctx).ErrorLog("[Kiro] Stream error: %v", err)
+				w.CloseWithError(fmt.Errorf("kiro stream: %w", err))
+				return
 			}
 
-			// 6. Read Message CRC (4 bytes) - discard
-			if _, err := reader.Discard(4); err != nil {
-				break
+			event, ok := classifyKiroMessage(msg)
+			if !ok {
+				continue
 			}
 
-			// Parse payload
-			var event struct {
-				Content string `json:"content"`
+			var delta openai.Delta
+			switch event.Type {
+			case "content":
+				delta.Content, _ = event.Data["content"].(string)
+			case "toolUse", "toolUseInput":
+				toolID, _ := event.Data["toolUseId"].(string)
+				input, _ := event.Data["input"].(string)
+				index, known := toolIndex[toolID]
+				call := openai.ToolCall{Function: openai.FunctionCall{Arguments: input}}
+				if !known {
+					index = len(toolIndex)
+					toolIndex[toolID] = index
+					call.ID = toolID
+					call.Type = "function"
+					call.Function.Name, _ = event.Data["name"].(string)
+				} else if input == "" {
+					continue
+				}
+				call.Index = &index
+				delta.ToolCalls = []openai.ToolCall{call}
 			}
-			
-			if err := json.Unmarshal(payload, &event); err != nil {
+			if delta.Content == "" && len(delta.ToolCalls) == 0 {
 				continue
 			}
-
-			if event.Content != "" {
-				chunk := map[string]interface{}{
-					"id":      id,
-					"object":  "chat.completion.chunk",
-					"created": created,
-					"model":   model,
-					"choices": []map[string]interface{}{
-						{
-							"index": 0,
-							"delta": map[string]string{
-								"content": event.Content,
-							},
-							"finish_reason": nil,
-						},
-					},
-				}
-				
-				chunkBytes, _ := json.Marshal(chunk)
-				fmt.Fprintf(w, "data: %s\n\n", string(chunkBytes))
+			if err := writeChunk(delta, nil); err != nil {
+				return
 			}
 		}
 
+		finishReason := "stop"
+		if len(toolIndex) > 0 {
+			finishReason = "tool_calls"
+		}
+		if err := writeChunk(openai.Delta{}, openai.FinishReason(finishReason)); err != nil {
+			return
+		}
+
 		// Send DONE
-		fmt.Fprintf(w, "data: [DONE]\n\n")
+		sw.WriteData([]byte("[DONE]"))
+		w.Close()
 	}()
 
 	return r
@@ -388,8 +418,11 @@ func (p *Provider) convertBedrockStreamToOpenAI(ctx context.Context, body io.Rea
 func (p *Provider) buildKiroRequest(claudeReq *ClaudeRequest, model string) map[string]interface{} {
 	conversationID := generateUUID()
 
-	// Extract system prompt
-	systemPrompt := claudeReq.System
+	// Extract system prompt (either a string or an array of text blocks)
+	systemPrompt := ""
+	if claudeReq.System != nil {
+		systemPrompt = p.extractTextContent(claudeReq.System)
+	}
 
 	// Process messages
 	var history []map[string]interface{}
@@ -513,6 +546,15 @@ func (p *Provider) extractTextContent(content interface{}) string {
 	if s, ok := content.(string); ok {
 		return s
 	}
+	if blocks, ok := content.([]ContentBlock); ok {
+		var result string
+		for _, b := range blocks {
+			if b.Type == "text" {
+				result += b.Text
+			}
+		}
+		return result
+	}
 	if blocks, ok := content.([]interface{}); ok {
 		var result string
 		for _, b := range blocks {
@@ -548,106 +590,67 @@ type KiroEvent struct {
 	Data map[string]interface{} `json:"data"`
 }
 
-// parseAwsEventStream extracts JSON payloads from AWS Event Stream binary data
-func (p *Provider) parseAwsEventStream(data []byte) []KiroEvent {
+// decodeKiroEvents decodes a complete AWS event stream body into Kiro events.
+// An exception message on the stream is returned as an error.
+func (p *Provider) decodeKiroEvents(body io.Reader) ([]KiroEvent, error) {
 	var events []KiroEvent
-
-	// Possible JSON keys that indicate the start of an event
-	patterns := []string{
-		`{"content":`,
-		`{"name":`,
-		`{"followupPrompt":`,
-		`{"input":`,
-		`{"stop":`,
-	}
-
-	searchStart := 0
-	for {
-		earliest := -1
-		for _, pattern := range patterns {
-			pos := bytes.Index(data[searchStart:], []byte(pattern))
-			if pos != -1 {
-				absPos := searchStart + pos
-				if earliest == -1 || absPos < earliest {
-					earliest = absPos
-				}
-			}
+	for msg, err := range eventstream.NewDecoder(body).All() {
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode event stream: %w", err)
 		}
-
-		if earliest == -1 {
-			break
+		if err := msg.Err(); err != nil {
+			return nil, fmt.Errorf("kiro API error: %w", err)
 		}
-
-		// Find the end of the JSON object using brace counting
-		braceCount := 0
-		jsonEnd := -1
-		inString := false
-		escapeNext := false
-
-		for i := earliest; i < len(data); i++ {
-			char := data[i]
-
-			if escapeNext {
-				escapeNext = false
-				continue
-			}
-
-			if char == '\\' {
-				escapeNext = true
-				continue
-			}
-
-			if char == '"' {
-				inString = !inString
-				continue
-			}
-
-			if !inString {
-				if char == '{' {
-					braceCount++
-				} else if char == '}' {
-					braceCount--
-					if braceCount == 0 {
-						jsonEnd = i
-						break
-					}
-				}
-			}
+		if event, ok := classifyKiroMessage(msg); ok {
+			events = append(events, event)
 		}
+	}
+	return events, nil
+}
 
-		if jsonEnd == -1 {
-			// Incomplete JSON, but we are parsing a full response, so it shouldn't happen
-			break
-		}
+// classifyKiroMessage maps an event-stream message to a Kiro event.
+// The :event-type header is preferred; the payload shape is used when it is absent.
+func classifyKiroMessage(msg *eventstream.Message) (KiroEvent, bool) {
+	var parsed map[string]interface{}
+	if err := json.Unmarshal(msg.Payload, &parsed); err != nil {
+		return KiroEvent{}, false
+	}
 
-		jsonStr := data[earliest : jsonEnd+1]
-		var parsed map[string]interface{}
-		if err := json.Unmarshal(jsonStr, &parsed); err == nil {
-			// Determine event type
-			if _, ok := parsed["content"]; ok && parsed["followupPrompt"] == nil {
-				events = append(events, KiroEvent{Type: "content", Data: parsed})
-			} else if _, ok := parsed["name"]; ok && parsed["toolUseId"] != nil {
-				events = append(events, KiroEvent{Type: "toolUse", Data: parsed})
-			} else if _, ok := parsed["input"]; ok && parsed["name"] == nil {
-				events = append(events, KiroEvent{Type: "toolUseInput", Data: parsed})
-			} else if _, ok := parsed["stop"]; ok {
-				events = append(events, KiroEvent{Type: "toolUseStop", Data: parsed})
-			}
+	switch msg.EventType() {
+	case "assistantResponseEvent":
+		return KiroEvent{Type: "content", Data: parsed}, true
+	case "toolUseEvent":
+		if stop, _ := parsed["stop"].(bool); stop {
+			return KiroEvent{Type: "toolUseStop", Data: parsed}, true
 		}
-
-		searchStart = jsonEnd + 1
-		if searchStart >= len(data) {
-			break
+		if _, ok := parsed["name"]; ok {
+			return KiroEvent{Type: "toolUse", Data: parsed}, true
 		}
-	}
-
-	return events
+		return KiroEvent{Type: "toolUseInput", Data: parsed}, true
+	case "":
+		// Fall through to payload inspection below
+	default:
+		return KiroEvent{}, false
+	}
+
+	if _, ok := parsed["content"]; ok && parsed["followupPrompt"] == nil {
+		return KiroEvent{Type: "content", Data: parsed}, true
+	} else if _, ok := parsed["name"]; ok && parsed["toolUseId"] != nil {
+		return KiroEvent{Type: "toolUse", Data: parsed}, true
+	} else if _, ok := parsed["input"]; ok && parsed["name"] == nil {
+		return KiroEvent{Type: "toolUseInput", Data: parsed}, true
+	} else if _, ok := parsed["stop"]; ok {
+		return KiroEvent{Type: "toolUseStop", Data: parsed}, true
+	}
+	return KiroEvent{}, false
 }
 
 // convertToClaudeResponse converts multiple Kiro events to Claude format
 func (p *Provider) convertToClaudeResponse(events []KiroEvent, model string) *ClaudeResponse {
 	fullContent := ""
 	var toolUseBlocks []ContentBlock
+	var toolInputs []string
+	toolIndex := make(map[string]int)
 
 	for _, event := range events {
 		switch event.Type {
@@ -655,32 +658,34 @@ func (p *Provider) convertToClaudeResponse(events []KiroEvent, model string) *Cl
 			if text, ok := event.Data["content"].(string); ok {
 				fullContent += text
 			}
-		case "toolUse":
-			name, _ := event.Data["name"].(string)
+		case "toolUse", "toolUseInput", "toolUseStop":
+			// Tool input arrives as string fragments across events and is parsed once complete
 			id, _ := event.Data["toolUseId"].(string)
-			inputStr, _ := event.Data["input"].(string) // Input is usually a string here?
-
-			var input interface{}
-			if inputStr != "" {
-				json.Unmarshal([]byte(inputStr), &input)
-			}
-
-			toolUseBlocks = append(toolUseBlocks, ContentBlock{
-				Type:  "tool_use",
-				ID:    id,
-				Name:  name,
-				Input: input,
-			})
-		case "toolUseInput":
-			if len(toolUseBlocks) > 0 {
-				if _, ok := event.Data["input"].(string); ok {
-					// This is tricky because Input is interface{}
-					// We might need to accumulate it as string first
+			i, known := toolIndex[id]
+			if !known {
+				if event.Type != "toolUse" {
+					continue
 				}
+				name, _ := event.Data["name"].(string)
+				i = len(toolUseBlocks)
+				toolIndex[id] = i
+				toolUseBlocks = append(toolUseBlocks, ContentBlock{Type: "tool_use", ID: id, Name: name})
+				toolInputs = append(toolInputs, "")
+			}
+			if input, ok := event.Data["input"].(string); ok {
+				toolInputs[i] += input
 			}
 		}
 	}
 
+	for i := range toolUseBlocks {
+		var input interface{} = map[string]interface{}{}
+		if toolInputs[i] != "" {
+			json.Unmarshal([]byte(toolInputs[i]), &input)
+		}
+		toolUseBlocks[i].Input = input
+	}
+
 	response := &ClaudeResponse{
 		ID:    "msg_" + generateUUID(),
 		Type:  "message",
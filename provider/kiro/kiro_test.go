@@ -0,0 +1,164 @@
+package kiro
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/sunbankio/qwencoder-proxy/eventstream"
+	"github.com/sunbankio/qwencoder-proxy/logging"
+	"github.com/sunbankio/qwencoder-proxy/openai"
+	"github.com/sunbankio/qwencoder-proxy/sse"
+)
+
+func kiroStream(t *testing.T, msgs ...*eventstream.Message) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	for _, msg := range msgs {
+		if err := eventstream.Encode(&buf, msg); err != nil {
+			t.Fatalf("Encode() error = %v", err)
+		}
+	}
+	return buf.Bytes()
+}
+
+func kiroEvent(eventType, payload string) *eventstream.Message {
+	return &eventstream.Message{
+		Headers: eventstream.Headers{
+			eventstream.StringHeader(":message-type", "event"),
+			eventstream.StringHeader(":event-type", eventType),
+		},
+		Payload: []byte(payload),
+	}
+}
+
+func kiroException(exceptionType, message string) *eventstream.Message {
+	return &eventstream.Message{
+		Headers: eventstream.Headers{
+			eventstream.StringHeader(":message-type", "exception"),
+			eventstream.StringHeader(":exception-type", exceptionType),
+		},
+		Payload: []byte(`{"message":"` + message + `"}`),
+	}
+}
+
+func TestDecodeKiroEventsBuildsResponse(t *testing.T) {
+	p := &Provider{logger: logging.NewLogger("kiro")}
+	body := kiroStream(t,
+		kiroEvent("assistantResponseEvent", `{"content":"Checking "}`),
+		kiroEvent("assistantResponseEvent", `{"content":"the weather."}`),
+		kiroEvent("toolUseEvent", `{"name":"get_weather","toolUseId":"t1","input":"{\"city\":"}`),
+		kiroEvent("toolUseEvent", `{"name":"get_weather","toolUseId":"t1","input":"\"Paris\"}"}`),
+		kiroEvent("toolUseEvent", `{"name":"get_weather","toolUseId":"t1","stop":true}`),
+		kiroEvent("meteringEvent", `{"usage":1}`),
+	)
+
+	events, err := p.decodeKiroEvents(bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("decodeKiroEvents() error = %v", err)
+	}
+
+	resp := p.convertToClaudeResponse(events, "claude-sonnet-4-5")
+	if len(resp.Content) != 2 {
+		t.Fatalf("expected 2 content blocks, got %d: %+v", len(resp.Content), resp.Content)
+	}
+	if resp.Content[0].Text != "Checking the weather." {
+		t.Errorf("text = %q", resp.Content[0].Text)
+	}
+	input, _ := resp.Content[1].Input.(map[string]interface{})
+	if resp.Content[1].ID != "t1" || input["city"] != "Paris" {
+		t.Errorf("tool use not assembled: %+v", resp.Content[1])
+	}
+	if resp.StopReason != "tool_use" {
+		t.Errorf("StopReason = %s, want tool_use", resp.StopReason)
+	}
+}
+
+func TestDecodeKiroEventsSurfacesException(t *testing.T) {
+	p := &Provider{logger: logging.NewLogger("kiro")}
+	body := kiroStream(t,
+		kiroEvent("assistantResponseEvent", `{"content":"partial"}`),
+		kiroException("ThrottlingException", "slow down"),
+	)
+
+	_, err := p.decodeKiroEvents(bytes.NewReader(body))
+	var exc *eventstream.ExceptionError
+	if !errors.As(err, &exc) || exc.Type != "ThrottlingException" {
+		t.Fatalf("decodeKiroEvents() error = %v, want ThrottlingException", err)
+	}
+}
+
+func TestStreamSurfacesException(t *testing.T) {
+	p := &Provider{logger: logging.NewLogger("kiro")}
+	body := kiroStream(t,
+		kiroEvent("assistantResponseEvent", `{"content":"hello"}`),
+		kiroException("ValidationException", "bad input"),
+	)
+
+	stream := p.convertBedrockStreamToOpenAI(context.Background(), io.NopCloser(bytes.NewReader(body)), "claude-sonnet-4-5")
+	out, err := io.ReadAll(stream)
+	if !strings.Contains(string(out), `"content":"hello"`) {
+		t.Errorf("stream output missing content: %s", out)
+	}
+	var exc *eventstream.ExceptionError
+	if !errors.As(err, &exc) || exc.Type != "ValidationException" {
+		t.Errorf("stream error = %v, want ValidationException", err)
+	}
+}
+
+func TestStreamEmitsToolCalls(t *testing.T) {
+	p := &Provider{logger: logging.NewLogger("kiro")}
+	body := kiroStream(t,
+		kiroEvent("assistantResponseEvent", `{"content":"Checking."}`),
+		kiroEvent("toolUseEvent", `{"name":"get_weather","toolUseId":"t1","input":"{\"city\":"}`),
+		kiroEvent("toolUseEvent", `{"name":"get_weather","toolUseId":"t1","input":"\"Paris\"}"}`),
+		kiroEvent("toolUseEvent", `{"name":"get_weather","toolUseId":"t1","stop":true}`),
+	)
+
+	stream := p.convertBedrockStreamToOpenAI(context.Background(), io.NopCloser(bytes.NewReader(body)), "claude-sonnet-4-5")
+	reader := sse.NewReader(stream, 0)
+	acc := openai.NewAccumulator()
+	for {
+		ev, err := reader.Next()
+		if err != nil {
+			t.Fatalf("Next() error = %v", err)
+		}
+		if ev.Data == "[DONE]" {
+			break
+		}
+		var chunk openai.ChatCompletionChunk
+		if err := json.Unmarshal([]byte(ev.Data), &chunk); err != nil {
+			t.Fatalf("invalid chunk %s: %v", ev.Data, err)
+		}
+		acc.Add(&chunk)
+	}
+
+	choice := acc.Response().Choices[0]
+	if choice.Message.Content.String() != "Checking." || choice.FinishReason != "tool_calls" {
+		t.Errorf("choice = %+v", choice)
+	}
+	if calls := choice.Message.ToolCalls; len(calls) != 1 || calls[0].ID != "t1" || calls[0].Function.Arguments != `{"city":"Paris"}` {
+		t.Errorf("tool calls = %+v", calls)
+	}
+}
+
+func TestModelMappingsArePerProvider(t *testing.T) {
+	mapped := NewProvider(nil)
+	mapped.SetModelMappings(map[string]string{"claude-test-model": "CLAUDE_TEST_V1_0"})
+	plain := NewProvider(nil)
+
+	if !slices.Contains(mapped.SupportedModels(), "claude-test-model") || mapped.mapModel("claude-test-model") != "CLAUDE_TEST_V1_0" {
+		t.Errorf("mapped provider models = %v, mapping = %s; want the added model", mapped.SupportedModels(), mapped.mapModel("claude-test-model"))
+	}
+	if slices.Contains(plain.SupportedModels(), "claude-test-model") || plain.mapModel("claude-test-model") != "claude-test-model" {
+		t.Error("a mapping set on one provider leaked into another")
+	}
+	if slices.Contains(SupportedModels, "claude-test-model") {
+		t.Error("SetModelMappings() changed the package model list")
+	}
+}
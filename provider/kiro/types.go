@@ -6,7 +6,7 @@ type ClaudeRequest struct {
 	Model         string         `json:"model"`
 	Messages      []Message      `json:"messages"`
 	MaxTokens     int            `json:"max_tokens"`
-	System        string         `json:"system,omitempty"`
+	System        MessageContent `json:"system,omitempty"`
 	Temperature   *float64       `json:"temperature,omitempty"`
 	TopP          *float64       `json:"top_p,omitempty"`
 	TopK          *int           `json:"top_k,omitempty"`
@@ -15,11 +15,12 @@ type ClaudeRequest struct {
 	Metadata      *Metadata      `json:"metadata,omitempty"`
 	Tools         []Tool         `json:"tools,omitempty"`
 	ToolChoice    *ToolChoice    `json:"tool_choice,omitempty"`
+	Thinking      *Thinking      `json:"thinking,omitempty"`
 }
 
 // Message represents a message in the conversation
 type Message struct {
-	Role    string        `json:"role"`
+	Role    string         `json:"role"`
 	Content MessageContent `json:"content"`
 }
 
@@ -28,21 +29,49 @@ type MessageContent interface{}
 
 // ContentBlock represents a content block in a message
 type ContentBlock struct {
-	Type      string     `json:"type"`
-	Text      string     `json:"text,omitempty"`
-	Source    *Source    `json:"source,omitempty"`
-	ID        string     `json:"id,omitempty"`
-	Name      string     `json:"name,omitempty"`
-	Input     interface{} `json:"input,omitempty"`
-	ToolUseID string     `json:"tool_use_id,omitempty"`
-	Content   string     `json:"content,omitempty"`
+	Type         string        `json:"type"`
+	Text         string        `json:"text,omitempty"`
+	Source       *Source       `json:"source,omitempty"`
+	ID           string        `json:"id,omitempty"`
+	Name         string        `json:"name,omitempty"`
+	Input        interface{}   `json:"input,omitempty"`
+	ToolUseID    string        `json:"tool_use_id,omitempty"`
+	Content      interface{}   `json:"content,omitempty"`
+	IsError      bool          `json:"is_error,omitempty"`
+	Thinking     string        `json:"thinking,omitempty"`
+	Signature    string        `json:"signature,omitempty"`
+	CacheControl *CacheControl `json:"cache_control,omitempty"`
+	Citations    []Citation    `json:"citations,omitempty"`
+}
+
+// CacheControl marks a block as a prompt caching breakpoint
+type CacheControl struct {
+	Type string `json:"type"`
+	TTL  string `json:"ttl,omitempty"`
+}
+
+// Citation represents a source reference attached to a text block
+type Citation struct {
+	Type      string `json:"type"`
+	CitedText string `json:"cited_text,omitempty"`
+	URL       string `json:"url,omitempty"`
+	Title     string `json:"title,omitempty"`
+	StartChar int    `json:"start_char_index,omitempty"`
+	EndChar   int    `json:"end_char_index,omitempty"`
+}
+
+// Thinking represents the extended thinking configuration of a request
+type Thinking struct {
+	Type         string `json:"type"`
+	BudgetTokens int    `json:"budget_tokens,omitempty"`
 }
 
 // Source represents an image source
 type Source struct {
 	Type      string `json:"type"`
-	MediaType string `json:"media_type"`
-	Data      string `json:"data"`
+	MediaType string `json:"media_type,omitempty"`
+	Data      string `json:"data,omitempty"`
+	URL       string `json:"url,omitempty"`
 }
 
 // Metadata represents request metadata
@@ -77,8 +106,10 @@ type ClaudeResponse struct {
 
 // Usage represents token usage information
 type Usage struct {
-	InputTokens  int `json:"input_tokens"`
-	OutputTokens int `json:"output_tokens"`
+	InputTokens              int `json:"input_tokens"`
+	OutputTokens             int `json:"output_tokens"`
+	CacheCreationInputTokens int `json:"cache_creation_input_tokens,omitempty"`
+	CacheReadInputTokens     int `json:"cache_read_input_tokens,omitempty"`
 }
 
 // StreamEvent represents a streaming event
@@ -95,6 +126,9 @@ type StreamEvent struct {
 type Delta struct {
 	Type         string `json:"type,omitempty"`
 	Text         string `json:"text,omitempty"`
+	Thinking     string `json:"thinking,omitempty"`
+	Signature    string `json:"signature,omitempty"`
+	PartialJSON  string `json:"partial_json,omitempty"`
 	StopReason   string `json:"stop_reason,omitempty"`
 	StopSequence string `json:"stop_sequence,omitempty"`
 }
@@ -114,13 +148,13 @@ type ClaudeModelsResponse struct {
 
 // KiroCredentials represents the Kiro AWS SSO credentials
 type KiroCredentials struct {
-	AccessToken  string `json:"accessToken"`
-	ExpiresAt    string `json:"expiresAt"`
-	RefreshToken string `json:"refreshToken,omitempty"`
-	Region       string `json:"region,omitempty"`
-	StartURL     string `json:"startUrl,omitempty"`
-	ClientID     string `json:"clientId,omitempty"`
-	ClientSecret string `json:"clientSecret,omitempty"`
+	AccessToken           string `json:"accessToken"`
+	ExpiresAt             string `json:"expiresAt"`
+	RefreshToken          string `json:"refreshToken,omitempty"`
+	Region                string `json:"region,omitempty"`
+	StartURL              string `json:"startUrl,omitempty"`
+	ClientID              string `json:"clientId,omitempty"`
+	ClientSecret          string `json:"clientSecret,omitempty"`
 	RegistrationExpiresAt string `json:"registrationExpiresAt,omitempty"`
 }
 
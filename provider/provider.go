@@ -4,6 +4,7 @@ package provider
 import (
 	"context"
 	"io"
+	"time"
 )
 
 // ProviderType identifies the provider
@@ -27,6 +28,32 @@ const (
 	ProtocolQwen   ProtocolType = "qwen"
 )
 
+// AccountReporter is implemented by providers that can identify the upstream
+// account serving their requests, such as an email address or profile ARN
+type AccountReporter interface {
+	Account() string
+}
+
+// TypeReporter is implemented by providers that can be registered under a name of
+// their own, such as a second account, to report the type of provider they are
+type TypeReporter interface {
+	Type() ProviderType
+}
+
+// TypeOf returns the type of a provider: the type it reports, or else its name
+func TypeOf(p Provider) ProviderType {
+	if reporter, ok := p.(TypeReporter); ok {
+		return reporter.Type()
+	}
+	return p.Name()
+}
+
+// ExpiryReporter is implemented by authenticators that can tell when their access
+// token expires. The zero time means no token is stored.
+type ExpiryReporter interface {
+	TokenExpiry() time.Time
+}
+
 // Provider defines the interface for LLM providers
 type Provider interface {
 	// Name returns the provider identifier
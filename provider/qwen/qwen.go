@@ -17,6 +17,8 @@ import (
 	"github.com/sunbankio/qwencoder-proxy/logging"
 	"github.com/sunbankio/qwencoder-proxy/provider"
 	"github.com/sunbankio/qwencoder-proxy/qwenclient"
+	"github.com/sunbankio/qwencoder-proxy/tracing"
+	"github.com/sunbankio/qwencoder-proxy/transport"
 )
 
 const (
@@ -51,7 +53,13 @@ func (a *QwenAuthenticator) Authenticate(ctx context.Context) error {
 }
 
 // GetToken returns a valid access token
-func (a *QwenAuthenticator) GetToken(ctx context.Context) (string, error) {
+func (a *QwenAuthenticator) GetToken(ctx context.Context) (accessToken string, err error) {
+	_, span := tracing.Start(ctx, "GetToken", tracing.String("auth.authenticator", "qwen"))
+	defer func() {
+		span.RecordError(err)
+		span.End()
+	}()
+
 	token, _, err := qwenclient.GetValidTokenAndEndpoint()
 	if err != nil {
 		// If we get an auth error, try to trigger the authentication flow
@@ -79,6 +87,15 @@ func (a *QwenAuthenticator) IsAuthenticated() bool {
 	return err == nil
 }
 
+// TokenExpiry returns when the stored access token expires
+func (a *QwenAuthenticator) TokenExpiry() time.Time {
+	credentials, err := auth.LoadQwenCredentials()
+	if err != nil || credentials.ExpiryDate == 0 {
+		return time.Time{}
+	}
+	return time.UnixMilli(credentials.ExpiryDate)
+}
+
 // GetCredentialsPath returns the path to stored credentials
 func (a *QwenAuthenticator) GetCredentialsPath() string {
 	return auth.GetQwenCredentialsPath()
@@ -95,8 +112,8 @@ func (a *QwenAuthenticator) ClearCredentials() error {
 func NewProvider() *Provider {
 	return &Provider{
 		authenticator: NewQwenAuthenticator(),
-		httpClient:    &http.Client{Timeout: 5 * time.Minute},
-		logger:        logging.NewLogger(),
+		httpClient:    transport.NewClient(string(provider.ProviderQwen)),
+		logger:        logging.NewLogger("qwen"),
 	}
 }
 
@@ -136,8 +153,23 @@ func (p *Provider) GetAuthenticator() provider.Authenticator {
 
 // IsHealthy checks if the provider is available
 func (p *Provider) IsHealthy(ctx context.Context) bool {
-	// Try to list models as a health check
-	_, err := p.ListModels(ctx)
+	// The model list is static, so check that a token can be obtained, refreshing it if
+	// needed, without starting the interactive login. qwenclient takes no context, so a
+	// check still waiting when ctx is done fails and any refresh completes in the background.
+	result := make(chan error, 1)
+	go func() {
+		_, _, err := qwenclient.GetValidTokenAndEndpoint()
+		result <- err
+	}()
+	var err error
+	select {
+	case err = <-result:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	if err != nil {
+		p.logger.WithContext(ctx).WarningLog("[Qwen] Health check failed: %v", err)
+	}
 	return err == nil
 }
 
@@ -161,13 +193,14 @@ func (p *Provider) ListModels(ctx context.Context) (interface{}, error) {
 
 // GenerateContent handles non-streaming requests with native format
 func (p *Provider) GenerateContent(ctx context.Context, model string, request interface{}) (interface{}, error) {
+	logger := p.logger.WithContext(ctx)
 	token, endpoint, err := qwenclient.GetValidTokenAndEndpoint()
 	if err != nil {
 		return nil, fmt.Errorf("failed to get token: %w", err)
 	}
 
 	// Log the original request before conversion
-	p.logger.DebugLog("[Qwen] Original request before conversion: %+v", request)
+	logger.DebugLog("[Qwen] Original request before conversion: %+v", request)
 	
 	// Convert request to proper format for Qwen API
 	reqBody, err := json.Marshal(request)
@@ -175,7 +208,7 @@ func (p *Provider) GenerateContent(ctx context.Context, model string, request in
 		return nil, fmt.Errorf("failed to marshal request: %w", err)
 	}
 
-	p.logger.DebugLog("[Qwen] Request body being sent: %s", string(reqBody))
+	logger.DebugLog("[Qwen] Request body being sent: %s", string(reqBody))
 
 	// For OpenAI-compatible requests, we need to forward the original request path
 	// The endpoint from credentials may or may not include /v1, so we ensure it's properly formatted
@@ -191,9 +224,10 @@ func (p *Provider) GenerateContent(ctx context.Context, model string, request in
 	// Since this is called from the OpenAI handler for /v1/chat/completions,
 	// we construct the appropriate path
 	url := fmt.Sprintf("%s/chat/completions", normalizedEndpoint)
-	p.logger.DebugLog("[Qwen] Constructed target URL: %s", url)
+	logger.DebugLog("[Qwen] Constructed target URL: %s", url)
 	
-	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(reqBody))
+	reqCtx := transport.WithModel(ctx, model, false)
+	req, err := http.NewRequestWithContext(reqCtx, "POST", url, bytes.NewReader(reqBody))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
@@ -205,21 +239,21 @@ func (p *Provider) GenerateContent(ctx context.Context, model string, request in
 	req.Header.Set("X-DashScope-UserAgent", fmt.Sprintf("QwenCode/0.0.10 (%s; %s)", runtime.GOOS, runtime.GOARCH))
 	req.Header.Set("X-DashScope-CacheControl", "enable")
 
-	p.logger.DebugLog("[Qwen] Sending request to %s with headers: %v", url, req.Header)
+	logger.DebugLog("[Qwen] Sending request to %s with headers: %v", url, req.Header)
 
 	resp, err := p.httpClient.Do(req)
 	if err != nil {
-		p.logger.ErrorLog("[Qwen] Failed to send request: %v", err)
+		logger.ErrorLog("[Qwen] Failed to send request: %v", err)
 		return nil, fmt.Errorf("failed to send request: %w", err)
 	}
 	defer resp.Body.Close()
 
-	p.logger.DebugLog("[Qwen] Response status: %d", resp.StatusCode)
+	logger.DebugLog("[Qwen] Response status: %d", resp.StatusCode)
 	
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		p.logger.ErrorLog("[Qwen] API error (status %d): %s", resp.StatusCode, string(body))
-		return nil, fmt.Errorf("API error (status %d): %s", resp.StatusCode, string(body))
+		logger.ErrorLog("[Qwen] API error (status %d): %s", resp.StatusCode, string(body))
+		return nil, provider.NewAPIError(p.Name(), resp, body)
 	}
 
 	var qwenResp map[string]interface{}
@@ -227,12 +261,13 @@ func (p *Provider) GenerateContent(ctx context.Context, model string, request in
 		return nil, fmt.Errorf("failed to decode response: %w", err)
 	}
 
-	p.logger.DebugLog("[Qwen] Received response: %+v", qwenResp)
+	logger.DebugLog("[Qwen] Received response: %+v", qwenResp)
 	return qwenResp, nil
 }
 
 // GenerateContentStream handles streaming requests with native format
 func (p *Provider) GenerateContentStream(ctx context.Context, model string, request interface{}) (io.ReadCloser, error) {
+	logger := p.logger.WithContext(ctx)
 	token, endpoint, err := qwenclient.GetValidTokenAndEndpoint()
 	if err != nil {
 		return nil, fmt.Errorf("failed to get token: %w", err)
@@ -256,9 +291,10 @@ func (p *Provider) GenerateContentStream(ctx context.Context, model string, requ
 	}
 	
 	url := fmt.Sprintf("%s/chat/completions", normalizedEndpoint)
-	p.logger.DebugLog("[Qwen] Constructed streaming target URL: %s", url)
+	logger.DebugLog("[Qwen] Constructed streaming target URL: %s", url)
 	
-	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(reqBody))
+	reqCtx := transport.WithModel(ctx, model, true)
+	req, err := http.NewRequestWithContext(reqCtx, "POST", url, bytes.NewReader(reqBody))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
@@ -271,21 +307,21 @@ func (p *Provider) GenerateContentStream(ctx context.Context, model string, requ
 	req.Header.Set("X-DashScope-UserAgent", fmt.Sprintf("QwenCode/0.0.10 (%s; %s)", runtime.GOOS, runtime.GOARCH))
 	req.Header.Set("X-DashScope-CacheControl", "enable")
 
-	p.logger.DebugLog("[Qwen] Sending streaming request to %s", url)
+	logger.DebugLog("[Qwen] Sending streaming request to %s", url)
 
 	resp, err := p.httpClient.Do(req)
 	if err != nil {
-		p.logger.ErrorLog("[Qwen] Failed to send streaming request: %v", err)
+		logger.ErrorLog("[Qwen] Failed to send streaming request: %v", err)
 		return nil, fmt.Errorf("failed to send request: %w", err)
 	}
 
-	p.logger.DebugLog("[Qwen] Streaming response status: %d", resp.StatusCode)
+	logger.DebugLog("[Qwen] Streaming response status: %d", resp.StatusCode)
 	
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
 		resp.Body.Close()
-		p.logger.ErrorLog("[Qwen] Streaming API error (status %d): %s", resp.StatusCode, string(body))
-		return nil, fmt.Errorf("API error (status %d): %s", resp.StatusCode, string(body))
+		logger.ErrorLog("[Qwen] Streaming API error (status %d): %s", resp.StatusCode, string(body))
+		return nil, provider.NewAPIError(p.Name(), resp, body)
 	}
 
 	return resp.Body, nil
@@ -0,0 +1,390 @@
+package provider
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ErrProvidersDisabled is returned by GetByModel when every provider of a model is disabled
+var ErrProvidersDisabled = errors.New("every provider serving the model is disabled")
+
+// ErrRulesNotSaved is returned when a routing rule took effect but could not be written
+// to the rules file, so it will be lost on restart
+var ErrRulesNotSaved = errors.New("routing rules changed but not saved")
+
+// RoutingRules are operator overrides of how requests are routed. They come from the
+// config file and the admin API; rules set through the admin API take effect immediately,
+// are kept in a rules file and replace config file rules for the same model, provider or
+// account.
+type RoutingRules struct {
+	Models    map[string]ModelRule          `json:"models,omitempty"`
+	Providers map[ProviderType]ProviderRule `json:"providers,omitempty"`
+	// Accounts apply to every provider whose AccountReporter reports the account
+	Accounts map[string]ProviderRule `json:"accounts,omitempty"`
+}
+
+// ModelRule overrides the choice of provider for a model. Both take precedence over the
+// last successful provider.
+type ModelRule struct {
+	// Pin is used first while its circuit is not open
+	Pin ProviderType `json:"pin,omitempty"`
+	// Order lists providers to try in turn; providers not listed follow in catalog order
+	Order []ProviderType `json:"order,omitempty"`
+}
+
+// ProviderRule takes a provider or account out of rotation
+type ProviderRule struct {
+	// Disabled providers are never chosen, until DisabledUntil if it is set
+	Disabled      bool       `json:"disabled,omitempty"`
+	DisabledUntil *time.Time `json:"disabled_until,omitempty"`
+	// Draining providers get no new requests while another provider serves the model;
+	// requests already running finish normally
+	Draining bool `json:"draining,omitempty"`
+}
+
+// disabled reports whether the rule disables a provider at now
+func (r ProviderRule) disabled(now time.Time) bool {
+	return r.Disabled && (r.DisabledUntil == nil || now.Before(*r.DisabledUntil))
+}
+
+// empty reports whether the rule changes nothing, so it can be removed unless it
+// overrides a configured rule
+func (r ProviderRule) empty() bool {
+	return !r.Disabled && !r.Draining
+}
+
+// LoadRoutingRules reads the routing rules from path, which later changes are saved to.
+// A missing file leaves the rules empty.
+func (f *Factory) LoadRoutingRules(path string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.rulesPath = path
+	if path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read routing rules: %w", err)
+	}
+	var rules RoutingRules
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return fmt.Errorf("failed to parse routing rules %s: %w", path, err)
+	}
+	f.adminRules = rules
+	f.mergeRules()
+	return nil
+}
+
+// SetConfiguredRules sets the routing rules from the config file. Pinned and ordered
+// providers must be registered.
+func (f *Factory) SetConfiguredRules(rules RoutingRules) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for model, rule := range rules.Models {
+		if rule.Pin != "" {
+			if _, ok := f.providers[rule.Pin]; !ok {
+				return fmt.Errorf("model %s is pinned to unknown provider %s", model, rule.Pin)
+			}
+		}
+		for _, providerType := range rule.Order {
+			if _, ok := f.providers[providerType]; !ok {
+				return fmt.Errorf("model %s orders unknown provider %s", model, providerType)
+			}
+		}
+	}
+	for providerType := range rules.Providers {
+		if _, ok := f.providers[providerType]; !ok {
+			return fmt.Errorf("provider not found: %s", providerType)
+		}
+	}
+	f.configuredRules = rules
+	f.mergeRules()
+	return nil
+}
+
+// mergeRules sets the rules in effect: the admin rules over the configured ones. The
+// caller must hold f.mu.
+func (f *Factory) mergeRules() {
+	f.rules = RoutingRules{
+		Models:    make(map[string]ModelRule),
+		Providers: make(map[ProviderType]ProviderRule),
+		Accounts:  make(map[string]ProviderRule),
+	}
+	for _, rules := range []RoutingRules{f.configuredRules, f.adminRules} {
+		for model, rule := range rules.Models {
+			f.rules.Models[model] = rule
+		}
+		for providerType, rule := range rules.Providers {
+			f.rules.Providers[providerType] = rule
+		}
+		for account, rule := range rules.Accounts {
+			f.rules.Accounts[account] = rule
+		}
+	}
+}
+
+// RoutingRules returns a copy of the routing rules in effect
+func (f *Factory) RoutingRules() RoutingRules {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	rules := RoutingRules{
+		Models:    make(map[string]ModelRule, len(f.rules.Models)),
+		Providers: make(map[ProviderType]ProviderRule, len(f.rules.Providers)),
+		Accounts:  make(map[string]ProviderRule, len(f.rules.Accounts)),
+	}
+	for model, rule := range f.rules.Models {
+		rule.Order = append([]ProviderType(nil), rule.Order...)
+		rules.Models[model] = rule
+	}
+	for providerType, rule := range f.rules.Providers {
+		rules.Providers[providerType] = rule
+	}
+	for account, rule := range f.rules.Accounts {
+		rules.Accounts[account] = rule
+	}
+	return rules
+}
+
+// SetModelRule replaces the admin rule of a model; an empty rule removes it. The pinned
+// provider must serve the model in the current catalog, and ordered providers must be
+// registered.
+func (f *Factory) SetModelRule(model string, rule ModelRule) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if rule.Pin != "" && !f.serves(model, rule.Pin) {
+		return fmt.Errorf("provider %s does not serve model %s", rule.Pin, model)
+	}
+	seen := make(map[ProviderType]bool, len(rule.Order))
+	for _, providerType := range rule.Order {
+		if _, ok := f.providers[providerType]; !ok {
+			return fmt.Errorf("provider not found: %s", providerType)
+		}
+		if seen[providerType] {
+			return fmt.Errorf("provider %s is listed twice", providerType)
+		}
+		seen[providerType] = true
+	}
+
+	if f.adminRules.Models == nil {
+		f.adminRules.Models = make(map[string]ModelRule)
+	}
+	_, configured := f.configuredRules.Models[model]
+	if rule.Pin == "" && len(rule.Order) == 0 && !configured {
+		delete(f.adminRules.Models, model)
+	} else {
+		f.adminRules.Models[model] = rule
+	}
+	f.mergeRules()
+	f.logger.InfoLog("[Factory] Routing rule for model %s set to pin %q, order %v", model, rule.Pin, rule.Order)
+	return f.saveRules()
+}
+
+// DeleteModelRule removes the admin rule of a model, so a configured rule applies again
+func (f *Factory) DeleteModelRule(model string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	delete(f.adminRules.Models, model)
+	f.mergeRules()
+	f.logger.InfoLog("[Factory] Routing rule for model %s removed", model)
+	return f.saveRules()
+}
+
+// SetProviderRule replaces the admin rule of a provider. An empty rule removes it, unless
+// it overrides a configured rule, so a provider the config file disables can be enabled.
+func (f *Factory) SetProviderRule(providerType ProviderType, rule ProviderRule) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.providers[providerType]; !ok {
+		return fmt.Errorf("provider not found: %s", providerType)
+	}
+	if f.adminRules.Providers == nil {
+		f.adminRules.Providers = make(map[ProviderType]ProviderRule)
+	}
+	if _, configured := f.configuredRules.Providers[providerType]; rule.empty() && !configured {
+		delete(f.adminRules.Providers, providerType)
+	} else {
+		f.adminRules.Providers[providerType] = rule
+	}
+	f.mergeRules()
+	f.logger.InfoLog("[Factory] Routing rule for provider %s set to disabled %v, draining %v", providerType, rule.Disabled, rule.Draining)
+	return f.saveRules()
+}
+
+// DeleteProviderRule removes the admin rule of a provider, so a configured rule applies again
+func (f *Factory) DeleteProviderRule(providerType ProviderType) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.providers[providerType]; !ok {
+		return fmt.Errorf("provider not found: %s", providerType)
+	}
+	delete(f.adminRules.Providers, providerType)
+	f.mergeRules()
+	f.logger.InfoLog("[Factory] Routing rule for provider %s removed", providerType)
+	return f.saveRules()
+}
+
+// SetAccountRule replaces the admin rule of an upstream account. An empty rule removes it,
+// unless it overrides a configured rule.
+func (f *Factory) SetAccountRule(account string, rule ProviderRule) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if account == "" {
+		return errors.New("account is required")
+	}
+	if f.adminRules.Accounts == nil {
+		f.adminRules.Accounts = make(map[string]ProviderRule)
+	}
+	if _, configured := f.configuredRules.Accounts[account]; rule.empty() && !configured {
+		delete(f.adminRules.Accounts, account)
+	} else {
+		f.adminRules.Accounts[account] = rule
+	}
+	f.mergeRules()
+	f.logger.InfoLog("[Factory] Routing rule for account %s set to disabled %v, draining %v", account, rule.Disabled, rule.Draining)
+	return f.saveRules()
+}
+
+// DeleteAccountRule removes the admin rule of an upstream account, so a configured rule
+// applies again
+func (f *Factory) DeleteAccountRule(account string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if account == "" {
+		return errors.New("account is required")
+	}
+	delete(f.adminRules.Accounts, account)
+	f.mergeRules()
+	f.logger.InfoLog("[Factory] Routing rule for account %s removed", account)
+	return f.saveRules()
+}
+
+// SetAliases sets model names clients may send in place of others, such as "sonnet" for
+// "claude-sonnet-4-5"
+func (f *Factory) SetAliases(aliases map[string]string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.aliases = aliases
+}
+
+// ResolveModel returns the model an alias stands for, or model itself if it is not an alias
+func (f *Factory) ResolveModel(model string) string {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if target, ok := f.aliases[model]; ok {
+		return target
+	}
+	return model
+}
+
+// serves reports whether a provider serves a model in the catalog. The caller must hold f.mu.
+func (f *Factory) serves(model string, providerType ProviderType) bool {
+	for _, p := range f.modelProviders[model] {
+		if p.Name() == providerType {
+			return true
+		}
+	}
+	return false
+}
+
+// providerRule returns the rule applying to a provider, combining its own rule with the
+// rule of its account. The caller must hold f.mu.
+func (f *Factory) providerRule(p Provider, now time.Time) ProviderRule {
+	rule := f.rules.Providers[p.Name()]
+	if reporter, ok := p.(AccountReporter); ok && len(f.rules.Accounts) > 0 {
+		if accountRule, ok := f.rules.Accounts[reporter.Account()]; ok {
+			rule.Disabled = rule.disabled(now) || accountRule.disabled(now)
+			rule.DisabledUntil = nil
+			rule.Draining = rule.Draining || accountRule.Draining
+		}
+	}
+	return rule
+}
+
+// routable applies the routing rules to the candidates of a model: disabled providers are
+// removed, the rest are put in the model's order, and draining providers are removed
+// unless that leaves none. The caller must hold f.mu.
+func (f *Factory) routable(model string, candidates []Provider) []Provider {
+	now := time.Now()
+	enabled := make([]Provider, 0, len(candidates))
+	draining := make([]Provider, 0)
+	for _, p := range candidates {
+		rule := f.providerRule(p, now)
+		switch {
+		case rule.disabled(now):
+		case rule.Draining:
+			draining = append(draining, p)
+		default:
+			enabled = append(enabled, p)
+		}
+	}
+	if len(enabled) == 0 {
+		enabled = draining
+	}
+
+	order := f.rules.Models[model].Order
+	if len(order) == 0 {
+		return enabled
+	}
+	ordered := make([]Provider, 0, len(enabled))
+	listed := make(map[ProviderType]bool, len(order))
+	for _, providerType := range order {
+		listed[providerType] = true
+		for _, p := range enabled {
+			if p.Name() == providerType {
+				ordered = append(ordered, p)
+			}
+		}
+	}
+	for _, p := range enabled {
+		if !listed[p.Name()] {
+			ordered = append(ordered, p)
+		}
+	}
+	return ordered
+}
+
+// saveRules writes the admin rules to the rules file. The caller must hold f.mu.
+func (f *Factory) saveRules() error {
+	if err := f.writeRules(); err != nil {
+		return fmt.Errorf("%w: %v", ErrRulesNotSaved, err)
+	}
+	return nil
+}
+
+// writeRules replaces the rules file atomically. The caller must hold f.mu.
+func (f *Factory) writeRules() error {
+	if f.rulesPath == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(f.adminRules, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode routing rules: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(f.rulesPath), 0700); err != nil {
+		return fmt.Errorf("failed to create routing rules directory: %w", err)
+	}
+	tmp := f.rulesPath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write routing rules: %w", err)
+	}
+	if err := os.Rename(tmp, f.rulesPath); err != nil {
+		return fmt.Errorf("failed to write routing rules: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,205 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// accountStub is a stubProvider reporting an upstream account
+type accountStub struct {
+	stubProvider
+	account string
+}
+
+func (a *accountStub) Account() string { return a.account }
+
+func newRoutingFactory(t *testing.T) *Factory {
+	t.Helper()
+	f := NewFactory()
+	f.Register(&stubProvider{name: ProviderQwen})
+	f.Register(&stubProvider{name: ProviderIFlow})
+	f.Register(&accountStub{stubProvider: stubProvider{name: ProviderKiro}, account: "arn:profile/1"})
+	if err := f.PopulateModelProviders(context.Background()); err != nil {
+		t.Fatalf("PopulateModelProviders() error = %v", err)
+	}
+	return f
+}
+
+func TestRoutingRulesSelection(t *testing.T) {
+	f := newRoutingFactory(t)
+	f.RecordSuccess("shared-model", ProviderIFlow)
+	choose := func() ProviderType {
+		t.Helper()
+		p, err := f.GetByModel("shared-model")
+		if err != nil {
+			t.Fatalf("GetByModel() error = %v", err)
+		}
+		return p.Name()
+	}
+
+	if err := f.SetModelRule("shared-model", ModelRule{Order: []ProviderType{ProviderKiro, ProviderQwen}}); err != nil {
+		t.Fatalf("SetModelRule() error = %v", err)
+	}
+	if got := choose(); got != ProviderKiro {
+		t.Errorf("with an order, GetByModel() = %s, want kiro over the last success", got)
+	}
+	if err := f.SetModelRule("shared-model", ModelRule{Pin: ProviderQwen, Order: []ProviderType{ProviderKiro}}); err != nil {
+		t.Fatalf("SetModelRule() error = %v", err)
+	}
+	if got := choose(); got != ProviderQwen {
+		t.Errorf("with a pin, GetByModel() = %s, want qwen", got)
+	}
+
+	if err := f.SetProviderRule(ProviderQwen, ProviderRule{Draining: true}); err != nil {
+		t.Fatalf("SetProviderRule() error = %v", err)
+	}
+	if got := choose(); got != ProviderKiro {
+		t.Errorf("with the pinned provider draining, GetByModel() = %s, want kiro", got)
+	}
+	if err := f.SetAccountRule("arn:profile/1", ProviderRule{Disabled: true}); err != nil {
+		t.Fatalf("SetAccountRule() error = %v", err)
+	}
+	if got := choose(); got != ProviderIFlow {
+		t.Errorf("with kiro's account disabled, GetByModel() = %s, want iflow", got)
+	}
+	if alt, err := f.GetAlternativeProvider("shared-model", ProviderIFlow); err != nil || alt.Name() != ProviderQwen {
+		t.Errorf("GetAlternativeProvider() = %v, %v; want the draining qwen as the only other choice", alt, err)
+	}
+
+	past := time.Now().Add(-time.Minute)
+	if err := f.SetProviderRule(ProviderIFlow, ProviderRule{Disabled: true, DisabledUntil: &past}); err != nil {
+		t.Fatalf("SetProviderRule() error = %v", err)
+	}
+	if got := choose(); got != ProviderIFlow {
+		t.Errorf("after iflow's disable expired, GetByModel() = %s, want iflow", got)
+	}
+
+	f.SetProviderRule(ProviderIFlow, ProviderRule{Disabled: true})
+	f.SetProviderRule(ProviderQwen, ProviderRule{Disabled: true})
+	if _, err := f.GetByModel("shared-model"); !errors.Is(err, ErrProvidersDisabled) {
+		t.Errorf("with every provider disabled, GetByModel() error = %v, want ErrProvidersDisabled", err)
+	}
+}
+
+func TestRoutingRulesValidation(t *testing.T) {
+	f := newRoutingFactory(t)
+	if err := f.SetModelRule("shared-model", ModelRule{Pin: ProviderGeminiCLI}); err == nil {
+		t.Error("SetModelRule() pinning an unregistered provider succeeded")
+	}
+	if err := f.SetModelRule("shared-model", ModelRule{Order: []ProviderType{ProviderKiro, ProviderKiro}}); err == nil {
+		t.Error("SetModelRule() with a repeated provider succeeded")
+	}
+	if err := f.SetProviderRule(ProviderAntigravity, ProviderRule{Disabled: true}); err == nil {
+		t.Error("SetProviderRule() for an unregistered provider succeeded")
+	}
+}
+
+func TestRoutingRulesPersist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "routing_rules.json")
+	f := newRoutingFactory(t)
+	if err := f.LoadRoutingRules(path); err != nil {
+		t.Fatalf("LoadRoutingRules() of a missing file error = %v", err)
+	}
+	if err := f.SetModelRule("shared-model", ModelRule{Pin: ProviderKiro}); err != nil {
+		t.Fatalf("SetModelRule() error = %v", err)
+	}
+	if err := f.SetProviderRule(ProviderQwen, ProviderRule{Draining: true}); err != nil {
+		t.Fatalf("SetProviderRule() error = %v", err)
+	}
+
+	restarted := newRoutingFactory(t)
+	if err := restarted.LoadRoutingRules(path); err != nil {
+		t.Fatalf("LoadRoutingRules() error = %v", err)
+	}
+	rules := restarted.RoutingRules()
+	if rules.Models["shared-model"].Pin != ProviderKiro || !rules.Providers[ProviderQwen].Draining {
+		t.Errorf("restored rules = %+v, want the kiro pin and qwen draining", rules)
+	}
+	if p, err := restarted.GetByModel("shared-model"); err != nil || p.Name() != ProviderKiro {
+		t.Errorf("GetByModel() after restart = %v, %v; want kiro", p, err)
+	}
+}
+
+func TestConfiguredRulesAndAliases(t *testing.T) {
+	f := newRoutingFactory(t)
+	if err := f.SetConfiguredRules(RoutingRules{Models: map[string]ModelRule{"shared-model": {Pin: ProviderGeminiCLI}}}); err == nil {
+		t.Error("SetConfiguredRules() pinning an unregistered provider succeeded")
+	}
+	configured := RoutingRules{
+		Models:    map[string]ModelRule{"shared-model": {Pin: ProviderQwen}},
+		Providers: map[ProviderType]ProviderRule{ProviderIFlow: {Disabled: true}},
+	}
+	if err := f.SetConfiguredRules(configured); err != nil {
+		t.Fatalf("SetConfiguredRules() error = %v", err)
+	}
+	if p, err := f.GetByModel("shared-model"); err != nil || p.Name() != ProviderQwen {
+		t.Errorf("GetByModel() with a configured pin = %v, %v; want qwen", p, err)
+	}
+
+	// Admin rules override configured ones, which apply again once the admin rule is deleted
+	if err := f.SetModelRule("shared-model", ModelRule{Pin: ProviderKiro}); err != nil {
+		t.Fatalf("SetModelRule() error = %v", err)
+	}
+	if p, err := f.GetByModel("shared-model"); err != nil || p.Name() != ProviderKiro {
+		t.Errorf("GetByModel() with an admin pin = %v, %v; want kiro", p, err)
+	}
+	if err := f.DeleteModelRule("shared-model"); err != nil {
+		t.Fatalf("DeleteModelRule() error = %v", err)
+	}
+	if p, err := f.GetByModel("shared-model"); err != nil || p.Name() != ProviderQwen {
+		t.Errorf("GetByModel() after clearing the admin pin = %v, %v; want qwen", p, err)
+	}
+	if !f.RoutingRules().Providers[ProviderIFlow].Disabled {
+		t.Error("RoutingRules() dropped the configured iflow rule")
+	}
+
+	f.SetAliases(map[string]string{"shared": "shared-model"})
+	if got := f.ResolveModel("shared"); got != "shared-model" {
+		t.Errorf("ResolveModel(shared) = %s, want shared-model", got)
+	}
+	if got := f.ResolveModel("other-model"); got != "other-model" {
+		t.Errorf("ResolveModel(other-model) = %s, want it unchanged", got)
+	}
+}
+
+func TestAdminRulesOverrideConfiguredRules(t *testing.T) {
+	f := newRoutingFactory(t)
+	configured := RoutingRules{
+		Models:    map[string]ModelRule{"shared-model": {Pin: ProviderQwen}},
+		Providers: map[ProviderType]ProviderRule{ProviderIFlow: {Disabled: true}},
+	}
+	if err := f.SetConfiguredRules(configured); err != nil {
+		t.Fatalf("SetConfiguredRules() error = %v", err)
+	}
+
+	// Enabling a provider the config file disables keeps an empty admin rule in its place
+	if err := f.SetProviderRule(ProviderIFlow, ProviderRule{}); err != nil {
+		t.Fatalf("SetProviderRule() error = %v", err)
+	}
+	if f.RoutingRules().Providers[ProviderIFlow].Disabled {
+		t.Error("iflow is still disabled after an admin rule enabled it")
+	}
+	if err := f.SetModelRule("shared-model", ModelRule{}); err != nil {
+		t.Fatalf("SetModelRule() error = %v", err)
+	}
+	if pin := f.RoutingRules().Models["shared-model"].Pin; pin != "" {
+		t.Errorf("pin = %s after an admin rule cleared it, want none", pin)
+	}
+
+	// Deleting the admin rules brings the configured ones back
+	if err := f.DeleteProviderRule(ProviderIFlow); err != nil {
+		t.Fatalf("DeleteProviderRule() error = %v", err)
+	}
+	if !f.RoutingRules().Providers[ProviderIFlow].Disabled {
+		t.Error("iflow is enabled after the admin rule was deleted, want the configured rule")
+	}
+	if err := f.DeleteModelRule("shared-model"); err != nil {
+		t.Fatalf("DeleteModelRule() error = %v", err)
+	}
+	if pin := f.RoutingRules().Models["shared-model"].Pin; pin != ProviderQwen {
+		t.Errorf("pin = %q after the admin rule was deleted, want the configured qwen", pin)
+	}
+}
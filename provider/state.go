@@ -0,0 +1,166 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// stateSnapshotInterval is how often a changed routing state is written to the state file
+const stateSnapshotInterval = 30 * time.Second
+
+// routingState is the snapshot of what the factory learned while routing requests, so a
+// restarted proxy routes like the one it replaces
+type routingState struct {
+	SavedAt       time.Time                       `json:"saved_at"`
+	LastSuccess   map[string]ProviderType         `json:"last_success"`
+	Circuits      map[ProviderType]circuitState   `json:"circuits"`
+	Catalog       map[string][]ProviderType       `json:"catalog"`
+	ContextLimits map[ProviderType]map[string]int `json:"context_limits"`
+}
+
+// circuitState is the snapshot of one circuit breaker
+type circuitState struct {
+	Failures int           `json:"failures"`
+	OpenedAt time.Time     `json:"opened_at,omitempty"`
+	Cooldown time.Duration `json:"cooldown,omitempty"`
+}
+
+// RestoreState reads the routing state snapshot at path, which later snapshots are written
+// to. Providers must be registered first; state of other providers is ignored. It reports
+// whether a model catalog was restored, in which case requests can be routed before
+// PopulateModelProviders runs. A missing file restores nothing.
+func (f *Factory) RestoreState(path string) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.statePath = path
+	if path == "" {
+		return false, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to read routing state: %w", err)
+	}
+	var state routingState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return false, fmt.Errorf("failed to parse routing state %s: %w", path, err)
+	}
+
+	for model, providerType := range state.LastSuccess {
+		if _, ok := f.providers[providerType]; ok {
+			f.lastSuccess[model] = providerType
+		}
+	}
+	for providerType, snapshot := range state.Circuits {
+		if _, ok := f.providers[providerType]; ok {
+			f.circuits[providerType] = &circuit{failures: snapshot.Failures, openedAt: snapshot.OpenedAt, cooldown: snapshot.Cooldown}
+		}
+	}
+	for model, providerTypes := range state.Catalog {
+		for _, providerType := range providerTypes {
+			if p, ok := f.providers[providerType]; ok {
+				f.modelProviders.add(model, p)
+			}
+		}
+	}
+	for providerType, limits := range state.ContextLimits {
+		if _, ok := f.providers[providerType]; ok {
+			f.contextLimits[providerType] = limits
+		}
+	}
+	f.logger.InfoLog("[Factory] Restored routing state from %s saved at %s: %d models, %d last successes, %d circuits",
+		path, state.SavedAt.Format(time.RFC3339), len(f.modelProviders), len(f.lastSuccess), len(f.circuits))
+	return len(f.modelProviders) > 0, nil
+}
+
+// SaveState writes the routing state to the state file if it changed since the last snapshot
+func (f *Factory) SaveState() error {
+	f.saveMu.Lock()
+	defer f.saveMu.Unlock()
+
+	f.mu.Lock()
+	if f.statePath == "" || !f.dirty {
+		f.mu.Unlock()
+		return nil
+	}
+	path := f.statePath
+	state := routingState{
+		SavedAt:       time.Now(),
+		LastSuccess:   maps.Clone(f.lastSuccess),
+		Circuits:      make(map[ProviderType]circuitState, len(f.circuits)),
+		Catalog:       make(map[string][]ProviderType, len(f.modelProviders)),
+		ContextLimits: maps.Clone(f.contextLimits), // Per-provider limits are replaced, never changed in place
+	}
+	for providerType, c := range f.circuits {
+		state.Circuits[providerType] = circuitState{Failures: c.failures, OpenedAt: c.openedAt, Cooldown: c.cooldown}
+	}
+	for model, providers := range f.modelProviders {
+		for _, p := range providers {
+			state.Catalog[model] = append(state.Catalog[model], p.Name())
+		}
+	}
+	f.dirty = false
+	f.mu.Unlock()
+
+	if err := writeState(path, state); err != nil {
+		f.mu.Lock()
+		f.dirty = true // Try again on the next snapshot
+		f.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// writeState encodes state and replaces the file at path with it
+func writeState(path string, state routingState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode routing state: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to create routing state directory: %w", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write routing state: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to write routing state: %w", err)
+	}
+	return nil
+}
+
+// SnapshotState writes the routing state to the state file whenever it changed, every
+// stateSnapshotInterval until ctx is done, and once more then. The returned channel is
+// closed after that last write.
+func (f *Factory) SnapshotState(ctx context.Context) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(stateSnapshotInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				if err := f.SaveState(); err != nil {
+					f.logger.ErrorLog("[Factory] Failed to save routing state: %v", err)
+				}
+				return
+			case <-ticker.C:
+				if err := f.SaveState(); err != nil {
+					f.logger.ErrorLog("[Factory] Failed to save routing state: %v", err)
+				}
+			}
+		}
+	}()
+	return done
+}
@@ -0,0 +1,85 @@
+package provider
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRoutingStateRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "routing_state.json")
+	f := newRoutingFactory(t)
+	if restored, err := f.RestoreState(path); err != nil || restored {
+		t.Fatalf("RestoreState() of a missing file = %v, %v; want nothing restored", restored, err)
+	}
+	f.RecordSuccess("shared-model", ProviderIFlow)
+	f.RecordFailure("shared-model", ProviderQwen, &APIError{StatusCode: http.StatusTooManyRequests, RetryAfter: time.Hour})
+	if err := f.SaveState(); err != nil {
+		t.Fatalf("SaveState() error = %v", err)
+	}
+
+	// A restarted proxy routes from the snapshot before discovering any models
+	restarted := NewFactory()
+	restarted.Register(&stubProvider{name: ProviderQwen})
+	restarted.Register(&stubProvider{name: ProviderIFlow})
+	restored, err := restarted.RestoreState(path)
+	if err != nil || !restored {
+		t.Fatalf("RestoreState() = %v, %v; want the catalog restored", restored, err)
+	}
+	if got := len(restarted.GetAllModels()["shared-model"]); got != 2 {
+		t.Errorf("restored catalog has %d providers for shared-model, want 2", got)
+	}
+	if got := restarted.CircuitState(ProviderQwen); got != CircuitOpen {
+		t.Errorf("CircuitState(qwen) = %v, want open for the restored Retry-After", got)
+	}
+	if p, err := restarted.GetByModel("shared-model"); err != nil || p.Name() != ProviderIFlow {
+		t.Errorf("GetByModel() = %v, %v; want the restored last success iflow", p, err)
+	}
+
+	// State of providers that are no longer registered is dropped
+	single := NewFactory()
+	single.Register(&stubProvider{name: ProviderQwen})
+	if _, err := single.RestoreState(path); err != nil {
+		t.Fatalf("RestoreState() error = %v", err)
+	}
+	if got := single.GetAllModels()["shared-model"]; len(got) != 1 || got[0] != ProviderQwen {
+		t.Errorf("restored catalog = %v, want only qwen", got)
+	}
+	if err := single.PopulateModelProviders(context.Background()); err != nil {
+		t.Fatalf("PopulateModelProviders() after restore error = %v", err)
+	}
+	if got := len(single.GetAllModels()["shared-model"]); got != 1 {
+		t.Errorf("catalog after discovery has %d providers for shared-model, want 1", got)
+	}
+}
+
+func TestSaveStateRetriesFailedWrite(t *testing.T) {
+	blocker := filepath.Join(t.TempDir(), "blocker")
+	path := filepath.Join(blocker, "routing_state.json")
+	f := newRoutingFactory(t)
+	if _, err := f.RestoreState(path); err != nil {
+		t.Fatalf("RestoreState() error = %v", err)
+	}
+	// A regular file where the state directory belongs makes the write fail
+	if err := os.WriteFile(blocker, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	f.RecordSuccess("shared-model", ProviderIFlow)
+	if err := f.SaveState(); err == nil {
+		t.Fatal("SaveState() under a regular file succeeded, want an error")
+	}
+
+	// The unsaved state is written by the next snapshot once the directory can be created
+	if err := os.Remove(blocker); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.SaveState(); err != nil {
+		t.Fatalf("SaveState() error = %v", err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("state file not written after a failed save: %v", err)
+	}
+}
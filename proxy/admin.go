@@ -0,0 +1,197 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sunbankio/qwencoder-proxy/apikey"
+	"github.com/sunbankio/qwencoder-proxy/logging"
+	"github.com/sunbankio/qwencoder-proxy/usage"
+)
+
+// RequireAdmin restricts a handler to keys marked as admin keys. When authentication
+// is disabled there are no keys, so only requests from the local machine are accepted.
+func RequireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := apikey.FromContext(r.Context())
+		switch {
+		case key != nil && key.Admin:
+		case key == nil && isLoopback(r.RemoteAddr):
+		case key == nil:
+			writeOpenAIError(w, newErrorInfo(kindPermission, "admin endpoints are only available from localhost unless API keys are configured"))
+			return
+		default:
+			writeOpenAIError(w, newErrorInfo(kindPermission, fmt.Sprintf("API key %s is not an admin key", key.Name())))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// isLoopback reports whether a remote address is on the local machine
+func isLoopback(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// RecordUsage adds a usage record to store for every generation request once it completes
+func RecordUsage(store *usage.Store, next http.Handler) http.Handler {
+	if store == nil {
+		return next
+	}
+	logger := logging.NewLogger("proxy")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scope, w, r := scoped(w, r)
+		next.ServeHTTP(w, r)
+		if scope.provider == nil {
+			return // Not a generation request
+		}
+
+		tokens := scope.meter.Usage()
+		record := usage.Record{
+			Time:             scope.start,
+			Provider:         string(scope.provider.Name()),
+			Model:            scope.model,
+			Account:          scope.account(),
+			Stream:           scope.meter.Streaming(),
+			PromptTokens:     tokens.Input,
+			CompletionTokens: tokens.Output,
+			CachedTokens:     tokens.Cached,
+			LatencyMs:        time.Since(scope.start).Milliseconds(),
+			Status:           scope.meter.Status(),
+			Error:            scope.meter.Failed(),
+		}
+		if scope.key != nil {
+			record.Key = scope.key.Name()
+		}
+		if err := store.Add(record); err != nil {
+			logger.WithContext(r.Context()).ErrorLog("[Usage] Failed to record usage: %v", err)
+		}
+	})
+}
+
+// UsageHandler serves usage reports at /admin/usage
+type UsageHandler struct {
+	store *usage.Store
+}
+
+// NewUsageHandler creates a handler reporting on the records in store
+func NewUsageHandler(store *usage.Store) *UsageHandler {
+	return &UsageHandler{store: store}
+}
+
+// usageReport is the JSON body of a usage report
+type usageReport struct {
+	From    time.Time   `json:"from"`
+	To      time.Time   `json:"to"`
+	GroupBy []string    `json:"group_by"`
+	Rows    []usage.Row `json:"rows"`
+}
+
+// ServeHTTP handles GET /admin/usage. The from, to, group_by, key, provider and model
+// parameters select and group records; format=csv, or an Accept header asking for
+// text/csv, returns CSV instead of JSON.
+func (h *UsageHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeOpenAIError(w, newErrorInfo(kindInvalidRequest, "Method not allowed").withStatus(http.StatusMethodNotAllowed))
+		return
+	}
+	query, err := usage.ParseQuery(r.URL.Query(), time.Now())
+	if err != nil {
+		writeOpenAIError(w, newErrorInfo(kindInvalidRequest, err.Error()))
+		return
+	}
+	rows, err := h.store.Report(query)
+	if err != nil {
+		writeOpenAIError(w, newErrorInfo(kindInternal, err.Error()))
+		return
+	}
+
+	if r.URL.Query().Get("format") == "csv" || strings.Contains(r.Header.Get("Accept"), "text/csv") {
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", `attachment; filename="usage.csv"`)
+		usage.WriteCSV(w, query.GroupBy, rows)
+		return
+	}
+	if query.GroupBy == nil {
+		query.GroupBy = []string{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(usageReport{From: query.From, To: query.To, GroupBy: query.GroupBy, Rows: rows})
+}
+
+// logLevelsUpdate is the body of PUT /admin/log-levels. An empty subsystem level makes
+// the subsystem follow the default again.
+type logLevelsUpdate struct {
+	Default    string            `json:"default"`
+	Subsystems map[string]string `json:"subsystems"`
+}
+
+// LogLevelsHandler serves GET and PUT /admin/log-levels to read and change log levels at runtime
+func LogLevelsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var update logLevelsUpdate
+		if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+			writeOpenAIError(w, newErrorInfo(kindInvalidRequest, fmt.Sprintf("Invalid request body: %v", err)))
+			return
+		}
+		// Validate everything before applying anything
+		var defaultLevel slog.Level
+		if update.Default != "" {
+			level, err := logging.ParseLevel(update.Default)
+			if err != nil {
+				writeOpenAIError(w, newErrorInfo(kindInvalidRequest, err.Error()))
+				return
+			}
+			defaultLevel = level
+		}
+		levels := make(map[string]slog.Level, len(update.Subsystems))
+		for subsystem, value := range update.Subsystems {
+			if value == "" {
+				continue
+			}
+			level, err := logging.ParseLevel(value)
+			if err != nil {
+				writeOpenAIError(w, newErrorInfo(kindInvalidRequest, fmt.Sprintf("subsystem %s: %v", subsystem, err)))
+				return
+			}
+			levels[subsystem] = level
+		}
+
+		if update.Default != "" {
+			logging.SetDefaultLevel(defaultLevel)
+		}
+		for subsystem, value := range update.Subsystems {
+			if value == "" {
+				logging.ResetLevel(subsystem)
+			} else {
+				logging.SetLevel(subsystem, levels[subsystem])
+			}
+		}
+		logging.NewLogger("proxy").WithContext(r.Context()).InfoLog("[Admin] Log levels changed: default %q, subsystems %v", update.Default, update.Subsystems)
+	default:
+		writeOpenAIError(w, newErrorInfo(kindInvalidRequest, "Method not allowed").withStatus(http.StatusMethodNotAllowed))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(logging.Levels())
+}
+
+// RegisterAdminRoutes registers the admin endpoints, which require an admin key
+func RegisterAdminRoutes(mux *http.ServeMux, store *usage.Store) {
+	if store != nil {
+		mux.Handle("/admin/usage", RequireAdmin(NewUsageHandler(store)))
+	}
+	mux.Handle("/admin/log-levels", RequireAdmin(http.HandlerFunc(LogLevelsHandler)))
+}
@@ -0,0 +1,138 @@
+package proxy
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sunbankio/qwencoder-proxy/apikey"
+	"github.com/sunbankio/qwencoder-proxy/config"
+	"github.com/sunbankio/qwencoder-proxy/converter"
+	"github.com/sunbankio/qwencoder-proxy/logging"
+	"github.com/sunbankio/qwencoder-proxy/provider"
+	"github.com/sunbankio/qwencoder-proxy/usage"
+)
+
+func TestRequireAdmin(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) })
+	tests := []struct {
+		name       string
+		key        *apikey.Key
+		remoteAddr string
+		want       int
+	}{
+		{"admin key", &apikey.Key{Hash: apikey.Hash("a"), Admin: true}, "203.0.113.5:4000", http.StatusNoContent},
+		{"client key", &apikey.Key{Hash: apikey.Hash("b"), Label: "intern"}, "127.0.0.1:4000", http.StatusForbidden},
+		{"no auth from localhost", nil, "[::1]:4000", http.StatusNoContent},
+		{"no auth from the network", nil, "203.0.113.5:4000", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/admin/usage", nil)
+		req.RemoteAddr = tt.remoteAddr
+		if tt.key != nil {
+			req = req.WithContext(apikey.WithKey(req.Context(), tt.key))
+		}
+		rec := httptest.NewRecorder()
+		RequireAdmin(ok).ServeHTTP(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.name, rec.Code, tt.want)
+		}
+	}
+}
+
+func TestRecordUsage(t *testing.T) {
+	withStreamingConfig(t, config.StreamingConfig{})
+	store, err := usage.Open(t.TempDir(), 0)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer store.Close()
+
+	factory := provider.NewFactory()
+	factory.Register(newIFlowFake(true))
+	mux := http.NewServeMux()
+	mux.Handle("/iflow/v1/", NewProviderSpecificHandler(factory, converter.NewFactory(), provider.ProviderIFlow))
+	RegisterAdminRoutes(mux, store)
+	key := &apikey.Key{Hash: apikey.Hash("sk-ops"), Label: "ops", Admin: true}
+	handler := RequireAPIKey(apikey.NewStore(key), RecordUsage(store, mux))
+
+	send := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer sk-ops")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+	for _, stream := range []string{"true", "false"} {
+		if rec := send(http.MethodPost, "/iflow/v1/chat/completions", `{"model":"fake-model","stream":`+stream+`,"messages":[{"role":"user","content":"hi"}]}`); rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, body = %s", rec.Code, rec.Body.String())
+		}
+	}
+	send(http.MethodGet, "/iflow/v1/models", "") // Not a generation request
+
+	rec := send(http.MethodGet, "/admin/usage?group_by=key,provider,model", "")
+	var report usageReport
+	if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+		t.Fatalf("invalid report %s: %v", rec.Body.String(), err)
+	}
+	if len(report.Rows) != 1 {
+		t.Fatalf("rows = %+v", report.Rows)
+	}
+	row := report.Rows[0]
+	if row.Group["key"] != "ops" || row.Group["provider"] != "iflow" || row.Group["model"] != "fake-model" || row.Requests != 2 || row.Errors != 0 {
+		t.Errorf("row = %+v", row)
+	}
+	// The stream reports usage; the unary response has its usage estimated
+	if row.PromptTokens < 3 || row.CompletionTokens < 2 {
+		t.Errorf("tokens = %d prompt, %d completion", row.PromptTokens, row.CompletionTokens)
+	}
+
+	rec = send(http.MethodGet, "/admin/usage?format=csv&group_by=model", "")
+	if rec.Header().Get("Content-Type") != "text/csv" || !strings.HasPrefix(rec.Body.String(), "model,requests,") || !strings.Contains(rec.Body.String(), "\nfake-model,2,0,") {
+		t.Errorf("CSV report = %q", rec.Body.String())
+	}
+	if rec := send(http.MethodGet, "/admin/usage?group_by=colour", ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid group_by: status = %d", rec.Code)
+	}
+}
+
+func TestLogLevelsHandler(t *testing.T) {
+	logging.NewLogger("kiro")
+	defer func() {
+		logging.SetDefaultLevel(slog.LevelInfo)
+		logging.ResetLevel("kiro")
+	}()
+
+	send := func(method, body string) (*httptest.ResponseRecorder, logging.LevelSettings) {
+		req := httptest.NewRequest(method, "/admin/log-levels", strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		LogLevelsHandler(rec, req)
+		var settings logging.LevelSettings
+		json.Unmarshal(rec.Body.Bytes(), &settings)
+		return rec, settings
+	}
+
+	rec, settings := send(http.MethodPut, `{"default": "warn", "subsystems": {"kiro": "debug"}}`)
+	if rec.Code != http.StatusOK || settings.Default != "warn" || settings.Subsystems["kiro"] != "debug" {
+		t.Fatalf("PUT = %d %+v", rec.Code, settings)
+	}
+
+	// An invalid level rejects the whole update
+	if rec, _ := send(http.MethodPut, `{"default": "error", "subsystems": {"kiro": "loud"}}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("PUT with an invalid level = %d, want 400", rec.Code)
+	}
+	if _, settings := send(http.MethodGet, ""); settings.Default != "warn" {
+		t.Errorf("default level after a rejected update = %q, want warn", settings.Default)
+	}
+
+	// An empty level makes the subsystem follow the default again
+	if _, settings := send(http.MethodPut, `{"subsystems": {"kiro": ""}}`); settings.Subsystems["kiro"] != "warn" || len(settings.Overrides) != 0 {
+		t.Errorf("after reset = %+v", settings)
+	}
+	if rec, _ := send(http.MethodDelete, ""); rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("DELETE = %d, want 405", rec.Code)
+	}
+}
@@ -0,0 +1,253 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/sunbankio/qwencoder-proxy/converter"
+	"github.com/sunbankio/qwencoder-proxy/logging"
+	"github.com/sunbankio/qwencoder-proxy/metrics"
+	"github.com/sunbankio/qwencoder-proxy/openai"
+	"github.com/sunbankio/qwencoder-proxy/provider"
+	"github.com/sunbankio/qwencoder-proxy/provider/kiro"
+	"github.com/sunbankio/qwencoder-proxy/sse"
+)
+
+// chunkStream yields a response as OpenAI stream chunks, whatever protocol the upstream speaks
+type chunkStream interface {
+	// NextChunk returns the next chunk, or io.EOF when the response is complete
+	NextChunk() (*openai.ChatCompletionChunk, error)
+	Close() error
+}
+
+// simulatedStream replays a unary response as stream chunks
+type simulatedStream struct {
+	chunks []openai.ChatCompletionChunk
+}
+
+func (s *simulatedStream) NextChunk() (*openai.ChatCompletionChunk, error) {
+	if len(s.chunks) == 0 {
+		return nil, io.EOF
+	}
+	chunk := &s.chunks[0]
+	s.chunks = s.chunks[1:]
+	return chunk, nil
+}
+
+func (s *simulatedStream) Close() error {
+	return nil
+}
+
+// providerListed reports whether a provider appears in a configured list, by name or by
+// type so that a type also covers its additional accounts; "*" matches every provider
+func providerListed(list []string, p provider.Provider) bool {
+	for _, name := range list {
+		if name == "*" || provider.ProviderType(name) == p.Name() || provider.ProviderType(name) == provider.TypeOf(p) {
+			return true
+		}
+	}
+	return false
+}
+
+// aggregates reports whether non-streaming requests to p are served over an upstream stream
+func aggregates(p provider.Provider) bool {
+	return providerListed(streamingConfig.AggregateProviders, p)
+}
+
+// simulatesStreaming reports whether streaming requests to p are served from a unary call
+func simulatesStreaming(p provider.Provider) bool {
+	return providerListed(streamingConfig.SimulateProviders, p)
+}
+
+// streamProtocol returns the protocol of the events in p's stream.
+// Kiro and OpenAI-compatible providers already stream OpenAI chunks.
+func streamProtocol(p provider.Provider) provider.ProtocolType {
+	if needsStreamConversion(p.Protocol()) {
+		return p.Protocol()
+	}
+	return provider.ProtocolOpenAI
+}
+
+// withStreamFlag returns a copy of a native request asking for a streaming or unary response,
+// for upstreams that choose between them from the request body
+func withStreamFlag(nativeReq interface{}, stream bool) interface{} {
+	switch req := nativeReq.(type) {
+	case *openai.ChatCompletionRequest:
+		copied := *req
+		copied.Stream = stream
+		copied.StreamOptions = nil
+		if stream {
+			// Usage only arrives on a stream when asked for
+			copied.StreamOptions = &openai.StreamOptions{IncludeUsage: true}
+		}
+		return &copied
+	case *kiro.ClaudeRequest:
+		copied := *req
+		copied.Stream = stream
+		return &copied
+	default:
+		return nativeReq
+	}
+}
+
+// simulatedChunks calls p once and replays its response as stream chunks
+func simulatedChunks(ctx context.Context, convFactory *converter.Factory, p provider.Provider, nativeReq interface{}, model string) (chunkStream, error) {
+	conv, err := convFactory.Get(p.Protocol())
+	if err != nil {
+		return nil, err
+	}
+	resp, err := GenerateAndConvert(ctx, p, conv, withStreamFlag(nativeReq, false), model)
+	if err != nil {
+		return nil, err
+	}
+	return &simulatedStream{chunks: openai.ResponseChunks(resp)}, nil
+}
+
+// openChunkStream starts generation on p and returns the response as OpenAI chunks.
+// Providers configured for simulated streaming, or that report ErrStreamingUnsupported,
+// are called once and their response is replayed.
+func openChunkStream(ctx context.Context, convFactory *converter.Factory, p provider.Provider, nativeReq interface{}, model string, logger *logging.Logger) (chunkStream, error) {
+	if simulatesStreaming(p) {
+		logger.DebugLog("[Stream] Simulating stream for provider %s with model %s", p.Name(), model)
+		return simulatedChunks(ctx, convFactory, p, nativeReq, model)
+	}
+
+	conv, err := convFactory.Get(streamProtocol(p))
+	if err != nil {
+		return nil, err
+	}
+	stream, err := p.GenerateContentStream(ctx, model, withStreamFlag(nativeReq, true))
+	if errors.Is(err, provider.ErrStreamingUnsupported) {
+		logger.DebugLog("[Stream] Provider %s cannot stream, simulating from a unary response", p.Name())
+		metrics.Retries.Inc(string(p.Name()), metrics.RetryUnaryFallback)
+		return simulatedChunks(ctx, convFactory, p, nativeReq, model)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return NewStreamConverter(stream, conv, model, logger), nil
+}
+
+// aggregateResponse streams the response from p and assembles it into a complete OpenAI response
+func aggregateResponse(ctx context.Context, convFactory *converter.Factory, p provider.Provider, nativeReq interface{}, model string, logger *logging.Logger) (*openai.ChatCompletionResponse, error) {
+	stream, err := openChunkStream(ctx, convFactory, p, nativeReq, model, logger)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.Close()
+
+	acc := openai.NewAccumulator()
+	for {
+		chunk, err := stream.NextChunk()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		acc.Add(chunk)
+	}
+
+	resp := acc.Response()
+	if resp.Model == "" {
+		resp.Model = model
+	}
+	logger.DebugLog("[Stream] Aggregated streamed response from provider %s with model %s", p.Name(), model)
+	return resp, nil
+}
+
+// generateResponse returns a complete OpenAI response from p, assembling it from an upstream
+// stream when p is configured for aggregation
+func generateResponse(ctx context.Context, convFactory *converter.Factory, p provider.Provider, nativeReq interface{}, model string, logger *logging.Logger) (*openai.ChatCompletionResponse, error) {
+	if aggregates(p) {
+		return aggregateResponse(ctx, convFactory, p, nativeReq, model, logger)
+	}
+	conv, err := convFactory.Get(p.Protocol())
+	if err != nil {
+		return nil, fmt.Errorf("failed to get converter for protocol %s: %w", p.Protocol(), err)
+	}
+	return GenerateAndConvert(ctx, p, conv, nativeReq, model)
+}
+
+// encodedStream renders a chunk stream in a client protocol's streaming format
+type encodedStream struct {
+	chunks  chunkStream
+	encoder converter.StreamEncoder
+	pending []byte
+	done    bool
+}
+
+// Read implements io.Reader interface
+func (s *encodedStream) Read(p []byte) (int, error) {
+	for len(s.pending) == 0 {
+		if s.done {
+			return 0, io.EOF
+		}
+
+		var events []sse.Event
+		chunk, err := s.chunks.NextChunk()
+		switch {
+		case err == io.EOF:
+			s.done = true
+			events, err = s.encoder.Finish()
+		case err == nil:
+			events, err = s.encoder.Encode(chunk)
+		}
+		if err != nil {
+			return 0, err
+		}
+		for i := range events {
+			s.pending = sse.AppendEvent(s.pending, &events[i])
+		}
+	}
+
+	n := copy(p, s.pending)
+	s.pending = s.pending[n:]
+	return n, nil
+}
+
+// Close implements io.Closer interface
+func (s *encodedStream) Close() error {
+	return s.chunks.Close()
+}
+
+// relayChunks streams chunks to the client in the streaming format of the client's protocol
+func relayChunks(ctx context.Context, w http.ResponseWriter, convFactory *converter.Factory, clientProtocol provider.ProtocolType, chunks chunkStream, model string, logger *logging.Logger) error {
+	encoder, err := convFactory.NewStreamEncoder(clientProtocol, model)
+	if err != nil {
+		chunks.Close()
+		return err
+	}
+	SetStreamingHeaders(w)
+	return copyTracedStream(ctx, w, &encodedStream{chunks: chunks, encoder: encoder}, model, logger)
+}
+
+// aggregateAs assembles the streamed response from p and converts it to the client's protocol
+func aggregateAs(ctx context.Context, convFactory *converter.Factory, clientProtocol provider.ProtocolType, p provider.Provider, nativeReq interface{}, model string, logger *logging.Logger) (interface{}, error) {
+	resp, err := aggregateResponse(ctx, convFactory, p, nativeReq, model, logger)
+	if err != nil {
+		return nil, err
+	}
+	clientConv, err := convFactory.Get(clientProtocol)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get converter for protocol %s: %w", clientProtocol, err)
+	}
+	return clientConv.FromOpenAIResponse(resp)
+}
+
+// SimulatedStreamResponse calls the provider once and replays its response to the client as an OpenAI stream
+func SimulatedStreamResponse(w http.ResponseWriter, r *http.Request, factory *provider.Factory, convFactory *converter.Factory, p provider.Provider, nativeReq interface{}, model string, logger *logging.Logger) error {
+	chunks, err := simulatedChunks(r.Context(), convFactory, p, nativeReq, model)
+	if err != nil {
+		return err
+	}
+
+	// Record success for routing
+	factory.RecordSuccess(model, p.Name())
+	logger.DebugLog("[Stream] Recorded success for simulated stream from provider %s with model %s", p.Name(), model)
+
+	return relayChunks(r.Context(), w, convFactory, provider.ProtocolOpenAI, chunks, model, logger)
+}
@@ -0,0 +1,174 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sunbankio/qwencoder-proxy/config"
+	"github.com/sunbankio/qwencoder-proxy/converter"
+	"github.com/sunbankio/qwencoder-proxy/logging"
+	"github.com/sunbankio/qwencoder-proxy/openai"
+	"github.com/sunbankio/qwencoder-proxy/provider"
+	"github.com/sunbankio/qwencoder-proxy/sse"
+)
+
+// openAIStreamBody is an OpenAI-compatible upstream stream answering "hello world"
+const openAIStreamBody = `data: {"id":"chatcmpl-1","model":"fake-model","choices":[{"index":0,"delta":{"role":"assistant","content":"hello "}}]}` + "\n\n" +
+	`data: {"id":"chatcmpl-1","model":"fake-model","choices":[{"index":0,"delta":{"content":"world"}}]}` + "\n\n" +
+	`data: {"id":"chatcmpl-1","model":"fake-model","choices":[{"index":0,"delta":{},"finish_reason":"stop"}],"usage":{"prompt_tokens":3,"completion_tokens":2,"total_tokens":5}}` + "\n\n" +
+	"data: [DONE]\n\n"
+
+func newIFlowFake(stream bool) *fakeProvider {
+	fake := &fakeProvider{
+		name:     provider.ProviderIFlow,
+		protocol: provider.ProtocolOpenAI,
+		response: &openai.ChatCompletionResponse{
+			ID:      "chatcmpl-2",
+			Model:   "fake-model",
+			Choices: []openai.Choice{{Message: openai.Message{Role: "assistant", Content: openai.TextContent("hello world")}, FinishReason: "stop"}},
+		},
+	}
+	if stream {
+		fake.stream = func() io.ReadCloser { return io.NopCloser(strings.NewReader(openAIStreamBody)) }
+	}
+	return fake
+}
+
+func readEvents(t *testing.T, body string) []sse.Event {
+	t.Helper()
+	var events []sse.Event
+	reader := sse.NewReader(strings.NewReader(body), 0)
+	for {
+		ev, err := reader.Next()
+		if err == io.EOF {
+			return events
+		}
+		if err != nil {
+			t.Fatalf("Next() error = %v", err)
+		}
+		events = append(events, *ev)
+	}
+}
+
+func TestOpenAIHandlerAggregatesStream(t *testing.T) {
+	withStreamingConfig(t, config.StreamingConfig{AggregateProviders: []string{"iflow"}})
+	fake := newIFlowFake(true)
+	fake.response = nil // Only the stream may be used
+	factory := provider.NewFactory()
+	factory.Register(fake)
+	handler := NewProviderSpecificHandler(factory, converter.NewFactory(), provider.ProviderIFlow)
+
+	body := `{"model":"fake-model","messages":[{"role":"user","content":"hi"}]}`
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/iflow/v1/chat/completions", strings.NewReader(body)))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	var resp openai.ChatCompletionResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("invalid response %s: %v", rec.Body.String(), err)
+	}
+	if len(resp.Choices) != 1 || resp.Choices[0].Message.Content.String() != "hello world" || resp.Choices[0].FinishReason != "stop" {
+		t.Errorf("choices = %+v", resp.Choices)
+	}
+	if resp.Usage == nil || resp.Usage.TotalTokens != 5 {
+		t.Errorf("usage = %+v", resp.Usage)
+	}
+}
+
+func TestOpenAIHandlerSimulatesStream(t *testing.T) {
+	tests := []struct {
+		name     string
+		simulate []string
+		stream   bool
+	}{
+		{name: "configured", simulate: []string{"*"}, stream: true},
+		{name: "upstream cannot stream", stream: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withStreamingConfig(t, config.StreamingConfig{SimulateProviders: tt.simulate})
+			factory := provider.NewFactory()
+			factory.Register(newIFlowFake(tt.stream))
+			handler := NewProviderSpecificHandler(factory, converter.NewFactory(), provider.ProviderIFlow)
+
+			body := `{"model":"fake-model","stream":true,"messages":[{"role":"user","content":"hi"}]}`
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/iflow/v1/chat/completions", strings.NewReader(body)))
+
+			events := readEvents(t, rec.Body.String())
+			if len(events) == 0 || events[len(events)-1].Data != "[DONE]" {
+				t.Fatalf("stream not terminated: %s", rec.Body.String())
+			}
+			acc := openai.NewAccumulator()
+			for _, ev := range events[:len(events)-1] {
+				var chunk openai.ChatCompletionChunk
+				if err := json.Unmarshal([]byte(ev.Data), &chunk); err != nil {
+					t.Fatalf("invalid chunk %s: %v", ev.Data, err)
+				}
+				acc.Add(&chunk)
+			}
+			if got := acc.Response().Choices[0].Message.Content.String(); got != "hello world" {
+				t.Errorf("content = %q, want hello world", got)
+			}
+		})
+	}
+}
+
+func TestAnthropicHandlerStreamsTranslatedModel(t *testing.T) {
+	withStreamingConfig(t, config.DefaultConfig().Streaming)
+	factory := provider.NewFactory()
+	factory.Register(newIFlowFake(true))
+	if err := factory.PopulateModelProviders(context.Background()); err != nil {
+		t.Fatalf("PopulateModelProviders() error = %v", err)
+	}
+	handler := NewAnthropicHandler(factory, converter.NewFactory())
+
+	body := `{"model":"fake-model","max_tokens":100,"stream":true,"messages":[{"role":"user","content":"hi"}]}`
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/anthropic/messages", strings.NewReader(body)))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	events := readEvents(t, rec.Body.String())
+	var types []string
+	var text strings.Builder
+	for _, ev := range events {
+		types = append(types, ev.Type)
+		var delta struct {
+			Delta struct {
+				Text string `json:"text"`
+			} `json:"delta"`
+		}
+		if ev.Type == "content_block_delta" && json.Unmarshal([]byte(ev.Data), &delta) == nil {
+			text.WriteString(delta.Delta.Text)
+		}
+	}
+	if len(types) < 2 || types[0] != "message_start" || types[len(types)-1] != "message_stop" {
+		t.Errorf("event types = %v", types)
+	}
+	if text.String() != "hello world" {
+		t.Errorf("streamed text = %q, want hello world", text.String())
+	}
+}
+
+func TestAggregateAsGemini(t *testing.T) {
+	fake := newIFlowFake(true)
+	req := &openai.ChatCompletionRequest{Model: "fake-model"}
+	resp, err := aggregateAs(context.Background(), converter.NewFactory(), provider.ProtocolGemini, fake, req, "fake-model", logging.NewLogger("proxy"))
+	if err != nil {
+		t.Fatalf("aggregateAs() error = %v", err)
+	}
+	out, _ := json.Marshal(resp)
+	if !strings.Contains(string(out), `"text":"hello world"`) || !strings.Contains(string(out), `"totalTokenCount":5`) {
+		t.Errorf("gemini response = %s", out)
+	}
+}
@@ -3,25 +3,32 @@ package proxy
 
 import (
 	"encoding/json"
+	"fmt"
+	"maps"
 	"net/http"
+	"slices"
 	"strings"
 
+	"github.com/sunbankio/qwencoder-proxy/converter"
 	"github.com/sunbankio/qwencoder-proxy/logging"
 	"github.com/sunbankio/qwencoder-proxy/provider"
 	"github.com/sunbankio/qwencoder-proxy/provider/kiro"
 )
 
-// AnthropicHandler handles requests to /anthropic/* routes
+// AnthropicHandler handles requests to /anthropic/* routes. Each request is served by the
+// provider the factory picks for its model, translated when it speaks another protocol.
 type AnthropicHandler struct {
-	provider *kiro.Provider
-	logger   *logging.Logger
+	factory     *provider.Factory
+	convFactory *converter.Factory
+	logger      *logging.Logger
 }
 
 // NewAnthropicHandler creates a new Anthropic route handler
-func NewAnthropicHandler(p *kiro.Provider) *AnthropicHandler {
+func NewAnthropicHandler(factory *provider.Factory, convFactory *converter.Factory) *AnthropicHandler {
 	return &AnthropicHandler{
-		provider: p,
-		logger:   logging.NewLogger(),
+		factory:     factory,
+		convFactory: convFactory,
+		logger:      logging.NewLogger("proxy"),
 	}
 }
 
@@ -30,7 +37,7 @@ func (h *AnthropicHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// Set CORS headers
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key")
 
 	if r.Method == http.MethodOptions {
 		w.WriteHeader(http.StatusOK)
@@ -41,7 +48,7 @@ func (h *AnthropicHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	path := strings.TrimPrefix(r.URL.Path, "/anthropic")
 	path = strings.TrimPrefix(path, "/")
 
-	h.logger.DebugLog("[Anthropic Handler] Request: %s %s", r.Method, path)
+	h.logger.WithContext(r.Context()).DebugLog("[Anthropic Handler] Request: %s %s", r.Method, path)
 
 	switch {
 	case path == "models" && r.Method == http.MethodGet:
@@ -49,39 +56,46 @@ func (h *AnthropicHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	case path == "messages" && r.Method == http.MethodPost:
 		h.handleMessages(w, r)
 	default:
-		http.Error(w, "Not found", http.StatusNotFound)
+		writeAnthropicError(w, newErrorInfo(kindNotFound, fmt.Sprintf("Unsupported Anthropic endpoint: %s", path)))
 	}
 }
 
-// handleListModels handles GET /anthropic/models
+// handleListModels handles GET /anthropic/models, listing every model in the catalog
 func (h *AnthropicHandler) handleListModels(w http.ResponseWriter, r *http.Request) {
-	ctx := r.Context()
-
-	models, err := h.provider.ListModels(ctx)
-	if err != nil {
-		h.logger.ErrorLog("[Anthropic Handler] Failed to list models: %v", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+	logger := h.logger.WithContext(r.Context())
+	models := &kiro.ClaudeModelsResponse{Data: []kiro.ClaudeModel{}}
+	for _, model := range slices.Sorted(maps.Keys(h.factory.GetAllModels())) {
+		models.Data = append(models.Data, kiro.ClaudeModel{ID: model, DisplayName: model})
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(models); err != nil {
-		h.logger.ErrorLog("[Anthropic Handler] Failed to encode response: %v", err)
+		logger.ErrorLog("[Anthropic Handler] Failed to encode response: %v", err)
 	}
 }
 
 // handleMessages handles POST /anthropic/messages
 func (h *AnthropicHandler) handleMessages(w http.ResponseWriter, r *http.Request) {
+	logger := h.logger.WithContext(r.Context())
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeAnthropicError(w, newErrorInfo(kindInvalidRequest, "Method not allowed").withStatus(http.StatusMethodNotAllowed))
 		return
 	}
 
 	// Parse request body
 	var request kiro.ClaudeRequest
 	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
-		h.logger.ErrorLog("[Anthropic Handler] Failed to decode request: %v", err)
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		logger.ErrorLog("[Anthropic Handler] Failed to decode request: %v", err)
+		writeAnthropicError(w, newErrorInfo(kindInvalidRequest, fmt.Sprintf("Invalid request body: %v", err)))
+		return
+	}
+	request.Model = h.factory.ResolveModel(request.Model)
+
+	p, translator, nativeReq, ok := h.route(w, &request, logger)
+	if !ok {
+		return
+	}
+	if !admit(w, r, p, request.Model) {
 		return
 	}
 
@@ -89,60 +103,94 @@ func (h *AnthropicHandler) handleMessages(w http.ResponseWriter, r *http.Request
 	isStreaming := request.Stream
 
 	if isStreaming {
-		h.handleStreamMessages(w, r, &request)
+		h.handleStreamMessages(w, r, p, &request, nativeReq, request.Model)
 	} else {
-		h.handleNonStreamMessages(w, r, &request)
+		h.handleNonStreamMessages(w, r, p, translator, &request, nativeReq, request.Model)
+	}
+}
+
+// route picks the provider serving the model under the routing rules, and the request to
+// send it. Requests for providers that do not speak the Claude protocol are translated.
+// It writes the error response and returns false when no provider can take the request.
+func (h *AnthropicHandler) route(w http.ResponseWriter, request *kiro.ClaudeRequest, logger *logging.Logger) (provider.Provider, converter.Translator, interface{}, bool) {
+	p, err := h.factory.GetByModel(request.Model)
+	if err != nil {
+		logger.WarningLog("[Anthropic Handler] Failed to get provider: %v", err)
+		writeAnthropicError(w, routingError(request.Model, err))
+		return nil, nil, nil, false
 	}
+	translator, err := h.convFactory.GetTranslator(provider.ProtocolClaude, p.Protocol())
+	if err != nil {
+		logger.ErrorLog("[Anthropic Handler] No translator for %s: %v", p.Protocol(), err)
+		writeAnthropicError(w, classifyError(err))
+		return nil, nil, nil, false
+	}
+	nativeReq, err := translator.TranslateRequest(request)
+	if err != nil {
+		writeAnthropicError(w, newErrorInfo(kindInvalidRequest, fmt.Sprintf("Failed to translate request: %v", err)))
+		return nil, nil, nil, false
+	}
+	logger.DebugLog("[Anthropic Handler] Using provider %s for model %s", p.Name(), request.Model)
+	return p, translator, nativeReq, true
 }
 
 // handleNonStreamMessages handles non-streaming messages
-func (h *AnthropicHandler) handleNonStreamMessages(w http.ResponseWriter, r *http.Request, request *kiro.ClaudeRequest) {
+func (h *AnthropicHandler) handleNonStreamMessages(w http.ResponseWriter, r *http.Request, p provider.Provider, translator converter.Translator, request *kiro.ClaudeRequest, nativeReq interface{}, model string) {
+	logger := h.logger.WithContext(r.Context())
 	ctx := r.Context()
-	response, err := h.provider.GenerateContent(ctx, request.Model, request)
+	var response interface{}
+	var err error
+	if aggregates(p) {
+		response, err = aggregateAs(ctx, h.convFactory, provider.ProtocolClaude, p, nativeReq, model, logger)
+	} else {
+		response, err = p.GenerateContent(ctx, model, nativeReq)
+		if err == nil {
+			response, err = translator.TranslateResponse(response, model)
+		}
+	}
 	if err != nil {
-		h.logger.ErrorLog("[Anthropic Handler] GenerateContent failed: %v", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		logger.ErrorLog("[Anthropic Handler] GenerateContent failed with %s: %v", p.Name(), err)
+		h.factory.RecordFailure(model, p.Name(), err)
+		writeAnthropicError(w, classifyError(err))
 		return
 	}
+	h.factory.RecordSuccess(model, p.Name())
+	fillClientUsage(h.convFactory, provider.ProtocolClaude, clientRequest(h.convFactory, provider.ProtocolClaude, request, model, logger), response, logger)
 
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(response); err != nil {
-		h.logger.ErrorLog("[Anthropic Handler] Failed to encode response: %v", err)
+		logger.ErrorLog("[Anthropic Handler] Failed to encode response: %v", err)
 	}
 }
 
-// handleStreamMessages handles streaming messages
-func (h *AnthropicHandler) handleStreamMessages(w http.ResponseWriter, r *http.Request, request *kiro.ClaudeRequest) {
+// handleStreamMessages streams the response from p as Anthropic message events
+func (h *AnthropicHandler) handleStreamMessages(w http.ResponseWriter, r *http.Request, p provider.Provider, request *kiro.ClaudeRequest, nativeReq interface{}, model string) {
+	logger := h.logger.WithContext(r.Context())
 	ctx := r.Context()
-	stream, err := h.provider.GenerateContentStream(ctx, request.Model, request)
+	chunks, err := openChunkStream(ctx, h.convFactory, p, nativeReq, model, logger)
 	if err != nil {
-		h.logger.ErrorLog("[Anthropic Handler] GenerateContentStream failed: %v", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		logger.ErrorLog("[Anthropic Handler] GenerateContentStream failed with %s: %v", p.Name(), err)
+		h.factory.RecordFailure(model, p.Name(), err)
+		writeAnthropicError(w, classifyError(err))
 		return
 	}
-	defer stream.Close()
-
-	// Set streaming headers
-	SetStreamingHeaders(w)
-
-	if err := CopyStreamToResponse(w, stream, h.logger); err != nil {
-		h.logger.ErrorLog("[Anthropic Handler] Stream error: %v", err)
+	h.factory.RecordSuccess(model, p.Name())
+
+	chunks = fillStreamUsage(chunks, clientRequest(h.convFactory, provider.ProtocolClaude, request, model, logger), logger)
+
+	tracker := &responseTracker{ResponseWriter: w}
+	if err := relayChunks(ctx, tracker, h.convFactory, provider.ProtocolClaude, chunks, model, logger); err != nil {
+		logger.ErrorLog("[Anthropic Handler] Stream error: %v", err)
+		h.factory.RecordFailure(model, p.Name(), err)
+		if !tracker.started {
+			writeAnthropicError(w, classifyError(err))
+		} else if ctx.Err() == nil {
+			writeAnthropicStreamError(w, classifyError(err))
+		}
 	}
 }
 
 // RegisterAnthropicRoutes registers Anthropic routes with the given provider factory
-func RegisterAnthropicRoutes(mux *http.ServeMux, factory *provider.Factory) error {
-	p, err := factory.Get(provider.ProviderKiro)
-	if err != nil {
-		return err
-	}
-
-	kiroProvider, ok := p.(*kiro.Provider)
-	if !ok {
-		return nil // Provider not available or wrong type
-	}
-
-	handler := NewAnthropicHandler(kiroProvider)
-	mux.Handle("/anthropic/", handler)
-	return nil
+func RegisterAnthropicRoutes(mux *http.ServeMux, factory *provider.Factory, convFactory *converter.Factory) {
+	mux.Handle("/anthropic/", NewAnthropicHandler(factory, convFactory))
 }
@@ -0,0 +1,41 @@
+package proxy
+
+import (
+	"net/http"
+
+	"github.com/sunbankio/qwencoder-proxy/audit"
+	"github.com/sunbankio/qwencoder-proxy/logging"
+)
+
+// RecordAudit writes an audit record for each sampled generation request once it
+// completes. Upstream calls are added by the audit transport of the provider clients.
+func RecordAudit(recorder *audit.Recorder, next http.Handler) http.Handler {
+	if recorder == nil {
+		return next
+	}
+	logger := logging.NewLogger("proxy")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		exchange, r := recorder.Begin(r)
+		if exchange == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		scope, w, r := scoped(w, r)
+		next.ServeHTTP(exchange.WrapResponse(w), r)
+		if scope.provider == nil {
+			return // Not a generation request
+		}
+
+		meta := audit.Meta{
+			RequestID: logging.RequestID(r.Context()),
+			Provider:  string(scope.provider.Name()),
+			Model:     scope.model,
+		}
+		if scope.key != nil {
+			meta.Key = scope.key.Name()
+		}
+		if err := exchange.Finish(meta); err != nil {
+			logger.WithContext(r.Context()).ErrorLog("[Audit] Failed to record exchange: %v", err)
+		}
+	})
+}
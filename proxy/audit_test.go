@@ -0,0 +1,68 @@
+package proxy
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/sunbankio/qwencoder-proxy/audit"
+	"github.com/sunbankio/qwencoder-proxy/config"
+	"github.com/sunbankio/qwencoder-proxy/converter"
+	"github.com/sunbankio/qwencoder-proxy/provider"
+)
+
+func TestRecordAudit(t *testing.T) {
+	withStreamingConfig(t, config.StreamingConfig{})
+	dir := t.TempDir()
+	recorder, err := audit.Open(config.AuditConfig{Dir: dir, SampleRate: 1, MaxBodyBytes: 1 << 20, RedactPrompts: true})
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer recorder.Close()
+
+	factory := provider.NewFactory()
+	factory.Register(newIFlowFake(true))
+	mux := http.NewServeMux()
+	mux.Handle("/iflow/v1/", NewProviderSpecificHandler(factory, converter.NewFactory(), provider.ProviderIFlow))
+	handler := AssignRequestIDs(RecordAudit(recorder, mux))
+
+	req := httptest.NewRequest(http.MethodPost, "/iflow/v1/chat/completions", strings.NewReader(`{"model":"fake-model","messages":[{"role":"user","content":"secret plans"}]}`))
+	req.Header.Set(RequestIDHeader, "audit-test")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/iflow/v1/models", nil)) // Not a generation request
+
+	files, _ := audit.Files(dir)
+	if len(files) != 1 {
+		t.Fatalf("audit files = %v", files)
+	}
+	file, _ := os.Open(files[0])
+	defer file.Close()
+	var records []audit.Record
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var record audit.Record
+		json.Unmarshal(scanner.Bytes(), &record)
+		records = append(records, record)
+	}
+	if len(records) != 1 {
+		t.Fatalf("recorded %d exchanges, want 1", len(records))
+	}
+	record := records[0]
+	if record.RequestID != "audit-test" || record.Provider != "iflow" || record.Model != "fake-model" || !record.PromptsRedacted {
+		t.Errorf("record = %+v", record)
+	}
+	if strings.Contains(string(record.Request.Body), "secret plans") {
+		t.Errorf("prompt recorded despite redaction: %s", record.Request.Body)
+	}
+	if record.Response.Status != http.StatusOK || !strings.Contains(string(record.Response.Body), `"choices"`) {
+		t.Errorf("response = %+v", record.Response)
+	}
+}
@@ -0,0 +1,67 @@
+package proxy
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sunbankio/qwencoder-proxy/apikey"
+	"github.com/sunbankio/qwencoder-proxy/logging"
+	"github.com/sunbankio/qwencoder-proxy/provider"
+)
+
+// RequireAPIKey wraps a handler so that every request must carry a configured API key,
+// sent as "Authorization: Bearer <key>", "x-api-key" or, for Gemini routes, "x-goog-api-key".
+// Rejected requests get a 401 in the error format of the route's protocol. The key is
+// stored in the request context for the handlers. A nil or empty store disables the check.
+func RequireAPIKey(store *apikey.Store, next http.Handler) http.Handler {
+	if store == nil || store.Len() == 0 {
+		return next
+	}
+	logger := logging.NewLogger("proxy")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// CORS preflight requests, health probes and the dashboard's static files never
+		// carry credentials
+		if r.Method == http.MethodOptions || healthPaths[r.URL.Path] || isDashboardAsset(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key, err := store.Lookup(requestAPIKey(r), time.Now())
+		if err != nil {
+			if !errors.Is(err, apikey.ErrMissingKey) {
+				logger.WithContext(r.Context()).DebugLog("[Auth] Rejected %s %s from %s: %v", r.Method, r.URL.Path, r.RemoteAddr, err)
+			}
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+			w.Header().Set("WWW-Authenticate", `Bearer realm="qwencoder-proxy"`)
+			writeProtocolError(w, routeProtocol(r.URL.Path), newErrorInfo(kindAuthentication, err.Error()))
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(apikey.WithKey(r.Context(), key)))
+	})
+}
+
+// requestAPIKey extracts the client key from the headers clients of each protocol use
+func requestAPIKey(r *http.Request) string {
+	if scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " "); ok && strings.EqualFold(scheme, "Bearer") {
+		return strings.TrimSpace(token)
+	}
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	return r.Header.Get("X-Goog-API-Key")
+}
+
+// routeProtocol returns the client protocol served at path
+func routeProtocol(path string) provider.ProtocolType {
+	switch {
+	case strings.HasPrefix(path, "/anthropic/"):
+		return provider.ProtocolClaude
+	case strings.HasPrefix(path, "/gemini/") && !strings.HasPrefix(path, "/gemini/v1/"):
+		// /gemini/v1/ is the OpenAI-compatible route for the Gemini provider
+		return provider.ProtocolGemini
+	default:
+		return provider.ProtocolOpenAI
+	}
+}
@@ -0,0 +1,81 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sunbankio/qwencoder-proxy/apikey"
+)
+
+func TestRequireAPIKey(t *testing.T) {
+	store := apikey.NewStore(
+		&apikey.Key{Hash: apikey.Hash("sk-valid"), Label: "laptop"},
+		&apikey.Key{Hash: apikey.Hash("sk-expired"), ExpiresAt: time.Now().Add(-time.Hour)},
+	)
+	var seen *apikey.Key
+	handler := RequireAPIKey(store, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = apikey.FromContext(r.Context())
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		header     string
+		value      string
+		wantStatus int
+		wantBody   string // Field that identifies the protocol's error format
+	}{
+		{name: "bearer", path: "/v1/chat/completions", header: "Authorization", value: "Bearer sk-valid", wantStatus: http.StatusNoContent},
+		{name: "x-api-key", path: "/anthropic/messages", header: "X-API-Key", value: "sk-valid", wantStatus: http.StatusNoContent},
+		{name: "x-goog-api-key", path: "/gemini/models", header: "X-Goog-API-Key", value: "sk-valid", wantStatus: http.StatusNoContent},
+		{name: "preflight", method: http.MethodOptions, path: "/v1/chat/completions", wantStatus: http.StatusNoContent},
+		{name: "missing openai", path: "/v1/chat/completions", wantStatus: http.StatusUnauthorized, wantBody: "invalid_api_key"},
+		{name: "invalid anthropic", path: "/anthropic/messages", header: "X-API-Key", value: "sk-wrong", wantStatus: http.StatusUnauthorized, wantBody: "authentication_error"},
+		{name: "expired gemini", path: "/gemini/models/gemini-2.5-pro:generateContent", header: "Authorization", value: "Bearer sk-expired", wantStatus: http.StatusUnauthorized, wantBody: "UNAUTHENTICATED"},
+		{name: "gemini openai route", path: "/gemini/v1/chat/completions", header: "Authorization", value: "Basic sk-valid", wantStatus: http.StatusUnauthorized, wantBody: "invalid_api_key"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			seen = nil
+			method := tt.method
+			if method == "" {
+				method = http.MethodPost
+			}
+			req := httptest.NewRequest(method, tt.path, nil)
+			if tt.header != "" {
+				req.Header.Set(tt.header, tt.value)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d; body = %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if tt.wantBody == "" {
+				if tt.value != "" && (seen == nil || seen.Label != "laptop") {
+					t.Errorf("key in context = %+v, want the laptop key", seen)
+				}
+				return
+			}
+			if !strings.Contains(rec.Body.String(), `"`+tt.wantBody+`"`) {
+				t.Errorf("error = %s, want %s", rec.Body.String(), tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestRequireAPIKeyDisabledWithoutKeys(t *testing.T) {
+	next := http.NotFoundHandler()
+	handler := RequireAPIKey(apikey.NewStore(), next)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/models", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want the wrapped handler's 404", rec.Code)
+	}
+}
@@ -2,17 +2,33 @@ package proxy
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"time"
 
+	"github.com/sunbankio/qwencoder-proxy/config"
 	"github.com/sunbankio/qwencoder-proxy/converter"
 	"github.com/sunbankio/qwencoder-proxy/logging"
+	"github.com/sunbankio/qwencoder-proxy/openai"
 	"github.com/sunbankio/qwencoder-proxy/provider"
+	"github.com/sunbankio/qwencoder-proxy/sse"
 )
 
+// streamingConfig holds the streaming settings used by all handlers
+var streamingConfig = config.DefaultConfig().Streaming
+
+// ConfigureStreaming sets the streaming settings used by all handlers
+func ConfigureStreaming(cfg config.StreamingConfig) {
+	if cfg.BufferSize <= 0 {
+		cfg.BufferSize = config.DefaultConfig().Streaming.BufferSize
+	}
+	streamingConfig = cfg
+}
+
 // GenerateAndConvert handles non-streaming content generation and conversion to OpenAI format
-func GenerateAndConvert(ctx context.Context, p provider.Provider, conv converter.Converter, nativeReq interface{}, model string) (interface{}, error) {
+func GenerateAndConvert(ctx context.Context, p provider.Provider, conv converter.Converter, nativeReq interface{}, model string) (*openai.ChatCompletionResponse, error) {
 	nativeResp, err := p.GenerateContent(ctx, model, nativeReq)
 	if err != nil {
 		return nil, err
@@ -28,7 +44,21 @@ func SetStreamingHeaders(w http.ResponseWriter) {
 	w.Header().Set("Transfer-Encoding", "chunked")
 }
 
-// CopyStreamToResponse copies blocks from an io.ReadCloser to a http.ResponseWriter with flushing
+// ErrStreamIdle is returned when an upstream stream stays silent longer than the idle timeout
+var ErrStreamIdle = errors.New("upstream stream idle timeout")
+
+// keepaliveComment is sent as an SSE comment while the upstream is silent
+const keepaliveComment = "keepalive"
+
+// readResult carries the outcome of a single upstream read
+type readResult struct {
+	n   int
+	err error
+}
+
+// CopyStreamToResponse copies blocks from an io.ReadCloser to a http.ResponseWriter with flushing.
+// While the upstream is silent it sends SSE keepalive comments so intermediaries do not drop the
+// connection, and it aborts with ErrStreamIdle once the upstream has been silent for the idle timeout.
 func CopyStreamToResponse(w http.ResponseWriter, stream io.ReadCloser, logger *logging.Logger) error {
 	defer stream.Close()
 
@@ -37,23 +67,129 @@ func CopyStreamToResponse(w http.ResponseWriter, stream io.ReadCloser, logger *l
 		return fmt.Errorf("streaming not supported")
 	}
 
-	buf := make([]byte, 4096)
+	// Reads run in a goroutine so upstream silence can be observed. buf belongs to the
+	// goroutine until its result is received, and to this loop until resume is signalled.
+	buf := make([]byte, streamingConfig.BufferSize)
+	reads := make(chan readResult)
+	resume := make(chan struct{})
+	done := make(chan struct{})
+	defer close(done) // Deferred after stream.Close so it runs first; Close then unblocks a pending Read
+	go func() {
+		for {
+			n, err := stream.Read(buf)
+			select {
+			case reads <- readResult{n, err}:
+			case <-done:
+				return
+			}
+			if err != nil {
+				return
+			}
+			select {
+			case <-resume:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	keepaliveInterval := time.Duration(streamingConfig.KeepaliveSeconds) * time.Second
+	idleTimeout := time.Duration(streamingConfig.IdleTimeoutSeconds) * time.Second
+
+	var keepalive *time.Ticker
+	var keepaliveC <-chan time.Time
+	if keepaliveInterval > 0 {
+		keepalive = time.NewTicker(keepaliveInterval)
+		defer keepalive.Stop()
+		keepaliveC = keepalive.C
+	}
+	var idle *time.Timer
+	var idleC <-chan time.Time
+	if idleTimeout > 0 {
+		idle = time.NewTimer(idleTimeout)
+		defer idle.Stop()
+		idleC = idle.C
+	}
+
+	sw := sse.NewWriter(w)
+	boundary := newEventBoundary()
 	for {
-		n, err := stream.Read(buf)
-		if n > 0 {
-			if _, writeErr := w.Write(buf[:n]); writeErr != nil {
-				return writeErr
+		select {
+		case res := <-reads:
+			if res.n > 0 {
+				if _, writeErr := w.Write(buf[:res.n]); writeErr != nil {
+					return writeErr
+				}
+				flusher.Flush()
+				boundary.update(buf[:res.n])
+				if keepalive != nil {
+					keepalive.Reset(keepaliveInterval)
+				}
+				if idle != nil {
+					idle.Reset(idleTimeout)
+				}
+			}
+			if res.err == io.EOF {
+				return nil
+			}
+			if res.err != nil {
+				return res.err
+			}
+			resume <- struct{}{}
+
+		case <-keepaliveC:
+			// A comment inside a partially relayed event would corrupt it
+			if !boundary.atEnd() {
+				continue
+			}
+			logger.DebugLog("[Common] Upstream idle, sending SSE keepalive")
+			if err := sw.WriteComment(keepaliveComment); err != nil {
+				return err
 			}
 			flusher.Flush()
+
+		case <-idleC:
+			logger.ErrorLog("[Common] Upstream stream idle for %s, aborting", idleTimeout)
+			return fmt.Errorf("%w after %s", ErrStreamIdle, idleTimeout)
 		}
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return err
+	}
+}
+
+// eventBoundary tracks whether relayed SSE output currently ends between events
+type eventBoundary struct {
+	written    bool // Anything has been relayed
+	lineLen    int  // Bytes on the current, unterminated line
+	afterCR    bool // The last byte was CR, so a following LF belongs to the same line ending
+	blankEnded bool // The last terminated line was blank, which dispatches an event
+}
+
+// newEventBoundary returns a tracker for a stream that has not written anything yet
+func newEventBoundary() *eventBoundary {
+	return &eventBoundary{}
+}
+
+// update records the latest bytes written to the client
+func (b *eventBoundary) update(p []byte) {
+	for _, c := range p {
+		b.written = true
+		switch {
+		case c == '\n' && b.afterCR:
+			b.afterCR = false
+		case c == '\r' || c == '\n':
+			b.blankEnded = b.lineLen == 0
+			b.lineLen = 0
+			b.afterCR = c == '\r'
+		default:
+			b.lineLen++
+			b.afterCR = false
+			b.blankEnded = false
 		}
 	}
-	return nil
+}
+
+// atEnd reports whether nothing has been written or the output ends with a dispatched event
+func (b *eventBoundary) atEnd() bool {
+	return !b.written || (b.blankEnded && b.lineLen == 0)
 }
 
 // StreamResponse handles streaming content generation and writing to the response writer
@@ -71,5 +207,5 @@ func StreamResponse(w http.ResponseWriter, r *http.Request, factory *provider.Fa
 	// Set streaming headers
 	SetStreamingHeaders(w)
 
-	return CopyStreamToResponse(w, stream, logger)
+	return copyTracedStream(ctx, w, stream, model, logger)
 }
@@ -0,0 +1,87 @@
+package proxy
+
+import (
+	"errors"
+	"io"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sunbankio/qwencoder-proxy/config"
+	"github.com/sunbankio/qwencoder-proxy/logging"
+)
+
+func TestCopyStreamToResponseKeepalive(t *testing.T) {
+	withStreamingConfig(t, config.StreamingConfig{BufferSize: 4096, KeepaliveSeconds: 1})
+
+	pr, pw := io.Pipe()
+	go func() {
+		// The first pause falls inside an event, the second between events
+		pw.Write([]byte("data: par"))
+		time.Sleep(1300 * time.Millisecond)
+		pw.Write([]byte("tial\r\n\r\n"))
+		time.Sleep(1300 * time.Millisecond)
+		pw.Write([]byte("data: b\n\n"))
+		pw.Close()
+	}()
+
+	rec := httptest.NewRecorder()
+	if err := CopyStreamToResponse(rec, pr, logging.NewLogger("proxy")); err != nil {
+		t.Fatalf("CopyStreamToResponse() error = %v", err)
+	}
+
+	want := "data: partial\r\n\r\n: keepalive\n\ndata: b\n\n"
+	if got := rec.Body.String(); got != want {
+		t.Errorf("body = %q, want %q", got, want)
+	}
+}
+
+func TestCopyStreamToResponseIdleTimeout(t *testing.T) {
+	withStreamingConfig(t, config.StreamingConfig{BufferSize: 4096, IdleTimeoutSeconds: 1})
+
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	go pw.Write([]byte("data: a\n\n"))
+
+	start := time.Now()
+	err := CopyStreamToResponse(httptest.NewRecorder(), pr, logging.NewLogger("proxy"))
+	if !errors.Is(err, ErrStreamIdle) {
+		t.Fatalf("CopyStreamToResponse() error = %v, want ErrStreamIdle", err)
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("idle timeout took %s", elapsed)
+	}
+
+	// Closing the stream on abort unblocks the upstream side
+	if _, err := pw.Write([]byte("data: b\n\n")); err == nil {
+		t.Errorf("upstream write after abort succeeded, want closed pipe")
+	}
+}
+
+func TestEventBoundary(t *testing.T) {
+	tests := []struct {
+		name   string
+		writes []string
+		want   bool
+	}{
+		{"nothing written", nil, true},
+		{"lf event", []string{"data: a\n\n"}, true},
+		{"crlf event split", []string{"data: a\r\n\r", "\n"}, true},
+		{"cr event", []string{"data: a\r\r"}, true},
+		{"mid line", []string{"data: a"}, false},
+		{"mid event", []string{"data: a\n"}, false},
+		{"mid crlf event", []string{"data: a\r\n"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newEventBoundary()
+			for _, w := range tt.writes {
+				b.update([]byte(w))
+			}
+			if got := b.atEnd(); got != tt.want {
+				t.Errorf("atEnd() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
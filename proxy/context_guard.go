@@ -0,0 +1,81 @@
+package proxy
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/sunbankio/qwencoder-proxy/config"
+	"github.com/sunbankio/qwencoder-proxy/openai"
+	"github.com/sunbankio/qwencoder-proxy/provider"
+	"github.com/sunbankio/qwencoder-proxy/tokens"
+)
+
+const (
+	// ContextTrimHeader opts a request in to dropping old history instead of being rejected
+	ContextTrimHeader = "X-Context-Trim"
+	// ContextTrimmedHeader reports how many messages were dropped to fit the context window
+	ContextTrimmedHeader = "X-Context-Trimmed"
+)
+
+// contextConfig holds the context window check settings used by the OpenAI handlers
+var contextConfig = config.DefaultConfig().Context
+
+// ConfigureContextGuard sets the context window check settings used by the OpenAI handlers
+func ConfigureContextGuard(cfg config.ContextConfig) {
+	if cfg.RejectMarginPercent < 0 {
+		cfg.RejectMarginPercent = config.DefaultConfig().Context.RejectMarginPercent
+	}
+	contextConfig = cfg
+}
+
+// contextLimit returns the context window for a model on a provider.
+// Limits reported by the provider take precedence over the built-in table.
+func contextLimit(factory *provider.Factory, p provider.Provider, model string) int {
+	if limit := factory.ContextLimit(p.Name(), model); limit > 0 {
+		return limit
+	}
+	return tokens.DefaultContextLimit(model)
+}
+
+// trimRequested reports whether the client opted in to history trimming
+func trimRequested(r *http.Request) bool {
+	switch strings.ToLower(strings.TrimSpace(r.Header.Get(ContextTrimHeader))) {
+	case "1", "true", "yes", "auto", "oldest":
+		return true
+	default:
+		return false
+	}
+}
+
+// guardContext checks that the request fits the model's context window before dispatch.
+// The token count is an estimate, so a request is only rejected with an OpenAI
+// context_length_exceeded error when it exceeds the window by more than the configured
+// margin; the upstream decides closer calls. If the client opted in, the oldest history is
+// trimmed to fit the window instead. It returns false if the request was rejected.
+func (h *OpenAIHandler) guardContext(w http.ResponseWriter, r *http.Request, p provider.Provider, req *openai.ChatCompletionRequest) bool {
+	logger := h.logger.WithContext(r.Context())
+	limit := contextLimit(h.factory, p, req.Model)
+	if limit <= 0 {
+		return true
+	}
+
+	if trimRequested(r) {
+		removed, err := tokens.TrimHistory(req, limit)
+		if err == nil && removed > 0 {
+			logger.DebugLog("[Handler] Trimmed %d messages to fit %d token context of %s", removed, limit, req.Model)
+			w.Header().Set(ContextTrimmedHeader, strconv.Itoa(removed))
+		}
+	}
+
+	err := tokens.CheckContextLength(req, limit+limit*contextConfig.RejectMarginPercent/100)
+	var ctxErr *tokens.ContextLengthError
+	if errors.As(err, &ctxErr) {
+		ctxErr.Limit = limit // Clients see the window, not the margin
+		logger.DebugLog("[Handler] Rejecting request for %s: %v", req.Model, ctxErr)
+		writeOpenAIError(w, classifyError(ctxErr))
+		return false
+	}
+	return true
+}
@@ -0,0 +1,65 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sunbankio/qwencoder-proxy/config"
+	"github.com/sunbankio/qwencoder-proxy/converter"
+	"github.com/sunbankio/qwencoder-proxy/openai"
+	"github.com/sunbankio/qwencoder-proxy/provider"
+	"github.com/sunbankio/qwencoder-proxy/tokens"
+)
+
+// limitedFake lists fake-model with a 100 token context window
+type limitedFake struct {
+	*fakeProvider
+}
+
+func (f *limitedFake) ListModels(ctx context.Context) (interface{}, error) {
+	return map[string]interface{}{"data": []interface{}{map[string]interface{}{"id": "fake-model", "context_length": float64(100)}}}, nil
+}
+
+func TestGuardContextMargin(t *testing.T) {
+	old := contextConfig
+	t.Cleanup(func() { contextConfig = old })
+	ConfigureContextGuard(config.ContextConfig{RejectMarginPercent: 10})
+
+	fake := &limitedFake{newIFlowFake(false)}
+	factory := provider.NewFactory()
+	factory.Register(fake)
+	if err := factory.PopulateModelProviders(context.Background()); err != nil {
+		t.Fatalf("PopulateModelProviders() error = %v", err)
+	}
+	handler := NewProviderSpecificHandler(factory, converter.NewFactory(), provider.ProviderIFlow)
+
+	req := openai.ChatCompletionRequest{Model: "fake-model", Messages: []openai.Message{{Role: "user", Content: openai.TextContent("hi")}}}
+	prompt := tokens.CountRequest(&req)
+	tests := []struct {
+		name string
+		over int // Estimated tokens beyond the 100 token window
+		want bool
+	}{
+		{"fits", 0, true},
+		{"within the margin", 5, true},
+		{"beyond the margin", 20, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			guarded := req
+			maxTokens := 100 - prompt + tt.over
+			guarded.MaxTokens = &maxTokens
+			rec := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, "/iflow/v1/chat/completions", nil)
+			if got := handler.guardContext(rec, r, fake, &guarded); got != tt.want {
+				t.Fatalf("guardContext() = %v, want %v; body = %s", got, tt.want, rec.Body.String())
+			}
+			if !tt.want && (rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "maximum context length is 100 tokens")) {
+				t.Errorf("rejection = %d %s, want a 400 naming the 100 token window", rec.Code, rec.Body.String())
+			}
+		})
+	}
+}
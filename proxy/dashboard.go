@@ -0,0 +1,191 @@
+package proxy
+
+import (
+	"context"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/sunbankio/qwencoder-proxy/logging"
+	"github.com/sunbankio/qwencoder-proxy/metrics"
+	"github.com/sunbankio/qwencoder-proxy/provider"
+)
+
+// dashboardFiles holds the admin dashboard, a single page that reads the admin endpoints
+//
+//go:embed dashboard
+var dashboardFiles embed.FS
+
+// isDashboardAsset reports whether path is part of the dashboard's static files. They
+// hold no data, so they are served without an API key; the page asks for an admin key
+// and sends it with every call to the admin endpoints.
+func isDashboardAsset(path string) bool {
+	return path == "/admin" || strings.HasPrefix(path, "/admin/static/")
+}
+
+// Dashboard serves the admin dashboard and the admin endpoints behind its buttons
+type Dashboard struct {
+	factory *provider.Factory
+	logger  *logging.Logger
+
+	mu             sync.Mutex
+	authenticating map[provider.ProviderType]bool // Providers running an authentication flow
+}
+
+// NewDashboard creates a dashboard managing the providers of factory
+func NewDashboard(factory *provider.Factory) *Dashboard {
+	return &Dashboard{
+		factory:        factory,
+		logger:         logging.NewLogger("proxy"),
+		authenticating: make(map[provider.ProviderType]bool),
+	}
+}
+
+// modelsReport is the body of GET /admin/models
+type modelsReport struct {
+	Models map[string][]provider.ProviderType `json:"models"`
+	Rules  map[string]provider.ModelRule      `json:"rules"` // Routing rules of the models that have one
+}
+
+// dashboardStats is the body of GET /admin/stats
+type dashboardStats struct {
+	RequestsInFlight int                           `json:"requests_in_flight"` // Including the stats request
+	StreamsInFlight  int                           `json:"streams_in_flight"`
+	UpstreamInFlight map[provider.ProviderType]int `json:"upstream_in_flight"`
+	Authenticating   []provider.ProviderType       `json:"authenticating"`
+	RecentErrors     []provider.ProviderError      `json:"recent_errors"`
+}
+
+// Models serves GET /admin/models with the model to provider map and the models' routing rules
+func (d *Dashboard) Models(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, modelsReport{Models: d.factory.GetAllModels(), Rules: d.factory.RoutingRules().Models})
+}
+
+// RefreshModels serves POST /admin/models/refresh, which fetches every provider's catalog again
+func (d *Dashboard) RefreshModels(w http.ResponseWriter, r *http.Request) {
+	if err := d.factory.PopulateModelProviders(r.Context()); err != nil {
+		writeOpenAIError(w, newErrorInfo(kindInternal, fmt.Sprintf("Failed to refresh models: %v", err)))
+		return
+	}
+	d.logger.WithContext(r.Context()).InfoLog("[Admin] Model catalog refreshed")
+	d.Models(w, r)
+}
+
+// Stats serves GET /admin/stats with live request and stream counts and recent provider failures
+func (d *Dashboard) Stats(w http.ResponseWriter, r *http.Request) {
+	stats := dashboardStats{
+		RequestsInFlight: int(metrics.InFlight.Sum()),
+		StreamsInFlight:  int(metrics.StreamsInFlight.Value()),
+		UpstreamInFlight: make(map[provider.ProviderType]int),
+		Authenticating:   []provider.ProviderType{},
+		RecentErrors:     d.factory.RecentErrors(),
+	}
+	for _, providerType := range d.factory.ListTypes() {
+		stats.UpstreamInFlight[providerType] = int(metrics.UpstreamInFlight.Value(string(providerType)))
+	}
+	d.mu.Lock()
+	for providerType := range d.authenticating {
+		stats.Authenticating = append(stats.Authenticating, providerType)
+	}
+	d.mu.Unlock()
+	sort.Slice(stats.Authenticating, func(i, j int) bool { return stats.Authenticating[i] < stats.Authenticating[j] })
+	writeJSON(w, http.StatusOK, stats)
+}
+
+// Authenticate serves POST /admin/providers/{name}/authenticate. The provider's login
+// flow may wait for a browser, so it runs in the background and the request returns
+// 202 at once; the provider status shows the outcome.
+func (d *Dashboard) Authenticate(w http.ResponseWriter, r *http.Request) {
+	p, authenticator, ok := d.authenticator(w, r)
+	if !ok {
+		return
+	}
+	d.mu.Lock()
+	if d.authenticating[p.Name()] {
+		d.mu.Unlock()
+		writeOpenAIError(w, newErrorInfo(kindInvalidRequest, fmt.Sprintf("%s is already authenticating", p.Name())).withStatus(http.StatusConflict))
+		return
+	}
+	d.authenticating[p.Name()] = true
+	d.mu.Unlock()
+
+	logger := d.logger.WithContext(r.Context())
+	logger.InfoLog("[Admin] Starting authentication for %s", p.Name())
+	go func() {
+		defer func() {
+			d.mu.Lock()
+			delete(d.authenticating, p.Name())
+			d.mu.Unlock()
+		}()
+		if err := authenticator.Authenticate(context.Background()); err != nil {
+			logger.ErrorLog("[Admin] Authentication for %s failed: %v", p.Name(), err)
+			return
+		}
+		logger.InfoLog("[Admin] Authentication for %s succeeded", p.Name())
+		if err := d.factory.RefreshProviderModels(context.Background(), p.Name()); err != nil {
+			logger.WarningLog("[Admin] Failed to refresh models for %s: %v", p.Name(), err)
+		}
+	}()
+	writeJSON(w, http.StatusAccepted, map[string]string{"status": "authenticating"})
+}
+
+// ClearCredentials serves POST /admin/providers/{name}/clear-credentials, which removes
+// the provider's stored credentials
+func (d *Dashboard) ClearCredentials(w http.ResponseWriter, r *http.Request) {
+	p, authenticator, ok := d.authenticator(w, r)
+	if !ok {
+		return
+	}
+	if err := authenticator.ClearCredentials(); err != nil {
+		writeOpenAIError(w, newErrorInfo(kindInternal, fmt.Sprintf("Failed to clear %s credentials: %v", p.Name(), err)))
+		return
+	}
+	d.logger.WithContext(r.Context()).InfoLog("[Admin] Cleared credentials for %s", p.Name())
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// authenticator returns the provider named in the path and its authenticator, writing
+// an error when there is none
+func (d *Dashboard) authenticator(w http.ResponseWriter, r *http.Request) (provider.Provider, provider.Authenticator, bool) {
+	p, err := d.factory.Get(provider.ProviderType(r.PathValue("name")))
+	if err != nil {
+		writeOpenAIError(w, newErrorInfo(kindNotFound, err.Error()))
+		return nil, nil, false
+	}
+	authenticator := p.GetAuthenticator()
+	if authenticator == nil {
+		writeOpenAIError(w, newErrorInfo(kindInvalidRequest, fmt.Sprintf("%s has no authenticator", p.Name())))
+		return nil, nil, false
+	}
+	return p, authenticator, true
+}
+
+// writeJSON writes v as a JSON response with the given status
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// RegisterDashboardRoutes registers the dashboard at /admin and the admin endpoints it
+// uses, which require an admin key. Pinning uses the routing endpoints.
+func RegisterDashboardRoutes(mux *http.ServeMux, factory *provider.Factory) {
+	d := NewDashboard(factory)
+	static, _ := fs.Sub(dashboardFiles, "dashboard")
+	mux.Handle("GET /admin/static/", http.StripPrefix("/admin/static/", http.FileServerFS(static)))
+	mux.HandleFunc("GET /admin", func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFileFS(w, r, static, "index.html")
+	})
+
+	admin := func(handler http.HandlerFunc) http.Handler { return RequireAdmin(handler) }
+	mux.Handle("GET /admin/models", admin(d.Models))
+	mux.Handle("POST /admin/models/refresh", admin(d.RefreshModels))
+	mux.Handle("GET /admin/stats", admin(d.Stats))
+	mux.Handle("POST /admin/providers/{name}/authenticate", admin(d.Authenticate))
+	mux.Handle("POST /admin/providers/{name}/clear-credentials", admin(d.ClearCredentials))
+}
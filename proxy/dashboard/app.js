@@ -0,0 +1,153 @@
+// Admin dashboard: polls the admin endpoints with the key entered in the header, which
+// is kept in session storage for this tab only.
+"use strict";
+
+const refreshInterval = 5000;
+
+function el(tag, attrs, ...children) {
+  const node = document.createElement(tag);
+  for (const [name, value] of Object.entries(attrs || {})) {
+    if (name === "onclick") node.onclick = value;
+    else node.setAttribute(name, value);
+  }
+  for (const child of children) {
+    node.append(child instanceof Node ? child : String(child ?? ""));
+  }
+  return node;
+}
+
+function showMessage(text) {
+  const message = document.getElementById("message");
+  message.textContent = text;
+  message.hidden = !text;
+}
+
+async function api(method, path, body) {
+  const headers = {};
+  const key = sessionStorage.getItem("adminKey");
+  if (key) headers["Authorization"] = "Bearer " + key;
+  if (body !== undefined) headers["Content-Type"] = "application/json";
+  const response = await fetch(path, {method, headers, body: body === undefined ? undefined : JSON.stringify(body)});
+  if (response.status === 204) return null;
+  const data = await response.json().catch(() => null);
+  if (!response.ok) {
+    throw new Error((data && data.error && data.error.message) || response.status + " " + response.statusText);
+  }
+  return data;
+}
+
+function formatTime(value) {
+  return value ? new Date(value).toLocaleString() : "";
+}
+
+function state(ok, text) {
+  return el("span", {class: ok ? "ok" : "bad"}, text);
+}
+
+async function action(confirmText, method, path, body) {
+  if (confirmText && !confirm(confirmText)) return;
+  try {
+    await api(method, path, body);
+    showMessage("");
+  } catch (err) {
+    showMessage(err.message);
+  }
+  refresh();
+}
+
+function renderProviders(providers) {
+  const rows = providers.map((p) => {
+    const circuitClass = p.circuit === "closed" ? "ok" : p.circuit === "open" ? "bad" : "warn";
+    return el("tr", {},
+      el("td", {}, p.name),
+      el("td", {}, p.account || ""),
+      el("td", {}, state(p.authenticated, p.authenticated ? "authenticated" : "not authenticated")),
+      el("td", {}, formatTime(p.token_expiry)),
+      el("td", {}, p.checked_at ? state(p.healthy, p.healthy ? "healthy" : "unhealthy") : "not checked"),
+      el("td", {}, el("span", {class: circuitClass}, p.circuit)),
+      el("td", {}, p.models),
+      el("td", {class: "error"}, p.last_error ? formatTime(p.last_error.time) + ": " + p.last_error.message : ""),
+      el("td", {},
+        el("button", {onclick: () => action(null, "POST", `/admin/providers/${p.name}/authenticate`)}, "Re-authenticate"),
+        " ",
+        el("button", {onclick: () => action(`Delete the stored ${p.name} credentials?`, "POST", `/admin/providers/${p.name}/clear-credentials`)}, "Clear credentials")));
+  });
+  document.getElementById("providers").replaceChildren(...rows);
+}
+
+function renderModels(report) {
+  const rows = Object.keys(report.models).sort().map((model) => {
+    const providers = report.models[model];
+    const pin = el("select", {}, el("option", {value: ""}, "automatic"), ...providers.map((name) => el("option", {value: name}, name)));
+    const rule = report.rules[model] || {};
+    pin.value = rule.pin || "";
+    pin.onchange = () => {
+      const path = "/admin/routing/models/" + encodeURIComponent(model);
+      if (pin.value || rule.order) action(null, "PUT", path, {pin: pin.value, order: rule.order});
+      else action(null, "DELETE", path);
+    };
+    return el("tr", {}, el("td", {}, model), el("td", {}, providers.join(", ")), el("td", {}, providers.length > 1 ? pin : ""));
+  });
+  document.getElementById("models").replaceChildren(...rows);
+}
+
+function renderStats(stats) {
+  document.getElementById("requests").textContent = stats.requests_in_flight;
+  document.getElementById("streams").textContent = stats.streams_in_flight;
+  document.getElementById("upstream").textContent = Object.values(stats.upstream_in_flight).reduce((a, b) => a + b, 0);
+  const rows = stats.recent_errors.map((e) =>
+    el("tr", {}, el("td", {}, formatTime(e.time)), el("td", {}, e.provider), el("td", {}, e.model || ""), el("td", {class: "error"}, e.message)));
+  document.getElementById("errors").replaceChildren(...rows);
+}
+
+function renderBars(id, rows, label) {
+  const max = Math.max(1, ...rows.map((row) => row.total_tokens));
+  const bars = rows.map((row) =>
+    el("div", {class: "bar", title: `${label(row)}: ${row.requests} requests, ${row.total_tokens} tokens, ${row.errors} errors`},
+      el("div", {style: `height: ${(row.total_tokens / max) * 100}%`}),
+      label(row)));
+  document.getElementById(id).replaceChildren(...(bars.length ? bars : ["No usage recorded"]));
+}
+
+async function refreshUsage() {
+  try {
+    const byDay = await api("GET", "/admin/usage?from=14d&group_by=day");
+    renderBars("usage-chart", byDay.rows, (row) => row.group.day.slice(5));
+    const byProvider = await api("GET", "/admin/usage?from=14d&group_by=provider");
+    renderBars("usage-providers", byProvider.rows, (row) => row.group.provider);
+  } catch (err) {
+    document.getElementById("usage-chart").replaceChildren("Usage unavailable: " + err.message);
+    document.getElementById("usage-providers").replaceChildren();
+  }
+}
+
+async function refresh() {
+  try {
+    const [providers, models, stats] = await Promise.all([
+      api("GET", "/admin/providers"),
+      api("GET", "/admin/models"),
+      api("GET", "/admin/stats"),
+    ]);
+    renderProviders(providers.providers);
+    renderModels(models);
+    renderStats(stats);
+  } catch (err) {
+    showMessage(err.message);
+  }
+}
+
+document.getElementById("key-form").onsubmit = (event) => {
+  event.preventDefault();
+  sessionStorage.setItem("adminKey", document.getElementById("key").value);
+  document.getElementById("key").value = "";
+  showMessage("");
+  refresh();
+  refreshUsage();
+};
+
+document.getElementById("refresh-models").onclick = () => action(null, "POST", "/admin/models/refresh");
+
+refresh();
+refreshUsage();
+setInterval(refresh, refreshInterval);
+setInterval(refreshUsage, 60000);
@@ -0,0 +1,93 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sunbankio/qwencoder-proxy/apikey"
+	"github.com/sunbankio/qwencoder-proxy/provider"
+)
+
+func TestDashboardRoutes(t *testing.T) {
+	factory := provider.NewFactory()
+	factory.Register(newIFlowFake(true))
+	factory.Register(&fakeProvider{name: provider.ProviderQwen, protocol: provider.ProtocolOpenAI})
+	if err := factory.PopulateModelProviders(context.Background()); err != nil {
+		t.Fatalf("PopulateModelProviders() error = %v", err)
+	}
+	mux := http.NewServeMux()
+	RegisterDashboardRoutes(mux, factory)
+	RegisterRoutingRoutes(mux, factory)
+	admin := &apikey.Key{Hash: apikey.Hash("sk-admin"), Admin: true}
+	client := &apikey.Key{Hash: apikey.Hash("sk-client"), Label: "client"}
+	handler := RequireAPIKey(apikey.NewStore(admin, client), mux)
+
+	do := func(method, path, key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.RemoteAddr = "203.0.113.5:4000"
+		if key != "" {
+			req.Header.Set("Authorization", "Bearer "+key)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := do(http.MethodGet, "/admin", "", ""); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "/admin/static/app.js") {
+		t.Errorf("GET /admin status = %d, want the dashboard page without a key", rec.Code)
+	}
+	if rec := do(http.MethodGet, "/admin/static/app.js", "", ""); rec.Code != http.StatusOK {
+		t.Errorf("GET /admin/static/app.js status = %d, want 200", rec.Code)
+	}
+	if rec := do(http.MethodGet, "/admin/models", "sk-client", ""); rec.Code != http.StatusForbidden {
+		t.Errorf("GET /admin/models with a client key status = %d, want 403", rec.Code)
+	}
+
+	rec := do(http.MethodPut, "/admin/routing/models/fake-model", "sk-admin", `{"pin":"qwen"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("pin status = %d, want 200; body = %s", rec.Code, rec.Body)
+	}
+	var report modelsReport
+	rec = do(http.MethodGet, "/admin/models", "sk-admin", "")
+	if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+		t.Fatalf("decode models: %v", err)
+	}
+	if len(report.Models["fake-model"]) != 2 || report.Rules["fake-model"].Pin != provider.ProviderQwen {
+		t.Errorf("models = %+v, want fake-model served by two providers and pinned to qwen", report)
+	}
+	factory.RecordSuccess("fake-model", provider.ProviderIFlow)
+	if p, _ := factory.GetByModel("fake-model"); p.Name() != provider.ProviderQwen {
+		t.Errorf("GetByModel() = %s, want the pinned qwen over the last success", p.Name())
+	}
+
+	if rec := do(http.MethodPut, "/admin/routing/models/fake-model", "sk-admin", `{"pin":"kiro"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("pin to a provider without the model status = %d, want 400", rec.Code)
+	}
+	if rec := do(http.MethodDelete, "/admin/routing/models/fake-model", "sk-admin", ""); rec.Code != http.StatusOK {
+		t.Errorf("unpin status = %d, want 200", rec.Code)
+	}
+	if p, _ := factory.GetByModel("fake-model"); p.Name() != provider.ProviderIFlow {
+		t.Errorf("GetByModel() after unpin = %s, want the last success iflow", p.Name())
+	}
+
+	if rec := do(http.MethodPost, "/admin/providers/iflow/authenticate", "sk-admin", ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("authenticate without an authenticator status = %d, want 400", rec.Code)
+	}
+	if rec := do(http.MethodPost, "/admin/providers/nope/clear-credentials", "sk-admin", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("clear credentials of an unknown provider status = %d, want 404", rec.Code)
+	}
+
+	factory.RecordFailure("fake-model", provider.ProviderIFlow, &provider.APIError{StatusCode: http.StatusBadGateway})
+	rec = do(http.MethodGet, "/admin/stats", "sk-admin", "")
+	var stats dashboardStats
+	if err := json.Unmarshal(rec.Body.Bytes(), &stats); err != nil {
+		t.Fatalf("decode stats: %v", err)
+	}
+	if len(stats.RecentErrors) != 1 || stats.RecentErrors[0].Provider != provider.ProviderIFlow || stats.RecentErrors[0].Model != "fake-model" {
+		t.Errorf("recent errors = %+v, want the iflow failure", stats.RecentErrors)
+	}
+}
@@ -0,0 +1,352 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sunbankio/qwencoder-proxy/eventstream"
+	"github.com/sunbankio/qwencoder-proxy/provider"
+	"github.com/sunbankio/qwencoder-proxy/sse"
+	"github.com/sunbankio/qwencoder-proxy/tokens"
+	"github.com/sunbankio/qwencoder-proxy/transport"
+)
+
+// errorKind is the protocol-independent category of an error reported to clients
+type errorKind int
+
+const (
+	kindInternal errorKind = iota
+	kindInvalidRequest
+	kindContextLength
+	kindAuthentication
+	kindPermission
+	kindNotFound
+	kindRequestTooLarge
+	kindRateLimit
+	kindUnavailable
+	kindTimeout
+	kindUpstreamAuth // The upstream rejected the proxy's credentials, not the client's key
+)
+
+// errorShape describes how an error kind is spelled in each client protocol
+type errorShape struct {
+	status        int
+	openAIType    string
+	openAICode    string
+	anthropicType string
+	geminiStatus  string
+}
+
+var errorShapes = map[errorKind]errorShape{
+	kindInternal:        {http.StatusInternalServerError, "server_error", "", "api_error", "INTERNAL"},
+	kindInvalidRequest:  {http.StatusBadRequest, "invalid_request_error", "", "invalid_request_error", "INVALID_ARGUMENT"},
+	kindContextLength:   {http.StatusBadRequest, "invalid_request_error", "context_length_exceeded", "invalid_request_error", "INVALID_ARGUMENT"},
+	kindAuthentication:  {http.StatusUnauthorized, "authentication_error", "invalid_api_key", "authentication_error", "UNAUTHENTICATED"},
+	kindPermission:      {http.StatusForbidden, "permission_error", "", "permission_error", "PERMISSION_DENIED"},
+	kindNotFound:        {http.StatusNotFound, "not_found_error", "", "not_found_error", "NOT_FOUND"},
+	kindRequestTooLarge: {http.StatusRequestEntityTooLarge, "invalid_request_error", "request_too_large", "request_too_large", "INVALID_ARGUMENT"},
+	kindRateLimit:       {http.StatusTooManyRequests, "rate_limit_error", "rate_limit_exceeded", "rate_limit_error", "RESOURCE_EXHAUSTED"},
+	kindUnavailable:     {http.StatusServiceUnavailable, "server_error", "service_unavailable", "overloaded_error", "UNAVAILABLE"},
+	kindTimeout:         {http.StatusGatewayTimeout, "server_error", "timeout", "api_error", "DEADLINE_EXCEEDED"},
+	kindUpstreamAuth:    {http.StatusBadGateway, "server_error", "upstream_authentication_failed", "api_error", "UNAVAILABLE"},
+}
+
+// errorInfo is an error ready to be written in any client protocol
+type errorInfo struct {
+	kind       errorKind
+	status     int
+	message    string
+	param      string
+	code       string // overrides the kind's default OpenAI code when set
+	retryAfter time.Duration
+}
+
+// newErrorInfo creates an errorInfo with the default status of its kind
+func newErrorInfo(kind errorKind, message string) errorInfo {
+	return errorInfo{kind: kind, status: errorShapes[kind].status, message: message}
+}
+
+// withStatus overrides the HTTP status of an errorInfo
+func (e errorInfo) withStatus(status int) errorInfo {
+	e.status = status
+	return e
+}
+
+// withParam sets the request parameter an errorInfo refers to
+func (e errorInfo) withParam(param string) errorInfo {
+	e.param = param
+	return e
+}
+
+// withCode overrides the OpenAI error code of an errorInfo
+func (e errorInfo) withCode(code string) errorInfo {
+	e.code = code
+	return e
+}
+
+// withRetryAfter sets how long the client should wait before retrying
+func (e errorInfo) withRetryAfter(d time.Duration) errorInfo {
+	e.retryAfter = d
+	return e
+}
+
+// classifyError maps an error from a provider or the proxy itself to an errorInfo
+func classifyError(err error) errorInfo {
+	var ctxErr *tokens.ContextLengthError
+	if errors.As(err, &ctxErr) {
+		return newErrorInfo(kindContextLength, ctxErr.Error()).withParam("messages")
+	}
+
+	var apiErr *provider.APIError
+	if errors.As(err, &apiErr) {
+		info := newErrorInfo(upstreamStatusKind(apiErr.StatusCode), apiErr.Message())
+		if info.kind == kindUpstreamAuth {
+			info.message = upstreamAuthMessage(apiErr.Message())
+		}
+		if info.kind == kindInvalidRequest && isContextLengthMessage(apiErr.Body) {
+			info = newErrorInfo(kindContextLength, apiErr.Message()).withParam("messages")
+		}
+		info.retryAfter = apiErr.RetryAfter
+		return info
+	}
+
+	var excErr *eventstream.ExceptionError
+	if errors.As(err, &excErr) {
+		info := newErrorInfo(exceptionKind(excErr.Type), excErr.Message)
+		if info.kind == kindUpstreamAuth {
+			info.message = upstreamAuthMessage(excErr.Message)
+		}
+		return info
+	}
+
+	if errors.Is(err, ErrMalformedStream) {
+		return newErrorInfo(kindUnavailable, err.Error()).withStatus(http.StatusBadGateway)
+	}
+
+	if errors.Is(err, ErrStreamIdle) {
+		return newErrorInfo(kindTimeout, err.Error())
+	}
+
+	var timeoutErr *transport.TimeoutError
+	if errors.As(err, &timeoutErr) {
+		return newErrorInfo(kindTimeout, timeoutErr.Error())
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		return newErrorInfo(kindTimeout, "upstream request timed out")
+	}
+
+	return newErrorInfo(kindInternal, err.Error())
+}
+
+// routingError maps a failure to pick a provider for a model to an errorInfo
+func routingError(model string, err error) errorInfo {
+	if errors.Is(err, provider.ErrProvidersDisabled) {
+		return newErrorInfo(kindUnavailable, fmt.Sprintf("Every provider serving the model `%s` is disabled", model))
+	}
+	return newErrorInfo(kindNotFound, fmt.Sprintf("The model `%s` does not exist or is not served by any provider", model)).withParam("model").withCode("model_not_found")
+}
+
+// upstreamAuthMessage tells clients that the upstream refused the proxy's credentials
+func upstreamAuthMessage(message string) string {
+	return "The upstream provider rejected the proxy's credentials: " + message
+}
+
+// upstreamStatusKind maps an upstream HTTP status to an error kind. A 401 or 403 is about
+// the proxy's credentials for the provider, which the client cannot fix with its own key.
+func upstreamStatusKind(status int) errorKind {
+	switch {
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return kindUpstreamAuth
+	case status == http.StatusNotFound:
+		return kindNotFound
+	case status == http.StatusRequestEntityTooLarge:
+		return kindRequestTooLarge
+	case status == http.StatusTooManyRequests:
+		return kindRateLimit
+	case status == http.StatusRequestTimeout || status == http.StatusGatewayTimeout:
+		return kindTimeout
+	case status >= 500:
+		return kindUnavailable
+	case status >= 400:
+		return kindInvalidRequest
+	default:
+		return kindInternal
+	}
+}
+
+// exceptionKind maps an AWS event stream exception type to an error kind
+func exceptionKind(exceptionType string) errorKind {
+	switch exceptionType {
+	case "ThrottlingException", "TooManyRequestsException", "ServiceQuotaExceededException":
+		return kindRateLimit
+	case "ValidationException":
+		return kindInvalidRequest
+	case "AccessDeniedException", "UnauthorizedException", "ExpiredTokenException", "UnrecognizedClientException":
+		return kindUpstreamAuth
+	case "ResourceNotFoundException":
+		return kindNotFound
+	case "ServiceUnavailableException", "InternalServerException", "ModelStreamErrorException", "ModelNotReadyException":
+		return kindUnavailable
+	default:
+		return kindInternal
+	}
+}
+
+// isContextLengthMessage reports whether an upstream error body describes an oversized prompt
+func isContextLengthMessage(body string) bool {
+	b := strings.ToLower(body)
+	for _, marker := range []string{"context_length_exceeded", "maximum context length", "context window", "input length", "prompt is too long", "too many tokens"} {
+		if strings.Contains(b, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// setRetryAfter sets the Retry-After header in whole seconds, rounding up
+func setRetryAfter(w http.ResponseWriter, d time.Duration) {
+	if d > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(d.Seconds()))))
+	}
+}
+
+// writeJSONError writes an error body with the given status
+func writeJSONError(w http.ResponseWriter, info errorInfo, body interface{}) {
+	setRetryAfter(w, info.retryAfter)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Del("Content-Length")
+	w.WriteHeader(info.status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// writeSSEError emits a terminal error as an SSE error event on a stream that has already started
+func writeSSEError(w http.ResponseWriter, body interface{}) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return
+	}
+	sw := sse.NewWriter(w)
+	if sw.WriteEvent(&sse.Event{Type: "error", Data: string(data)}) == nil {
+		sw.Flush()
+	}
+}
+
+// OpenAIError is the error object inside an OpenAI error response
+type OpenAIError struct {
+	Message string  `json:"message"`
+	Type    string  `json:"type"`
+	Param   *string `json:"param"`
+	Code    *string `json:"code"`
+}
+
+// OpenAIErrorResponse is the OpenAI error envelope
+type OpenAIErrorResponse struct {
+	Error OpenAIError `json:"error"`
+}
+
+// openAIErrorBody builds the OpenAI error envelope for an errorInfo
+func openAIErrorBody(info errorInfo) OpenAIErrorResponse {
+	shape := errorShapes[info.kind]
+	body := OpenAIErrorResponse{Error: OpenAIError{Message: info.message, Type: shape.openAIType}}
+	code := shape.openAICode
+	if info.code != "" {
+		code = info.code
+	}
+	if code != "" {
+		body.Error.Code = &code
+	}
+	if info.param != "" {
+		param := info.param
+		body.Error.Param = &param
+	}
+	return body
+}
+
+// writeProtocolError writes an error envelope in the format of a client protocol
+func writeProtocolError(w http.ResponseWriter, protocol provider.ProtocolType, info errorInfo) {
+	switch protocol {
+	case provider.ProtocolClaude:
+		writeAnthropicError(w, info)
+	case provider.ProtocolGemini:
+		writeGeminiError(w, info)
+	default:
+		writeOpenAIError(w, info)
+	}
+}
+
+// writeOpenAIError writes an OpenAI-style error envelope
+func writeOpenAIError(w http.ResponseWriter, info errorInfo) {
+	writeJSONError(w, info, openAIErrorBody(info))
+}
+
+// writeOpenAIStreamError emits an OpenAI-style error event on a started stream
+func writeOpenAIStreamError(w http.ResponseWriter, info errorInfo) {
+	writeSSEError(w, openAIErrorBody(info))
+}
+
+// AnthropicError is the error object inside an Anthropic error response
+type AnthropicError struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+}
+
+// AnthropicErrorResponse is the Anthropic error envelope
+type AnthropicErrorResponse struct {
+	Type  string         `json:"type"`
+	Error AnthropicError `json:"error"`
+}
+
+// anthropicErrorBody builds the Anthropic error envelope for an errorInfo
+func anthropicErrorBody(info errorInfo) AnthropicErrorResponse {
+	return AnthropicErrorResponse{
+		Type:  "error",
+		Error: AnthropicError{Type: errorShapes[info.kind].anthropicType, Message: info.message},
+	}
+}
+
+// writeAnthropicError writes an Anthropic-style error envelope
+func writeAnthropicError(w http.ResponseWriter, info errorInfo) {
+	writeJSONError(w, info, anthropicErrorBody(info))
+}
+
+// writeAnthropicStreamError emits an Anthropic-style error event on a started stream
+func writeAnthropicStreamError(w http.ResponseWriter, info errorInfo) {
+	writeSSEError(w, anthropicErrorBody(info))
+}
+
+// GeminiError is the error object inside a Gemini (Google API) error response
+type GeminiError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Status  string `json:"status"`
+}
+
+// GeminiErrorResponse is the Gemini error envelope
+type GeminiErrorResponse struct {
+	Error GeminiError `json:"error"`
+}
+
+// geminiErrorBody builds the Gemini error envelope for an errorInfo
+func geminiErrorBody(info errorInfo) GeminiErrorResponse {
+	return GeminiErrorResponse{
+		Error: GeminiError{Code: info.status, Message: info.message, Status: errorShapes[info.kind].geminiStatus},
+	}
+}
+
+// writeGeminiError writes a Gemini-style error envelope
+func writeGeminiError(w http.ResponseWriter, info errorInfo) {
+	writeJSONError(w, info, geminiErrorBody(info))
+}
+
+// writeGeminiStreamError emits a Gemini-style error event on a started stream
+func writeGeminiStreamError(w http.ResponseWriter, info errorInfo) {
+	writeSSEError(w, geminiErrorBody(info))
+}
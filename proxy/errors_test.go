@@ -0,0 +1,112 @@
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/sunbankio/qwencoder-proxy/config"
+	"github.com/sunbankio/qwencoder-proxy/eventstream"
+	"github.com/sunbankio/qwencoder-proxy/provider"
+	"github.com/sunbankio/qwencoder-proxy/transport"
+)
+
+func TestClassifyUpstreamErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus int
+		wantType   string
+		wantCode   string
+	}{
+		{"unauthorized", &provider.APIError{StatusCode: 401, Body: `{"error":{"message":"bad token"}}`}, 502, "server_error", "upstream_authentication_failed"},
+		{"forbidden", &provider.APIError{StatusCode: 403}, 502, "server_error", "upstream_authentication_failed"},
+		{"not found", &provider.APIError{StatusCode: 404}, 404, "not_found_error", ""},
+		{"too large", &provider.APIError{StatusCode: 413}, 413, "invalid_request_error", "request_too_large"},
+		{"rate limited", fmt.Errorf("retry failed: %w", &provider.APIError{StatusCode: 429}), 429, "rate_limit_error", "rate_limit_exceeded"},
+		{"upstream 500", &provider.APIError{StatusCode: 500}, 503, "server_error", "service_unavailable"},
+		{"context length", &provider.APIError{StatusCode: 400, Body: `{"error":{"message":"Range of input length should be [1, 129024]"}}`}, 400, "invalid_request_error", "context_length_exceeded"},
+		{"kiro throttling", &eventstream.ExceptionError{Type: "ThrottlingException", Message: "slow down"}, 429, "rate_limit_error", "rate_limit_exceeded"},
+		{"kiro expired token", &eventstream.ExceptionError{Type: "ExpiredTokenException", Message: "token expired"}, 502, "server_error", "upstream_authentication_failed"},
+		{"idle stream", fmt.Errorf("%w after 5m0s", ErrStreamIdle), 504, "server_error", "timeout"},
+		{"upstream first byte", &url.Error{Op: "Post", URL: "https://upstream", Err: &transport.TimeoutError{Timeout: config.TimeoutFirstByte, After: time.Minute}}, 504, "server_error", "timeout"},
+		{"plain error", errors.New("boom"), 500, "server_error", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			writeOpenAIError(rec, classifyError(tt.err))
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+				t.Errorf("Content-Type = %s, want application/json", ct)
+			}
+			var body OpenAIErrorResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("invalid JSON body %s: %v", rec.Body.String(), err)
+			}
+			if body.Error.Type != tt.wantType {
+				t.Errorf("type = %s, want %s", body.Error.Type, tt.wantType)
+			}
+			gotCode := ""
+			if body.Error.Code != nil {
+				gotCode = *body.Error.Code
+			}
+			if gotCode != tt.wantCode {
+				t.Errorf("code = %q, want %q", gotCode, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestUpstreamMessageAndRetryAfter(t *testing.T) {
+	resp := &http.Response{StatusCode: 429, Header: http.Header{"Retry-After": []string{"7"}}}
+	apiErr := provider.NewAPIError(provider.ProviderQwen, resp, []byte(`{"error":{"message":"quota exceeded"}}`))
+
+	rec := httptest.NewRecorder()
+	writeOpenAIError(rec, classifyError(apiErr))
+
+	if got := rec.Header().Get("Retry-After"); got != "7" {
+		t.Errorf("Retry-After = %q, want 7", got)
+	}
+	var body OpenAIErrorResponse
+	json.Unmarshal(rec.Body.Bytes(), &body)
+	if body.Error.Message != "quota exceeded" {
+		t.Errorf("message = %q, want upstream message", body.Error.Message)
+	}
+}
+
+func TestProtocolErrorShapes(t *testing.T) {
+	info := classifyError(&provider.APIError{StatusCode: 429, Body: "slow down", RetryAfter: 1500 * time.Millisecond})
+
+	rec := httptest.NewRecorder()
+	writeAnthropicError(rec, info)
+	var anthropic AnthropicErrorResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &anthropic); err != nil {
+		t.Fatalf("invalid Anthropic body: %v", err)
+	}
+	if rec.Code != 429 || anthropic.Type != "error" || anthropic.Error.Type != "rate_limit_error" || anthropic.Error.Message != "slow down" {
+		t.Errorf("unexpected Anthropic error %d %+v", rec.Code, anthropic)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "2" {
+		t.Errorf("Retry-After = %q, want 2 (rounded up)", got)
+	}
+
+	rec = httptest.NewRecorder()
+	writeGeminiError(rec, info)
+	var gemini GeminiErrorResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &gemini); err != nil {
+		t.Fatalf("invalid Gemini body: %v", err)
+	}
+	if gemini.Error.Code != 429 || gemini.Error.Status != "RESOURCE_EXHAUSTED" {
+		t.Errorf("unexpected Gemini error %+v", gemini)
+	}
+}
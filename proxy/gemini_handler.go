@@ -3,25 +3,33 @@ package proxy
 
 import (
 	"encoding/json"
+	"fmt"
+	"maps"
 	"net/http"
+	"slices"
 	"strings"
 
+	"github.com/sunbankio/qwencoder-proxy/converter"
 	"github.com/sunbankio/qwencoder-proxy/logging"
 	"github.com/sunbankio/qwencoder-proxy/provider"
 	"github.com/sunbankio/qwencoder-proxy/provider/gemini"
+	"github.com/sunbankio/qwencoder-proxy/tokens"
 )
 
-// GeminiHandler handles requests to /gemini/* routes
+// GeminiHandler handles requests to /gemini/* routes. Each request is served by the
+// provider the factory picks for its model, translated when it speaks another protocol.
 type GeminiHandler struct {
-	provider *gemini.Provider
-	logger   *logging.Logger
+	factory     *provider.Factory
+	convFactory *converter.Factory
+	logger      *logging.Logger
 }
 
 // NewGeminiHandler creates a new Gemini route handler
-func NewGeminiHandler(p *gemini.Provider) *GeminiHandler {
+func NewGeminiHandler(factory *provider.Factory, convFactory *converter.Factory) *GeminiHandler {
 	return &GeminiHandler{
-		provider: p,
-		logger:   logging.NewLogger(),
+		factory:     factory,
+		convFactory: convFactory,
+		logger:      logging.NewLogger("proxy"),
 	}
 }
 
@@ -30,7 +38,7 @@ func (h *GeminiHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// Set CORS headers
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key, X-Goog-API-Key")
 
 	if r.Method == http.MethodOptions {
 		w.WriteHeader(http.StatusOK)
@@ -41,7 +49,7 @@ func (h *GeminiHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	path := strings.TrimPrefix(r.URL.Path, "/gemini")
 	path = strings.TrimPrefix(path, "/")
 
-	h.logger.DebugLog("[Gemini Handler] Request: %s %s", r.Method, path)
+	h.logger.WithContext(r.Context()).DebugLog("[Gemini Handler] Request: %s %s", r.Method, path)
 
 	switch {
 	case path == "models" && r.Method == http.MethodGet:
@@ -51,92 +59,172 @@ func (h *GeminiHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	case strings.HasPrefix(path, "models/") && strings.Contains(path, ":streamGenerateContent"):
 		h.handleStreamGenerateContent(w, r, path)
 	default:
-		http.Error(w, "Not found", http.StatusNotFound)
+		writeGeminiError(w, newErrorInfo(kindNotFound, fmt.Sprintf("Unsupported Gemini endpoint: %s", path)))
 	}
 }
 
-// handleListModels handles GET /gemini/models
+// handleListModels handles GET /gemini/models, listing every model in the catalog
 func (h *GeminiHandler) handleListModels(w http.ResponseWriter, r *http.Request) {
-	ctx := r.Context()
-
-	models, err := h.provider.ListModels(ctx)
-	if err != nil {
-		h.logger.ErrorLog("[Gemini Handler] Failed to list models: %v", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+	logger := h.logger.WithContext(r.Context())
+	catalog := h.factory.GetAllModels()
+	models := &gemini.GeminiModelsResponse{Models: []gemini.GeminiModel{}}
+	for _, model := range slices.Sorted(maps.Keys(catalog)) {
+		limit := tokens.DefaultContextLimit(model)
+		for _, providerType := range catalog[model] {
+			if reported := h.factory.ContextLimit(providerType, model); reported > 0 {
+				limit = reported
+				break
+			}
+		}
+		models.Models = append(models.Models, gemini.GeminiModel{
+			Name:                       model,
+			DisplayName:                model,
+			InputTokenLimit:            limit,
+			SupportedGenerationMethods: []string{"generateContent", "streamGenerateContent"},
+		})
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(models); err != nil {
-		h.logger.ErrorLog("[Gemini Handler] Failed to encode response: %v", err)
+		logger.ErrorLog("[Gemini Handler] Failed to encode response: %v", err)
 	}
 }
 
 // handleGenerateContent handles POST /gemini/models/{model}:generateContent
 func (h *GeminiHandler) handleGenerateContent(w http.ResponseWriter, r *http.Request, path string) {
+	logger := h.logger.WithContext(r.Context())
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeGeminiError(w, newErrorInfo(kindInvalidRequest, "Method not allowed").withStatus(http.StatusMethodNotAllowed))
 		return
 	}
 
 	// Extract model name from path: models/{model}:generateContent
-	model := extractModelFromPath(path, ":generateContent")
+	model := h.factory.ResolveModel(extractModelFromPath(path, ":generateContent"))
 	if model == "" {
-		http.Error(w, "Invalid model path", http.StatusBadRequest)
+		writeGeminiError(w, newErrorInfo(kindInvalidRequest, "Invalid model path"))
 		return
 	}
 
 	// Parse request body
 	var request gemini.GeminiRequest
 	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
-		h.logger.ErrorLog("[Gemini Handler] Failed to decode request: %v", err)
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		logger.ErrorLog("[Gemini Handler] Failed to decode request: %v", err)
+		writeGeminiError(w, newErrorInfo(kindInvalidRequest, fmt.Sprintf("Invalid request body: %v", err)))
+		return
+	}
+
+	p, translator, nativeReq, ok := h.route(w, model, &request, logger)
+	if !ok {
+		return
+	}
+	if !admit(w, r, p, model) {
 		return
 	}
 
 	ctx := r.Context()
-	response, err := h.provider.GenerateContent(ctx, model, &request)
+	var response interface{}
+	var err error
+	if aggregates(p) {
+		response, err = aggregateAs(ctx, h.convFactory, provider.ProtocolGemini, p, nativeReq, model, logger)
+	} else {
+		response, err = p.GenerateContent(ctx, model, nativeReq)
+		if err == nil {
+			response, err = translator.TranslateResponse(response, model)
+		}
+	}
 	if err != nil {
-		h.logger.ErrorLog("[Gemini Handler] GenerateContent failed: %v", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		logger.ErrorLog("[Gemini Handler] GenerateContent failed with %s: %v", p.Name(), err)
+		h.factory.RecordFailure(model, p.Name(), err)
+		writeGeminiError(w, classifyError(err))
 		return
 	}
+	h.factory.RecordSuccess(model, p.Name())
+	fillClientUsage(h.convFactory, provider.ProtocolGemini, clientRequest(h.convFactory, provider.ProtocolGemini, &request, model, logger), response, logger)
 
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(response); err != nil {
-		h.logger.ErrorLog("[Gemini Handler] Failed to encode response: %v", err)
+		logger.ErrorLog("[Gemini Handler] Failed to encode response: %v", err)
 	}
 }
 
 // handleStreamGenerateContent handles POST /gemini/models/{model}:streamGenerateContent
 func (h *GeminiHandler) handleStreamGenerateContent(w http.ResponseWriter, r *http.Request, path string) {
+	logger := h.logger.WithContext(r.Context())
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeGeminiError(w, newErrorInfo(kindInvalidRequest, "Method not allowed").withStatus(http.StatusMethodNotAllowed))
 		return
 	}
 
 	// Extract model name from path: models/{model}:streamGenerateContent
-	model := extractModelFromPath(path, ":streamGenerateContent")
+	model := h.factory.ResolveModel(extractModelFromPath(path, ":streamGenerateContent"))
 	if model == "" {
-		http.Error(w, "Invalid model path", http.StatusBadRequest)
+		writeGeminiError(w, newErrorInfo(kindInvalidRequest, "Invalid model path"))
 		return
 	}
 
 	// Parse request body
 	var request gemini.GeminiRequest
 	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
-		h.logger.ErrorLog("[Gemini Handler] Failed to decode request: %v", err)
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		logger.ErrorLog("[Gemini Handler] Failed to decode request: %v", err)
+		writeGeminiError(w, newErrorInfo(kindInvalidRequest, fmt.Sprintf("Invalid request body: %v", err)))
 		return
 	}
 
-	// Create a minimal factory for the converter (this is a temporary solution)
-	// In a real implementation, the factory should be passed to the handler
-	factory := &provider.Factory{}
-	if err := ConvertedStreamResponse(w, r, factory, h.provider, &request, model, h.logger); err != nil {
-		h.logger.ErrorLog("[Gemini Handler] Stream error: %v", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	p, _, nativeReq, ok := h.route(w, model, &request, logger)
+	if !ok {
+		return
+	}
+	if !admit(w, r, p, model) {
+		return
 	}
+
+	ctx := r.Context()
+	chunks, err := openChunkStream(ctx, h.convFactory, p, nativeReq, model, logger)
+	if err != nil {
+		logger.ErrorLog("[Gemini Handler] GenerateContentStream failed with %s: %v", p.Name(), err)
+		h.factory.RecordFailure(model, p.Name(), err)
+		writeGeminiError(w, classifyError(err))
+		return
+	}
+	h.factory.RecordSuccess(model, p.Name())
+
+	chunks = fillStreamUsage(chunks, clientRequest(h.convFactory, provider.ProtocolGemini, &request, model, logger), logger)
+
+	tracker := &responseTracker{ResponseWriter: w}
+	if err := relayChunks(ctx, tracker, h.convFactory, provider.ProtocolGemini, chunks, model, logger); err != nil {
+		logger.ErrorLog("[Gemini Handler] Stream error: %v", err)
+		h.factory.RecordFailure(model, p.Name(), err)
+		if !tracker.started {
+			writeGeminiError(w, classifyError(err))
+		} else if ctx.Err() == nil {
+			writeGeminiStreamError(w, classifyError(err))
+		}
+	}
+}
+
+// route picks the provider serving the model under the routing rules, and the request to
+// send it. Requests for providers that do not speak the Gemini protocol are translated.
+// It writes the error response and returns false when no provider can take the request.
+func (h *GeminiHandler) route(w http.ResponseWriter, model string, request *gemini.GeminiRequest, logger *logging.Logger) (provider.Provider, converter.Translator, interface{}, bool) {
+	p, err := h.factory.GetByModel(model)
+	if err != nil {
+		logger.WarningLog("[Gemini Handler] Failed to get provider: %v", err)
+		writeGeminiError(w, routingError(model, err))
+		return nil, nil, nil, false
+	}
+	translator, err := h.convFactory.GetTranslator(provider.ProtocolGemini, p.Protocol())
+	if err != nil {
+		logger.ErrorLog("[Gemini Handler] No translator for %s: %v", p.Protocol(), err)
+		writeGeminiError(w, classifyError(err))
+		return nil, nil, nil, false
+	}
+	nativeReq, err := translator.TranslateRequest(request)
+	if err != nil {
+		writeGeminiError(w, newErrorInfo(kindInvalidRequest, fmt.Sprintf("Failed to translate request: %v", err)))
+		return nil, nil, nil, false
+	}
+	logger.DebugLog("[Gemini Handler] Using provider %s for model %s", p.Name(), model)
+	return p, translator, nativeReq, true
 }
 
 // extractModelFromPath extracts the model name from a path like "models/{model}:action"
@@ -152,18 +240,6 @@ func extractModelFromPath(path, action string) string {
 }
 
 // RegisterGeminiRoutes registers Gemini routes with the given provider factory
-func RegisterGeminiRoutes(mux *http.ServeMux, factory *provider.Factory) error {
-	p, err := factory.Get(provider.ProviderGeminiCLI)
-	if err != nil {
-		return err
-	}
-
-	geminiProvider, ok := p.(*gemini.Provider)
-	if !ok {
-		return nil // Provider not available or wrong type
-	}
-
-	handler := NewGeminiHandler(geminiProvider)
-	mux.Handle("/gemini/", handler)
-	return nil
+func RegisterGeminiRoutes(mux *http.ServeMux, factory *provider.Factory, convFactory *converter.Factory) {
+	mux.Handle("/gemini/", NewGeminiHandler(factory, convFactory))
 }
@@ -0,0 +1,65 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/sunbankio/qwencoder-proxy/provider"
+)
+
+// healthPaths are probed by load balancers and orchestrators, which carry no API key
+var healthPaths = map[string]bool{
+	"/healthz": true,
+	"/readyz":  true,
+}
+
+// readiness is the body of /readyz
+type readiness struct {
+	Status    string                  `json:"status"`
+	Providers []provider.ProviderType `json:"providers"` // Providers able to serve requests
+}
+
+// HealthzHandler serves /healthz, which reports that the process is up
+func HealthzHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"status":"ok"}` + "\n"))
+}
+
+// ReadyzHandler serves /readyz, which succeeds while at least one provider is
+// authenticated and healthy. It reads the monitor's cached results only.
+func ReadyzHandler(monitor *provider.HealthMonitor) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := readiness{Status: "ready", Providers: []provider.ProviderType{}}
+		for _, status := range monitor.Statuses() {
+			if status.Ready() {
+				body.Providers = append(body.Providers, status.Name)
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if len(body.Providers) == 0 {
+			body.Status = "not ready"
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(body)
+	})
+}
+
+// ProvidersHandler serves GET /admin/providers with the status of every provider
+func ProvidersHandler(monitor *provider.HealthMonitor) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeOpenAIError(w, newErrorInfo(kindInvalidRequest, "Method not allowed").withStatus(http.StatusMethodNotAllowed))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"providers": monitor.Statuses()})
+	})
+}
+
+// RegisterHealthRoutes registers the health endpoints. /healthz and /readyz are open to
+// unauthenticated probes; /admin/providers requires an admin key.
+func RegisterHealthRoutes(mux *http.ServeMux, monitor *provider.HealthMonitor) {
+	mux.HandleFunc("/healthz", HealthzHandler)
+	mux.Handle("/readyz", ReadyzHandler(monitor))
+	mux.Handle("/admin/providers", RequireAdmin(ProvidersHandler(monitor)))
+}
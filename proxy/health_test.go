@@ -0,0 +1,58 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sunbankio/qwencoder-proxy/apikey"
+	"github.com/sunbankio/qwencoder-proxy/provider"
+)
+
+func TestHealthRoutes(t *testing.T) {
+	factory := provider.NewFactory()
+	factory.Register(newIFlowFake(true))
+	if err := factory.PopulateModelProviders(context.Background()); err != nil {
+		t.Fatalf("PopulateModelProviders() error = %v", err)
+	}
+	monitor := provider.NewHealthMonitor(factory, time.Minute, time.Second)
+	mux := http.NewServeMux()
+	RegisterHealthRoutes(mux, monitor)
+	key := &apikey.Key{Hash: apikey.Hash("sk-client"), Label: "client"}
+	handler := RequireAPIKey(apikey.NewStore(key), mux)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.RemoteAddr = "203.0.113.5:4000"
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := get("/healthz"); rec.Code != http.StatusOK {
+		t.Errorf("/healthz status = %d, want 200", rec.Code)
+	}
+	if rec := get("/readyz"); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("/readyz before the first check status = %d, want 503", rec.Code)
+	}
+
+	monitor.Check(context.Background())
+	rec := get("/readyz")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("/readyz status = %d, want 200; body = %s", rec.Code, rec.Body)
+	}
+	var body readiness
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode /readyz: %v", err)
+	}
+	if len(body.Providers) != 1 || body.Providers[0] != provider.ProviderIFlow {
+		t.Errorf("/readyz providers = %v, want [iflow]", body.Providers)
+	}
+
+	if rec := get("/admin/providers"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("/admin/providers without a key status = %d, want 401", rec.Code)
+	}
+}
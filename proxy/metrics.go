@@ -0,0 +1,120 @@
+package proxy
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sunbankio/qwencoder-proxy/metrics"
+	"github.com/sunbankio/qwencoder-proxy/provider"
+)
+
+// knownRoutes are the endpoints metrics are labelled with. Any other path is counted as
+// "other", so unmatched requests cannot grow the number of series without bound.
+var knownRoutes = func() map[string]bool {
+	routes := map[string]bool{
+		"/metrics":              true,
+		"/healthz":              true,
+		"/readyz":               true,
+		"/admin":                true,
+		"/admin/usage":          true,
+		"/admin/providers":      true,
+		"/admin/models":         true,
+		"/admin/models/refresh": true,
+		"/admin/stats":          true,
+		"/admin/routing":        true,
+	}
+	for _, prefix := range []string{"/v1", "/qwen/v1", "/gemini/v1", "/kiro/v1", "/antigravity/v1", "/iflow/v1"} {
+		for _, endpoint := range []string{"/models", "/chat/completions", "/tokenize"} {
+			routes[prefix+endpoint] = true
+		}
+	}
+	for _, endpoint := range []string{"/models", "/models/{model}:generateContent", "/models/{model}:streamGenerateContent"} {
+		routes["/gemini"+endpoint] = true
+	}
+	for _, endpoint := range []string{"/models", "/messages"} {
+		routes["/anthropic"+endpoint] = true
+	}
+	return routes
+}()
+
+// routeLabel returns the route of a request path for metric labels, with the model
+// in Gemini paths replaced by {model}
+func routeLabel(path string) string {
+	if i := strings.Index(path, "/models/"); i >= 0 {
+		if j := strings.LastIndexByte(path, ':'); j > i {
+			path = path[:i] + "/models/{model}" + path[j:]
+		}
+	}
+	if knownRoutes[path] {
+		return path
+	}
+	return "other"
+}
+
+// modelLabel returns the catalog name of a requested model for metric labels, resolving
+// aliases. Models not in factory's catalog are counted as "other", like unknown routes.
+func modelLabel(factory *provider.Factory, model string) string {
+	if model == "" {
+		return ""
+	}
+	if model = factory.ResolveModel(model); len(factory.GetModelProviders(model)) > 0 {
+		return model
+	}
+	return "other"
+}
+
+// ObserveRequests records the request, latency, token and stream metrics served at /metrics.
+// Models are labelled with their names in factory's catalog.
+func ObserveRequests(factory *provider.Factory, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scope, w, r := scoped(w, r)
+		route := routeLabel(r.URL.Path)
+		scope.observed = true
+		metrics.InFlight.Add(1, route)
+		scope.meter.onStream = func() { metrics.StreamsInFlight.Add(1) }
+		defer func() {
+			metrics.InFlight.Add(-1, route)
+			if scope.meter.Streaming() {
+				metrics.StreamsInFlight.Add(-1)
+			}
+			scope.trackUpstream("")
+		}()
+
+		next.ServeHTTP(w, r)
+
+		end := time.Now()
+		status := scope.meter.Status()
+		if status == 0 {
+			status = http.StatusOK // Nothing was written, so the server sends an empty 200
+		}
+		var providerName string
+		if scope.provider != nil {
+			providerName = string(scope.provider.Name())
+		}
+		code := strconv.Itoa(status)
+		model := modelLabel(factory, scope.model)
+		metrics.Requests.Inc(route, model, providerName, code)
+		metrics.RequestDuration.Observe(end.Sub(scope.start).Seconds(), route, model, providerName, code)
+		if scope.provider == nil {
+			return
+		}
+
+		if first := scope.meter.FirstWrite(); scope.meter.Streaming() && !first.IsZero() {
+			metrics.TimeToFirstToken.Observe(first.Sub(scope.start).Seconds(), route, model, providerName)
+			metrics.StreamDuration.Observe(end.Sub(first).Seconds(), route, model, providerName)
+		}
+		usage := scope.meter.Usage()
+		metrics.Tokens.Add(float64(usage.Input), providerName, model, "input")
+		metrics.Tokens.Add(float64(usage.Output), providerName, model, "output")
+		metrics.Tokens.Add(float64(usage.Cached), providerName, model, "cached")
+	})
+}
+
+// RegisterMetricsRoute serves the metrics registry at /metrics, refreshing the catalog
+// and circuit breaker gauges from factory on every scrape
+func RegisterMetricsRoute(mux *http.ServeMux, factory *provider.Factory) {
+	metrics.Default.OnScrape(factory.PublishMetrics)
+	mux.Handle("/metrics", metrics.Handler())
+}
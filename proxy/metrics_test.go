@@ -0,0 +1,120 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sunbankio/qwencoder-proxy/metrics"
+	"github.com/sunbankio/qwencoder-proxy/provider"
+)
+
+func TestRouteLabel(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/v1/chat/completions", "/v1/chat/completions"},
+		{"/kiro/v1/models", "/kiro/v1/models"},
+		{"/anthropic/messages", "/anthropic/messages"},
+		{"/gemini/models/gemini-2.5-pro:streamGenerateContent", "/gemini/models/{model}:streamGenerateContent"},
+		{"/gemini/models/a/b:generateContent", "/gemini/models/{model}:generateContent"},
+		{"/v1/unknown", "other"},
+		{"/wp-login.php", "other"},
+	}
+	for _, tt := range tests {
+		if got := routeLabel(tt.path); got != tt.want {
+			t.Errorf("routeLabel(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestObserveRequests(t *testing.T) {
+	p := newIFlowFake(false)
+	factory := provider.NewFactory()
+	factory.Register(p)
+	if err := factory.PopulateModelProviders(context.Background()); err != nil {
+		t.Fatalf("PopulateModelProviders() error = %v", err)
+	}
+	factory.SetAliases(map[string]string{"observe-alias": "fake-model"})
+	// Requests for an alias are labelled with the catalog model it stands for
+	const model = "fake-model"
+	handler := ObserveRequests(factory, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !admit(w, r, p, r.URL.Query().Get("model")) {
+			return
+		}
+		if got := metrics.UpstreamInFlight.Value(string(p.Name())); got < 1 {
+			t.Errorf("upstream in flight during request = %v, want at least 1", got)
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, "data: {\"choices\":[]}\n\n")
+		io.WriteString(w, "data: {\"usage\":{\"prompt_tokens\":12,\"completion_tokens\":5}}\n\n")
+	}))
+
+	before := metrics.UpstreamInFlight.Value(string(p.Name()))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/chat/completions?model=observe-alias", strings.NewReader("{}")))
+
+	labels := []string{"/v1/chat/completions", model, string(p.Name())}
+	if got := metrics.Requests.Value(append(labels, "200")...); got != 1 {
+		t.Errorf("requests_total = %v, want 1", got)
+	}
+	if got := metrics.RequestDuration.Count(append(labels, "200")...); got != 1 {
+		t.Errorf("request_duration_seconds count = %v, want 1", got)
+	}
+	if got := metrics.TimeToFirstToken.Count(labels...); got != 1 {
+		t.Errorf("time_to_first_token_seconds count = %v, want 1", got)
+	}
+	if got := metrics.StreamDuration.Count(labels...); got != 1 {
+		t.Errorf("stream_duration_seconds count = %v, want 1", got)
+	}
+	if got := metrics.Tokens.Value(string(p.Name()), model, "input"); got != 12 {
+		t.Errorf("input tokens = %v, want 12", got)
+	}
+	if got := metrics.Tokens.Value(string(p.Name()), model, "output"); got != 5 {
+		t.Errorf("output tokens = %v, want 5", got)
+	}
+	if got := metrics.UpstreamInFlight.Value(string(p.Name())); got != before {
+		t.Errorf("upstream in flight after request = %v, want %v", got, before)
+	}
+	if got := metrics.InFlight.Value("/v1/chat/completions"); got != 0 {
+		t.Errorf("requests in flight after request = %v, want 0", got)
+	}
+
+	// Models outside the catalog share one series
+	for _, unknown := range []string{"made-up-1", "made-up-2"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/v1/chat/completions?model="+unknown, strings.NewReader("{}")))
+	}
+	if got := metrics.Requests.Value("/v1/chat/completions", "other", string(p.Name()), "200"); got != 2 {
+		t.Errorf("requests_total for unknown models = %v, want 2 labelled other", got)
+	}
+	if got := metrics.Requests.Value("/v1/chat/completions", "made-up-1", string(p.Name()), "200"); got != 0 {
+		t.Errorf("requests_total for made-up-1 = %v, want no series of its own", got)
+	}
+}
+
+func TestMetricsRoute(t *testing.T) {
+	factory := provider.NewFactory()
+	factory.Register(&fakeProvider{name: provider.ProviderQwen})
+	factory.RecordFailure("fake-model", provider.ProviderQwen, &provider.APIError{StatusCode: http.StatusBadGateway})
+	mux := http.NewServeMux()
+	RegisterMetricsRoute(mux, factory)
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := rec.Body.String()
+	for _, want := range []string{
+		"# TYPE qwencoder_proxy_requests_total counter",
+		"# TYPE qwencoder_proxy_request_duration_seconds histogram",
+		`qwencoder_proxy_circuit_state{provider="qwen"} 0`,
+		`qwencoder_proxy_provider_models{provider="qwen"} 0`,
+		"qwencoder_proxy_catalog_models 0",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("/metrics is missing %q", want)
+		}
+	}
+}
@@ -3,13 +3,18 @@ package proxy
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"strings"
 
 	"github.com/sunbankio/qwencoder-proxy/converter"
 	"github.com/sunbankio/qwencoder-proxy/logging"
+	"github.com/sunbankio/qwencoder-proxy/metrics"
+	"github.com/sunbankio/qwencoder-proxy/openai"
 	"github.com/sunbankio/qwencoder-proxy/provider"
+	"github.com/sunbankio/qwencoder-proxy/tokens"
+	"github.com/sunbankio/qwencoder-proxy/tracing"
 )
 
 // OpenAIHandler handles OpenAI-compatible requests and routes them to appropriate providers
@@ -25,7 +30,7 @@ func NewOpenAIHandler(factory *provider.Factory, convFactory *converter.Factory)
 	return &OpenAIHandler{
 		factory:     factory,
 		convFactory: convFactory,
-		logger:      logging.NewLogger(),
+		logger:      logging.NewLogger("proxy"),
 	}
 }
 
@@ -35,7 +40,7 @@ func NewProviderSpecificHandler(factory *provider.Factory, convFactory *converte
 		factory:       factory,
 		convFactory:   convFactory,
 		fixedProvider: providerType,
-		logger:        logging.NewLogger(),
+		logger:        logging.NewLogger("proxy"),
 	}
 }
 
@@ -44,16 +49,21 @@ func (h *OpenAIHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// Set CORS headers
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key, "+ContextTrimHeader)
+	w.Header().Set("Access-Control-Expose-Headers", ContextTrimmedHeader)
 
 	if r.Method == http.MethodOptions {
 		w.WriteHeader(http.StatusOK)
 		return
 	}
 
-	// Determine the path by stripping known prefixes
+	// Determine the path by stripping known prefixes, the fixed provider's own first, so
+	// additional accounts served under their names are handled too
 	path := r.URL.Path
 	prefixes := []string{"/v1", "/qwen/v1", "/gemini/v1", "/kiro/v1", "/antigravity/v1", "/iflow/v1"}
+	if h.fixedProvider != "" {
+		prefixes = append([]string{"/" + string(h.fixedProvider) + "/v1"}, prefixes...)
+	}
 	for _, prefix := range prefixes {
 		if strings.HasPrefix(path, prefix) {
 			path = strings.TrimPrefix(path, prefix)
@@ -62,33 +72,36 @@ func (h *OpenAIHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 	path = strings.TrimPrefix(path, "/")
 
-	h.logger.DebugLog("[Handler] Request: %s %s (Fixed Provider: %s)", r.Method, path, h.fixedProvider)
+	h.logger.WithContext(r.Context()).DebugLog("[Handler] Request: %s %s (Fixed Provider: %s)", r.Method, path, h.fixedProvider)
 
 	switch {
 	case path == "models" && r.Method == http.MethodGet:
 		h.handleListModels(w, r)
 	case path == "chat/completions" && r.Method == http.MethodPost:
 		h.handleChatCompletions(w, r)
+	case path == "tokenize" && r.Method == http.MethodPost:
+		h.handleTokenize(w, r)
 	default:
-		http.Error(w, fmt.Sprintf("Unsupported OpenAI-compatible endpoint: %s", path), http.StatusNotFound)
+		writeOpenAIError(w, newErrorInfo(kindNotFound, fmt.Sprintf("Unsupported OpenAI-compatible endpoint: %s", path)))
 	}
 }
 
 // handleListModels handles GET /v1/models
 func (h *OpenAIHandler) handleListModels(w http.ResponseWriter, r *http.Request) {
+	logger := h.logger.WithContext(r.Context())
 	var allModels []provider.OpenAIModel
 
 	if h.fixedProvider != "" {
 		// Specific provider request
 		p, err := h.factory.Get(h.fixedProvider)
 		if err != nil {
-			http.Error(w, fmt.Sprintf("Provider not available: %s", h.fixedProvider), http.StatusInternalServerError)
+			writeOpenAIError(w, newErrorInfo(kindUnavailable, fmt.Sprintf("Provider not available: %s", h.fixedProvider)))
 			return
 		}
 		modelsData, err := p.ListModels(r.Context())
 		if err != nil {
-			h.logger.ErrorLog("[Handler] Failed to list models for provider %s: %v", h.fixedProvider, err)
-			http.Error(w, "Failed to list models", http.StatusInternalServerError)
+			logger.ErrorLog("[Handler] Failed to list models for provider %s: %v", h.fixedProvider, err)
+			writeOpenAIError(w, classifyError(err))
 			return
 		}
 		allModels = h.factory.FormatOpenAIModels(modelsData, h.fixedProvider)
@@ -102,7 +115,7 @@ func (h *OpenAIHandler) handleListModels(w http.ResponseWriter, r *http.Request)
 
 			modelsData, err := p.ListModels(r.Context())
 			if err != nil {
-				h.logger.ErrorLog("[Handler] Failed to list models for provider %s: %v", providerType, err)
+				logger.ErrorLog("[Handler] Failed to list models for provider %s: %v", providerType, err)
 				continue
 			}
 
@@ -123,16 +136,18 @@ func (h *OpenAIHandler) handleListModels(w http.ResponseWriter, r *http.Request)
 
 // handleChatCompletions handles POST /v1/chat/completions
 func (h *OpenAIHandler) handleChatCompletions(w http.ResponseWriter, r *http.Request) {
-	var openaiReq map[string]interface{}
+	logger := h.logger.WithContext(r.Context())
+	var openaiReq openai.ChatCompletionRequest
 	if err := json.NewDecoder(r.Body).Decode(&openaiReq); err != nil {
-		h.logger.ErrorLog("[Handler] Failed to decode request: %v", err)
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		logger.ErrorLog("[Handler] Failed to decode request: %v", err)
+		writeOpenAIError(w, newErrorInfo(kindInvalidRequest, fmt.Sprintf("Invalid request body: %v", err)))
 		return
 	}
 
-	model, _ := openaiReq["model"].(string)
+	openaiReq.Model = h.factory.ResolveModel(openaiReq.Model)
+	model := openaiReq.Model
 	if model == "" {
-		http.Error(w, "Model is required", http.StatusBadRequest)
+		writeOpenAIError(w, newErrorInfo(kindInvalidRequest, "Model is required").withParam("model"))
 		return
 	}
 
@@ -146,72 +161,124 @@ func (h *OpenAIHandler) handleChatCompletions(w http.ResponseWriter, r *http.Req
 	}
 
 	if err != nil {
-		h.logger.ErrorLog("[Handler] Failed to get provider: %v", err)
-		http.Error(w, fmt.Sprintf("Provider not found: %v", err), http.StatusBadRequest)
+		logger.WarningLog("[Handler] Failed to get provider: %v", err)
+		writeOpenAIError(w, routingError(model, err))
+		return
+	}
+
+	logger.DebugLog("[Handler] Using provider %s for model %s", p.Name(), model)
+
+	if !admit(w, r, p, model) {
 		return
 	}
 
-	h.logger.DebugLog("[Handler] Using provider %s for model %s", p.Name(), model)
+	if !h.guardContext(w, r, p, &openaiReq) {
+		return
+	}
 
 	conv, err := h.convFactory.Get(p.Protocol())
 	if err != nil {
-		h.logger.ErrorLog("[Handler] No converter for protocol %s: %v", p.Protocol(), err)
-		http.Error(w, "Protocol conversion not supported", http.StatusInternalServerError)
+		logger.ErrorLog("[Handler] No converter for protocol %s: %v", p.Protocol(), err)
+		writeOpenAIError(w, newErrorInfo(kindInternal, fmt.Sprintf("Protocol conversion not supported for %s", p.Protocol())))
 		return
 	}
 
-	nativeReq, err := conv.FromOpenAIRequest(openaiReq)
+	_, span := tracing.Start(r.Context(), "FromOpenAIRequest", tracing.String("proxy.protocol", string(p.Protocol())))
+	nativeReq, err := conv.FromOpenAIRequest(&openaiReq)
+	span.RecordError(err)
+	span.End()
 	if err != nil {
-		h.logger.ErrorLog("[Handler] Conversion failed: %v", err)
-		http.Error(w, "Failed to convert request", http.StatusInternalServerError)
+		logger.ErrorLog("[Handler] Conversion failed: %v", err)
+		writeOpenAIError(w, newErrorInfo(kindInvalidRequest, fmt.Sprintf("Failed to convert request: %v", err)))
 		return
 	}
 
-	isStreaming, _ := openaiReq["stream"].(bool)
-	if isStreaming {
-		// Use converted streaming for providers that need format conversion
-		if needsStreamConversion(p.Protocol()) {
-			if err := ConvertedStreamResponse(w, r, h.factory, p, nativeReq, model, h.logger); err != nil {
-				h.logger.ErrorLog("[Handler] Converted streaming error: %v", err)
-			}
+	if openaiReq.Stream {
+		tracker := &responseTracker{ResponseWriter: w}
+		if openaiReq.StreamOptions != nil && openaiReq.StreamOptions.IncludeUsage {
+			// Usage was asked for, so the stream is relayed chunk by chunk to complete its usage
+			err = h.streamWithUsage(tracker, r, p, &openaiReq, nativeReq, model)
+		} else if simulatesStreaming(p) {
+			err = SimulatedStreamResponse(tracker, r, h.factory, h.convFactory, p, nativeReq, model, logger)
+		} else if needsStreamConversion(p.Protocol()) {
+			// Use converted streaming for providers that need format conversion
+			err = ConvertedStreamResponse(tracker, r, h.factory, p, nativeReq, model, logger)
 		} else {
 			// Use raw streaming for providers that already format correctly (like Kiro)
-			if err := StreamResponse(w, r, h.factory, p, nativeReq, model, h.logger); err != nil {
-				h.logger.ErrorLog("[Handler] Raw streaming error: %v", err)
+			err = StreamResponse(tracker, r, h.factory, p, nativeReq, model, logger)
+		}
+		if errors.Is(err, provider.ErrStreamingUnsupported) && !tracker.started {
+			logger.DebugLog("[Handler] Provider %s cannot stream, simulating from a unary response", p.Name())
+			metrics.Retries.Inc(string(p.Name()), metrics.RetryUnaryFallback)
+			err = SimulatedStreamResponse(tracker, r, h.factory, h.convFactory, p, nativeReq, model, logger)
+		}
+		if err != nil {
+			logger.ErrorLog("[Handler] Streaming error with %s: %v", p.Name(), err)
+			h.factory.RecordFailure(model, p.Name(), err)
+			if !tracker.started {
+				writeOpenAIError(w, classifyError(err))
+			} else if r.Context().Err() == nil {
+				writeOpenAIStreamError(w, classifyError(err))
 			}
 		}
 	} else {
-		h.handleNonStreamCompletions(w, r, p, conv, nativeReq, model)
+		h.handleNonStreamCompletions(w, r, p, &openaiReq, nativeReq, model)
 	}
 }
 
-func (h *OpenAIHandler) handleNonStreamCompletions(w http.ResponseWriter, r *http.Request, p provider.Provider, conv converter.Converter, nativeReq interface{}, model string) {
-	resp, err := GenerateAndConvert(r.Context(), p, conv, nativeReq, model)
+// streamWithUsage streams the response from p with its usage chunk filled in where the upstream left it out
+func (h *OpenAIHandler) streamWithUsage(w http.ResponseWriter, r *http.Request, p provider.Provider, openaiReq *openai.ChatCompletionRequest, nativeReq interface{}, model string) error {
+	logger := h.logger.WithContext(r.Context())
+	chunks, err := openChunkStream(r.Context(), h.convFactory, p, nativeReq, model, logger)
 	if err != nil {
-		h.logger.ErrorLog("[Handler] GenerateAndConvert failed with %s: %v", p.Name(), err)
+		return err
+	}
+	h.factory.RecordSuccess(model, p.Name())
+	return relayChunks(r.Context(), w, h.convFactory, provider.ProtocolOpenAI, fillStreamUsage(chunks, openaiReq, logger), model, logger)
+}
+
+func (h *OpenAIHandler) handleNonStreamCompletions(w http.ResponseWriter, r *http.Request, p provider.Provider, openaiReq *openai.ChatCompletionRequest, nativeReq interface{}, model string) {
+	logger := h.logger.WithContext(r.Context())
+	resp, err := generateResponse(r.Context(), h.convFactory, p, nativeReq, model, logger)
+	if err != nil {
+		logger.ErrorLog("[Handler] Generation failed with %s: %v", p.Name(), err)
+		h.factory.RecordFailure(model, p.Name(), err)
 
 		// Try alternative if not a fixed provider request
 		if h.fixedProvider == "" {
-			if altProvider, altErr := h.factory.GetAlternativeProvider(model, p.Name()); altErr == nil {
-				h.logger.DebugLog("[Handler] Retrying with alternative %s", altProvider.Name())
-				altConv, _ := h.convFactory.Get(altProvider.Protocol())
-				if altResp, altErr := GenerateAndConvert(r.Context(), altProvider, altConv, nativeReq, model); altErr == nil {
+			if altProvider, altErr := h.factory.GetAlternativeProvider(model, p.Name()); altErr == nil && permitted(r, altProvider, model) {
+				logger.DebugLog("[Handler] Retrying with alternative %s", altProvider.Name())
+				metrics.Failovers.Inc(string(p.Name()), string(altProvider.Name()))
+				altResp, altErr := generateResponse(r.Context(), h.convFactory, altProvider, nativeReq, model, logger)
+				if altErr == nil {
 					h.factory.RecordSuccess(model, altProvider.Name())
+					reroute(r, altProvider)
+					fillUsage(openaiReq, altResp, logger)
 					w.Header().Set("Content-Type", "application/json")
 					json.NewEncoder(w).Encode(altResp)
 					return
 				}
+				h.factory.RecordFailure(model, altProvider.Name(), altErr)
 			}
 		}
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeOpenAIError(w, classifyError(err))
 		return
 	}
 
 	h.factory.RecordSuccess(model, p.Name())
+	fillUsage(openaiReq, resp, logger)
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(resp)
 }
 
+// fillUsage estimates token usage the upstream did not report
+func fillUsage(req *openai.ChatCompletionRequest, resp *openai.ChatCompletionResponse, logger *logging.Logger) {
+	if tokens.FillUsage(req, resp) {
+		logger.DebugLog("[Handler] Estimated missing usage for model %s: %d prompt, %d completion",
+			req.Model, resp.Usage.PromptTokens, resp.Usage.CompletionTokens)
+	}
+}
+
 // needsStreamConversion determines if a provider protocol needs stream format conversion
 func needsStreamConversion(protocol provider.ProtocolType) bool {
 	switch protocol {
@@ -241,4 +308,12 @@ func RegisterProviderSpecificRoutes(mux *http.ServeMux, factory *provider.Factor
 	mux.Handle("/kiro/v1/", NewProviderSpecificHandler(factory, convFactory, provider.ProviderKiro))
 	mux.Handle("/antigravity/v1/", NewProviderSpecificHandler(factory, convFactory, provider.ProviderAntigravity))
 	mux.Handle("/iflow/v1/", NewProviderSpecificHandler(factory, convFactory, provider.ProviderIFlow))
+
+	// Additional accounts are served under their own names
+	for _, providerType := range factory.ListTypes() {
+		p, err := factory.Get(providerType)
+		if err == nil && provider.TypeOf(p) != providerType {
+			mux.Handle("/"+string(providerType)+"/v1/", NewProviderSpecificHandler(factory, convFactory, providerType))
+		}
+	}
 }
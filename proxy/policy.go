@@ -0,0 +1,132 @@
+package proxy
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sunbankio/qwencoder-proxy/apikey"
+	"github.com/sunbankio/qwencoder-proxy/logging"
+	"github.com/sunbankio/qwencoder-proxy/provider"
+	"github.com/sunbankio/qwencoder-proxy/tokens"
+)
+
+// ApplyKeyPolicy enforces the policy of the key authenticated by RequireAPIKey.
+// Handlers check the allowed models and limits once they know the provider and model,
+// and the tokens an admitted request used are charged to its key when it completes.
+// Usage a response does not report, such as Kiro's, is charged as estimated from the
+// request and the generated text, so no route or stream escapes the limits.
+func ApplyKeyPolicy(limiter *apikey.Limiter, next http.Handler) http.Handler {
+	if limiter == nil {
+		return next
+	}
+	logger := logging.NewLogger("proxy")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scope, w, r := scoped(w, r)
+		if scope.key == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		scope.limiter = limiter
+		body := &capturedBody{ReadCloser: r.Body}
+		if r.Body != nil {
+			r.Body = body
+		}
+		next.ServeHTTP(w, r)
+		if !scope.admitted {
+			return
+		}
+
+		usage := chargedUsage(scope, body.data.Bytes())
+		logger := logger.WithContext(r.Context())
+		logger.DebugLog("[Policy] Key %s used %d input and %d output tokens", scope.key.Name(), usage.Input, usage.Output)
+		limiter.Record(scope.key, usage.Total(), time.Now())
+	})
+}
+
+// chargedUsage returns the usage to charge for an admitted request: the usage its
+// response reported, with counts it left out estimated from the request body and the
+// generated text. Error responses, which no upstream served, are not charged estimated
+// input.
+func chargedUsage(scope *requestScope, request []byte) tokenUsage {
+	usage := scope.meter.Usage()
+	estimator := tokens.ForModel(scope.model)
+	if usage.Input == 0 && scope.meter.Status() < http.StatusBadRequest {
+		var text strings.Builder
+		appendJSONStrings(&text, request)
+		usage.Input = int64(estimator.Count(text.String()))
+	}
+	if usage.Output == 0 {
+		usage.Output = int64(estimator.Count(scope.meter.Text()))
+	}
+	return usage
+}
+
+// capturedBody keeps up to maxMeteredBody bytes of a request body as the handler reads it
+type capturedBody struct {
+	io.ReadCloser
+	data bytes.Buffer
+}
+
+func (b *capturedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 && b.data.Len()+n <= maxMeteredBody {
+		b.data.Write(p[:n])
+	}
+	return n, err
+}
+
+// admit records the provider and model chosen for a generation request, then checks that
+// the request's key may use them and is within its limits. It writes a 403 or 429 in the
+// route's error format and returns false otherwise.
+func admit(w http.ResponseWriter, r *http.Request, p provider.Provider, model string) bool {
+	logging.SetRoute(r.Context(), string(p.Name()), model)
+	scope := scopeOf(r)
+	if scope == nil {
+		return true
+	}
+	scope.provider, scope.model = p, model
+	scope.trackUpstream(p.Name())
+	if scope.limiter == nil {
+		return true
+	}
+
+	err := scope.limiter.Allow(scope.key, string(p.Name()), model, time.Now())
+	if err == nil {
+		scope.admitted = true
+		return true
+	}
+
+	info := newErrorInfo(kindPermission, err.Error())
+	var limitErr *apikey.LimitError
+	if errors.As(err, &limitErr) {
+		wait := time.Until(limitErr.ResetAt)
+		info = newErrorInfo(kindRateLimit, err.Error()).withRetryAfter(wait)
+		setLimitHeaders(w, limitErr, wait)
+	}
+	writeProtocolError(w, routeProtocol(r.URL.Path), info)
+	return false
+}
+
+// permitted reports whether the request's key may use model on a provider, without
+// counting against its limits. Handlers use it before falling back to another provider.
+func permitted(r *http.Request, p provider.Provider, model string) bool {
+	scope := scopeOf(r)
+	return scope == nil || scope.key == nil || scope.key.Policy.Permits(string(p.Name()), model)
+}
+
+// setLimitHeaders describes an exhausted limit in the style of OpenAI's rate limit headers,
+// such as x-ratelimit-limit-requests and x-ratelimit-reset-requests
+func setLimitHeaders(w http.ResponseWriter, limitErr *apikey.LimitError, wait time.Duration) {
+	suffix := strings.ReplaceAll(limitErr.Limit, "_", "-")
+	reset := time.Duration(math.Ceil(max(wait, 0).Seconds())) * time.Second
+	w.Header().Set("X-RateLimit-Limit-"+suffix, strconv.FormatInt(limitErr.Max, 10))
+	w.Header().Set("X-RateLimit-Remaining-"+suffix, "0")
+	w.Header().Set("X-RateLimit-Reset-"+suffix, reset.String())
+}
@@ -0,0 +1,131 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sunbankio/qwencoder-proxy/apikey"
+	"github.com/sunbankio/qwencoder-proxy/config"
+	"github.com/sunbankio/qwencoder-proxy/converter"
+	"github.com/sunbankio/qwencoder-proxy/provider"
+)
+
+func TestParseUsage(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want tokenUsage
+	}{
+		{"openai", `{"usage":{"prompt_tokens":3,"completion_tokens":2,"total_tokens":5}}`, tokenUsage{Input: 3, Output: 2}},
+		{"anthropic message", `{"type":"message","usage":{"input_tokens":10,"output_tokens":4}}`, tokenUsage{Input: 10, Output: 4}},
+		{"anthropic message_start", `{"type":"message_start","message":{"usage":{"input_tokens":10,"output_tokens":1}}}`, tokenUsage{Input: 10, Output: 1}},
+		{"gemini", `{"usageMetadata":{"promptTokenCount":7,"candidatesTokenCount":3,"thoughtsTokenCount":5}}`, tokenUsage{Input: 7, Output: 8}},
+		{"gemini array", `[{"usageMetadata":{"promptTokenCount":7,"candidatesTokenCount":1}},{"usageMetadata":{"promptTokenCount":7,"candidatesTokenCount":3}}]`, tokenUsage{Input: 7, Output: 3}},
+		{"openai cached", `{"usage":{"prompt_tokens":30,"completion_tokens":2,"prompt_tokens_details":{"cached_tokens":24}}}`, tokenUsage{Input: 30, Output: 2, Cached: 24}},
+		{"anthropic cached", `{"usage":{"input_tokens":6,"cache_read_input_tokens":24,"output_tokens":2}}`, tokenUsage{Input: 6, Output: 2, Cached: 24}},
+		{"no usage", `{"choices":[]}`, tokenUsage{}},
+		{"not json", `[DONE]`, tokenUsage{}},
+	}
+	for _, tt := range tests {
+		if got := parseUsage([]byte(tt.data)); got != tt.want {
+			t.Errorf("%s: parseUsage() = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestKeyPolicy(t *testing.T) {
+	withStreamingConfig(t, config.StreamingConfig{})
+	factory := provider.NewFactory()
+	factory.Register(newIFlowFake(true))
+	limiter, _ := apikey.NewLimiter("")
+	key := &apikey.Key{Hash: apikey.Hash("sk-intern"), Label: "intern", Policy: apikey.Policy{
+		Providers:       []string{"iflow"},
+		Models:          []string{"fake-*"},
+		TokensPerMinute: 4,
+	}}
+	handler := RequireAPIKey(apikey.NewStore(key), ApplyKeyPolicy(limiter,
+		NewProviderSpecificHandler(factory, converter.NewFactory(), provider.ProviderIFlow)))
+
+	send := func(model string, stream bool) *httptest.ResponseRecorder {
+		body := `{"model":"` + model + `","stream":` + map[bool]string{true: "true", false: "false"}[stream] + `,"messages":[{"role":"user","content":"hi"}]}`
+		req := httptest.NewRequest(http.MethodPost, "/iflow/v1/chat/completions", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer sk-intern")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := send("claude-opus-4-1", false); rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), "permission_error") {
+		t.Errorf("disallowed model: status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+
+	// The stream reports 5 tokens, overdrawing the 4 tokens per minute
+	if rec := send("fake-model", true); rec.Code != http.StatusOK {
+		t.Fatalf("first request: status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	rec := send("fake-model", false)
+	if rec.Code != http.StatusTooManyRequests || !strings.Contains(rec.Body.String(), "rate_limit_exceeded") {
+		t.Fatalf("second request: status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	if rec.Header().Get("Retry-After") == "" || rec.Header().Get("X-RateLimit-Limit-tokens") != "4" || rec.Header().Get("X-RateLimit-Reset-tokens") == "" {
+		t.Errorf("missing reset hints: %v", rec.Header())
+	}
+}
+
+func TestKeyPolicyChargesEstimatedStreamUsage(t *testing.T) {
+	withStreamingConfig(t, config.StreamingConfig{})
+	fake := newIFlowFake(true)
+	withoutUsage := strings.Replace(openAIStreamBody, `,"usage":{"prompt_tokens":3,"completion_tokens":2,"total_tokens":5}`, "", 1)
+	fake.stream = func() io.ReadCloser { return io.NopCloser(strings.NewReader(withoutUsage)) }
+	factory := provider.NewFactory()
+	factory.Register(fake)
+	if err := factory.PopulateModelProviders(context.Background()); err != nil {
+		t.Fatalf("PopulateModelProviders() error = %v", err)
+	}
+	limiter, _ := apikey.NewLimiter("")
+	key := &apikey.Key{Hash: apikey.Hash("sk-intern"), Label: "intern", Policy: apikey.Policy{DailyTokens: 3}}
+	handler := RequireAPIKey(apikey.NewStore(key), ApplyKeyPolicy(limiter,
+		NewAnthropicHandler(factory, converter.NewFactory())))
+
+	send := func() *httptest.ResponseRecorder {
+		body := `{"model":"fake-model","max_tokens":100,"stream":true,"messages":[{"role":"user","content":"tell me something"}]}`
+		req := httptest.NewRequest(http.MethodPost, "/anthropic/messages", strings.NewReader(body))
+		req.Header.Set("x-api-key", "sk-intern")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// The stream reports no usage, so the estimate of the prompt and "hello world" is charged
+	if rec := send(); rec.Code != http.StatusOK {
+		t.Fatalf("first request: status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	if rec := send(); rec.Code != http.StatusTooManyRequests || rec.Header().Get("X-RateLimit-Limit-daily-tokens") != "3" {
+		t.Errorf("second request: status = %d, headers = %v, want the daily budget exhausted", rec.Code, rec.Header())
+	}
+}
+
+func TestAppendText(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{"openai message", `{"choices":[{"message":{"content":"hello","tool_calls":[{"function":{"name":"f","arguments":"{}"}}]}}]}`, "hello f{}"},
+		{"openai delta", `{"choices":[{"delta":{"reasoning_content":"hm"}}]}`, "hm"},
+		{"anthropic message", `{"content":[{"type":"text","text":"hello"},{"type":"tool_use","name":"f","input":{}}]}`, "hellof{}"},
+		{"anthropic delta", `{"type":"content_block_delta","delta":{"type":"input_json_delta","partial_json":"{\"a\""}}`, `{"a"`},
+		{"gemini", `{"candidates":[{"content":{"parts":[{"text":"hi"},{"functionCall":{"name":"f","args":{}}}]}}]}`, "hif{}"},
+	}
+	for _, tt := range tests {
+		var out strings.Builder
+		appendText(&out, []byte(tt.data))
+		if got := strings.ReplaceAll(out.String(), " ", ""); got != strings.ReplaceAll(tt.want, " ", "") {
+			t.Errorf("%s: appendText() = %q, want %q", tt.name, out.String(), tt.want)
+		}
+	}
+}
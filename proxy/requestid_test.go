@@ -0,0 +1,46 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sunbankio/qwencoder-proxy/logging"
+)
+
+func TestAssignRequestIDs(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		keep   bool
+	}{
+		{"client ID", "trace-42:abc", true},
+		{"no header", "", false},
+		{"unsafe characters", "id\nwith newline", false},
+		{"too long", strings.Repeat("a", maxRequestIDLength+1), false},
+	}
+	for _, tt := range tests {
+		var seen string
+		handler := AssignRequestIDs(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			seen = logging.RequestID(r.Context())
+		}))
+		req := httptest.NewRequest(http.MethodGet, "/v1/models", nil)
+		if tt.header != "" {
+			req.Header.Set(RequestIDHeader, tt.header)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		got := rec.Header().Get(RequestIDHeader)
+		if got != seen {
+			t.Errorf("%s: response ID %q differs from the logged ID %q", tt.name, got, seen)
+		}
+		if tt.keep && got != tt.header {
+			t.Errorf("%s: ID = %q, want the client's %q", tt.name, got, tt.header)
+		}
+		if !tt.keep && (len(got) != 32 || got == tt.header) {
+			t.Errorf("%s: ID = %q, want a generated ID", tt.name, got)
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package proxy
+
+import "net/http"
+
+// responseTracker records whether a response has started, so that an error
+// occurring mid-stream is not written over data already sent to the client
+type responseTracker struct {
+	http.ResponseWriter
+	started bool
+}
+
+// WriteHeader records that the response has started
+func (t *responseTracker) WriteHeader(code int) {
+	t.started = true
+	t.ResponseWriter.WriteHeader(code)
+}
+
+// Write records that the response has started
+func (t *responseTracker) Write(b []byte) (int, error) {
+	t.started = true
+	return t.ResponseWriter.Write(b)
+}
+
+// Flush forwards to the underlying writer if it supports flushing
+func (t *responseTracker) Flush() {
+	if f, ok := t.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap returns the underlying writer for http.ResponseController
+func (t *responseTracker) Unwrap() http.ResponseWriter {
+	return t.ResponseWriter
+}
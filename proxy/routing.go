@@ -0,0 +1,142 @@
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/sunbankio/qwencoder-proxy/logging"
+	"github.com/sunbankio/qwencoder-proxy/metrics"
+	"github.com/sunbankio/qwencoder-proxy/provider"
+)
+
+// RoutingHandler serves the admin endpoints that change how requests are routed
+type RoutingHandler struct {
+	factory *provider.Factory
+	logger  *logging.Logger
+}
+
+// NewRoutingHandler creates a handler changing the routing rules of factory
+func NewRoutingHandler(factory *provider.Factory) *RoutingHandler {
+	return &RoutingHandler{factory: factory, logger: logging.NewLogger("proxy")}
+}
+
+// routingReport is the body of the routing endpoints' responses
+type routingReport struct {
+	provider.RoutingRules
+	// UpstreamInFlight counts the requests each provider is serving, to tell when a
+	// draining provider is idle
+	UpstreamInFlight map[provider.ProviderType]int `json:"upstream_in_flight"`
+}
+
+// providerRuleUpdate is the body of PUT /admin/routing/providers/{name} and
+// /admin/routing/accounts/{account}. DisabledFor, a duration such as "30m", sets
+// disabled_until relative to now.
+type providerRuleUpdate struct {
+	provider.ProviderRule
+	DisabledFor string `json:"disabled_for,omitempty"`
+}
+
+// Rules serves GET /admin/routing with the routing rules
+func (h *RoutingHandler) Rules(w http.ResponseWriter, r *http.Request) {
+	report := routingReport{RoutingRules: h.factory.RoutingRules(), UpstreamInFlight: make(map[provider.ProviderType]int)}
+	for _, providerType := range h.factory.ListTypes() {
+		report.UpstreamInFlight[providerType] = int(metrics.UpstreamInFlight.Value(string(providerType)))
+	}
+	writeJSON(w, http.StatusOK, report)
+}
+
+// SetModelRule serves PUT /admin/routing/models/{model}, which pins a provider for the
+// model or sets the order its providers are tried in
+func (h *RoutingHandler) SetModelRule(w http.ResponseWriter, r *http.Request) {
+	var rule provider.ModelRule
+	if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+		writeOpenAIError(w, newErrorInfo(kindInvalidRequest, fmt.Sprintf("Invalid request body: %v", err)))
+		return
+	}
+	h.apply(w, r, h.factory.SetModelRule(r.PathValue("model"), rule))
+}
+
+// DeleteModelRule serves DELETE /admin/routing/models/{model}
+func (h *RoutingHandler) DeleteModelRule(w http.ResponseWriter, r *http.Request) {
+	h.apply(w, r, h.factory.DeleteModelRule(r.PathValue("model")))
+}
+
+// SetProviderRule serves PUT /admin/routing/providers/{name}, which disables or drains a provider
+func (h *RoutingHandler) SetProviderRule(w http.ResponseWriter, r *http.Request) {
+	rule, ok := decodeProviderRule(w, r)
+	if !ok {
+		return
+	}
+	h.apply(w, r, h.factory.SetProviderRule(provider.ProviderType(r.PathValue("name")), rule))
+}
+
+// DeleteProviderRule serves DELETE /admin/routing/providers/{name}, which removes the admin
+// rule of a provider so it is back in rotation, unless the config file takes it out
+func (h *RoutingHandler) DeleteProviderRule(w http.ResponseWriter, r *http.Request) {
+	h.apply(w, r, h.factory.DeleteProviderRule(provider.ProviderType(r.PathValue("name"))))
+}
+
+// SetAccountRule serves PUT /admin/routing/accounts/{account}, which disables or drains
+// the providers using an upstream account
+func (h *RoutingHandler) SetAccountRule(w http.ResponseWriter, r *http.Request) {
+	rule, ok := decodeProviderRule(w, r)
+	if !ok {
+		return
+	}
+	h.apply(w, r, h.factory.SetAccountRule(r.PathValue("account"), rule))
+}
+
+// DeleteAccountRule serves DELETE /admin/routing/accounts/{account}
+func (h *RoutingHandler) DeleteAccountRule(w http.ResponseWriter, r *http.Request) {
+	h.apply(w, r, h.factory.DeleteAccountRule(r.PathValue("account")))
+}
+
+// apply writes the outcome of a rule change: the new rules, or the error
+func (h *RoutingHandler) apply(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, provider.ErrRulesNotSaved) {
+		writeOpenAIError(w, newErrorInfo(kindInternal, err.Error()))
+		return
+	}
+	if err != nil {
+		writeOpenAIError(w, newErrorInfo(kindInvalidRequest, err.Error()))
+		return
+	}
+	h.logger.WithContext(r.Context()).InfoLog("[Admin] Routing rules changed by %s %s", r.Method, r.URL.Path)
+	h.Rules(w, r)
+}
+
+// decodeProviderRule reads a provider or account rule from the request body
+func decodeProviderRule(w http.ResponseWriter, r *http.Request) (provider.ProviderRule, bool) {
+	var update providerRuleUpdate
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		writeOpenAIError(w, newErrorInfo(kindInvalidRequest, fmt.Sprintf("Invalid request body: %v", err)))
+		return provider.ProviderRule{}, false
+	}
+	if update.DisabledFor != "" {
+		d, err := time.ParseDuration(update.DisabledFor)
+		if err != nil || d <= 0 {
+			writeOpenAIError(w, newErrorInfo(kindInvalidRequest, fmt.Sprintf("Invalid disabled_for %q: want a positive duration such as 30m", update.DisabledFor)).withParam("disabled_for"))
+			return provider.ProviderRule{}, false
+		}
+		until := time.Now().Add(d)
+		update.Disabled = true
+		update.DisabledUntil = &until
+	}
+	return update.ProviderRule, true
+}
+
+// RegisterRoutingRoutes registers the routing admin endpoints, which require an admin key
+func RegisterRoutingRoutes(mux *http.ServeMux, factory *provider.Factory) {
+	h := NewRoutingHandler(factory)
+	admin := func(handler http.HandlerFunc) http.Handler { return RequireAdmin(handler) }
+	mux.Handle("GET /admin/routing", admin(h.Rules))
+	mux.Handle("PUT /admin/routing/models/{model...}", admin(h.SetModelRule))
+	mux.Handle("DELETE /admin/routing/models/{model...}", admin(h.DeleteModelRule))
+	mux.Handle("PUT /admin/routing/providers/{name}", admin(h.SetProviderRule))
+	mux.Handle("DELETE /admin/routing/providers/{name}", admin(h.DeleteProviderRule))
+	mux.Handle("PUT /admin/routing/accounts/{account...}", admin(h.SetAccountRule))
+	mux.Handle("DELETE /admin/routing/accounts/{account...}", admin(h.DeleteAccountRule))
+}
@@ -0,0 +1,184 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sunbankio/qwencoder-proxy/config"
+	"github.com/sunbankio/qwencoder-proxy/converter"
+	"github.com/sunbankio/qwencoder-proxy/provider"
+	"github.com/sunbankio/qwencoder-proxy/provider/kiro"
+)
+
+func TestRoutingRoutes(t *testing.T) {
+	withStreamingConfig(t, config.StreamingConfig{})
+	factory := provider.NewFactory()
+	factory.Register(newIFlowFake(false))
+	if err := factory.PopulateModelProviders(context.Background()); err != nil {
+		t.Fatalf("PopulateModelProviders() error = %v", err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/v1/", NewOpenAIHandler(factory, converter.NewFactory()))
+	RegisterRoutingRoutes(mux, factory)
+
+	send := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.RemoteAddr = "127.0.0.1:4000"
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+	chat := `{"model":"fake-model","messages":[{"role":"user","content":"hi"}]}`
+
+	rec := send(http.MethodPut, "/admin/routing/providers/iflow", `{"disabled_for":"30m"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("disable status = %d, want 200; body = %s", rec.Code, rec.Body)
+	}
+	var report routingReport
+	if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+		t.Fatalf("decode rules: %v", err)
+	}
+	rule := report.Providers[provider.ProviderIFlow]
+	if !rule.Disabled || rule.DisabledUntil == nil || time.Until(*rule.DisabledUntil) < 29*time.Minute {
+		t.Errorf("iflow rule = %+v, want disabled for 30 minutes", rule)
+	}
+	if rec := send(http.MethodPost, "/v1/chat/completions", chat); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("chat with the only provider disabled status = %d, want 503", rec.Code)
+	}
+
+	if rec := send(http.MethodDelete, "/admin/routing/providers/iflow", ""); rec.Code != http.StatusOK {
+		t.Errorf("enable status = %d, want 200", rec.Code)
+	}
+	if rec := send(http.MethodPost, "/v1/chat/completions", chat); rec.Code != http.StatusOK {
+		t.Errorf("chat after enabling status = %d, want 200; body = %s", rec.Code, rec.Body)
+	}
+
+	if rec := send(http.MethodPut, "/admin/routing/providers/iflow", `{"disabled_for":"soon"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid disabled_for status = %d, want 400", rec.Code)
+	}
+	if rec := send(http.MethodPut, "/admin/routing/models/fake-model", `{"order":["nope"]}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("order with an unknown provider status = %d, want 400", rec.Code)
+	}
+}
+
+// accountFake is a fakeProvider registered as an additional account of iflow
+type accountFake struct{ *fakeProvider }
+
+func (a accountFake) Type() provider.ProviderType { return provider.ProviderIFlow }
+
+func TestAccountRoutes(t *testing.T) {
+	withStreamingConfig(t, config.StreamingConfig{})
+	account := newIFlowFake(false)
+	account.name = "iflow-work"
+	factory := provider.NewFactory()
+	factory.Register(newIFlowFake(false))
+	factory.Register(accountFake{account})
+	mux := http.NewServeMux()
+	RegisterProviderSpecificRoutes(mux, factory, converter.NewFactory())
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/iflow-work/v1/models", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "fake-model") {
+		t.Errorf("models status = %d, want 200 listing fake-model; body = %s", rec.Code, rec.Body)
+	}
+
+	chat := `{"model":"fake-model","messages":[{"role":"user","content":"hi"}]}`
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/iflow-work/v1/chat/completions", strings.NewReader(chat)))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "hello world") {
+		t.Errorf("chat status = %d, want 200; body = %s", rec.Code, rec.Body)
+	}
+}
+
+func TestAnthropicRoutesFollowRoutingRules(t *testing.T) {
+	withStreamingConfig(t, config.StreamingConfig{})
+	factory := provider.NewFactory()
+	factory.Register(&fakeProvider{
+		name:     provider.ProviderKiro,
+		protocol: provider.ProtocolClaude,
+		response: &kiro.ClaudeResponse{ID: "msg_1", Type: "message", Role: "assistant", Model: "fake-model",
+			Content: []kiro.ContentBlock{{Type: "text", Text: "from kiro"}}, StopReason: "end_turn"},
+	})
+	factory.Register(newIFlowFake(false))
+	if err := factory.PopulateModelProviders(context.Background()); err != nil {
+		t.Fatalf("PopulateModelProviders() error = %v", err)
+	}
+	if err := factory.SetModelRule("fake-model", provider.ModelRule{Pin: "kiro"}); err != nil {
+		t.Fatalf("SetModelRule() error = %v", err)
+	}
+	handler := NewAnthropicHandler(factory, converter.NewFactory())
+	send := func() *httptest.ResponseRecorder {
+		body := `{"model":"fake-model","max_tokens":100,"messages":[{"role":"user","content":"hi"}]}`
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/anthropic/messages", strings.NewReader(body)))
+		return rec
+	}
+
+	if rec := send(); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "from kiro") {
+		t.Fatalf("pinned: status = %d, body = %s; want kiro's answer", rec.Code, rec.Body)
+	}
+	for _, rule := range []provider.ProviderRule{{Draining: true}, {Disabled: true}} {
+		if err := factory.SetProviderRule(provider.ProviderKiro, rule); err != nil {
+			t.Fatalf("SetProviderRule() error = %v", err)
+		}
+		if rec := send(); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "hello world") {
+			t.Errorf("kiro %+v: status = %d, body = %s; want iflow's answer", rule, rec.Code, rec.Body)
+		}
+	}
+
+	if err := factory.SetProviderRule(provider.ProviderIFlow, provider.ProviderRule{Disabled: true}); err != nil {
+		t.Fatalf("SetProviderRule() error = %v", err)
+	}
+	if rec := send(); rec.Code != http.StatusServiceUnavailable || !strings.Contains(rec.Body.String(), "disabled") {
+		t.Errorf("all disabled: status = %d, body = %s; want 503", rec.Code, rec.Body)
+	}
+}
+
+func TestNativeRoutesServeAccounts(t *testing.T) {
+	withStreamingConfig(t, config.StreamingConfig{})
+	factory := provider.NewFactory()
+	factory.Register(&fakeProvider{
+		name:     "kiro-work",
+		protocol: provider.ProtocolClaude,
+		response: &kiro.ClaudeResponse{ID: "msg_1", Type: "message", Role: "assistant", Model: "fake-model",
+			Content: []kiro.ContentBlock{{Type: "text", Text: "from kiro-work"}}, StopReason: "end_turn"},
+	})
+	factory.Register(newIFlowFake(false))
+	if err := factory.PopulateModelProviders(context.Background()); err != nil {
+		t.Fatalf("PopulateModelProviders() error = %v", err)
+	}
+	if err := factory.SetModelRule("fake-model", provider.ModelRule{Order: []provider.ProviderType{"kiro-work", provider.ProviderIFlow}}); err != nil {
+		t.Fatalf("SetModelRule() error = %v", err)
+	}
+	// Neither kiro nor gemini-cli is registered, as when the config file disables them
+	mux := http.NewServeMux()
+	RegisterAnthropicRoutes(mux, factory, converter.NewFactory())
+	RegisterGeminiRoutes(mux, factory, converter.NewFactory())
+
+	rec := httptest.NewRecorder()
+	body := `{"model":"fake-model","max_tokens":100,"messages":[{"role":"user","content":"hi"}]}`
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/anthropic/messages", strings.NewReader(body)))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "from kiro-work") {
+		t.Errorf("messages status = %d, body = %s; want the kiro-work account's answer", rec.Code, rec.Body)
+	}
+
+	rec = httptest.NewRecorder()
+	body = `{"contents":[{"role":"user","parts":[{"text":"hi"}]}]}`
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/gemini/models/fake-model:generateContent", strings.NewReader(body)))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "from kiro-work") {
+		t.Errorf("generateContent status = %d, body = %s; want the kiro-work account's answer", rec.Code, rec.Body)
+	}
+
+	for _, path := range []string{"/anthropic/models", "/gemini/models"} {
+		rec = httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"fake-model"`) {
+			t.Errorf("GET %s status = %d, body = %s; want the catalog", path, rec.Code, rec.Body)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/sunbankio/qwencoder-proxy/apikey"
+	"github.com/sunbankio/qwencoder-proxy/logging"
+	"github.com/sunbankio/qwencoder-proxy/metrics"
+	"github.com/sunbankio/qwencoder-proxy/provider"
+)
+
+// requestScope collects what handlers learn about a request for the middlewares that
+// enforce key policies and record usage around them
+type requestScope struct {
+	start   time.Time
+	key     *apikey.Key // nil when authentication is disabled
+	meter   *usageMeter
+	limiter *apikey.Limiter // Set by ApplyKeyPolicy
+
+	observed bool   // Set by ObserveRequests, which exports request metrics
+	upstream string // Provider counted in the upstream in-flight gauge
+
+	// Set by admit once the handler has chosen a provider for a generation request
+	provider provider.Provider
+	model    string
+	admitted bool
+}
+
+type requestScopeKey struct{}
+
+// scoped returns the scope of a request. The first middleware to call it creates the
+// scope and a usageMeter around the response; later calls return the same ones.
+func scoped(w http.ResponseWriter, r *http.Request) (*requestScope, http.ResponseWriter, *http.Request) {
+	if scope := scopeOf(r); scope != nil {
+		return scope, w, r
+	}
+	scope := &requestScope{
+		start: time.Now(),
+		key:   apikey.FromContext(r.Context()),
+		meter: &usageMeter{ResponseWriter: w},
+	}
+	return scope, scope.meter, r.WithContext(context.WithValue(r.Context(), requestScopeKey{}, scope))
+}
+
+// scopeOf returns the scope of a request, or nil outside the middlewares
+func scopeOf(r *http.Request) *requestScope {
+	scope, _ := r.Context().Value(requestScopeKey{}).(*requestScope)
+	return scope
+}
+
+// account returns the upstream account of the chosen provider, if it reports one
+func (s *requestScope) account() string {
+	if reporter, ok := s.provider.(provider.AccountReporter); ok {
+		return reporter.Account()
+	}
+	return ""
+}
+
+// reroute records that the request was served by another provider after a failure
+func reroute(r *http.Request, p provider.Provider) {
+	if scope := scopeOf(r); scope != nil {
+		logging.SetRoute(r.Context(), string(p.Name()), scope.model)
+		scope.provider = p
+		scope.trackUpstream(p.Name())
+	}
+}
+
+// trackUpstream moves the request in the upstream in-flight gauge to a provider, or
+// removes it when providerType is empty
+func (s *requestScope) trackUpstream(providerType provider.ProviderType) {
+	if !s.observed {
+		return
+	}
+	if s.upstream != "" {
+		metrics.UpstreamInFlight.Add(-1, s.upstream)
+	}
+	s.upstream = string(providerType)
+	if s.upstream != "" {
+		metrics.UpstreamInFlight.Add(1, s.upstream)
+	}
+}
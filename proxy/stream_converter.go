@@ -1,27 +1,34 @@
 package proxy
 
 import (
-	"bufio"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
-	"strings"
 
 	"github.com/sunbankio/qwencoder-proxy/converter"
 	"github.com/sunbankio/qwencoder-proxy/logging"
+	"github.com/sunbankio/qwencoder-proxy/openai"
 	"github.com/sunbankio/qwencoder-proxy/provider"
+	"github.com/sunbankio/qwencoder-proxy/sse"
 )
 
+// ErrMalformedStream is returned when an upstream stream exceeds the malformed chunk budget
+var ErrMalformedStream = errors.New("upstream stream sent too many malformed chunks")
+
 // StreamConverter wraps a native provider stream and converts it to OpenAI SSE format
 type StreamConverter struct {
 	stream    io.ReadCloser
 	converter converter.Converter
 	model     string
 	logger    *logging.Logger
-	scanner   *bufio.Scanner
+	reader    *sse.Reader
 	done      bool
 	sentData  bool
+	pending   []byte // Converted bytes not yet returned to the reader
+	malformed int    // Chunks that could not be parsed or converted
+	maxErrors int    // Malformed chunks tolerated before aborting; 0 means unlimited
 }
 
 // NewStreamConverter creates a new stream converter
@@ -31,38 +38,34 @@ func NewStreamConverter(stream io.ReadCloser, conv converter.Converter, model st
 		converter: conv,
 		model:     model,
 		logger:    logger,
-		scanner:   bufio.NewScanner(stream),
+		reader:    sse.NewReader(stream, streamingConfig.MaxEventSize),
+		maxErrors: streamingConfig.MaxErrors,
 	}
 }
 
 // Read implements io.Reader interface
 func (sc *StreamConverter) Read(p []byte) (n int, err error) {
-	if sc.done {
-		return 0, io.EOF
-	}
+	if len(sc.pending) == 0 {
+		if sc.done {
+			return 0, io.EOF
+		}
 
-	// Try to read the next chunk from the SSE stream
-	chunk, err := sc.readNextSSEChunk()
-	if err != nil {
+		// Try to read the next chunk from the SSE stream
+		chunk, err := sc.readNextSSEChunk()
 		if err == io.EOF {
 			// Send final [DONE] message
-			doneMsg := "data: [DONE]\n\n"
-			if len(p) < len(doneMsg) {
-				return 0, fmt.Errorf("buffer too small for DONE message")
-			}
-			copy(p, []byte(doneMsg))
+			chunk = sse.AppendEvent(nil, &sse.Event{Data: "[DONE]"})
 			sc.done = true
-			return len(doneMsg), nil
+		} else if err != nil {
+			return 0, err
 		}
-		return 0, err
-	}
-
-	if len(p) < len(chunk) {
-		return 0, fmt.Errorf("buffer too small for chunk")
+		sc.pending = chunk
 	}
 
-	copy(p, chunk)
-	return len(chunk), nil
+	// Chunks larger than p are returned over several reads
+	n = copy(p, sc.pending)
+	sc.pending = sc.pending[n:]
+	return n, nil
 }
 
 // Close implements io.Closer interface
@@ -70,79 +73,108 @@ func (sc *StreamConverter) Close() error {
 	return sc.stream.Close()
 }
 
-// readNextSSEChunk reads and converts the next SSE chunk from the native stream
+// recordMalformed counts a chunk that could not be used and reports whether the budget is exhausted
+func (sc *StreamConverter) recordMalformed(reason string, err error) error {
+	sc.malformed++
+	sc.logger.DebugLog("[StreamConverter] %s (%d malformed): %v", reason, sc.malformed, err)
+	if sc.maxErrors > 0 && sc.malformed > sc.maxErrors {
+		sc.logger.ErrorLog("[StreamConverter] Aborting stream for %s after %d malformed chunks, last: %v", sc.model, sc.malformed, err)
+		return fmt.Errorf("%w: %d malformed chunks, last: %v", ErrMalformedStream, sc.malformed, err)
+	}
+	return nil
+}
+
+// readNextSSEChunk reads and converts the next SSE event from the native stream
 func (sc *StreamConverter) readNextSSEChunk() ([]byte, error) {
-	var buffer []string
-	
-	for sc.scanner.Scan() {
-		line := sc.scanner.Text()
-		
-		if strings.HasPrefix(line, "data: ") {
-			// Extract the JSON data after "data: "
-			buffer = append(buffer, line[6:])
-		} else if line == "" && len(buffer) > 0 {
-			// Empty line indicates end of SSE event, process the buffered data
-			jsonData := strings.Join(buffer, "\n")
-			sc.logger.DebugLog("[StreamConverter] Processing SSE chunk: %s", jsonData)
-			
-			// Parse the JSON data
-			var nativeChunk interface{}
-			if err := json.Unmarshal([]byte(jsonData), &nativeChunk); err != nil {
-				sc.logger.DebugLog("[StreamConverter] Failed to parse SSE chunk: %v", err)
-				buffer = []string{} // Clear buffer and continue
-				continue
-			}
+	for {
+		chunk, err := sc.NextChunk()
+		if err != nil {
+			return nil, err
+		}
 
-			// Extract the actual response from the wrapper (for Gemini/Antigravity format)
-			var nativeResp interface{}
-			if responseMap, ok := nativeChunk.(map[string]interface{}); ok {
-				if response, hasResponse := responseMap["response"]; hasResponse {
-					// Gemini/Antigravity format: {"response": {...}, "traceId": "..."}
-					nativeResp = response
-				} else {
-					// Direct format
-					nativeResp = nativeChunk
-				}
-			} else {
-				nativeResp = nativeChunk
+		// Marshal to JSON
+		chunkBytes, err := json.Marshal(chunk)
+		if err != nil {
+			if abortErr := sc.recordMalformed("Failed to marshal chunk", err); abortErr != nil {
+				return nil, abortErr
 			}
+			continue
+		}
 
-			sc.logger.DebugLog("[StreamConverter] Extracted native response: %+v", nativeResp)
+		// Format as SSE
+		sc.sentData = true
+		return sse.AppendEvent(make([]byte, 0, len(chunkBytes)+8), &sse.Event{Data: string(chunkBytes)}), nil
+	}
+}
 
-			// Convert to OpenAI format
-			openAIChunk, err := sc.converter.ToOpenAIStreamChunk(nativeResp, sc.model)
-			if err != nil {
-				sc.logger.DebugLog("[StreamConverter] Failed to convert chunk: %v", err)
-				buffer = []string{} // Clear buffer and continue
-				continue
-			}
+// NextChunk reads and converts the next event from the native stream.
+// It returns io.EOF when the upstream stream ends.
+func (sc *StreamConverter) NextChunk() (*openai.ChatCompletionChunk, error) {
+	for {
+		event, err := sc.reader.Next()
+		if err == io.EOF {
+			sc.logger.DebugLog("[StreamConverter] Stream ended")
+			return nil, io.EOF
+		}
+		if err != nil {
+			sc.logger.DebugLog("[StreamConverter] Read error: %v", err)
+			return nil, err
+		}
+		sc.logger.DebugLog("[StreamConverter] Processing SSE event %q: %s", event.Type, event.Data)
 
-			sc.logger.DebugLog("[StreamConverter] Converted chunk: %+v", openAIChunk)
+		// OpenAI-style upstreams terminate with [DONE]; the converter emits its own
+		if event.Data == "[DONE]" {
+			return nil, io.EOF
+		}
 
-			// Marshal to JSON
-			chunkBytes, err := json.Marshal(openAIChunk)
-			if err != nil {
-				sc.logger.DebugLog("[StreamConverter] Failed to marshal chunk: %v", err)
-				buffer = []string{} // Clear buffer and continue
-				continue
+		// Gemini/Antigravity wrap each chunk as {"response": {...}, "traceId": "..."}.
+		// The native payload stays raw JSON so the converter decodes it only once.
+		data := []byte(event.Data)
+		var wrapper struct {
+			Response json.RawMessage `json:"response"`
+			Error    json.RawMessage `json:"error"`
+		}
+		if err := json.Unmarshal(data, &wrapper); err != nil {
+			if event.Type == "error" {
+				return nil, &provider.APIError{StatusCode: http.StatusBadGateway, Body: event.Data}
 			}
+			if abortErr := sc.recordMalformed("Failed to parse SSE chunk", err); abortErr != nil {
+				return nil, abortErr
+			}
+			continue
+		}
+		if event.Type == "error" || (len(wrapper.Error) > 0 && string(wrapper.Error) != "null") {
+			// The upstream reported a terminal error in-band
+			return nil, streamPayloadError(data, wrapper.Error)
+		}
+		nativeResp := json.RawMessage(data)
+		if len(wrapper.Response) > 0 {
+			nativeResp = wrapper.Response
+		}
 
-			// Format as SSE
-			sseChunk := fmt.Sprintf("data: %s\n\n", string(chunkBytes))
-			sc.logger.DebugLog("[StreamConverter] Generated SSE chunk: %s", sseChunk)
-			
-			buffer = []string{} // Clear buffer for next event
-			return []byte(sseChunk), nil
+		// Convert to OpenAI format
+		openAIChunk, err := sc.converter.ToOpenAIStreamChunk(nativeResp, sc.model)
+		if err != nil {
+			if abortErr := sc.recordMalformed("Failed to convert chunk", err); abortErr != nil {
+				return nil, abortErr
+			}
+			continue
 		}
+		return openAIChunk, nil
 	}
+}
 
-	if err := sc.scanner.Err(); err != nil {
-		sc.logger.DebugLog("[StreamConverter] Scanner error: %v", err)
-		return nil, err
+// streamPayloadError converts an in-band {"error": {...}} stream payload into an upstream API error
+func streamPayloadError(payload, errField json.RawMessage) error {
+	var detail struct {
+		Code int `json:"code"`
 	}
-
-	sc.logger.DebugLog("[StreamConverter] Stream ended")
-	return nil, io.EOF
+	json.Unmarshal(errField, &detail)
+	status := detail.Code
+	if status < 400 || status > 599 {
+		status = http.StatusBadGateway
+	}
+	return &provider.APIError{StatusCode: status, Body: string(payload)}
 }
 
 // ConvertedStreamResponse handles streaming with format conversion
@@ -172,5 +204,5 @@ func ConvertedStreamResponse(w http.ResponseWriter, r *http.Request, factory *pr
 	SetStreamingHeaders(w)
 
 	// Copy converted stream to response
-	return CopyStreamToResponse(w, convertedStream, logger)
-}
\ No newline at end of file
+	return copyTracedStream(ctx, w, convertedStream, model, logger)
+}
@@ -0,0 +1,249 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/sunbankio/qwencoder-proxy/config"
+	"github.com/sunbankio/qwencoder-proxy/converter"
+	"github.com/sunbankio/qwencoder-proxy/logging"
+	"github.com/sunbankio/qwencoder-proxy/provider"
+	"github.com/sunbankio/qwencoder-proxy/sse"
+)
+
+const geminiChunk = `data: {"response":{"candidates":[{"content":{"role":"model","parts":[{"text":"hello"}]}}]}}` + "\n\n"
+
+// fakeProvider serves canned responses for handler tests
+type fakeProvider struct {
+	name     provider.ProviderType
+	protocol provider.ProtocolType
+	stream   func() io.ReadCloser // nil reports provider.ErrStreamingUnsupported
+	response interface{}          // returned by GenerateContent when set
+}
+
+func (f *fakeProvider) Name() provider.ProviderType              { return f.name }
+func (f *fakeProvider) Protocol() provider.ProtocolType          { return f.protocol }
+func (f *fakeProvider) SupportedModels() []string                { return []string{"fake-model"} }
+func (f *fakeProvider) SupportsModel(model string) bool          { return model == "fake-model" }
+func (f *fakeProvider) GetAuthenticator() provider.Authenticator { return nil }
+func (f *fakeProvider) IsHealthy(ctx context.Context) bool       { return true }
+
+func (f *fakeProvider) ListModels(ctx context.Context) (interface{}, error) {
+	return map[string]interface{}{"data": []interface{}{map[string]interface{}{"id": "fake-model"}}}, nil
+}
+
+func (f *fakeProvider) GenerateContent(ctx context.Context, model string, request interface{}) (interface{}, error) {
+	if f.response == nil {
+		return nil, errors.New("not implemented")
+	}
+	return f.response, nil
+}
+
+func (f *fakeProvider) GenerateContentStream(ctx context.Context, model string, request interface{}) (io.ReadCloser, error) {
+	if f.stream == nil {
+		return nil, provider.ErrStreamingUnsupported
+	}
+	return f.stream(), nil
+}
+
+// failingReader returns err once its data is exhausted
+type failingReader struct {
+	r   io.Reader
+	err error
+}
+
+func (f *failingReader) Read(p []byte) (int, error) {
+	n, err := f.r.Read(p)
+	if err == io.EOF {
+		return n, f.err
+	}
+	return n, err
+}
+
+func newGeminiStreamConverter(t *testing.T, body string) *StreamConverter {
+	t.Helper()
+	conv, err := converter.NewFactory().Get(provider.ProtocolGemini)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	return NewStreamConverter(io.NopCloser(strings.NewReader(body)), conv, "gemini-2.5-pro", logging.NewLogger("proxy"))
+}
+
+func withStreamingConfig(t *testing.T, cfg config.StreamingConfig) {
+	t.Helper()
+	previous := streamingConfig
+	ConfigureStreaming(cfg)
+	t.Cleanup(func() { streamingConfig = previous })
+}
+
+func TestStreamConverterSmallReads(t *testing.T) {
+	sc := newGeminiStreamConverter(t, geminiChunk+geminiChunk)
+
+	var out strings.Builder
+	buf := make([]byte, 7)
+	for {
+		n, err := sc.Read(buf)
+		out.Write(buf[:n])
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Read() error = %v", err)
+		}
+	}
+
+	got := out.String()
+	if strings.Count(got, `"content":"hello"`) != 2 || !strings.HasSuffix(got, "data: [DONE]\n\n") {
+		t.Errorf("unexpected stream output: %s", got)
+	}
+}
+
+func TestStreamConverterMalformedBudget(t *testing.T) {
+	withStreamingConfig(t, config.StreamingConfig{MaxErrors: 2, BufferSize: 4096})
+
+	malformed := "data: {not json\n\n"
+	sc := newGeminiStreamConverter(t, geminiChunk+malformed+malformed+malformed+geminiChunk)
+
+	out, err := io.ReadAll(sc)
+	if !errors.Is(err, ErrMalformedStream) {
+		t.Fatalf("ReadAll() error = %v, want ErrMalformedStream", err)
+	}
+	if strings.Count(string(out), `"content":"hello"`) != 1 {
+		t.Errorf("expected the chunk before the bad ones to be delivered, got %s", out)
+	}
+}
+
+func TestStreamConverterToleratesMalformedWithinBudget(t *testing.T) {
+	withStreamingConfig(t, config.StreamingConfig{MaxErrors: 2, BufferSize: 4096})
+
+	sc := newGeminiStreamConverter(t, geminiChunk+"data: {oops\n\n"+geminiChunk)
+	out, err := io.ReadAll(sc)
+	if err != nil {
+		t.Fatalf("ReadAll() error = %v", err)
+	}
+	if strings.Count(string(out), `"content":"hello"`) != 2 {
+		t.Errorf("unexpected stream output: %s", out)
+	}
+}
+
+func TestStreamConverterInBandError(t *testing.T) {
+	sc := newGeminiStreamConverter(t, geminiChunk+`data: {"error":{"code":429,"message":"Resource exhausted","status":"RESOURCE_EXHAUSTED"}}`+"\n\n")
+
+	_, err := io.ReadAll(sc)
+	var apiErr *provider.APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != 429 {
+		t.Fatalf("ReadAll() error = %v, want APIError 429", err)
+	}
+	if apiErr.Message() != "Resource exhausted" {
+		t.Errorf("Message() = %q", apiErr.Message())
+	}
+}
+
+func TestOpenAIHandlerReportsMidStreamError(t *testing.T) {
+	fake := &fakeProvider{
+		name:     provider.ProviderIFlow,
+		protocol: provider.ProtocolOpenAI,
+		stream: func() io.ReadCloser {
+			return io.NopCloser(&failingReader{
+				r:   strings.NewReader(`data: {"choices":[{"delta":{"content":"hi"}}]}` + "\n\n"),
+				err: &provider.APIError{StatusCode: 503, Body: `{"error":{"message":"upstream overloaded"}}`},
+			})
+		},
+	}
+	factory := provider.NewFactory()
+	factory.Register(fake)
+	handler := NewProviderSpecificHandler(factory, converter.NewFactory(), provider.ProviderIFlow)
+
+	body := `{"model":"fake-model","stream":true,"messages":[{"role":"user","content":"hi"}]}`
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/iflow/v1/chat/completions", strings.NewReader(body)))
+
+	out := rec.Body.String()
+	if !strings.Contains(out, `"content":"hi"`) {
+		t.Errorf("stream data missing: %s", out)
+	}
+	if !strings.Contains(out, "event: error\ndata: ") || !strings.Contains(out, "upstream overloaded") {
+		t.Errorf("error event missing: %s", out)
+	}
+	if strings.Contains(out, "[DONE]") {
+		t.Errorf("stream should not complete normally after an error: %s", out)
+	}
+}
+
+// streamFixtures are recorded upstream streams shared with the sse package tests
+var streamFixtures = []string{
+	"../sse/testdata/gemini_stream.sse",
+	"../sse/testdata/antigravity_stream.sse",
+}
+
+func TestStreamConverterFixtures(t *testing.T) {
+	tests := []struct {
+		fixture string
+		want    []string
+	}{
+		{streamFixtures[0], []string{`"content":"Here is"`, `a haiku`, `"finish_reason":"stop"`}},
+		{streamFixtures[1], []string{`"content":"Let me check the weather."`, `"name":"get_weather"`}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			data, err := os.ReadFile(tt.fixture)
+			if err != nil {
+				t.Fatalf("ReadFile() error = %v", err)
+			}
+			out, err := io.ReadAll(newGeminiStreamConverter(t, string(data)))
+			if err != nil {
+				t.Fatalf("ReadAll() error = %v", err)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(string(out), want) {
+					t.Errorf("output missing %s:\n%s", want, out)
+				}
+			}
+			if !strings.HasSuffix(string(out), "data: [DONE]\n\n") {
+				t.Errorf("output not terminated with [DONE]")
+			}
+		})
+	}
+}
+
+func FuzzStreamConverter(f *testing.F) {
+	for _, path := range streamFixtures {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			f.Fatalf("ReadFile() error = %v", err)
+		}
+		f.Add(data)
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		sc := newGeminiStreamConverter(t, string(data))
+		out, err := io.ReadAll(iotest.OneByteReader(sc))
+		if err != nil {
+			return
+		}
+		// Every emitted event must be valid SSE carrying JSON or the terminator
+		reader := sse.NewReader(bytes.NewReader(out), 0)
+		for {
+			ev, err := reader.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("converter emitted invalid SSE: %v", err)
+			}
+			if ev.Data != "[DONE]" && !json.Valid([]byte(ev.Data)) {
+				t.Fatalf("converter emitted invalid JSON: %s", ev.Data)
+			}
+		}
+	})
+}
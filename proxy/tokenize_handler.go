@@ -0,0 +1,60 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/sunbankio/qwencoder-proxy/openai"
+	"github.com/sunbankio/qwencoder-proxy/tokens"
+)
+
+// TokenizeRequest is the body of POST /v1/tokenize.
+// Either Messages (optionally with Tools) or Prompt is counted.
+type TokenizeRequest struct {
+	Model    string           `json:"model"`
+	Messages []openai.Message `json:"messages,omitempty"`
+	Tools    []openai.Tool    `json:"tools,omitempty"`
+	Prompt   string           `json:"prompt,omitempty"`
+}
+
+// TokenizeResponse reports the estimated token count of a tokenize request
+type TokenizeResponse struct {
+	Object    string        `json:"object"`
+	Model     string        `json:"model"`
+	Family    tokens.Family `json:"family"`
+	Count     int           `json:"count"`
+	Estimated bool          `json:"estimated"`
+}
+
+// handleTokenize handles POST /v1/tokenize
+func (h *OpenAIHandler) handleTokenize(w http.ResponseWriter, r *http.Request) {
+	var req TokenizeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.logger.WithContext(r.Context()).ErrorLog("[Handler] Failed to decode tokenize request: %v", err)
+		writeOpenAIError(w, newErrorInfo(kindInvalidRequest, fmt.Sprintf("Invalid request body: %v", err)))
+		return
+	}
+	req.Model = h.factory.ResolveModel(req.Model)
+	if req.Model == "" {
+		writeOpenAIError(w, newErrorInfo(kindInvalidRequest, "Model is required").withParam("model"))
+		return
+	}
+
+	estimator := tokens.ForModel(req.Model)
+	var count int
+	if len(req.Messages) > 0 {
+		count = tokens.CountMessages(estimator, req.Messages) + tokens.CountTools(estimator, req.Tools)
+	} else {
+		count = estimator.Count(req.Prompt)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(TokenizeResponse{
+		Object:    "tokenize",
+		Model:     req.Model,
+		Family:    estimator.Family(),
+		Count:     count,
+		Estimated: true,
+	})
+}
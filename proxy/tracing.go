@@ -0,0 +1,91 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/sunbankio/qwencoder-proxy/logging"
+	"github.com/sunbankio/qwencoder-proxy/tracing"
+)
+
+// TraceRequests records a server span for every request, continuing the trace of a
+// client that sends a W3C traceparent header
+func TraceRequests(next http.Handler) http.Handler {
+	logger := logging.NewLogger("proxy")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scope, w, r := scoped(w, r)
+		ctx := r.Context()
+		if header := r.Header.Get(tracing.TraceparentHeader); header != "" {
+			if parent, err := tracing.ParseTraceparent(header); err == nil {
+				ctx = tracing.WithRemoteParent(ctx, parent)
+			} else {
+				logger.WithContext(r.Context()).DebugLog("[Tracing] Ignoring invalid traceparent: %v", err)
+			}
+		}
+
+		route := routeLabel(r.URL.Path)
+		ctx, span := tracing.StartKind(ctx, tracing.KindServer, r.Method+" "+route,
+			tracing.String("http.request.method", r.Method),
+			tracing.String("http.route", route),
+			tracing.String("url.path", r.URL.Path),
+			tracing.String("user_agent.original", r.UserAgent()),
+		)
+		if span == nil {
+			next.ServeHTTP(w, r.WithContext(ctx))
+			return
+		}
+		defer span.End()
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+
+		status := scope.meter.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		span.SetAttributes(tracing.Int("http.response.status_code", int64(status)))
+		if scope.provider != nil {
+			usage := scope.meter.Usage()
+			span.SetAttributes(
+				tracing.String("gen_ai.request.model", scope.model),
+				tracing.String("proxy.provider", string(scope.provider.Name())),
+				tracing.Bool("proxy.stream", scope.meter.Streaming()),
+				tracing.Int("gen_ai.usage.input_tokens", usage.Input),
+				tracing.Int("gen_ai.usage.output_tokens", usage.Output),
+			)
+		}
+		// Client errors leave server spans unset, as in OpenTelemetry; streams that ended
+		// with an error event are failures despite their 200
+		if status >= http.StatusInternalServerError || (scope.meter.Failed() && status < http.StatusBadRequest) {
+			span.SetStatus(tracing.StatusError, http.StatusText(status))
+		}
+	})
+}
+
+// copyTracedStream copies an upstream stream to the client inside a span covering the copy
+func copyTracedStream(ctx context.Context, w http.ResponseWriter, stream io.ReadCloser, model string, logger *logging.Logger) error {
+	_, span := tracing.Start(ctx, "stream.copy", tracing.String("gen_ai.request.model", model))
+	if span == nil {
+		return CopyStreamToResponse(w, stream, logger)
+	}
+	counted := &countingStream{ReadCloser: stream}
+	err := CopyStreamToResponse(w, counted, logger)
+	span.SetAttributes(tracing.Int("stream.bytes", counted.n.Load()))
+	span.RecordError(err)
+	span.End()
+	return err
+}
+
+// countingStream counts the bytes read from a stream
+type countingStream struct {
+	io.ReadCloser
+	n atomic.Int64 // Read runs on the copy's reader goroutine
+}
+
+// Read implements io.Reader interface
+func (c *countingStream) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.n.Add(int64(n))
+	return n, err
+}
@@ -0,0 +1,64 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sunbankio/qwencoder-proxy/provider"
+	"github.com/sunbankio/qwencoder-proxy/tracing"
+)
+
+func TestTraceRequests(t *testing.T) {
+	var out bytes.Buffer
+	if err := tracing.Install(tracing.NewStdoutExporter(&out), 1); err != nil {
+		t.Fatalf("Install() error = %v", err)
+	}
+	defer tracing.Shutdown(context.Background())
+
+	p := &fakeProvider{name: provider.ProviderKiro}
+	handler := TraceRequests(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !admit(w, r, p, "trace-test-model") {
+			return
+		}
+		_, span := tracing.Start(r.Context(), "GetToken")
+		span.End()
+		io.WriteString(w, `{"usage":{"prompt_tokens":7,"completion_tokens":3}}`)
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader("{}"))
+	req.Header.Set(tracing.TraceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	if err := tracing.ForceFlush(context.Background()); err != nil {
+		t.Fatalf("ForceFlush() error = %v", err)
+	}
+
+	var spans []map[string]interface{}
+	dec := json.NewDecoder(&out)
+	for dec.More() {
+		var span map[string]interface{}
+		if err := dec.Decode(&span); err != nil {
+			t.Fatalf("invalid span line: %v", err)
+		}
+		spans = append(spans, span)
+	}
+	if len(spans) != 2 {
+		t.Fatalf("exported %d spans, want 2", len(spans))
+	}
+	child, server := spans[0], spans[1]
+	if server["name"] != "POST /v1/chat/completions" || server["trace_id"] != "4bf92f3577b34da6a3ce929d0e0e4736" || server["parent_span_id"] != "00f067aa0ba902b7" {
+		t.Errorf("server span = %v, want a child of the client's traceparent", server)
+	}
+	if child["parent_span_id"] != server["span_id"] {
+		t.Errorf("handler span parent = %v, want the server span %v", child["parent_span_id"], server["span_id"])
+	}
+	attrs := server["attributes"].(map[string]interface{})
+	if attrs["proxy.provider"] != string(provider.ProviderKiro) || attrs["gen_ai.request.model"] != "trace-test-model" || attrs["http.response.status_code"] != float64(200) {
+		t.Errorf("server span attributes = %v", attrs)
+	}
+}
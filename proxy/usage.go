@@ -0,0 +1,362 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// maxMeteredBody bounds how much of a non-streaming response is buffered to read its usage
+const maxMeteredBody = 8 << 20
+
+// tokenUsage is the token usage reported in a response
+type tokenUsage struct {
+	Input  int64
+	Output int64
+	Cached int64 // Prompt tokens served from the upstream's cache
+}
+
+// Total returns the input and output tokens combined
+func (u tokenUsage) Total() int64 {
+	return u.Input + u.Output
+}
+
+// merge keeps the larger of each count. Every protocol reports cumulative counts,
+// so the largest values seen in a stream are its totals.
+func (u *tokenUsage) merge(other tokenUsage) {
+	u.Input = max(u.Input, other.Input)
+	u.Output = max(u.Output, other.Output)
+	u.Cached = max(u.Cached, other.Cached)
+}
+
+// usageFields covers the usage objects of the OpenAI, Anthropic and Gemini formats
+type usageFields struct {
+	PromptTokens         int64 `json:"prompt_tokens"`
+	CompletionTokens     int64 `json:"completion_tokens"`
+	InputTokens          int64 `json:"input_tokens"`
+	OutputTokens         int64 `json:"output_tokens"`
+	PromptTokenCount     int64 `json:"promptTokenCount"`
+	CandidatesTokenCount int64 `json:"candidatesTokenCount"`
+	ThoughtsTokenCount   int64 `json:"thoughtsTokenCount"`
+
+	PromptTokensDetails *struct {
+		CachedTokens int64 `json:"cached_tokens"`
+	} `json:"prompt_tokens_details"`
+	CacheReadInputTokens    int64 `json:"cache_read_input_tokens"`
+	CachedContentTokenCount int64 `json:"cachedContentTokenCount"`
+}
+
+// cached returns the cached prompt tokens in any of the formats
+func (f *usageFields) cached() int64 {
+	cached := f.CacheReadInputTokens + f.CachedContentTokenCount
+	if f.PromptTokensDetails != nil {
+		cached += f.PromptTokensDetails.CachedTokens
+	}
+	return cached
+}
+
+// usageProbe locates usage in a response body or stream event of any client protocol
+type usageProbe struct {
+	Usage         *usageFields `json:"usage"`
+	UsageMetadata *usageFields `json:"usageMetadata"`
+	Message       *struct {
+		Usage *usageFields `json:"usage"` // Anthropic message_start
+	} `json:"message"`
+}
+
+// parseUsage extracts token usage from a JSON object, or an array of objects as
+// returned by Gemini's streamGenerateContent without alt=sse
+func parseUsage(data []byte) tokenUsage {
+	var usage tokenUsage
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '[' {
+		var items []json.RawMessage
+		if json.Unmarshal(data, &items) == nil {
+			for _, item := range items {
+				usage.merge(parseUsage(item))
+			}
+		}
+		return usage
+	}
+
+	var probe usageProbe
+	if json.Unmarshal(data, &probe) != nil {
+		return usage
+	}
+	for _, fields := range []*usageFields{probe.Usage, probe.UsageMetadata} {
+		if fields != nil {
+			usage.merge(tokenUsage{
+				Input:  fields.PromptTokens + fields.InputTokens + fields.PromptTokenCount,
+				Output: fields.CompletionTokens + fields.OutputTokens + fields.CandidatesTokenCount + fields.ThoughtsTokenCount,
+				Cached: fields.cached(),
+			})
+		}
+	}
+	if probe.Message != nil && probe.Message.Usage != nil {
+		fields := probe.Message.Usage
+		usage.merge(tokenUsage{Input: fields.InputTokens, Output: fields.OutputTokens, Cached: fields.cached()})
+	}
+	return usage
+}
+
+// textProbe locates generated text and tool arguments in a response body or stream
+// event of any client protocol, so usage a response does not report can be estimated
+type textProbe struct {
+	Choices []struct {
+		Message *openAIText `json:"message"`
+		Delta   *openAIText `json:"delta"`
+	} `json:"choices"`
+	Content    []anthropicText `json:"content"` // Anthropic message
+	Delta      *anthropicText  `json:"delta"`   // Anthropic content_block_delta
+	Candidates []struct {
+		Content struct {
+			Parts []struct {
+				Text         string `json:"text"`
+				FunctionCall *struct {
+					Name string          `json:"name"`
+					Args json.RawMessage `json:"args"`
+				} `json:"functionCall"`
+			} `json:"parts"`
+		} `json:"content"`
+	} `json:"candidates"`
+}
+
+// openAIText is the text of an OpenAI message or delta
+type openAIText struct {
+	Content          json.RawMessage `json:"content"` // A string or content parts
+	ReasoningContent string          `json:"reasoning_content"`
+	ToolCalls        []struct {
+		Function struct {
+			Name      string `json:"name"`
+			Arguments string `json:"arguments"`
+		} `json:"function"`
+	} `json:"tool_calls"`
+}
+
+// anthropicText is the text of an Anthropic content block or block delta
+type anthropicText struct {
+	Text        string          `json:"text"`
+	Thinking    string          `json:"thinking"`
+	PartialJSON string          `json:"partial_json"`
+	Name        string          `json:"name"`
+	Input       json.RawMessage `json:"input"`
+}
+
+// appendText appends the generated text found in a JSON object, or an array of objects,
+// to out
+func appendText(out *strings.Builder, data []byte) {
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '[' {
+		var items []json.RawMessage
+		if json.Unmarshal(data, &items) == nil {
+			for _, item := range items {
+				appendText(out, item)
+			}
+		}
+		return
+	}
+
+	var probe textProbe
+	if json.Unmarshal(data, &probe) != nil {
+		return
+	}
+	for _, choice := range probe.Choices {
+		for _, text := range []*openAIText{choice.Message, choice.Delta} {
+			if text == nil {
+				continue
+			}
+			appendJSONStrings(out, text.Content)
+			out.WriteString(text.ReasoningContent)
+			for _, call := range text.ToolCalls {
+				out.WriteString(call.Function.Name)
+				out.WriteString(call.Function.Arguments)
+			}
+		}
+	}
+	blocks := probe.Content
+	if probe.Delta != nil {
+		blocks = append(blocks, *probe.Delta)
+	}
+	for _, block := range blocks {
+		out.WriteString(block.Text)
+		out.WriteString(block.Thinking)
+		out.WriteString(block.PartialJSON)
+		out.WriteString(block.Name)
+		if len(block.Input) > 0 {
+			out.Write(block.Input)
+		}
+	}
+	for _, candidate := range probe.Candidates {
+		for _, part := range candidate.Content.Parts {
+			out.WriteString(part.Text)
+			if part.FunctionCall != nil {
+				out.WriteString(part.FunctionCall.Name)
+				out.Write(part.FunctionCall.Args)
+			}
+		}
+	}
+}
+
+// appendJSONStrings appends the string values in a JSON document to out, leaving out
+// object keys and inline media, which are base64 "data" fields or data: URLs. It
+// approximates the text a request sends whatever its protocol.
+func appendJSONStrings(out *strings.Builder, data []byte) {
+	var value interface{}
+	if len(data) == 0 || json.Unmarshal(data, &value) != nil {
+		return
+	}
+	var walk func(v interface{})
+	walk = func(v interface{}) {
+		switch v := v.(type) {
+		case string:
+			if !strings.HasPrefix(v, "data:") {
+				out.WriteString(v)
+				out.WriteByte(' ')
+			}
+		case []interface{}:
+			for _, item := range v {
+				walk(item)
+			}
+		case map[string]interface{}:
+			for key, item := range v {
+				if key != "data" {
+					walk(item)
+				}
+			}
+		}
+	}
+	walk(value)
+}
+
+// usageMeter passes a response through while reading its status and the token usage
+// it reports. Streams are parsed event by event; other bodies are buffered up to
+// maxMeteredBody and parsed when Usage is called. The generated text is kept, up to
+// maxMeteredBody, to estimate output tokens a response does not report.
+type usageMeter struct {
+	http.ResponseWriter
+	status      int
+	firstWrite  time.Time
+	streaming   *bool
+	onStream    func() // Called once if the response turns out to be an event stream
+	streamError bool   // An SSE error event was sent
+	line        []byte // Partial SSE line carried between writes
+	body        bytes.Buffer
+	usage       tokenUsage
+	text        strings.Builder
+}
+
+// WriteHeader records and forwards the status code
+func (m *usageMeter) WriteHeader(code int) {
+	m.detect()
+	if m.status == 0 {
+		m.status = code
+	}
+	m.ResponseWriter.WriteHeader(code)
+}
+
+// Write forwards the data and scans it for usage
+func (m *usageMeter) Write(b []byte) (int, error) {
+	m.detect()
+	if m.status == 0 {
+		m.status = http.StatusOK
+	}
+	if m.firstWrite.IsZero() {
+		m.firstWrite = time.Now()
+	}
+	if *m.streaming {
+		m.scanEvents(b)
+	} else if m.body.Len()+len(b) <= maxMeteredBody {
+		m.body.Write(b)
+	}
+	return m.ResponseWriter.Write(b)
+}
+
+// detect decides from the content type whether the response is a stream
+func (m *usageMeter) detect() {
+	if m.streaming == nil {
+		streaming := strings.HasPrefix(m.Header().Get("Content-Type"), "text/event-stream")
+		m.streaming = &streaming
+		if streaming && m.onStream != nil {
+			m.onStream()
+		}
+	}
+}
+
+// scanEvents reads usage from the data lines of SSE events and notes error events
+func (m *usageMeter) scanEvents(b []byte) {
+	m.line = append(m.line, b...)
+	for {
+		i := bytes.IndexByte(m.line, '\n')
+		if i < 0 {
+			break
+		}
+		line := bytes.TrimRight(m.line[:i], "\r")
+		if data, ok := bytes.CutPrefix(line, []byte("data:")); ok {
+			m.usage.merge(parseUsage(data))
+			if m.text.Len() < maxMeteredBody {
+				appendText(&m.text, data)
+			}
+		} else if event, ok := bytes.CutPrefix(line, []byte("event:")); ok && string(bytes.TrimSpace(event)) == "error" {
+			m.streamError = true
+		}
+		m.line = m.line[i+1:]
+	}
+	if len(m.line) > maxMeteredBody {
+		m.line = nil
+	}
+}
+
+// Status returns the status code sent to the client, or 0 if nothing was sent
+func (m *usageMeter) Status() int {
+	return m.status
+}
+
+// FirstWrite returns when the first bytes of the body were written, or the zero time
+func (m *usageMeter) FirstWrite() time.Time {
+	return m.firstWrite
+}
+
+// Failed reports whether the response was an error, including a stream that ended with an error event
+func (m *usageMeter) Failed() bool {
+	return m.status >= http.StatusBadRequest || m.streamError
+}
+
+// Streaming reports whether the response is an event stream
+func (m *usageMeter) Streaming() bool {
+	return m.streaming != nil && *m.streaming
+}
+
+// Usage returns the token usage reported by the response written so far
+func (m *usageMeter) Usage() tokenUsage {
+	m.parseBody()
+	return m.usage
+}
+
+// Text returns the text generated in the response written so far
+func (m *usageMeter) Text() string {
+	m.parseBody()
+	return m.text.String()
+}
+
+// parseBody reads the usage and text of the buffered body of a non-streaming response
+func (m *usageMeter) parseBody() {
+	if m.body.Len() > 0 {
+		m.usage.merge(parseUsage(m.body.Bytes()))
+		appendText(&m.text, m.body.Bytes())
+		m.body.Reset()
+	}
+}
+
+// Flush forwards to the underlying writer if it supports flushing
+func (m *usageMeter) Flush() {
+	if f, ok := m.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap returns the underlying writer for http.ResponseController
+func (m *usageMeter) Unwrap() http.ResponseWriter {
+	return m.ResponseWriter
+}
@@ -0,0 +1,132 @@
+package proxy
+
+import (
+	"io"
+
+	"github.com/sunbankio/qwencoder-proxy/converter"
+	"github.com/sunbankio/qwencoder-proxy/logging"
+	"github.com/sunbankio/qwencoder-proxy/openai"
+	"github.com/sunbankio/qwencoder-proxy/provider"
+	"github.com/sunbankio/qwencoder-proxy/provider/gemini"
+	"github.com/sunbankio/qwencoder-proxy/provider/kiro"
+)
+
+// usageFillingStream estimates the usage a chunk stream leaves out. A usage chunk is
+// completed from the request and the text streamed before it, and a stream that ends
+// without one gets a final chunk carrying the estimate.
+type usageFillingStream struct {
+	chunks   chunkStream
+	req      *openai.ChatCompletionRequest
+	acc      *openai.Accumulator
+	logger   *logging.Logger
+	sawUsage bool
+	done     bool
+}
+
+// fillStreamUsage wraps chunks so their usage is estimated where the upstream did not
+// report it. req is the client's request in OpenAI form; without it chunks are unchanged.
+func fillStreamUsage(chunks chunkStream, req *openai.ChatCompletionRequest, logger *logging.Logger) chunkStream {
+	if req == nil {
+		return chunks
+	}
+	return &usageFillingStream{chunks: chunks, req: req, acc: openai.NewAccumulator(), logger: logger}
+}
+
+func (s *usageFillingStream) NextChunk() (*openai.ChatCompletionChunk, error) {
+	if s.done {
+		return nil, io.EOF
+	}
+	chunk, err := s.chunks.NextChunk()
+	if err == io.EOF {
+		s.done = true
+		if s.sawUsage {
+			return nil, io.EOF
+		}
+		resp := s.acc.Response()
+		fillUsage(s.req, resp, s.logger)
+		return &openai.ChatCompletionChunk{
+			ID:      resp.ID,
+			Object:  "chat.completion.chunk",
+			Created: resp.Created,
+			Model:   resp.Model,
+			Choices: []openai.ChunkChoice{},
+			Usage:   resp.Usage,
+		}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	s.acc.Add(chunk)
+	if chunk.Usage != nil {
+		// Usage arrives with or after the last content, so the text so far is the whole reply
+		s.sawUsage = true
+		resp := s.acc.Response()
+		resp.Usage = chunk.Usage
+		fillUsage(s.req, resp, s.logger)
+	}
+	return chunk, nil
+}
+
+func (s *usageFillingStream) Close() error {
+	return s.chunks.Close()
+}
+
+// clientRequest returns a client's native request in OpenAI form for estimating usage,
+// or nil if it cannot be converted
+func clientRequest(convFactory *converter.Factory, clientProtocol provider.ProtocolType, request interface{}, model string, logger *logging.Logger) *openai.ChatCompletionRequest {
+	conv, err := convFactory.Get(clientProtocol)
+	if err != nil {
+		return nil
+	}
+	req, err := conv.ToOpenAIRequest(request)
+	if err != nil {
+		logger.DebugLog("[Handler] Cannot estimate usage of a %s request: %v", clientProtocol, err)
+		return nil
+	}
+	req.Model = model
+	return req
+}
+
+// fillClientUsage estimates the usage an Anthropic or Gemini response did not report,
+// from the client's request in OpenAI form
+func fillClientUsage(convFactory *converter.Factory, clientProtocol provider.ProtocolType, req *openai.ChatCompletionRequest, response interface{}, logger *logging.Logger) {
+	if req == nil {
+		return
+	}
+	conv, err := convFactory.Get(clientProtocol)
+	if err != nil {
+		return
+	}
+	view, err := conv.ToOpenAIResponse(response, req.Model)
+	if err != nil {
+		return
+	}
+	view.Usage = nil // Taken from the native usage below, which counts thoughts as output
+	switch resp := response.(type) {
+	case *kiro.ClaudeResponse:
+		if resp.Usage != nil {
+			view.Usage = &openai.Usage{PromptTokens: resp.Usage.InputTokens, CompletionTokens: resp.Usage.OutputTokens}
+		}
+		fillUsage(req, view, logger)
+		if resp.Usage == nil {
+			resp.Usage = &kiro.Usage{}
+		}
+		resp.Usage.InputTokens, resp.Usage.OutputTokens = view.Usage.PromptTokens, view.Usage.CompletionTokens
+	case *gemini.GeminiResponse:
+		if resp.UsageMetadata != nil {
+			view.Usage = &openai.Usage{PromptTokens: resp.UsageMetadata.PromptTokenCount, CompletionTokens: resp.UsageMetadata.CandidatesTokenCount + resp.UsageMetadata.ThoughtsTokenCount}
+		}
+		fillUsage(req, view, logger)
+		if resp.UsageMetadata == nil {
+			resp.UsageMetadata = &gemini.UsageMetadata{}
+		}
+		if resp.UsageMetadata.PromptTokenCount == 0 {
+			resp.UsageMetadata.PromptTokenCount = view.Usage.PromptTokens
+		}
+		if resp.UsageMetadata.CandidatesTokenCount+resp.UsageMetadata.ThoughtsTokenCount == 0 {
+			resp.UsageMetadata.CandidatesTokenCount = view.Usage.CompletionTokens
+		}
+		resp.UsageMetadata.TotalTokenCount = resp.UsageMetadata.PromptTokenCount + resp.UsageMetadata.CandidatesTokenCount + resp.UsageMetadata.ThoughtsTokenCount
+	}
+}
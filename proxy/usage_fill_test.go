@@ -0,0 +1,89 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/sunbankio/qwencoder-proxy/config"
+	"github.com/sunbankio/qwencoder-proxy/converter"
+	"github.com/sunbankio/qwencoder-proxy/openai"
+	"github.com/sunbankio/qwencoder-proxy/provider"
+	"github.com/sunbankio/qwencoder-proxy/provider/kiro"
+)
+
+// newUsagelessFake returns a provider whose stream and unary responses report no usage
+func newUsagelessFake(t *testing.T) *provider.Factory {
+	t.Helper()
+	fake := newIFlowFake(true)
+	withoutUsage := strings.Replace(openAIStreamBody, `,"usage":{"prompt_tokens":3,"completion_tokens":2,"total_tokens":5}`, "", 1)
+	fake.stream = func() io.ReadCloser { return io.NopCloser(strings.NewReader(withoutUsage)) }
+	factory := provider.NewFactory()
+	factory.Register(fake)
+	if err := factory.PopulateModelProviders(context.Background()); err != nil {
+		t.Fatalf("PopulateModelProviders() error = %v", err)
+	}
+	return factory
+}
+
+func TestOpenAIStreamFillsUsage(t *testing.T) {
+	withStreamingConfig(t, config.StreamingConfig{})
+	handler := NewProviderSpecificHandler(newUsagelessFake(t), converter.NewFactory(), provider.ProviderIFlow)
+
+	body := `{"model":"fake-model","stream":true,"stream_options":{"include_usage":true},"messages":[{"role":"user","content":"tell me something"}]}`
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/iflow/v1/chat/completions", strings.NewReader(body)))
+
+	events := readEvents(t, rec.Body.String())
+	if len(events) < 2 || events[len(events)-1].Data != "[DONE]" {
+		t.Fatalf("stream not terminated: %s", rec.Body.String())
+	}
+	var last openai.ChatCompletionChunk
+	if err := json.Unmarshal([]byte(events[len(events)-2].Data), &last); err != nil {
+		t.Fatalf("invalid chunk %s: %v", events[len(events)-2].Data, err)
+	}
+	if last.Usage == nil || last.Usage.PromptTokens == 0 || last.Usage.CompletionTokens == 0 ||
+		last.Usage.TotalTokens != last.Usage.PromptTokens+last.Usage.CompletionTokens {
+		t.Errorf("final chunk = %s, want estimated usage", events[len(events)-2].Data)
+	}
+}
+
+func TestAnthropicResponsesFillUsage(t *testing.T) {
+	withStreamingConfig(t, config.StreamingConfig{})
+	handler := NewAnthropicHandler(newUsagelessFake(t), converter.NewFactory())
+	send := func(stream bool) string {
+		body := `{"model":"fake-model","max_tokens":100,"stream":` + strconv.FormatBool(stream) +
+			`,"messages":[{"role":"user","content":"tell me something"}]}`
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/anthropic/messages", strings.NewReader(body)))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("stream=%v: status = %d, body = %s", stream, rec.Code, rec.Body.String())
+		}
+		return rec.Body.String()
+	}
+
+	var message kiro.ClaudeResponse
+	if err := json.Unmarshal([]byte(send(false)), &message); err != nil {
+		t.Fatalf("invalid response: %v", err)
+	}
+	if message.Usage == nil || message.Usage.InputTokens == 0 || message.Usage.OutputTokens == 0 {
+		t.Errorf("message usage = %+v, want estimated counts", message.Usage)
+	}
+
+	var delta struct {
+		Usage kiro.Usage `json:"usage"`
+	}
+	for _, ev := range readEvents(t, send(true)) {
+		if ev.Type == "message_delta" {
+			json.Unmarshal([]byte(ev.Data), &delta)
+		}
+	}
+	if delta.Usage.OutputTokens == 0 {
+		t.Errorf("message_delta usage = %+v, want estimated output tokens", delta.Usage)
+	}
+}
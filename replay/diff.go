@@ -0,0 +1,92 @@
+package replay
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Kinds of difference between a recorded and a replayed response
+const (
+	KindStatus          = "status"
+	KindStream          = "stream"
+	KindFinishReason    = "finish_reason"
+	KindMissingToolCall = "missing_tool_call"
+	KindExtraToolCall   = "extra_tool_call"
+	KindToolArguments   = "tool_arguments"
+	KindDroppedUsage    = "dropped_usage"
+	KindMissingText     = "missing_text"
+	KindMissingReason   = "missing_reasoning"
+)
+
+// Difference is one structural change in a replayed response
+type Difference struct {
+	Kind   string `json:"kind"`
+	Detail string `json:"detail"`
+}
+
+// String implements fmt.Stringer interface
+func (d Difference) String() string {
+	return d.Kind + ": " + d.Detail
+}
+
+// Diff compares a replayed response with the recorded one. Only losses and changes
+// are reported; a replay that gains usage or text is not a regression.
+func Diff(recorded, replayed Summary) []Difference {
+	var diffs []Difference
+	add := func(kind, format string, args ...interface{}) {
+		diffs = append(diffs, Difference{Kind: kind, Detail: fmt.Sprintf(format, args...)})
+	}
+
+	if recorded.Status != replayed.Status {
+		add(KindStatus, "%d became %d", recorded.Status, replayed.Status)
+		// An error body has nothing else worth comparing
+		if replayed.Status >= 400 {
+			return diffs
+		}
+	}
+	if recorded.Stream != replayed.Stream {
+		add(KindStream, "stream %t became %t", recorded.Stream, replayed.Stream)
+	}
+	if recorded.FinishReason != replayed.FinishReason {
+		add(KindFinishReason, "%q became %q", recorded.FinishReason, replayed.FinishReason)
+	}
+
+	for i, want := range recorded.ToolCalls {
+		if i >= len(replayed.ToolCalls) {
+			add(KindMissingToolCall, "call %d to %s", i, want.Name)
+			continue
+		}
+		got := replayed.ToolCalls[i]
+		if got.Name != want.Name {
+			add(KindMissingToolCall, "call %d to %s became a call to %s", i, want.Name, got.Name)
+			continue
+		}
+		// Recorded arguments are unknown when they were redacted
+		if want.Arguments != nil && !slices.Equal(want.Arguments, got.Arguments) {
+			add(KindToolArguments, "call %d to %s: arguments [%s] became [%s]", i, want.Name,
+				strings.Join(want.Arguments, ", "), strings.Join(got.Arguments, ", "))
+		}
+	}
+	for i := len(recorded.ToolCalls); i < len(replayed.ToolCalls); i++ {
+		add(KindExtraToolCall, "call %d to %s", i, replayed.ToolCalls[i].Name)
+	}
+
+	if recorded.Usage != nil && replayed.Usage == nil {
+		add(KindDroppedUsage, "usage was not reported")
+	} else if recorded.Usage != nil {
+		if recorded.Usage.Input > 0 && replayed.Usage.Input == 0 {
+			add(KindDroppedUsage, "input tokens were not reported")
+		}
+		if recorded.Usage.Output > 0 && replayed.Usage.Output == 0 {
+			add(KindDroppedUsage, "output tokens were not reported")
+		}
+	}
+	if recorded.Text && !replayed.Text {
+		add(KindMissingText, "no text was returned")
+	}
+	if recorded.Reasoning && !replayed.Reasoning {
+		add(KindMissingReason, "no reasoning was returned")
+	}
+	return diffs
+}
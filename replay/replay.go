@@ -0,0 +1,175 @@
+package replay
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/sunbankio/qwencoder-proxy/audit"
+)
+
+// Options control where and how recorded requests are sent again
+type Options struct {
+	Target   string // Base URL of the proxy, such as http://localhost:8143
+	APIKey   string // Client key sent as a bearer token; recorded keys are redacted
+	Provider string // Send OpenAI requests to this provider's route instead
+	Model    string // Ask for this model instead of the recorded one
+	Client   *http.Client
+}
+
+// Result is the outcome of replaying one recorded exchange
+type Result struct {
+	Record      *audit.Record `json:"-"`
+	RequestID   string        `json:"request_id,omitempty"`
+	Path        string        `json:"path"`
+	Recorded    Summary       `json:"recorded"`
+	Replayed    Summary       `json:"replayed"`
+	Differences []Difference  `json:"differences,omitempty"`
+	Skipped     string        `json:"skipped,omitempty"` // Why the record could not be replayed
+	Error       string        `json:"error,omitempty"`   // Set when the replay request failed
+}
+
+// skipHeaders are not copied from the recorded request: credentials were redacted and
+// the others are set by the client for the new request
+var skipHeaders = map[string]bool{
+	"Authorization":     true,
+	"X-Api-Key":         true,
+	"X-Goog-Api-Key":    true,
+	"Cookie":            true,
+	"Content-Length":    true,
+	"Accept-Encoding":   true,
+	"Connection":        true,
+	"Transfer-Encoding": true,
+	"Traceparent":       true,
+	"Tracestate":        true,
+}
+
+// Replay sends a recorded request to the target and compares the response with the
+// recorded one
+func Replay(ctx context.Context, opts Options, record *audit.Record) Result {
+	result := Result{Record: record, RequestID: record.RequestID}
+	target, err := url.Parse(record.Request.URL)
+	if err != nil {
+		result.Skipped = fmt.Sprintf("invalid recorded URL: %v", err)
+		return result
+	}
+	result.Path = target.Path
+	protocol := ProtocolOf(target.Path)
+	switch {
+	case protocol == "":
+		result.Skipped = "not a generation request"
+		return result
+	case record.Request.Truncated || record.Request.Omitted != "" || record.Response.Truncated:
+		result.Skipped = "body was not fully recorded"
+		return result
+	case record.Request.Body == nil:
+		result.Skipped = "request has no JSON body"
+		return result
+	case record.PromptsRedacted:
+		result.Skipped = "prompts were redacted"
+		return result
+	}
+
+	body, path, err := rewrite(record.Request.Body, target.Path, protocol, opts)
+	if err != nil {
+		result.Skipped = err.Error()
+		return result
+	}
+	result.Path = path
+	result.Recorded = Summarize(protocol, record.Response)
+
+	base, err := url.Parse(opts.Target)
+	if err != nil {
+		result.Error = fmt.Sprintf("invalid target: %v", err)
+		return result
+	}
+	query := target.Query()
+	query.Del("key") // Recorded as a redacted placeholder
+	u := base.JoinPath(path)
+	u.RawQuery = query.Encode()
+
+	method := record.Request.Method
+	if method == "" {
+		method = http.MethodPost
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	for name, values := range record.Request.Header {
+		if !skipHeaders[http.CanonicalHeaderKey(name)] {
+			req.Header[name] = values
+		}
+	}
+	if opts.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+opts.APIKey)
+	}
+
+	client := opts.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		result.Error = fmt.Sprintf("failed to read response: %v", err)
+		return result
+	}
+
+	msg := audit.Message{Status: resp.StatusCode}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType == "text/event-stream" {
+		msg.Events = audit.ParseEvents(data)
+	} else if json.Valid(data) {
+		msg.Body = data
+	}
+	result.Replayed = Summarize(protocol, msg)
+	result.Differences = Diff(result.Recorded, result.Replayed)
+	return result
+}
+
+// rewrite applies the provider and model overrides to a recorded request
+func rewrite(body json.RawMessage, path string, protocol Protocol, opts Options) ([]byte, string, error) {
+	if opts.Provider != "" {
+		if protocol != ProtocolOpenAI {
+			return nil, "", fmt.Errorf("provider override applies to OpenAI requests only")
+		}
+		path = "/" + opts.Provider + "/v1/chat/completions"
+	}
+	if opts.Model == "" {
+		return body, path, nil
+	}
+
+	if protocol == ProtocolGemini {
+		// The model is part of the path: /gemini/models/{model}:generateContent
+		prefix, rest, _ := strings.Cut(path, "/models/")
+		_, method, ok := strings.Cut(rest, ":")
+		if !ok {
+			return nil, "", fmt.Errorf("no model in path %s", path)
+		}
+		return body, prefix + "/models/" + opts.Model + ":" + method, nil
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return nil, "", fmt.Errorf("request body is not a JSON object")
+	}
+	fields["model"], _ = json.Marshal(opts.Model)
+	out, err := json.Marshal(fields)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to encode request: %w", err)
+	}
+	return out, path, nil
+}
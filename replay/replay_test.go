@@ -0,0 +1,216 @@
+package replay
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/sunbankio/qwencoder-proxy/audit"
+)
+
+// events builds a recorded stream from JSON payloads
+func events(payloads ...string) []audit.Event {
+	out := make([]audit.Event, len(payloads))
+	for i, payload := range payloads {
+		out[i] = audit.Event{Data: json.RawMessage(payload)}
+	}
+	return out
+}
+
+func TestSummarize(t *testing.T) {
+	tests := []struct {
+		name     string
+		protocol Protocol
+		msg      audit.Message
+		want     Summary
+	}{
+		{
+			name:     "openai unary tool call",
+			protocol: ProtocolOpenAI,
+			msg:      audit.Message{Status: 200, Body: json.RawMessage(`{"choices":[{"message":{"content":null,"tool_calls":[{"function":{"name":"get_weather","arguments":"{\"city\":\"Paris\",\"unit\":\"c\"}"}}]},"finish_reason":"tool_calls"}],"usage":{"prompt_tokens":10,"completion_tokens":5}}`)},
+			want: Summary{Status: 200, ToolCalls: []ToolCall{{Name: "get_weather", Arguments: []string{"city", "unit"}}},
+				FinishReason: "tool_calls", Usage: &Usage{Input: 10, Output: 5}},
+		},
+		{
+			name:     "openai stream with split arguments",
+			protocol: ProtocolOpenAI,
+			msg: audit.Message{Status: 200, Events: append(events(
+				`{"choices":[{"delta":{"reasoning_content":"hmm"}}]}`,
+				`{"choices":[{"delta":{"content":"Let me check"}}]}`,
+				`{"choices":[{"delta":{"tool_calls":[{"index":0,"function":{"name":"get_weather","arguments":"{\"ci"}}]}}]}`,
+				`{"choices":[{"delta":{"tool_calls":[{"index":0,"function":{"arguments":"ty\":\"Paris\"}"}}]}}]}`,
+				`{"choices":[{"delta":{},"finish_reason":"tool_calls"}]}`,
+			), audit.Event{Text: "[DONE]"})},
+			want: Summary{Status: 200, Stream: true, Text: true, Reasoning: true,
+				ToolCalls: []ToolCall{{Name: "get_weather", Arguments: []string{"city"}}}, FinishReason: "tool_calls"},
+		},
+		{
+			name:     "redacted arguments are unknown",
+			protocol: ProtocolOpenAI,
+			msg:      audit.Message{Status: 200, Body: json.RawMessage(`{"choices":[{"message":{"tool_calls":[{"function":{"name":"f","arguments":"[REDACTED 7 chars]"}}]}}]}`)},
+			want:     Summary{Status: 200, ToolCalls: []ToolCall{{Name: "f"}}},
+		},
+		{
+			name:     "anthropic stream",
+			protocol: ProtocolAnthropic,
+			msg: audit.Message{Status: 200, Events: events(
+				`{"type":"message_start","message":{"usage":{"input_tokens":12,"output_tokens":1}}}`,
+				`{"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`,
+				`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Sure"}}`,
+				`{"type":"content_block_start","index":1,"content_block":{"type":"tool_use","name":"read_file","input":{}}}`,
+				`{"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"{\"path\":"}}`,
+				`{"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"\"a.go\"}"}}`,
+				`{"type":"message_delta","delta":{"stop_reason":"tool_use"},"usage":{"output_tokens":20}}`,
+			)},
+			want: Summary{Status: 200, Stream: true, Text: true,
+				ToolCalls: []ToolCall{{Name: "read_file", Arguments: []string{"path"}}}, FinishReason: "tool_use", Usage: &Usage{Input: 12, Output: 20}},
+		},
+		{
+			name:     "anthropic message",
+			protocol: ProtocolAnthropic,
+			msg:      audit.Message{Status: 200, Body: json.RawMessage(`{"type":"message","content":[{"type":"thinking","thinking":"x"},{"type":"tool_use","name":"ls","input":{"dir":"."}}],"stop_reason":"tool_use","usage":{"input_tokens":3,"output_tokens":4}}`)},
+			want: Summary{Status: 200, Reasoning: true, ToolCalls: []ToolCall{{Name: "ls", Arguments: []string{"dir"}}},
+				FinishReason: "tool_use", Usage: &Usage{Input: 3, Output: 4}},
+		},
+		{
+			name:     "gemini stream",
+			protocol: ProtocolGemini,
+			msg: audit.Message{Status: 200, Events: events(
+				`{"candidates":[{"content":{"parts":[{"text":"plan","thought":true},{"text":"Hi"}]}}]}`,
+				`{"candidates":[{"content":{"parts":[{"functionCall":{"name":"search","args":{"q":"go"}}}]},"finishReason":"STOP"}],"usageMetadata":{"promptTokenCount":7,"candidatesTokenCount":9}}`,
+			)},
+			want: Summary{Status: 200, Stream: true, Text: true, Reasoning: true,
+				ToolCalls: []ToolCall{{Name: "search", Arguments: []string{"q"}}}, FinishReason: "STOP", Usage: &Usage{Input: 7, Output: 9}},
+		},
+	}
+	for _, tt := range tests {
+		if got := Summarize(tt.protocol, tt.msg); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: Summarize() = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestProtocolOf(t *testing.T) {
+	tests := map[string]Protocol{
+		"/v1/chat/completions":                                ProtocolOpenAI,
+		"/kiro/v1/chat/completions":                           ProtocolOpenAI,
+		"/anthropic/messages":                                 ProtocolAnthropic,
+		"/gemini/models/gemini-2.5-pro:streamGenerateContent": ProtocolGemini,
+		"/v1/models":                                          "",
+	}
+	for path, want := range tests {
+		if got := ProtocolOf(path); got != want {
+			t.Errorf("ProtocolOf(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
+
+func TestDiff(t *testing.T) {
+	recorded := Summary{Status: 200, Stream: true, Text: true, FinishReason: "tool_calls",
+		ToolCalls: []ToolCall{{Name: "read_file", Arguments: []string{"path"}}}, Usage: &Usage{Input: 10, Output: 5}}
+
+	kinds := func(diffs []Difference) []string {
+		var out []string
+		for _, diff := range diffs {
+			out = append(out, diff.Kind)
+		}
+		return out
+	}
+	tests := []struct {
+		name     string
+		replayed func(s Summary) Summary
+		want     []string
+	}{
+		{"identical", func(s Summary) Summary { return s }, nil},
+		{"more usage is fine", func(s Summary) Summary { s.Usage = &Usage{Input: 11, Output: 6}; s.Reasoning = true; return s }, nil},
+		{"dropped tool call", func(s Summary) Summary { s.ToolCalls = nil; s.FinishReason = "stop"; return s },
+			[]string{KindFinishReason, KindMissingToolCall}},
+		{"changed arguments", func(s Summary) Summary {
+			s.ToolCalls = []ToolCall{{Name: "read_file", Arguments: []string{"file"}}}
+			return s
+		}, []string{KindToolArguments}},
+		{"extra call", func(s Summary) Summary {
+			s.ToolCalls = append([]ToolCall{}, s.ToolCalls[0], ToolCall{Name: "ls"})
+			return s
+		}, []string{KindExtraToolCall}},
+		{"dropped usage", func(s Summary) Summary { s.Usage = nil; return s }, []string{KindDroppedUsage}},
+		{"dropped output tokens", func(s Summary) Summary { s.Usage = &Usage{Input: 10}; return s }, []string{KindDroppedUsage}},
+		{"error stops the comparison", func(s Summary) Summary { return Summary{Status: 502} }, []string{KindStatus}},
+	}
+	for _, tt := range tests {
+		if got := kinds(Diff(recorded, tt.replayed(recorded))); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: Diff() kinds = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	// Redacted arguments are not compared
+	unknown := Summary{Status: 200, ToolCalls: []ToolCall{{Name: "f"}}}
+	if diffs := Diff(unknown, Summary{Status: 200, ToolCalls: []ToolCall{{Name: "f", Arguments: []string{"a"}}}}); diffs != nil {
+		t.Errorf("Diff() compared unknown arguments: %v", diffs)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	var gotPath, gotAuth string
+	var gotBody map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath, gotAuth = r.URL.Path, r.Header.Get("Authorization")
+		data, _ := io.ReadAll(r.Body)
+		json.Unmarshal(data, &gotBody)
+		// The converter now drops the tool call and usage
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, "data: {\"choices\":[{\"delta\":{\"content\":\"ok\"}}]}\n\n")
+		io.WriteString(w, "data: {\"choices\":[{\"delta\":{},\"finish_reason\":\"stop\"}]}\n\n")
+		io.WriteString(w, "data: [DONE]\n\n")
+	}))
+	defer server.Close()
+
+	record := &audit.Record{
+		RequestID: "req-1",
+		Request: audit.Message{
+			Method: http.MethodPost,
+			URL:    "/v1/chat/completions",
+			Header: http.Header{"Authorization": {"[REDACTED]"}, "Content-Type": {"application/json"}},
+			Body:   json.RawMessage(`{"model":"qwen3-coder-plus","stream":true,"messages":[{"role":"user","content":"hi"}]}`),
+		},
+		Response: audit.Message{Status: 200, Events: events(
+			`{"choices":[{"delta":{"tool_calls":[{"index":0,"function":{"name":"ls","arguments":"{}"}}]}}]}`,
+			`{"choices":[{"delta":{},"finish_reason":"tool_calls"}],"usage":{"prompt_tokens":3,"completion_tokens":2}}`,
+		)},
+	}
+	result := Replay(context.Background(), Options{Target: server.URL, APIKey: "sk-test", Provider: "kiro", Model: "claude-sonnet-4"}, record)
+	if result.Skipped != "" || result.Error != "" {
+		t.Fatalf("Replay() = %+v", result)
+	}
+	if gotPath != "/kiro/v1/chat/completions" || gotAuth != "Bearer sk-test" || gotBody["model"] != "claude-sonnet-4" {
+		t.Errorf("replayed request: path %s, auth %q, body %v", gotPath, gotAuth, gotBody)
+	}
+	var kinds []string
+	for _, diff := range result.Differences {
+		kinds = append(kinds, diff.Kind)
+	}
+	if want := []string{KindFinishReason, KindMissingToolCall, KindDroppedUsage}; !reflect.DeepEqual(kinds, want) {
+		t.Errorf("differences = %v, want %v", result.Differences, want)
+	}
+
+	for name, record := range map[string]*audit.Record{
+		"truncated": {Request: audit.Message{URL: "/v1/chat/completions", Body: json.RawMessage(`{}`), Truncated: true}},
+		"redacted":  {Request: audit.Message{URL: "/v1/chat/completions", Body: json.RawMessage(`{}`)}, PromptsRedacted: true},
+		"not chat":  {Request: audit.Message{URL: "/v1/models"}},
+	} {
+		if result := Replay(context.Background(), Options{Target: server.URL}, record); result.Skipped == "" {
+			t.Errorf("%s record was replayed", name)
+		}
+	}
+
+	// Gemini models are part of the path
+	_, path, err := rewrite(json.RawMessage(`{}`), "/gemini/models/gemini-2.5-pro:streamGenerateContent", ProtocolGemini, Options{Model: "gemini-2.5-flash"})
+	if err != nil || !strings.HasSuffix(path, "/models/gemini-2.5-flash:streamGenerateContent") {
+		t.Errorf("rewrite() path = %s, %v", path, err)
+	}
+}
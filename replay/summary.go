@@ -0,0 +1,358 @@
+// Package replay re-sends exchanges recorded in the audit log and compares the new
+// responses with the recorded ones. Model output differs between runs, so responses
+// are compared by structure: status, tool calls, finish reason and usage reporting.
+package replay
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"github.com/sunbankio/qwencoder-proxy/audit"
+)
+
+// Protocol is the API a client used, which decides how responses are read
+type Protocol string
+
+// Client protocols served by the proxy
+const (
+	ProtocolOpenAI    Protocol = "openai"
+	ProtocolAnthropic Protocol = "anthropic"
+	ProtocolGemini    Protocol = "gemini"
+)
+
+// ProtocolOf returns the protocol of a request path, or "" for paths that are not
+// generation routes
+func ProtocolOf(path string) Protocol {
+	switch {
+	case strings.HasSuffix(path, "/chat/completions"):
+		return ProtocolOpenAI
+	case strings.HasSuffix(path, "/messages"):
+		return ProtocolAnthropic
+	case strings.Contains(path, "/models/") && strings.Contains(path, "enerateContent"):
+		return ProtocolGemini
+	}
+	return ""
+}
+
+// Summary is the structure of a response
+type Summary struct {
+	Status       int        `json:"status"`
+	Stream       bool       `json:"stream"`
+	Text         bool       `json:"text"`      // Some text was returned
+	Reasoning    bool       `json:"reasoning"` // Some reasoning or thinking was returned
+	ToolCalls    []ToolCall `json:"tool_calls,omitempty"`
+	FinishReason string     `json:"finish_reason,omitempty"`
+	Usage        *Usage     `json:"usage,omitempty"` // Nil when the response reported no usage
+}
+
+// ToolCall is the structure of a tool call
+type ToolCall struct {
+	Name string `json:"name"`
+	// Arguments are the top-level argument names, nil when the arguments are unknown,
+	// as when they were redacted or are not a JSON object
+	Arguments []string `json:"arguments,omitempty"`
+}
+
+// Usage is the token usage a response reported
+type Usage struct {
+	Input  int64 `json:"input"`
+	Output int64 `json:"output"`
+}
+
+// Summarize reads the structure of a response in protocol
+func Summarize(protocol Protocol, msg audit.Message) Summary {
+	s := &summarizer{summary: Summary{Status: msg.Status, Stream: len(msg.Events) > 0}, calls: map[int]*toolCallParts{}}
+	if s.summary.Stream {
+		for _, event := range msg.Events {
+			if event.Data != nil {
+				s.add(protocol, event.Data)
+			}
+		}
+	} else if msg.Body != nil {
+		s.add(protocol, msg.Body)
+	}
+	return s.finish()
+}
+
+// summarizer accumulates a response that may arrive as a stream of chunks
+type summarizer struct {
+	summary Summary
+	calls   map[int]*toolCallParts // By index, in the order they started
+	order   []int
+}
+
+// toolCallParts is a tool call whose arguments may arrive in pieces
+type toolCallParts struct {
+	name      string
+	arguments strings.Builder
+	object    map[string]json.RawMessage // Arguments sent as an object
+}
+
+func (s *summarizer) call(index int) *toolCallParts {
+	call, ok := s.calls[index]
+	if !ok {
+		call = &toolCallParts{}
+		s.calls[index] = call
+		s.order = append(s.order, index)
+	}
+	return call
+}
+
+func (s *summarizer) usage(input, output int64) {
+	if s.summary.Usage == nil {
+		s.summary.Usage = &Usage{}
+	}
+	if input > 0 {
+		s.summary.Usage.Input = input
+	}
+	if output > 0 {
+		s.summary.Usage.Output = output
+	}
+}
+
+func (s *summarizer) finish() Summary {
+	for _, index := range s.order {
+		parts := s.calls[index]
+		call := ToolCall{Name: parts.name}
+		object := parts.object
+		if object == nil {
+			json.Unmarshal([]byte(parts.arguments.String()), &object)
+		}
+		if object != nil {
+			call.Arguments = make([]string, 0, len(object))
+			for name := range object {
+				call.Arguments = append(call.Arguments, name)
+			}
+			sort.Strings(call.Arguments)
+		}
+		s.summary.ToolCalls = append(s.summary.ToolCalls, call)
+	}
+	return s.summary
+}
+
+func (s *summarizer) add(protocol Protocol, data json.RawMessage) {
+	switch protocol {
+	case ProtocolOpenAI:
+		s.addOpenAI(data)
+	case ProtocolAnthropic:
+		s.addAnthropic(data)
+	case ProtocolGemini:
+		s.addGemini(data)
+	}
+}
+
+// openAIMessage covers a chat completion and a chunk; chunks carry deltas
+type openAIMessage struct {
+	Content          *string `json:"content"`
+	ReasoningContent *string `json:"reasoning_content"`
+	ToolCalls        []struct {
+		Index    *int `json:"index"`
+		Function struct {
+			Name      string `json:"name"`
+			Arguments string `json:"arguments"`
+		} `json:"function"`
+	} `json:"tool_calls"`
+}
+
+func (s *summarizer) addOpenAI(data json.RawMessage) {
+	var resp struct {
+		Choices []struct {
+			Message      *openAIMessage `json:"message"`
+			Delta        *openAIMessage `json:"delta"`
+			FinishReason *string        `json:"finish_reason"`
+		} `json:"choices"`
+		Usage *struct {
+			PromptTokens     int64 `json:"prompt_tokens"`
+			CompletionTokens int64 `json:"completion_tokens"`
+		} `json:"usage"`
+	}
+	if json.Unmarshal(data, &resp) != nil {
+		return
+	}
+	if resp.Usage != nil {
+		s.usage(resp.Usage.PromptTokens, resp.Usage.CompletionTokens)
+	}
+	if len(resp.Choices) == 0 {
+		return
+	}
+	choice := resp.Choices[0]
+	if choice.FinishReason != nil && *choice.FinishReason != "" {
+		s.summary.FinishReason = *choice.FinishReason
+	}
+	msg := choice.Message
+	if msg == nil {
+		msg = choice.Delta
+	}
+	if msg == nil {
+		return
+	}
+	if msg.Content != nil && *msg.Content != "" {
+		s.summary.Text = true
+	}
+	if msg.ReasoningContent != nil && *msg.ReasoningContent != "" {
+		s.summary.Reasoning = true
+	}
+	for i, tc := range msg.ToolCalls {
+		index := i
+		if tc.Index != nil {
+			index = *tc.Index
+		}
+		call := s.call(index)
+		if tc.Function.Name != "" {
+			call.name = tc.Function.Name
+		}
+		call.arguments.WriteString(tc.Function.Arguments)
+	}
+}
+
+// anthropicBlock is a content block of a message or a content_block_start event
+type anthropicBlock struct {
+	Type     string                     `json:"type"`
+	Text     string                     `json:"text"`
+	Thinking string                     `json:"thinking"`
+	Name     string                     `json:"name"`
+	Input    map[string]json.RawMessage `json:"input"`
+}
+
+type anthropicUsage struct {
+	InputTokens  int64 `json:"input_tokens"`
+	OutputTokens int64 `json:"output_tokens"`
+}
+
+func (s *summarizer) addAnthropic(data json.RawMessage) {
+	var event struct {
+		Type    string `json:"type"`
+		Index   int    `json:"index"`
+		Message *struct {
+			Content    []anthropicBlock `json:"content"`
+			StopReason string           `json:"stop_reason"`
+			Usage      *anthropicUsage  `json:"usage"`
+		} `json:"message"`
+		ContentBlock *anthropicBlock `json:"content_block"`
+		Delta        *struct {
+			Type        string `json:"type"`
+			Text        string `json:"text"`
+			Thinking    string `json:"thinking"`
+			PartialJSON string `json:"partial_json"`
+			StopReason  string `json:"stop_reason"`
+		} `json:"delta"`
+		Usage *anthropicUsage `json:"usage"`
+
+		// A non-streaming message has these at the top level
+		Content    []anthropicBlock `json:"content"`
+		StopReason string           `json:"stop_reason"`
+	}
+	if json.Unmarshal(data, &event) != nil {
+		return
+	}
+
+	switch event.Type {
+	case "message":
+		s.anthropicBlocks(event.Content)
+		s.summary.FinishReason = event.StopReason
+		if event.Usage != nil {
+			s.usage(event.Usage.InputTokens, event.Usage.OutputTokens)
+		}
+	case "message_start":
+		if event.Message != nil && event.Message.Usage != nil {
+			s.usage(event.Message.Usage.InputTokens, event.Message.Usage.OutputTokens)
+		}
+	case "content_block_start":
+		if block := event.ContentBlock; block != nil {
+			switch block.Type {
+			case "tool_use":
+				s.call(event.Index).name = block.Name
+			case "text":
+				s.summary.Text = s.summary.Text || block.Text != ""
+			case "thinking":
+				s.summary.Reasoning = s.summary.Reasoning || block.Thinking != ""
+			}
+		}
+	case "content_block_delta":
+		if delta := event.Delta; delta != nil {
+			switch delta.Type {
+			case "text_delta":
+				s.summary.Text = s.summary.Text || delta.Text != ""
+			case "thinking_delta":
+				s.summary.Reasoning = s.summary.Reasoning || delta.Thinking != ""
+			case "input_json_delta":
+				s.call(event.Index).arguments.WriteString(delta.PartialJSON)
+			}
+		}
+	case "message_delta":
+		if event.Delta != nil && event.Delta.StopReason != "" {
+			s.summary.FinishReason = event.Delta.StopReason
+		}
+		if event.Usage != nil {
+			s.usage(event.Usage.InputTokens, event.Usage.OutputTokens)
+		}
+	}
+}
+
+func (s *summarizer) anthropicBlocks(blocks []anthropicBlock) {
+	for i, block := range blocks {
+		switch block.Type {
+		case "text":
+			s.summary.Text = s.summary.Text || block.Text != ""
+		case "thinking":
+			s.summary.Reasoning = s.summary.Reasoning || block.Thinking != ""
+		case "tool_use":
+			call := s.call(i)
+			call.name, call.object = block.Name, block.Input
+			if call.object == nil {
+				call.object = map[string]json.RawMessage{}
+			}
+		}
+	}
+}
+
+func (s *summarizer) addGemini(data json.RawMessage) {
+	var resp struct {
+		Candidates []struct {
+			Content struct {
+				Parts []struct {
+					Text         string `json:"text"`
+					Thought      bool   `json:"thought"`
+					FunctionCall *struct {
+						Name string                     `json:"name"`
+						Args map[string]json.RawMessage `json:"args"`
+					} `json:"functionCall"`
+				} `json:"parts"`
+			} `json:"content"`
+			FinishReason string `json:"finishReason"`
+		} `json:"candidates"`
+		UsageMetadata *struct {
+			PromptTokenCount     int64 `json:"promptTokenCount"`
+			CandidatesTokenCount int64 `json:"candidatesTokenCount"`
+		} `json:"usageMetadata"`
+	}
+	if json.Unmarshal(data, &resp) != nil {
+		return
+	}
+	if resp.UsageMetadata != nil {
+		s.usage(resp.UsageMetadata.PromptTokenCount, resp.UsageMetadata.CandidatesTokenCount)
+	}
+	if len(resp.Candidates) == 0 {
+		return
+	}
+	candidate := resp.Candidates[0]
+	if candidate.FinishReason != "" {
+		s.summary.FinishReason = candidate.FinishReason
+	}
+	for _, part := range candidate.Content.Parts {
+		switch {
+		case part.FunctionCall != nil:
+			// Gemini sends each call whole, so every call gets its own index
+			call := s.call(len(s.order))
+			call.name, call.object = part.FunctionCall.Name, part.FunctionCall.Args
+			if call.object == nil {
+				call.object = map[string]json.RawMessage{}
+			}
+		case part.Thought:
+			s.summary.Reasoning = s.summary.Reasoning || part.Text != ""
+		case part.Text != "":
+			s.summary.Text = true
+		}
+	}
+}
@@ -0,0 +1,179 @@
+// Package sse reads and writes server-sent event streams as specified by the
+// WHATWG HTML Living Standard (section 9.2, "Server-sent events").
+package sse
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultMaxEventSize is the default cap on the size of a single event
+const DefaultMaxEventSize = 16 << 20
+
+// maxRetryMillis is the largest retry value representable as a time.Duration
+const maxRetryMillis = math.MaxInt64 / int64(time.Millisecond)
+
+// ErrEventTooLarge is returned when an event or line exceeds the reader's size cap
+var ErrEventTooLarge = errors.New("sse: event exceeds maximum size")
+
+// Event is a single dispatched server-sent event
+type Event struct {
+	// Type is the event field; empty means the default "message" type
+	Type string
+	// Data is the concatenation of the event's data fields, joined with "\n"
+	Data string
+	// ID is the last event ID at the time of dispatch
+	ID string
+	// Retry is the reconnection time set by the event, or zero
+	Retry time.Duration
+}
+
+// Reader parses server-sent events from a byte stream
+type Reader struct {
+	br           *bufio.Reader
+	maxEventSize int
+	line         []byte
+	skipLF       bool // the previous line ended in CR, so a following LF is part of the same line ending
+	started      bool // the leading byte order mark has been handled
+	lastID       string
+}
+
+// NewReader creates a Reader that rejects events larger than maxEventSize bytes.
+// A maxEventSize of zero or less uses DefaultMaxEventSize.
+func NewReader(r io.Reader, maxEventSize int) *Reader {
+	if maxEventSize <= 0 {
+		maxEventSize = DefaultMaxEventSize
+	}
+	return &Reader{br: bufio.NewReader(r), maxEventSize: maxEventSize}
+}
+
+// Next returns the next dispatched event. It returns io.EOF when the stream ends;
+// a trailing event that was not terminated by a blank line is discarded, as the spec requires.
+func (r *Reader) Next() (*Event, error) {
+	var (
+		eventType string
+		data      strings.Builder
+		hasData   bool
+		retry     time.Duration
+	)
+
+	for {
+		line, err := r.readLine()
+		if err != nil {
+			return nil, err
+		}
+
+		if len(line) == 0 {
+			// A blank line dispatches the event, unless no data was received
+			if !hasData {
+				eventType, retry = "", 0
+				continue
+			}
+			return &Event{Type: eventType, Data: data.String(), ID: r.lastID, Retry: retry}, nil
+		}
+
+		if line[0] == ':' {
+			continue // Comment
+		}
+
+		field, value := line, []byte(nil)
+		if i := bytes.IndexByte(line, ':'); i >= 0 {
+			field, value = line[:i], line[i+1:]
+			if len(value) > 0 && value[0] == ' ' {
+				value = value[1:]
+			}
+		}
+
+		switch string(field) {
+		case "event":
+			eventType = string(value)
+		case "data":
+			if hasData {
+				data.WriteByte('\n')
+			}
+			data.Write(value)
+			hasData = true
+			if data.Len() > r.maxEventSize {
+				return nil, fmt.Errorf("%w (%d bytes)", ErrEventTooLarge, r.maxEventSize)
+			}
+		case "id":
+			if bytes.IndexByte(value, 0) < 0 {
+				r.lastID = string(value)
+			}
+		case "retry":
+			if ms, err := strconv.ParseInt(string(value), 10, 64); err == nil && isDigits(value) && ms <= maxRetryMillis {
+				retry = time.Duration(ms) * time.Millisecond
+			}
+		}
+	}
+}
+
+// LastEventID returns the most recent event ID seen on the stream
+func (r *Reader) LastEventID() string {
+	return r.lastID
+}
+
+// readLine returns the next line without its terminator (CRLF, LF or CR).
+// The returned slice is only valid until the next call.
+func (r *Reader) readLine() ([]byte, error) {
+	r.line = r.line[:0]
+
+	if !r.started {
+		r.started = true
+		if bom, err := r.br.Peek(3); err == nil && bytes.Equal(bom, []byte("\xEF\xBB\xBF")) {
+			r.br.Discard(3)
+		}
+	}
+
+	for {
+		if _, err := r.br.Peek(1); err != nil {
+			if err == io.EOF && len(r.line) > 0 {
+				// An unterminated final line cannot complete an event
+				return nil, io.EOF
+			}
+			return nil, err
+		}
+		buf, _ := r.br.Peek(r.br.Buffered())
+
+		if r.skipLF {
+			r.skipLF = false
+			if buf[0] == '\n' {
+				r.br.Discard(1)
+				continue
+			}
+		}
+
+		if i := bytes.IndexAny(buf, "\r\n"); i >= 0 {
+			r.line = append(r.line, buf[:i]...)
+			r.skipLF = buf[i] == '\r'
+			r.br.Discard(i + 1)
+			return r.line, nil
+		}
+
+		r.line = append(r.line, buf...)
+		r.br.Discard(len(buf))
+		if len(r.line) > r.maxEventSize {
+			return nil, fmt.Errorf("%w (%d bytes)", ErrEventTooLarge, r.maxEventSize)
+		}
+	}
+}
+
+// isDigits reports whether b is non-empty and only contains ASCII digits
+func isDigits(b []byte) bool {
+	if len(b) == 0 {
+		return false
+	}
+	for _, c := range b {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,216 @@
+package sse
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+// readAll collects every event from r until EOF or an error
+func readAll(r io.Reader, maxEventSize int) ([]Event, error) {
+	reader := NewReader(r, maxEventSize)
+	var events []Event
+	for {
+		ev, err := reader.Next()
+		if err == io.EOF {
+			return events, nil
+		}
+		if err != nil {
+			return events, err
+		}
+		events = append(events, *ev)
+	}
+}
+
+func TestReaderSpecCases(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []Event
+	}{
+		{"data without space", "data:hello\n\n", []Event{{Data: "hello"}}},
+		{"only one leading space stripped", "data:  two\n\n", []Event{{Data: " two"}}},
+		{"multi-line data", "data: a\ndata: b\n\n", []Event{{Data: "a\nb"}}},
+		{"crlf endings", "data: a\r\n\r\ndata: b\r\n\r\n", []Event{{Data: "a"}, {Data: "b"}}},
+		{"cr endings", "data: a\r\rdata: b\r\r", []Event{{Data: "a"}, {Data: "b"}}},
+		{"event type and id", "event: error\nid: 7\ndata: {}\n\n", []Event{{Type: "error", ID: "7", Data: "{}"}}},
+		{"id carries forward", "id: 1\ndata: a\n\ndata: b\n\n", []Event{{ID: "1", Data: "a"}, {ID: "1", Data: "b"}}},
+		{"id with NUL ignored", "id: 1\ndata: a\n\nid: x\x00y\ndata: b\n\n", []Event{{ID: "1", Data: "a"}, {ID: "1", Data: "b"}}},
+		{"retry", "retry: 1500\ndata: a\n\n", []Event{{Data: "a", Retry: 1500 * time.Millisecond}}},
+		{"invalid retry ignored", "retry: 15s\ndata: a\n\n", []Event{{Data: "a"}}},
+		{"comments and unknown fields", ": ping\nfoo: bar\ndata: a\n\n", []Event{{Data: "a"}}},
+		{"field without colon", "data\n\n", []Event{{Data: ""}}},
+		{"event without data not dispatched", "event: ping\n\ndata: a\n\n", []Event{{Data: "a"}}},
+		{"byte order mark", "\xEF\xBB\xBFdata: a\n\n", []Event{{Data: "a"}}},
+		{"unterminated event discarded", "data: a\n\ndata: b\n", []Event{{Data: "a"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := readAll(strings.NewReader(tt.input), 0)
+			if err != nil {
+				t.Fatalf("readAll() error = %v", err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d events %+v, want %d", len(got), got, len(tt.want))
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("event %d = %+v, want %+v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestReaderLargeEvent(t *testing.T) {
+	// Larger than bufio.Scanner's default 64KB token limit
+	payload := strings.Repeat("x", 200*1024)
+	got, err := readAll(strings.NewReader("data: "+payload+"\n\n"), 0)
+	if err != nil {
+		t.Fatalf("readAll() error = %v", err)
+	}
+	if len(got) != 1 || got[0].Data != payload {
+		t.Fatalf("large event not read intact")
+	}
+
+	_, err = readAll(strings.NewReader("data: "+payload+"\n\n"), 64*1024)
+	if !errors.Is(err, ErrEventTooLarge) {
+		t.Errorf("readAll() error = %v, want ErrEventTooLarge", err)
+	}
+
+	// The cap applies to the whole event, not just a single line
+	multi := strings.Repeat("data: "+strings.Repeat("y", 1000)+"\n", 100) + "\n"
+	_, err = readAll(strings.NewReader(multi), 64*1024)
+	if !errors.Is(err, ErrEventTooLarge) {
+		t.Errorf("readAll() multi-line error = %v, want ErrEventTooLarge", err)
+	}
+}
+
+func TestWriterRoundTrip(t *testing.T) {
+	events := []Event{
+		{Data: "plain"},
+		{Type: "error", ID: "42", Data: "{\"error\":{}}"},
+		{ID: "42", Data: "line one\nline two\r\nline three"},
+		{ID: "", Data: " leading space", Retry: 3 * time.Second},
+	}
+
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	for i := range events {
+		if err := w.WriteEvent(&events[i]); err != nil {
+			t.Fatalf("WriteEvent() error = %v", err)
+		}
+	}
+	if err := w.WriteComment("keepalive"); err != nil {
+		t.Fatalf("WriteComment() error = %v", err)
+	}
+
+	got, err := readAll(&buf, 0)
+	if err != nil {
+		t.Fatalf("readAll() error = %v", err)
+	}
+	events[2].Data = "line one\nline two\nline three"
+	if len(got) != len(events) {
+		t.Fatalf("got %d events, want %d", len(got), len(events))
+	}
+	for i := range got {
+		if got[i] != events[i] {
+			t.Errorf("event %d = %+v, want %+v", i, got[i], events[i])
+		}
+	}
+}
+
+// loadFixtures returns the recorded upstream streams in testdata
+func loadFixtures(t testing.TB) map[string][]byte {
+	t.Helper()
+	paths, err := filepath.Glob(filepath.Join("testdata", "*.sse"))
+	if err != nil || len(paths) == 0 {
+		t.Fatalf("no fixtures found: %v", err)
+	}
+	fixtures := make(map[string][]byte, len(paths))
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("ReadFile(%s) error = %v", path, err)
+		}
+		fixtures[filepath.Base(path)] = data
+	}
+	return fixtures
+}
+
+func TestReaderFixtures(t *testing.T) {
+	want := map[string]int{
+		"gemini_stream.sse":      4,
+		"antigravity_stream.sse": 3,
+	}
+	for name, data := range loadFixtures(t) {
+		got, err := readAll(bytes.NewReader(data), 0)
+		if err != nil {
+			t.Errorf("%s: readAll() error = %v", name, err)
+			continue
+		}
+		if len(got) != want[name] {
+			t.Errorf("%s: got %d events, want %d", name, len(got), want[name])
+		}
+		for _, ev := range got {
+			if !strings.HasPrefix(ev.Data, `{"response":`) {
+				t.Errorf("%s: unexpected event data %q", name, ev.Data)
+			}
+		}
+	}
+}
+
+func FuzzReader(f *testing.F) {
+	for _, data := range loadFixtures(f) {
+		f.Add(data)
+	}
+	f.Add([]byte("data: a\r\n\r\nid: 1\revent: x\rdata: b\r\r"))
+	f.Add([]byte(": comment\nretry: 10\ndata\n\n"))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		const maxEventSize = 1 << 16
+		whole, wholeErr := readAll(bytes.NewReader(data), maxEventSize)
+
+		// Splitting the input into single-byte reads must not change the result
+		split, splitErr := readAll(iotest.OneByteReader(bytes.NewReader(data)), maxEventSize)
+		if (wholeErr == nil) != (splitErr == nil) || len(whole) != len(split) {
+			t.Fatalf("chunked read differs: %d events (%v) vs %d events (%v)", len(whole), wholeErr, len(split), splitErr)
+		}
+		for i := range whole {
+			if whole[i] != split[i] {
+				t.Fatalf("event %d differs: %+v vs %+v", i, whole[i], split[i])
+			}
+		}
+		if wholeErr != nil {
+			return
+		}
+
+		// Re-encoding the parsed events must reproduce them exactly
+		var buf bytes.Buffer
+		w := NewWriter(&buf)
+		for i := range whole {
+			if err := w.WriteEvent(&whole[i]); err != nil {
+				t.Fatalf("WriteEvent() error = %v", err)
+			}
+		}
+		again, err := readAll(&buf, 0)
+		if err != nil {
+			t.Fatalf("re-read error = %v", err)
+		}
+		if len(again) != len(whole) {
+			t.Fatalf("round trip produced %d events, want %d", len(again), len(whole))
+		}
+		for i := range whole {
+			if again[i] != whole[i] {
+				t.Fatalf("round trip event %d = %+v, want %+v", i, again[i], whole[i])
+			}
+		}
+	})
+}
@@ -0,0 +1,113 @@
+package sse
+
+import (
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Writer writes server-sent events to an underlying writer
+type Writer struct {
+	w      io.Writer
+	buf    []byte
+	lastID string
+}
+
+// NewWriter creates a Writer
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+// WriteEvent writes a complete event followed by the blank line that dispatches it.
+// The id field is written whenever ev.ID differs from the previous event's, since
+// clients carry the last event ID forward.
+func (w *Writer) WriteEvent(ev *Event) error {
+	w.buf = appendEvent(w.buf[:0], ev, ev.ID != w.lastID)
+	w.lastID = ev.ID
+	_, err := w.w.Write(w.buf)
+	return err
+}
+
+// WriteData writes a default-typed event carrying data
+func (w *Writer) WriteData(data []byte) error {
+	w.buf = appendData(w.buf[:0], string(data))
+	w.buf = append(w.buf, '\n')
+	_, err := w.w.Write(w.buf)
+	return err
+}
+
+// WriteComment writes a comment line, which clients ignore; it is typically used as a keepalive
+func (w *Writer) WriteComment(text string) error {
+	w.buf = w.buf[:0]
+	for _, line := range splitLines(text) {
+		w.buf = append(w.buf, ": "...)
+		w.buf = append(w.buf, line...)
+		w.buf = append(w.buf, '\n')
+	}
+	w.buf = append(w.buf, '\n')
+	_, err := w.w.Write(w.buf)
+	return err
+}
+
+// Flush flushes the underlying writer if it supports flushing
+func (w *Writer) Flush() {
+	if f, ok := w.w.(interface{ Flush() }); ok {
+		f.Flush()
+	}
+}
+
+// AppendEvent appends the wire encoding of ev to dst.
+// Line breaks in the data are encoded as separate data fields so they survive a round trip;
+// line breaks in the type and ID, which the format cannot represent, are dropped.
+func AppendEvent(dst []byte, ev *Event) []byte {
+	return appendEvent(dst, ev, ev.ID != "")
+}
+
+// appendEvent appends the wire encoding of ev, including the id field when writeID is set
+func appendEvent(dst []byte, ev *Event, writeID bool) []byte {
+	if writeID {
+		dst = append(dst, "id: "...)
+		dst = append(dst, stripLineBreaks(ev.ID)...)
+		dst = append(dst, '\n')
+	}
+	if ev.Type != "" {
+		dst = append(dst, "event: "...)
+		dst = append(dst, stripLineBreaks(ev.Type)...)
+		dst = append(dst, '\n')
+	}
+	if ev.Retry > 0 {
+		dst = append(dst, "retry: "...)
+		dst = strconv.AppendInt(dst, ev.Retry.Milliseconds(), 10)
+		dst = append(dst, '\n')
+	}
+	dst = appendData(dst, ev.Data)
+	return append(dst, '\n')
+}
+
+// appendData appends one data field per line of data
+func appendData(dst []byte, data string) []byte {
+	for _, line := range splitLines(data) {
+		dst = append(dst, "data: "...)
+		dst = append(dst, line...)
+		dst = append(dst, '\n')
+	}
+	return dst
+}
+
+// splitLines splits s on CRLF, LF and CR
+func splitLines(s string) []string {
+	if !strings.ContainsAny(s, "\r\n") {
+		return []string{s}
+	}
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	s = strings.ReplaceAll(s, "\r", "\n")
+	return strings.Split(s, "\n")
+}
+
+// stripLineBreaks removes CR and LF characters from a single-line field value
+func stripLineBreaks(s string) string {
+	if !strings.ContainsAny(s, "\r\n") {
+		return s
+	}
+	return strings.NewReplacer("\r", "", "\n", "").Replace(s)
+}
@@ -0,0 +1,131 @@
+package tokens
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/sunbankio/qwencoder-proxy/openai"
+)
+
+const (
+	// perMessageOverhead covers role markers and separators around each message
+	perMessageOverhead = 4
+	// replyPriming covers the tokens that open the assistant reply
+	replyPriming = 3
+	// perImageTokens is a flat estimate for an image part
+	perImageTokens = 765
+)
+
+// CountMessages estimates the prompt tokens of a list of chat messages
+func CountMessages(e Estimator, messages []openai.Message) int {
+	total := replyPriming
+	for _, msg := range messages {
+		total += perMessageOverhead
+		total += e.Count(msg.Role)
+		if msg.Name != "" {
+			total += e.Count(msg.Name)
+		}
+		if msg.Content.IsParts() {
+			for _, part := range msg.Content.Parts {
+				switch part.Type {
+				case "text":
+					total += e.Count(part.Text)
+				case "image_url":
+					total += perImageTokens
+				}
+			}
+		} else {
+			total += e.Count(msg.Content.Text)
+		}
+		for _, call := range msg.ToolCalls {
+			total += e.Count(call.Function.Name) + e.Count(call.Function.Arguments)
+		}
+	}
+	return total
+}
+
+// CountTools estimates the tokens used by tool definitions
+func CountTools(e Estimator, tools []openai.Tool) int {
+	total := 0
+	for _, tool := range tools {
+		schema, _ := json.Marshal(tool.Function.Parameters)
+		total += e.Count(tool.Function.Name) + e.Count(tool.Function.Description) + e.Count(string(schema))
+	}
+	return total
+}
+
+// CountRequest estimates the prompt tokens of a chat completion request
+func CountRequest(req *openai.ChatCompletionRequest) int {
+	e := ForModel(req.Model)
+	return CountMessages(e, req.Messages) + CountTools(e, req.Tools)
+}
+
+// CountResponse estimates the completion tokens of a chat completion response
+func CountResponse(resp *openai.ChatCompletionResponse) int {
+	e := ForModel(resp.Model)
+	total := 0
+	for _, choice := range resp.Choices {
+		total += e.Count(choice.Message.Content.String())
+		total += e.Count(choice.Message.ReasoningContent)
+		for _, call := range choice.Message.ToolCalls {
+			total += e.Count(call.Function.Name) + e.Count(call.Function.Arguments)
+		}
+	}
+	return total
+}
+
+// FillUsage estimates any usage the upstream did not report.
+// It returns true when the response usage was modified.
+func FillUsage(req *openai.ChatCompletionRequest, resp *openai.ChatCompletionResponse) bool {
+	if resp.Usage == nil {
+		resp.Usage = &openai.Usage{}
+	}
+	usage := resp.Usage
+	filled := false
+
+	if usage.PromptTokens == 0 {
+		usage.PromptTokens = CountRequest(req)
+		filled = true
+	}
+	if usage.CompletionTokens == 0 {
+		if resp.Model == "" {
+			resp.Model = req.Model
+		}
+		usage.CompletionTokens = CountResponse(resp)
+		filled = true
+	}
+	if filled || usage.TotalTokens == 0 {
+		usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+	}
+	return filled
+}
+
+// ContextLengthError reports a request that does not fit a model's context window
+type ContextLengthError struct {
+	Model        string
+	Limit        int
+	PromptTokens int
+	MaxTokens    int
+}
+
+func (e *ContextLengthError) Error() string {
+	return fmt.Sprintf("This model's maximum context length is %d tokens. However, you requested %d tokens (%d in the messages, %d in the completion). Please reduce the length of the messages or completion.",
+		e.Limit, e.PromptTokens+e.MaxTokens, e.PromptTokens, e.MaxTokens)
+}
+
+// CheckContextLength returns a *ContextLengthError if the estimated prompt plus the
+// requested completion exceeds limit. A limit of zero or less disables the check.
+func CheckContextLength(req *openai.ChatCompletionRequest, limit int) error {
+	if limit <= 0 {
+		return nil
+	}
+	prompt := CountRequest(req)
+	maxTokens := 0
+	if req.MaxTokens != nil {
+		maxTokens = *req.MaxTokens
+	}
+	if prompt+maxTokens > limit {
+		return &ContextLengthError{Model: req.Model, Limit: limit, PromptTokens: prompt, MaxTokens: maxTokens}
+	}
+	return nil
+}
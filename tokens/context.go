@@ -0,0 +1,118 @@
+package tokens
+
+import (
+	"strings"
+
+	"github.com/sunbankio/qwencoder-proxy/openai"
+)
+
+// contextLimits lists known context windows by model name prefix; longer prefixes win
+var contextLimits = []struct {
+	prefix string
+	limit  int
+}{
+	{"qwen3-coder-plus", 1000000},
+	{"qwen3-coder-flash", 1000000},
+	{"qwen3-coder", 262144},
+	{"qwen3-max", 262144},
+	{"qwen3-vl", 262144},
+	{"qwen", 131072},
+	{"gemini-claude", 200000},
+	{"claude", 200000},
+	{"gemini-3", 1048576},
+	{"gemini-2.5", 1048576},
+	{"gemini", 1048576},
+	{"glm-4.6", 204800},
+	{"deepseek", 131072},
+	{"kimi-k2", 262144},
+}
+
+// DefaultContextLimit returns the known context window of a model, or 0 if unknown
+func DefaultContextLimit(model string) int {
+	m := strings.ToLower(model)
+	best, limit := 0, 0
+	for _, entry := range contextLimits {
+		if strings.HasPrefix(m, entry.prefix) && len(entry.prefix) > best {
+			best, limit = len(entry.prefix), entry.limit
+		}
+	}
+	return limit
+}
+
+// TrimHistory drops the oldest non-system messages until the request fits limit.
+// An assistant message with tool calls is dropped together with its tool results,
+// and the latest message is always kept. It returns the number of messages removed,
+// or a *ContextLengthError (leaving the request untouched) if trimming cannot make it fit.
+func TrimHistory(req *openai.ChatCompletionRequest, limit int) (int, error) {
+	err := CheckContextLength(req, limit)
+	if err == nil {
+		return 0, nil
+	}
+
+	e := ForModel(req.Model)
+	budget := limit - replyPriming - CountTools(e, req.Tools)
+	if req.MaxTokens != nil {
+		budget -= *req.MaxTokens
+	}
+
+	total := 0
+	costs := make([]int, len(req.Messages))
+	for i, msg := range req.Messages {
+		costs[i] = CountMessages(e, []openai.Message{msg}) - replyPriming
+		total += costs[i]
+	}
+
+	units := droppableUnits(req.Messages)
+	drop := make([]bool, len(req.Messages))
+	removed := 0
+	// The final unit holds the message being answered and is never dropped
+	for i := 0; i < len(units)-1 && total > budget; i++ {
+		for j := units[i].start; j < units[i].end; j++ {
+			drop[j] = true
+			total -= costs[j]
+			removed++
+		}
+	}
+	if total > budget {
+		return 0, err
+	}
+
+	kept := make([]openai.Message, 0, len(req.Messages)-removed)
+	for i, msg := range req.Messages {
+		if !drop[i] {
+			kept = append(kept, msg)
+		}
+	}
+	req.Messages = kept
+	return removed, nil
+}
+
+// messageUnit is a half-open range of messages that must be dropped together
+type messageUnit struct {
+	start, end int
+}
+
+// droppableUnits groups non-system messages into units in conversation order,
+// keeping each assistant tool call message with the tool results that follow it
+func droppableUnits(messages []openai.Message) []messageUnit {
+	var units []messageUnit
+	for i := 0; i < len(messages); i++ {
+		if isSystemRole(messages[i].Role) {
+			continue
+		}
+		unit := messageUnit{start: i, end: i + 1}
+		if messages[i].Role == "assistant" && len(messages[i].ToolCalls) > 0 {
+			for unit.end < len(messages) && messages[unit.end].Role == "tool" {
+				unit.end++
+			}
+			i = unit.end - 1
+		}
+		units = append(units, unit)
+	}
+	return units
+}
+
+// isSystemRole reports whether a role carries instructions that must survive trimming
+func isSystemRole(role string) bool {
+	return role == "system" || role == "developer"
+}
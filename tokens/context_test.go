@@ -0,0 +1,98 @@
+package tokens
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/sunbankio/qwencoder-proxy/openai"
+)
+
+func TestDefaultContextLimit(t *testing.T) {
+	tests := []struct {
+		model string
+		want  int
+	}{
+		{"qwen3-coder-plus", 1000000},
+		{"qwen3-coder-480b", 262144},
+		{"claude-sonnet-4-5", 200000},
+		{"gemini-claude-sonnet-4-5-thinking", 200000},
+		{"gemini-2.5-pro", 1048576},
+		{"unknown-model", 0},
+	}
+	for _, tt := range tests {
+		if got := DefaultContextLimit(tt.model); got != tt.want {
+			t.Errorf("DefaultContextLimit(%q) = %d, want %d", tt.model, got, tt.want)
+		}
+	}
+}
+
+func longText(words int) openai.MessageContent {
+	return openai.TextContent(strings.Repeat("lorem ", words))
+}
+
+func TestTrimHistoryKeepsToolPairs(t *testing.T) {
+	req := &openai.ChatCompletionRequest{
+		Model: "claude-sonnet-4-5",
+		Messages: []openai.Message{
+			{Role: "system", Content: openai.TextContent("You are helpful.")},
+			{Role: "user", Content: longText(100)},
+			{Role: "assistant", Content: openai.NullContent(), ToolCalls: []openai.ToolCall{
+				{ID: "call_1", Type: "function", Function: openai.FunctionCall{Name: "read", Arguments: `{}`}},
+			}},
+			{Role: "tool", ToolCallID: "call_1", Content: longText(100)},
+			{Role: "assistant", Content: longText(10)},
+			{Role: "user", Content: openai.TextContent("Next question?")},
+		},
+	}
+
+	// Enough room for the system prompt and the tail of the conversation only
+	limit := CountRequest(req) - 150
+	removed, err := TrimHistory(req, limit)
+	if err != nil {
+		t.Fatalf("TrimHistory() error = %v", err)
+	}
+	if removed != 3 {
+		t.Errorf("removed = %d, want 3 (user turn plus tool call pair)", removed)
+	}
+
+	var roles []string
+	for _, msg := range req.Messages {
+		roles = append(roles, msg.Role)
+	}
+	if got := strings.Join(roles, ","); got != "system,assistant,user" {
+		t.Errorf("roles after trim = %s, want system,assistant,user", got)
+	}
+	if err := CheckContextLength(req, limit); err != nil {
+		t.Errorf("request still too long after trim: %v", err)
+	}
+}
+
+func TestTrimHistoryFailsWhenLatestTurnTooLong(t *testing.T) {
+	req := &openai.ChatCompletionRequest{
+		Model: "qwen3-coder-plus",
+		Messages: []openai.Message{
+			{Role: "user", Content: openai.TextContent("hi")},
+			{Role: "user", Content: longText(500)},
+		},
+	}
+
+	_, err := TrimHistory(req, 100)
+	var ctxErr *ContextLengthError
+	if !errors.As(err, &ctxErr) {
+		t.Fatalf("TrimHistory() error = %v, want *ContextLengthError", err)
+	}
+	if len(req.Messages) != 2 {
+		t.Errorf("request modified on failure: %d messages", len(req.Messages))
+	}
+}
+
+func TestTrimHistoryNoopWhenFits(t *testing.T) {
+	req := &openai.ChatCompletionRequest{
+		Model:    "gemini-2.5-pro",
+		Messages: []openai.Message{{Role: "user", Content: openai.TextContent("hi")}},
+	}
+	if removed, err := TrimHistory(req, 1000); err != nil || removed != 0 {
+		t.Errorf("TrimHistory() = %d, %v; want 0, nil", removed, err)
+	}
+}
@@ -0,0 +1,169 @@
+// Package tokens provides local token count estimates for prompts and completions.
+// The estimators approximate each model family's BPE tokenizer without shipping vocabularies,
+// so counts are close but not exact.
+package tokens
+
+import (
+	"math"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Family identifies a tokenizer family
+type Family string
+
+const (
+	FamilyQwen    Family = "qwen"
+	FamilyClaude  Family = "claude"
+	FamilyGemini  Family = "gemini"
+	FamilyDefault Family = "default"
+)
+
+// Estimator estimates how many tokens a text occupies for one tokenizer family
+type Estimator interface {
+	// Family returns the tokenizer family this estimator approximates
+	Family() Family
+
+	// Count returns the estimated number of tokens in text
+	Count(text string) int
+}
+
+// bpeParams tunes the approximation to a tokenizer's merge behaviour
+type bpeParams struct {
+	// charsPerToken is the average number of Latin letters merged into one token
+	charsPerToken float64
+	// cjkCharsPerToken is the average number of CJK characters per token
+	cjkCharsPerToken float64
+	// digitsPerToken is how many digits the tokenizer groups together
+	digitsPerToken int
+}
+
+// wordMergeFactor scales charsPerToken to the longest word still expected to be one token
+const wordMergeFactor = 1.75
+
+// approxEstimator splits text into pre-tokens the way BPE tokenizers do
+// (words, digit runs, punctuation, whitespace) and estimates merges within each
+type approxEstimator struct {
+	family Family
+	params bpeParams
+}
+
+var estimators = map[Family]*approxEstimator{
+	// Qwen's 150k vocabulary merges CJK aggressively and splits digits individually
+	FamilyQwen: {family: FamilyQwen, params: bpeParams{charsPerToken: 4.2, cjkCharsPerToken: 1.4, digitsPerToken: 1}},
+	// Claude tokenizes CJK close to one token per character
+	FamilyClaude: {family: FamilyClaude, params: bpeParams{charsPerToken: 3.8, cjkCharsPerToken: 1.0, digitsPerToken: 3}},
+	// Gemini's SentencePiece vocabulary is large and multilingual
+	FamilyGemini:  {family: FamilyGemini, params: bpeParams{charsPerToken: 4.5, cjkCharsPerToken: 1.5, digitsPerToken: 1}},
+	FamilyDefault: {family: FamilyDefault, params: bpeParams{charsPerToken: 4.0, cjkCharsPerToken: 1.0, digitsPerToken: 3}},
+}
+
+// ForFamily returns the estimator for a tokenizer family
+func ForFamily(family Family) Estimator {
+	if e, ok := estimators[family]; ok {
+		return e
+	}
+	return estimators[FamilyDefault]
+}
+
+// ForModel returns the estimator matching a model name
+func ForModel(model string) Estimator {
+	return ForFamily(FamilyForModel(model))
+}
+
+// FamilyForModel infers the tokenizer family from a model name
+func FamilyForModel(model string) Family {
+	m := strings.ToLower(model)
+	switch {
+	case strings.Contains(m, "qwen"):
+		return FamilyQwen
+	case strings.Contains(m, "claude"):
+		return FamilyClaude
+	case strings.Contains(m, "gemini"):
+		return FamilyGemini
+	default:
+		return FamilyDefault
+	}
+}
+
+// Family returns the tokenizer family
+func (e *approxEstimator) Family() Family {
+	return e.family
+}
+
+// Count returns the estimated number of tokens in text
+func (e *approxEstimator) Count(text string) int {
+	total := 0
+	letters, digits, cjk := 0, 0, 0
+
+	flushWord := func() {
+		if letters > 0 {
+			// Common short words are a single vocabulary entry; longer ones split into pieces
+			if float64(letters) <= e.params.charsPerToken*wordMergeFactor {
+				total++
+			} else {
+				total += int(math.Ceil(float64(letters) / e.params.charsPerToken))
+			}
+			letters = 0
+		}
+		if digits > 0 {
+			total += (digits + e.params.digitsPerToken - 1) / e.params.digitsPerToken
+			digits = 0
+		}
+		if cjk > 0 {
+			total += int(math.Ceil(float64(cjk) / e.params.cjkCharsPerToken))
+			cjk = 0
+		}
+	}
+
+	inSpace := false
+	for i := 0; i < len(text); {
+		r, size := utf8.DecodeRuneInString(text[i:])
+		i += size
+
+		switch {
+		case unicode.IsSpace(r):
+			flushWord()
+			// A run of whitespace is usually one token; a single space merges into the next word
+			if !inSpace && r != ' ' {
+				total++
+			}
+			inSpace = true
+			continue
+		case isCJK(r):
+			if letters > 0 || digits > 0 {
+				flushWord()
+			}
+			cjk++
+		case unicode.IsDigit(r):
+			if letters > 0 || cjk > 0 {
+				flushWord()
+			}
+			digits++
+		case unicode.IsLetter(r) || r == '_' || r == '\'':
+			if digits > 0 || cjk > 0 {
+				flushWord()
+			}
+			if r < utf8.RuneSelf {
+				letters++
+			} else {
+				// Non-ASCII letters rarely merge as well as ASCII ones
+				letters += 2
+			}
+		default:
+			// Punctuation and symbols are usually their own token
+			flushWord()
+			total++
+		}
+		inSpace = false
+	}
+	flushWord()
+
+	return total
+}
+
+// isCJK reports whether r is a Chinese, Japanese or Korean character
+func isCJK(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul)
+}
@@ -0,0 +1,103 @@
+package tokens
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/sunbankio/qwencoder-proxy/openai"
+)
+
+func TestFamilyForModel(t *testing.T) {
+	tests := []struct {
+		model string
+		want  Family
+	}{
+		{"qwen3-coder-plus", FamilyQwen},
+		{"claude-sonnet-4-5", FamilyClaude},
+		{"gemini-2.5-pro", FamilyGemini},
+		{"gpt-4o", FamilyDefault},
+	}
+	for _, tt := range tests {
+		if got := FamilyForModel(tt.model); got != tt.want {
+			t.Errorf("FamilyForModel(%q) = %s, want %s", tt.model, got, tt.want)
+		}
+	}
+}
+
+func TestCountApproximatesBPE(t *testing.T) {
+	tests := []struct {
+		name     string
+		family   Family
+		text     string
+		min, max int
+	}{
+		{"empty", FamilyDefault, "", 0, 0},
+		{"short sentence", FamilyDefault, "Hello, world!", 3, 5},
+		// Reference tokenizers produce 9-11 tokens for this sentence
+		{"english", FamilyClaude, "The quick brown fox jumps over the lazy dog.", 9, 13},
+		// Qwen merges common Chinese words, Claude is close to a token per character
+		{"chinese qwen", FamilyQwen, "你好，世界。今天天气很好。", 6, 12},
+		{"chinese claude", FamilyClaude, "你好，世界。今天天气很好。", 10, 16},
+		{"digits qwen", FamilyQwen, "1234567890", 10, 10},
+		{"digits claude", FamilyClaude, "1234567890", 4, 4},
+		{"code", FamilyQwen, "func main() {\n\tfmt.Println(\"hi\")\n}", 10, 20},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ForFamily(tt.family).Count(tt.text)
+			if got < tt.min || got > tt.max {
+				t.Errorf("Count(%q) = %d, want between %d and %d", tt.text, got, tt.min, tt.max)
+			}
+		})
+	}
+}
+
+func TestFillUsage(t *testing.T) {
+	req := &openai.ChatCompletionRequest{
+		Model: "qwen3-coder-plus",
+		Messages: []openai.Message{
+			{Role: "user", Content: openai.TextContent("Write a haiku about the sea.")},
+		},
+	}
+	resp := &openai.ChatCompletionResponse{
+		Choices: []openai.Choice{{Message: openai.Message{Role: "assistant", Content: openai.TextContent("Waves fold into foam")}}},
+		Usage:   &openai.Usage{},
+	}
+
+	if !FillUsage(req, resp) {
+		t.Fatal("FillUsage() = false, want true")
+	}
+	if resp.Usage.PromptTokens == 0 || resp.Usage.CompletionTokens == 0 {
+		t.Errorf("usage not filled: %+v", resp.Usage)
+	}
+	if resp.Usage.TotalTokens != resp.Usage.PromptTokens+resp.Usage.CompletionTokens {
+		t.Errorf("TotalTokens = %d, want sum", resp.Usage.TotalTokens)
+	}
+
+	reported := &openai.ChatCompletionResponse{Usage: &openai.Usage{PromptTokens: 10, CompletionTokens: 5, TotalTokens: 15}}
+	if FillUsage(req, reported) {
+		t.Error("FillUsage() modified usage reported by upstream")
+	}
+}
+
+func TestCheckContextLength(t *testing.T) {
+	maxTokens := 100
+	req := &openai.ChatCompletionRequest{
+		Model:     "claude-sonnet-4-5",
+		Messages:  []openai.Message{{Role: "user", Content: openai.TextContent("hello there")}},
+		MaxTokens: &maxTokens,
+	}
+
+	if err := CheckContextLength(req, 1000); err != nil {
+		t.Errorf("CheckContextLength() error = %v, want nil", err)
+	}
+
+	var ctxErr *ContextLengthError
+	if err := CheckContextLength(req, 50); !errors.As(err, &ctxErr) {
+		t.Fatalf("CheckContextLength() error = %v, want *ContextLengthError", err)
+	}
+	if ctxErr.Limit != 50 || ctxErr.MaxTokens != 100 {
+		t.Errorf("unexpected error fields: %+v", ctxErr)
+	}
+}
@@ -0,0 +1,228 @@
+package tracing
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sunbankio/qwencoder-proxy/config"
+	"github.com/sunbankio/qwencoder-proxy/logging"
+)
+
+// Exporter sends finished spans to a backend
+type Exporter interface {
+	// ExportSpans sends a batch of spans
+	ExportSpans(ctx context.Context, spans []SpanData) error
+	// Shutdown releases the exporter's resources after the last batch
+	Shutdown(ctx context.Context) error
+}
+
+// Batching settings
+const (
+	queueSize      = 2048            // Spans waiting for export; more are dropped
+	maxBatchSize   = 512             // Spans sent in one export call
+	exportInterval = 5 * time.Second // Longest time a span waits for its batch
+	exportTimeout  = 30 * time.Second
+)
+
+// tracer queues finished spans and exports them in batches from a background goroutine
+type tracer struct {
+	exporter Exporter
+	ratio    float64
+	logger   *logging.Logger
+
+	mu      sync.RWMutex // Guards closed against sends on the closed queue
+	closed  bool
+	queue   chan SpanData
+	flushes chan chan struct{}
+	done    chan struct{}
+	dropped atomic.Int64
+}
+
+var (
+	activeMu sync.RWMutex
+	active   *tracer
+)
+
+// current returns the installed tracer, or nil when tracing is off
+func current() *tracer {
+	activeMu.RLock()
+	defer activeMu.RUnlock()
+	return active
+}
+
+// Configure installs the exporter selected by cfg, replacing any previous one
+func Configure(cfg config.TracingConfig) error {
+	var exporter Exporter
+	switch cfg.Exporter {
+	case "", "none":
+	case "otlp":
+		exporter = NewOTLPExporter(cfg.Endpoint, cfg.Headers, cfg.ServiceName)
+	case "console", "stdout":
+		exporter = NewStdoutExporter(os.Stdout)
+	default:
+		return fmt.Errorf("unknown traces exporter %q: want none, otlp or console", cfg.Exporter)
+	}
+	return Install(exporter, cfg.SampleRatio)
+}
+
+// Install starts exporting spans to exporter, recording the given share of new traces.
+// A nil exporter turns tracing off. The previous exporter is flushed and shut down.
+func Install(exporter Exporter, ratio float64) error {
+	var t *tracer
+	if exporter != nil {
+		t = &tracer{
+			exporter: exporter,
+			ratio:    ratio,
+			logger:   logging.NewLogger("tracing"),
+			queue:    make(chan SpanData, queueSize),
+			flushes:  make(chan chan struct{}),
+			done:     make(chan struct{}),
+		}
+		go t.run()
+	}
+
+	activeMu.Lock()
+	previous := active
+	active = t
+	activeMu.Unlock()
+
+	if previous != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), exportTimeout)
+		defer cancel()
+		return previous.shutdown(ctx)
+	}
+	return nil
+}
+
+// Shutdown exports the queued spans and turns tracing off
+func Shutdown(ctx context.Context) error {
+	activeMu.Lock()
+	t := active
+	active = nil
+	activeMu.Unlock()
+
+	if t == nil {
+		return nil
+	}
+	return t.shutdown(ctx)
+}
+
+// ForceFlush exports the spans queued so far
+func ForceFlush(ctx context.Context) error {
+	t := current()
+	if t == nil {
+		return nil
+	}
+	ack := make(chan struct{})
+	select {
+	case t.flushes <- ack:
+	case <-t.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case <-ack:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// sample decides whether to record a new trace, consistently for the same trace ID
+// in the manner of OpenTelemetry's TraceIDRatioBased sampler
+func (t *tracer) sample(id TraceID) bool {
+	if t.ratio >= 1 {
+		return true
+	}
+	if t.ratio <= 0 {
+		return false
+	}
+	return binary.BigEndian.Uint64(id[8:])>>1 < uint64(t.ratio*(1<<63))
+}
+
+// enqueue queues a finished span, dropping it if the queue is full
+func (t *tracer) enqueue(span SpanData) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.closed {
+		return
+	}
+	select {
+	case t.queue <- span:
+	default:
+		if t.dropped.Add(1)%queueSize == 1 {
+			t.logger.ErrorLog("[Tracing] Export queue full, %d spans dropped so far", t.dropped.Load())
+		}
+	}
+}
+
+// run exports batches until the queue is closed
+func (t *tracer) run() {
+	defer close(t.done)
+	ticker := time.NewTicker(exportInterval)
+	defer ticker.Stop()
+
+	batch := make([]SpanData, 0, maxBatchSize)
+	export := func() {
+		if len(batch) == 0 {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), exportTimeout)
+		if err := t.exporter.ExportSpans(ctx, batch); err != nil {
+			t.logger.ErrorLog("[Tracing] Failed to export %d spans: %v", len(batch), err)
+		}
+		cancel()
+		batch = make([]SpanData, 0, maxBatchSize)
+	}
+
+	for {
+		select {
+		case span, ok := <-t.queue:
+			if !ok {
+				export()
+				return
+			}
+			if batch = append(batch, span); len(batch) >= maxBatchSize {
+				export()
+			}
+		case <-ticker.C:
+			export()
+		case ack := <-t.flushes:
+			for drained := false; !drained; {
+				select {
+				case span := <-t.queue:
+					if batch = append(batch, span); len(batch) >= maxBatchSize {
+						export()
+					}
+				default:
+					drained = true
+				}
+			}
+			export()
+			close(ack)
+		}
+	}
+}
+
+// shutdown stops accepting spans, exports the queued ones and shuts down the exporter
+func (t *tracer) shutdown(ctx context.Context) error {
+	t.mu.Lock()
+	if !t.closed {
+		t.closed = true
+		close(t.queue)
+	}
+	t.mu.Unlock()
+
+	select {
+	case <-t.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return t.exporter.Shutdown(ctx)
+}
@@ -0,0 +1,90 @@
+package tracing
+
+import (
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// TraceparentHeader is the W3C trace context header
+const TraceparentHeader = "traceparent"
+
+// Transport wraps an upstream RoundTripper so each request is a client span lasting until
+// its response body is read to the end or closed, with an event when the first byte arrives.
+// The trace context is not forwarded to upstreams, which are third-party APIs.
+func Transport(base http.RoundTripper, attrs ...Attribute) http.RoundTripper {
+	return &transport{base: base, attrs: attrs}
+}
+
+type transport struct {
+	base  http.RoundTripper
+	attrs []Attribute
+}
+
+// RoundTrip implements http.RoundTripper interface
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, span := StartKind(req.Context(), KindClient, req.Method+" "+req.URL.Host, t.attrs...)
+	if span == nil {
+		return t.base.RoundTrip(req)
+	}
+	// The query is left out as some upstreams take credentials there
+	span.SetAttributes(
+		String("http.request.method", req.Method),
+		String("server.address", req.URL.Host),
+		String("url.path", req.URL.Path),
+	)
+
+	resp, err := t.base.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		span.RecordError(err)
+		span.End()
+		return nil, err
+	}
+	span.SetAttributes(Int("http.response.status_code", int64(resp.StatusCode)))
+	span.AddEvent("response_headers")
+	if resp.StatusCode >= http.StatusBadRequest {
+		span.SetStatus(StatusError, resp.Status)
+	}
+	resp.Body = &tracedBody{ReadCloser: resp.Body, span: span}
+	return resp, nil
+}
+
+// tracedBody ends a client span once the response body is done
+type tracedBody struct {
+	io.ReadCloser
+	span  *Span
+	bytes atomic.Int64 // Close may run while a Read is blocked
+	first sync.Once
+	end   sync.Once
+}
+
+// Read implements io.Reader interface
+func (b *tracedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		b.first.Do(func() { b.span.AddEvent("first_byte") })
+		b.bytes.Add(int64(n))
+	}
+	if err == io.EOF {
+		b.finish(nil)
+	} else if err != nil {
+		b.finish(err)
+	}
+	return n, err
+}
+
+// Close implements io.Closer interface
+func (b *tracedBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.finish(nil)
+	return err
+}
+
+func (b *tracedBody) finish(err error) {
+	b.end.Do(func() {
+		b.span.SetAttributes(Int("http.response.body.size", b.bytes.Load()))
+		b.span.RecordError(err)
+		b.span.End()
+	})
+}
@@ -0,0 +1,264 @@
+// Package tracing records OpenTelemetry-compatible spans for requests through the proxy.
+// Trace context arrives from clients in the W3C traceparent header, and finished spans
+// are exported over OTLP/HTTP JSON or printed to stdout. Without a configured exporter
+// Start returns nil spans, whose methods do nothing, so instrumentation costs little.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TraceID identifies a trace
+type TraceID [16]byte
+
+// SpanID identifies a span within a trace
+type SpanID [8]byte
+
+func (t TraceID) String() string { return hex.EncodeToString(t[:]) }
+func (s SpanID) String() string  { return hex.EncodeToString(s[:]) }
+
+// IsValid reports whether the ID is not all zeros, which the W3C format forbids
+func (t TraceID) IsValid() bool { return t != TraceID{} }
+
+// IsValid reports whether the ID is not all zeros, which the W3C format forbids
+func (s SpanID) IsValid() bool { return s != SpanID{} }
+
+// SpanContext is the part of a span that propagates to its children
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+	Remote  bool // Received from a client rather than started here
+}
+
+// ParseTraceparent parses a W3C traceparent header such as
+// 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01
+func ParseTraceparent(value string) (SpanContext, error) {
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return SpanContext{}, fmt.Errorf("malformed traceparent %q", value)
+	}
+	// Version ff is invalid; later versions may append fields, which are ignored
+	if parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return SpanContext{}, fmt.Errorf("unsupported traceparent version in %q", value)
+	}
+
+	var sc SpanContext
+	var version, flags [1]byte
+	for _, field := range []struct {
+		hex string
+		dst []byte
+	}{{parts[0], version[:]}, {parts[1], sc.TraceID[:]}, {parts[2], sc.SpanID[:]}, {parts[3], flags[:]}} {
+		if strings.ToLower(field.hex) != field.hex {
+			return SpanContext{}, fmt.Errorf("traceparent %q is not lowercase hex", value)
+		}
+		if _, err := hex.Decode(field.dst, []byte(field.hex)); err != nil {
+			return SpanContext{}, fmt.Errorf("traceparent %q is not hex: %w", value, err)
+		}
+	}
+	if !sc.TraceID.IsValid() || !sc.SpanID.IsValid() {
+		return SpanContext{}, fmt.Errorf("traceparent %q has a zero ID", value)
+	}
+	sc.Sampled = flags[0]&0x01 == 1
+	sc.Remote = true
+	return sc, nil
+}
+
+// Traceparent formats the span context as a W3C traceparent header
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + sc.TraceID.String() + "-" + sc.SpanID.String() + "-" + flags
+}
+
+// SpanKind describes the relationship of a span to the remote side of a call
+type SpanKind int
+
+// Span kinds, numbered as in OTLP
+const (
+	KindInternal SpanKind = 1
+	KindServer   SpanKind = 2
+	KindClient   SpanKind = 3
+)
+
+// StatusCode is the outcome of a span, numbered as in OTLP
+type StatusCode int
+
+const (
+	StatusUnset StatusCode = 0
+	StatusOK    StatusCode = 1
+	StatusError StatusCode = 2
+)
+
+// Attribute is a key and a string, int64, float64 or bool value
+type Attribute struct {
+	Key   string
+	Value interface{}
+}
+
+// String returns a string attribute
+func String(key, value string) Attribute { return Attribute{key, value} }
+
+// Int returns an integer attribute
+func Int(key string, value int64) Attribute { return Attribute{key, value} }
+
+// Float returns a floating point attribute
+func Float(key string, value float64) Attribute { return Attribute{key, value} }
+
+// Bool returns a boolean attribute
+func Bool(key string, value bool) Attribute { return Attribute{key, value} }
+
+// Event is a timestamped point within a span, such as the first byte of a response
+type Event struct {
+	Name       string
+	Time       time.Time
+	Attributes []Attribute
+}
+
+// SpanData is a finished span as handed to exporters
+type SpanData struct {
+	Name          string
+	Kind          SpanKind
+	Context       SpanContext
+	Parent        SpanID // Zero for root spans
+	Start         time.Time
+	End           time.Time
+	Attributes    []Attribute
+	Events        []Event
+	Status        StatusCode
+	StatusMessage string
+}
+
+// Span records one operation. A nil Span is valid and records nothing.
+type Span struct {
+	mu    sync.Mutex
+	data  SpanData
+	ended bool
+}
+
+type spanKey struct{}
+
+// Start starts an internal span as a child of the span in ctx
+func Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, *Span) {
+	return StartKind(ctx, KindInternal, name, attrs...)
+}
+
+// StartKind starts a span of the given kind as a child of the span in ctx, or as a new
+// trace when ctx has none. It returns ctx unchanged and a nil span when tracing is off
+// or the trace is not sampled.
+func StartKind(ctx context.Context, kind SpanKind, name string, attrs ...Attribute) (context.Context, *Span) {
+	t := current()
+	if t == nil {
+		return ctx, nil
+	}
+
+	parent, hasParent := ctx.Value(spanKey{}).(SpanContext)
+	sc := SpanContext{Sampled: true}
+	if hasParent {
+		sc.TraceID, sc.Sampled = parent.TraceID, parent.Sampled
+	} else {
+		rand.Read(sc.TraceID[:])
+		sc.Sampled = t.sample(sc.TraceID)
+	}
+	rand.Read(sc.SpanID[:])
+	if !sc.Sampled {
+		// Children of an unsampled span are not recorded either
+		return context.WithValue(ctx, spanKey{}, sc), nil
+	}
+
+	span := &Span{data: SpanData{
+		Name:       name,
+		Kind:       kind,
+		Context:    sc,
+		Start:      time.Now(),
+		Attributes: append([]Attribute(nil), attrs...),
+	}}
+	if hasParent {
+		span.data.Parent = parent.SpanID
+	}
+	return context.WithValue(ctx, spanKey{}, sc), span
+}
+
+// WithRemoteParent returns a context whose spans continue a trace started by a client
+func WithRemoteParent(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, spanKey{}, sc)
+}
+
+// SpanContextFromContext returns the context of the current span, if there is one
+func SpanContextFromContext(ctx context.Context) (SpanContext, bool) {
+	sc, ok := ctx.Value(spanKey{}).(SpanContext)
+	return sc, ok
+}
+
+// SetAttributes adds attributes to the span
+func (s *Span) SetAttributes(attrs ...Attribute) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Attributes = append(s.data.Attributes, attrs...)
+}
+
+// AddEvent records an event at the current time
+func (s *Span) AddEvent(name string, attrs ...Attribute) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Events = append(s.data.Events, Event{Name: name, Time: time.Now(), Attributes: attrs})
+}
+
+// RecordError marks the span as failed with err, if err is not nil
+func (s *Span) RecordError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Status = StatusError
+	s.data.StatusMessage = err.Error()
+	s.data.Events = append(s.data.Events, Event{Name: "exception", Time: time.Now(), Attributes: []Attribute{
+		String("exception.message", err.Error()),
+		String("exception.type", fmt.Sprintf("%T", err)),
+	}})
+}
+
+// SetStatus sets the outcome of the span
+func (s *Span) SetStatus(code StatusCode, message string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Status, s.data.StatusMessage = code, message
+}
+
+// End finishes the span and queues it for export. Later calls do nothing.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = time.Now()
+	data := s.data
+	s.mu.Unlock()
+
+	if t := current(); t != nil {
+		t.enqueue(data)
+	}
+}
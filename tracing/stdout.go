@@ -0,0 +1,92 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// StdoutExporter writes each span as a line of JSON, for local debugging and tests
+type StdoutExporter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewStdoutExporter creates an exporter writing to w, usually os.Stdout
+func NewStdoutExporter(w io.Writer) *StdoutExporter {
+	return &StdoutExporter{w: w}
+}
+
+// stdoutSpan is the line written for a span
+type stdoutSpan struct {
+	Name          string                 `json:"name"`
+	Kind          string                 `json:"kind"`
+	TraceID       string                 `json:"trace_id"`
+	SpanID        string                 `json:"span_id"`
+	ParentSpanID  string                 `json:"parent_span_id,omitempty"`
+	Start         time.Time              `json:"start"`
+	DurationMs    float64                `json:"duration_ms"`
+	Attributes    map[string]interface{} `json:"attributes,omitempty"`
+	Events        []stdoutEvent          `json:"events,omitempty"`
+	Status        string                 `json:"status,omitempty"`
+	StatusMessage string                 `json:"status_message,omitempty"`
+}
+
+type stdoutEvent struct {
+	Name       string                 `json:"name"`
+	Time       time.Time              `json:"time"`
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+}
+
+var (
+	kindNames   = map[SpanKind]string{KindInternal: "internal", KindServer: "server", KindClient: "client"}
+	statusNames = map[StatusCode]string{StatusOK: "ok", StatusError: "error"}
+)
+
+// ExportSpans writes the spans
+func (e *StdoutExporter) ExportSpans(ctx context.Context, spans []SpanData) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	enc := json.NewEncoder(e.w)
+	for _, span := range spans {
+		line := stdoutSpan{
+			Name:          span.Name,
+			Kind:          kindNames[span.Kind],
+			TraceID:       span.Context.TraceID.String(),
+			SpanID:        span.Context.SpanID.String(),
+			Start:         span.Start,
+			DurationMs:    float64(span.End.Sub(span.Start).Microseconds()) / 1000,
+			Attributes:    attributeMap(span.Attributes),
+			Status:        statusNames[span.Status],
+			StatusMessage: span.StatusMessage,
+		}
+		if span.Parent.IsValid() {
+			line.ParentSpanID = span.Parent.String()
+		}
+		for _, event := range span.Events {
+			line.Events = append(line.Events, stdoutEvent{Name: event.Name, Time: event.Time, Attributes: attributeMap(event.Attributes)})
+		}
+		if err := enc.Encode(line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Shutdown implements Exporter
+func (e *StdoutExporter) Shutdown(ctx context.Context) error {
+	return nil
+}
+
+func attributeMap(attrs []Attribute) map[string]interface{} {
+	if len(attrs) == 0 {
+		return nil
+	}
+	m := make(map[string]interface{}, len(attrs))
+	for _, attr := range attrs {
+		m[attr.Key] = attr.Value
+	}
+	return m
+}
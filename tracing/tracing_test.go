@@ -0,0 +1,281 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// recordingExporter keeps exported spans for inspection
+type recordingExporter struct {
+	mu    sync.Mutex
+	spans []SpanData
+}
+
+func (e *recordingExporter) ExportSpans(ctx context.Context, spans []SpanData) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = append(e.spans, spans...)
+	return nil
+}
+
+func (e *recordingExporter) Shutdown(ctx context.Context) error { return nil }
+
+// install exports spans to a recordingExporter for the rest of the test
+func install(t *testing.T, ratio float64) *recordingExporter {
+	t.Helper()
+	exporter := &recordingExporter{}
+	if err := Install(exporter, ratio); err != nil {
+		t.Fatalf("Install() error = %v", err)
+	}
+	t.Cleanup(func() { Shutdown(context.Background()) })
+	return exporter
+}
+
+// flushed returns the spans exported so far
+func (e *recordingExporter) flushed(t *testing.T) []SpanData {
+	t.Helper()
+	if err := ForceFlush(context.Background()); err != nil {
+		t.Fatalf("ForceFlush() error = %v", err)
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]SpanData(nil), e.spans...)
+}
+
+func TestParseTraceparent(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		sampled bool
+		wantErr bool
+	}{
+		{"sampled", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true, false},
+		{"not sampled", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", false, false},
+		{"future version with extra field", "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", true, false},
+		{"version 00 with extra field", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", false, true},
+		{"invalid version", "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false, true},
+		{"uppercase", "00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", false, true},
+		{"zero trace id", "00-00000000000000000000000000000000-00f067aa0ba902b7-01", false, true},
+		{"zero span id", "00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", false, true},
+		{"short", "00-4bf92f3577b34da6a3ce929d0e0e4736-01", false, true},
+		{"not hex", "00-4bf92f3577b34da6a3ce929d0e0e473z-00f067aa0ba902b7-01", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sc, err := ParseTraceparent(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseTraceparent(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if sc.Sampled != tt.sampled || !sc.Remote {
+				t.Errorf("ParseTraceparent(%q) = %+v, want sampled %v and remote", tt.value, sc, tt.sampled)
+			}
+			if sc.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" || sc.SpanID.String() != "00f067aa0ba902b7" {
+				t.Errorf("ParseTraceparent(%q) IDs = %s %s", tt.value, sc.TraceID, sc.SpanID)
+			}
+		})
+	}
+
+	sc, _ := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	if got := sc.Traceparent(); got != "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01" {
+		t.Errorf("Traceparent() = %q", got)
+	}
+}
+
+func TestSpansAreNilWithoutExporter(t *testing.T) {
+	ctx, span := Start(context.Background(), "noop")
+	if span != nil {
+		t.Fatalf("Start() without an exporter returned a span")
+	}
+	if _, ok := SpanContextFromContext(ctx); ok {
+		t.Errorf("Start() without an exporter changed the context")
+	}
+	// Methods on the nil span must not panic
+	span.SetAttributes(String("k", "v"))
+	span.AddEvent("event")
+	span.RecordError(errors.New("failed"))
+	span.End()
+}
+
+func TestSpanHierarchy(t *testing.T) {
+	exporter := install(t, 1)
+
+	parent, _ := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	ctx := WithRemoteParent(context.Background(), parent)
+	ctx, server := StartKind(ctx, KindServer, "POST /v1/chat/completions")
+	_, child := Start(ctx, "GetToken", String("auth.authenticator", "kiro"))
+	child.RecordError(errors.New("refresh failed"))
+	child.End()
+	child.End() // Ending twice exports once
+	server.End()
+
+	spans := exporter.flushed(t)
+	if len(spans) != 2 {
+		t.Fatalf("exported %d spans, want 2", len(spans))
+	}
+	token, root := spans[0], spans[1]
+	if root.Context.TraceID != parent.TraceID || root.Parent != parent.SpanID || root.Kind != KindServer {
+		t.Errorf("server span = %+v, want a server child of the client's span", root)
+	}
+	if token.Context.TraceID != parent.TraceID || token.Parent != root.Context.SpanID {
+		t.Errorf("GetToken span is not a child of the server span: %+v", token)
+	}
+	if token.Status != StatusError || token.StatusMessage != "refresh failed" || len(token.Events) != 1 {
+		t.Errorf("GetToken span did not record the error: %+v", token)
+	}
+}
+
+func TestSampling(t *testing.T) {
+	exporter := install(t, 0)
+
+	// New traces are dropped at ratio 0, along with their children
+	ctx, span := Start(context.Background(), "dropped")
+	if span != nil {
+		t.Errorf("Start() at ratio 0 returned a span")
+	}
+	if _, child := Start(ctx, "child"); child != nil {
+		t.Errorf("child of an unsampled span was recorded")
+	}
+
+	// A client's sampling decision wins over the ratio
+	parent, _ := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	_, span = Start(WithRemoteParent(context.Background(), parent), "kept")
+	span.End()
+	if spans := exporter.flushed(t); len(spans) != 1 || spans[0].Name != "kept" {
+		t.Errorf("exported %+v, want only the span of the sampled trace", spans)
+	}
+}
+
+func TestTransportSpan(t *testing.T) {
+	exporter := install(t, 1)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(TraceparentHeader) != "" {
+			t.Errorf("trace context was forwarded upstream")
+		}
+		w.WriteHeader(http.StatusTooManyRequests)
+		io.WriteString(w, "slow down")
+	}))
+	defer upstream.Close()
+
+	client := &http.Client{Transport: Transport(http.DefaultTransport, String("proxy.provider", "qwen"))}
+	ctx, parent := Start(context.Background(), "parent")
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, upstream.URL+"/v1/chat?key=secret", nil)
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Do() error = %v", err)
+	}
+	if spans := exporter.flushed(t); len(spans) != 0 {
+		t.Errorf("client span ended before the body was read: %+v", spans)
+	}
+	io.ReadAll(resp.Body)
+	resp.Body.Close()
+	parent.End()
+
+	spans := exporter.flushed(t)
+	if len(spans) != 2 {
+		t.Fatalf("exported %d spans, want 2", len(spans))
+	}
+	span := spans[0]
+	attrs := attributeMap(span.Attributes)
+	if span.Kind != KindClient || span.Parent != spans[1].Context.SpanID {
+		t.Errorf("upstream span = %+v, want a client child of the parent", span)
+	}
+	if attrs["url.path"] != "/v1/chat" || attrs["proxy.provider"] != "qwen" || attrs["http.response.status_code"] != int64(429) || attrs["http.response.body.size"] != int64(9) {
+		t.Errorf("upstream span attributes = %v", attrs)
+	}
+	if span.Status != StatusError {
+		t.Errorf("upstream span status = %v, want error for a 429", span.Status)
+	}
+	var events []string
+	for _, event := range span.Events {
+		events = append(events, event.Name)
+	}
+	if strings.Join(events, ",") != "response_headers,first_byte" {
+		t.Errorf("upstream span events = %v", events)
+	}
+}
+
+func TestOTLPExporter(t *testing.T) {
+	var body map[string]interface{}
+	var header http.Header
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+		json.NewDecoder(r.Body).Decode(&body)
+	}))
+	defer collector.Close()
+
+	parent, _ := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	exporter := NewOTLPExporter(collector.URL+"/v1/traces", map[string]string{"Authorization": "Bearer token"}, "proxy-test")
+	err := exporter.ExportSpans(context.Background(), []SpanData{{
+		Name:       "GetToken",
+		Kind:       KindInternal,
+		Context:    SpanContext{TraceID: parent.TraceID, SpanID: SpanID{1, 2, 3, 4, 5, 6, 7, 8}},
+		Parent:     parent.SpanID,
+		Attributes: []Attribute{String("auth.authenticator", "kiro"), Int("attempt", 2), Bool("refreshed", true)},
+		Status:     StatusError,
+	}})
+	if err != nil {
+		t.Fatalf("ExportSpans() error = %v", err)
+	}
+	if header.Get("Authorization") != "Bearer token" || header.Get("Content-Type") != "application/json" {
+		t.Errorf("export headers = %v", header)
+	}
+
+	resourceSpans := body["resourceSpans"].([]interface{})[0].(map[string]interface{})
+	service := resourceSpans["resource"].(map[string]interface{})["attributes"].([]interface{})[0].(map[string]interface{})
+	if service["key"] != "service.name" || service["value"].(map[string]interface{})["stringValue"] != "proxy-test" {
+		t.Errorf("resource attributes = %v", service)
+	}
+	span := resourceSpans["scopeSpans"].([]interface{})[0].(map[string]interface{})["spans"].([]interface{})[0].(map[string]interface{})
+	if span["traceId"] != "4bf92f3577b34da6a3ce929d0e0e4736" || span["spanId"] != "0102030405060708" || span["parentSpanId"] != "00f067aa0ba902b7" {
+		t.Errorf("span IDs = %v %v %v", span["traceId"], span["spanId"], span["parentSpanId"])
+	}
+	attrs := span["attributes"].([]interface{})
+	if attempt := attrs[1].(map[string]interface{})["value"].(map[string]interface{}); attempt["intValue"] != "2" {
+		t.Errorf("int attribute = %v, want the string \"2\"", attempt)
+	}
+	if span["status"].(map[string]interface{})["code"] != float64(StatusError) {
+		t.Errorf("status = %v", span["status"])
+	}
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "bad payload", http.StatusBadRequest)
+	}))
+	defer failing.Close()
+	if err := NewOTLPExporter(failing.URL, nil, "proxy-test").ExportSpans(context.Background(), nil); err == nil || !strings.Contains(err.Error(), "bad payload") {
+		t.Errorf("ExportSpans() to a failing collector error = %v", err)
+	}
+}
+
+func TestStdoutExporter(t *testing.T) {
+	var out bytes.Buffer
+	exporter := NewStdoutExporter(&out)
+	err := exporter.ExportSpans(context.Background(), []SpanData{
+		{Name: "first", Kind: KindServer, Context: SpanContext{TraceID: TraceID{1}, SpanID: SpanID{1}}},
+		{Name: "second", Kind: KindClient, Context: SpanContext{TraceID: TraceID{1}, SpanID: SpanID{2}}, Parent: SpanID{1}, Status: StatusError},
+	})
+	if err != nil {
+		t.Fatalf("ExportSpans() error = %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("wrote %d lines, want 2:\n%s", len(lines), out.String())
+	}
+	var second stdoutSpan
+	if err := json.Unmarshal([]byte(lines[1]), &second); err != nil {
+		t.Fatalf("line is not JSON: %v", err)
+	}
+	if second.Name != "second" || second.Kind != "client" || second.ParentSpanID != "0100000000000000" || second.Status != "error" {
+		t.Errorf("second span = %+v", second)
+	}
+}
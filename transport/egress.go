@@ -0,0 +1,162 @@
+package transport
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/sunbankio/qwencoder-proxy/config"
+)
+
+// egress is the resolved proxy selection and TLS configuration for one provider
+type egress struct {
+	proxy func(*http.Request) (*url.URL, error)
+	tls   *tls.Config // nil trusts the system roots only
+}
+
+// newEgress validates egress settings and prepares them for use by a transport
+func newEgress(cfg config.EgressConfig) (*egress, error) {
+	e := &egress{}
+
+	noProxy := parseNoProxy(cfg.NoProxy)
+	switch cfg.ProxyURL {
+	case "":
+		e.proxy = func(req *http.Request) (*url.URL, error) {
+			if noProxy.matches(req.URL) {
+				return nil, nil
+			}
+			return http.ProxyFromEnvironment(req)
+		}
+	case config.DirectProxy:
+		e.proxy = nil
+	default:
+		proxyURL, err := url.Parse(cfg.ProxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy URL %q: %w", cfg.ProxyURL, err)
+		}
+		switch proxyURL.Scheme {
+		case "http", "https", "socks5", "socks5h":
+		default:
+			return nil, fmt.Errorf("unsupported proxy scheme %q in %q", proxyURL.Scheme, cfg.ProxyURL)
+		}
+		if proxyURL.Host == "" {
+			return nil, fmt.Errorf("proxy URL %q has no host", cfg.ProxyURL)
+		}
+		e.proxy = func(req *http.Request) (*url.URL, error) {
+			if noProxy.matches(req.URL) {
+				return nil, nil
+			}
+			return proxyURL, nil
+		}
+	}
+
+	if len(cfg.CABundles) > 0 {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		for _, path := range cfg.CABundles {
+			pem, err := os.ReadFile(path)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read CA bundle: %w", err)
+			}
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no certificates found in CA bundle %s", path)
+			}
+		}
+		e.tls = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	}
+	return e, nil
+}
+
+// apply configures a transport to use the egress settings
+func (e *egress) apply(t *http.Transport) {
+	t.Proxy = e.proxy
+	if e.tls != nil {
+		t.TLSClientConfig = e.tls.Clone()
+	}
+}
+
+// noProxyRule is one entry of a no-proxy list
+type noProxyRule struct {
+	all    bool       // "*" matches every host
+	cidr   *net.IPNet // Matches IP addresses in the range
+	host   string     // Matches the host, and its subdomains unless exact is set
+	exact  bool
+	suffix bool // Entry began with "." or "*.", so only subdomains match
+	port   string
+}
+
+type noProxyList []noProxyRule
+
+// parseNoProxy parses a comma- or space-separated list in NO_PROXY syntax:
+// "*", IP addresses, CIDR ranges, and host names with an optional port.
+// A host name also matches its subdomains; a leading "." or "*." matches only subdomains.
+func parseNoProxy(value string) noProxyList {
+	var rules noProxyList
+	for _, entry := range strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == ' ' }) {
+		entry = strings.ToLower(entry)
+		if entry == "*" {
+			rules = append(rules, noProxyRule{all: true})
+			continue
+		}
+		if _, cidr, err := net.ParseCIDR(entry); err == nil {
+			rules = append(rules, noProxyRule{cidr: cidr})
+			continue
+		}
+
+		rule := noProxyRule{host: entry}
+		if host, port, err := net.SplitHostPort(entry); err == nil {
+			rule.host, rule.port = host, port
+		}
+		if ip := net.ParseIP(strings.Trim(rule.host, "[]")); ip != nil {
+			rule.host, rule.exact = ip.String(), true
+		} else if trimmed, ok := strings.CutPrefix(rule.host, "*."); ok {
+			rule.host, rule.suffix = trimmed, true
+		} else if trimmed, ok := strings.CutPrefix(rule.host, "."); ok {
+			rule.host, rule.suffix = trimmed, true
+		}
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// matches reports whether requests to u bypass the proxy
+func (l noProxyList) matches(u *url.URL) bool {
+	host := strings.ToLower(u.Hostname())
+	port := u.Port()
+	if port == "" {
+		port = map[string]string{"http": "80", "https": "443"}[u.Scheme]
+	}
+	ip := net.ParseIP(host)
+	if ip != nil {
+		host = ip.String()
+	}
+
+	for _, rule := range l {
+		switch {
+		case rule.all:
+			return true
+		case rule.cidr != nil:
+			if ip != nil && rule.cidr.Contains(ip) {
+				return true
+			}
+			continue
+		}
+		if rule.port != "" && rule.port != port {
+			continue
+		}
+		if host == rule.host && !rule.suffix {
+			return true
+		}
+		if !rule.exact && strings.HasSuffix(host, "."+rule.host) {
+			return true
+		}
+	}
+	return false
+}
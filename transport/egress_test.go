@@ -0,0 +1,143 @@
+package transport
+
+import (
+	"encoding/pem"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sunbankio/qwencoder-proxy/config"
+)
+
+func TestNoProxyMatches(t *testing.T) {
+	list := parseNoProxy("localhost, .internal.example.com,10.0.0.0/8 api.example.com:8443,*.corp,::1")
+	tests := []struct {
+		url  string
+		want bool
+	}{
+		{"http://localhost:8080/x", true},
+		{"https://internal.example.com", false},
+		{"https://auth.internal.example.com", true},
+		{"https://10.1.2.3/v1", true},
+		{"https://11.1.2.3/v1", false},
+		{"https://api.example.com:8443", true},
+		{"https://api.example.com", false},
+		{"https://build.corp", true},
+		{"https://corp", false},
+		{"http://[::1]:9000", true},
+		{"https://oauth2.googleapis.com/token", false},
+	}
+
+	for _, tt := range tests {
+		u, _ := url.Parse(tt.url)
+		if got := list.matches(u); got != tt.want {
+			t.Errorf("matches(%s) = %v, want %v", tt.url, got, tt.want)
+		}
+	}
+
+	if u, _ := url.Parse("https://anything"); !parseNoProxy("*").matches(u) {
+		t.Error("* should match every host")
+	}
+}
+
+func TestNewEgressRejectsInvalidSettings(t *testing.T) {
+	empty := filepath.Join(t.TempDir(), "empty.pem")
+	os.WriteFile(empty, []byte("not a certificate"), 0o600)
+
+	tests := []struct {
+		name string
+		cfg  config.EgressConfig
+		want string
+	}{
+		{"unsupported scheme", config.EgressConfig{ProxyURL: "ftp://proxy:21"}, "unsupported proxy scheme"},
+		{"missing host", config.EgressConfig{ProxyURL: "socks5://"}, "has no host"},
+		{"missing bundle", config.EgressConfig{CABundles: []string{filepath.Join(t.TempDir(), "missing.pem")}}, "failed to read CA bundle"},
+		{"bundle without certificates", config.EgressConfig{CABundles: []string{empty}}, "no certificates found"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := newEgress(tt.cfg); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("newEgress() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestClientsUseConfiguredProxy(t *testing.T) {
+	var proxied []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// A forward proxy receives the absolute upstream URL
+		proxied = append(proxied, r.URL.String())
+		io.WriteString(w, "via proxy")
+	}))
+	defer proxy.Close()
+
+	cfg := config.DefaultConfig()
+	cfg.HTTPClient.Egress = config.EgressConfig{ProxyURL: proxy.URL, NoProxy: "direct.example"}
+	cfg.HTTPClient.ProviderEgress = map[string]config.EgressConfig{"iflow": {ProxyURL: config.DirectProxy}}
+	configure(t, cfg)
+
+	for _, client := range []*http.Client{NewClient("kiro"), NewAuthClient("kiro")} {
+		resp, err := client.Get("http://upstream.example/v1/models")
+		if err != nil {
+			t.Fatalf("Get() error = %v", err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(body) != "via proxy" {
+			t.Errorf("body = %q, want the proxy's response", body)
+		}
+	}
+	if len(proxied) != 2 || proxied[0] != "http://upstream.example/v1/models" {
+		t.Errorf("proxied requests = %v", proxied)
+	}
+
+	// Direct providers and no-proxy hosts bypass the proxy, so these requests fail to resolve
+	for _, tc := range []struct{ provider, url string }{
+		{"iflow", "http://upstream.invalid/"},
+		{"kiro", "http://direct.example.invalid/"},
+	} {
+		proxied = nil
+		if tc.provider == "kiro" {
+			cfg.HTTPClient.Egress.NoProxy = "direct.example.invalid"
+			configure(t, cfg)
+		}
+		if _, err := NewAuthClient(tc.provider).Get(tc.url); err == nil || len(proxied) != 0 {
+			t.Errorf("%s request to %s went through the proxy", tc.provider, tc.url)
+		}
+	}
+}
+
+func TestClientsTrustCABundles(t *testing.T) {
+	upstream := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+	defer upstream.Close()
+
+	bundle := filepath.Join(t.TempDir(), "ca.pem")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: upstream.Certificate().Raw})
+	if err := os.WriteFile(bundle, certPEM, 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	cfg := config.DefaultConfig()
+	cfg.HTTPClient.Egress.ProxyURL = config.DirectProxy
+	configure(t, cfg)
+	if _, err := NewClient("gemini-cli").Get(upstream.URL); err == nil {
+		t.Fatal("expected the test certificate to be untrusted without a CA bundle")
+	}
+
+	cfg.HTTPClient.ProviderEgress = map[string]config.EgressConfig{"gemini-cli": {CABundles: []string{bundle}}}
+	configure(t, cfg)
+	resp, err := NewClient("gemini-cli").Get(upstream.URL)
+	if err != nil {
+		t.Fatalf("Get() with CA bundle error = %v", err)
+	}
+	resp.Body.Close()
+}
@@ -0,0 +1,243 @@
+// Package transport builds the HTTP clients providers and their authenticators use to
+// reach upstreams. Clients connect through the configured egress proxy and trust the
+// configured CA bundles, and provider clients enforce connect, TLS handshake,
+// time-to-first-byte, inter-chunk idle and total deadlines. Settings are configured
+// globally and overridden per provider, and timeouts also per model.
+package transport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/sunbankio/qwencoder-proxy/audit"
+	"github.com/sunbankio/qwencoder-proxy/config"
+	"github.com/sunbankio/qwencoder-proxy/tracing"
+)
+
+// authTimeout bounds OAuth and token requests, which are small and infrequent
+const authTimeout = 30 * time.Second
+
+var (
+	settingsMu sync.RWMutex
+	settings   = config.DefaultConfig()
+)
+
+// Configure sets the HTTP client settings used by clients and requests made afterwards.
+// It returns an error when a proxy URL or CA bundle is invalid.
+func Configure(cfg *config.Config) error {
+	if _, err := newEgress(cfg.HTTPClient.Egress); err != nil {
+		return err
+	}
+	for name := range cfg.HTTPClient.ProviderEgress {
+		if _, err := newEgress(cfg.HTTPClient.EgressFor(name)); err != nil {
+			return fmt.Errorf("provider %s: %w", name, err)
+		}
+	}
+
+	settingsMu.Lock()
+	defer settingsMu.Unlock()
+	settings = cfg
+	return nil
+}
+
+func currentSettings() *config.Config {
+	settingsMu.RLock()
+	defer settingsMu.RUnlock()
+	return settings
+}
+
+// TimeoutError reports an upstream request that exceeded one of its deadlines.
+// It matches context.DeadlineExceeded with errors.Is.
+type TimeoutError struct {
+	Timeout string // One of the config.Timeout* names
+	After   time.Duration
+}
+
+func (e *TimeoutError) Error() string {
+	switch e.Timeout {
+	case config.TimeoutFirstByte:
+		return fmt.Sprintf("upstream sent no data within %s", e.After)
+	case config.TimeoutIdle:
+		return fmt.Sprintf("upstream stalled for %s between chunks", e.After)
+	default:
+		return fmt.Sprintf("upstream request exceeded %s", e.After)
+	}
+}
+
+// Unwrap lets callers match the error against context.DeadlineExceeded
+func (e *TimeoutError) Unwrap() error {
+	return context.DeadlineExceeded
+}
+
+type requestInfoKey struct{}
+
+type requestInfo struct {
+	model     string
+	streaming bool
+}
+
+// WithModel annotates a request context with the model and response mode, which select
+// the timeouts that apply to requests made with it
+func WithModel(ctx context.Context, model string, streaming bool) context.Context {
+	return context.WithValue(ctx, requestInfoKey{}, requestInfo{model: model, streaming: streaming})
+}
+
+// NewClient returns an HTTP client for a provider's upstream requests.
+// The client has no overall timeout of its own; every request is bounded by the
+// provider's configured deadlines instead, so long streams are not cut off.
+func NewClient(providerName string) *http.Client {
+	base := &deadlineTransport{base: providerTransport(providerName), provider: providerName}
+	return &http.Client{Transport: tracing.Transport(audit.Transport(base), tracing.String("proxy.provider", providerName))}
+}
+
+// NewAuthClient returns an HTTP client for a provider's OAuth and token requests.
+// It uses the same proxy and CA bundles as the provider's API requests.
+func NewAuthClient(providerName string) *http.Client {
+	base := providerTransport(providerName)
+	return &http.Client{Timeout: authTimeout, Transport: tracing.Transport(base, tracing.String("proxy.provider", providerName))}
+}
+
+// providerTransport builds the connection pool for a provider from the shared client settings
+func providerTransport(providerName string) http.RoundTripper {
+	cfg := currentSettings()
+	timeouts := cfg.HTTPClient.Timeouts(providerName, "", false)
+	e, err := newEgress(cfg.HTTPClient.EgressFor(providerName))
+	if err != nil {
+		// Configure rejects invalid settings, so this only happens if a CA bundle changed since
+		return errorTransport{err: fmt.Errorf("provider %s: %w", providerName, err)}
+	}
+
+	base := cfg.SharedHTTPClient().Transport.(*http.Transport)
+	dialer := &net.Dialer{Timeout: timeouts.Connect, KeepAlive: 30 * time.Second}
+	base.DialContext = dialer.DialContext
+	base.TLSHandshakeTimeout = timeouts.TLSHandshake
+	e.apply(base)
+	return base
+}
+
+// errorTransport fails every request with an egress configuration error
+type errorTransport struct {
+	err error
+}
+
+// RoundTrip implements http.RoundTripper interface
+func (t errorTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return nil, t.err
+}
+
+// deadlineTransport applies the per-request deadlines to a provider's requests
+type deadlineTransport struct {
+	base     http.RoundTripper
+	provider string
+}
+
+// RoundTrip implements http.RoundTripper interface
+func (t *deadlineTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	info, _ := req.Context().Value(requestInfoKey{}).(requestInfo)
+	timeouts := currentSettings().HTTPClient.Timeouts(t.provider, info.model, info.streaming)
+
+	ctx, cancel := context.WithCancelCause(req.Context())
+	total := startDeadline(cancel, config.TimeoutTotal, timeouts.Total)
+	firstByte := startDeadline(cancel, config.TimeoutFirstByte, timeouts.FirstByte)
+
+	resp, err := t.base.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		stopDeadline(total)
+		stopDeadline(firstByte)
+		err = timeoutCause(ctx, err)
+		cancel(nil)
+		return nil, err
+	}
+
+	body := &deadlineBody{
+		body:        resp.Body,
+		ctx:         ctx,
+		cancel:      cancel,
+		total:       total,
+		firstByte:   firstByte,
+		idleTimeout: timeouts.Idle,
+	}
+	if firstByte == nil {
+		// Without a first byte deadline the silence is measured from the response headers
+		body.idle = startDeadline(cancel, config.TimeoutIdle, timeouts.Idle)
+	}
+	resp.Body = body
+	return resp, nil
+}
+
+// startDeadline cancels a request with a TimeoutError once d elapses; it returns nil when d is zero
+func startDeadline(cancel context.CancelCauseFunc, timeout string, d time.Duration) *time.Timer {
+	if d <= 0 {
+		return nil
+	}
+	return time.AfterFunc(d, func() {
+		cancel(&TimeoutError{Timeout: timeout, After: d})
+	})
+}
+
+func stopDeadline(timer *time.Timer) {
+	if timer != nil {
+		timer.Stop()
+	}
+}
+
+// timeoutCause replaces the error of a request cancelled by a deadline with the TimeoutError
+func timeoutCause(ctx context.Context, err error) error {
+	var timeoutErr *TimeoutError
+	if errors.As(context.Cause(ctx), &timeoutErr) {
+		return timeoutErr
+	}
+	return err
+}
+
+// deadlineBody enforces the first byte and idle deadlines while a response body is read.
+// The idle deadline starts once the first byte has arrived.
+type deadlineBody struct {
+	body        io.ReadCloser
+	ctx         context.Context
+	cancel      context.CancelCauseFunc
+	idleTimeout time.Duration
+
+	mu        sync.Mutex // Guards the timers; Close may race with a blocked Read
+	total     *time.Timer
+	firstByte *time.Timer
+	idle      *time.Timer
+}
+
+// Read implements io.Reader interface
+func (b *deadlineBody) Read(p []byte) (int, error) {
+	n, err := b.body.Read(p)
+	if n > 0 {
+		b.mu.Lock()
+		if b.firstByte != nil {
+			b.firstByte.Stop()
+			b.firstByte = nil
+			b.idle = startDeadline(b.cancel, config.TimeoutIdle, b.idleTimeout)
+		} else if b.idle != nil {
+			b.idle.Reset(b.idleTimeout)
+		}
+		b.mu.Unlock()
+	}
+	if err != nil && err != io.EOF {
+		err = timeoutCause(b.ctx, err)
+	}
+	return n, err
+}
+
+// Close implements io.Closer interface
+func (b *deadlineBody) Close() error {
+	b.mu.Lock()
+	stopDeadline(b.total)
+	stopDeadline(b.firstByte)
+	stopDeadline(b.idle)
+	b.mu.Unlock()
+	err := b.body.Close()
+	b.cancel(nil)
+	return err
+}
@@ -0,0 +1,116 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sunbankio/qwencoder-proxy/config"
+)
+
+// withTimeouts configures one-second deadlines for the duration of a test
+func withTimeouts(t *testing.T, firstByte, idle, streamTotal int) {
+	t.Helper()
+	cfg := config.DefaultConfig()
+	cfg.HTTPClient.FirstByteTimeoutSeconds = firstByte
+	cfg.HTTPClient.ReadTimeoutSeconds = idle
+	cfg.HTTPClient.StreamingTimeoutSeconds = streamTotal
+	configure(t, cfg)
+}
+
+// configure applies settings for the duration of a test
+func configure(t *testing.T, cfg *config.Config) {
+	t.Helper()
+	previous := currentSettings()
+	if err := Configure(cfg); err != nil {
+		t.Fatalf("Configure() error = %v", err)
+	}
+	t.Cleanup(func() { Configure(previous) })
+}
+
+// chunkServer flushes headers, waits delay, then writes a chunk every interval until count chunks are sent
+func chunkServer(t *testing.T, delay, interval time.Duration, count int) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		wait := delay
+		for i := 0; i < count; i++ {
+			select {
+			case <-time.After(wait):
+			case <-r.Context().Done():
+				return
+			}
+			w.Write([]byte("data: chunk\n\n"))
+			w.(http.Flusher).Flush()
+			wait = interval
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func streamBody(t *testing.T, url string) ([]byte, error) {
+	t.Helper()
+	ctx := WithModel(context.Background(), "test-model", true)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, nil)
+	if err != nil {
+		t.Fatalf("NewRequest() error = %v", err)
+	}
+	resp, err := NewClient("test").Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return io.ReadAll(resp.Body)
+}
+
+func TestFirstByteTimeout(t *testing.T) {
+	withTimeouts(t, 1, 0, 0)
+	srv := chunkServer(t, 3*time.Second, 0, 1)
+
+	start := time.Now()
+	_, err := streamBody(t, srv.URL)
+	var timeoutErr *TimeoutError
+	if !errors.As(err, &timeoutErr) || timeoutErr.Timeout != config.TimeoutFirstByte {
+		t.Fatalf("error = %v, want first byte timeout", err)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("error %v does not match context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("timeout took %s", elapsed)
+	}
+}
+
+func TestIdleTimeout(t *testing.T) {
+	withTimeouts(t, 1, 1, 0)
+	srv := chunkServer(t, 0, 3*time.Second, 2)
+
+	body, err := streamBody(t, srv.URL)
+	var timeoutErr *TimeoutError
+	if !errors.As(err, &timeoutErr) || timeoutErr.Timeout != config.TimeoutIdle {
+		t.Fatalf("error = %v, want idle timeout", err)
+	}
+	if string(body) != "data: chunk\n\n" {
+		t.Errorf("body = %q, want the first chunk", body)
+	}
+}
+
+func TestLongStreamWithinDeadlines(t *testing.T) {
+	withTimeouts(t, 1, 1, 0)
+	// Lasts well past both deadlines while never going quiet for long
+	srv := chunkServer(t, 200*time.Millisecond, 200*time.Millisecond, 12)
+
+	body, err := streamBody(t, srv.URL)
+	if err != nil {
+		t.Fatalf("stream error = %v", err)
+	}
+	if len(body) != 12*len("data: chunk\n\n") {
+		t.Errorf("got %d bytes, want all 12 chunks", len(body))
+	}
+}
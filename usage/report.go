@@ -0,0 +1,227 @@
+package usage
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Dimensions records can be grouped by
+const (
+	GroupKey      = "key"
+	GroupProvider = "provider"
+	GroupModel    = "model"
+	GroupAccount  = "account"
+	GroupStatus   = "status"
+	GroupDay      = "day"
+	GroupHour     = "hour"
+)
+
+// groupValue returns the value of a dimension for a record
+func groupValue(r Record, dimension string) string {
+	switch dimension {
+	case GroupKey:
+		return r.Key
+	case GroupProvider:
+		return r.Provider
+	case GroupModel:
+		return r.Model
+	case GroupAccount:
+		return r.Account
+	case GroupStatus:
+		return strconv.Itoa(r.Status)
+	case GroupDay:
+		return r.Time.UTC().Format("2006-01-02")
+	case GroupHour:
+		return r.Time.UTC().Format("2006-01-02T15:00Z")
+	}
+	return ""
+}
+
+func isDimension(name string) bool {
+	switch name {
+	case GroupKey, GroupProvider, GroupModel, GroupAccount, GroupStatus, GroupDay, GroupHour:
+		return true
+	}
+	return false
+}
+
+// defaultRange is the report period when no start time is given
+const defaultRange = 24 * time.Hour
+
+// Query selects and groups records for a report
+type Query struct {
+	From    time.Time
+	To      time.Time
+	GroupBy []string
+	// Filters; empty values match every record
+	Key      string
+	Provider string
+	Model    string
+}
+
+// ParseQuery builds a query from the parameters from, to, group_by, key, provider and model.
+// Times are RFC 3339 timestamps, dates, or durations before now such as "24h". The range
+// defaults to the last 24 hours.
+func ParseQuery(values url.Values, now time.Time) (Query, error) {
+	q := Query{
+		From:     now.Add(-defaultRange),
+		To:       now,
+		Key:      values.Get("key"),
+		Provider: values.Get("provider"),
+		Model:    values.Get("model"),
+	}
+	for _, param := range []struct {
+		name   string
+		target *time.Time
+	}{{"from", &q.From}, {"to", &q.To}} {
+		if value := values.Get(param.name); value != "" {
+			t, err := ParseTime(value, now)
+			if err != nil {
+				return Query{}, fmt.Errorf("invalid %s: %w", param.name, err)
+			}
+			*param.target = t
+		}
+	}
+	if !q.From.Before(q.To) {
+		return Query{}, fmt.Errorf("from %s is not before to %s", q.From.Format(time.RFC3339), q.To.Format(time.RFC3339))
+	}
+
+	for _, value := range values["group_by"] {
+		for _, dimension := range strings.Split(value, ",") {
+			if dimension = strings.TrimSpace(dimension); dimension == "" {
+				continue
+			}
+			if !isDimension(dimension) {
+				return Query{}, fmt.Errorf("cannot group by %q: want key, provider, model, account, status, day or hour", dimension)
+			}
+			q.GroupBy = append(q.GroupBy, dimension)
+		}
+	}
+	return q, nil
+}
+
+// ParseTime parses an RFC 3339 timestamp, a UTC date, or a duration before now
+func ParseTime(value string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		return t, nil
+	}
+	if d, err := time.ParseDuration(value); err == nil && d >= 0 {
+		return now.Add(-d), nil
+	}
+	return time.Time{}, fmt.Errorf("%q is not an RFC 3339 time, a date or a duration", value)
+}
+
+// matches reports whether a record passes the query's filters
+func (q Query) matches(r Record) bool {
+	return (q.Key == "" || r.Key == q.Key) &&
+		(q.Provider == "" || r.Provider == q.Provider) &&
+		(q.Model == "" || r.Model == q.Model)
+}
+
+// Row is the usage of one group of records
+type Row struct {
+	Group            map[string]string `json:"group,omitempty"`
+	Requests         int64             `json:"requests"`
+	Errors           int64             `json:"errors"`
+	PromptTokens     int64             `json:"prompt_tokens"`
+	CompletionTokens int64             `json:"completion_tokens"`
+	CachedTokens     int64             `json:"cached_tokens"`
+	TotalTokens      int64             `json:"total_tokens"`
+	AvgLatencyMs     int64             `json:"avg_latency_ms"`
+	MaxLatencyMs     int64             `json:"max_latency_ms"`
+
+	latencyMs int64 // Sum, for the average
+	sortKey   string
+}
+
+// Report summarizes the records matching a query, one row per group sorted by group values
+func (s *Store) Report(q Query) ([]Row, error) {
+	groups := make(map[string]*Row)
+	err := s.Scan(q.From, q.To, func(r Record) error {
+		if !q.matches(r) {
+			return nil
+		}
+		values := make([]string, len(q.GroupBy))
+		for i, dimension := range q.GroupBy {
+			values[i] = groupValue(r, dimension)
+		}
+		sortKey := strings.Join(values, "\x00")
+		row, ok := groups[sortKey]
+		if !ok {
+			row = &Row{sortKey: sortKey}
+			if len(q.GroupBy) > 0 {
+				row.Group = make(map[string]string, len(q.GroupBy))
+				for i, dimension := range q.GroupBy {
+					row.Group[dimension] = values[i]
+				}
+			}
+			groups[sortKey] = row
+		}
+		row.add(r)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	rows := make([]Row, 0, len(groups))
+	for _, row := range groups {
+		if row.Requests > 0 {
+			row.AvgLatencyMs = row.latencyMs / row.Requests
+		}
+		rows = append(rows, *row)
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].sortKey < rows[j].sortKey })
+	return rows, nil
+}
+
+func (row *Row) add(r Record) {
+	row.Requests++
+	if r.Error {
+		row.Errors++
+	}
+	row.PromptTokens += r.PromptTokens
+	row.CompletionTokens += r.CompletionTokens
+	row.CachedTokens += r.CachedTokens
+	row.TotalTokens += r.PromptTokens + r.CompletionTokens
+	row.latencyMs += r.LatencyMs
+	row.MaxLatencyMs = max(row.MaxLatencyMs, r.LatencyMs)
+}
+
+// Columns returns the column names of a report grouped by groupBy
+func Columns(groupBy []string) []string {
+	return append(append([]string(nil), groupBy...),
+		"requests", "errors", "prompt_tokens", "completion_tokens", "cached_tokens", "total_tokens", "avg_latency_ms", "max_latency_ms")
+}
+
+// Values returns a row's values in the order of Columns
+func (row Row) Values(groupBy []string) []string {
+	values := make([]string, 0, len(groupBy)+8)
+	for _, dimension := range groupBy {
+		values = append(values, row.Group[dimension])
+	}
+	for _, n := range []int64{row.Requests, row.Errors, row.PromptTokens, row.CompletionTokens, row.CachedTokens, row.TotalTokens, row.AvgLatencyMs, row.MaxLatencyMs} {
+		values = append(values, strconv.FormatInt(n, 10))
+	}
+	return values
+}
+
+// WriteCSV writes a report as CSV with a header row
+func WriteCSV(w io.Writer, groupBy []string, rows []Row) error {
+	cw := csv.NewWriter(w)
+	cw.Write(Columns(groupBy))
+	for _, row := range rows {
+		cw.Write(row.Values(groupBy))
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,185 @@
+// Package usage records completed proxy requests and summarizes them for reporting.
+// Records are appended as JSON lines to one file per UTC day, so the store needs no
+// database and reports over a time range only read the days in that range.
+package usage
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Record describes one completed request
+type Record struct {
+	Time             time.Time `json:"time"` // When the request started
+	Key              string    `json:"key,omitempty"`
+	Provider         string    `json:"provider"`
+	Model            string    `json:"model"`
+	Account          string    `json:"account,omitempty"`
+	Stream           bool      `json:"stream,omitempty"`
+	PromptTokens     int64     `json:"prompt_tokens"`
+	CompletionTokens int64     `json:"completion_tokens"`
+	CachedTokens     int64     `json:"cached_tokens,omitempty"`
+	LatencyMs        int64     `json:"latency_ms"`
+	Status           int       `json:"status"`
+	Error            bool      `json:"error,omitempty"` // Set for error statuses and streams that ended with an error event
+}
+
+// segmentLayout names the file holding a day's records
+const segmentLayout = "2006-01-02"
+
+// Store appends records to daily segment files in a directory
+type Store struct {
+	dir       string
+	retention time.Duration // Segments older than this are deleted; 0 keeps them all
+
+	mu   sync.Mutex
+	day  string
+	file *os.File
+}
+
+// Open opens the store in dir, creating the directory if needed. Segments older than
+// retentionDays are deleted as days roll over; 0 keeps every segment.
+func Open(dir string, retentionDays int) (*Store, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create usage directory: %w", err)
+	}
+	return &Store{dir: dir, retention: time.Duration(retentionDays) * 24 * time.Hour}, nil
+}
+
+// Add appends a record to the segment of its day
+func (s *Store) Add(r Record) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("failed to encode usage record: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	day := r.Time.UTC().Format(segmentLayout)
+	if s.file == nil || day != s.day {
+		if err := s.rotate(day, r.Time); err != nil {
+			return err
+		}
+	}
+	if _, err := s.file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write usage record: %w", err)
+	}
+	return nil
+}
+
+// rotate switches to the segment of day and prunes expired segments
+func (s *Store) rotate(day string, now time.Time) error {
+	if s.file != nil {
+		s.file.Close()
+		s.file = nil
+	}
+	file, err := os.OpenFile(s.segmentPath(day), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open usage segment: %w", err)
+	}
+	s.file, s.day = file, day
+
+	if s.retention > 0 {
+		cutoff := now.UTC().Add(-s.retention).Format(segmentLayout)
+		days, _ := s.days()
+		for _, d := range days {
+			if d < cutoff {
+				os.Remove(s.segmentPath(d))
+			}
+		}
+	}
+	return nil
+}
+
+// Close closes the current segment
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
+
+func (s *Store) segmentPath(day string) string {
+	return filepath.Join(s.dir, "usage-"+day+".jsonl")
+}
+
+// days lists the days with a segment, oldest first
+func (s *Store) days() ([]string, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list usage segments: %w", err)
+	}
+	var days []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if day, ok := strings.CutPrefix(strings.TrimSuffix(name, ".jsonl"), "usage-"); ok && strings.HasSuffix(name, ".jsonl") {
+			if _, err := time.Parse(segmentLayout, day); err == nil {
+				days = append(days, day)
+			}
+		}
+	}
+	sort.Strings(days)
+	return days, nil
+}
+
+// Scan calls fn for each record that started in [from, to), reading only the segments
+// of days in the range. Lines that cannot be parsed, such as a record still being
+// written, are skipped.
+func (s *Store) Scan(from, to time.Time, fn func(Record) error) error {
+	days, err := s.days()
+	if err != nil {
+		return err
+	}
+	first, last := from.UTC().Format(segmentLayout), to.UTC().Format(segmentLayout)
+	for _, day := range days {
+		if day < first || day > last {
+			continue
+		}
+		if err := s.scanSegment(day, from, to, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Store) scanSegment(day string, from, to time.Time, fn func(Record) error) error {
+	file, err := os.Open(s.segmentPath(day))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil // Pruned since it was listed
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open usage segment: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	for scanner.Scan() {
+		var r Record
+		if json.Unmarshal(scanner.Bytes(), &r) != nil {
+			continue
+		}
+		if r.Time.Before(from) || !r.Time.Before(to) {
+			continue
+		}
+		if err := fn(r); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read usage segment %s: %w", day, err)
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package usage
+
+import (
+	"bytes"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStoreReport(t *testing.T) {
+	dir := t.TempDir()
+	store, err := Open(dir, 0)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer store.Close()
+
+	day := time.Date(2026, 6, 1, 10, 0, 0, 0, time.UTC)
+	records := []Record{
+		{Time: day, Key: "alice", Provider: "kiro", Model: "claude-sonnet-4-5", PromptTokens: 100, CompletionTokens: 20, CachedTokens: 80, LatencyMs: 1000, Status: 200},
+		{Time: day.Add(time.Hour), Key: "alice", Provider: "kiro", Model: "claude-sonnet-4-5", PromptTokens: 50, CompletionTokens: 10, LatencyMs: 3000, Status: 200},
+		{Time: day.Add(2 * time.Hour), Key: "bob", Provider: "qwen", Model: "qwen3-coder-plus", LatencyMs: 10, Status: 429, Error: true},
+		{Time: day.Add(24 * time.Hour), Key: "bob", Provider: "qwen", Model: "qwen3-coder-plus", PromptTokens: 7, CompletionTokens: 3, LatencyMs: 500, Status: 200},
+	}
+	for _, r := range records {
+		if err := store.Add(r); err != nil {
+			t.Fatalf("Add() error = %v", err)
+		}
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 2 {
+		t.Errorf("got %d segments, want one per day", len(entries))
+	}
+
+	rows, err := store.Report(Query{From: day, To: day.Add(48 * time.Hour), GroupBy: []string{GroupKey, GroupModel}})
+	if err != nil {
+		t.Fatalf("Report() error = %v", err)
+	}
+	if len(rows) != 2 {
+		t.Fatalf("got %d rows, want 2: %+v", len(rows), rows)
+	}
+	alice, bob := rows[0], rows[1]
+	if alice.Group[GroupKey] != "alice" || alice.Requests != 2 || alice.TotalTokens != 180 || alice.CachedTokens != 80 || alice.AvgLatencyMs != 2000 || alice.MaxLatencyMs != 3000 {
+		t.Errorf("alice = %+v", alice)
+	}
+	if bob.Requests != 2 || bob.Errors != 1 || bob.TotalTokens != 10 {
+		t.Errorf("bob = %+v", bob)
+	}
+
+	// Time ranges and filters
+	rows, _ = store.Report(Query{From: day, To: day.Add(24 * time.Hour), Provider: "qwen"})
+	if len(rows) != 1 || rows[0].Requests != 1 || rows[0].Group != nil {
+		t.Errorf("filtered rows = %+v", rows)
+	}
+
+	var buf bytes.Buffer
+	WriteCSV(&buf, []string{GroupDay}, []Row{{Group: map[string]string{GroupDay: "2026-06-01"}, Requests: 3, TotalTokens: 180}})
+	want := "day,requests,errors,prompt_tokens,completion_tokens,cached_tokens,total_tokens,avg_latency_ms,max_latency_ms\n2026-06-01,3,0,0,0,0,180,0,0\n"
+	if buf.String() != want {
+		t.Errorf("CSV = %q, want %q", buf.String(), want)
+	}
+}
+
+func TestStorePrunesExpiredSegments(t *testing.T) {
+	dir := t.TempDir()
+	old := filepath.Join(dir, "usage-2026-01-01.jsonl")
+	os.WriteFile(old, []byte("{}\n"), 0o600)
+
+	store, _ := Open(dir, 30)
+	defer store.Close()
+	if err := store.Add(Record{Time: time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC)}); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	if _, err := os.Stat(old); !os.IsNotExist(err) {
+		t.Errorf("expired segment still exists: %v", err)
+	}
+}
+
+func TestParseQuery(t *testing.T) {
+	now := time.Date(2026, 6, 2, 12, 0, 0, 0, time.UTC)
+	q, err := ParseQuery(url.Values{"from": {"2026-06-01"}, "to": {"1h"}, "group_by": {"model, day"}, "key": {"alice"}}, now)
+	if err != nil {
+		t.Fatalf("ParseQuery() error = %v", err)
+	}
+	if !q.From.Equal(time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC)) || !q.To.Equal(now.Add(-time.Hour)) {
+		t.Errorf("range = %s to %s", q.From, q.To)
+	}
+	if strings.Join(q.GroupBy, ",") != "model,day" || q.Key != "alice" {
+		t.Errorf("query = %+v", q)
+	}
+
+	for _, values := range []url.Values{
+		{"group_by": {"colour"}},
+		{"from": {"yesterday"}},
+		{"from": {"1h"}, "to": {"2h"}},
+	} {
+		if _, err := ParseQuery(values, now); err == nil {
+			t.Errorf("ParseQuery(%v) succeeded, want an error", values)
+		}
+	}
+}